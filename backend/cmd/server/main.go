@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -10,19 +12,30 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/danielsaas/generic-saas/internal/apikey"
 	"github.com/danielsaas/generic-saas/internal/auth"
+	"github.com/danielsaas/generic-saas/internal/config"
 	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
 	"github.com/danielsaas/generic-saas/internal/metrics"
+	"github.com/danielsaas/generic-saas/internal/mfa"
 	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/oidc"
+	"github.com/danielsaas/generic-saas/internal/password"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+	"github.com/danielsaas/generic-saas/internal/token"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
 )
 
-// handleUserProfile routes between GET and PUT for user profile
+// handleUserProfile routes between GET and PUT for user profile. API keys
+// need the read or write scope to match. Impersonators can't change the
+// profile, since that includes the email address.
 func handleUserProfile(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		metrics.HandleGetUserProfile(w, r)
+		middleware.RequireScope(apikey.ScopeProfileRead)(http.HandlerFunc(metrics.HandleGetUserProfile)).ServeHTTP(w, r)
 	case http.MethodPut:
-		metrics.HandleUpdateUserProfile(w, r)
+		middleware.ForbidImpersonation(middleware.RequireScope(apikey.ScopeProfileWrite)(http.HandlerFunc(metrics.HandleUpdateUserProfile))).ServeHTTP(w, r)
 	default:
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -55,35 +68,92 @@ func main() {
 		db = dbFactory.CreateMemory()
 	}
 
+	// Create the default roles and the first administrator
+	if err := initRoles(db, config.GetAuthConfig(), logger); err != nil {
+		logger.Error("Failed to initialize roles", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize token signing
+	tokenManager, err := newTokenManager(config.GetAuthConfig(), logger)
+	if err != nil {
+		logger.Error("Failed to initialize token signing", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize encryption of two-factor secrets
+	secretBox, err := newSecretBox(config.GetAuthConfig(), logger)
+	if err != nil {
+		logger.Error("Failed to initialize two-factor encryption", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize passkeys
+	relyingParty, err := newRelyingParty(config.GetAuthConfig())
+	if err != nil {
+		logger.Error("Failed to initialize passkeys", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize OpenID Connect login providers
+	oidcProviders, err := newOIDCProviders(config.GetAuthConfig())
+	if err != nil {
+		logger.Error("Failed to initialize login providers", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize password hashing
+	passwordHasher, err := newPasswordHasher(config.GetAuthConfig())
+	if err != nil {
+		logger.Error("Failed to initialize password hashing", "error", err)
+		os.Exit(1)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(config.GetAuthConfig())
+	if err != nil {
+		logger.Error("Failed to initialize password policy", "error", err)
+		os.Exit(1)
+	}
+
+	// Initialize email
+	emailService, err := newEmailService()
+	if err != nil {
+		logger.Error("Failed to initialize email service", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Email service initialized", "provider", emailService.GetProviderName())
+
 	// Initialize services
-	authService := auth.NewService(db)
+	authService := auth.NewService(db, tokenManager)
+	authService.SetEmailService(emailService)
+	authService.SetPasswordHasher(passwordHasher)
+	authService.SetPasswordPolicy(passwordPolicy)
+	authService.SetSecretBox(secretBox)
+	authService.SetRelyingParty(relyingParty)
+	authService.SetOIDCProviders(oidcProviders)
+	authService.SetEmailTokens(newEmailTokenManager(db, emailService))
 	auth.SetService(authService)
 
 	metricsService := metrics.NewService(db)
+	metricsService.SetPasswordHasher(passwordHasher)
+	metricsService.SetPasswordPolicy(passwordPolicy)
+	metricsService.SetEmailChanger(authService)
 	metrics.SetService(metricsService)
 
 	// Set up routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleRoot)
-	mux.HandleFunc("/health", handleHealth)
-
-	// Auth routes
-	mux.HandleFunc("/auth/login", auth.HandleLogin)
-	mux.HandleFunc("/auth/register", auth.HandleRegister)
-
-	// Protected API routes
-	protectedMux := http.NewServeMux()
-	protectedMux.HandleFunc("/api/metrics", metrics.HandleGetMetrics)
-	protectedMux.HandleFunc("/api/user/profile", handleUserProfile)
-	protectedMux.HandleFunc("/api/user/password", metrics.HandleUpdateUserPassword)
-
-	// Apply auth middleware to protected routes
-	protectedHandler := middleware.RequireAuth(db)(protectedMux)
-	mux.Handle("/api/", protectedHandler)
+	mux := newRouter(db, tokenManager, middleware.AuthOptions{
+		UnverifiedEmail: config.GetAuthConfig().EmailVerificationPolicy,
+		SessionCookies:  config.GetAuthConfig().SessionCookies,
+	})
 
 	// Apply middleware
 	var handler http.Handler = mux
-	handler = middleware.CORS([]string{"*"})(handler) // Allow all origins for development
+	// All origins are allowed by default for development. Session cookies
+	// are only sent from origins named in CORS_ALLOWED_ORIGINS.
+	handler = middleware.CORSWithOptions(middleware.CORSOptions{
+		AllowedOrigins:   config.GetAuthConfig().CORSAllowedOrigins,
+		AllowCredentials: config.GetAuthConfig().SessionCookies,
+	})(handler)
 	handler = middleware.ErrorRecovery(logger)(handler)
 	handler = middleware.RequestLogging(logger)(handler)
 
@@ -103,6 +173,12 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Purge accounts whose deletion grace period has ended
+	go purgeDeletedAccounts(authService, logger)
+
+	// Purge expired tokens and login states
+	go purgeExpiredRecords(db, logger)
+
 	// Create a channel to listen for interrupt signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -134,6 +210,266 @@ func main() {
 	logger.Info("Server exited gracefully")
 }
 
+// newRouter serves the API's routes, with those under /api/ behind
+// authentication with opts. API keys and OAuth access tokens only reach
+// routes wrapped in middleware.RequireScope.
+func newRouter(db database.Database, tokenManager *token.Manager, opts middleware.AuthOptions) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleRoot)
+	mux.HandleFunc("/health", handleHealth)
+
+	protectedMux := http.NewServeMux()
+	protectedMux.Handle("/api/metrics", middleware.RequireScope(apikey.ScopeMetricsRead)(http.HandlerFunc(metrics.HandleGetMetrics)))
+	protectedMux.HandleFunc("/api/user/profile", handleUserProfile)
+	protectedMux.Handle("/api/user/password", middleware.ForbidImpersonation(http.HandlerFunc(metrics.HandleUpdateUserPassword)))
+	auth.RegisterRoutes(mux, protectedMux, db)
+
+	mux.Handle("/api/", middleware.RequireAuthWithOptions(db, tokenManager, opts)(protectedMux))
+	return mux
+}
+
+// newTokenManager builds the JWT manager from the auth configuration
+func newTokenManager(cfg *config.AuthConfig, logger *slog.Logger) (*token.Manager, error) {
+	tokenConfig := token.Config{
+		Algorithm: token.Algorithm(cfg.JWTAlgorithm),
+		KeyID:     cfg.JWTKeyID,
+		Issuer:    cfg.JWTIssuer,
+		Audience:  cfg.JWTAudience,
+		TTL:       cfg.AccessTokenTTL,
+		Leeway:    30 * time.Second,
+	}
+
+	switch tokenConfig.Algorithm {
+	case token.HS256:
+		if cfg.JWTSecret != "" {
+			tokenConfig.Secret = []byte(cfg.JWTSecret)
+		} else {
+			// Development fallback - tokens will not survive a restart
+			logger.Warn("JWT_SECRET not set, using an ephemeral signing secret")
+			tokenConfig.Secret = make([]byte, 32)
+			if _, err := rand.Read(tokenConfig.Secret); err != nil {
+				return nil, fmt.Errorf("failed to generate signing secret: %w", err)
+			}
+		}
+	default:
+		if cfg.JWTPrivateKeyFile == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", cfg.JWTAlgorithm)
+		}
+		pemData, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		privateKey, err := token.ParsePrivateKeyPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT private key: %w", err)
+		}
+		tokenConfig.PrivateKey = privateKey
+	}
+
+	return token.NewManager(tokenConfig)
+}
+
+// newSecretBox builds the cipher for TOTP secrets from MFA_ENCRYPTION_KEY
+func newSecretBox(cfg *config.AuthConfig, logger *slog.Logger) (*mfa.SecretBox, error) {
+	if cfg.MFAEncryptionKey == "" {
+		// Development fallback - enrolled authenticators will not survive a restart
+		logger.Warn("MFA_ENCRYPTION_KEY not set, using an ephemeral encryption key")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+		}
+		return mfa.NewSecretBox(key)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.MFAEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be base64 encoded: %w", err)
+	}
+	return mfa.NewSecretBox(key)
+}
+
+// newRelyingParty builds the WebAuthn relying party for passkeys
+func newRelyingParty(cfg *config.AuthConfig) (*webauthn.RelyingParty, error) {
+	return webauthn.NewRelyingParty(webauthn.Config{
+		RPID:        cfg.WebAuthnRPID,
+		RPName:      cfg.WebAuthnRPName,
+		Origins:     cfg.WebAuthnOrigins,
+		Attestation: cfg.WebAuthnAttestation,
+	})
+}
+
+// newOIDCProviders builds the configured OpenID Connect login providers
+func newOIDCProviders(cfg *config.AuthConfig) ([]*oidc.Provider, error) {
+	providers := make([]*oidc.Provider, 0, len(cfg.OIDCProviders))
+	for _, p := range cfg.OIDCProviders {
+		provider, err := oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			DisplayName:  p.DisplayName,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", p.Name, err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// initRoles creates the default roles and gives the admin role to
+// BOOTSTRAP_ADMIN_EMAIL if nobody has it yet. If that account doesn't exist
+// or isn't verified yet, it gets the role when it is verified or signs in.
+func initRoles(db database.Database, cfg *config.AuthConfig, logger *slog.Logger) error {
+	ctx := context.Background()
+	if err := rbac.EnsureDefaultRoles(ctx, db.Roles()); err != nil {
+		return err
+	}
+
+	bootstrapped, err := rbac.BootstrapAdmin(ctx, db, cfg.BootstrapAdminEmail)
+	if err != nil {
+		return err
+	}
+	if bootstrapped {
+		logger.Info("Gave the admin role to the bootstrap administrator", "email", cfg.BootstrapAdminEmail)
+	}
+	return nil
+}
+
+// purgeDeletedAccounts deletes accounts past their deletion grace period,
+// checking once at startup and then every hour
+func purgeDeletedAccounts(authService *auth.Service, logger *slog.Logger) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := authService.PurgeDeletedAccounts(context.Background(), time.Now())
+		if err != nil {
+			logger.Error("Failed to purge deleted accounts", "error", err)
+		} else if purged > 0 {
+			logger.Info("Purged deleted accounts", "count", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// purgeExpiredRecords deletes expired refresh tokens, login states and
+// authorization codes, checking once at startup and then every hour. Some
+// are created by unauthenticated requests, so they mustn't pile up.
+func purgeExpiredRecords(db database.Database, logger *slog.Logger) {
+	purges := []struct {
+		name  string
+		purge func(ctx context.Context, before time.Time) (int, error)
+	}{
+		{"refresh tokens", db.RefreshTokens().DeleteExpiredRefreshTokens},
+		{"OpenID Connect login states", db.OIDCLoginStates().DeleteExpiredOIDCLoginStates},
+		{"OAuth authorization codes", db.OAuthAuthorizationCodes().DeleteExpiredOAuthAuthorizationCodes},
+		{"SAML logins", db.SAMLLogins().DeleteExpiredSAMLLogins},
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		for _, p := range purges {
+			purged, err := p.purge(context.Background(), time.Now())
+			if err != nil {
+				logger.Error("Failed to purge expired "+p.name, "error", err)
+			} else if purged > 0 {
+				logger.Info("Purged expired "+p.name, "count", purged)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// newPasswordHasher creates the password hasher from the auth configuration
+func newPasswordHasher(cfg *config.AuthConfig) (password.Hasher, error) {
+	hashConfig := password.DefaultConfig()
+	hashConfig.Algorithm = cfg.PasswordHashAlgorithm
+	hashConfig.Argon2.Memory = uint32(cfg.Argon2Memory)
+	hashConfig.Argon2.Iterations = uint32(cfg.Argon2Iterations)
+	hashConfig.Argon2.Parallelism = uint8(min(cfg.Argon2Parallelism, 255))
+	hashConfig.BcryptCost = cfg.BcryptCost
+	hashConfig.Pepper = cfg.PasswordPepper
+	return password.New(hashConfig)
+}
+
+// newPasswordPolicy creates the rules for new passwords from the auth
+// configuration, with the breached password source it names
+func newPasswordPolicy(cfg *config.AuthConfig) (password.Policy, error) {
+	policy := password.Policy{
+		MinLength:          cfg.PasswordMinLength,
+		MaxLength:          cfg.PasswordMaxLength,
+		RequireUpper:       cfg.PasswordRequireUpper,
+		RequireLower:       cfg.PasswordRequireLower,
+		RequireDigit:       cfg.PasswordRequireDigit,
+		RequireSpecial:     cfg.PasswordRequireSpecial,
+		RejectPersonalInfo: cfg.PasswordRejectPersonalInfo,
+		HistorySize:        cfg.PasswordHistory,
+	}
+	if policy.MaxLength < policy.MinLength {
+		return policy, fmt.Errorf("PASSWORD_MAX_LENGTH %d is below PASSWORD_MIN_LENGTH %d", policy.MaxLength, policy.MinLength)
+	}
+	if cfg.PasswordHashAlgorithm == password.AlgorithmBcrypt {
+		// bcrypt can't hash anything longer
+		policy.MaxBytes = password.BcryptMaxBytes
+	}
+
+	switch cfg.PasswordBreachCheck {
+	case "off", "":
+	case "api":
+		policy.Breaches = password.NewRangeAPI(cfg.PasswordBreachAPIURL, nil)
+	case "file":
+		file, err := password.NewBreachFile(cfg.PasswordBreachFile)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breaches = file
+	default:
+		return policy, fmt.Errorf("unknown PASSWORD_BREACH_CHECK %q, expected off, api or file", cfg.PasswordBreachCheck)
+	}
+	return policy, nil
+}
+
+// newEmailService creates the email service from environment variables. The
+// SMTP provider only logs messages, which makes it the development default.
+func newEmailService() (email.EmailService, error) {
+	appConfig := config.GetAppConfig()
+	provider := os.Getenv("EMAIL_PROVIDER")
+	if provider == "" {
+		provider = email.ProviderSMTP
+	}
+
+	return email.NewEmailService(&email.Config{
+		Provider:           provider,
+		SendGridAPIKey:     os.Getenv("SENDGRID_API_KEY"),
+		SESRegion:          os.Getenv("AWS_REGION"),
+		SESAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SESSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		FromEmail:          appConfig.GetEmailFromAddress(),
+		FromName:           appConfig.EmailFromName,
+		RequireTLS:         true,
+	})
+}
+
+// newEmailTokenManager keeps emailed tokens in PostgreSQL when it is the
+// database, and in memory otherwise
+func newEmailTokenManager(db database.Database, emailService email.EmailService) auth.EmailTokens {
+	if pg, ok := db.(*database.PostgreSQLDatabase); ok {
+		return email.NewTokenManager(pg.SQL(), emailService)
+	}
+	return email.NewMemoryTokenManager(emailService, func(address string) (int, bool) {
+		user, err := db.Users().GetUserByEmail(context.Background(), address)
+		if err != nil {
+			return 0, false
+		}
+		return user.ID, true
+	})
+}
+
 // handleRoot handles requests to the root path
 func handleRoot(w http.ResponseWriter, r *http.Request) {
 	// Set content type
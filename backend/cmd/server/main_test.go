@@ -4,7 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/auth"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/metrics"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
 )
 
 // RootResponse represents the expected JSON response from the root endpoint
@@ -230,6 +237,75 @@ func TestHTTPServerIntegration(t *testing.T) {
 	}
 }
 
+// TestRouterRequiresScope checks that API keys only reach the routes their
+// scopes name
+func TestRouterRequiresScope(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	tokens, err := token.NewManager(token.Config{
+		Algorithm: token.HS256,
+		Secret:    []byte("test-secret-that-is-at-least-32-bytes"),
+		KeyID:     "test",
+		Issuer:    "https://test.example.com",
+		Audience:  "test-api",
+	})
+	if err != nil {
+		t.Fatalf("could not create token manager: %v", err)
+	}
+	auth.SetService(auth.NewService(db, tokens))
+	metrics.SetService(metrics.NewService(db))
+	router := newRouter(db, tokens, middleware.AuthOptions{})
+
+	serve := func(method, path, body, credential string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/auth/register", `{"name": "John Doe", "email": "john@example.com", "password": "Password123!"}`, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("could not register: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = serve("POST", "/auth/login", `{"email": "john@example.com", "password": "Password123!"}`, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("could not log in: %d %s", rr.Code, rr.Body.String())
+	}
+	var session auth.AuthResponse
+	json.NewDecoder(rr.Body).Decode(&session)
+
+	rr = serve("POST", "/api/user/api-keys", `{"name": "ci", "scopes": ["profile:read"]}`, session.Token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("could not create API key: %d %s", rr.Code, rr.Body.String())
+	}
+	var created auth.CreateAPIKeyResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"scope granted", "GET", "/api/user/profile", http.StatusOK},
+		{"write scope missing", "PUT", "/api/user/profile", http.StatusForbidden},
+		{"metrics scope missing", "GET", "/api/metrics", http.StatusForbidden},
+		{"route without a scope", "GET", "/api/admin/roles", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(tt.method, tt.path, `{"name": "Mallory"}`, created.Key)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusForbidden && !strings.Contains(rr.Body.String(), middleware.AuthCodeInsufficientScope) {
+				t.Errorf("expected %s, got %s", middleware.AuthCodeInsufficientScope, rr.Body.String())
+			}
+		})
+	}
+}
+
 // BenchmarkHandleRoot benchmarks the root handler
 func BenchmarkHandleRoot(b *testing.B) {
 	req, err := http.NewRequest("GET", "/", nil)
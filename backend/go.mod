@@ -7,3 +7,5 @@ require golang.org/x/crypto v0.43.0
 require github.com/lib/pq v1.10.9
 
 require github.com/DATA-DOG/go-sqlmock v1.5.2 // indirect
+
+require golang.org/x/sys v0.37.0 // indirect
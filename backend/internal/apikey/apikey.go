@@ -0,0 +1,56 @@
+// Package apikey generates personal API keys and defines the scopes they
+// can be granted. Keys are opaque: the server stores only their hash.
+package apikey
+
+import (
+	"strings"
+
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Prefix starts every key, so keys are recognisable in configs and logs and
+// can be told apart from JWTs in an Authorization header
+const Prefix = "gsk_"
+
+// displayLength is how much of a key is kept in the clear to identify it
+const displayLength = len(Prefix) + 8
+
+// Scopes that can be granted to a key. User sessions carry all of them.
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+	ScopeMetricsRead  = "metrics:read"
+)
+
+// Scopes lists every scope a key can be granted
+var Scopes = []string{ScopeProfileRead, ScopeProfileWrite, ScopeMetricsRead}
+
+// ValidScope reports whether a scope can be granted to a key
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns a new key and the prefix shown to identify it
+func Generate() (key, displayPrefix string, err error) {
+	secret, err := token.GenerateOpaque()
+	if err != nil {
+		return "", "", err
+	}
+	key = Prefix + secret
+	return key, key[:displayLength], nil
+}
+
+// IsKey reports whether a bearer credential is an API key rather than a JWT
+func IsKey(raw string) bool {
+	return strings.HasPrefix(raw, Prefix)
+}
+
+// Hash returns the hash a key is stored and looked up by
+func Hash(key string) string {
+	return token.HashOpaque(key)
+}
@@ -0,0 +1,42 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if !IsKey(key) || !strings.HasPrefix(key, prefix) || len(prefix) != displayLength {
+		t.Errorf("Unexpected key %q with prefix %q", key, prefix)
+	}
+	if Hash(key) == key || len(Hash(key)) != 64 {
+		t.Errorf("Unexpected hash %q", Hash(key))
+	}
+
+	other, _, _ := Generate()
+	if other == key {
+		t.Error("Expected keys to be unique")
+	}
+}
+
+func TestIsKey(t *testing.T) {
+	if IsKey("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("Expected a JWT not to be taken for an API key")
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, scope := range Scopes {
+		if !ValidScope(scope) {
+			t.Errorf("Expected %q to be valid", scope)
+		}
+	}
+	if ValidScope("admin") || ValidScope("") {
+		t.Error("Expected unknown scopes to be invalid")
+	}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+)
+
+// DeleteAccountRequest is the body of DELETE /api/user/account. Accounts
+// with a password confirm with it. Accounts with two-factor on may confirm
+// with a code instead.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// DeleteAccountResponse tells the user when their account will be purged
+type DeleteAccountResponse struct {
+	Message             string    `json:"message"`
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// DeleteAccount schedules the authenticated user's account for deletion
+// after the grace period. Every device is signed out, and signing in again
+// before the grace period ends cancels the deletion.
+func (s *Service) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	switch {
+	case req.Password != "":
+		if err := s.passwords.Verify(req.Password, user.Password); err != nil {
+			writeErrorResponse(w, "Password is incorrect", http.StatusUnauthorized)
+			return
+		}
+	case req.Code != "" && user.TOTPEnabled:
+		verified, err := s.checkTOTP(ctx, user, req.Code)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			writeErrorResponse(w, "Invalid authentication code", http.StatusUnauthorized)
+			return
+		}
+	case user.Password != "" || user.TOTPEnabled:
+		writeErrorResponse(w, "Password or authentication code is required", http.StatusBadRequest)
+		return
+	default:
+		// Accounts without a password or second factor signed in with a
+		// passkey, provider or magic link, and have nothing to confirm with.
+		// The emailed notice and the grace period protect them instead.
+	}
+
+	org, err := s.lastOwnedOrganization(ctx, user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if org != nil {
+		writeCodedErrorResponse(w, "You are the last owner of "+org.Name+". Transfer it to another member first.", CodeLastOwner, http.StatusConflict)
+		return
+	}
+
+	deletionAt := time.Now().Add(s.deletionGracePeriod)
+	user.DeletionScheduledAt = &deletionAt
+	if _, err := s.db.Users().UpdateUser(ctx, user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "Your account is scheduled to be deleted on "+deletionAt.UTC().Format("2 January 2006 at 15:04 MST")+
+		" and every device signed in to it was signed out. To keep your account, sign in again before then.")
+
+	writeJSONResponse(w, DeleteAccountResponse{
+		Message:             "Account scheduled for deletion",
+		DeletionScheduledAt: deletionAt,
+	}, http.StatusAccepted)
+}
+
+// signOutEverywhere revokes every session, refresh token, API key and OAuth
+// token of a user, so nothing keeps working on an account waiting to be
+// deleted or taken back from someone else
+func (s *Service) signOutEverywhere(ctx context.Context, userID int) error {
+	if err := s.db.Sessions().RevokeUserSessions(ctx, userID, ""); err != nil {
+		return err
+	}
+	if err := s.db.RefreshTokens().RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.db.OAuthTokens().RevokeUserOAuthTokens(ctx, userID); err != nil {
+		return err
+	}
+
+	keys, err := s.db.APIKeys().ListUserAPIKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.RevokedAt != nil {
+			continue
+		}
+		if err := s.db.APIKeys().RevokeAPIKey(ctx, userID, key.ID); err != nil && !errors.Is(err, database.ErrAPIKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelDeletion keeps an account that was scheduled for deletion. Signing
+// in is how a user changes their mind.
+func (s *Service) cancelDeletion(r *http.Request, user *User) error {
+	user.DeletionScheduledAt = nil
+	updated, err := s.db.Users().UpdateUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+	*user = *updated
+
+	s.sendSecurityAlert(r, user, "You signed in, so your account will no longer be deleted. "+
+		"If you didn't do this, reset your password and review your account security.")
+	return nil
+}
+
+// PurgeDeletedAccounts deletes every account whose grace period has ended,
+// with everything it owns, and returns how many were deleted
+func (s *Service) PurgeDeletedAccounts(ctx context.Context, now time.Time) (int, error) {
+	users, err := s.db.Users().ListUsersDueForDeletion(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		// The user may have signed in and cancelled since the list was made
+		current, err := s.db.Users().GetUserByID(ctx, user.ID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		if current.DeletionScheduledAt == nil || current.DeletionScheduledAt.After(now) {
+			continue
+		}
+
+		if err := s.purgeUser(ctx, user); err != nil {
+			if errors.Is(err, database.ErrUserNotFound) {
+				continue
+			}
+			return purged, err
+		}
+		purged++
+
+		if s.emailService != nil {
+			s.emailService.SendSecurityAlert(ctx, user.Email, "Your account and its data have been deleted.", email.SecurityContext{
+				RequestTime: now,
+			})
+		}
+	}
+	return purged, nil
+}
+
+// purgeUser deletes a user with everything they own
+func (s *Service) purgeUser(ctx context.Context, user *User) error {
+	if err := s.handOverOrganizations(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.db.PurgeUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// Counters are keyed by address or user ID rather than stored with the
+	// user, so they stay behind unless cleared
+	for _, key := range []string{"email:" + user.Email, "reset:" + user.Email, mfaKey(user.ID)} {
+		if err := s.db.LoginAttempts().ClearLoginAttempts(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleDeleteAccount is a wrapper around the service DeleteAccount method
+func HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteAccount(w, r)
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/mfa"
+)
+
+func TestDeleteAccount_SchedulesDeletion(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+	other := loginAgain(t, service)
+
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{"password": "wrong"}`, session.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong password to be rejected, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{}`, session.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing password to be rejected, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "DELETE", "/api/user/account", `{"password": "password123"}`, session.Token)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	var response DeleteAccountResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	wantAt := time.Now().Add(service.deletionGracePeriod)
+	if response.DeletionScheduledAt.Sub(wantAt).Abs() > time.Minute {
+		t.Errorf("Expected deletion at about %v, got %v", wantAt, response.DeletionScheduledAt)
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if user.DeletionScheduledAt == nil {
+		t.Error("Expected the deletion to be stored")
+	}
+
+	// Every device is signed out
+	for _, accessToken := range []string{session.Token, other.Token} {
+		if rr := serveAPI(service, "GET", "/api/user/sessions", "", accessToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected sessions to be revoked, got %d", rr.Code)
+		}
+	}
+
+	if len(emails.alerts) != 1 || !strings.Contains(emails.alerts[0], "scheduled to be deleted") {
+		t.Errorf("Expected a deletion notice, got %v", emails.alerts)
+	}
+}
+
+func TestDeleteAccount_WithTwoFactorCode(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+	secret, _ := enableTOTP(t, service, db, session.Token)
+
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{"code": "000000"}`, session.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong code to be rejected, got %d", rr.Code)
+	}
+
+	// The code used to enable two-factor can't be replayed, so use the next one
+	code, _ := mfa.Code(secret, time.Now().Add(mfa.Period))
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{"code": "`+code+`"}`, session.Token); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeleteAccount_SigningInCancels(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{"password": "password123"}`, session.Token); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	response := loginAgain(t, service)
+	if response.User.DeletionScheduledAt != nil {
+		t.Error("Expected the login response to show the deletion cancelled")
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if user.DeletionScheduledAt != nil {
+		t.Error("Expected signing in to cancel the deletion")
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "no longer be deleted") {
+		t.Errorf("Expected a cancellation notice, got %q", last)
+	}
+
+	purged, err := service.PurgeDeletedAccounts(context.Background(), time.Now().Add(service.deletionGracePeriod+time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("Expected nothing to be purged, got %d, %v", purged, err)
+	}
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	db.APIKeys().CreateAPIKey(context.Background(), &database.APIKey{UserID: user.ID, Name: "script", Prefix: "gsk_test", KeyHash: "hash"})
+
+	if rr := serveAPI(service, "DELETE", "/api/user/account", `{"password": "password123"}`, session.Token); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+
+	// Nothing happens during the grace period
+	if purged, _ := service.PurgeDeletedAccounts(context.Background(), time.Now()); purged != 0 {
+		t.Fatalf("Expected nothing to be purged yet, got %d", purged)
+	}
+
+	purged, err := service.PurgeDeletedAccounts(context.Background(), time.Now().Add(service.deletionGracePeriod+time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeletedAccounts() error = %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected one account to be purged, got %d", purged)
+	}
+
+	if _, err := db.Users().GetUserByID(context.Background(), user.ID); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("Expected the user to be deleted, got %v", err)
+	}
+	if keys, _ := db.APIKeys().ListUserAPIKeys(context.Background(), user.ID); len(keys) != 0 {
+		t.Errorf("Expected the user's API keys to be deleted, got %d", len(keys))
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "have been deleted") {
+		t.Errorf("Expected a final notice, got %q", last)
+	}
+
+	if rr := postLogin(service, "john@example.com", "password123", "192.0.2.1:1234"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the deleted account to be unable to log in, got %d", rr.Code)
+	}
+}
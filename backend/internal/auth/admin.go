@@ -0,0 +1,632 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// Error codes returned by the admin endpoints and the restrictions they set
+const (
+	CodeAccountSuspended      = "account_suspended"
+	CodePasswordResetRequired = "password_reset_required"
+	CodeEmailAlreadyVerified  = "email_already_verified"
+	CodeCannotTargetSelf      = "cannot_target_self"
+)
+
+// Actions recorded in the audit trail
+const (
+	AuditActionSearchUsers        = "users.search"
+	AuditActionViewUser           = "user.view"
+	AuditActionViewSessions       = "user.view_sessions"
+	AuditActionViewAPIKeys        = "user.view_api_keys"
+	AuditActionForcePasswordReset = "user.force_password_reset"
+	AuditActionSuspend            = "user.suspend"
+	AuditActionUnsuspend          = "user.unsuspend"
+	AuditActionResendVerification = "user.resend_verification"
+	AuditActionDelete             = "user.delete"
+	AuditActionAssignRole         = "user.assign_role"
+	AuditActionUnassignRole       = "user.unassign_role"
+)
+
+const (
+	// defaultAdminPageSize is how many results a listing returns without a limit
+	defaultAdminPageSize = 50
+
+	// maxAdminPageSize caps the limit a listing accepts
+	maxAdminPageSize = 200
+)
+
+// errAccountSuspended means a suspended user tried to start a session
+var errAccountSuspended = errors.New("account is suspended")
+
+// errPasswordResetRequired means a user who has to reset their password
+// tried to start a session
+var errPasswordResetRequired = errors.New("password reset required")
+
+// AdminUsersResponse is the body of GET /api/admin/users
+type AdminUsersResponse struct {
+	Users []*User `json:"users"`
+}
+
+// AdminUserResponse describes one user to an administrator
+type AdminUserResponse struct {
+	User  User             `json:"user"`
+	Roles []*database.Role `json:"roles"`
+}
+
+// SuspendUserRequest is the body of POST /api/admin/users/{id}/suspend
+type SuspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AuditLogResponse is the body of GET /api/admin/audit-log
+type AuditLogResponse struct {
+	Entries []*database.AuditEntry `json:"entries"`
+}
+
+// AdminSearchUsers lists users matching the query string. q matches part of
+// the email or name, created_after and created_before take a date or an
+// RFC 3339 time, and verified and suspended take true or false.
+func (s *Service) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, err := s.db.Users().SearchUsers(r.Context(), filter)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionSearchUsers, 0, r.URL.RawQuery) {
+		return
+	}
+
+	writeJSONResponse(w, AdminUsersResponse{Users: users}, http.StatusOK)
+}
+
+// AdminGetUser returns the user named in the path with their roles
+func (s *Service) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := s.db.Roles().ListUserRoles(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionViewUser, user.ID, "") {
+		return
+	}
+
+	writeJSONResponse(w, AdminUserResponse{User: *user, Roles: roles}, http.StatusOK)
+}
+
+// AdminListSessions returns the active sessions of the user named in the path
+func (s *Service) AdminListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.db.Sessions().ListUserSessions(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionViewSessions, user.ID, "") {
+		return
+	}
+
+	now := time.Now()
+	response := SessionsResponse{Sessions: []SessionInfo{}}
+	for _, session := range sessions {
+		if !session.Active(now) {
+			continue
+		}
+		response.Sessions = append(response.Sessions, SessionInfo{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			AuthMethod: session.AuthMethod,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+		})
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// AdminListAPIKeys returns the API keys of the user named in the path
+func (s *Service) AdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.db.APIKeys().ListUserAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionViewAPIKeys, user.ID, "") {
+		return
+	}
+
+	response := APIKeysResponse{APIKeys: []APIKeyInfo{}}
+	for _, key := range keys {
+		response.APIKeys = append(response.APIKeys, apiKeyInfo(key))
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// AdminForcePasswordReset stops the user named in the path from signing in
+// with their password until they reset it, and signs them out everywhere.
+// They are emailed a reset code if emailed codes are configured.
+func (s *Service) AdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	user.PasswordResetRequired = true
+	user, err := s.db.Users().UpdateUser(ctx, user)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.db.Sessions().RevokeUserSessions(ctx, user.ID, ""); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.RefreshTokens().RevokeUserRefreshTokens(ctx, user.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionForcePasswordReset, user.ID, "") {
+		return
+	}
+
+	if s.emailTokens != nil {
+		// A rate limit means a code was sent moments ago, which will do
+		s.emailTokens.RequestPasswordReset(email.PasswordResetRequest{
+			Email:     user.Email,
+			RequestIP: middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+	}
+	s.sendSecurityAlert(r, user, "An administrator asked you to reset your password and signed you out everywhere. "+
+		"Use the reset code we sent you, or ask for a new one, to choose a new password.")
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// AdminSuspendUser suspends the user named in the path. They are signed out
+// everywhere, their API keys are revoked and they can't sign in again until
+// the suspension is lifted.
+func (s *Service) AdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SuspendUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok || !s.notSelf(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	if !user.Suspended() {
+		now := time.Now()
+		user.SuspendedAt = &now
+		var err error
+		if user, err = s.db.Users().UpdateUser(ctx, user); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionSuspend, user.ID, strings.TrimSpace(req.Reason)) {
+		return
+	}
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// AdminUnsuspendUser lifts the suspension of the user named in the path
+func (s *Service) AdminUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Suspended() {
+		user.SuspendedAt = nil
+		var err error
+		if user, err = s.db.Users().UpdateUser(r.Context(), user); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !s.audit(w, r, AuditActionUnsuspend, user.ID, "") {
+		return
+	}
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// AdminResendVerification emails the user named in the path a new
+// verification link
+func (s *Service) AdminResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Email verification is not configured", CodeVerificationNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified() {
+		writeCodedErrorResponse(w, "Email is already verified", CodeEmailAlreadyVerified, http.StatusConflict)
+		return
+	}
+
+	err := s.emailTokens.RequestEmailVerification(email.EmailVerificationRequest{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		RequestIP: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if errors.Is(err, email.ErrRateLimitExceeded) {
+		writeErrorResponse(w, "Too many verification emails, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionResendVerification, user.ID, "") {
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{
+		"message": "Verification email sent",
+	}, http.StatusAccepted)
+}
+
+// AdminDeleteUser deletes the user named in the path straight away, with
+// everything they own. There is no grace period.
+func (s *Service) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok || !s.notSelf(w, r, user) {
+		return
+	}
+
+	if err := s.purgeUser(r.Context(), user); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The entry outlives the account, so it keeps the address
+	if !s.audit(w, r, AuditActionDelete, user.ID, user.Email) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminListAuditLog lists the audit trail, newest first. actor_id and
+// user_id narrow it to what one administrator did or what was done to one
+// user, and action to one kind of action.
+func (s *Service) AdminListAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := database.AuditFilter{Action: query.Get("action"), Limit: limit, Offset: offset}
+	if filter.ActorID, err = parseOptionalID(query.Get("actor_id")); err != nil {
+		writeErrorResponse(w, "Invalid actor_id", http.StatusBadRequest)
+		return
+	}
+	if filter.TargetUserID, err = parseOptionalID(query.Get("user_id")); err != nil {
+		writeErrorResponse(w, "Invalid user_id", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.db.AuditLog().ListAuditEntries(r.Context(), filter)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, AuditLogResponse{Entries: entries}, http.StatusOK)
+}
+
+// audit records an administrator's action. It writes the response and
+// returns false if the entry can't be stored.
+func (s *Service) audit(w http.ResponseWriter, r *http.Request, action string, targetUserID int, details string) bool {
+	actorID, _ := middleware.UserIDFromContext(r.Context())
+	_, err := s.db.AuditLog().CreateAuditEntry(r.Context(), &database.AuditEntry{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      details,
+		IPAddress:    middleware.ClientIP(r),
+		UserAgent:    r.UserAgent(),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// notSelf refuses actions an administrator could lock themselves out with.
+// It writes the response and returns false if the user is the caller.
+func (s *Service) notSelf(w http.ResponseWriter, r *http.Request, user *User) bool {
+	if actorID, _ := middleware.UserIDFromContext(r.Context()); actorID == user.ID {
+		writeCodedErrorResponse(w, "You can't do this to your own account", CodeCannotTargetSelf, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// parseUserFilter reads a user search from the query string
+func parseUserFilter(r *http.Request) (database.UserFilter, error) {
+	query := r.URL.Query()
+	filter := database.UserFilter{Query: query.Get("q")}
+
+	var err error
+	if filter.Limit, filter.Offset, err = parsePage(r); err != nil {
+		return filter, err
+	}
+	if filter.CreatedAfter, err = parseOptionalTime(query.Get("created_after")); err != nil {
+		return filter, &ValidationError{"Invalid created_after"}
+	}
+	if filter.CreatedBefore, err = parseOptionalTime(query.Get("created_before")); err != nil {
+		return filter, &ValidationError{"Invalid created_before"}
+	}
+	if filter.Verified, err = parseOptionalBool(query.Get("verified")); err != nil {
+		return filter, &ValidationError{"Invalid verified"}
+	}
+	if filter.Suspended, err = parseOptionalBool(query.Get("suspended")); err != nil {
+		return filter, &ValidationError{"Invalid suspended"}
+	}
+	return filter, nil
+}
+
+// parsePage reads limit and offset from the query string
+func parsePage(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+	limit, offset := defaultAdminPageSize, 0
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, &ValidationError{"Invalid limit"}
+		}
+		limit = min(n, maxAdminPageSize)
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, &ValidationError{"Invalid offset"}
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseOptionalTime parses a date or an RFC 3339 time, if there is one
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// parseOptionalBool parses true or false, if there is a value
+func parseOptionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseOptionalID parses a positive ID, or zero if there is none
+func parseOptionalID(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid ID")
+	}
+	return id, nil
+}
+
+// HandleAdminSearchUsers is a wrapper around the service AdminSearchUsers method
+func HandleAdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminSearchUsers(w, r)
+}
+
+// HandleAdminGetUser is a wrapper around the service AdminGetUser method
+func HandleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminGetUser(w, r)
+}
+
+// HandleAdminListSessions is a wrapper around the service AdminListSessions method
+func HandleAdminListSessions(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminListSessions(w, r)
+}
+
+// HandleAdminListAPIKeys is a wrapper around the service AdminListAPIKeys method
+func HandleAdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminListAPIKeys(w, r)
+}
+
+// HandleAdminForcePasswordReset is a wrapper around the service AdminForcePasswordReset method
+func HandleAdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminForcePasswordReset(w, r)
+}
+
+// HandleAdminSuspendUser is a wrapper around the service AdminSuspendUser method
+func HandleAdminSuspendUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminSuspendUser(w, r)
+}
+
+// HandleAdminUnsuspendUser is a wrapper around the service AdminUnsuspendUser method
+func HandleAdminUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminUnsuspendUser(w, r)
+}
+
+// HandleAdminResendVerification is a wrapper around the service AdminResendVerification method
+func HandleAdminResendVerification(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminResendVerification(w, r)
+}
+
+// HandleAdminDeleteUser is a wrapper around the service AdminDeleteUser method
+func HandleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminDeleteUser(w, r)
+}
+
+// HandleAdminListAuditLog is a wrapper around the service AdminListAuditLog method
+func HandleAdminListAuditLog(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AdminListAuditLog(w, r)
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+)
+
+// createJane adds a second, verified user who can sign in with password123
+func createJane(t *testing.T, db database.Database) *User {
+	t.Helper()
+
+	john, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	jane, err := db.Users().CreateUser(context.Background(), &database.User{
+		Name:            "Jane",
+		Email:           "jane@example.com",
+		Password:        john.Password,
+		EmailVerifiedAt: john.EmailVerifiedAt,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Jane: %v", err)
+	}
+	return jane
+}
+
+func auditActions(t *testing.T, service *Service, db database.Database, query, accessToken string) []string {
+	t.Helper()
+
+	rr := serveAPI(service, "GET", "/api/admin/audit-log"+query, "", accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response AuditLogResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	actions := []string{}
+	for _, entry := range response.Entries {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+func TestAdminSearchUsers(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	createJane(t, db)
+	db.Users().CreateUser(context.Background(), &database.User{Name: "Unverified", Email: "new@example.com"})
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"everyone", "", []string{"new@example.com", "jane@example.com", "john@example.com"}},
+		{"by email", "?q=JANE", []string{"jane@example.com"}},
+		{"verified", "?verified=false", []string{"new@example.com"}},
+		{"created after", "?created_after=2999-01-01", []string{}},
+		{"page", "?limit=1&offset=1", []string{"jane@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(service, "GET", "/api/admin/users"+tt.query, "", session.Token)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			var response AdminUsersResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			addresses := []string{}
+			for _, user := range response.Users {
+				addresses = append(addresses, user.Email)
+			}
+			if strings.Join(addresses, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, addresses)
+			}
+		})
+	}
+
+	for _, query := range []string{"?verified=maybe", "?created_after=yesterday", "?limit=-1"} {
+		if rr := serveAPI(service, "GET", "/api/admin/users"+query, "", session.Token); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestAdminEndpoints_RequirePermission(t *testing.T) {
+	service, db, _, _ := setupAdmin(t)
+	createJane(t, db)
+
+	session := postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	var jane AuthResponse
+	json.NewDecoder(session.Body).Decode(&jane)
+
+	for _, path := range []string{"/api/admin/users", "/api/admin/users/1", "/api/admin/audit-log"} {
+		if rr := serveAPI(service, "GET", path, "", jane.Token); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected %s to be forbidden, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestAdminSuspendUser(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	jane := createJane(t, db)
+	path := "/api/admin/users/" + strconv.Itoa(jane.ID)
+
+	rr := postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	var janeSession AuthResponse
+	json.NewDecoder(rr.Body).Decode(&janeSession)
+
+	rr = serveAPI(service, "POST", path+"/suspend", `{"reason": "spam"}`, session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if !response.User.Suspended() {
+		t.Error("Expected Jane to be suspended")
+	}
+
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", janeSession.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected Jane's sessions to be revoked, got %d", rr.Code)
+	}
+	rr = postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeAccountSuspended) {
+		t.Errorf("Expected a suspended user not to sign in, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(service, "POST", "/api/admin/users/"+strconv.Itoa(session.User.ID)+"/suspend", `{}`, session.Token)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeCannotTargetSelf) {
+		t.Errorf("Expected admins not to suspend themselves, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := serveAPI(service, "POST", path+"/unsuspend", "", session.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected Jane to sign in again, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	actions := auditActions(t, service, db, "?user_id="+strconv.Itoa(jane.ID), session.Token)
+	if strings.Join(actions, ",") != AuditActionUnsuspend+","+AuditActionSuspend {
+		t.Errorf("Expected the suspension to be audited, got %v", actions)
+	}
+}
+
+// requirePasswordReset makes John reset the password before signing in again
+func requirePasswordReset(t *testing.T, db database.Database) {
+	t.Helper()
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	user.PasswordResetRequired = true
+	if _, err := db.Users().UpdateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to require a password reset: %v", err)
+	}
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	service, db, emails, session := setupAdmin(t)
+	service.SetEmailTokens(email.NewMemoryTokenManager(emails, func(address string) (int, bool) {
+		user, err := db.Users().GetUserByEmail(context.Background(), address)
+		if err != nil {
+			return 0, false
+		}
+		return user.ID, true
+	}))
+	jane := createJane(t, db)
+
+	rr := serveAPI(service, "POST", "/api/admin/users/"+strconv.Itoa(jane.ID)+"/password-reset", "", session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if len(emails.resetCodes) != 1 {
+		t.Fatalf("Expected a reset code to be sent, got %d", len(emails.resetCodes))
+	}
+
+	rr = postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodePasswordResetRequired) {
+		t.Errorf("Expected the old password to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := postResetPassword(service, "jane@example.com", emails.resetCodes[0], "NewPassword1!"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the reset to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postLogin(service, "jane@example.com", "NewPassword1!", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the new password to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminResendVerification(t *testing.T) {
+	service, db, emails, session := setupAdmin(t)
+	unverified, _ := db.Users().CreateUser(context.Background(), &database.User{Name: "New", Email: "new@example.com"})
+	path := "/api/admin/users/" + strconv.Itoa(unverified.ID) + "/verification/resend"
+
+	service.SetEmailTokens(nil)
+	if rr := serveAPI(service, "POST", path, "", session.Token); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without emailed tokens, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	service.SetEmailTokens(email.NewMemoryTokenManager(emails, nil))
+	if rr := serveAPI(service, "POST", path, "", session.Token); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if len(emails.verificationURLs) != 1 {
+		t.Errorf("Expected a verification email, got %d", len(emails.verificationURLs))
+	}
+
+	rr := serveAPI(service, "POST", "/api/admin/users/"+strconv.Itoa(session.User.ID)+"/verification/resend", "", session.Token)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeEmailAlreadyVerified) {
+		t.Errorf("Expected a verified address to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminDeleteUser(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	jane := createJane(t, db)
+	path := "/api/admin/users/" + strconv.Itoa(jane.ID)
+
+	if rr := serveAPI(service, "GET", path, "", session.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", path, "", session.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "GET", path, "", session.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected Jane to be gone, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "DELETE", "/api/admin/users/"+strconv.Itoa(session.User.ID), "", session.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected admins not to delete themselves, got %d", rr.Code)
+	}
+
+	actions := auditActions(t, service, db, "?action="+AuditActionDelete, session.Token)
+	if len(actions) != 1 {
+		t.Errorf("Expected the deletion to outlive the account in the audit log, got %v", actions)
+	}
+	actions = auditActions(t, service, db, "?actor_id="+strconv.Itoa(session.User.ID)+"&limit=2", session.Token)
+	if strings.Join(actions, ",") != AuditActionDelete+","+AuditActionViewUser {
+		t.Errorf("Expected the latest actions first, got %v", actions)
+	}
+}
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/apikey"
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// maxAPIKeyNameLength caps the label users give their API keys
+const maxAPIKeyNameLength = 64
+
+// APIKeyInfo describes one of the user's API keys. The secret is never
+// returned after creation.
+type APIKeyInfo struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeysResponse is the body of GET /api/user/api-keys
+type APIKeysResponse struct {
+	APIKeys []APIKeyInfo `json:"api_keys"`
+}
+
+// CreateAPIKeyRequest is the body of POST /api/user/api-keys
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse returns a new key. Key is shown only this once.
+type CreateAPIKeyResponse struct {
+	Key    string     `json:"key"`
+	APIKey APIKeyInfo `json:"api_key"`
+}
+
+// ListAPIKeys returns the authenticated user's API keys
+func (s *Service) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.db.APIKeys().ListUserAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := APIKeysResponse{APIKeys: []APIKeyInfo{}}
+	for _, key := range keys {
+		response.APIKeys = append(response.APIKeys, apiKeyInfo(key))
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateAPIKey creates an API key for the authenticated user
+func (s *Service) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCreateAPIKeyRequest(&req); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	raw, prefix, err := apikey.Generate()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := s.db.APIKeys().CreateAPIKey(r.Context(), &database.APIKey{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   apikey.Hash(raw),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "An API key named \""+key.Name+"\" was created for your account.")
+
+	writeJSONResponse(w, CreateAPIKeyResponse{Key: raw, APIKey: apiKeyInfo(key)}, http.StatusCreated)
+}
+
+// RevokeAPIKey revokes one of the authenticated user's API keys
+func (s *Service) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.APIKeys().RevokeAPIKey(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, database.ErrAPIKeyNotFound) {
+			writeErrorResponse(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "API key revoked"}, http.StatusOK)
+}
+
+// validateCreateAPIKeyRequest normalizes and checks a new key's name, scopes and expiry
+func validateCreateAPIKeyRequest(req *CreateAPIKeyRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return &ValidationError{"Name is required"}
+	}
+	if len(req.Name) > maxAPIKeyNameLength {
+		return &ValidationError{"Name is too long"}
+	}
+
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return err
+	}
+	req.Scopes = scopes
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return &ValidationError{"Expiry must be in the future"}
+	}
+
+	return nil
+}
+
+// normalizeScopes checks scopes requested for an API key or OAuth client
+// and drops duplicates
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, &ValidationError{"At least one scope is required"}
+	}
+	seen := make(map[string]bool, len(requested))
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if !apikey.ValidScope(scope) {
+			return nil, &ValidationError{"Unknown scope: " + scope}
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// apiKeyInfo converts a stored key to its API representation
+func apiKeyInfo(key *database.APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		ExpiresAt:  key.ExpiresAt,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// HandleListAPIKeys is a wrapper around the service ListAPIKeys method
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListAPIKeys(w, r)
+}
+
+// HandleCreateAPIKey is a wrapper around the service CreateAPIKey method
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateAPIKey(w, r)
+}
+
+// HandleRevokeAPIKey is a wrapper around the service RevokeAPIKey method
+func HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RevokeAPIKey(w, r)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/apikey"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+func createTestAPIKey(t *testing.T, service *Service, db database.Database, accessToken, body string) CreateAPIKeyResponse {
+	t.Helper()
+
+	rr := serveAPI(service, "POST", "/api/user/api-keys", body, accessToken)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response CreateAPIKeyResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func TestAPIKeys_CreateListRevoke(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+
+	created := createTestAPIKey(t, service, db, login.Token, `{"name": " CI ", "scopes": ["metrics:read", "metrics:read"]}`)
+	if !apikey.IsKey(created.Key) || !strings.HasPrefix(created.Key, created.APIKey.Prefix) {
+		t.Errorf("Unexpected key %q with prefix %q", created.Key, created.APIKey.Prefix)
+	}
+	if created.APIKey.Name != "CI" || len(created.APIKey.Scopes) != 1 {
+		t.Errorf("Expected name and scopes to be normalized, got %+v", created.APIKey)
+	}
+	if len(emails.alerts) != 1 {
+		t.Errorf("Expected a security alert, got %v", emails.alerts)
+	}
+
+	rr := serveAPI(service, "GET", "/api/user/api-keys", "", login.Token)
+	if strings.Contains(rr.Body.String(), created.Key) {
+		t.Error("Expected the secret not to be listed")
+	}
+	var list APIKeysResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.APIKeys) != 1 || list.APIKeys[0].ID != created.APIKey.ID {
+		t.Fatalf("Unexpected keys: %+v", list)
+	}
+
+	path := "/api/user/api-keys/" + strconv.Itoa(created.APIKey.ID)
+	if rr := serveAPI(service, "DELETE", path, "", login.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Revoke failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", path, "", login.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking twice to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	handler := middleware.RequireAuth(db, service.tokens)(middleware.RequireScope(apikey.ScopeMetricsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	req := httptest.NewRequest("GET", "/api/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked key to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestAPIKeys_Validation(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"scopes": ["metrics:read"]}`},
+		{"name too long", `{"name": "` + strings.Repeat("a", maxAPIKeyNameLength+1) + `", "scopes": ["metrics:read"]}`},
+		{"no scopes", `{"name": "ci"}`},
+		{"unknown scope", `{"name": "ci", "scopes": ["admin"]}`},
+		{"expiry in the past", `{"name": "ci", "scopes": ["metrics:read"], "expires_at": "` + past + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(service, "POST", "/api/user/api-keys", tt.body, login.Token)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIKeys_CannotManageKeys(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	created := createTestAPIKey(t, service, db, login.Token, `{"name": "ci", "scopes": ["profile:read", "profile:write", "metrics:read"]}`)
+
+	// Key management is not scoped, so no key can reach it
+	rr := serveAPI(service, "POST", "/api/user/api-keys", `{"name": "escalate", "scopes": ["metrics:read"]}`, created.Key)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an API key to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,15 +1,23 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/danielsaas/generic-saas/internal/config"
 	"github.com/danielsaas/generic-saas/internal/database"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/mfa"
+	"github.com/danielsaas/generic-saas/internal/oidc"
+	"github.com/danielsaas/generic-saas/internal/password"
+	"github.com/danielsaas/generic-saas/internal/token"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
 )
 
 // Use the User type from the database package
@@ -27,26 +35,182 @@ type RegisterRequest struct {
 }
 
 type AuthResponse struct {
-	Token string `json:"token,omitempty"`
-	User  User   `json:"user"`
+	Token        string `json:"token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	User         User   `json:"user"`
+
+	// CSRFToken is returned instead of the tokens when they are sent as
+	// session cookies. Browsers send it back in middleware.CSRFHeader.
+	CSRFToken string `json:"csrf_token,omitempty"`
+
+	// sessionID is the session the tokens were issued for
+	sessionID string
 }
 
+// CodeRegistrationClosed is returned when an account would be created while
+// open registration is off
+const CodeRegistrationClosed = "registration_closed"
+
+// errRegistrationClosed means a login would have created an account while
+// open registration is off
+var errRegistrationClosed = errors.New("registration is closed")
+
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 // Service holds the auth service dependencies
 type Service struct {
-	db database.Database
+	db           database.Database
+	tokens       *token.Manager
+	refreshTTL   time.Duration
+	emailService email.EmailService
+
+	// Passwords
+	passwords      password.Hasher
+	passwordPolicy password.Policy
+
+	// Two-factor authentication
+	secretBox   *mfa.SecretBox
+	mfaIssuer   string
+	mfaAttempts *challengeAttempts
+
+	// Passkeys
+	relyingParty *webauthn.RelyingParty
+
+	// OpenID Connect login providers
+	oidcProviders []*oidc.Provider
+
+	// Brute-force protection on password login
+	loginThrottle LoginThrottle
+
+	// Emailed password reset codes, verification links and sign-in links
+	emailTokens EmailTokens
+
+	// Whether new accounts may be created
+	openRegistration bool
+
+	// How long a deleted account is kept before it is purged
+	deletionGracePeriod time.Duration
+
+	// Address of the account given the admin role while nobody has it
+	bootstrapAdminEmail string
+
+	// Administrators signing in as other users
+	impersonationTTL    time.Duration
+	notifyImpersonation bool
+
+	// How long an emailed organization invitation stays valid
+	invitationTTL time.Duration
+
+	// Alerting users to sign-ins from devices they haven't used before
+	newDeviceAlerts    bool
+	deviceCookieMaxAge time.Duration
+
+	// Cookie transport for browser sessions
+	sessionCookies SessionCookies
+
+	// Lifetimes of tokens issued to OAuth clients
+	oauthAccessTTL  time.Duration
+	oauthRefreshTTL time.Duration
+
+	// Base URL of the SAML service provider endpoints, and the DNS lookup
+	// that checks organizations own the domains they claim
+	samlBaseURL string
+	lookupTXT   func(ctx context.Context, name string) ([]string, error)
+
+	// Base URL of the SCIM provisioning endpoints
+	scimBaseURL string
+}
+
+// EmailTokens issues and redeems the codes and links sent by email.
+// email.TokenManager and email.MemoryTokenManager implement it.
+type EmailTokens interface {
+	RequestPasswordReset(req email.PasswordResetRequest) error
+	VerifyPasswordResetCode(email, code string) (*email.EmailToken, error)
+	RequestEmailVerification(req email.EmailVerificationRequest) error
+	VerifyEmailToken(token string) (*email.EmailToken, error)
+	RequestMagicLink(req email.MagicLinkRequest) error
+	VerifyMagicLink(token string) (*email.EmailToken, error)
+	RequestEmailChange(req email.EmailChangeRequest) error
+	VerifyEmailChange(token string) (*email.EmailToken, error)
+	CancelEmailChange(token string) (*email.EmailToken, error)
 }
 
 // NewService creates a new auth service
-func NewService(db database.Database) *Service {
+func NewService(db database.Database, tokens *token.Manager) *Service {
+	authConfig := config.GetAuthConfig()
 	return &Service{
-		db: db,
+		db:                  db,
+		tokens:              tokens,
+		refreshTTL:          authConfig.RefreshTokenTTL,
+		passwords:           password.Default(),
+		passwordPolicy:      password.DefaultPolicy(),
+		mfaIssuer:           authConfig.MFAIssuer,
+		mfaAttempts:         newChallengeAttempts(),
+		openRegistration:    authConfig.OpenRegistration,
+		deletionGracePeriod: authConfig.AccountDeletionGracePeriod,
+		bootstrapAdminEmail: strings.ToLower(strings.TrimSpace(authConfig.BootstrapAdminEmail)),
+		impersonationTTL:    authConfig.ImpersonationTTL,
+		notifyImpersonation: authConfig.ImpersonationNotifyUser,
+		invitationTTL:       authConfig.InvitationTTL,
+		newDeviceAlerts:     authConfig.NewDeviceAlerts,
+		deviceCookieMaxAge:  authConfig.DeviceCookieMaxAge,
+		oauthAccessTTL:      authConfig.OAuthAccessTokenTTL,
+		oauthRefreshTTL:     authConfig.OAuthRefreshTokenTTL,
+		samlBaseURL:         authConfig.SAMLBaseURL,
+		lookupTXT:           net.DefaultResolver.LookupTXT,
+		scimBaseURL:         authConfig.SCIMBaseURL,
+		sessionCookies: SessionCookies{
+			Enabled:  authConfig.SessionCookies,
+			Domain:   authConfig.SessionCookieDomain,
+			Secure:   authConfig.SessionCookieSecure,
+			SameSite: parseSameSite(authConfig.SessionCookieSameSite),
+		},
+		loginThrottle: LoginThrottle{
+			MaxFailures:      authConfig.LoginMaxFailures,
+			MaxFailuresPerIP: authConfig.LoginMaxFailuresPerIP,
+			LockoutDuration:  authConfig.LoginLockoutDuration,
+		},
 	}
 }
 
+// SetEmailService sets the service used for security notifications
+func (s *Service) SetEmailService(emailService email.EmailService) {
+	s.emailService = emailService
+}
+
+// SetPasswordHasher sets how passwords are hashed. Stored hashes made
+// another way are upgraded the next time their user logs in.
+func (s *Service) SetPasswordHasher(hasher password.Hasher) {
+	s.passwords = hasher
+}
+
+// SetPasswordPolicy sets the rules new passwords must meet
+func (s *Service) SetPasswordPolicy(policy password.Policy) {
+	s.passwordPolicy = policy
+}
+
+// SetEmailTokens sets the store for emailed codes and links. Password reset,
+// email verification and magic-link login are unavailable until it is set.
+func (s *Service) SetEmailTokens(tokens EmailTokens) {
+	s.emailTokens = tokens
+}
+
+// SetOpenRegistration sets whether new accounts may be created
+func (s *Service) SetOpenRegistration(open bool) {
+	s.openRegistration = open
+}
+
+// SetSecretBox sets the cipher used to encrypt TOTP secrets. Two-factor
+// enrollment is unavailable until it is set.
+func (s *Service) SetSecretBox(box *mfa.SecretBox) {
+	s.secretBox = box
+}
+
 // Login handles user login
 func (s *Service) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -66,32 +230,70 @@ func (s *Service) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse to check the password while the email or client IP is throttled
+	emailKey, ipKey := loginKeys(r, req.Email)
+	wait, locked, err := s.loginRetryAfter(r.Context(), time.Now(), emailKey, ipKey)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeLoginThrottled(w, wait, locked)
+		return
+	}
+
 	// Find user by email
 	user, err := s.db.Users().GetUserByEmail(r.Context(), req.Email)
-	if err != nil {
-		if errors.Is(err, database.ErrUserNotFound) {
-			writeErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
-			return
-		}
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
 		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+	if user == nil || s.passwords.Verify(req.Password, user.Password) != nil {
+		if err := s.recordLoginFailure(r, emailKey, ipKey, user); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 		writeErrorResponse(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate token (simplified for now)
-	token := generateToken(user.ID)
+	if err := s.db.LoginAttempts().ClearLoginAttempts(r.Context(), emailKey); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only someone who knows the password learns why they can't sign in
+	if user.Suspended() {
+		writeCodedErrorResponse(w, "This account has been suspended", CodeAccountSuspended, http.StatusForbidden)
+		return
+	}
+	if user.PasswordResetRequired {
+		writeCodedErrorResponse(w, "Reset your password to sign in", CodePasswordResetRequired, http.StatusForbidden)
+		return
+	}
 
-	response := AuthResponse{
-		Token: token,
-		User:  *user,
+	s.upgradePasswordHash(r, user, req.Password)
+
+	// With two-factor enabled the password only earns a challenge token
+	if user.TOTPEnabled {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	response, err := s.startSession(w, r, user, AuthMethodPassword)
+	if err != nil {
+		writeSessionError(w, err)
+		return
 	}
 
-	writeJSONResponse(w, response, http.StatusOK)
+	s.writeSession(w, response, http.StatusOK)
 }
 
 // Register handles user registration
@@ -101,6 +303,11 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.openRegistration {
+		writeCodedErrorResponse(w, "Registration is closed", CodeRegistrationClosed, http.StatusForbidden)
+		return
+	}
+
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
@@ -108,13 +315,13 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate input
-	if err := validateRegisterRequest(req); err != nil {
+	if err := s.validateRegisterRequest(r.Context(), req); err != nil {
 		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.Hash(req.Password)
 	if err != nil {
 		writeErrorResponse(w, "Failed to process password", http.StatusInternalServerError)
 		return
@@ -124,7 +331,7 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 	user := &User{
 		Name:     req.Name,
 		Email:    strings.ToLower(strings.TrimSpace(req.Email)),
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 	}
 
 	// Save user to database
@@ -138,6 +345,8 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.sendVerificationEmail(r, createdUser)
+
 	response := AuthResponse{
 		User: *createdUser,
 	}
@@ -145,7 +354,27 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, response, http.StatusCreated)
 }
 
-func validateRegisterRequest(req RegisterRequest) error {
+// upgradePasswordHash rehashes a just-verified password if its stored hash
+// is out of date. Failing to is harmless, so errors are ignored and the
+// upgrade is tried again at the next login.
+func (s *Service) upgradePasswordHash(r *http.Request, user *User, plaintext string) {
+	if !s.passwords.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(plaintext)
+	if err != nil {
+		return
+	}
+
+	upgraded := *user
+	upgraded.Password = hashedPassword
+	if updated, err := s.db.Users().UpdateUser(r.Context(), &upgraded); err == nil {
+		*user = *updated
+	}
+}
+
+func (s *Service) validateRegisterRequest(ctx context.Context, req RegisterRequest) error {
 	if strings.TrimSpace(req.Name) == "" {
 		return &ValidationError{"Name is required"}
 	}
@@ -162,26 +391,28 @@ func validateRegisterRequest(req RegisterRequest) error {
 		return &ValidationError{"Invalid email format"}
 	}
 
-	if len(req.Password) < 8 {
-		return &ValidationError{"Password must be at least 8 characters"}
-	}
-
-	// Check for uppercase letter
-	if !strings.ContainsAny(req.Password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
-		return &ValidationError{"Password must contain at least one uppercase letter"}
-	}
+	return s.checkNewPassword(ctx, req.Password, &User{Name: req.Name, Email: req.Email})
+}
 
-	// Check for lowercase letter
-	if !strings.ContainsAny(req.Password, "abcdefghijklmnopqrstuvwxyz") {
-		return &ValidationError{"Password must contain at least one lowercase letter"}
+// checkNewPassword applies the password policy to a password about to be
+// set for the user. Policy violations are returned as *ValidationError.
+// A user without an ID is still registering and has no password history.
+func (s *Service) checkNewPassword(ctx context.Context, candidate string, user *User) error {
+	account := password.Account{Name: user.Name, Email: user.Email}
+	if user.ID != 0 {
+		hashes, err := s.passwordPolicy.RecentHashes(ctx, s.db.PasswordHistory(), user.ID, user.Password)
+		if err != nil {
+			return err
+		}
+		account.PasswordHashes = hashes
 	}
 
-	// Check for special character
-	if !strings.ContainsAny(req.Password, "!@#$%^&*()_+-=[]{}|;':\",./<>?") {
-		return &ValidationError{"Password must contain at least one special character"}
+	err := s.passwordPolicy.Check(ctx, candidate, account, s.passwords)
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return &ValidationError{policyErr.Message}
 	}
-
-	return nil
+	return err
 }
 
 func isValidEmail(email string) bool {
@@ -215,9 +446,22 @@ func HandleRegister(w http.ResponseWriter, r *http.Request) {
 	globalAuthService.Register(w, r)
 }
 
-func generateToken(userID int) string {
-	// Simplified token generation - in production use JWT
-	return "token_" + time.Now().Format("20060102150405") + "_" + string(rune(userID+48))
+// HandleRefresh is a wrapper around the service Refresh method
+func HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Refresh(w, r)
+}
+
+// HandleLogout is a wrapper around the service Logout method
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Logout(w, r)
 }
 
 func writeJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
@@ -231,6 +475,12 @@ func writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 	writeJSONResponse(w, response, statusCode)
 }
 
+// writeCodedErrorResponse writes an error with a machine-readable code
+func writeCodedErrorResponse(w http.ResponseWriter, message, code string, statusCode int) {
+	response := ErrorResponse{Error: message, Code: code}
+	writeJSONResponse(w, response, statusCode)
+}
+
 type ValidationError struct {
 	message string
 }
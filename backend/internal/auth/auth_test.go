@@ -9,15 +9,91 @@ import (
 	"testing"
 
 	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/mfa"
+	"github.com/danielsaas/generic-saas/internal/password"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
 )
 
+func newTestTokenManager() *token.Manager {
+	tokens, err := token.NewManager(token.Config{
+		Algorithm: token.HS256,
+		Secret:    []byte("test-secret-that-is-at-least-32-bytes"),
+		KeyID:     "test",
+		Issuer:    "https://test.example.com",
+		Audience:  "test-api",
+	})
+	if err != nil {
+		panic(err)
+	}
+	return tokens
+}
+
 func setupTestService() (*Service, database.Database) {
 	db := database.NewMemoryDatabase()
-	service := NewService(db)
+	service := NewService(db, newTestTokenManager())
 	SetService(service) // Set for global handlers
 	return service, db
 }
 
+// setupFullTestService returns a service with two-factor codes, passkeys
+// and emailed codes and links configured, recording the email it sends
+func setupFullTestService(t *testing.T) (*Service, database.Database, *recordingEmailService) {
+	t.Helper()
+
+	service, db := setupTestService()
+	box, err := mfa.NewSecretBox([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+	service.SetSecretBox(box)
+
+	rp, err := webauthn.NewRelyingParty(webauthn.Config{
+		RPID:    "app.example.com",
+		RPName:  "Example",
+		Origins: []string{testPasskeyOrigin},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create relying party: %v", err)
+	}
+	service.SetRelyingParty(rp)
+
+	emails := &recordingEmailService{}
+	service.SetEmailService(emails)
+	service.SetEmailTokens(email.NewMemoryTokenManager(emails, func(address string) (int, bool) {
+		user, err := db.Users().GetUserByEmail(context.Background(), address)
+		if err != nil {
+			return 0, false
+		}
+		return user.ID, true
+	}))
+	return service, db, emails
+}
+
+// serveRoutes sends a request through the auth routes as the server
+// registers them, so it passes the same middleware as in production
+func serveRoutes(service *Service, req *http.Request) *httptest.ResponseRecorder {
+	SetService(service)
+	mux := http.NewServeMux()
+	protected := http.NewServeMux()
+	RegisterRoutes(mux, protected, service.db)
+	mux.Handle("/api/", middleware.RequireAuth(service.db, service.tokens)(protected))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+// serveAPI sends a request to the auth routes with credential, an access
+// token, API key or OAuth access token, as its bearer token
+func serveAPI(service *Service, method, path, body, credential string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+credential)
+	return serveRoutes(service, req)
+}
+
 func TestService_Register(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -30,7 +106,7 @@ func TestService_Register(t *testing.T) {
 			requestBody: `{
 				"name": "John Doe",
 				"email": "john@example.com",
-				"password": "password123"
+				"password": "Password123!"
 			}`,
 			expectedStatus: http.StatusCreated,
 			expectedError:  "",
@@ -40,7 +116,7 @@ func TestService_Register(t *testing.T) {
 			requestBody: `{
 				"name": "",
 				"email": "john@example.com",
-				"password": "password123"
+				"password": "Password123!"
 			}`,
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Name is required",
@@ -50,7 +126,7 @@ func TestService_Register(t *testing.T) {
 			requestBody: `{
 				"name": "John Doe",
 				"email": "invalid-email",
-				"password": "password123"
+				"password": "Password123!"
 			}`,
 			expectedStatus: http.StatusBadRequest,
 			expectedError:  "Invalid email format",
@@ -126,7 +202,7 @@ func TestService_Register_DuplicateEmail(t *testing.T) {
 	requestBody := `{
 		"name": "Jane Doe",
 		"email": "john@example.com",
-		"password": "password123"
+		"password": "Password123!"
 	}`
 
 	req := httptest.NewRequest("POST", "/auth/register", strings.NewReader(requestBody))
@@ -251,6 +327,13 @@ func TestService_Login(t *testing.T) {
 				if response.Token == "" {
 					t.Error("Expected token in response")
 				}
+				claims, err := service.tokens.VerifyType(response.Token, token.TypeAccess)
+				if err != nil {
+					t.Fatalf("Expected a verifiable access token, got: %v", err)
+				}
+				if claims.Subject != "1" {
+					t.Errorf("Expected subject '1', got '%s'", claims.Subject)
+				}
 				if response.User.Email != "john@example.com" {
 					t.Errorf("Expected user email 'john@example.com', got '%s'", response.User.Email)
 				}
@@ -259,6 +342,71 @@ func TestService_Login(t *testing.T) {
 	}
 }
 
+func TestService_Login_UpgradesPasswordHash(t *testing.T) {
+	service, db := setupTestService()
+
+	// loginTestUser stores a bcrypt hash, which the default argon2id hasher
+	// treats as outdated
+	loginTestUser(t, service, db)
+
+	user, err := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if err != nil {
+		t.Fatalf("Failed to load user: %v", err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("Expected the hash to be upgraded to argon2id, got %q", user.Password)
+	}
+
+	// The upgraded hash still works, and isn't rewritten again
+	upgraded := user.Password
+	loginAgain(t, service)
+	user, _ = db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if user.Password != upgraded {
+		t.Error("Expected a current hash to be left alone")
+	}
+
+	// A failed login never touches the hash
+	if rr := postLogin(service, "john@example.com", "wrong-password", "192.0.2.9:1234"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	user, _ = db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if user.Password != upgraded {
+		t.Error("Expected a failed login to leave the hash alone")
+	}
+}
+
+func TestService_Login_AddsPepperToOldHashes(t *testing.T) {
+	service, db := setupTestService()
+
+	// Hashes made before the pepper was configured still sign in
+	loginTestUser(t, service, db)
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	unpeppered := user.Password
+	if strings.Contains(unpeppered, ",keyid=") {
+		t.Fatalf("Expected a hash without a pepper, got %q", unpeppered)
+	}
+
+	config := password.DefaultConfig()
+	config.Pepper = "server secret"
+	hasher, err := password.New(config)
+	if err != nil {
+		t.Fatalf("Failed to create hasher: %v", err)
+	}
+	service.SetPasswordHasher(hasher)
+
+	loginAgain(t, service)
+	user, _ = db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if !strings.Contains(user.Password, ",keyid=") {
+		t.Fatalf("Expected the hash to be rehashed with the pepper, got %q", user.Password)
+	}
+	if err := hasher.Verify("password123", user.Password); err != nil {
+		t.Errorf("Expected the peppered hash to verify, got %v", err)
+	}
+
+	// The peppered hash keeps working
+	loginAgain(t, service)
+}
+
 func TestHandleLogin_WithGlobalService(t *testing.T) {
 	// Test the global handler functions
 	_, db := setupTestService()
@@ -307,7 +455,7 @@ func TestHandleRegister_WithGlobalService(t *testing.T) {
 	requestBody := `{
 		"name": "John Doe",
 		"email": "john@example.com",
-		"password": "password123"
+		"password": "Password123!"
 	}`
 
 	req := httptest.NewRequest("POST", "/auth/register", strings.NewReader(requestBody))
@@ -378,7 +526,7 @@ func TestValidateRegisterRequest(t *testing.T) {
 			request: RegisterRequest{
 				Name:     "John Doe",
 				Email:    "john@example.com",
-				Password: "password123",
+				Password: "Password123!",
 			},
 			hasError: false,
 		},
@@ -387,7 +535,7 @@ func TestValidateRegisterRequest(t *testing.T) {
 			request: RegisterRequest{
 				Name:     "",
 				Email:    "john@example.com",
-				Password: "password123",
+				Password: "Password123!",
 			},
 			hasError: true,
 			error:    "Name is required",
@@ -397,7 +545,7 @@ func TestValidateRegisterRequest(t *testing.T) {
 			request: RegisterRequest{
 				Name:     "A",
 				Email:    "john@example.com",
-				Password: "password123",
+				Password: "Password123!",
 			},
 			hasError: true,
 			error:    "Name must be at least 2 characters",
@@ -407,7 +555,7 @@ func TestValidateRegisterRequest(t *testing.T) {
 			request: RegisterRequest{
 				Name:     "John Doe",
 				Email:    "invalid-email",
-				Password: "password123",
+				Password: "Password123!",
 			},
 			hasError: true,
 			error:    "Invalid email format",
@@ -422,11 +570,32 @@ func TestValidateRegisterRequest(t *testing.T) {
 			hasError: true,
 			error:    "Password must be at least 8 characters",
 		},
+		{
+			name: "Password without special character",
+			request: RegisterRequest{
+				Name:     "John Doe",
+				Email:    "john@example.com",
+				Password: "Password123",
+			},
+			hasError: true,
+			error:    "Password must contain at least one special character",
+		},
+		{
+			name: "Password containing name",
+			request: RegisterRequest{
+				Name:     "John Doe",
+				Email:    "jd@example.com",
+				Password: "JohnPassword1!",
+			},
+			hasError: true,
+			error:    "Password must not contain your name or email address",
+		},
 	}
 
+	service, _ := setupTestService()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateRegisterRequest(tt.request)
+			err := service.validateRegisterRequest(context.Background(), tt.request)
 
 			if tt.hasError {
 				if err == nil {
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// CodeCSRFTokenInvalid is returned when a request authenticated by a
+// session cookie lacks the session's CSRF token
+const CodeCSRFTokenInvalid = middleware.AuthCodeCSRFTokenInvalid
+
+// refreshCookiePath limits the refresh token cookie to the endpoints that
+// take it
+const refreshCookiePath = "/auth"
+
+// SessionCookies configures the cookie transport for browser sessions.
+// When it is enabled, endpoints that start or refresh a session put the
+// tokens in HttpOnly cookies instead of the response body, so scripts
+// can't read them.
+type SessionCookies struct {
+	Enabled  bool
+	Domain   string // Empty means the API's own host
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// SetSessionCookies replaces the session cookie settings
+func (s *Service) SetSessionCookies(cookies SessionCookies) {
+	s.sessionCookies = cookies
+}
+
+// writeSession responds with the tokens of a new or refreshed session.
+// With session cookies on they are set as cookies, and the body carries
+// the session's CSRF token instead.
+func (s *Service) writeSession(w http.ResponseWriter, response *AuthResponse, statusCode int) {
+	if !s.sessionCookies.Enabled {
+		writeJSONResponse(w, response, statusCode)
+		return
+	}
+
+	csrfToken, err := s.tokens.Issue(token.Claims{
+		Type:      token.TypeCSRF,
+		SessionID: response.sessionID,
+		ExpiresAt: time.Now().Add(s.refreshTTL).Unix(),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	maxAge := int(s.refreshTTL.Seconds())
+	s.setCookie(w, middleware.AccessTokenCookie, response.Token, "/", maxAge, true)
+	if response.RefreshToken != "" {
+		s.setCookie(w, middleware.RefreshTokenCookie, response.RefreshToken, refreshCookiePath, maxAge, true)
+	}
+	s.setCookie(w, middleware.CSRFCookie, csrfToken, "/", maxAge, false)
+
+	body := *response
+	body.Token = ""
+	body.RefreshToken = ""
+	body.CSRFToken = csrfToken
+	writeJSONResponse(w, body, statusCode)
+}
+
+// clearSessionCookies removes the session cookies from the browser
+func (s *Service) clearSessionCookies(w http.ResponseWriter) {
+	if !s.sessionCookies.Enabled {
+		return
+	}
+	s.setCookie(w, middleware.AccessTokenCookie, "", "/", -1, true)
+	s.setCookie(w, middleware.RefreshTokenCookie, "", refreshCookiePath, -1, true)
+	s.setCookie(w, middleware.CSRFCookie, "", "/", -1, false)
+}
+
+func (s *Service) setCookie(w http.ResponseWriter, name, value, path string, maxAge int, httpOnly bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   s.sessionCookies.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.sessionCookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: s.sessionCookies.SameSite,
+	})
+}
+
+// requestRefreshToken reads the refresh token from the body of a refresh
+// or logout request, or with session cookies on from its cookie. It
+// reports whether the token came from the cookie, in which case the caller
+// must check the CSRF token once it knows the session. It writes the
+// response and returns false if there is no token.
+func (s *Service) requestRefreshToken(w http.ResponseWriter, r *http.Request) (string, bool, bool) {
+	var req RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !(s.sessionCookies.Enabled && errors.Is(err, io.EOF)) {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return "", false, false
+	}
+
+	if req.RefreshToken != "" {
+		return req.RefreshToken, false, true
+	}
+	if s.sessionCookies.Enabled {
+		if cookie, err := r.Cookie(middleware.RefreshTokenCookie); err == nil && cookie.Value != "" {
+			return cookie.Value, true, true
+		}
+	}
+
+	writeErrorResponse(w, "Refresh token is required", http.StatusBadRequest)
+	return "", false, false
+}
+
+// writeCSRFTokenInvalid refuses a cookie-authenticated request without the
+// session's CSRF token
+func writeCSRFTokenInvalid(w http.ResponseWriter) {
+	writeCodedErrorResponse(w, "Missing or invalid CSRF token", CodeCSRFTokenInvalid, http.StatusForbidden)
+}
+
+// parseSameSite maps a SESSION_COOKIE_SAMESITE value to its cookie
+// attribute. Unknown values fall back to Lax.
+func parseSameSite(value string) http.SameSite {
+	switch value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// sessionCookie returns the cookie of the given name a response set
+func sessionCookie(t *testing.T, rr *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	t.Fatalf("Expected a %s cookie, got %v", name, rr.Header().Values("Set-Cookie"))
+	return nil
+}
+
+// postWithCookies calls a refresh or logout handler the way a browser in
+// cookie mode does, with no body
+func postWithCookies(handler func(http.ResponseWriter, *http.Request), path, csrfToken string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		req.Header.Set(middleware.CSRFHeader, csrfToken)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestSessionCookies(t *testing.T) {
+	service, db := setupTestService()
+	service.SetSessionCookies(SessionCookies{Enabled: true, Secure: true, SameSite: http.SameSiteStrictMode})
+	login := loginTestUser(t, service, db)
+
+	if login.Token != "" || login.RefreshToken != "" {
+		t.Error("Expected no tokens in the body in cookie mode")
+	}
+	if login.CSRFToken == "" {
+		t.Fatal("Expected a CSRF token in the body")
+	}
+
+	rr := postLogin(service, "john@example.com", "password123", "192.0.2.1:1234")
+	access := sessionCookie(t, rr, middleware.AccessTokenCookie)
+	refresh := sessionCookie(t, rr, middleware.RefreshTokenCookie)
+	csrf := sessionCookie(t, rr, middleware.CSRFCookie)
+	for _, cookie := range []*http.Cookie{access, refresh, csrf} {
+		if cookie.Value == "" || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("Expected a secure, strict %s cookie, got %+v", cookie.Name, cookie)
+		}
+	}
+	if !access.HttpOnly || !refresh.HttpOnly || csrf.HttpOnly {
+		t.Error("Expected the token cookies to be HttpOnly and the CSRF cookie readable")
+	}
+	if refresh.Path != refreshCookiePath {
+		t.Errorf("Expected the refresh cookie on %s, got %s", refreshCookiePath, refresh.Path)
+	}
+
+	if rr := postWithCookies(service.Refresh, "/auth/refresh", "", refresh); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeCSRFTokenInvalid) {
+		t.Fatalf("Expected a refresh without the CSRF token to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postWithCookies(service.Refresh, "/auth/refresh", login.CSRFToken, refresh); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected another session's CSRF token to be refused, got %d", rr.Code)
+	}
+
+	rr = postWithCookies(service.Refresh, "/auth/refresh", csrf.Value, refresh)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var refreshed AuthResponse
+	json.NewDecoder(rr.Body).Decode(&refreshed)
+	if refreshed.Token != "" || refreshed.RefreshToken != "" || refreshed.CSRFToken == "" {
+		t.Errorf("Expected only a CSRF token in the refresh body, got %+v", refreshed)
+	}
+	refresh = sessionCookie(t, rr, middleware.RefreshTokenCookie)
+	csrf = sessionCookie(t, rr, middleware.CSRFCookie)
+
+	rr = postWithCookies(service.Logout, "/auth/logout", csrf.Value, refresh)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	for _, name := range []string{middleware.AccessTokenCookie, middleware.RefreshTokenCookie, middleware.CSRFCookie} {
+		if cookie := sessionCookie(t, rr, name); cookie.MaxAge >= 0 {
+			t.Errorf("Expected the %s cookie to be cleared, got %+v", name, cookie)
+		}
+	}
+	if rr := postRefreshToken(service, service.Refresh, refresh.Value); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to be signed out, got %d", rr.Code)
+	}
+}
+
+func TestSessionCookies_BodyTokensStillWork(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	service.SetSessionCookies(SessionCookies{Enabled: true, SameSite: http.SameSiteLaxMode})
+
+	// Tokens in the body aren't sent by browsers on their own, so API
+	// clients need no CSRF token
+	if rr := postRefreshToken(service, service.Refresh, login.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("Expected a refresh token in the body to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/config"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Error codes returned by the login report endpoint
+const (
+	CodeLoginReportInvalid = "login_report_invalid"
+)
+
+// DeviceCookieName is the long-lived cookie that lets a browser be
+// recognized when it signs in again
+const DeviceCookieName = "device_id"
+
+// loginReportTTL is how long the "this wasn't me" link in a new-device
+// alert works
+const loginReportTTL = 7 * 24 * time.Hour
+
+// ReportLoginRequest is the body of POST /auth/login/report
+type ReportLoginRequest struct {
+	Token string `json:"token"`
+}
+
+// recognizeDevice looks the signing-in device up among the user's known
+// devices by its cookie, or failing that by its fingerprint, and records
+// it. A new device is remembered and, unless it is the user's first,
+// reported to them. Failures never stop the sign-in.
+func (s *Service) recognizeDevice(w http.ResponseWriter, r *http.Request, user *User, session *database.Session) {
+	ctx := r.Context()
+
+	// A browser keeps one cookie whoever signs in with it
+	var cookieHash string
+	rawCookie := ""
+	if cookie, err := r.Cookie(DeviceCookieName); err == nil && cookie.Value != "" {
+		rawCookie = cookie.Value
+		cookieHash = token.HashOpaque(rawCookie)
+	}
+	fingerprint := deviceFingerprint(r)
+
+	device, err := s.db.KnownDevices().FindKnownDevice(ctx, user.ID, cookieHash, fingerprint)
+	if err != nil && !errors.Is(err, database.ErrKnownDeviceNotFound) {
+		return
+	}
+
+	if rawCookie == "" {
+		if rawCookie, err = token.GenerateOpaque(); err != nil {
+			return
+		}
+		cookieHash = token.HashOpaque(rawCookie)
+	}
+
+	if device != nil {
+		device.TokenHash = cookieHash
+		device.Fingerprint = fingerprint
+		device.UserAgent = r.UserAgent()
+		device.IPAddress = middleware.ClientIP(r)
+		device.LastSeenAt = time.Now()
+		if s.db.KnownDevices().UpdateKnownDevice(ctx, device) == nil {
+			s.setDeviceCookie(w, rawCookie)
+		}
+		return
+	}
+
+	known, err := s.db.KnownDevices().CountKnownDevices(ctx, user.ID)
+	if err != nil {
+		return
+	}
+	_, err = s.db.KnownDevices().CreateKnownDevice(ctx, &database.KnownDevice{
+		UserID:      user.ID,
+		TokenHash:   cookieHash,
+		Fingerprint: fingerprint,
+		UserAgent:   r.UserAgent(),
+		IPAddress:   middleware.ClientIP(r),
+	})
+	if err != nil {
+		return
+	}
+	s.setDeviceCookie(w, rawCookie)
+
+	if known > 0 && s.newDeviceAlerts {
+		s.sendNewDeviceAlert(r, user, session)
+	}
+}
+
+// setDeviceCookie gives the browser the cookie that identifies it
+func (s *Service) setDeviceCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    value,
+		Path:     "/auth",
+		MaxAge:   int(s.deviceCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.GetAppConfig().AppBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// deviceFingerprint hashes the user agent with the network the request
+// came from, so a device is still recognized after a new address from the
+// same provider or the loss of its cookie
+func deviceFingerprint(r *http.Request) string {
+	network := middleware.ClientIP(r)
+	if addr, err := netip.ParseAddr(network); err == nil {
+		bits := 48
+		if addr.Unmap().Is4() {
+			addr, bits = addr.Unmap(), 24
+		}
+		if prefix, err := addr.Prefix(bits); err == nil {
+			network = prefix.String()
+		}
+	}
+
+	sum := sha256.Sum256([]byte(r.UserAgent() + "\n" + network))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendNewDeviceAlert emails the user about a sign-in from a new device
+// with a link that signs the session out and makes them reset their
+// password if it wasn't them
+func (s *Service) sendNewDeviceAlert(r *http.Request, user *User, session *database.Session) {
+	reportToken, err := s.tokens.Issue(token.Claims{
+		Subject:   strconv.Itoa(user.ID),
+		Type:      token.TypeLoginReport,
+		SessionID: session.ID,
+		ExpiresAt: time.Now().Add(loginReportTTL).Unix(),
+	})
+	if err != nil {
+		return
+	}
+
+	reportURL := config.GetAppConfig().AppBaseURL + "/report-login?token=" + url.QueryEscape(reportToken)
+	s.sendSecurityAlert(r, user, "Your account was just signed in to from a device you haven't used before. "+
+		"If this was you, there's nothing to do. If it wasn't, sign that device out and reset your password: "+reportURL)
+}
+
+// ReportLogin handles the "this wasn't me" link of a new-device alert. It
+// signs out the reported session and makes the user reset their password
+// before they can sign in again.
+func (s *Service) ReportLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReportLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.tokens.VerifyType(req.Token, token.TypeLoginReport)
+	if err != nil || claims.SessionID == "" || claims.ID == "" {
+		writeCodedErrorResponse(w, "Invalid or expired report link", CodeLoginReportInvalid, http.StatusBadRequest)
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		writeCodedErrorResponse(w, "Invalid or expired report link", CodeLoginReportInvalid, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	user, err := s.db.Users().GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeCodedErrorResponse(w, "Invalid or expired report link", CodeLoginReportInvalid, http.StatusBadRequest)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The link works once, so a copy of it can't force another reset after
+	// the user has chosen a new password
+	fresh, err := s.consumeLoginReport(ctx, claims, time.Now())
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !fresh {
+		writeCodedErrorResponse(w, "Invalid or expired report link", CodeLoginReportInvalid, http.StatusBadRequest)
+		return
+	}
+
+	// A session that already ended has nothing left to sign out, but the
+	// password still has to change
+	if err := s.revokeSession(ctx, claims.SessionID); err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.PasswordResetRequired {
+		user.PasswordResetRequired = true
+		if _, err := s.db.Users().UpdateUser(ctx, user); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if s.emailTokens != nil {
+			s.emailTokens.RequestPasswordReset(email.PasswordResetRequest{
+				Email:     user.Email,
+				RequestIP: middleware.ClientIP(r),
+				UserAgent: r.UserAgent(),
+			})
+		}
+	}
+
+	writeJSONResponse(w, map[string]string{
+		"message": "The device was signed out. Use the code we emailed you to choose a new password.",
+	}, http.StatusOK)
+}
+
+// consumeLoginReport marks a report link used and reports whether it was
+// still unused. The mark is a throttling key locked until the link expires,
+// so it outlives the purge of stale counters.
+func (s *Service) consumeLoginReport(ctx context.Context, claims *token.Claims, now time.Time) (bool, error) {
+	key := "report:" + claims.ID
+	attempts, err := s.db.LoginAttempts().RecordLoginFailure(ctx, key, now, now.Add(-loginReportTTL))
+	if err != nil {
+		return false, err
+	}
+	if attempts.Failures > 1 {
+		return false, nil
+	}
+	return true, s.db.LoginAttempts().LockLogin(ctx, key, time.Unix(claims.ExpiresAt, 0))
+}
+
+// HandleReportLogin is a wrapper around the service ReportLogin method
+func HandleReportLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ReportLogin(w, r)
+}
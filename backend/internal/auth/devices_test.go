@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+const (
+	firefoxAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	safariAgent  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Version/17.5 Mobile/15E148 Safari/604.1"
+)
+
+// loginFromDevice signs John in from a browser, sending its device cookie
+// if it has one
+func loginFromDevice(service *Service, remoteAddr, userAgent, deviceCookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email": "john@example.com", "password": "password123"}`))
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("User-Agent", userAgent)
+	if deviceCookie != "" {
+		req.AddCookie(&http.Cookie{Name: DeviceCookieName, Value: deviceCookie})
+	}
+	rr := httptest.NewRecorder()
+	service.Login(rr, req)
+	return rr
+}
+
+// deviceCookie returns the device cookie a response set
+func deviceCookie(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == DeviceCookieName && cookie.Value != "" {
+			if !cookie.HttpOnly || cookie.MaxAge <= 0 {
+				t.Errorf("Expected a long-lived HttpOnly device cookie, got %+v", cookie)
+			}
+			return cookie.Value
+		}
+	}
+	t.Fatalf("Expected a device cookie, got %v", rr.Header().Values("Set-Cookie"))
+	return ""
+}
+
+func TestLogin_NewDeviceAlert(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	emails.alerts = nil
+
+	// John's first device was remembered quietly, but a second is reported
+	rr := loginFromDevice(service, "198.51.100.7:1234", firefoxAgent, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	laptop := deviceCookie(t, rr)
+	if len(emails.alerts) != 1 {
+		t.Fatalf("Expected an alert for a second device, got %v", emails.alerts)
+	}
+
+	// The cookie is recognized from anywhere
+	emails.alerts = nil
+	rr = loginFromDevice(service, "203.0.113.9:1234", firefoxAgent+" Updated", laptop)
+	if rr.Code != http.StatusOK || deviceCookie(t, rr) != laptop {
+		t.Fatalf("Expected the laptop to keep its cookie, got %d", rr.Code)
+	}
+
+	// So is the same browser on the same network without its cookie
+	loginFromDevice(service, "203.0.113.200:1234", firefoxAgent+" Updated", "")
+	if len(emails.alerts) != 0 {
+		t.Fatalf("Expected known devices not to be reported, got %v", emails.alerts)
+	}
+
+	rr = loginFromDevice(service, "192.0.2.50:1234", safariAgent, "")
+	if rr.Code != http.StatusOK || len(emails.alerts) != 1 {
+		t.Fatalf("Expected an alert for the phone, got %d: %v", rr.Code, emails.alerts)
+	}
+	if !strings.Contains(emails.alerts[0], "/report-login?token=") {
+		t.Errorf("Expected a link to report the sign-in, got %q", emails.alerts[0])
+	}
+}
+
+func TestLogin_NewDeviceAlertsDisabled(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	service.newDeviceAlerts = false
+	loginTestUser(t, service, db)
+
+	loginFromDevice(service, "192.0.2.50:1234", safariAgent, "")
+	if len(emails.alerts) != 0 {
+		t.Errorf("Expected no alerts, got %v", emails.alerts)
+	}
+	if count, _ := db.KnownDevices().CountKnownDevices(context.Background(), 1); count != 2 {
+		t.Errorf("Expected devices to be remembered anyway, got %d", count)
+	}
+}
+
+func TestMagicLink_NewDeviceAlert(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	emails.alerts = nil
+
+	// A sign-in that never touches the password is reported all the same
+	postMagicLink(service, "john@example.com")
+	req := httptest.NewRequest("GET", "/auth/magic-link/callback?token="+url.QueryEscape(verificationToken(t, emails.magicLinks[0])), nil)
+	req.RemoteAddr = "192.0.2.50:1234"
+	req.Header.Set("User-Agent", safariAgent)
+	rr := httptest.NewRecorder()
+	service.FinishMagicLink(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Magic link sign-in failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	deviceCookie(t, rr)
+	if len(emails.alerts) != 1 || !strings.Contains(emails.alerts[0], "/report-login?token=") {
+		t.Fatalf("Expected an alert for the new device, got %v", emails.alerts)
+	}
+}
+
+func postReportLogin(service *Service, reportToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ReportLoginRequest{Token: reportToken})
+	req := httptest.NewRequest("POST", "/auth/login/report", strings.NewReader(string(body)))
+	rr := httptest.NewRecorder()
+	service.ReportLogin(rr, req)
+	return rr
+}
+
+func TestReportLogin(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	owner := loginTestUser(t, service, db)
+	emails.alerts = nil
+
+	rr := loginFromDevice(service, "192.0.2.50:1234", safariAgent, "")
+	var intruder AuthResponse
+	json.NewDecoder(rr.Body).Decode(&intruder)
+	if len(emails.alerts) != 1 {
+		t.Fatalf("Expected an alert for the new device, got %v", emails.alerts)
+	}
+	reportToken := unlockURLToken(t, emails.alerts[0])
+
+	if rr := postReportLogin(service, "not-a-token"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeLoginReportInvalid) {
+		t.Errorf("Expected an invalid link to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postReportLogin(service, owner.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an access token not to work as a report link, got %d", rr.Code)
+	}
+
+	if rr := postReportLogin(service, reportToken); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if rr := postRefreshToken(service, service.Refresh, intruder.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the reported session to be signed out, got %d", rr.Code)
+	}
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if !user.PasswordResetRequired {
+		t.Error("Expected a password reset to be required")
+	}
+	rr = loginFromDevice(service, "192.0.2.50:1234", safariAgent, "")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodePasswordResetRequired) {
+		t.Errorf("Expected sign-in to wait for the reset, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The link works once
+	if rr := postReportLogin(service, reportToken); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeLoginReportInvalid) {
+		t.Errorf("Expected a used link to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReportLogin_SessionAlreadyEnded(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	owner := loginTestUser(t, service, db)
+	emails.alerts = nil
+
+	loginFromDevice(service, "192.0.2.50:1234", safariAgent, "")
+	reportToken := unlockURLToken(t, emails.alerts[0])
+
+	// The owner signed the device out before following the link
+	var intruderID string
+	for _, session := range listSessions(t, service, db, owner.Token) {
+		if !session.Current {
+			intruderID = session.ID
+		}
+	}
+	if rr := serveAPI(service, "DELETE", "/api/user/sessions/"+intruderID, "", owner.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the session to be revoked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postReportLogin(service, reportToken); rr.Code != http.StatusOK {
+		t.Fatalf("Expected a revoked session to be reported, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A session that is gone altogether still gets the password reset
+	goneToken, err := service.tokens.Issue(token.Claims{
+		Subject:   strconv.Itoa(owner.User.ID),
+		Type:      token.TypeLoginReport,
+		SessionID: "gone",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to issue a report token: %v", err)
+	}
+	if rr := postReportLogin(service, goneToken); rr.Code != http.StatusOK {
+		t.Fatalf("Expected a missing session to be reported, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if !user.PasswordResetRequired {
+		t.Error("Expected a password reset to be required")
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// Error codes returned by the email change endpoints
+const (
+	CodeEmailChangeTokenInvalid  = "email_change_token_invalid"
+	CodeEmailChangeNotConfigured = "email_change_not_configured"
+)
+
+// errEmailChangeNotConfigured means there is no token manager to send the
+// confirmation link with
+var errEmailChangeNotConfigured = errors.New("email change is not configured")
+
+// RequestEmailChange starts moving a user to a new email address. The
+// account keeps its current address until the link sent to the new one is
+// followed, and the current address is sent a link to cancel the change.
+// It returns database.ErrUserAlreadyExists if another account has the
+// address.
+func (s *Service) RequestEmailChange(r *http.Request, user *User, newEmail string) error {
+	if s.emailTokens == nil {
+		return errEmailChangeNotConfigured
+	}
+
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	_, err := s.db.Users().GetUserByEmail(r.Context(), newEmail)
+	if err == nil {
+		return database.ErrUserAlreadyExists
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return err
+	}
+
+	return s.emailTokens.RequestEmailChange(email.EmailChangeRequest{
+		UserID:    user.ID,
+		OldEmail:  user.Email,
+		NewEmail:  newEmail,
+		RequestIP: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+}
+
+// ConfirmEmailChange moves the account to the new address using the token
+// from the link sent there. Following the link proves the user owns the
+// address, so it counts as verified.
+func (s *Service) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Email change is not configured", CodeEmailChangeNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	changeToken := r.URL.Query().Get("token")
+	if changeToken == "" {
+		writeErrorResponse(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	emailToken, err := s.emailTokens.VerifyEmailChange(changeToken)
+	if errors.Is(err, email.ErrInvalidToken) || errors.Is(err, email.ErrTokenExpired) {
+		writeCodedErrorResponse(w, "Invalid or expired email change link", CodeEmailChangeTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, ok := s.emailChangeUser(w, r, emailToken)
+	if !ok {
+		return
+	}
+
+	oldEmail := user.Email
+	if !strings.EqualFold(oldEmail, emailToken.Email) {
+		now := time.Now()
+		user.Email = emailToken.Email
+		user.EmailVerifiedAt = &now
+		if user, err = s.db.Users().UpdateUser(r.Context(), user); err != nil {
+			if errors.Is(err, database.ErrUserAlreadyExists) {
+				writeErrorResponse(w, "Email already in use", http.StatusConflict)
+				return
+			}
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		s.sendSecurityAlertTo(r, oldEmail, "The email address of your account was changed to "+user.Email+
+			". If you didn't do this, follow the cancel link we sent to this address to undo it.")
+	}
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// CancelEmailChange drops a pending email change using the token from the
+// link sent to the old address. If the change already went through the
+// account is moved back and signed out everywhere, since whoever changed it
+// may have been signed in as the user.
+func (s *Service) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Email change is not configured", CodeEmailChangeNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	cancelToken := r.URL.Query().Get("token")
+	if cancelToken == "" {
+		writeErrorResponse(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	emailToken, err := s.emailTokens.CancelEmailChange(cancelToken)
+	if errors.Is(err, email.ErrInvalidToken) || errors.Is(err, email.ErrTokenExpired) {
+		writeCodedErrorResponse(w, "Invalid or expired email change link", CodeEmailChangeTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, ok := s.emailChangeUser(w, r, emailToken)
+	if !ok {
+		return
+	}
+
+	if !strings.EqualFold(user.Email, emailToken.Email) {
+		ctx := r.Context()
+		changedTo := user.Email
+
+		// The cancel link was sent to the old address, so following it
+		// proves the user still owns it
+		now := time.Now()
+		user.Email = emailToken.Email
+		user.EmailVerifiedAt = &now
+		if user, err = s.db.Users().UpdateUser(ctx, user); err != nil {
+			if errors.Is(err, database.ErrUserAlreadyExists) {
+				writeErrorResponse(w, "Email already in use", http.StatusConflict)
+				return
+			}
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		s.sendSecurityAlert(r, user, "The change of your email address to "+changedTo+" was undone and every device "+
+			"signed in to your account was signed out. If you didn't make the change, reset your password.")
+	}
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// emailChangeUser loads the account a redeemed email change token belongs to
+func (s *Service) emailChangeUser(w http.ResponseWriter, r *http.Request, emailToken *email.EmailToken) (*User, bool) {
+	user, err := s.db.Users().GetUserByID(r.Context(), emailToken.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeCodedErrorResponse(w, "Invalid or expired email change link", CodeEmailChangeTokenInvalid, http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// HandleConfirmEmailChange is a wrapper around the service ConfirmEmailChange method
+func HandleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ConfirmEmailChange(w, r)
+}
+
+// HandleCancelEmailChange is a wrapper around the service CancelEmailChange method
+func HandleCancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CancelEmailChange(w, r)
+}
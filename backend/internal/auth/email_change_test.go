@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+func getEmailChange(service *Service, handler http.HandlerFunc, changeToken string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/auth/email-change?token="+url.QueryEscape(changeToken), nil))
+	return rr
+}
+
+// requestEmailChange asks to move john@example.com to newEmail and returns
+// the confirm and cancel tokens that were emailed
+func requestEmailChange(t *testing.T, service *Service, db database.Database, emails *recordingEmailService, newEmail string) (string, string) {
+	t.Helper()
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if err := service.RequestEmailChange(httptest.NewRequest("PUT", "/api/user/profile", nil), user, newEmail); err != nil {
+		t.Fatalf("RequestEmailChange() error = %v", err)
+	}
+	if len(emails.emailChanges) == 0 || len(emails.emailCancels) == 0 {
+		t.Fatal("Expected a confirmation and a cancel link to be sent")
+	}
+	return verificationToken(t, emails.emailChanges[len(emails.emailChanges)-1]),
+		verificationToken(t, emails.emailCancels[len(emails.emailCancels)-1])
+}
+
+func TestEmailChange_Confirm(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+
+	confirmToken, _ := requestEmailChange(t, service, db, emails, " John.New@Example.com ")
+
+	// Nothing changes until the new address is confirmed
+	if _, err := db.Users().GetUserByEmail(context.Background(), "john@example.com"); err != nil {
+		t.Fatalf("Expected the account to keep its address, got %v", err)
+	}
+
+	rr := getEmailChange(service, service.ConfirmEmailChange, confirmToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.User.Email != "john.new@example.com" || !response.User.EmailVerified() {
+		t.Errorf("Expected the new address to be set and verified, got %+v", response.User)
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "changed to john.new@example.com") {
+		t.Errorf("Expected the old address to be told, got %q", last)
+	}
+
+	if rr := postLogin(service, "john.new@example.com", "password123", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected to log in with the new address, got %d", rr.Code)
+	}
+
+	// Links work once
+	if rr := getEmailChange(service, service.ConfirmEmailChange, confirmToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a used link to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestEmailChange_CancelBeforeConfirm(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+
+	confirmToken, cancelToken := requestEmailChange(t, service, db, emails, "john.new@example.com")
+
+	if rr := getEmailChange(service, service.CancelEmailChange, cancelToken); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr := getEmailChange(service, service.ConfirmEmailChange, confirmToken)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeEmailChangeTokenInvalid) {
+		t.Errorf("Expected the cancelled change to be unconfirmable, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Users().GetUserByEmail(context.Background(), "john@example.com"); err != nil {
+		t.Errorf("Expected the account to keep its address, got %v", err)
+	}
+}
+
+func TestEmailChange_CancelAfterConfirmRevertsAndSignsOut(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+
+	confirmToken, cancelToken := requestEmailChange(t, service, db, emails, "attacker@example.com")
+	if rr := getEmailChange(service, service.ConfirmEmailChange, confirmToken); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr := getEmailChange(service, service.CancelEmailChange, cancelToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.User.Email != "john@example.com" || !response.User.EmailVerified() {
+		t.Errorf("Expected the old address to be restored and verified, got %+v", response.User)
+	}
+
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", session.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected sessions to be revoked, got %d", rr.Code)
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "was undone") {
+		t.Errorf("Expected a notice about the undone change, got %q", last)
+	}
+}
+
+func TestEmailChange_NewRequestReplacesPending(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+
+	firstToken, _ := requestEmailChange(t, service, db, emails, "first@example.com")
+	secondToken, _ := requestEmailChange(t, service, db, emails, "second@example.com")
+
+	if rr := getEmailChange(service, service.ConfirmEmailChange, firstToken); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the replaced change to be unconfirmable, got %d", rr.Code)
+	}
+	if rr := getEmailChange(service, service.ConfirmEmailChange, secondToken); rr.Code != http.StatusOK {
+		t.Errorf("Expected the latest change to be confirmable, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEmailChange_RejectsTakenAddress(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	db.Users().CreateUser(context.Background(), &database.User{Name: "Jane", Email: "jane@example.com"})
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	err := service.RequestEmailChange(httptest.NewRequest("PUT", "/api/user/profile", nil), user, "jane@example.com")
+	if !errors.Is(err, database.ErrUserAlreadyExists) {
+		t.Errorf("Expected ErrUserAlreadyExists, got %v", err)
+	}
+	if len(emails.emailChanges) != 0 {
+		t.Errorf("Expected no confirmation to be sent, got %d", len(emails.emailChanges))
+	}
+}
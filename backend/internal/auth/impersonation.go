@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// AuthMethodImpersonation is recorded on sessions an administrator started
+// as another user
+const AuthMethodImpersonation = "impersonation"
+
+// AuditActionImpersonate is recorded when an administrator starts a session
+// as another user
+const AuditActionImpersonate = "user.impersonate"
+
+// CodeCannotImpersonate is returned for users that can't be impersonated
+const CodeCannotImpersonate = "cannot_impersonate"
+
+// ImpersonateRequest is the body of POST /api/admin/users/{id}/impersonate
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonationResponse is the session an administrator gets as another
+// user. It ends at ExpiresAt however often it is refreshed.
+type ImpersonationResponse struct {
+	AuthResponse
+	ExpiresAt time.Time `json:"impersonation_expires_at"`
+}
+
+// Impersonate starts a session as the user named in the path, so an
+// administrator can see what they see. The reason is kept in the audit
+// trail. Users with roles can't be impersonated, so nobody gains
+// permissions by impersonating them.
+func (s *Service) Impersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		writeErrorResponse(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+
+	impersonatorID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok || !s.notSelf(w, r, user) {
+		return
+	}
+
+	ctx := r.Context()
+	roles, err := s.db.Roles().ListUserRoles(ctx, user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(roles) > 0 {
+		writeCodedErrorResponse(w, "Users with roles can't be impersonated", CodeCannotImpersonate, http.StatusForbidden)
+		return
+	}
+	if user.Suspended() {
+		writeSessionError(w, errAccountSuspended)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionImpersonate, user.ID, reason) {
+		return
+	}
+
+	sessionID, err := newFamilyID()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	session, err := s.db.Sessions().CreateSession(ctx, &database.Session{
+		ID:             sessionID,
+		UserID:         user.ID,
+		UserAgent:      r.UserAgent(),
+		IPAddress:      middleware.ClientIP(r),
+		AuthMethod:     AuthMethodImpersonation,
+		ExpiresAt:      time.Now().Add(s.impersonationTTL),
+		ImpersonatorID: impersonatorID,
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.issueTokenPair(r, user, session)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if s.notifyImpersonation {
+		s.sendSecurityAlert(r, user, "A member of our support team signed in to your account to look into a problem. "+
+			"They can't change your password, two-factor settings or email address, and their access ends on "+
+			session.ExpiresAt.UTC().Format("2 January 2006 at 15:04 MST")+".")
+	}
+
+	writeJSONResponse(w, ImpersonationResponse{AuthResponse: *response, ExpiresAt: session.ExpiresAt}, http.StatusOK)
+}
+
+// HandleImpersonate is a wrapper around the service Impersonate method
+func HandleImpersonate(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Impersonate(w, r)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+)
+
+func postImpersonate(service *Service, userID int, body, accessToken string) *httptest.ResponseRecorder {
+	return serveAPI(service, "POST", "/api/admin/users/"+strconv.Itoa(userID)+"/impersonate", body, accessToken)
+}
+
+func TestImpersonate(t *testing.T) {
+	service, db, emails, session := setupAdmin(t)
+	jane := createJane(t, db)
+
+	rr := postImpersonate(service, jane.ID, `{"reason": "ticket 42"}`, session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response ImpersonationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.User.ID != jane.ID || response.Token == "" {
+		t.Fatalf("Expected a session as Jane, got %+v", response)
+	}
+	if until := time.Until(response.ExpiresAt); until <= 0 || until > service.impersonationTTL {
+		t.Errorf("Expected the session to end within %s, got %s", service.impersonationTTL, until)
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "signed in to your account") {
+		t.Errorf("Expected Jane to be told, got %q", last)
+	}
+
+	var impersonatorID int
+	handler := middleware.ForbidImpersonation(http.HandlerFunc(service.DisableTOTP))
+	inspect := func(w http.ResponseWriter, r *http.Request) {
+		impersonatorID, _ = middleware.ImpersonatorFromContext(r.Context())
+		handler.ServeHTTP(w, r)
+	}
+	req := httptest.NewRequest("POST", "/api/user/mfa/totp/disable", strings.NewReader(`{"code": "123456"}`))
+	req.Header.Set("Authorization", "Bearer "+response.Token)
+	rr = httptest.NewRecorder()
+	middleware.RequireAuth(db, service.tokens)(http.HandlerFunc(inspect)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), middleware.AuthCodeImpersonating) {
+		t.Errorf("Expected 2FA changes to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if impersonatorID != session.User.ID {
+		t.Errorf("Expected impersonator %d in the context, got %d", session.User.ID, impersonatorID)
+	}
+
+	// Refreshing doesn't make the session last longer
+	rr = postRefreshToken(service, service.Refresh, response.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	stored, _ := db.Sessions().ListUserSessions(context.Background(), jane.ID)
+	if len(stored) != 1 || !stored[0].ExpiresAt.Equal(response.ExpiresAt) || stored[0].ImpersonatorID != session.User.ID {
+		t.Errorf("Expected the session to keep its expiry and impersonator, got %+v", stored)
+	}
+
+	actions := auditActions(t, service, db, "?action="+AuditActionImpersonate, session.Token)
+	if len(actions) != 1 {
+		t.Errorf("Expected the impersonation to be audited, got %v", actions)
+	}
+}
+
+func TestImpersonate_Expires(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	jane := createJane(t, db)
+	service.impersonationTTL = -time.Second
+
+	rr := postImpersonate(service, jane.ID, `{"reason": "ticket 42"}`, session.Token)
+	var response ImpersonationResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", response.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an ended impersonation to be refused, got %d", rr.Code)
+	}
+	if rr := postRefreshToken(service, service.Refresh, response.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an ended impersonation not to refresh, got %d", rr.Code)
+	}
+}
+
+func TestImpersonate_Refused(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	jane := createJane(t, db)
+	ctx := context.Background()
+	db.Roles().CreateRole(ctx, &database.Role{Name: "support", Permissions: []string{rbac.PermissionUsersRead}})
+	helper, _ := db.Users().CreateUser(ctx, &database.User{Name: "Helper", Email: "helper@example.com"})
+	db.Roles().AssignRole(ctx, helper.ID, "support")
+
+	tests := []struct {
+		name           string
+		userID         int
+		body           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"no reason", jane.ID, `{"reason": " "}`, http.StatusBadRequest, ""},
+		{"themselves", session.User.ID, `{"reason": "ticket 42"}`, http.StatusBadRequest, CodeCannotTargetSelf},
+		{"a user with a role", helper.ID, `{"reason": "ticket 42"}`, http.StatusForbidden, CodeCannotImpersonate},
+		{"an unknown user", 999, `{"reason": "ticket 42"}`, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postImpersonate(service, tt.userID, tt.body, session.Token)
+			if rr.Code != tt.expectedStatus || !strings.Contains(rr.Body.String(), tt.expectedCode) {
+				t.Errorf("Expected status %d and code %q, got %d: %s", tt.expectedStatus, tt.expectedCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	rr := postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	var janeSession AuthResponse
+	json.NewDecoder(rr.Body).Decode(&janeSession)
+	if rr := postImpersonate(service, helper.ID, `{"reason": "ticket 42"}`, janeSession.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected users without the permission to be refused, got %d", rr.Code)
+	}
+}
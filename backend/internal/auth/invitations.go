@@ -0,0 +1,461 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/config"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// AuthMethodInvitation is recorded on sessions started by registering
+// through an invitation
+const AuthMethodInvitation = "invitation"
+
+// Error codes returned by the invitation endpoints
+const (
+	CodeInvitationInvalid        = "invitation_invalid"
+	CodeInvitationNotPending     = "invitation_not_pending"
+	CodeInvitationEmailMismatch  = "invitation_email_mismatch"
+	CodeInvitationsNotConfigured = "invitations_not_configured"
+	CodeAlreadyMember            = "already_member"
+	CodeInvitationAccountExists  = "account_exists"
+)
+
+// InvitationRequest is the body of POST /api/organizations/{id}/invitations
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// InvitationsResponse lists an organization's invitations
+type InvitationsResponse struct {
+	Invitations []*database.Invitation `json:"invitations"`
+}
+
+// InvitationDetails describes a pending invitation to whoever holds its
+// link, so they can choose to sign in or register
+type InvitationDetails struct {
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	OrganizationName string    `json:"organization_name"`
+	InviterName      string    `json:"inviter_name,omitempty"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	AccountExists    bool      `json:"account_exists"`
+}
+
+// AcceptInvitationRequest is the body of POST /api/invitations/accept and
+// POST /auth/invitations/accept. Name and password are only used when
+// registering.
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// CreateInvitation emails an invitation to join the organization named in
+// the path. It needs the admin role, and only owners can invite owners. A
+// new invitation to the same address replaces any still pending.
+func (s *Service) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	emailAddress := strings.ToLower(strings.TrimSpace(req.Email))
+	if !isValidEmail(emailAddress) {
+		writeErrorResponse(w, "Invalid email format", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = database.OrgRoleMember
+	}
+	if !database.ValidOrgRole(req.Role) {
+		writeErrorResponse(w, "Role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+
+	org, actor, ok := s.pathOrganization(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	if req.Role == database.OrgRoleOwner && actor.Role != database.OrgRoleOwner {
+		writeOrganizationRoleRequired(w, database.OrgRoleOwner)
+		return
+	}
+	if s.emailService == nil {
+		writeCodedErrorResponse(w, "Invitations are not configured", CodeInvitationsNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	existing, err := s.db.Users().GetUserByEmail(ctx, emailAddress)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		_, err := s.db.Organizations().GetMembership(ctx, org.ID, existing.ID)
+		if err == nil {
+			writeCodedErrorResponse(w, "This person is already a member", CodeAlreadyMember, http.StatusConflict)
+			return
+		}
+		if !errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	inviter, err := s.db.Users().GetUserByID(ctx, actor.UserID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rawToken, err := token.GenerateOpaque()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	invitation, err := s.db.Invitations().CreateInvitation(ctx, &database.Invitation{
+		OrganizationID: org.ID,
+		Email:          emailAddress,
+		Role:           req.Role,
+		TokenHash:      token.HashOpaque(rawToken),
+		InvitedBy:      inviter.ID,
+		ExpiresAt:      time.Now().Add(s.invitationTTL),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	inviteURL := config.GetAppConfig().GetInvitationURL(rawToken)
+	if err := s.emailService.SendInvitation(ctx, emailAddress, inviter.Name, org.Name, inviteURL); err != nil {
+		// Nobody can accept an invitation that was never delivered
+		s.db.Invitations().RevokeInvitation(ctx, invitation.ID)
+		writeErrorResponse(w, "Failed to send invitation", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, invitation, http.StatusCreated)
+}
+
+// ListInvitations returns the invitations of the organization named in the
+// path, newest first. It needs the admin role.
+func (s *Service) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := s.db.Invitations().ListOrganizationInvitations(r.Context(), org.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, InvitationsResponse{Invitations: invitations}, http.StatusOK)
+}
+
+// RevokeInvitation stops a pending invitation from being accepted. It
+// needs the admin role in the invitation's organization.
+func (s *Service) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.PathValue("invitation_id"))
+	if err != nil {
+		writeErrorResponse(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	invitation, err := s.db.Invitations().GetInvitation(ctx, id)
+	if errors.Is(err, database.ErrInvitationNotFound) || (err == nil && invitation.OrganizationID != org.ID) {
+		writeErrorResponse(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.db.Invitations().RevokeInvitation(ctx, invitation.ID); err != nil {
+		if errors.Is(err, database.ErrInvitationNotPending) {
+			writeCodedErrorResponse(w, "The invitation was already "+invitation.Status, CodeInvitationNotPending, http.StatusConflict)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetInvitation describes the pending invitation whose token is in the
+// query string, and whether its address already has an account
+func (s *Service) GetInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	invitation, org, ok := s.pendingInvitation(w, r, r.URL.Query().Get("token"))
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	details := InvitationDetails{
+		Email:            invitation.Email,
+		Role:             invitation.Role,
+		OrganizationName: org.Name,
+		ExpiresAt:        invitation.ExpiresAt,
+	}
+	if inviter, err := s.db.Users().GetUserByID(ctx, invitation.InvitedBy); err == nil {
+		details.InviterName = inviter.Name
+	}
+	_, err := s.db.Users().GetUserByEmail(ctx, invitation.Email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	details.AccountExists = err == nil
+
+	writeJSONResponse(w, details, http.StatusOK)
+}
+
+// AcceptInvitation adds the signed-in user to the organization they were
+// invited to. The invitation must have been sent to their address.
+func (s *Service) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	invitation, org, ok := s.pendingInvitation(w, r, req.Token)
+	if !ok {
+		return
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		writeCodedErrorResponse(w, "This invitation was sent to another email address", CodeInvitationEmailMismatch, http.StatusForbidden)
+		return
+	}
+
+	membership, ok := s.acceptInvitation(w, r, invitation, user)
+	if !ok {
+		return
+	}
+
+	writeJSONResponse(w, database.UserOrganization{Organization: *org, Role: membership.Role}, http.StatusOK)
+}
+
+// RegisterWithInvitation creates an account for the invited address, adds
+// it to the organization and signs it in. It works while open
+// registration is off, and the address counts as verified because the
+// link was emailed to it.
+func (s *Service) RegisterWithInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invitation, _, ok := s.pendingInvitation(w, r, req.Token)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	register := RegisterRequest{Name: strings.TrimSpace(req.Name), Email: invitation.Email, Password: req.Password}
+	if err := s.validateRegisterRequest(ctx, register); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		writeErrorResponse(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	user, err := s.db.Users().CreateUser(ctx, &User{
+		Name:            register.Name,
+		Email:           invitation.Email,
+		Password:        hashedPassword,
+		EmailVerifiedAt: &now,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrUserAlreadyExists) {
+			writeCodedErrorResponse(w, "An account already uses this address. Sign in to accept the invitation.", CodeInvitationAccountExists, http.StatusConflict)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := s.acceptInvitation(w, r, invitation, user); !ok {
+		// Don't leave an account behind that only the invitation justified
+		s.db.PurgeUser(ctx, user.ID)
+		return
+	}
+
+	response, err := s.startSession(w, r, user, AuthMethodInvitation)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusCreated)
+}
+
+// pendingInvitation looks up the invitation a raw token belongs to and its
+// organization. It writes the response and returns false unless the
+// invitation can still be accepted.
+func (s *Service) pendingInvitation(w http.ResponseWriter, r *http.Request, rawToken string) (*database.Invitation, *database.Organization, bool) {
+	if rawToken == "" {
+		writeErrorResponse(w, "Token is required", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	ctx := r.Context()
+	invitation, err := s.db.Invitations().GetInvitationByTokenHash(ctx, token.HashOpaque(rawToken))
+	if err != nil && !errors.Is(err, database.ErrInvitationNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if invitation == nil || !invitation.Pending(time.Now()) {
+		writeCodedErrorResponse(w, "Invalid or expired invitation", CodeInvitationInvalid, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	org, err := s.db.Organizations().GetOrganization(ctx, invitation.OrganizationID)
+	if err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			writeCodedErrorResponse(w, "Invalid or expired invitation", CodeInvitationInvalid, http.StatusBadRequest)
+			return nil, nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return invitation, org, true
+}
+
+// acceptInvitation redeems an invitation for a user. Following the link
+// proves they own the address, so it is marked verified.
+func (s *Service) acceptInvitation(w http.ResponseWriter, r *http.Request, invitation *database.Invitation, user *User) (*database.Membership, bool) {
+	ctx := r.Context()
+	membership, err := s.db.Invitations().AcceptInvitation(ctx, invitation.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrInvitationNotPending) || errors.Is(err, database.ErrInvitationNotFound) ||
+			errors.Is(err, database.ErrOrganizationNotFound) {
+			writeCodedErrorResponse(w, "Invalid or expired invitation", CodeInvitationInvalid, http.StatusBadRequest)
+			return nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !user.EmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if updated, err := s.db.Users().UpdateUser(ctx, user); err == nil {
+			*user = *updated
+		}
+	}
+
+	return membership, true
+}
+
+// HandleCreateInvitation is a wrapper around the service CreateInvitation method
+func HandleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateInvitation(w, r)
+}
+
+// HandleListInvitations is a wrapper around the service ListInvitations method
+func HandleListInvitations(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListInvitations(w, r)
+}
+
+// HandleRevokeInvitation is a wrapper around the service RevokeInvitation method
+func HandleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RevokeInvitation(w, r)
+}
+
+// HandleGetInvitation is a wrapper around the service GetInvitation method
+func HandleGetInvitation(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.GetInvitation(w, r)
+}
+
+// HandleAcceptInvitation is a wrapper around the service AcceptInvitation method
+func HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AcceptInvitation(w, r)
+}
+
+// HandleRegisterWithInvitation is a wrapper around the service RegisterWithInvitation method
+func HandleRegisterWithInvitation(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RegisterWithInvitation(w, r)
+}
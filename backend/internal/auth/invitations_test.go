@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// inviteTestUser has John invite an address to Acme and returns the
+// invitation with the token emailed for it
+func inviteTestUser(t *testing.T, service *Service, db database.Database, org *database.UserOrganization, john AuthResponse, body string) (*database.Invitation, string) {
+	t.Helper()
+
+	rr := serveAPI(service, "POST", organizationPath(org, "/invitations"), body, john.Token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var invitation database.Invitation
+	json.NewDecoder(rr.Body).Decode(&invitation)
+
+	emails := service.emailService.(*recordingEmailService)
+	return &invitation, verificationToken(t, emails.invitations[len(emails.invitations)-1])
+}
+
+func TestCreateInvitation(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+	path := organizationPath(org, "/invitations")
+
+	if rr := serveAPI(service, "POST", path, `{"email": "carol@example.com"}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected members not to invite, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "POST", path, `{"email": "not-an-email"}`, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid address to be rejected, got %d", rr.Code)
+	}
+	rr := serveAPI(service, "POST", path, `{"email": "Jane@Example.com"}`, john.Token)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeAlreadyMember) {
+		t.Errorf("Expected members not to be invited again, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invitation, rawToken := inviteTestUser(t, service, db, org, john, `{"email": " Carol@Example.com ", "role": "admin"}`)
+	if invitation.Email != "carol@example.com" || invitation.Role != database.OrgRoleAdmin || invitation.Status != database.InvitationPending {
+		t.Errorf("Expected a pending admin invitation for Carol, got %+v", invitation)
+	}
+	stored, _ := db.Invitations().GetInvitation(context.Background(), invitation.ID)
+	if stored.TokenHash == rawToken || stored.InvitedBy != john.User.ID {
+		t.Errorf("Expected only the token hash to be stored with the inviter, got %+v", stored)
+	}
+	if time.Until(stored.ExpiresAt) < 6*24*time.Hour {
+		t.Errorf("Expected the invitation to last a week, got %v", stored.ExpiresAt)
+	}
+
+	// Admins can invite, but only owners can invite owners
+	db.Organizations().UpdateMemberRole(context.Background(), org.ID, jane.User.ID, database.OrgRoleAdmin)
+	if rr := serveAPI(service, "POST", path, `{"email": "dave@example.com", "role": "owner"}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected admins not to invite owners, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "POST", path, `{"email": "dave@example.com"}`, jane.Token); rr.Code != http.StatusCreated {
+		t.Errorf("Expected admins to invite members, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestListAndRevokeInvitations(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+
+	first, firstToken := inviteTestUser(t, service, db, org, john, `{"email": "carol@example.com"}`)
+	second, _ := inviteTestUser(t, service, db, org, john, `{"email": "dave@example.com"}`)
+
+	if rr := serveAPI(service, "GET", organizationPath(org, "/invitations"), "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected members not to list invitations, got %d", rr.Code)
+	}
+	rr := serveAPI(service, "GET", organizationPath(org, "/invitations"), "", john.Token)
+	var list InvitationsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list.Invitations) != 2 || list.Invitations[0].ID != second.ID {
+		t.Fatalf("Expected both invitations newest first, got %d: %+v", rr.Code, list)
+	}
+
+	revokePath := organizationPath(org, "/invitations/"+strconv.Itoa(first.ID))
+	if rr := serveAPI(service, "DELETE", revokePath, "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	rr = serveAPI(service, "DELETE", revokePath, "", john.Token)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeInvitationNotPending) {
+		t.Errorf("Expected a revoked invitation not to be revoked again, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", organizationPath(org, "/invitations/999"), "", john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	rr = getInvitation(service, firstToken)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvitationInvalid) {
+		t.Errorf("Expected a revoked invitation to be invalid, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func getInvitation(service *Service, rawToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/auth/invitations?token="+rawToken, nil)
+	rr := httptest.NewRecorder()
+	service.GetInvitation(rr, req)
+	return rr
+}
+
+func registerWithInvitation(service *Service, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/auth/invitations/accept", strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+	service.RegisterWithInvitation(rr, req)
+	return rr
+}
+
+func TestAcceptInvitation_ExistingAccount(t *testing.T) {
+	service, db, org, john, _ := setupOrganization(t)
+	ctx := context.Background()
+
+	carol, _ := db.Users().CreateUser(ctx, &database.User{Name: "Carol", Email: "carol@example.com"})
+	_, rawToken := inviteTestUser(t, service, db, org, john, `{"email": "carol@example.com", "role": "admin"}`)
+
+	rr := getInvitation(service, rawToken)
+	var details InvitationDetails
+	json.NewDecoder(rr.Body).Decode(&details)
+	if rr.Code != http.StatusOK || !details.AccountExists || details.OrganizationName != "Acme" || details.InviterName != "John Doe" {
+		t.Errorf("Expected the invitation to describe Acme and Carol's account, got %d: %+v", rr.Code, details)
+	}
+
+	// Registering is refused for an address that has an account
+	rr = registerWithInvitation(service, `{"token": "`+rawToken+`", "name": "Carol", "password": "Orbit-Lantern-2024!"}`)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeInvitationAccountExists) {
+		t.Errorf("Expected existing accounts to sign in, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Only the invited address can accept
+	body := `{"token": "` + rawToken + `"}`
+	rr = serveAPI(service, "POST", "/api/invitations/accept", body, john.Token)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeInvitationEmailMismatch) {
+		t.Errorf("Expected another address to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	carolSession, err := service.startSession(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil), carol, AuthMethodPassword)
+	if err != nil {
+		t.Fatalf("Failed to sign Carol in: %v", err)
+	}
+	rr = serveAPI(service, "POST", "/api/invitations/accept", body, carolSession.Token)
+	var joined database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&joined)
+	if rr.Code != http.StatusOK || joined.ID != org.ID || joined.Role != database.OrgRoleAdmin {
+		t.Fatalf("Expected Carol to join Acme as an admin, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stored, _ := db.Users().GetUserByID(ctx, carol.ID); !stored.EmailVerified() {
+		t.Error("Expected accepting an invitation to verify the address")
+	}
+
+	rr = serveAPI(service, "POST", "/api/invitations/accept", body, carolSession.Token)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvitationInvalid) {
+		t.Errorf("Expected an invitation to be accepted once, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRegisterWithInvitation(t *testing.T) {
+	service, db, org, john, _ := setupOrganization(t)
+	service.SetOpenRegistration(false)
+
+	_, rawToken := inviteTestUser(t, service, db, org, john, `{"email": "carol@example.com"}`)
+
+	if rr := registerWithInvitation(service, `{"token": "`+rawToken+`", "name": "Carol", "password": "short"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the password policy to apply, got %d", rr.Code)
+	}
+
+	rr := registerWithInvitation(service, `{"token": "`+rawToken+`", "name": "Carol", "password": "Orbit-Lantern-2024!"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var session AuthResponse
+	json.NewDecoder(rr.Body).Decode(&session)
+	if session.Token == "" || session.User.Email != "carol@example.com" || !session.User.EmailVerified() {
+		t.Errorf("Expected a signed-in, verified account for Carol, got %+v", session.User)
+	}
+	if membership, err := db.Organizations().GetMembership(context.Background(), org.ID, session.User.ID); err != nil || membership.Role != database.OrgRoleMember {
+		t.Errorf("Expected Carol to be a member of Acme, got %+v, %v", membership, err)
+	}
+
+	rr = registerWithInvitation(service, `{"token": "`+rawToken+`", "name": "Carol", "password": "Orbit-Lantern-2024!"}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvitationInvalid) {
+		t.Errorf("Expected the invitation to be single-use, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := registerWithInvitation(service, `{"token": "unknown", "name": "Dave", "password": "Orbit-Lantern-2024!"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown token to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestRegisterWithInvitation_Expired(t *testing.T) {
+	service, db, org, john, _ := setupOrganization(t)
+	service.invitationTTL = -time.Minute
+
+	_, rawToken := inviteTestUser(t, service, db, org, john, `{"email": "carol@example.com"}`)
+
+	rr := registerWithInvitation(service, `{"token": "`+rawToken+`", "name": "Carol", "password": "Orbit-Lantern-2024!"}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeInvitationInvalid) {
+		t.Errorf("Expected an expired invitation to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Users().GetUserByEmail(context.Background(), "carol@example.com"); err == nil {
+		t.Error("Expected no account to be created")
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/config"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Error codes returned when password login is throttled
+const (
+	CodeLoginThrottled     = "login_throttled"
+	CodeAccountLocked      = "account_locked"
+	CodeUnlockTokenInvalid = "unlock_token_invalid"
+)
+
+const (
+	// freeLoginFailures is how many failures are allowed before delays start
+	freeLoginFailures = 3
+
+	// baseLoginDelay is the first delay, doubled with each further failure
+	baseLoginDelay = time.Second
+
+	// maxLoginDelay caps the delay between attempts short of a lockout
+	maxLoginDelay = time.Minute
+)
+
+// LoginThrottle configures brute-force protection on password login.
+// Failures are counted per email address and per client IP.
+type LoginThrottle struct {
+	MaxFailures      int           // Failures per email address before it is locked
+	MaxFailuresPerIP int           // Failures per client IP before it is locked
+	LockoutDuration  time.Duration // How long a lock lasts and failures are remembered
+}
+
+// UnlockAccountRequest is the body of POST /auth/unlock
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
+
+// SetLoginThrottle replaces the brute-force protection settings
+func (s *Service) SetLoginThrottle(throttle LoginThrottle) {
+	s.loginThrottle = throttle
+}
+
+// loginKeys returns the throttling keys for a login attempt
+func loginKeys(r *http.Request, emailAddress string) (emailKey, ipKey string) {
+	return "email:" + strings.ToLower(strings.TrimSpace(emailAddress)), "ip:" + middleware.ClientIP(r)
+}
+
+// loginDelay returns how long to wait after the given number of failures
+func loginDelay(failures int) time.Duration {
+	if failures < freeLoginFailures {
+		return 0
+	}
+	shift := failures - freeLoginFailures
+	if shift >= 16 {
+		return maxLoginDelay
+	}
+	return min(baseLoginDelay<<shift, maxLoginDelay)
+}
+
+// loginRetryAfter returns how long the caller must wait before trying any of
+// the keys again, and whether that is because one of them is locked
+func (s *Service) loginRetryAfter(ctx context.Context, now time.Time, keys ...string) (time.Duration, bool, error) {
+	var wait time.Duration
+	locked := false
+
+	for _, key := range keys {
+		attempts, err := s.db.LoginAttempts().GetLoginAttempts(ctx, key)
+		if errors.Is(err, database.ErrLoginAttemptsNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+
+		if attempts.Locked(now) {
+			locked = true
+			wait = max(wait, attempts.LockedUntil.Sub(now))
+			continue
+		}
+		if remaining := attempts.LastFailureAt.Add(loginDelay(attempts.Failures)).Sub(now); remaining > 0 {
+			wait = max(wait, remaining)
+		}
+	}
+
+	return wait, locked, nil
+}
+
+// recordLoginFailure counts a failed password against the email address and
+// client IP, locking either once it reaches its limit. The owner of a newly
+// locked account is emailed an unlock link; user is nil for unknown emails.
+func (s *Service) recordLoginFailure(r *http.Request, emailKey, ipKey string, user *User) error {
+	now := time.Now()
+
+	locked, err := s.recordFailure(r.Context(), emailKey, s.loginThrottle.MaxFailures, now)
+	if err != nil {
+		return err
+	}
+	if locked && user != nil {
+		s.sendLockoutAlert(r, user, now.Add(s.loginThrottle.LockoutDuration))
+	}
+
+	_, err = s.recordFailure(r.Context(), ipKey, s.loginThrottle.MaxFailuresPerIP, now)
+	return err
+}
+
+// recordFailure counts a failure against a throttling key and locks the key
+// once it reaches limit. It reports whether this failure started the lock.
+func (s *Service) recordFailure(ctx context.Context, key string, limit int, now time.Time) (bool, error) {
+	attempts, err := s.db.LoginAttempts().RecordLoginFailure(ctx, key, now, now.Add(-s.loginThrottle.LockoutDuration))
+	if err != nil {
+		return false, err
+	}
+	if attempts.Failures < limit || attempts.Locked(now) {
+		return false, nil
+	}
+	return true, s.db.LoginAttempts().LockLogin(ctx, key, now.Add(s.loginThrottle.LockoutDuration))
+}
+
+// sendLockoutAlert emails the owner of a locked account a link that lifts
+// the lock. The link names the lock it was sent for, so it stops working
+// once that lock is gone.
+func (s *Service) sendLockoutAlert(r *http.Request, user *User, lockedUntil time.Time) {
+	unlockToken, err := s.tokens.Issue(token.Claims{
+		Subject:   strconv.Itoa(user.ID),
+		Type:      token.TypeAccountUnlock,
+		Nonce:     strconv.FormatInt(lockedUntil.Unix(), 10),
+		ExpiresAt: lockedUntil.Unix(),
+	})
+	if err != nil {
+		return
+	}
+
+	unlockURL := config.GetAppConfig().AppBaseURL + "/unlock-account?token=" + url.QueryEscape(unlockToken)
+	minutes := int(math.Ceil(s.loginThrottle.LockoutDuration.Minutes()))
+	s.sendSecurityAlert(r, user, "Sign-in to your account was locked for "+strconv.Itoa(minutes)+
+		" minutes after too many failed password attempts. If this was you, unlock it now: "+unlockURL+
+		" If it wasn't, someone may be guessing your password. Consider changing it.")
+}
+
+// writeLoginThrottled writes a 429 telling the client when to try again
+func writeLoginThrottled(w http.ResponseWriter, wait time.Duration, locked bool) {
+	setRetryAfter(w, wait)
+	if locked {
+		writeCodedErrorResponse(w, "Too many failed attempts. Sign-in is temporarily locked", CodeAccountLocked, http.StatusTooManyRequests)
+		return
+	}
+	writeCodedErrorResponse(w, "Too many failed attempts. Try again later", CodeLoginThrottled, http.StatusTooManyRequests)
+}
+
+// setRetryAfter tells the client how many whole seconds to wait
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// UnlockAccount lifts a login lockout using the link emailed when it started
+func (s *Service) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.tokens.VerifyType(req.Token, token.TypeAccountUnlock)
+	if err != nil {
+		writeCodedErrorResponse(w, "Invalid or expired unlock link", CodeUnlockTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		writeCodedErrorResponse(w, "Invalid or expired unlock link", CodeUnlockTokenInvalid, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	user, err := s.db.Users().GetUserByID(ctx, userID)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		writeCodedErrorResponse(w, "Invalid or expired unlock link", CodeUnlockTokenInvalid, http.StatusBadRequest)
+		return
+	}
+
+	emailKey, _ := loginKeys(r, user.Email)
+	attempts, err := s.db.LoginAttempts().GetLoginAttempts(ctx, emailKey)
+	if err != nil && !errors.Is(err, database.ErrLoginAttemptsNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if attempts == nil || attempts.LockedUntil == nil || strconv.FormatInt(attempts.LockedUntil.Unix(), 10) != claims.Nonce {
+		writeCodedErrorResponse(w, "Invalid or expired unlock link", CodeUnlockTokenInvalid, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.LoginAttempts().ClearLoginAttempts(ctx, emailKey); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "Account unlocked"}, http.StatusOK)
+}
+
+// HandleUnlockAccount is a wrapper around the service UnlockAccount method
+func HandleUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.UnlockAccount(w, r)
+}
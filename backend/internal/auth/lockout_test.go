@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// postLogin attempts a password login from the given client address
+func postLogin(service *Service, emailAddress, password, remoteAddr string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(LoginRequest{Email: emailAddress, Password: password})
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(string(body)))
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	service.Login(rr, req)
+	return rr
+}
+
+// unlockURLToken pulls the unlock token out of a lockout alert
+func unlockURLToken(t *testing.T, alert string) string {
+	t.Helper()
+
+	start := strings.Index(alert, "token=")
+	if start < 0 {
+		t.Fatalf("Expected an unlock link in %q", alert)
+	}
+	raw := strings.Fields(alert[start+len("token="):])[0]
+	unlockToken, err := url.QueryUnescape(raw)
+	if err != nil {
+		t.Fatalf("Failed to unescape token: %v", err)
+	}
+	return unlockToken
+}
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginFailures - 1, 0},
+		{freeLoginFailures, time.Second},
+		{freeLoginFailures + 1, 2 * time.Second},
+		{freeLoginFailures + 3, 8 * time.Second},
+		{freeLoginFailures + 10, maxLoginDelay},
+		{1000, maxLoginDelay},
+	}
+
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLogin_ThrottlesRepeatedFailures(t *testing.T) {
+	service, db := setupTestService()
+	loginTestUser(t, service, db)
+
+	for i := 0; i < freeLoginFailures; i++ {
+		if rr := postLogin(service, "john@example.com", "wrong", "192.0.2.1:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected status %d, got %d", i+1, http.StatusUnauthorized, rr.Code)
+		}
+	}
+
+	// Even the right password has to wait out the delay
+	rr := postLogin(service, "john@example.com", "password123", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeLoginThrottled) {
+		t.Fatalf("Expected throttled login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After of 1 second, got %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestLogin_SuccessClearsFailures(t *testing.T) {
+	service, db := setupTestService()
+	loginTestUser(t, service, db)
+
+	postLogin(service, "john@example.com", "wrong", "192.0.2.1:1234")
+	if rr := postLogin(service, "john@example.com", "password123", "192.0.2.1:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if _, err := db.LoginAttempts().GetLoginAttempts(context.Background(), "email:john@example.com"); !errors.Is(err, database.ErrLoginAttemptsNotFound) {
+		t.Errorf("Expected failures to be cleared, got %v", err)
+	}
+}
+
+func TestLogin_LockoutAndUnlock(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	service.SetLoginThrottle(LoginThrottle{MaxFailures: 3, MaxFailuresPerIP: 100, LockoutDuration: 15 * time.Minute})
+
+	for i := 0; i < 3; i++ {
+		postLogin(service, "John@Example.com", "wrong", "192.0.2.1:1234")
+	}
+
+	rr := postLogin(service, "john@example.com", "password123", "198.51.100.7:1234")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeAccountLocked) {
+		t.Fatalf("Expected locked account from any IP, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") != "900" {
+		t.Errorf("Expected Retry-After of 900 seconds, got %q", rr.Header().Get("Retry-After"))
+	}
+	if len(emails.alerts) != 1 {
+		t.Fatalf("Expected one lockout alert, got %v", emails.alerts)
+	}
+
+	body, _ := json.Marshal(UnlockAccountRequest{Token: unlockURLToken(t, emails.alerts[0])})
+	unlock := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		service.UnlockAccount(rr, httptest.NewRequest("POST", "/auth/unlock", strings.NewReader(string(body))))
+		return rr
+	}
+
+	if rr := unlock(); rr.Code != http.StatusOK {
+		t.Fatalf("Expected unlock to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postLogin(service, "john@example.com", "password123", "198.51.100.7:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected login after unlock, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The link only lifts the lock it was sent for
+	if rr := unlock(); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeUnlockTokenInvalid) {
+		t.Errorf("Expected reused link to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLogin_UnknownEmailIsThrottledWithoutAlert(t *testing.T) {
+	service, _, emails := setupFullTestService(t)
+	service.SetLoginThrottle(LoginThrottle{MaxFailures: 2, MaxFailuresPerIP: 100, LockoutDuration: time.Minute})
+
+	postLogin(service, "nobody@example.com", "wrong", "192.0.2.1:1234")
+	postLogin(service, "nobody@example.com", "wrong", "192.0.2.1:1234")
+
+	rr := postLogin(service, "nobody@example.com", "wrong", "192.0.2.1:1234")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeAccountLocked) {
+		t.Errorf("Expected unknown email to be locked like a real one, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(emails.alerts) != 0 {
+		t.Errorf("Expected no alert, got %v", emails.alerts)
+	}
+}
+
+func TestLogin_LocksClientIP(t *testing.T) {
+	service, db := setupTestService()
+	loginTestUser(t, service, db)
+	service.SetLoginThrottle(LoginThrottle{MaxFailures: 100, MaxFailuresPerIP: 3, LockoutDuration: time.Minute})
+
+	for _, address := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		postLogin(service, address, "wrong", "192.0.2.1:1234")
+	}
+
+	rr := postLogin(service, "john@example.com", "password123", "192.0.2.1:5678")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeAccountLocked) {
+		t.Errorf("Expected the IP to be locked, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := postLogin(service, "john@example.com", "password123", "198.51.100.7:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be unaffected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestUnlockAccount_InvalidToken(t *testing.T) {
+	service, _ := setupTestService()
+
+	rr := httptest.NewRecorder()
+	service.UnlockAccount(rr, httptest.NewRequest("POST", "/auth/unlock", strings.NewReader(`{"token": "forged"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// AuthMethodMagicLink is recorded on sessions started with an emailed sign-in link
+const AuthMethodMagicLink = "magic_link"
+
+// Error codes returned by the magic-link endpoints
+const (
+	CodeMagicLinkInvalid       = "magic_link_invalid"
+	CodeMagicLinkNotConfigured = "magic_link_not_configured"
+)
+
+// MagicLinkRequest is the body of POST /auth/magic-link
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// errMagicLinkInvalid means a redeemed link no longer matches its account
+var errMagicLinkInvalid = errors.New("magic link does not match the account")
+
+// RequestMagicLink emails a single-use sign-in link. It answers the same way
+// for every address, so it can't be used to find users.
+func (s *Service) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Magic-link login is not configured", CodeMagicLinkNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	address := strings.ToLower(strings.TrimSpace(req.Email))
+	if address == "" {
+		writeErrorResponse(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByEmail(r.Context(), address)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Unknown addresses only get a link if following it may create an account
+	if user != nil || s.openRegistration {
+		linkRequest := email.MagicLinkRequest{
+			Email:     address,
+			RequestIP: middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+		}
+		if user != nil {
+			linkRequest.UserID = user.ID
+		}
+
+		// Rate limits and delivery failures look like success, since the
+		// response must not depend on the address
+		s.emailTokens.RequestMagicLink(linkRequest)
+	}
+
+	writeJSONResponse(w, map[string]string{
+		"message": "If that email can sign in, a link has been sent",
+	}, http.StatusAccepted)
+}
+
+// FinishMagicLink redeems a sign-in link and starts a session, creating the
+// account first if the link was sent to a new address
+func (s *Service) FinishMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Magic-link login is not configured", CodeMagicLinkNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	linkToken := r.URL.Query().Get("token")
+	if linkToken == "" {
+		writeErrorResponse(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	emailToken, err := s.emailTokens.VerifyMagicLink(linkToken)
+	if errors.Is(err, email.ErrInvalidToken) || errors.Is(err, email.ErrTokenExpired) {
+		writeCodedErrorResponse(w, "Invalid or expired sign-in link", CodeMagicLinkInvalid, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.magicLinkUser(r.Context(), emailToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errMagicLinkInvalid):
+			writeCodedErrorResponse(w, "Invalid or expired sign-in link", CodeMagicLinkInvalid, http.StatusBadRequest)
+		case errors.Is(err, errRegistrationClosed):
+			writeCodedErrorResponse(w, "Registration is closed", CodeRegistrationClosed, http.StatusForbidden)
+		default:
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The link proves the inbox, not the second factor
+	if user.TOTPEnabled {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	response, err := s.startSession(w, r, user, AuthMethodMagicLink)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// magicLinkUser returns the account a redeemed link signs in to. Following
+// the link proves the user owns the address, so it is marked verified.
+func (s *Service) magicLinkUser(ctx context.Context, emailToken *email.EmailToken) (*User, error) {
+	var user *User
+	var err error
+	if emailToken.UserID > 0 {
+		user, err = s.db.Users().GetUserByID(ctx, emailToken.UserID)
+	} else {
+		// The address had no account when the link was sent, but may have
+		// registered since
+		user, err = s.db.Users().GetUserByEmail(ctx, emailToken.Email)
+	}
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		if emailToken.UserID > 0 {
+			return nil, errMagicLinkInvalid
+		}
+		if !s.openRegistration {
+			return nil, errRegistrationClosed
+		}
+		return s.createPasswordlessUser(ctx, emailToken.Email, "")
+	}
+
+	// A link sent before the account changed address doesn't sign in to it
+	if !strings.EqualFold(user.Email, emailToken.Email) {
+		return nil, errMagicLinkInvalid
+	}
+
+	if !user.EmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if user, err = s.db.Users().UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// HandleRequestMagicLink is a wrapper around the service RequestMagicLink method
+func HandleRequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RequestMagicLink(w, r)
+}
+
+// HandleFinishMagicLink is a wrapper around the service FinishMagicLink method
+func HandleFinishMagicLink(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.FinishMagicLink(w, r)
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postMagicLink(service *Service, emailAddress string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(MagicLinkRequest{Email: emailAddress})
+	rr := httptest.NewRecorder()
+	service.RequestMagicLink(rr, httptest.NewRequest("POST", "/auth/magic-link", strings.NewReader(string(body))))
+	return rr
+}
+
+func getMagicLinkCallback(service *Service, linkToken string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	service.FinishMagicLink(rr, httptest.NewRequest("GET", "/auth/magic-link/callback?token="+url.QueryEscape(linkToken), nil))
+	return rr
+}
+
+func TestMagicLink_SignsInExistingUser(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+
+	if rr := postMagicLink(service, " John@Example.com "); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if len(emails.magicLinks) != 1 {
+		t.Fatalf("Expected one sign-in link, got %d", len(emails.magicLinks))
+	}
+	linkToken := verificationToken(t, emails.magicLinks[0])
+
+	rr := getMagicLinkCallback(service, linkToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Token == "" || response.RefreshToken == "" || response.User.ID != login.User.ID {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if !response.User.EmailVerified() {
+		t.Error("Expected following the link to verify the email")
+	}
+
+	// The session is recorded as a magic-link sign-in
+	for _, session := range listSessions(t, service, db, response.Token) {
+		if session.Current && session.AuthMethod != AuthMethodMagicLink {
+			t.Errorf("Expected a magic-link session, got %q", session.AuthMethod)
+		}
+	}
+
+	// Links work once
+	rr = getMagicLinkCallback(service, linkToken)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeMagicLinkInvalid) {
+		t.Errorf("Expected used link to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMagicLink_CreatesAccountWhenRegistrationIsOpen(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+
+	postMagicLink(service, "new@example.com")
+	if len(emails.magicLinks) != 1 {
+		t.Fatalf("Expected one sign-in link, got %d", len(emails.magicLinks))
+	}
+
+	rr := getMagicLinkCallback(service, verificationToken(t, emails.magicLinks[0]))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Token == "" || response.User.Email != "new@example.com" || response.User.Name != "new" {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if !response.User.EmailVerified() {
+		t.Error("Expected the new account to be verified")
+	}
+
+	if _, err := db.Users().GetUserByEmail(context.Background(), "new@example.com"); err != nil {
+		t.Errorf("Expected the account to be created: %v", err)
+	}
+}
+
+func TestMagicLink_ClosedRegistration(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+
+	// A link requested while registration was open can't create an account
+	// once it closes
+	postMagicLink(service, "new@example.com")
+	service.SetOpenRegistration(false)
+
+	rr := getMagicLinkCallback(service, verificationToken(t, emails.magicLinks[0]))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeRegistrationClosed) {
+		t.Errorf("Expected registration to be closed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Users().GetUserByEmail(context.Background(), "new@example.com"); err == nil {
+		t.Error("Expected no account to be created")
+	}
+
+	// Unknown addresses get the same answer but no link
+	known := postMagicLink(service, "john@example.com")
+	unknown := postMagicLink(service, "other@example.com")
+	if unknown.Code != http.StatusAccepted || known.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected identical responses, got %d %q and %d %q", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	if len(emails.magicLinks) != 1 {
+		t.Errorf("Expected no link for an unknown address, got %d", len(emails.magicLinks))
+	}
+
+	// Closed registration also closes the register endpoint
+	rr = httptest.NewRecorder()
+	service.Register(rr, httptest.NewRequest("POST", "/auth/register", strings.NewReader(`{"name": "Jane Doe", "email": "jane@example.com", "password": "Password123!"}`)))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeRegistrationClosed) {
+		t.Errorf("Expected registration to be closed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMagicLink_TwoFactorStillRequired(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	enableTOTP(t, service, db, login.Token)
+
+	postMagicLink(service, "john@example.com")
+	rr := getMagicLinkCallback(service, verificationToken(t, emails.magicLinks[0]))
+
+	var challenge MFAChallengeResponse
+	json.NewDecoder(rr.Body).Decode(&challenge)
+	if rr.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Errorf("Expected an MFA challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMagicLink_PasswordResetStillRequired(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	requirePasswordReset(t, db)
+
+	postMagicLink(service, "john@example.com")
+	rr := getMagicLinkCallback(service, verificationToken(t, emails.magicLinks[0]))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodePasswordResetRequired) {
+		t.Errorf("Expected a forced reset to stop the sign-in, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestMagicLink_NotConfigured(t *testing.T) {
+	service, _ := setupTestService()
+
+	if rr := postMagicLink(service, "john@example.com"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if rr := getMagicLinkCallback(service, "anything"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
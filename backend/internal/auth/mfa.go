@@ -0,0 +1,561 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/mfa"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Authentication methods recorded on sessions completed with a second factor
+const (
+	AuthMethodTOTP         = "totp"
+	AuthMethodRecoveryCode = "recovery_code"
+)
+
+// Error codes returned by the two-factor endpoints
+const (
+	CodeMFATokenInvalid = "mfa_token_invalid"
+	CodeMFACodeInvalid  = "mfa_code_invalid"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter their code after the password step
+	mfaChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts caps the codes that can be tried against one challenge
+	maxMFAAttempts = 5
+)
+
+// MFAChallengeResponse is returned by login instead of tokens when the user
+// has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// MFAVerifyRequest is the body of /auth/mfa/verify. Exactly one of Code and
+// RecoveryCode should be set.
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPEnrollResponse carries the secret for a pending enrollment
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TOTPConfirmRequest is the body of the enrollment confirmation
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse returns freshly generated recovery codes. They are
+// only ever shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTOTPRequest is the body of the disable endpoint
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+}
+
+// issueMFAChallenge signs the short-lived token that proves the password step
+func (s *Service) issueMFAChallenge(user *User) (*MFAChallengeResponse, error) {
+	challenge, err := s.tokens.Issue(token.Claims{
+		Subject:   strconv.Itoa(user.ID),
+		Type:      token.TypeMFAChallenge,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    challenge,
+		ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// VerifyMFA exchanges a login challenge and a TOTP or recovery code for a session
+func (s *Service) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		writeErrorResponse(w, "MFA token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.tokens.VerifyType(req.MFAToken, token.TypeMFAChallenge)
+	if err != nil {
+		writeCodedErrorResponse(w, "Invalid or expired MFA token", CodeMFATokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	if !s.mfaAttempts.allow(claims.ID) {
+		writeCodedErrorResponse(w, "Too many attempts, please log in again", CodeMFATokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		writeCodedErrorResponse(w, "Invalid or expired MFA token", CodeMFATokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeCodedErrorResponse(w, "Invalid or expired MFA token", CodeMFATokenInvalid, http.StatusUnauthorized)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		writeCodedErrorResponse(w, "Invalid or expired MFA token", CodeMFATokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes also count against the user, since anyone who knows the
+	// password can start as many challenges as they like
+	now := time.Now()
+	key := mfaKey(user.ID)
+	attempts, err := s.db.LoginAttempts().GetLoginAttempts(r.Context(), key)
+	if err != nil && !errors.Is(err, database.ErrLoginAttemptsNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if attempts != nil && attempts.Locked(now) {
+		writeLoginThrottled(w, attempts.LockedUntil.Sub(now), true)
+		return
+	}
+
+	var method string
+	var verified bool
+	if req.RecoveryCode != "" {
+		method = AuthMethodRecoveryCode
+		verified, err = s.consumeRecoveryCode(r.Context(), user, req.RecoveryCode)
+	} else {
+		method = AuthMethodTOTP
+		verified, err = s.checkTOTP(r.Context(), user, req.Code)
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !verified {
+		s.mfaAttempts.fail(claims.ID, time.Unix(claims.ExpiresAt, 0))
+		locked, err := s.recordFailure(r.Context(), key, s.loginThrottle.MaxFailures, now)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if locked {
+			s.sendMFALockoutAlert(r, user)
+		}
+		writeCodedErrorResponse(w, "Invalid verification code", CodeMFACodeInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.db.LoginAttempts().ClearLoginAttempts(r.Context(), key); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// A challenge is good for one session only
+	s.mfaAttempts.burn(claims.ID, time.Unix(claims.ExpiresAt, 0))
+
+	response, err := s.startSession(w, r, user, method)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// mfaKey returns the throttling key that counts a user's wrong two-factor
+// codes across challenges
+func mfaKey(userID int) string {
+	return "mfa:" + strconv.Itoa(userID)
+}
+
+// sendMFALockoutAlert tells a user that two-factor sign-in was locked. Only
+// someone who knew the password could have tried the codes.
+func (s *Service) sendMFALockoutAlert(r *http.Request, user *User) {
+	minutes := int(math.Ceil(s.loginThrottle.LockoutDuration.Minutes()))
+	s.sendSecurityAlert(r, user, "Sign-in to your account was locked for "+strconv.Itoa(minutes)+
+		" minutes after too many wrong two-factor codes. Whoever entered them knew your password, so change it now.")
+}
+
+// checkTOTP validates a code against the user's secret and records the
+// accepted time step so the same code cannot be used twice
+func (s *Service) checkTOTP(ctx context.Context, user *User, code string) (bool, error) {
+	if s.secretBox == nil || user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	secret, err := s.secretBox.Open(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := mfa.Validate(secret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+
+	user.TOTPLastStep = step
+	if _, err := s.db.Users().UpdateUser(ctx, user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// consumeRecoveryCode burns one of the user's recovery codes
+func (s *Service) consumeRecoveryCode(ctx context.Context, user *User, code string) (bool, error) {
+	hash := token.HashOpaque(mfa.NormalizeRecoveryCode(code))
+	err := s.db.RecoveryCodes().ConsumeRecoveryCode(ctx, user.ID, hash)
+	if err != nil {
+		if errors.Is(err, database.ErrRecoveryCodeNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// EnrollTOTP starts two-factor enrollment by generating a new secret. The
+// secret is stored but not enforced until ConfirmTOTP succeeds.
+func (s *Service) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.secretBox == nil {
+		writeErrorResponse(w, "Two-factor authentication is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		writeErrorResponse(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	sealed, err := s.secretBox.Seal(secret)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user.TOTPSecret = sealed
+	user.TOTPLastStep = 0
+	if _, err := s.db.Users().UpdateUser(r.Context(), user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: mfa.URI(s.mfaIssuer, user.Email, secret),
+	}, http.StatusOK)
+}
+
+// ConfirmTOTP finishes enrollment once the user proves their authenticator
+// works, and returns their recovery codes
+func (s *Service) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		writeErrorResponse(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		writeErrorResponse(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		writeErrorResponse(w, "Two-factor enrollment has not been started", http.StatusBadRequest)
+		return
+	}
+
+	verified, err := s.checkTOTP(r.Context(), user, req.Code)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		writeCodedErrorResponse(w, "Invalid verification code", CodeMFACodeInvalid, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user.TOTPEnabled = true
+	if _, err := s.db.Users().UpdateUser(r.Context(), user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, RecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// DisableTOTP turns two-factor authentication off after re-checking the password
+func (s *Service) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Password == "" {
+		writeErrorResponse(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.passwords.Verify(req.Password, user.Password); err != nil {
+		writeErrorResponse(w, "Password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		writeErrorResponse(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	wasEnabled := user.TOTPEnabled
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	if _, err := s.db.Users().UpdateUser(r.Context(), user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.db.RecoveryCodes().DeleteRecoveryCodes(r.Context(), user.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if wasEnabled {
+		s.sendSecurityAlert(r, user, "Two-factor authentication was disabled on your account.")
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "Two-factor authentication disabled"}, http.StatusOK)
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes and stores their hashes
+func (s *Service) replaceRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = token.HashOpaque(mfa.NormalizeRecoveryCode(code))
+	}
+
+	if err := s.db.RecoveryCodes().ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// currentUser loads the authenticated user, writing an error response if that fails
+func (s *Service) currentUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := s.db.Users().GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeErrorResponse(w, "User not found", http.StatusNotFound)
+			return nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// sendSecurityAlert notifies the user about a sensitive account change. It
+// is best effort: the change has already happened when this runs.
+func (s *Service) sendSecurityAlert(r *http.Request, user *User, message string) {
+	s.sendSecurityAlertTo(r, user.Email, message)
+}
+
+// sendSecurityAlertTo is sendSecurityAlert for an address the account may no
+// longer have
+func (s *Service) sendSecurityAlertTo(r *http.Request, address, message string) {
+	if s.emailService == nil {
+		return
+	}
+
+	s.emailService.SendSecurityAlert(r.Context(), address, message, email.SecurityContext{
+		RequestIP:   middleware.ClientIP(r),
+		UserAgent:   r.UserAgent(),
+		RequestTime: time.Now(),
+	})
+}
+
+// challengeAttempts counts failed codes per MFA challenge so a stolen
+// password can't be paired with unlimited guesses at the second factor
+type challengeAttempts struct {
+	mu       sync.Mutex
+	failures map[string]int
+	expires  map[string]time.Time
+}
+
+func newChallengeAttempts() *challengeAttempts {
+	return &challengeAttempts{
+		failures: make(map[string]int),
+		expires:  make(map[string]time.Time),
+	}
+}
+
+// allow reports whether the challenge may still be used
+func (c *challengeAttempts) allow(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures[id] < maxMFAAttempts
+}
+
+// fail records a wrong code against the challenge
+func (c *challengeAttempts) fail(id string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	c.failures[id]++
+	c.expires[id] = expiresAt
+}
+
+// burn makes the challenge unusable
+func (c *challengeAttempts) burn(id string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+	c.failures[id] = maxMFAAttempts
+	c.expires[id] = expiresAt
+}
+
+// prune forgets challenges that have expired anyway. Callers hold the lock.
+func (c *challengeAttempts) prune() {
+	now := time.Now()
+	for id, expiresAt := range c.expires {
+		if now.After(expiresAt) {
+			delete(c.failures, id)
+			delete(c.expires, id)
+		}
+	}
+}
+
+// HandleVerifyMFA is a wrapper around the service VerifyMFA method
+func HandleVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.VerifyMFA(w, r)
+}
+
+// HandleEnrollTOTP is a wrapper around the service EnrollTOTP method
+func HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.EnrollTOTP(w, r)
+}
+
+// HandleConfirmTOTP is a wrapper around the service ConfirmTOTP method
+func HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ConfirmTOTP(w, r)
+}
+
+// HandleDisableTOTP is a wrapper around the service DisableTOTP method
+func HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DisableTOTP(w, r)
+}
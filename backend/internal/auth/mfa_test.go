@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/mfa"
+)
+
+// recordingEmailService captures security alerts, reset codes, verification
+// links and sign-in links instead of sending them
+type recordingEmailService struct {
+	alerts           []string
+	resetCodes       []string
+	verificationURLs []string
+	magicLinks       []string
+	emailChanges     []string
+	emailCancels     []string
+	invitations      []string
+}
+
+func (m *recordingEmailService) SendEmail(ctx context.Context, e *email.Email) error { return nil }
+func (m *recordingEmailService) SendWelcomeEmail(ctx context.Context, to, name string) error {
+	return nil
+}
+func (m *recordingEmailService) SendPasswordResetCode(ctx context.Context, to, code string, securityCtx email.SecurityContext) error {
+	m.resetCodes = append(m.resetCodes, code)
+	return nil
+}
+func (m *recordingEmailService) SendEmailVerification(ctx context.Context, to, name, verificationURL string) error {
+	m.verificationURLs = append(m.verificationURLs, verificationURL)
+	return nil
+}
+func (m *recordingEmailService) SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx email.SecurityContext) error {
+	m.magicLinks = append(m.magicLinks, magicLinkURL)
+	return nil
+}
+func (m *recordingEmailService) SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx email.SecurityContext) error {
+	m.emailChanges = append(m.emailChanges, confirmURL)
+	return nil
+}
+func (m *recordingEmailService) SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx email.SecurityContext) error {
+	m.emailCancels = append(m.emailCancels, cancelURL)
+	return nil
+}
+func (m *recordingEmailService) SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error {
+	m.invitations = append(m.invitations, inviteURL)
+	return nil
+}
+func (m *recordingEmailService) SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx email.SecurityContext) error {
+	m.alerts = append(m.alerts, alertMessage)
+	return nil
+}
+func (m *recordingEmailService) GetProviderName() string { return "recording" }
+
+// enableTOTP enrolls the logged in user and returns the secret and recovery codes
+func enableTOTP(t *testing.T, service *Service, db database.Database, accessToken string) (string, []string) {
+	t.Helper()
+
+	rr := serveAPI(service, "POST", "/api/user/mfa/totp/enroll", "", accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Enroll failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var enroll TOTPEnrollResponse
+	json.NewDecoder(rr.Body).Decode(&enroll)
+	if enroll.Secret == "" || !strings.HasPrefix(enroll.OTPAuthURI, "otpauth://totp/") {
+		t.Fatalf("Unexpected enroll response: %+v", enroll)
+	}
+
+	code, _ := mfa.Code(enroll.Secret, time.Now())
+	rr = serveAPI(service, "POST", "/api/user/mfa/totp/confirm", `{"code": "`+code+`"}`, accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Confirm failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var confirm RecoveryCodesResponse
+	json.NewDecoder(rr.Body).Decode(&confirm)
+	return enroll.Secret, confirm.RecoveryCodes
+}
+
+// loginForChallenge logs in a user with two-factor enabled and returns the challenge
+func loginForChallenge(t *testing.T, service *Service) MFAChallengeResponse {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email": "john@example.com", "password": "password123"}`))
+	rr := httptest.NewRecorder()
+	service.Login(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var challenge MFAChallengeResponse
+	json.NewDecoder(rr.Body).Decode(&challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("Expected an MFA challenge, got %s", rr.Body.String())
+	}
+	return challenge
+}
+
+func postMFAVerify(service *Service, req MFAVerifyRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	rr := httptest.NewRecorder()
+	service.VerifyMFA(rr, httptest.NewRequest("POST", "/auth/mfa/verify", strings.NewReader(string(body))))
+	return rr
+}
+
+func TestTOTP_EnrollmentAndLogin(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	secret, recoveryCodes := enableTOTP(t, service, db, login.Token)
+
+	if len(recoveryCodes) != mfa.RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", mfa.RecoveryCodeCount, len(recoveryCodes))
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if !user.TOTPEnabled || user.TOTPSecret == "" || user.TOTPSecret == secret {
+		t.Error("Expected TOTP to be enabled with an encrypted secret")
+	}
+
+	challenge := loginForChallenge(t, service)
+
+	rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"})
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodeMFACodeInvalid) {
+		t.Errorf("Expected wrong code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The current code was spent confirming enrollment, so it can't be replayed
+	current, _ := mfa.Code(secret, time.Now())
+	if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: current}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected replayed code to be rejected, got %d", rr.Code)
+	}
+
+	next, _ := mfa.Code(secret, time.Now().Add(mfa.Period))
+	rr = postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: next})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Token == "" || response.RefreshToken == "" {
+		t.Error("Expected tokens after completing two-factor login")
+	}
+
+	// A challenge is single use
+	later, _ := mfa.Code(secret, time.Now().Add(2*mfa.Period))
+	if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: later}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected used challenge to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestTOTP_RecoveryCode(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	_, recoveryCodes := enableTOTP(t, service, db, login.Token)
+
+	challenge := loginForChallenge(t, service)
+	rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, RecoveryCode: strings.ToUpper(recoveryCodes[0])})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	challenge = loginForChallenge(t, service)
+	if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, RecoveryCode: recoveryCodes[0]}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected used recovery code to be rejected, got %d", rr.Code)
+	}
+
+	remaining, _ := db.RecoveryCodes().CountUnusedRecoveryCodes(context.Background(), 1)
+	if remaining != mfa.RecoveryCodeCount-1 {
+		t.Errorf("Expected %d unused recovery codes, got %d", mfa.RecoveryCodeCount-1, remaining)
+	}
+}
+
+func TestTOTP_AttemptLimit(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	secret, _ := enableTOTP(t, service, db, login.Token)
+
+	challenge := loginForChallenge(t, service)
+	for i := 0; i < maxMFAAttempts; i++ {
+		postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"})
+	}
+
+	next, _ := mfa.Code(secret, time.Now().Add(mfa.Period))
+	rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: next})
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodeMFATokenInvalid) {
+		t.Errorf("Expected challenge to be locked after too many attempts, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTOTP_LockoutAcrossChallenges(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	service.SetLoginThrottle(LoginThrottle{MaxFailures: 3, MaxFailuresPerIP: 100, LockoutDuration: 15 * time.Minute})
+	login := loginTestUser(t, service, db)
+	secret, recoveryCodes := enableTOTP(t, service, db, login.Token)
+
+	// A fresh challenge for every guess doesn't reset the count
+	for i := 0; i < 3; i++ {
+		challenge := loginForChallenge(t, service)
+		if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"}); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected wrong code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "wrong two-factor codes") {
+		t.Errorf("Expected the user to be told about the lock, got %q", last)
+	}
+
+	challenge := loginForChallenge(t, service)
+	next, _ := mfa.Code(secret, time.Now().Add(mfa.Period))
+	rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: next})
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeAccountLocked) || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the account to be locked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, RecoveryCode: recoveryCodes[0]}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected recovery codes to be locked too, got %d", rr.Code)
+	}
+
+	// Once the lock has passed, a right code works and clears the count
+	db.LoginAttempts().LockLogin(context.Background(), mfaKey(login.User.ID), time.Now().Add(-time.Second))
+	if rr := postMFAVerify(service, MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: next}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d after the lock, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if _, err := db.LoginAttempts().GetLoginAttempts(context.Background(), mfaKey(login.User.ID)); err == nil {
+		t.Error("Expected the count to be cleared")
+	}
+}
+
+func TestTOTP_Disable(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	enableTOTP(t, service, db, login.Token)
+
+	rr := serveAPI(service, "POST", "/api/user/mfa/totp/disable", `{"password": "wrong"}`, login.Token)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected wrong password to be rejected, got %d", rr.Code)
+	}
+
+	rr = serveAPI(service, "POST", "/api/user/mfa/totp/disable", `{"password": "password123"}`, login.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if len(emails.alerts) != 1 {
+		t.Errorf("Expected one security alert, got %d", len(emails.alerts))
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	if user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Error("Expected TOTP to be cleared")
+	}
+
+	if response := loginAgain(t, service); response.Token == "" {
+		t.Error("Expected login to issue tokens directly again")
+	}
+}
+
+func TestTOTP_EnrollRequiresSecretBox(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	rr := serveAPI(service, "POST", "/api/user/mfa/totp/enroll", "", login.Token)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without a secret box, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
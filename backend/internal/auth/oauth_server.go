@@ -0,0 +1,905 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/oauth"
+)
+
+// Errors returned by the OAuth endpoints in the error field, as defined by
+// RFC 6749 §4.1.2.1 and §5.2
+const (
+	OAuthErrorInvalidRequest          = "invalid_request"
+	OAuthErrorInvalidClient           = "invalid_client"
+	OAuthErrorInvalidGrant            = "invalid_grant"
+	OAuthErrorUnauthorizedClient      = "unauthorized_client"
+	OAuthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrorUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrorInvalidScope            = "invalid_scope"
+	OAuthErrorAccessDenied            = "access_denied"
+)
+
+// CodeOAuthClientInvalid is returned when an authorization request names
+// an unknown client or a redirect URI it didn't register. The user can't
+// be sent back to the client then, since nothing says where it is.
+const CodeOAuthClientInvalid = "oauth_client_invalid"
+
+const (
+	// oauthCodeTTL is how long a client has to exchange an authorization code
+	oauthCodeTTL = 5 * time.Minute
+
+	// maxOAuthClientNameLength caps the name shown on the consent screen
+	maxOAuthClientNameLength = 64
+
+	// maxOAuthRedirectURIs caps how many redirect URIs a client registers
+	maxOAuthRedirectURIs = 10
+)
+
+// OAuthClientInfo describes a registered client. The secret is never
+// returned after registration.
+type OAuthClientInfo struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OAuthClientsResponse is the body of GET /api/oauth/clients
+type OAuthClientsResponse struct {
+	Clients []OAuthClientInfo `json:"clients"`
+}
+
+// CreateOAuthClientRequest is the body of POST /api/oauth/clients.
+// Confidential clients, such as web servers, get a secret. Public ones,
+// such as mobile and single-page apps, can't keep one.
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+// CreateOAuthClientResponse returns a new client. ClientSecret is shown
+// only this once.
+type CreateOAuthClientResponse struct {
+	ClientSecret string          `json:"client_secret,omitempty"`
+	Client       OAuthClientInfo `json:"client"`
+}
+
+// OAuthAuthorizeRequest is an authorization request, as query parameters
+// of GET /api/oauth/authorize or the body of POST /api/oauth/authorize.
+// Approve is the user's answer and only read by POST.
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// OAuthConsentResponse is what the consent screen shows: the client asking
+// for access and the scopes it asks for
+type OAuthConsentResponse struct {
+	Client      OAuthConsentClient `json:"client"`
+	Scopes      []string           `json:"scopes"`
+	RedirectURI string             `json:"redirect_uri"`
+	State       string             `json:"state,omitempty"`
+}
+
+// OAuthConsentClient is the public part of a client
+type OAuthConsentClient struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+}
+
+// OAuthRedirectResponse tells the frontend where to send the user back
+// to the client, with the authorization code or an error
+type OAuthRedirectResponse struct {
+	RedirectURI string `json:"redirect_uri"`
+}
+
+// OAuthErrorResponse is an error from an OAuth endpoint. RedirectURI is
+// set for authorization requests whose error the client should receive.
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+	RedirectURI      string `json:"redirect_uri,omitempty"`
+}
+
+// OAuthTokenResponse is the body of a successful token request (RFC 6749 §5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthIntrospectionResponse is the body of POST /oauth/introspect (RFC 7662 §2.2)
+type OAuthIntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
+
+// ListOAuthClients returns the clients the authenticated user registered
+func (s *Service) ListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	clients, err := s.db.OAuthClients().ListUserOAuthClients(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := OAuthClientsResponse{Clients: []OAuthClientInfo{}}
+	for _, client := range clients {
+		response.Clients = append(response.Clients, oauthClientInfo(client))
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// CreateOAuthClient registers a client owned by the authenticated user
+func (s *Service) CreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateOAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateCreateOAuthClientRequest(&req); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientID, err := oauth.NewClientID()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var secret, secretHash string
+	if req.Confidential {
+		if secret, err = oauth.NewClientSecret(); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		secretHash = oauth.Hash(secret)
+	}
+
+	client, err := s.db.OAuthClients().CreateOAuthClient(r.Context(), &database.OAuthClient{
+		ClientID:     clientID,
+		SecretHash:   secretHash,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		OwnerID:      user.ID,
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, CreateOAuthClientResponse{ClientSecret: secret, Client: oauthClientInfo(client)}, http.StatusCreated)
+}
+
+// DeleteOAuthClient deletes one of the authenticated user's clients. Every
+// token issued to it stops working.
+func (s *Service) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.OAuthClients().DeleteOAuthClient(r.Context(), user.ID, r.PathValue("client_id")); err != nil {
+		if errors.Is(err, database.ErrOAuthClientNotFound) {
+			writeErrorResponse(w, "OAuth client not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "OAuth client deleted"}, http.StatusOK)
+}
+
+// Authorize handles an authorization request for the consent screen. GET
+// checks the request and returns what to show the user. POST records
+// their answer and returns where to send them back to the client: with an
+// authorization code if they approved, or access_denied if not.
+func (s *Service) Authorize(w http.ResponseWriter, r *http.Request) {
+	var req OAuthAuthorizeRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req = OAuthAuthorizeRequest{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	client, scopes, ok := s.checkAuthorizeRequest(w, r, &req)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSONResponse(w, OAuthConsentResponse{
+			Client:      OAuthConsentClient{ClientID: client.ClientID, Name: client.Name},
+			Scopes:      scopes,
+			RedirectURI: req.RedirectURI,
+			State:       req.State,
+		}, http.StatusOK)
+		return
+	}
+
+	if !req.Approve {
+		writeJSONResponse(w, OAuthRedirectResponse{
+			RedirectURI: authorizationErrorRedirect(&req, OAuthErrorAccessDenied, "The user denied the request"),
+		}, http.StatusOK)
+		return
+	}
+
+	code, err := oauth.NewAuthorizationCode()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	err = s.db.OAuthAuthorizationCodes().CreateOAuthAuthorizationCode(r.Context(), &database.OAuthAuthorizationCode{
+		CodeHash:      oauth.Hash(code),
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "You gave the app \""+client.Name+"\" access to your account ("+
+		strings.Join(scopes, ", ")+"). If this wasn't you, change your password.")
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	writeJSONResponse(w, OAuthRedirectResponse{RedirectURI: withQuery(req.RedirectURI, params)}, http.StatusOK)
+}
+
+// checkAuthorizeRequest validates an authorization request and returns its
+// client and the scopes it asks for. An unknown client or redirect URI is a
+// plain error, since the user can't be sent back. Other errors carry the
+// redirect URI the client should get them at. It writes the response and
+// returns false if the request is invalid.
+func (s *Service) checkAuthorizeRequest(w http.ResponseWriter, r *http.Request, req *OAuthAuthorizeRequest) (*database.OAuthClient, []string, bool) {
+	client, err := s.db.OAuthClients().GetOAuthClient(r.Context(), req.ClientID)
+	if err != nil && !errors.Is(err, database.ErrOAuthClientNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if client == nil || !client.AllowsRedirectURI(req.RedirectURI) {
+		writeCodedErrorResponse(w, "Unknown client or redirect URI", CodeOAuthClientInvalid, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	fail := func(code, description string) (*database.OAuthClient, []string, bool) {
+		writeJSONResponse(w, OAuthErrorResponse{
+			Error:            code,
+			ErrorDescription: description,
+			RedirectURI:      authorizationErrorRedirect(req, code, description),
+		}, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		return fail(OAuthErrorUnsupportedResponseType, "Only the code response type is supported")
+	}
+	if req.CodeChallengeMethod != oauth.CodeChallengeMethodS256 || !oauth.ValidCodeChallenge(req.CodeChallenge) {
+		return fail(OAuthErrorInvalidRequest, "PKCE with the S256 method is required")
+	}
+
+	scopes, ok := grantableScopes(req.Scope, client.Scopes)
+	if !ok {
+		return fail(OAuthErrorInvalidScope, "The client can't be granted the requested scope")
+	}
+
+	return client, scopes, true
+}
+
+// Token handles token requests from clients (RFC 6749 §3.2): exchanging an
+// authorization code, refreshing, and the client credentials grant. The
+// body is form encoded.
+func (s *Service) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, OAuthErrorInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case oauth.GrantAuthorizationCode:
+		s.exchangeAuthorizationCode(w, r, client)
+	case oauth.GrantRefreshToken:
+		s.refreshOAuthToken(w, r, client)
+	case oauth.GrantClientCredentials:
+		s.grantClientCredentials(w, r, client)
+	default:
+		writeOAuthError(w, OAuthErrorUnsupportedGrantType, "Unsupported grant type", http.StatusBadRequest)
+	}
+}
+
+// exchangeAuthorizationCode issues tokens for an authorization code. The
+// code is used up whether or not the exchange succeeds.
+func (s *Service) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *database.OAuthClient) {
+	ctx := r.Context()
+	code, err := s.db.OAuthAuthorizationCodes().ConsumeOAuthAuthorizationCode(ctx, oauth.Hash(r.PostForm.Get("code")))
+	if err != nil && !errors.Is(err, database.ErrOAuthAuthorizationCodeNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if code == nil || code.ClientID != client.ClientID || !time.Now().Before(code.ExpiresAt) ||
+		code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, OAuthErrorInvalidGrant, "Invalid or expired authorization code", http.StatusBadRequest)
+		return
+	}
+	if !oauth.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		writeOAuthError(w, OAuthErrorInvalidGrant, "The code verifier doesn't match the code challenge", http.StatusBadRequest)
+		return
+	}
+
+	if !s.oauthUserActive(w, r, code.UserID, OAuthErrorInvalidGrant) {
+		return
+	}
+
+	s.issueOAuthToken(w, r, client, code.UserID, oauth.GrantAuthorizationCode, code.Scopes)
+}
+
+// refreshOAuthToken replaces a refresh token and its access token with new
+// ones. The scope may be narrowed but not widened.
+func (s *Service) refreshOAuthToken(w http.ResponseWriter, r *http.Request, client *database.OAuthClient) {
+	ctx := r.Context()
+	stored, err := s.db.OAuthTokens().GetOAuthTokenByRefreshHash(ctx, oauth.Hash(r.PostForm.Get("refresh_token")))
+	if err != nil && !errors.Is(err, database.ErrOAuthTokenNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if stored == nil || stored.ClientID != client.ClientID || !stored.RefreshActive(time.Now()) {
+		writeOAuthError(w, OAuthErrorInvalidGrant, "Invalid or expired refresh token", http.StatusBadRequest)
+		return
+	}
+
+	scopes, ok := grantableScopes(r.PostForm.Get("scope"), stored.Scopes)
+	if !ok {
+		writeOAuthError(w, OAuthErrorInvalidScope, "The requested scope exceeds the original grant", http.StatusBadRequest)
+		return
+	}
+
+	if !s.oauthUserActive(w, r, stored.UserID, OAuthErrorInvalidGrant) {
+		return
+	}
+
+	// Only one of two concurrent refreshes revokes the token
+	if err := s.db.OAuthTokens().RevokeOAuthToken(ctx, stored.ID); err != nil {
+		if errors.Is(err, database.ErrOAuthTokenNotFound) {
+			writeOAuthError(w, OAuthErrorInvalidGrant, "Invalid or expired refresh token", http.StatusBadRequest)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.issueOAuthToken(w, r, client, stored.UserID, stored.GrantType, scopes)
+}
+
+// grantClientCredentials issues an access token to a confidential client
+// acting for the user who registered it. It gets no refresh token, since
+// it can ask again whenever it likes.
+func (s *Service) grantClientCredentials(w http.ResponseWriter, r *http.Request, client *database.OAuthClient) {
+	if !client.Confidential() {
+		writeOAuthError(w, OAuthErrorUnauthorizedClient, "Public clients can't use the client credentials grant", http.StatusBadRequest)
+		return
+	}
+
+	scopes, ok := grantableScopes(r.PostForm.Get("scope"), client.Scopes)
+	if !ok {
+		writeOAuthError(w, OAuthErrorInvalidScope, "The client can't be granted the requested scope", http.StatusBadRequest)
+		return
+	}
+
+	if !s.oauthUserActive(w, r, client.OwnerID, OAuthErrorUnauthorizedClient) {
+		return
+	}
+
+	s.issueOAuthToken(w, r, client, client.OwnerID, oauth.GrantClientCredentials, scopes)
+}
+
+// oauthUserActive checks the user a token would act for can still be acted
+// for. Suspending an account or scheduling it for deletion revokes its
+// tokens, and a pending code or refresh token must not bring them back.
+// It writes the response and returns false if not.
+func (s *Service) oauthUserActive(w http.ResponseWriter, r *http.Request, userID int, errorCode string) bool {
+	user, err := s.db.Users().GetUserByID(r.Context(), userID)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if user == nil || user.Suspended() || user.DeletionScheduledAt != nil {
+		writeOAuthError(w, errorCode, "The account is no longer available", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// issueOAuthToken stores and returns a new access token, with a refresh
+// token unless it was granted by client credentials
+func (s *Service) issueOAuthToken(w http.ResponseWriter, r *http.Request, client *database.OAuthClient, userID int, grantType string, scopes []string) {
+	accessToken, err := oauth.NewAccessToken()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	issued := &database.OAuthToken{
+		ClientID:        client.ClientID,
+		UserID:          userID,
+		GrantType:       grantType,
+		Scopes:          scopes,
+		AccessTokenHash: oauth.Hash(accessToken),
+		ExpiresAt:       now.Add(s.oauthAccessTTL),
+	}
+
+	var refreshToken string
+	if grantType != oauth.GrantClientCredentials {
+		if refreshToken, err = oauth.NewRefreshToken(); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		refreshExpiresAt := now.Add(s.oauthRefreshTTL)
+		issued.RefreshTokenHash = oauth.Hash(refreshToken)
+		issued.RefreshExpiresAt = &refreshExpiresAt
+	}
+
+	if _, err := s.db.OAuthTokens().CreateOAuthToken(r.Context(), issued); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeJSONResponse(w, OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.oauthAccessTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        oauth.FormatScope(scopes),
+	}, http.StatusOK)
+}
+
+// Introspect tells a confidential client whether a token it was issued is
+// active, and what it grants (RFC 7662). Tokens issued to other clients
+// are reported inactive.
+func (s *Service) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, OAuthErrorInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+	if !client.Confidential() {
+		writeOAuthError(w, OAuthErrorUnauthorizedClient, "Public clients can't introspect tokens", http.StatusBadRequest)
+		return
+	}
+
+	raw := r.PostForm.Get("token")
+	if raw == "" {
+		writeOAuthError(w, OAuthErrorInvalidRequest, "The token parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	stored, isAccessToken, err := s.findOAuthToken(r, raw)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Cache-Control", "no-store")
+	if stored == nil || stored.ClientID != client.ClientID ||
+		(isAccessToken && !stored.Active(now)) || (!isAccessToken && !stored.RefreshActive(now)) {
+		writeJSONResponse(w, OAuthIntrospectionResponse{Active: false}, http.StatusOK)
+		return
+	}
+
+	response := OAuthIntrospectionResponse{
+		Active:   true,
+		Scope:    oauth.FormatScope(stored.Scopes),
+		ClientID: stored.ClientID,
+		Iat:      stored.CreatedAt.Unix(),
+		Sub:      strconv.Itoa(stored.UserID),
+	}
+	if isAccessToken {
+		response.TokenType = "Bearer"
+		response.Exp = stored.ExpiresAt.Unix()
+	} else {
+		response.Exp = stored.RefreshExpiresAt.Unix()
+	}
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// Revoke lets a client revoke a token it was issued (RFC 7009). Revoking
+// either the access or the refresh token revokes both. Unknown tokens and
+// tokens of other clients are ignored, so the response never reveals
+// whether a token exists.
+func (s *Service) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, OAuthErrorInvalidRequest, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := s.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	raw := r.PostForm.Get("token")
+	if raw == "" {
+		writeOAuthError(w, OAuthErrorInvalidRequest, "The token parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	stored, _, err := s.findOAuthToken(r, raw)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if stored != nil && stored.ClientID == client.ClientID {
+		if err := s.db.OAuthTokens().RevokeOAuthToken(r.Context(), stored.ID); err != nil && !errors.Is(err, database.ErrOAuthTokenNotFound) {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// findOAuthToken looks a token up as an access token and as a refresh
+// token. It returns nil if it is neither.
+func (s *Service) findOAuthToken(r *http.Request, raw string) (*database.OAuthToken, bool, error) {
+	hash := oauth.Hash(raw)
+	stored, err := s.db.OAuthTokens().GetOAuthTokenByAccessHash(r.Context(), hash)
+	if err == nil {
+		return stored, true, nil
+	}
+	if !errors.Is(err, database.ErrOAuthTokenNotFound) {
+		return nil, false, err
+	}
+
+	stored, err = s.db.OAuthTokens().GetOAuthTokenByRefreshHash(r.Context(), hash)
+	if err == nil {
+		return stored, false, nil
+	}
+	if !errors.Is(err, database.ErrOAuthTokenNotFound) {
+		return nil, false, err
+	}
+	return nil, false, nil
+}
+
+// authenticateOAuthClient identifies the client making a request to the
+// token, introspection or revocation endpoint, by HTTP Basic authentication
+// or client_id and client_secret in the body (RFC 6749 §2.3.1). Public
+// clients only send their client_id. It writes the response and returns
+// false if the client can't be authenticated.
+func (s *Service) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (*database.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// Basic credentials are form encoded before they are joined
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			writeInvalidClient(w)
+			return nil, false
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" {
+		writeInvalidClient(w)
+		return nil, false
+	}
+
+	client, err := s.db.OAuthClients().GetOAuthClient(r.Context(), clientID)
+	if err != nil && !errors.Is(err, database.ErrOAuthClientNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if client == nil {
+		writeInvalidClient(w)
+		return nil, false
+	}
+
+	if client.Confidential() {
+		if secret == "" || !oauth.SecretMatches(secret, client.SecretHash) {
+			writeInvalidClient(w)
+			return nil, false
+		}
+	} else if secret != "" {
+		writeInvalidClient(w)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// grantableScopes parses a requested scope and checks it is within the
+// allowed scopes. An empty request asks for all of them.
+func grantableScopes(requested string, allowed []string) ([]string, bool) {
+	scopes := oauth.ParseScope(requested)
+	if len(scopes) == 0 {
+		return append([]string(nil), allowed...), len(allowed) > 0
+	}
+
+	for _, scope := range scopes {
+		found := false
+		for _, a := range allowed {
+			if a == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return scopes, true
+}
+
+// authorizationErrorRedirect returns the redirect URI that sends an
+// authorization error back to the client (RFC 6749 §4.1.2.1)
+func authorizationErrorRedirect(req *OAuthAuthorizeRequest, code, description string) string {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return withQuery(req.RedirectURI, params)
+}
+
+// withQuery adds parameters to a URI's query, keeping any it already has
+func withQuery(uri string, params url.Values) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := parsed.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// validateCreateOAuthClientRequest normalizes and checks a new client's
+// name, redirect URIs and scopes
+func validateCreateOAuthClientRequest(req *CreateOAuthClientRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return &ValidationError{"Name is required"}
+	}
+	if len(req.Name) > maxOAuthClientNameLength {
+		return &ValidationError{"Name is too long"}
+	}
+
+	if len(req.RedirectURIs) == 0 {
+		return &ValidationError{"At least one redirect URI is required"}
+	}
+	if len(req.RedirectURIs) > maxOAuthRedirectURIs {
+		return &ValidationError{"Too many redirect URIs"}
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return &ValidationError{"Invalid redirect URI: " + uri}
+		}
+	}
+
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return err
+	}
+	req.Scopes = scopes
+
+	return nil
+}
+
+// validRedirectURI reports whether a client may register a redirect URI:
+// an absolute URI without a fragment that is https, http on the loopback
+// interface, or a private-use scheme such as com.example.app for native
+// apps (RFC 8252 §7)
+func validRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(uri, "\n#") {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+// oauthClientInfo converts a stored client to its API representation
+func oauthClientInfo(client *database.OAuthClient) OAuthClientInfo {
+	return OAuthClientInfo{
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Scopes:       client.Scopes,
+		Confidential: client.Confidential(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// writeOAuthError writes an error in the format of RFC 6749 §5.2
+func writeOAuthError(w http.ResponseWriter, code, description string, statusCode int) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(w, OAuthErrorResponse{Error: code, ErrorDescription: description}, statusCode)
+}
+
+// writeInvalidClient refuses a request from a client that couldn't be authenticated
+func writeInvalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	writeOAuthError(w, OAuthErrorInvalidClient, "Client authentication failed", http.StatusUnauthorized)
+}
+
+// HandleListOAuthClients is a wrapper around the service ListOAuthClients method
+func HandleListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListOAuthClients(w, r)
+}
+
+// HandleCreateOAuthClient is a wrapper around the service CreateOAuthClient method
+func HandleCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateOAuthClient(w, r)
+}
+
+// HandleDeleteOAuthClient is a wrapper around the service DeleteOAuthClient method
+func HandleDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteOAuthClient(w, r)
+}
+
+// HandleAuthorize is a wrapper around the service Authorize method
+func HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Authorize(w, r)
+}
+
+// HandleToken is a wrapper around the service Token method
+func HandleToken(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Token(w, r)
+}
+
+// HandleIntrospect is a wrapper around the service Introspect method
+func HandleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Introspect(w, r)
+}
+
+// HandleRevoke is a wrapper around the service Revoke method
+func HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.Revoke(w, r)
+}
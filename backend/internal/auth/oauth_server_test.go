@@ -0,0 +1,506 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/apikey"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/oauth"
+)
+
+// The PKCE example from RFC 7636 Appendix B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+const testRedirectURI = "https://app.example.com/callback"
+
+// postOAuthForm sends a form to a client facing endpoint, with the client
+// credentials in the body
+func postOAuthForm(handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func createTestOAuthClient(t *testing.T, service *Service, db database.Database, accessToken string, confidential bool) CreateOAuthClientResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(CreateOAuthClientRequest{
+		Name:         "Reports",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{apikey.ScopeProfileRead, apikey.ScopeMetricsRead},
+		Confidential: confidential,
+	})
+	rr := serveAPI(service, "POST", "/api/oauth/clients", string(body), accessToken)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Create client failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response CreateOAuthClientResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func authorizeQuery(clientID, scope string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {scope},
+		"state":                 {"xyz"},
+		"code_challenge":        {testCodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// approveAuthorization has the logged in user approve a request and
+// returns the authorization code
+func approveAuthorization(t *testing.T, service *Service, db database.Database, accessToken, clientID, scope string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(OAuthAuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         testRedirectURI,
+		Scope:               scope,
+		State:               "xyz",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+		Approve:             true,
+	})
+	rr := serveAPI(service, "POST", "/api/oauth/authorize", string(body), accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Approve failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response OAuthRedirectResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	redirect, err := url.Parse(response.RedirectURI)
+	if err != nil || !strings.HasPrefix(response.RedirectURI, testRedirectURI+"?") || redirect.Query().Get("state") != "xyz" {
+		t.Fatalf("Unexpected redirect %q", response.RedirectURI)
+	}
+	return redirect.Query().Get("code")
+}
+
+func decodeTokenResponse(t *testing.T, rr *httptest.ResponseRecorder) OAuthTokenResponse {
+	t.Helper()
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Token request failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	var response OAuthTokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func TestOAuthClients_CreateListDelete(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	created := createTestOAuthClient(t, service, db, login.Token, true)
+	if !strings.HasPrefix(created.ClientSecret, oauth.ClientSecretPrefix) || !created.Client.Confidential {
+		t.Errorf("Expected a confidential client with a secret, got %+v", created)
+	}
+	public := createTestOAuthClient(t, service, db, login.Token, false)
+	if public.ClientSecret != "" || public.Client.Confidential {
+		t.Errorf("Expected a public client without a secret, got %+v", public)
+	}
+
+	rr := serveAPI(service, "GET", "/api/oauth/clients", "", login.Token)
+	if strings.Contains(rr.Body.String(), created.ClientSecret) {
+		t.Error("Expected the secret not to be listed")
+	}
+	var list OAuthClientsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Clients) != 2 || list.Clients[0].ClientID != created.Client.ClientID {
+		t.Fatalf("Unexpected clients: %+v", list)
+	}
+
+	path := "/api/oauth/clients/" + created.Client.ClientID
+	if rr := serveAPI(service, "DELETE", path, "", login.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Delete failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", path, "", login.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected deleting twice to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestOAuthClients_Validation(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"redirect_uris": ["https://app.example.com/cb"], "scopes": ["profile:read"]}`},
+		{"no redirect URIs", `{"name": "App", "scopes": ["profile:read"]}`},
+		{"plain http", `{"name": "App", "redirect_uris": ["http://app.example.com/cb"], "scopes": ["profile:read"]}`},
+		{"fragment", `{"name": "App", "redirect_uris": ["https://app.example.com/cb#x"], "scopes": ["profile:read"]}`},
+		{"relative", `{"name": "App", "redirect_uris": ["/cb"], "scopes": ["profile:read"]}`},
+		{"unknown scope", `{"name": "App", "redirect_uris": ["https://app.example.com/cb"], "scopes": ["admin"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(service, "POST", "/api/oauth/clients", tt.body, login.Token)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Native apps may use loopback and private-use redirect URIs
+	body := `{"name": "App", "redirect_uris": ["http://127.0.0.1:8400/cb", "com.example.app:/cb"], "scopes": ["profile:read"]}`
+	if rr := serveAPI(service, "POST", "/api/oauth/clients", body, login.Token); rr.Code != http.StatusCreated {
+		t.Errorf("Expected native redirect URIs to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOAuthAuthorize_ConsentData(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, false)
+
+	rr := serveAPI(service, "GET", "/api/oauth/authorize?"+authorizeQuery(client.Client.ClientID, "").Encode(), "", login.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var consent OAuthConsentResponse
+	json.NewDecoder(rr.Body).Decode(&consent)
+	if consent.Client.Name != "Reports" || len(consent.Scopes) != 2 || consent.State != "xyz" {
+		t.Errorf("Expected the client and all its scopes, got %+v", consent)
+	}
+
+	query := authorizeQuery(client.Client.ClientID, "")
+	query.Set("redirect_uri", "https://evil.example.com/callback")
+	rr = serveAPI(service, "GET", "/api/oauth/authorize?"+query.Encode(), "", login.Token)
+	var errResp ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&errResp)
+	if rr.Code != http.StatusBadRequest || errResp.Code != CodeOAuthClientInvalid {
+		t.Errorf("Expected an unregistered redirect URI to be refused without redirecting, got %d: %+v", rr.Code, errResp)
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+		error string
+	}{
+		{"scope beyond the client", authorizeQuery(client.Client.ClientID, "profile:write"), OAuthErrorInvalidScope},
+		{"no PKCE", func() url.Values {
+			q := authorizeQuery(client.Client.ClientID, "")
+			q.Del("code_challenge")
+			return q
+		}(), OAuthErrorInvalidRequest},
+		{"plain PKCE", func() url.Values {
+			q := authorizeQuery(client.Client.ClientID, "")
+			q.Set("code_challenge_method", "plain")
+			return q
+		}(), OAuthErrorInvalidRequest},
+		{"implicit grant", func() url.Values {
+			q := authorizeQuery(client.Client.ClientID, "")
+			q.Set("response_type", "token")
+			return q
+		}(), OAuthErrorUnsupportedResponseType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveAPI(service, "GET", "/api/oauth/authorize?"+tt.query.Encode(), "", login.Token)
+			var response OAuthErrorResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			if rr.Code != http.StatusBadRequest || response.Error != tt.error {
+				t.Fatalf("Expected %s, got %d: %+v", tt.error, rr.Code, response)
+			}
+			redirect, _ := url.Parse(response.RedirectURI)
+			if redirect.Query().Get("error") != tt.error || redirect.Query().Get("state") != "xyz" {
+				t.Errorf("Expected the error to be sent back to the client, got %q", response.RedirectURI)
+			}
+		})
+	}
+}
+
+func TestOAuthAuthorize_Deny(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, false)
+
+	body := `{"response_type": "code", "client_id": "` + client.Client.ClientID + `", "redirect_uri": "` + testRedirectURI +
+		`", "code_challenge": "` + testCodeChallenge + `", "code_challenge_method": "S256", "approve": false}`
+	rr := serveAPI(service, "POST", "/api/oauth/authorize", body, login.Token)
+
+	var response OAuthRedirectResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	redirect, _ := url.Parse(response.RedirectURI)
+	if rr.Code != http.StatusOK || redirect.Query().Get("error") != OAuthErrorAccessDenied || redirect.Query().Has("code") {
+		t.Errorf("Expected access_denied, got %d: %q", rr.Code, response.RedirectURI)
+	}
+}
+
+func TestOAuthToken_AuthorizationCodeFlow(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, false)
+	clientID := client.Client.ClientID
+
+	code := approveAuthorization(t, service, db, login.Token, clientID, apikey.ScopeProfileRead)
+	if len(emails.alerts) != 1 {
+		t.Errorf("Expected a security alert about the grant, got %v", emails.alerts)
+	}
+
+	exchange := url.Values{
+		"grant_type":    {oauth.GrantAuthorizationCode},
+		"client_id":     {clientID},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}
+	rr := postOAuthForm(service.Token, exchange)
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected the token response not to be cached")
+	}
+	tokens := decodeTokenResponse(t, rr)
+	if !oauth.IsAccessToken(tokens.AccessToken) || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" || tokens.Scope != apikey.ScopeProfileRead {
+		t.Fatalf("Unexpected token response %+v", tokens)
+	}
+
+	if rr := postOAuthForm(service.Token, exchange); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), OAuthErrorInvalidGrant) {
+		t.Errorf("Expected a code to work once, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The access token reaches routes needing its scope and no others
+	scoped := func(scope string) *httptest.ResponseRecorder {
+		handler := middleware.RequireAuth(db, service.tokens)(middleware.RequireScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+		req := httptest.NewRequest("GET", "/api/user/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := scoped(apikey.ScopeProfileRead); rr.Code != http.StatusOK {
+		t.Errorf("Expected the access token to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := scoped(apikey.ScopeMetricsRead); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a scope that wasn't granted to be refused, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "GET", "/api/oauth/clients", "", tokens.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the access token to be refused on unscoped routes, got %d", rr.Code)
+	}
+
+	refreshed := decodeTokenResponse(t, postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantRefreshToken},
+		"client_id":     {clientID},
+		"refresh_token": {tokens.RefreshToken},
+	}))
+	if refreshed.RefreshToken == tokens.RefreshToken || refreshed.Scope != apikey.ScopeProfileRead {
+		t.Errorf("Expected a new token pair with the same scope, got %+v", refreshed)
+	}
+	if rr := scoped(apikey.ScopeProfileRead); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refreshing to revoke the old access token, got %d", rr.Code)
+	}
+	rr = postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantRefreshToken},
+		"client_id":     {clientID},
+		"refresh_token": {tokens.RefreshToken},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a refresh token to work once, got %d", rr.Code)
+	}
+	rr = postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantRefreshToken},
+		"client_id":     {clientID},
+		"refresh_token": {refreshed.RefreshToken},
+		"scope":         {apikey.ScopeMetricsRead},
+	})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), OAuthErrorInvalidScope) {
+		t.Errorf("Expected refreshing not to widen the scope, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOAuthToken_CodeChecks(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, true)
+	other := createTestOAuthClient(t, service, db, login.Token, false)
+
+	tests := []struct {
+		name   string
+		modify func(url.Values)
+		status int
+	}{
+		{"wrong verifier", func(f url.Values) { f.Set("code_verifier", strings.Repeat("a", 43)) }, http.StatusBadRequest},
+		{"wrong redirect URI", func(f url.Values) { f.Set("redirect_uri", "https://app.example.com/other") }, http.StatusBadRequest},
+		{"missing secret", func(f url.Values) { f.Del("client_secret") }, http.StatusUnauthorized},
+		{"wrong secret", func(f url.Values) { f.Set("client_secret", oauth.ClientSecretPrefix+"x") }, http.StatusUnauthorized},
+		{"another client", func(f url.Values) {
+			f.Set("client_id", other.Client.ClientID)
+			f.Del("client_secret")
+		}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"grant_type":    {oauth.GrantAuthorizationCode},
+				"client_id":     {client.Client.ClientID},
+				"client_secret": {client.ClientSecret},
+				"code":          {approveAuthorization(t, service, db, login.Token, client.Client.ClientID, "")},
+				"redirect_uri":  {testRedirectURI},
+				"code_verifier": {testCodeVerifier},
+			}
+			tt.modify(form)
+			if rr := postOAuthForm(service.Token, form); rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Confidential clients may authenticate with HTTP Basic
+	form := url.Values{
+		"grant_type":    {oauth.GrantAuthorizationCode},
+		"code":          {approveAuthorization(t, service, db, login.Token, client.Client.ClientID, "")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.Client.ClientID, client.ClientSecret)
+	rr := httptest.NewRecorder()
+	service.Token(rr, req)
+	decodeTokenResponse(t, rr)
+}
+
+func TestOAuthToken_ClientCredentials(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	confidential := createTestOAuthClient(t, service, db, login.Token, true)
+	public := createTestOAuthClient(t, service, db, login.Token, false)
+
+	rr := postOAuthForm(service.Token, url.Values{
+		"grant_type": {oauth.GrantClientCredentials},
+		"client_id":  {public.Client.ClientID},
+	})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), OAuthErrorUnauthorizedClient) {
+		t.Errorf("Expected a public client to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	tokens := decodeTokenResponse(t, postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantClientCredentials},
+		"client_id":     {confidential.Client.ClientID},
+		"client_secret": {confidential.ClientSecret},
+		"scope":         {apikey.ScopeMetricsRead},
+	}))
+	if tokens.RefreshToken != "" || tokens.Scope != apikey.ScopeMetricsRead {
+		t.Errorf("Expected an access token alone, got %+v", tokens)
+	}
+
+	stored, _ := db.OAuthTokens().GetOAuthTokenByAccessHash(t.Context(), oauth.Hash(tokens.AccessToken))
+	if stored.UserID != login.User.ID {
+		t.Errorf("Expected the token to act for the client's owner, got user %d", stored.UserID)
+	}
+}
+
+func TestOAuthIntrospectAndRevoke(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, true)
+	other := createTestOAuthClient(t, service, db, login.Token, true)
+
+	tokens := decodeTokenResponse(t, postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantAuthorizationCode},
+		"client_id":     {client.Client.ClientID},
+		"client_secret": {client.ClientSecret},
+		"code":          {approveAuthorization(t, service, db, login.Token, client.Client.ClientID, "")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}))
+
+	introspect := func(c CreateOAuthClientResponse, token string) OAuthIntrospectionResponse {
+		rr := postOAuthForm(service.Introspect, url.Values{
+			"client_id":     {c.Client.ClientID},
+			"client_secret": {c.ClientSecret},
+			"token":         {token},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Introspect failed with status %d: %s", rr.Code, rr.Body.String())
+		}
+		var response OAuthIntrospectionResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response
+	}
+
+	info := introspect(client, tokens.AccessToken)
+	if !info.Active || info.ClientID != client.Client.ClientID || info.TokenType != "Bearer" || info.Sub != "1" || info.Exp == 0 {
+		t.Errorf("Unexpected introspection %+v", info)
+	}
+	if info := introspect(client, tokens.RefreshToken); !info.Active || info.TokenType != "" {
+		t.Errorf("Expected the refresh token to be active, got %+v", info)
+	}
+	if info := introspect(other, tokens.AccessToken); info.Active {
+		t.Error("Expected another client's token to be reported inactive")
+	}
+	if info := introspect(client, "gsat_unknown"); info.Active {
+		t.Error("Expected an unknown token to be inactive")
+	}
+
+	// Revoking another client's token is ignored
+	revoke := func(c CreateOAuthClientResponse, token string) {
+		rr := postOAuthForm(service.Revoke, url.Values{
+			"client_id":     {c.Client.ClientID},
+			"client_secret": {c.ClientSecret},
+			"token":         {token},
+		})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Revoke failed with status %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	revoke(other, tokens.RefreshToken)
+	if info := introspect(client, tokens.AccessToken); !info.Active {
+		t.Error("Expected another client not to revoke the token")
+	}
+
+	revoke(client, tokens.RefreshToken)
+	if info := introspect(client, tokens.AccessToken); info.Active {
+		t.Error("Expected revoking the refresh token to revoke the access token")
+	}
+	revoke(client, tokens.RefreshToken)
+}
+
+func TestOAuthToken_RefusedAfterSignOutEverywhere(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+	client := createTestOAuthClient(t, service, db, login.Token, false)
+
+	tokens := decodeTokenResponse(t, postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantAuthorizationCode},
+		"client_id":     {client.Client.ClientID},
+		"code":          {approveAuthorization(t, service, db, login.Token, client.Client.ClientID, "")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}))
+
+	if err := service.signOutEverywhere(t.Context(), login.User.ID); err != nil {
+		t.Fatalf("signOutEverywhere() error = %v", err)
+	}
+
+	rr := postOAuthForm(service.Token, url.Values{
+		"grant_type":    {oauth.GrantRefreshToken},
+		"client_id":     {client.Client.ClientID},
+		"refresh_token": {tokens.RefreshToken},
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected signing out everywhere to revoke OAuth tokens, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/oidc"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// AuthMethodOIDC is recorded on sessions started through an OpenID Connect provider
+const AuthMethodOIDC = "oidc"
+
+// Error codes returned by the OpenID Connect endpoints
+const (
+	CodeOIDCStateInvalid    = "oidc_state_invalid"
+	CodeOIDCLoginFailed     = "oidc_login_failed"
+	CodeOIDCEmailUnverified = "oidc_email_unverified"
+)
+
+// oidcLoginTTL is how long the user has to finish logging in at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// OIDCProviderInfo describes a login provider for the login page
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// OIDCProvidersResponse is the body of GET /auth/oidc/providers
+type OIDCProvidersResponse struct {
+	Providers []OIDCProviderInfo `json:"providers"`
+}
+
+// OIDCStartResponse tells the frontend where to send the browser. The
+// frontend should keep State and check it against the callback's.
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+	ExpiresIn        int    `json:"expires_in"`
+}
+
+// OIDCCallbackRequest carries the parameters the provider redirected back with
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// SetOIDCProviders sets the OpenID Connect login providers, in the order
+// they should be offered
+func (s *Service) SetOIDCProviders(providers []*oidc.Provider) {
+	s.oidcProviders = providers
+}
+
+// oidcProvider finds a configured provider by name, writing a 404 if there is none
+func (s *Service) oidcProvider(w http.ResponseWriter, name string) (*oidc.Provider, bool) {
+	for _, provider := range s.oidcProviders {
+		if provider.Name() == name {
+			return provider, true
+		}
+	}
+	writeErrorResponse(w, "Unknown login provider", http.StatusNotFound)
+	return nil, false
+}
+
+// ListOIDCProviders returns the configured login providers
+func (s *Service) ListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := OIDCProvidersResponse{Providers: []OIDCProviderInfo{}}
+	for _, provider := range s.oidcProviders {
+		response.Providers = append(response.Providers, OIDCProviderInfo{
+			Name:        provider.Name(),
+			DisplayName: provider.DisplayName(),
+		})
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// StartOIDCLogin begins an authorization code flow with PKCE. The state,
+// nonce and code verifier are kept server side until the callback.
+func (s *Service) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider, ok := s.oidcProvider(w, r.PathValue("provider"))
+	if !ok {
+		return
+	}
+
+	state, errState := oidc.RandomString()
+	nonce, errNonce := oidc.RandomString()
+	verifier, errVerifier := oidc.RandomString()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		writeErrorResponse(w, "Login provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	err = s.db.OIDCLoginStates().CreateOIDCLoginState(r.Context(), &database.OIDCLoginState{
+		StateHash:    token.HashOpaque(state),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, OIDCStartResponse{
+		AuthorizationURL: authURL,
+		State:            state,
+		ExpiresIn:        int(oidcLoginTTL.Seconds()),
+	}, http.StatusOK)
+}
+
+// FinishOIDCLogin redeems the authorization code, signs in the linked user
+// and links or creates an account by verified email on first login
+func (s *Service) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider, ok := s.oidcProvider(w, r.PathValue("provider"))
+	if !ok {
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" || req.State == "" {
+		writeErrorResponse(w, "Code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	state, err := s.db.OIDCLoginStates().ConsumeOIDCLoginState(ctx, token.HashOpaque(req.State))
+	if err != nil && !errors.Is(err, database.ErrOIDCLoginStateNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if state == nil || state.Provider != provider.Name() || time.Now().After(state.ExpiresAt) {
+		writeCodedErrorResponse(w, "Invalid or expired login state", CodeOIDCStateInvalid, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := provider.Exchange(ctx, req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		writeCodedErrorResponse(w, "Login with provider failed", CodeOIDCLoginFailed, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.oidcUser(r, provider, claims)
+	if err != nil {
+		if errors.Is(err, errOIDCEmailUnverified) {
+			writeCodedErrorResponse(w, "The provider has not verified your email address", CodeOIDCEmailUnverified, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, errRegistrationClosed) {
+			writeCodedErrorResponse(w, "Registration is closed", CodeRegistrationClosed, http.StatusForbidden)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The provider vouches for who the user is, not for the second factor
+	if user.TOTPEnabled {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	response, err := s.startSession(w, r, user, AuthMethodOIDC)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// errOIDCEmailUnverified means an unlinked provider account has no verified email
+var errOIDCEmailUnverified = errors.New("provider did not verify the email address")
+
+// oidcUser returns the user linked to the provider account. Unlinked
+// accounts are linked to the user with the same email, or to a new user if
+// there is none. A matching account that never verified its email is reset
+// first, since whoever registered it may not own the address.
+func (s *Service) oidcUser(r *http.Request, provider *oidc.Provider, claims *oidc.IDTokenClaims) (*User, error) {
+	ctx := r.Context()
+
+	identity, err := s.db.OIDCIdentities().GetOIDCIdentity(ctx, provider.Name(), claims.Subject)
+	if err != nil && !errors.Is(err, database.ErrOIDCIdentityNotFound) {
+		return nil, err
+	}
+	if identity != nil {
+		if err := s.db.OIDCIdentities().TouchOIDCIdentity(ctx, identity.ID, claims.Email, time.Now()); err != nil {
+			return nil, err
+		}
+		return s.db.Users().GetUserByID(ctx, identity.UserID)
+	}
+
+	// Linking trusts the email, so it has to be one the provider verified
+	email, ok := claims.VerifiedEmail()
+	if !ok {
+		return nil, errOIDCEmailUnverified
+	}
+
+	user, err := s.db.Users().GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		return nil, err
+	}
+
+	existing := user != nil
+	if !existing {
+		if !s.openRegistration {
+			return nil, errRegistrationClosed
+		}
+		if user, err = s.createPasswordlessUser(ctx, email, claims.Name); err != nil {
+			return nil, err
+		}
+	} else if !user.EmailVerified() {
+		if user, err = s.reclaimUnverifiedUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err = s.db.OIDCIdentities().CreateOIDCIdentity(ctx, &database.OIDCIdentity{
+		UserID:   user.ID,
+		Provider: provider.Name(),
+		Subject:  claims.Subject,
+		Email:    email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.OIDCIdentities().TouchOIDCIdentity(ctx, identity.ID, email, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if existing {
+		s.sendSecurityAlert(r, user, "Sign-in with "+provider.DisplayName()+" was linked to your account.")
+	}
+
+	return user, nil
+}
+
+// reclaimUnverifiedUser hands an account to the owner of its email address.
+// Anyone could have registered it before the address was proven, so their
+// password, passkeys, two-factor setup and sessions are removed.
+func (s *Service) reclaimUnverifiedUser(ctx context.Context, user *User) (*User, error) {
+	if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.db.WebAuthnCredentials().ListUserWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, credential := range credentials {
+		if err := s.db.WebAuthnCredentials().DeleteWebAuthnCredential(ctx, user.ID, credential.ID); err != nil && !errors.Is(err, database.ErrWebAuthnCredentialNotFound) {
+			return nil, err
+		}
+	}
+	if err := s.db.RecoveryCodes().DeleteRecoveryCodes(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user.Password = ""
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.EmailVerifiedAt = &now
+	return s.db.Users().UpdateUser(ctx, user)
+}
+
+// createPasswordlessUser creates an account for a first-time provider or
+// magic-link login. It has no password, so it can only sign in that way
+// until one is set. Both prove the email, so the account starts out verified.
+func (s *Service) createPasswordlessUser(ctx context.Context, email, name string) (*User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	verifiedAt := time.Now()
+	return s.db.Users().CreateUser(ctx, &User{
+		Name:            name,
+		Email:           email,
+		EmailVerifiedAt: &verifiedAt,
+	})
+}
+
+// HandleListOIDCProviders is a wrapper around the service ListOIDCProviders method
+func HandleListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListOIDCProviders(w, r)
+}
+
+// HandleStartOIDCLogin is a wrapper around the service StartOIDCLogin method
+func HandleStartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.StartOIDCLogin(w, r)
+}
+
+// HandleFinishOIDCLogin is a wrapper around the service FinishOIDCLogin method
+func HandleFinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.FinishOIDCLogin(w, r)
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/oidc"
+	"github.com/danielsaas/generic-saas/internal/oidc/oidctest"
+)
+
+// setupOIDC returns a service with a fake provider named "fake"
+func setupOIDC(t *testing.T) (*Service, database.Database, *oidctest.Issuer, *recordingEmailService) {
+	t.Helper()
+
+	service, db, emails := setupFullTestService(t)
+	issuer := oidctest.NewIssuer("client-1", "secret-1")
+	t.Cleanup(issuer.Close)
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Name:         "fake",
+		DisplayName:  "Fake",
+		Issuer:       issuer.URL,
+		ClientID:     "client-1",
+		ClientSecret: "secret-1",
+		RedirectURL:  "https://app.example.com/auth/oidc/fake/callback",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	service.SetOIDCProviders([]*oidc.Provider{provider})
+	return service, db, issuer, emails
+}
+
+// serveOIDC routes a request through the OIDC endpoints
+func serveOIDC(service *Service, method, path, body string) *httptest.ResponseRecorder {
+	return serveRoutes(service, httptest.NewRequest(method, path, strings.NewReader(body)))
+}
+
+// authorizeOIDC starts a login and has the fake provider consent to it
+func authorizeOIDC(t *testing.T, service *Service, issuer *oidctest.Issuer) OIDCCallbackRequest {
+	t.Helper()
+
+	rr := serveOIDC(service, "POST", "/auth/oidc/fake/start", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Start failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var start OIDCStartResponse
+	json.NewDecoder(rr.Body).Decode(&start)
+
+	code, state, err := issuer.Authorize(start.AuthorizationURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if state != start.State {
+		t.Fatalf("Expected state %q to round trip, got %q", start.State, state)
+	}
+	return OIDCCallbackRequest{Code: code, State: state}
+}
+
+func finishOIDC(service *Service, callback OIDCCallbackRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(callback)
+	return serveOIDC(service, "POST", "/auth/oidc/fake/callback", string(body))
+}
+
+func TestOIDC_ListProviders(t *testing.T) {
+	service, _, _, _ := setupOIDC(t)
+
+	rr := serveOIDC(service, "GET", "/auth/oidc/providers", "")
+	var response OIDCProvidersResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if len(response.Providers) != 1 || response.Providers[0].Name != "fake" || response.Providers[0].DisplayName != "Fake" {
+		t.Errorf("Unexpected providers: %s", rr.Body.String())
+	}
+
+	if rr := serveOIDC(service, "POST", "/auth/oidc/unknown/start", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown provider to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestOIDC_FirstLoginCreatesUser(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "new@example.com", EmailVerified: true, Name: "New User"})
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Token == "" || response.User.Email != "new@example.com" || response.User.Name != "New User" {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	if !response.User.EmailVerified() {
+		t.Error("Expected the provider's verified email to count as verified")
+	}
+
+	// The link is by subject, so a changed email still reaches the same user
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "renamed@example.com", EmailVerified: false})
+	rr = finishOIDC(service, authorizeOIDC(t, service, issuer))
+	var again AuthResponse
+	json.NewDecoder(rr.Body).Decode(&again)
+	if rr.Code != http.StatusOK || again.User.ID != response.User.ID {
+		t.Errorf("Expected the linked user to sign in again, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	identities, _ := db.OIDCIdentities().ListUserOIDCIdentities(context.Background(), response.User.ID)
+	if len(identities) != 1 || identities[0].Email != "renamed@example.com" || identities[0].LastLoginAt == nil {
+		t.Errorf("Unexpected identities: %+v", identities)
+	}
+}
+
+func TestOIDC_ClosedRegistrationCreatesNoUser(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	service.SetOpenRegistration(false)
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeRegistrationClosed) {
+		t.Errorf("Expected registration to be closed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Users().GetUserByEmail(context.Background(), "new@example.com"); err == nil {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func verifyJohn(t *testing.T, db database.Database) {
+	t.Helper()
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	verifiedAt := time.Now()
+	user.EmailVerifiedAt = &verifiedAt
+	if _, err := db.Users().UpdateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to verify John: %v", err)
+	}
+}
+
+func TestOIDC_LinksExistingUserByVerifiedEmail(t *testing.T) {
+	service, db, issuer, emails := setupOIDC(t)
+	login := loginTestUser(t, service, db)
+	verifyJohn(t, db)
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.User.ID != login.User.ID {
+		t.Errorf("Expected to sign in as the existing user %d, got %d", login.User.ID, response.User.ID)
+	}
+	if len(emails.alerts) != 1 {
+		t.Errorf("Expected a security alert about the new link, got %v", emails.alerts)
+	}
+
+	// The account was the user's own, so their password and sessions stay
+	loginAgain(t, service)
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", login.Token); rr.Code != http.StatusOK {
+		t.Errorf("Expected the existing session to keep working, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDC_ReclaimsUnverifiedAccount(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	ctx := context.Background()
+
+	// Someone registered the address without owning it and set up a second factor
+	squatter := loginTestUser(t, service, db)
+	enableTOTP(t, service, db, squatter.Token)
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the owner to be signed in without a challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.User.ID != squatter.User.ID || !response.User.EmailVerified() {
+		t.Errorf("Expected the owner to take over the verified account, got %+v", response.User)
+	}
+
+	user, _ := db.Users().GetUserByID(ctx, squatter.User.ID)
+	if user.Password != "" || user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Error("Expected the squatter's password and two-factor setup to be removed")
+	}
+	if count, _ := db.RecoveryCodes().CountUnusedRecoveryCodes(ctx, user.ID); count != 0 {
+		t.Errorf("Expected the squatter's recovery codes to be removed, got %d", count)
+	}
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", squatter.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the squatter's session to be revoked, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email": "john@example.com", "password": "password123"}`))
+	rr = httptest.NewRecorder()
+	service.Login(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the squatter's password to stop working, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDC_UnverifiedEmailIsNotLinked(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	loginTestUser(t, service, db)
+	issuer.SetIdentity(oidctest.Identity{Subject: "attacker", Email: "john@example.com", EmailVerified: false})
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeOIDCEmailUnverified) {
+		t.Errorf("Expected unverified email to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if _, err := db.OIDCIdentities().GetOIDCIdentity(context.Background(), "fake", "attacker"); err == nil {
+		t.Error("Expected no identity to be linked")
+	}
+}
+
+func TestOIDC_StateIsSingleUse(t *testing.T) {
+	service, _, issuer, _ := setupOIDC(t)
+	callback := authorizeOIDC(t, service, issuer)
+
+	if rr := finishOIDC(service, callback); rr.Code != http.StatusOK {
+		t.Fatalf("Expected first callback to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := finishOIDC(service, callback)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeOIDCStateInvalid) {
+		t.Errorf("Expected replayed state to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = finishOIDC(service, OIDCCallbackRequest{Code: "code", State: "made-up"})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeOIDCStateInvalid) {
+		t.Errorf("Expected unknown state to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDC_BadCodeFails(t *testing.T) {
+	service, _, issuer, _ := setupOIDC(t)
+	callback := authorizeOIDC(t, service, issuer)
+	callback.Code = "forged"
+
+	rr := finishOIDC(service, callback)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodeOIDCLoginFailed) {
+		t.Errorf("Expected forged code to fail, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDC_TwoFactorStillRequired(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	login := loginTestUser(t, service, db)
+	verifyJohn(t, db)
+	enableTOTP(t, service, db, login.Token)
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	var challenge MFAChallengeResponse
+	json.NewDecoder(rr.Body).Decode(&challenge)
+	if rr.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Errorf("Expected an MFA challenge, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestOIDC_PasswordResetStillRequired(t *testing.T) {
+	service, db, issuer, _ := setupOIDC(t)
+	loginTestUser(t, service, db)
+	verifyJohn(t, db)
+	requirePasswordReset(t, db)
+
+	rr := finishOIDC(service, authorizeOIDC(t, service, issuer))
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodePasswordResetRequired) {
+		t.Errorf("Expected a forced reset to stop the sign-in, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
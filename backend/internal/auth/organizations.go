@@ -0,0 +1,693 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// CodeLastOwner is returned when a change would leave an organization
+// without an owner
+const CodeLastOwner = "last_owner"
+
+// maxOrganizationNameLength caps the length of an organization's name
+const maxOrganizationNameLength = 100
+
+// OrganizationRequest is the body of POST /api/organizations and
+// PUT /api/organizations/{id}
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// OrganizationsResponse lists the organizations a user belongs to
+type OrganizationsResponse struct {
+	Organizations []*database.UserOrganization `json:"organizations"`
+}
+
+// MembersResponse lists the members of an organization
+type MembersResponse struct {
+	Members []*database.OrganizationMember `json:"members"`
+}
+
+// UpdateMemberRequest is the body of PUT /api/organizations/{id}/members/{user_id}
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// TransferOrganizationRequest is the body of POST /api/organizations/{id}/transfer
+type TransferOrganizationRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// SwitchOrganizationRequest is the body of PUT /api/user/organization. An
+// organization ID of 0 leaves the session without one.
+type SwitchOrganizationRequest struct {
+	OrganizationID int `json:"organization_id"`
+}
+
+// ListOrganizations returns the organizations the authenticated user
+// belongs to and their role in each
+func (s *Service) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orgs, err := s.db.Organizations().ListUserOrganizations(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, OrganizationsResponse{Organizations: orgs}, http.StatusOK)
+}
+
+// CreateOrganization creates an organization owned by the authenticated user
+func (s *Service) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, err := s.db.Organizations().CreateOrganization(r.Context(), &database.Organization{Name: name}, userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, database.UserOrganization{Organization: *org, Role: database.OrgRoleOwner}, http.StatusCreated)
+}
+
+// GetOrganization returns the organization named in the path and the
+// authenticated user's role in it
+func (s *Service) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, membership, ok := s.pathOrganization(w, r, database.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	writeJSONResponse(w, database.UserOrganization{Organization: *org, Role: membership.Role}, http.StatusOK)
+}
+
+// UpdateOrganization renames the organization named in the path. It needs
+// the admin role in the organization.
+func (s *Service) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	org, membership, ok := s.pathOrganization(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+
+	org.Name = name
+	org, err = s.db.Organizations().UpdateOrganization(r.Context(), org)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, database.UserOrganization{Organization: *org, Role: membership.Role}, http.StatusOK)
+}
+
+// DeleteOrganization deletes the organization named in the path with its
+// memberships. It needs the owner role in the organization.
+func (s *Service) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	if err := s.db.Organizations().DeleteOrganization(r.Context(), org.ID); err != nil && !errors.Is(err, database.ErrOrganizationNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListOrganizationMembers returns the members of the organization named in
+// the path, longest standing first
+func (s *Service) ListOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleMember)
+	if !ok {
+		return
+	}
+
+	members, err := s.db.Organizations().ListMembers(r.Context(), org.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, MembersResponse{Members: members}, http.StatusOK)
+}
+
+// UpdateOrganizationMember changes the role of a member. Admins can make
+// members admins and back. Only owners can make someone an owner or change
+// an owner's role, and the last owner keeps theirs.
+func (s *Service) UpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !database.ValidOrgRole(req.Role) {
+		writeErrorResponse(w, "Role must be owner, admin or member", http.StatusBadRequest)
+		return
+	}
+
+	org, actor, ok := s.pathOrganization(w, r, database.OrgRoleAdmin)
+	if !ok {
+		return
+	}
+	member, ok := s.pathMember(w, r, org.ID)
+	if !ok {
+		return
+	}
+
+	if (req.Role == database.OrgRoleOwner || member.Role == database.OrgRoleOwner) && actor.Role != database.OrgRoleOwner {
+		writeOrganizationRoleRequired(w, database.OrgRoleOwner)
+		return
+	}
+	if member.Role == database.OrgRoleOwner && req.Role != database.OrgRoleOwner && !s.keepsAnOwner(w, r, org.ID) {
+		return
+	}
+
+	if err := s.db.Organizations().UpdateMemberRole(r.Context(), org.ID, member.UserID, req.Role); err != nil {
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	member.Role = req.Role
+	writeJSONResponse(w, member, http.StatusOK)
+}
+
+// RemoveOrganizationMember takes a member out of the organization. Anyone
+// can leave, admins can remove members and admins, and only owners can
+// remove owners. The last owner can't leave.
+func (s *Service) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, actor, ok := s.pathOrganization(w, r, database.OrgRoleMember)
+	if !ok {
+		return
+	}
+	member, ok := s.pathMember(w, r, org.ID)
+	if !ok {
+		return
+	}
+
+	if member.UserID != actor.UserID {
+		required := database.OrgRoleAdmin
+		if member.Role == database.OrgRoleOwner {
+			required = database.OrgRoleOwner
+		}
+		if !database.OrgRoleAtLeast(actor.Role, required) {
+			writeOrganizationRoleRequired(w, required)
+			return
+		}
+	}
+	if member.Role == database.OrgRoleOwner && !s.keepsAnOwner(w, r, org.ID) {
+		return
+	}
+
+	if err := s.db.Organizations().RemoveMember(r.Context(), org.ID, member.UserID); err != nil && !errors.Is(err, database.ErrMembershipNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TransferOrganization makes another member the owner of the organization
+// named in the path. The owner handing it over becomes an admin.
+func (s *Service) TransferOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	org, actor, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	if req.UserID == actor.UserID {
+		writeCodedErrorResponse(w, "You already own this organization", CodeCannotTargetSelf, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if err := s.db.Organizations().TransferOwnership(ctx, org.ID, actor.UserID, req.UserID); err != nil {
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	members, err := s.db.Organizations().ListMembers(ctx, org.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, MembersResponse{Members: members}, http.StatusOK)
+}
+
+// CurrentOrganization returns the organization the request works in, as
+// chosen by the X-Organization-ID header or the access token. It must run
+// inside middleware.RequireOrganization.
+func (s *Service) CurrentOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	membership, ok := middleware.MembershipFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	org, err := s.db.Organizations().GetOrganization(r.Context(), membership.OrganizationID)
+	if err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, database.UserOrganization{Organization: *org, Role: membership.Role}, http.StatusOK)
+}
+
+// SwitchOrganization records the organization the current session works
+// in and returns an access token carrying it. Refreshed tokens keep it.
+func (s *Service) SwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SwitchOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	sessionID, ok := middleware.SessionIDFromContext(ctx)
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if req.OrganizationID != 0 {
+		_, err := s.db.Organizations().GetMembership(ctx, req.OrganizationID, user.ID)
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.db.Sessions().SetSessionOrganization(ctx, sessionID, req.OrganizationID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	session, err := s.db.Sessions().GetSession(ctx, sessionID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := s.issueAccessToken(user, session)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSession(w, &AuthResponse{
+		Token:     accessToken,
+		TokenType: "Bearer",
+		ExpiresIn: int(s.tokens.TTL().Seconds()),
+		User:      *user,
+		sessionID: session.ID,
+	}, http.StatusOK)
+}
+
+// pathOrganization loads the organization whose ID is in the path and the
+// authenticated user's membership of it. Organizations the user doesn't
+// belong to are reported as not found, and a role weaker than minRole is
+// refused.
+func (s *Service) pathOrganization(w http.ResponseWriter, r *http.Request, minRole string) (*database.Organization, *database.Membership, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	ctx := r.Context()
+	membership, err := s.db.Organizations().GetMembership(ctx, id, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return nil, nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if !database.OrgRoleAtLeast(membership.Role, minRole) {
+		writeOrganizationRoleRequired(w, minRole)
+		return nil, nil, false
+	}
+
+	org, err := s.db.Organizations().GetOrganization(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return nil, nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	return org, membership, true
+}
+
+// pathMember loads the membership of the user whose ID is in the path
+func (s *Service) pathMember(w http.ResponseWriter, r *http.Request, organizationID int) (*database.Membership, bool) {
+	userID, err := strconv.Atoi(r.PathValue("user_id"))
+	if err != nil {
+		writeErrorResponse(w, "Member not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	membership, err := s.db.Organizations().GetMembership(r.Context(), organizationID, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			writeErrorResponse(w, "Member not found", http.StatusNotFound)
+			return nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return membership, true
+}
+
+// keepsAnOwner checks the organization has another owner before one stops
+// being an owner. It writes the response and returns false if not.
+func (s *Service) keepsAnOwner(w http.ResponseWriter, r *http.Request, organizationID int) bool {
+	owners, err := s.db.Organizations().CountMembers(r.Context(), organizationID, database.OrgRoleOwner)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if owners <= 1 {
+		writeCodedErrorResponse(w, "The last owner can't leave the organization. Transfer it to another member first.", CodeLastOwner, http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// lastOwnedOrganization returns an organization the user is the last owner
+// of while others still belong to it, or nil if there is none
+func (s *Service) lastOwnedOrganization(ctx context.Context, userID int) (*database.UserOrganization, error) {
+	orgs, err := s.db.Organizations().ListUserOrganizations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range orgs {
+		if org.Role != database.OrgRoleOwner {
+			continue
+		}
+		owners, err := s.db.Organizations().CountMembers(ctx, org.ID, database.OrgRoleOwner)
+		if err != nil {
+			return nil, err
+		}
+		members, err := s.db.Organizations().CountMembers(ctx, org.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		if owners == 1 && members > 1 {
+			return org, nil
+		}
+	}
+	return nil, nil
+}
+
+// handOverOrganizations keeps the organizations of a user being deleted
+// owned. One they are the only member of is deleted with them. One they
+// are the last owner of goes to its longest-standing admin, or to its
+// longest-standing member if it has no admin.
+func (s *Service) handOverOrganizations(ctx context.Context, userID int) error {
+	orgs, err := s.db.Organizations().ListUserOrganizations(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if org.Role != database.OrgRoleOwner {
+			continue
+		}
+		members, err := s.db.Organizations().ListMembers(ctx, org.ID)
+		if err != nil {
+			return err
+		}
+
+		var successor *database.OrganizationMember
+		for _, member := range members {
+			if member.UserID == userID {
+				continue
+			}
+			if member.Role == database.OrgRoleOwner {
+				successor = nil
+				break
+			}
+			if successor == nil || (member.Role == database.OrgRoleAdmin && successor.Role != database.OrgRoleAdmin) {
+				successor = member
+			}
+		}
+
+		switch {
+		case len(members) <= 1:
+			err = s.db.Organizations().DeleteOrganization(ctx, org.ID)
+		case successor != nil:
+			err = s.db.Organizations().UpdateMemberRole(ctx, org.ID, successor.UserID, database.OrgRoleOwner)
+		}
+		if err != nil && !errors.Is(err, database.ErrOrganizationNotFound) && !errors.Is(err, database.ErrMembershipNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOrganizationName trims a name and checks it fits
+func validateOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", &ValidationError{"Name is required"}
+	}
+	if len(name) > maxOrganizationNameLength {
+		return "", &ValidationError{"Name must be at most " + strconv.Itoa(maxOrganizationNameLength) + " characters"}
+	}
+	return name, nil
+}
+
+// writeOrganizationRoleRequired refuses a request the user's role in the
+// organization is too weak for
+func writeOrganizationRoleRequired(w http.ResponseWriter, role string) {
+	writeCodedErrorResponse(w, "The organization "+role+" role is required", middleware.AuthCodeOrganizationRoleRequired, http.StatusForbidden)
+}
+
+// HandleListOrganizations is a wrapper around the service ListOrganizations method
+func HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListOrganizations(w, r)
+}
+
+// HandleCreateOrganization is a wrapper around the service CreateOrganization method
+func HandleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateOrganization(w, r)
+}
+
+// HandleGetOrganization is a wrapper around the service GetOrganization method
+func HandleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.GetOrganization(w, r)
+}
+
+// HandleUpdateOrganization is a wrapper around the service UpdateOrganization method
+func HandleUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.UpdateOrganization(w, r)
+}
+
+// HandleDeleteOrganization is a wrapper around the service DeleteOrganization method
+func HandleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteOrganization(w, r)
+}
+
+// HandleListOrganizationMembers is a wrapper around the service ListOrganizationMembers method
+func HandleListOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListOrganizationMembers(w, r)
+}
+
+// HandleUpdateOrganizationMember is a wrapper around the service UpdateOrganizationMember method
+func HandleUpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.UpdateOrganizationMember(w, r)
+}
+
+// HandleRemoveOrganizationMember is a wrapper around the service RemoveOrganizationMember method
+func HandleRemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RemoveOrganizationMember(w, r)
+}
+
+// HandleTransferOrganization is a wrapper around the service TransferOrganization method
+func HandleTransferOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.TransferOrganization(w, r)
+}
+
+// HandleCurrentOrganization is a wrapper around the service CurrentOrganization method
+func HandleCurrentOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CurrentOrganization(w, r)
+}
+
+// HandleSwitchOrganization is a wrapper around the service SwitchOrganization method
+func HandleSwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.SwitchOrganization(w, r)
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// setupOrganization creates Acme owned by John with Jane as a member and
+// returns it with John's and Jane's sessions
+func setupOrganization(t *testing.T) (*Service, database.Database, *database.UserOrganization, AuthResponse, AuthResponse) {
+	t.Helper()
+
+	service, db, _ := setupFullTestService(t)
+	john := loginTestUser(t, service, db)
+	jane := createJane(t, db)
+
+	rr := serveAPI(service, "POST", "/api/organizations", `{"name": " Acme "}`, john.Token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var org database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&org)
+	if org.Name != "Acme" || org.Role != database.OrgRoleOwner {
+		t.Fatalf("Expected John to own Acme, got %+v", org)
+	}
+
+	if _, err := db.Organizations().AddMember(context.Background(), org.ID, jane.ID, database.OrgRoleMember); err != nil {
+		t.Fatalf("Failed to add Jane: %v", err)
+	}
+
+	rr = postLogin(service, "jane@example.com", "password123", "192.0.2.1:1234")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	var janeSession AuthResponse
+	json.NewDecoder(rr.Body).Decode(&janeSession)
+
+	return service, db, &org, john, janeSession
+}
+
+func organizationPath(org *database.UserOrganization, suffix string) string {
+	return "/api/organizations/" + strconv.Itoa(org.ID) + suffix
+}
+
+func TestOrganizations(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+
+	if rr := serveAPI(service, "POST", "/api/organizations", `{"name": "  "}`, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a blank name to be rejected, got %d", rr.Code)
+	}
+	long := `{"name": "` + strings.Repeat("a", maxOrganizationNameLength+1) + `"}`
+	if rr := serveAPI(service, "POST", "/api/organizations", long, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a long name to be rejected, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "GET", "/api/organizations", "", jane.Token)
+	var list OrganizationsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list.Organizations) != 1 || list.Organizations[0].Role != database.OrgRoleMember {
+		t.Fatalf("Expected Jane to be a member of Acme, got %d: %+v", rr.Code, list.Organizations)
+	}
+
+	// Members can read but not rename
+	if rr := serveAPI(service, "GET", organizationPath(org, ""), "", jane.Token); rr.Code != http.StatusOK {
+		t.Errorf("Expected Jane to read Acme, got %d", rr.Code)
+	}
+	rr = serveAPI(service, "PUT", organizationPath(org, ""), `{"name": "Jane Inc"}`, jane.Token)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), middleware.AuthCodeOrganizationRoleRequired) {
+		t.Errorf("Expected Jane not to rename Acme, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = serveAPI(service, "PUT", organizationPath(org, ""), `{"name": "Acme Ltd"}`, john.Token)
+	var renamed database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&renamed)
+	if rr.Code != http.StatusOK || renamed.Name != "Acme Ltd" {
+		t.Errorf("Expected John to rename Acme, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(service, "GET", organizationPath(org, "/members"), "", jane.Token)
+	var members MembersResponse
+	json.NewDecoder(rr.Body).Decode(&members)
+	if rr.Code != http.StatusOK || len(members.Members) != 2 || members.Members[0].Email != "john@example.com" {
+		t.Errorf("Expected John then Jane, got %d: %+v", rr.Code, members.Members)
+	}
+
+	// Outsiders can't tell the organization exists
+	other, _ := db.Organizations().CreateOrganization(context.Background(), &database.Organization{Name: "Other"}, jane.User.ID)
+	if rr := serveAPI(service, "GET", "/api/organizations/"+strconv.Itoa(other.ID), "", john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected another organization to be hidden, got %d", rr.Code)
+	}
+
+	if rr := serveAPI(service, "DELETE", organizationPath(org, ""), "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to delete Acme, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "DELETE", organizationPath(org, ""), "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected John to delete Acme, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.Organizations().GetOrganization(context.Background(), org.ID); !errors.Is(err, database.ErrOrganizationNotFound) {
+		t.Errorf("Expected Acme to be deleted, got %v", err)
+	}
+}
+
+func TestOrganizationMembers_Roles(t *testing.T) {
+	service, _, org, john, jane := setupOrganization(t)
+	janePath := organizationPath(org, "/members/"+strconv.Itoa(jane.User.ID))
+	johnPath := organizationPath(org, "/members/"+strconv.Itoa(john.User.ID))
+
+	if rr := serveAPI(service, "PUT", janePath, `{"role": "boss"}`, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown role to be rejected, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "PUT", janePath, `{"role": "admin"}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to promote herself, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "PUT", janePath, `{"role": "admin"}`, john.Token)
+	var member database.Membership
+	json.NewDecoder(rr.Body).Decode(&member)
+	if rr.Code != http.StatusOK || member.Role != database.OrgRoleAdmin {
+		t.Fatalf("Expected Jane to be made an admin, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Admins can't touch owners or make new ones
+	if rr := serveAPI(service, "PUT", janePath, `{"role": "owner"}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to make herself owner, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "PUT", johnPath, `{"role": "member"}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to demote John, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "DELETE", johnPath, "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to remove John, got %d", rr.Code)
+	}
+
+	// The last owner keeps the role and can't leave
+	for _, rr := range []*httptest.ResponseRecorder{
+		serveAPI(service, "PUT", johnPath, `{"role": "admin"}`, john.Token),
+		serveAPI(service, "DELETE", johnPath, "", john.Token),
+	} {
+		if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeLastOwner) {
+			t.Errorf("Expected the last owner to stay, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// A second owner lets the first step down
+	if rr := serveAPI(service, "PUT", janePath, `{"role": "owner"}`, john.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected John to make Jane an owner, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", johnPath, "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected John to leave, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "GET", organizationPath(org, ""), "", john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected John to have left, got %d", rr.Code)
+	}
+}
+
+func TestTransferOrganization(t *testing.T) {
+	service, _, org, john, jane := setupOrganization(t)
+
+	if rr := serveAPI(service, "POST", organizationPath(org, "/transfer"), `{"user_id": `+strconv.Itoa(john.User.ID)+`}`, jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected Jane not to take Acme over, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "POST", organizationPath(org, "/transfer"), `{"user_id": `+strconv.Itoa(john.User.ID)+`}`, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected John not to transfer to himself, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "POST", organizationPath(org, "/transfer"), `{"user_id": 999}`, john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a transfer to a non-member to fail, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "POST", organizationPath(org, "/transfer"), `{"user_id": `+strconv.Itoa(jane.User.ID)+`}`, john.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	roles := map[int]string{}
+	var members MembersResponse
+	json.NewDecoder(rr.Body).Decode(&members)
+	for _, member := range members.Members {
+		roles[member.UserID] = member.Role
+	}
+	if roles[jane.User.ID] != database.OrgRoleOwner || roles[john.User.ID] != database.OrgRoleAdmin {
+		t.Errorf("Expected Jane to own Acme and John to be an admin, got %v", roles)
+	}
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+
+	rr := serveAPI(service, "GET", "/api/user/organization", "", john.Token)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), middleware.AuthCodeOrganizationRequired) {
+		t.Errorf("Expected no organization to be selected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Only organizations the user belongs to can be selected
+	other, _ := db.Organizations().CreateOrganization(context.Background(), &database.Organization{Name: "Other"}, jane.User.ID)
+	if rr := serveAPI(service, "PUT", "/api/user/organization", `{"organization_id": `+strconv.Itoa(other.ID)+`}`, john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected John not to switch to Other, got %d", rr.Code)
+	}
+
+	rr = serveAPI(service, "PUT", "/api/user/organization", `{"organization_id": `+strconv.Itoa(org.ID)+`}`, john.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var switched AuthResponse
+	json.NewDecoder(rr.Body).Decode(&switched)
+	claims, err := service.tokens.Verify(switched.Token)
+	if err != nil || claims.OrganizationID != org.ID {
+		t.Fatalf("Expected the token to carry organization %d, got %+v (%v)", org.ID, claims, err)
+	}
+
+	rr = serveAPI(service, "GET", "/api/user/organization", "", switched.Token)
+	var current database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&current)
+	if rr.Code != http.StatusOK || current.ID != org.ID || current.Role != database.OrgRoleOwner {
+		t.Errorf("Expected Acme to be selected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Refreshing keeps the organization
+	rr = postRefreshToken(service, service.Refresh, john.RefreshToken)
+	var refreshed AuthResponse
+	json.NewDecoder(rr.Body).Decode(&refreshed)
+	if claims, err := service.tokens.Verify(refreshed.Token); err != nil || claims.OrganizationID != org.ID {
+		t.Errorf("Expected the refreshed token to carry organization %d, got %+v (%v)", org.ID, claims, err)
+	}
+
+	// Leaving the organization stops the old token working for it
+	db.Organizations().RemoveMember(context.Background(), org.ID, john.User.ID)
+	if rr := serveAPI(service, "GET", "/api/user/organization", "", refreshed.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a former member to be refused, got %d", rr.Code)
+	}
+}
+
+func TestDeleteAccount_LastOwner(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+
+	rr := serveAPI(service, "DELETE", "/api/user/account", `{"password": "password123"}`, john.Token)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeLastOwner) {
+		t.Fatalf("Expected the last owner not to delete their account, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// John's own organization goes with him, and Acme goes to Jane
+	solo, _ := db.Organizations().CreateOrganization(context.Background(), &database.Organization{Name: "Solo"}, john.User.ID)
+	user, _ := db.Users().GetUserByID(context.Background(), john.User.ID)
+	if err := service.purgeUser(context.Background(), user); err != nil {
+		t.Fatalf("purgeUser() error = %v", err)
+	}
+
+	if _, err := db.Organizations().GetOrganization(context.Background(), solo.ID); !errors.Is(err, database.ErrOrganizationNotFound) {
+		t.Errorf("Expected Solo to be deleted, got %v", err)
+	}
+	membership, err := db.Organizations().GetMembership(context.Background(), org.ID, jane.User.ID)
+	if err != nil || membership.Role != database.OrgRoleOwner {
+		t.Errorf("Expected Jane to own Acme, got %+v (%v)", membership, err)
+	}
+}
@@ -0,0 +1,553 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/token"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
+)
+
+// AuthMethodPasskey is recorded on sessions started with a passkey
+const AuthMethodPasskey = "passkey"
+
+// Error codes returned by the passkey endpoints
+const (
+	CodeCeremonyTokenInvalid = "ceremony_token_invalid"
+	CodePasskeyInvalid       = "passkey_invalid"
+	CodePasskeyCloned        = "passkey_sign_count_regression"
+)
+
+const (
+	// passkeyCeremonyTTL is how long the browser has to complete a ceremony
+	passkeyCeremonyTTL = 5 * time.Minute
+
+	// maxPasskeyNameLength caps the label users give their passkeys
+	maxPasskeyNameLength = 64
+)
+
+// PasskeyInfo describes one of the user's registered passkeys
+type PasskeyInfo struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Transports     []string   `json:"transports"`
+	BackupEligible bool       `json:"backup_eligible"`
+	BackupState    bool       `json:"backup_state"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// PasskeysResponse is the body of GET /api/user/passkeys
+type PasskeysResponse struct {
+	Passkeys []PasskeyInfo `json:"passkeys"`
+}
+
+// PasskeyRegistrationOptions is returned when a registration ceremony starts.
+// Options is passed to navigator.credentials.create().
+type PasskeyRegistrationOptions struct {
+	CeremonyToken string                   `json:"ceremony_token"`
+	Options       webauthn.CreationOptions `json:"options"`
+}
+
+// PasskeyRegistrationRequest is the body of the registration finish endpoint
+type PasskeyRegistrationRequest struct {
+	CeremonyToken string                        `json:"ceremony_token"`
+	Name          string                        `json:"name"`
+	Credential    *webauthn.AttestationResponse `json:"credential"`
+}
+
+// PasskeyLoginBeginRequest is the optional body of the login begin endpoint.
+// With an email the options list that user's passkeys; without one the
+// browser offers any discoverable passkey for this site.
+type PasskeyLoginBeginRequest struct {
+	Email string `json:"email"`
+}
+
+// PasskeyLoginOptions is returned when a login ceremony starts. Options is
+// passed to navigator.credentials.get().
+type PasskeyLoginOptions struct {
+	CeremonyToken string                  `json:"ceremony_token"`
+	Options       webauthn.RequestOptions `json:"options"`
+}
+
+// PasskeyLoginRequest is the body of the login finish endpoint
+type PasskeyLoginRequest struct {
+	CeremonyToken string                      `json:"ceremony_token"`
+	Credential    *webauthn.AssertionResponse `json:"credential"`
+}
+
+// SetRelyingParty sets the WebAuthn relying party. Passkeys are unavailable
+// until it is set.
+func (s *Service) SetRelyingParty(rp *webauthn.RelyingParty) {
+	s.relyingParty = rp
+}
+
+// issueCeremonyToken signs the token that carries a ceremony's challenge
+// between the begin and finish requests. subject may be empty.
+func (s *Service) issueCeremonyToken(tokenType, subject string, challenge []byte) (string, error) {
+	return s.tokens.Issue(token.Claims{
+		Subject:   subject,
+		Type:      tokenType,
+		Nonce:     webauthn.EncodeBase64URL(challenge),
+		ExpiresAt: time.Now().Add(passkeyCeremonyTTL).Unix(),
+	})
+}
+
+// verifyCeremonyToken checks a ceremony token and returns its claims and
+// challenge, writing an error response if it is unusable
+func (s *Service) verifyCeremonyToken(w http.ResponseWriter, raw, tokenType string) (*token.Claims, []byte, bool) {
+	claims, err := s.tokens.VerifyType(raw, tokenType)
+	if err != nil || !s.mfaAttempts.allow(claims.ID) {
+		writeCodedErrorResponse(w, "Invalid or expired ceremony token", CodeCeremonyTokenInvalid, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	challenge, err := webauthn.DecodeBase64URL(claims.Nonce)
+	if err != nil || len(challenge) == 0 {
+		writeCodedErrorResponse(w, "Invalid or expired ceremony token", CodeCeremonyTokenInvalid, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	return claims, challenge, true
+}
+
+// passkeysEnabled writes an error response if no relying party is configured
+func (s *Service) passkeysEnabled(w http.ResponseWriter) bool {
+	if s.relyingParty == nil {
+		writeErrorResponse(w, "Passkeys are not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// BeginPasskeyRegistration returns creation options for a new passkey on
+// the authenticated user's account
+func (s *Service) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.passkeysEnabled(w) {
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := s.db.WebAuthnCredentials().ListUserWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ceremonyToken, err := s.issueCeremonyToken(token.TypeWebAuthnRegistration, strconv.Itoa(user.ID), challenge)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The user handle is the user ID, so a login can find the account
+	// from the passkey alone
+	options := s.relyingParty.CreationOptions(webauthn.User{
+		Handle:      []byte(strconv.Itoa(user.ID)),
+		Name:        user.Email,
+		DisplayName: user.Name,
+	}, challenge, credentialDescriptors(existing))
+
+	writeJSONResponse(w, PasskeyRegistrationOptions{
+		CeremonyToken: ceremonyToken,
+		Options:       options,
+	}, http.StatusOK)
+}
+
+// FinishPasskeyRegistration verifies the authenticator's attestation and
+// stores the new passkey
+func (s *Service) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.passkeysEnabled(w) {
+		return
+	}
+
+	var req PasskeyRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CeremonyToken == "" || req.Credential == nil {
+		writeErrorResponse(w, "Ceremony token and credential are required", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameLength {
+		writeErrorResponse(w, "Passkey name is too long", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	claims, challenge, ok := s.verifyCeremonyToken(w, req.CeremonyToken, token.TypeWebAuthnRegistration)
+	if !ok {
+		return
+	}
+	if claims.Subject != strconv.Itoa(user.ID) {
+		writeCodedErrorResponse(w, "Invalid or expired ceremony token", CodeCeremonyTokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	// A challenge is good for one attempt at registration
+	s.mfaAttempts.burn(claims.ID, time.Unix(claims.ExpiresAt, 0))
+
+	credential, err := s.relyingParty.VerifyRegistration(req.Credential, challenge)
+	if err != nil {
+		writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusBadRequest)
+		return
+	}
+
+	stored, err := s.db.WebAuthnCredentials().CreateWebAuthnCredential(r.Context(), &database.WebAuthnCredential{
+		UserID:          user.ID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		Algorithm:       credential.Algorithm,
+		SignCount:       credential.SignCount,
+		AAGUID:          credential.AAGUID,
+		AttestationType: credential.AttestationType,
+		Transports:      credential.Transports,
+		Name:            name,
+		BackupEligible:  credential.BackupEligible,
+		BackupState:     credential.BackupState,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrWebAuthnCredentialExists) {
+			writeErrorResponse(w, "Passkey is already registered", http.StatusConflict)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "A passkey named \""+name+"\" was added to your account.")
+
+	writeJSONResponse(w, passkeyInfo(stored), http.StatusCreated)
+}
+
+// ListPasskeys returns the authenticated user's passkeys
+func (s *Service) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := s.db.WebAuthnCredentials().ListUserWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := PasskeysResponse{Passkeys: []PasskeyInfo{}}
+	for _, credential := range credentials {
+		response.Passkeys = append(response.Passkeys, passkeyInfo(credential))
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// DeletePasskey removes one of the authenticated user's passkeys
+func (s *Service) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.WebAuthnCredentials().DeleteWebAuthnCredential(r.Context(), user.ID, id); err != nil {
+		if errors.Is(err, database.ErrWebAuthnCredentialNotFound) {
+			writeErrorResponse(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "A passkey was removed from your account.")
+
+	writeJSONResponse(w, map[string]string{"message": "Passkey deleted"}, http.StatusOK)
+}
+
+// BeginPasskeyLogin returns request options for signing in with a passkey
+func (s *Service) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.passkeysEnabled(w) {
+		return
+	}
+
+	var req PasskeyLoginBeginRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var subject string
+	allow := []webauthn.CredentialDescriptor{}
+	if req.Email != "" {
+		user, err := s.db.Users().GetUserByEmail(r.Context(), req.Email)
+		if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Unknown emails get the same discoverable options as no email at all
+		if user != nil {
+			credentials, err := s.db.WebAuthnCredentials().ListUserWebAuthnCredentials(r.Context(), user.ID)
+			if err != nil {
+				writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if len(credentials) > 0 {
+				subject = strconv.Itoa(user.ID)
+				allow = credentialDescriptors(credentials)
+			}
+		}
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ceremonyToken, err := s.issueCeremonyToken(token.TypeWebAuthnLogin, subject, challenge)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, PasskeyLoginOptions{
+		CeremonyToken: ceremonyToken,
+		Options:       s.relyingParty.RequestOptions(challenge, allow),
+	}, http.StatusOK)
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and starts a session. A
+// user-verified passkey is already two factors, so TOTP is not asked for.
+func (s *Service) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.passkeysEnabled(w) {
+		return
+	}
+
+	var req PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CeremonyToken == "" || req.Credential == nil {
+		writeErrorResponse(w, "Ceremony token and credential are required", http.StatusBadRequest)
+		return
+	}
+
+	claims, challenge, ok := s.verifyCeremonyToken(w, req.CeremonyToken, token.TypeWebAuthnLogin)
+	if !ok {
+		return
+	}
+
+	// A challenge is good for one attempt at signing in
+	s.mfaAttempts.burn(claims.ID, time.Unix(claims.ExpiresAt, 0))
+
+	ctx := r.Context()
+	credentialID, err := req.Credential.CredentialID()
+	if err != nil {
+		writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	credential, err := s.db.WebAuthnCredentials().GetWebAuthnCredential(ctx, credentialID)
+	if err != nil {
+		if errors.Is(err, database.ErrWebAuthnCredentialNotFound) {
+			writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusUnauthorized)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The passkey must belong to the user the options were built for, and
+	// to the account its authenticator says it was registered to
+	owner := strconv.Itoa(credential.UserID)
+	userHandle, err := req.Credential.UserHandle()
+	if err != nil || (claims.Subject != "" && claims.Subject != owner) || (userHandle != nil && string(userHandle) != owner) {
+		writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByID(ctx, credential.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusUnauthorized)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := s.relyingParty.VerifyAssertion(req.Credential, challenge, credential.PublicKey, credential.SignCount)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrSignCountRegression) {
+			// A counter that went backwards means the key may have been cloned
+			s.sendSecurityAlert(r, user, "A sign-in was blocked because your passkey \""+credential.Name+"\" may have been copied. Consider removing it.")
+			writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyCloned, http.StatusUnauthorized)
+			return
+		}
+		writeCodedErrorResponse(w, "Passkey could not be verified", CodePasskeyInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	err = s.db.WebAuthnCredentials().UpdateWebAuthnCredentialUsage(ctx, credential.ID, result.SignCount, result.BackupState, time.Now())
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.startSession(w, r, user, AuthMethodPasskey)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// credentialDescriptors lists stored credentials for an allow or exclude list
+func credentialDescriptors(credentials []*database.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         webauthn.EncodeBase64URL(credential.CredentialID),
+			Transports: credential.Transports,
+		}
+	}
+	return descriptors
+}
+
+// passkeyInfo converts a stored credential to its API form
+func passkeyInfo(credential *database.WebAuthnCredential) PasskeyInfo {
+	transports := credential.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	return PasskeyInfo{
+		ID:             credential.ID,
+		Name:           credential.Name,
+		Transports:     transports,
+		BackupEligible: credential.BackupEligible,
+		BackupState:    credential.BackupState,
+		CreatedAt:      credential.CreatedAt,
+		LastUsedAt:     credential.LastUsedAt,
+	}
+}
+
+// HandleBeginPasskeyRegistration is a wrapper around the service BeginPasskeyRegistration method
+func HandleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.BeginPasskeyRegistration(w, r)
+}
+
+// HandleFinishPasskeyRegistration is a wrapper around the service FinishPasskeyRegistration method
+func HandleFinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.FinishPasskeyRegistration(w, r)
+}
+
+// HandleListPasskeys is a wrapper around the service ListPasskeys method
+func HandleListPasskeys(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListPasskeys(w, r)
+}
+
+// HandleDeletePasskey is a wrapper around the service DeletePasskey method
+func HandleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeletePasskey(w, r)
+}
+
+// HandleBeginPasskeyLogin is a wrapper around the service BeginPasskeyLogin method
+func HandleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.BeginPasskeyLogin(w, r)
+}
+
+// HandleFinishPasskeyLogin is a wrapper around the service FinishPasskeyLogin method
+func HandleFinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.FinishPasskeyLogin(w, r)
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
+	"github.com/danielsaas/generic-saas/internal/webauthn/webauthntest"
+)
+
+const testPasskeyOrigin = "https://app.example.com"
+
+// registerPasskey runs a full registration ceremony for the logged in user
+func registerPasskey(t *testing.T, service *Service, db database.Database, authenticator *webauthntest.Authenticator, accessToken string) PasskeyInfo {
+	t.Helper()
+
+	rr := serveAPI(service, "POST", "/api/user/passkeys/register/begin", "", accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Begin registration failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var begin PasskeyRegistrationOptions
+	json.NewDecoder(rr.Body).Decode(&begin)
+
+	credential, err := authenticator.Register(begin.Options, testPasskeyOrigin)
+	if err != nil {
+		t.Fatalf("Authenticator failed to register: %v", err)
+	}
+
+	body, _ := json.Marshal(PasskeyRegistrationRequest{CeremonyToken: begin.CeremonyToken, Name: "Laptop", Credential: credential})
+	rr = serveAPI(service, "POST", "/api/user/passkeys/register/finish", string(body), accessToken)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Finish registration failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var info PasskeyInfo
+	json.NewDecoder(rr.Body).Decode(&info)
+	return info
+}
+
+// beginPasskeyLogin starts a login ceremony, optionally for a given email
+func beginPasskeyLogin(t *testing.T, service *Service, body string) PasskeyLoginOptions {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	service.BeginPasskeyLogin(rr, httptest.NewRequest("POST", "/auth/passkey/login/begin", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Begin login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var begin PasskeyLoginOptions
+	json.NewDecoder(rr.Body).Decode(&begin)
+	return begin
+}
+
+func finishPasskeyLogin(service *Service, ceremonyToken string, assertion *webauthn.AssertionResponse) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(PasskeyLoginRequest{CeremonyToken: ceremonyToken, Credential: assertion})
+	rr := httptest.NewRecorder()
+	service.FinishPasskeyLogin(rr, httptest.NewRequest("POST", "/auth/passkey/login/finish", strings.NewReader(string(body))))
+	return rr
+}
+
+func TestPasskey_RegistrationAndLogin(t *testing.T) {
+	for _, alg := range webauthn.SupportedAlgorithms {
+		service, db, emails := setupFullTestService(t)
+		login := loginTestUser(t, service, db)
+
+		// Passkeys skip the second factor, so enable it to prove that
+		enableTOTP(t, service, db, login.Token)
+
+		authenticator := webauthntest.NewAuthenticator(alg)
+		info := registerPasskey(t, service, db, authenticator, login.Token)
+		if info.ID == 0 || info.Name != "Laptop" {
+			t.Fatalf("alg %d: unexpected passkey %+v", alg, info)
+		}
+		if len(emails.alerts) != 1 {
+			t.Errorf("alg %d: expected a security alert for the new passkey, got %v", alg, emails.alerts)
+		}
+
+		begin := beginPasskeyLogin(t, service, "")
+		if len(begin.Options.AllowCredentials) != 0 {
+			t.Errorf("alg %d: expected discoverable login options", alg)
+		}
+
+		assertion, err := authenticator.Login(begin.Options, testPasskeyOrigin)
+		if err != nil {
+			t.Fatalf("alg %d: authenticator failed to sign in: %v", alg, err)
+		}
+
+		rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("alg %d: expected status %d, got %d: %s", alg, http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var response AuthResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		if response.Token == "" || response.RefreshToken == "" || response.User.Email != "john@example.com" {
+			t.Fatalf("alg %d: expected tokens for the user, got %+v", alg, response)
+		}
+
+		sessions := listSessions(t, service, db, response.Token)
+		for _, session := range sessions {
+			if session.Current && session.AuthMethod != AuthMethodPasskey {
+				t.Errorf("alg %d: expected passkey session, got %q", alg, session.AuthMethod)
+			}
+		}
+
+		credentials, _ := db.WebAuthnCredentials().ListUserWebAuthnCredentials(context.Background(), response.User.ID)
+		if len(credentials) != 1 || credentials[0].SignCount != 1 || credentials[0].LastUsedAt == nil {
+			t.Errorf("alg %d: expected usage to be recorded, got %+v", alg, credentials)
+		}
+	}
+}
+
+func TestPasskey_LoginWithEmail(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	authenticator := webauthntest.NewAuthenticator(webauthn.AlgES256)
+	registerPasskey(t, service, db, authenticator, login.Token)
+
+	begin := beginPasskeyLogin(t, service, `{"email": "john@example.com"}`)
+	if len(begin.Options.AllowCredentials) != 1 {
+		t.Fatalf("Expected the user's passkey in the allow list, got %+v", begin.Options.AllowCredentials)
+	}
+
+	assertion, _ := authenticator.Login(begin.Options, testPasskeyOrigin)
+	if rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Unknown emails don't reveal anything beyond discoverable options
+	begin = beginPasskeyLogin(t, service, `{"email": "nobody@example.com"}`)
+	if len(begin.Options.AllowCredentials) != 0 {
+		t.Errorf("Expected no allow list for an unknown email, got %+v", begin.Options.AllowCredentials)
+	}
+}
+
+func TestPasskey_LoginRejections(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	authenticator := webauthntest.NewAuthenticator(webauthn.AlgES256)
+	registerPasskey(t, service, db, authenticator, login.Token)
+
+	t.Run("ceremony token is single use", func(t *testing.T) {
+		begin := beginPasskeyLogin(t, service, "")
+		assertion, _ := authenticator.Login(begin.Options, testPasskeyOrigin)
+		if rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion); rr.Code != http.StatusOK {
+			t.Fatalf("Expected first login to succeed, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		assertion, _ = authenticator.Login(begin.Options, testPasskeyOrigin)
+		rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion)
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodeCeremonyTokenInvalid) {
+			t.Errorf("Expected replayed ceremony to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		begin := beginPasskeyLogin(t, service, "")
+		assertion, _ := authenticator.Login(begin.Options, "https://evil.example.com")
+		rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion)
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodePasskeyInvalid) {
+			t.Errorf("Expected wrong origin to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("options built for another user", func(t *testing.T) {
+		other := &User{Name: "Jane", Email: "jane@example.com", Password: "x"}
+		otherUser, _ := db.Users().CreateUser(context.Background(), other)
+		db.WebAuthnCredentials().CreateWebAuthnCredential(context.Background(), &database.WebAuthnCredential{
+			UserID: otherUser.ID, CredentialID: []byte("jane-key"), PublicKey: []byte("key"),
+		})
+
+		begin := beginPasskeyLogin(t, service, `{"email": "jane@example.com"}`)
+		begin.Options.AllowCredentials = nil
+		assertion, _ := authenticator.Login(begin.Options, testPasskeyOrigin)
+		rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion)
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodePasskeyInvalid) {
+			t.Errorf("Expected passkey of another user to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		emails.alerts = nil
+		authenticator.SetSignCount(0)
+
+		begin := beginPasskeyLogin(t, service, "")
+		assertion, _ := authenticator.Login(begin.Options, testPasskeyOrigin)
+		rr := finishPasskeyLogin(service, begin.CeremonyToken, assertion)
+		if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), CodePasskeyCloned) {
+			t.Errorf("Expected cloned passkey to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(emails.alerts) != 1 {
+			t.Errorf("Expected a security alert about the cloned passkey, got %v", emails.alerts)
+		}
+	})
+}
+
+func TestPasskey_ListAndDelete(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	login := loginTestUser(t, service, db)
+	info := registerPasskey(t, service, db, webauthntest.NewAuthenticator(webauthn.AlgEdDSA), login.Token)
+
+	// Registering the same authenticator again would create a duplicate, so
+	// the begin options exclude it
+	rr := serveAPI(service, "POST", "/api/user/passkeys/register/begin", "", login.Token)
+	var begin PasskeyRegistrationOptions
+	json.NewDecoder(rr.Body).Decode(&begin)
+	if len(begin.Options.ExcludeCredentials) != 1 {
+		t.Errorf("Expected existing passkey to be excluded, got %+v", begin.Options.ExcludeCredentials)
+	}
+
+	rr = serveAPI(service, "GET", "/api/user/passkeys", "", login.Token)
+	var list PasskeysResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Passkeys) != 1 || list.Passkeys[0].ID != info.ID {
+		t.Fatalf("Unexpected passkey list: %s", rr.Body.String())
+	}
+
+	if rr := serveAPI(service, "DELETE", "/api/user/passkeys/999", "", login.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown passkey to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	path := "/api/user/passkeys/" + strconv.Itoa(info.ID)
+	if rr := serveAPI(service, "DELETE", path, "", login.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(service, "GET", "/api/user/passkeys", "", login.Token)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Passkeys) != 0 {
+		t.Errorf("Expected no passkeys after delete, got %d", len(list.Passkeys))
+	}
+}
+
+func TestPasskey_RequiresRelyingParty(t *testing.T) {
+	service, _ := setupTestService()
+
+	rr := httptest.NewRecorder()
+	service.BeginPasskeyLogin(rr, httptest.NewRequest("POST", "/auth/passkey/login/begin", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// Error codes returned by the password reset endpoints
+const (
+	CodeResetCodeInvalid   = "reset_code_invalid"
+	CodeResetThrottled     = "reset_throttled"
+	CodeResetNotConfigured = "reset_not_configured"
+)
+
+// maxResetCodeFailures is how many wrong codes an email address may submit
+// before resets for it are locked. Codes are only six digits long.
+const maxResetCodeFailures = 5
+
+// ForgotPasswordRequest is the body of POST /auth/password/forgot
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest is the body of POST /auth/password/reset
+type ResetPasswordRequest struct {
+	Email    string `json:"email"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword emails a reset code. It answers the same way whether or not
+// the email belongs to an account, so it can't be used to find users.
+func (s *Service) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Password reset is not configured", CodeResetNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Email) == "" {
+		writeErrorResponse(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Unknown emails and rate limits are deliberately not reported
+	s.emailTokens.RequestPasswordReset(email.PasswordResetRequest{
+		Email:     strings.ToLower(strings.TrimSpace(req.Email)),
+		RequestIP: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+
+	writeJSONResponse(w, map[string]string{
+		"message": "If an account exists for that email, a reset code has been sent",
+	}, http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using an emailed reset code and signs
+// out every existing session
+func (s *Service) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Password reset is not configured", CodeResetNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" || req.Code == "" || req.Password == "" {
+		writeErrorResponse(w, "Email, code and password are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	emailAddress := strings.ToLower(strings.TrimSpace(req.Email))
+
+	// Check what can be checked without the account first, so a weak
+	// password doesn't use up the code. Looking the account up here would
+	// reveal whether it exists.
+	if err := s.checkNewPassword(ctx, req.Password, &User{Email: emailAddress}); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	emailKey, ipKey := loginKeys(r, emailAddress)
+	resetKey := "reset:" + emailAddress
+
+	wait, _, err := s.loginRetryAfter(ctx, time.Now(), resetKey, ipKey)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		writeCodedErrorResponse(w, "Too many incorrect codes. Try again later", CodeResetThrottled, http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := s.redeemResetCode(r, emailAddress, req.Code, resetKey, ipKey)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		writeCodedErrorResponse(w, "Invalid or expired reset code", CodeResetCodeInvalid, http.StatusBadRequest)
+		return
+	}
+
+	// The name and password history need the account
+	if err := s.checkNewPassword(ctx, req.Password, user); err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		writeErrorResponse(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+	if err := s.passwordPolicy.Remember(ctx, s.db.PasswordHistory(), user.ID, user.Password); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	user.Password = hashedPassword
+	user.PasswordResetRequired = false
+
+	// The code was emailed, so using it proves the address too
+	if !user.EmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if _, err := s.db.Users().UpdateUser(ctx, user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password may still be signed in. Access tokens
+	// die with their session, since RequireAuth checks it.
+	if err := s.db.Sessions().RevokeUserSessions(ctx, user.ID, ""); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.RefreshTokens().RevokeUserRefreshTokens(ctx, user.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Proving control of the mailbox also lifts any login lockout
+	for _, key := range []string{resetKey, emailKey} {
+		if err := s.db.LoginAttempts().ClearLoginAttempts(ctx, key); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.sendSecurityAlert(r, user, "Your password was reset and every device signed in to your account was signed out. "+
+		"If you didn't do this, reset your password again and review your account security.")
+
+	writeJSONResponse(w, map[string]string{"message": "Password has been reset"}, http.StatusOK)
+}
+
+// redeemResetCode verifies a reset code and returns the user it was sent to,
+// or nil if the code is wrong or expired. Wrong codes count towards locking
+// resets for the email address and the client IP.
+func (s *Service) redeemResetCode(r *http.Request, emailAddress, code, resetKey, ipKey string) (*User, error) {
+	ctx := r.Context()
+
+	resetToken, err := s.emailTokens.VerifyPasswordResetCode(emailAddress, code)
+	if errors.Is(err, email.ErrInvalidToken) || errors.Is(err, email.ErrTokenExpired) || errors.Is(err, email.ErrInvalidEmail) {
+		now := time.Now()
+		if _, err := s.recordFailure(ctx, resetKey, maxResetCodeFailures, now); err != nil {
+			return nil, err
+		}
+		if _, err := s.recordFailure(ctx, ipKey, s.loginThrottle.MaxFailuresPerIP, now); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.Users().GetUserByID(ctx, resetToken.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The code was sent to an address the account no longer uses
+	if !strings.EqualFold(user.Email, emailAddress) {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// HandleForgotPassword is a wrapper around the service ForgotPassword method
+func HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ForgotPassword(w, r)
+}
+
+// HandleResetPassword is a wrapper around the service ResetPassword method
+func HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ResetPassword(w, r)
+}
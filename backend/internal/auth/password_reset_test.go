@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postForgotPassword(service *Service, emailAddress string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ForgotPasswordRequest{Email: emailAddress})
+	req := httptest.NewRequest("POST", "/auth/password/forgot", strings.NewReader(string(body)))
+	rr := httptest.NewRecorder()
+	service.ForgotPassword(rr, req)
+	return rr
+}
+
+func postResetPassword(service *Service, emailAddress, code, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ResetPasswordRequest{Email: emailAddress, Code: code, Password: password})
+	req := httptest.NewRequest("POST", "/auth/password/reset", strings.NewReader(string(body)))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+	service.ResetPassword(rr, req)
+	return rr
+}
+
+func TestForgotPassword_DoesNotRevealAccounts(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+
+	known := postForgotPassword(service, "John@Example.com")
+	unknown := postForgotPassword(service, "nobody@example.com")
+
+	if known.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 for both, got %d and %d", known.Code, unknown.Code)
+	}
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected identical responses, got %q and %q", known.Body.String(), unknown.Body.String())
+	}
+	if len(emails.resetCodes) != 1 {
+		t.Errorf("Expected one reset code to be sent, got %d", len(emails.resetCodes))
+	}
+
+	// Rate limited requests look the same too
+	for i := 0; i < 3; i++ {
+		if rr := postForgotPassword(service, "john@example.com"); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d", rr.Code)
+		}
+	}
+	if len(emails.resetCodes) != 3 {
+		t.Errorf("Expected codes to stop after the hourly limit, got %d", len(emails.resetCodes))
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+
+	postForgotPassword(service, "john@example.com")
+	code := emails.resetCodes[0]
+
+	rr := postResetPassword(service, "john@example.com", code, "NewPassword1!")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Only the new password works
+	if rr := postLogin(service, "john@example.com", "password123", "192.0.2.2:1234"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old password to be rejected, got %d", rr.Code)
+	}
+	if rr := postLogin(service, "john@example.com", "NewPassword1!", "192.0.2.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Expected new password to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Existing sessions and refresh tokens are gone
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", session.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old access token to be rejected, got %d", rr.Code)
+	}
+	if rr := postRefreshToken(service, service.Refresh, session.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old refresh token to be rejected, got %d", rr.Code)
+	}
+
+	if len(emails.alerts) != 1 || !strings.Contains(emails.alerts[0], "password was reset") {
+		t.Errorf("Expected a reset security alert, got %v", emails.alerts)
+	}
+
+	// The code works only once
+	rr = postResetPassword(service, "john@example.com", code, "OtherPassword1!")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeResetCodeInvalid) {
+		t.Errorf("Expected used code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestResetPassword_Validation(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	postForgotPassword(service, "john@example.com")
+	code := emails.resetCodes[0]
+
+	if rr := postResetPassword(service, "john@example.com", "", "NewPassword1!"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected missing code to be rejected, got %d", rr.Code)
+	}
+	if rr := postResetPassword(service, "john@example.com", code, "weak"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected weak password to be rejected, got %d", rr.Code)
+	}
+
+	// A rejected password doesn't use up the code
+	if rr := postResetPassword(service, "john@example.com", code, "NewPassword1!"); rr.Code != http.StatusOK {
+		t.Errorf("Expected code to still work, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestResetPassword_RejectsReusedPassword(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+
+	postForgotPassword(service, "john@example.com")
+	if rr := postResetPassword(service, "john@example.com", emails.resetCodes[0], "NewPassword1!"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	postForgotPassword(service, "john@example.com")
+	rr := postResetPassword(service, "john@example.com", emails.resetCodes[1], "NewPassword1!")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "previously used") {
+		t.Errorf("Expected the current password to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	postForgotPassword(service, "john@example.com")
+	if rr := postResetPassword(service, "john@example.com", emails.resetCodes[2], "Another-Pass1"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Both replaced passwords are remembered
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+	hashes, _ := db.PasswordHistory().ListPasswordHashes(context.Background(), user.ID, 10)
+	if len(hashes) != 2 {
+		t.Errorf("Expected two remembered passwords, got %d", len(hashes))
+	}
+}
+
+func TestResetPassword_LocksAfterWrongCodes(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	loginTestUser(t, service, db)
+	postForgotPassword(service, "john@example.com")
+	code := emails.resetCodes[0]
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	// Earlier guesses, made long enough ago that their delay has passed
+	earlier := time.Now().Add(-2 * time.Minute)
+	for i := 0; i < maxResetCodeFailures-1; i++ {
+		db.LoginAttempts().RecordLoginFailure(context.Background(), "reset:john@example.com", earlier, earlier.Add(-time.Hour))
+	}
+
+	if rr := postResetPassword(service, "john@example.com", wrong, "NewPassword1!"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected wrong code to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Even the right code is refused once locked
+	rr := postResetPassword(service, "john@example.com", code, "NewPassword1!")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), CodeResetThrottled) {
+		t.Fatalf("Expected resets to be locked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
+
+func TestResetPassword_NotConfigured(t *testing.T) {
+	service, _ := setupTestService()
+
+	if rr := postForgotPassword(service, "john@example.com"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if rr := postResetPassword(service, "john@example.com", "123456", "NewPassword1!"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+)
+
+// Error codes returned by the role endpoints
+const (
+	CodeLastAdmin = "last_admin"
+)
+
+// RolesResponse lists roles and the permissions they grant
+type RolesResponse struct {
+	Roles []*database.Role `json:"roles"`
+}
+
+// SetBootstrapAdminEmail sets the address of the first administrator. While
+// nobody has the admin role, the account with this address is given it once
+// the address is verified and the user signs in.
+func (s *Service) SetBootstrapAdminEmail(address string) {
+	s.bootstrapAdminEmail = strings.ToLower(strings.TrimSpace(address))
+}
+
+// bootstrapAdmin gives the admin role to the user if they are the
+// configured first administrator and nobody has it yet
+func (s *Service) bootstrapAdmin(ctx context.Context, user *User) error {
+	if s.bootstrapAdminEmail == "" || !strings.EqualFold(user.Email, s.bootstrapAdminEmail) {
+		return nil
+	}
+	_, err := rbac.BootstrapAdmin(ctx, s.db, user.Email)
+	return err
+}
+
+// ListMyRoles returns the roles of the authenticated user, so clients can
+// tell which admin features to show
+func (s *Service) ListMyRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles, ok := middleware.RolesFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSONResponse(w, RolesResponse{Roles: roles}, http.StatusOK)
+}
+
+// ListRoles returns every role
+func (s *Service) ListRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roles, err := s.db.Roles().ListRoles(r.Context())
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, RolesResponse{Roles: roles}, http.StatusOK)
+}
+
+// ListUserRoles returns the roles of the user named in the path
+func (s *Service) ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeUserRoles(w, r, user.ID)
+}
+
+// AssignUserRole gives the user named in the path a role. The user is told
+// by email, since a new role can let someone do a lot with their account.
+func (s *Service) AssignUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	roleName := r.PathValue("role")
+	if err := s.db.Roles().AssignRole(r.Context(), user.ID, roleName); err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) {
+			writeErrorResponse(w, "Role not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeErrorResponse(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionAssignRole, user.ID, roleName) {
+		return
+	}
+
+	s.sendSecurityAlert(r, user, "Your account was given the "+roleName+" role. "+
+		"If you don't expect this, contact support.")
+
+	s.writeUserRoles(w, r, user.ID)
+}
+
+// UnassignUserRole takes a role away from the user named in the path. The
+// last admin can't lose the role, or nobody could manage roles any more.
+func (s *Service) UnassignUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := s.pathUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	roleName := r.PathValue("role")
+	if roleName == rbac.RoleAdmin {
+		lastAdmin, err := s.isLastAdmin(ctx, user.ID)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if lastAdmin {
+			writeCodedErrorResponse(w, "The last admin can't lose the admin role", CodeLastAdmin, http.StatusConflict)
+			return
+		}
+	}
+
+	if err := s.db.Roles().UnassignRole(ctx, user.ID, roleName); err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) {
+			writeErrorResponse(w, "User doesn't have that role", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.audit(w, r, AuditActionUnassignRole, user.ID, roleName) {
+		return
+	}
+
+	s.writeUserRoles(w, r, user.ID)
+}
+
+// isLastAdmin reports whether the user is the only one with the admin role
+func (s *Service) isLastAdmin(ctx context.Context, userID int) (bool, error) {
+	admins, err := s.db.Roles().CountRoleUsers(ctx, rbac.RoleAdmin)
+	if err != nil || admins > 1 {
+		return false, err
+	}
+
+	roles, err := s.db.Roles().ListUserRoles(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.Name == rbac.RoleAdmin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pathUser loads the user whose ID is in the path
+func (s *Service) pathUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	user, err := s.db.Users().GetUserByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeErrorResponse(w, "User not found", http.StatusNotFound)
+			return nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// writeUserRoles responds with the roles a user has
+func (s *Service) writeUserRoles(w http.ResponseWriter, r *http.Request, userID int) {
+	roles, err := s.db.Roles().ListUserRoles(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, RolesResponse{Roles: roles}, http.StatusOK)
+}
+
+// HandleListMyRoles is a wrapper around the service ListMyRoles method
+func HandleListMyRoles(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListMyRoles(w, r)
+}
+
+// HandleListRoles is a wrapper around the service ListRoles method
+func HandleListRoles(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListRoles(w, r)
+}
+
+// HandleListUserRoles is a wrapper around the service ListUserRoles method
+func HandleListUserRoles(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListUserRoles(w, r)
+}
+
+// HandleAssignUserRole is a wrapper around the service AssignUserRole method
+func HandleAssignUserRole(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.AssignUserRole(w, r)
+}
+
+// HandleUnassignUserRole is a wrapper around the service UnassignUserRole method
+func HandleUnassignUserRole(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.UnassignUserRole(w, r)
+}
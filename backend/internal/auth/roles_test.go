@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+)
+
+func decodeRoleNames(t *testing.T, rr *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	var response RolesResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode roles response: %v", err)
+	}
+	names := []string{}
+	for _, role := range response.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// setupAdmin logs in john@example.com as the bootstrapped admin
+func setupAdmin(t *testing.T) (*Service, database.Database, *recordingEmailService, AuthResponse) {
+	t.Helper()
+
+	service, db, emails := setupFullTestService(t)
+	ctx := context.Background()
+	rbac.EnsureDefaultRoles(ctx, db.Roles())
+	service.SetBootstrapAdminEmail("John@Example.com")
+
+	session := loginTestUser(t, service, db)
+	if rr := serveAPI(service, "GET", "/api/admin/roles", "", session.Token); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected an unverified address not to be made admin, got %d", rr.Code)
+	}
+
+	user, _ := db.Users().GetUserByEmail(ctx, "john@example.com")
+	verifiedAt := time.Now()
+	user.EmailVerifiedAt = &verifiedAt
+	db.Users().UpdateUser(ctx, user)
+
+	return service, db, emails, loginAgain(t, service)
+}
+
+func TestBootstrapAdmin_OnSignIn(t *testing.T) {
+	service, _, _, session := setupAdmin(t)
+
+	rr := serveAPI(service, "GET", "/api/user/roles", "", session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if names := decodeRoleNames(t, rr); len(names) != 1 || names[0] != rbac.RoleAdmin {
+		t.Errorf("Expected the admin role, got %v", names)
+	}
+
+	if rr := serveAPI(service, "GET", "/api/admin/roles", "", session.Token); rr.Code != http.StatusOK {
+		t.Errorf("Expected the admin to list roles, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAssignAndUnassignUserRole(t *testing.T) {
+	service, db, emails, session := setupAdmin(t)
+	jane, _ := db.Users().CreateUser(context.Background(), &database.User{Name: "Jane", Email: "jane@example.com"})
+	path := "/api/admin/users/" + strconv.Itoa(jane.ID) + "/roles"
+
+	rr := serveAPI(service, "PUT", path+"/"+rbac.RoleAdmin, "", session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if names := decodeRoleNames(t, rr); len(names) != 1 || names[0] != rbac.RoleAdmin {
+		t.Errorf("Expected Jane to be admin, got %v", names)
+	}
+	if last := emails.alerts[len(emails.alerts)-1]; !strings.Contains(last, "given the admin role") {
+		t.Errorf("Expected Jane to be told, got %q", last)
+	}
+
+	if rr := serveAPI(service, "PUT", path+"/unknown", "", session.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown role to be rejected, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "PUT", "/api/admin/users/999/roles/"+rbac.RoleAdmin, "", session.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown user to be rejected, got %d", rr.Code)
+	}
+
+	if rr := serveAPI(service, "DELETE", path+"/"+rbac.RoleAdmin, "", session.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "GET", path, "", session.Token); len(decodeRoleNames(t, rr)) != 0 {
+		t.Error("Expected Jane to have no roles left")
+	}
+	if rr := serveAPI(service, "DELETE", path+"/"+rbac.RoleAdmin, "", session.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected removing a role Jane doesn't have to fail, got %d", rr.Code)
+	}
+}
+
+func TestUnassignUserRole_KeepsLastAdmin(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	user, _ := db.Users().GetUserByEmail(context.Background(), "john@example.com")
+
+	rr := serveAPI(service, "DELETE", "/api/admin/users/"+strconv.Itoa(user.ID)+"/roles/"+rbac.RoleAdmin, "", session.Token)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeLastAdmin) {
+		t.Errorf("Expected the last admin to keep the role, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+)
+
+// RegisterRoutes adds the auth endpoints, with the middleware that guards
+// each of them. Public routes go on mux. Routes under /api/ go on
+// protected, which the server serves behind middleware.RequireAuth; db is
+// the database that middleware uses.
+func RegisterRoutes(mux, protected *http.ServeMux, db database.Database) {
+	mux.HandleFunc("/auth/login", HandleLogin)
+	mux.HandleFunc("/auth/register", HandleRegister)
+	mux.HandleFunc("/auth/refresh", HandleRefresh)
+	mux.HandleFunc("/auth/logout", HandleLogout)
+	mux.HandleFunc("/auth/unlock", HandleUnlockAccount)
+	mux.HandleFunc("/auth/login/report", HandleReportLogin)
+	mux.HandleFunc("/auth/password/forgot", HandleForgotPassword)
+	mux.HandleFunc("/auth/password/reset", HandleResetPassword)
+	mux.HandleFunc("/auth/verify", HandleVerifyEmail)
+	mux.HandleFunc("/auth/verify/resend", HandleResendVerification)
+	mux.HandleFunc("/auth/magic-link", HandleRequestMagicLink)
+	mux.HandleFunc("/auth/magic-link/callback", HandleFinishMagicLink)
+	mux.HandleFunc("/auth/email-change/confirm", HandleConfirmEmailChange)
+	mux.HandleFunc("/auth/email-change/cancel", HandleCancelEmailChange)
+	mux.HandleFunc("/auth/invitations", HandleGetInvitation)
+	mux.HandleFunc("/auth/invitations/accept", HandleRegisterWithInvitation)
+	mux.HandleFunc("/auth/mfa/verify", HandleVerifyMFA)
+	mux.HandleFunc("/auth/passkey/login/begin", HandleBeginPasskeyLogin)
+	mux.HandleFunc("/auth/passkey/login/finish", HandleFinishPasskeyLogin)
+	mux.HandleFunc("/auth/oidc/providers", HandleListOIDCProviders)
+	mux.HandleFunc("/auth/oidc/{provider}/start", HandleStartOIDCLogin)
+	mux.HandleFunc("/auth/oidc/{provider}/callback", HandleFinishOIDCLogin)
+	mux.HandleFunc("/auth/saml/{org_id}/metadata", HandleSAMLMetadata)
+	mux.HandleFunc("/auth/saml/{org_id}/login", HandleStartSAMLLogin)
+	mux.HandleFunc("/auth/saml/{org_id}/acs", HandleSAMLAssertionConsumer)
+	mux.HandleFunc("/auth/saml/session", HandleFinishSAMLLogin)
+
+	// OAuth endpoints for third-party clients, which authenticate themselves
+	mux.HandleFunc("/oauth/token", HandleToken)
+	mux.HandleFunc("/oauth/introspect", HandleIntrospect)
+	mux.HandleFunc("/oauth/revoke", HandleRevoke)
+
+	// SCIM provisioning for identity providers, which authenticate with an
+	// organization's SCIM token
+	mux.HandleFunc("/scim/v2/Users", handleSCIMUsers)
+	mux.HandleFunc("/scim/v2/Users/{id}", handleSCIMUser)
+	mux.HandleFunc("/scim/v2/Groups", handleSCIMGroups)
+	mux.HandleFunc("/scim/v2/Groups/{id}", handleSCIMGroup)
+
+	protected.Handle("/api/user/account", middleware.ForbidImpersonation(http.HandlerFunc(HandleDeleteAccount)))
+	protected.HandleFunc("/api/user/sessions", HandleListSessions)
+	protected.HandleFunc("/api/user/sessions/revoke-others", HandleRevokeOtherSessions)
+	protected.HandleFunc("/api/user/sessions/{id}", HandleRevokeSession)
+	protected.Handle("/api/user/mfa/totp/enroll", middleware.ForbidImpersonation(http.HandlerFunc(HandleEnrollTOTP)))
+	protected.Handle("/api/user/mfa/totp/confirm", middleware.ForbidImpersonation(http.HandlerFunc(HandleConfirmTOTP)))
+	protected.Handle("/api/user/mfa/totp/disable", middleware.ForbidImpersonation(http.HandlerFunc(HandleDisableTOTP)))
+	protected.HandleFunc("/api/user/passkeys", HandleListPasskeys)
+	protected.Handle("/api/user/passkeys/register/begin", middleware.ForbidImpersonation(http.HandlerFunc(HandleBeginPasskeyRegistration)))
+	protected.Handle("/api/user/passkeys/register/finish", middleware.ForbidImpersonation(http.HandlerFunc(HandleFinishPasskeyRegistration)))
+	protected.Handle("/api/user/passkeys/{id}", middleware.ForbidImpersonation(http.HandlerFunc(HandleDeletePasskey)))
+	protected.HandleFunc("/api/user/api-keys", handleAPIKeys)
+	protected.Handle("/api/user/api-keys/{id}", middleware.ForbidImpersonation(http.HandlerFunc(HandleRevokeAPIKey)))
+	protected.HandleFunc("/api/oauth/clients", handleOAuthClients)
+	protected.Handle("/api/oauth/clients/{client_id}", middleware.ForbidImpersonation(http.HandlerFunc(HandleDeleteOAuthClient)))
+	protected.HandleFunc("/api/oauth/authorize", handleOAuthAuthorize)
+	protected.HandleFunc("/api/user/roles", HandleListMyRoles)
+	protected.HandleFunc("/api/user/organization", handleCurrentOrganization(db))
+
+	// Organization routes. Membership and organization roles are checked by
+	// the handlers.
+	protected.HandleFunc("/api/organizations", handleOrganizations)
+	protected.HandleFunc("/api/organizations/{id}", handleOrganization)
+	protected.HandleFunc("/api/organizations/{id}/members", HandleListOrganizationMembers)
+	protected.HandleFunc("/api/organizations/{id}/members/{user_id}", handleOrganizationMember)
+	protected.Handle("/api/organizations/{id}/transfer", middleware.ForbidImpersonation(http.HandlerFunc(HandleTransferOrganization)))
+	protected.HandleFunc("/api/organizations/{id}/invitations", handleInvitations)
+	protected.HandleFunc("/api/organizations/{id}/invitations/{invitation_id}", HandleRevokeInvitation)
+	protected.HandleFunc("/api/organizations/{id}/saml", handleSAMLConnection)
+	protected.Handle("/api/organizations/{id}/saml/verify", middleware.ForbidImpersonation(http.HandlerFunc(HandleVerifySAMLDomains)))
+	protected.HandleFunc("/api/organizations/{id}/scim/tokens", handleSCIMTokens)
+	protected.Handle("/api/organizations/{id}/scim/tokens/{token_id}", middleware.ForbidImpersonation(http.HandlerFunc(HandleDeleteSCIMToken)))
+	protected.Handle("/api/invitations/accept", middleware.ForbidImpersonation(http.HandlerFunc(HandleAcceptInvitation)))
+
+	// Admin routes declare the permission they need
+	protected.Handle("/api/admin/roles", middleware.RequirePermission(rbac.PermissionRolesRead)(http.HandlerFunc(HandleListRoles)))
+	protected.Handle("/api/admin/users/{id}/roles", middleware.RequirePermission(rbac.PermissionRolesRead)(http.HandlerFunc(HandleListUserRoles)))
+	protected.Handle("/api/admin/users/{id}/roles/{role}", middleware.RequirePermission(rbac.PermissionRolesWrite)(http.HandlerFunc(handleUserRole)))
+	protected.Handle("/api/admin/users", middleware.RequirePermission(rbac.PermissionUsersRead)(http.HandlerFunc(HandleAdminSearchUsers)))
+	protected.HandleFunc("/api/admin/users/{id}", handleAdminUser)
+	protected.Handle("/api/admin/users/{id}/sessions", middleware.RequirePermission(rbac.PermissionUsersRead)(http.HandlerFunc(HandleAdminListSessions)))
+	protected.Handle("/api/admin/users/{id}/api-keys", middleware.RequirePermission(rbac.PermissionUsersRead)(http.HandlerFunc(HandleAdminListAPIKeys)))
+	protected.Handle("/api/admin/users/{id}/password-reset", middleware.RequirePermission(rbac.PermissionUsersWrite)(http.HandlerFunc(HandleAdminForcePasswordReset)))
+	protected.Handle("/api/admin/users/{id}/suspend", middleware.RequirePermission(rbac.PermissionUsersWrite)(http.HandlerFunc(HandleAdminSuspendUser)))
+	protected.Handle("/api/admin/users/{id}/unsuspend", middleware.RequirePermission(rbac.PermissionUsersWrite)(http.HandlerFunc(HandleAdminUnsuspendUser)))
+	protected.Handle("/api/admin/users/{id}/verification/resend", middleware.RequirePermission(rbac.PermissionUsersWrite)(http.HandlerFunc(HandleAdminResendVerification)))
+	protected.Handle("/api/admin/users/{id}/impersonate", middleware.RequirePermission(rbac.PermissionUsersImpersonate)(http.HandlerFunc(HandleImpersonate)))
+	protected.Handle("/api/admin/audit-log", middleware.RequirePermission(rbac.PermissionAuditRead)(http.HandlerFunc(HandleAdminListAuditLog)))
+}
+
+// handleAdminUser routes between GET and DELETE for a user, with the
+// permission each needs
+func handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		middleware.RequirePermission(rbac.PermissionUsersRead)(http.HandlerFunc(HandleAdminGetUser)).ServeHTTP(w, r)
+	case http.MethodDelete:
+		middleware.RequirePermission(rbac.PermissionUsersWrite)(http.HandlerFunc(HandleAdminDeleteUser)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUserRole routes between PUT and DELETE for a user's role
+func handleUserRole(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		HandleAssignUserRole(w, r)
+	case http.MethodDelete:
+		HandleUnassignUserRole(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIKeys routes between GET and POST for API keys. Impersonators
+// can't create keys, which would outlive their session.
+func handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListAPIKeys(w, r)
+	case http.MethodPost:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleCreateAPIKey)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOAuthClients routes between GET and POST for OAuth clients.
+// Impersonators can't register clients, which would outlive their session.
+func handleOAuthClients(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListOAuthClients(w, r)
+	case http.MethodPost:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleCreateOAuthClient)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOAuthAuthorize routes between GET and POST for the consent
+// screen. Impersonators can see what an app asks for but can't grant it.
+func handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleAuthorize(w, r)
+	case http.MethodPost:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleAuthorize)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOrganizations routes between GET and POST for organizations
+func handleOrganizations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListOrganizations(w, r)
+	case http.MethodPost:
+		HandleCreateOrganization(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOrganization routes between GET, PUT and DELETE for an
+// organization. Impersonators can't delete organizations.
+func handleOrganization(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetOrganization(w, r)
+	case http.MethodPut:
+		HandleUpdateOrganization(w, r)
+	case http.MethodDelete:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleDeleteOrganization)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOrganizationMember routes between PUT and DELETE for a member of
+// an organization
+func handleOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		HandleUpdateOrganizationMember(w, r)
+	case http.MethodDelete:
+		HandleRemoveOrganizationMember(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleInvitations routes between GET and POST for an organization's
+// invitations
+func handleInvitations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListInvitations(w, r)
+	case http.MethodPost:
+		HandleCreateInvitation(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSAMLConnection routes between GET, PUT and DELETE for an
+// organization's SAML connection. Impersonators can't change how members
+// sign in.
+func handleSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetSAMLConnection(w, r)
+	case http.MethodPut:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleSaveSAMLConnection)).ServeHTTP(w, r)
+	case http.MethodDelete:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleDeleteSAMLConnection)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSCIMTokens routes between GET and POST for an organization's SCIM
+// tokens. Impersonators can't create credentials for the organization.
+func handleSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListSCIMTokens(w, r)
+	case http.MethodPost:
+		middleware.ForbidImpersonation(http.HandlerFunc(HandleCreateSCIMToken)).ServeHTTP(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSCIMUsers routes between listing and provisioning SCIM users
+func handleSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListSCIMUsers(w, r)
+	case http.MethodPost:
+		HandleCreateSCIMUser(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSCIMUser routes between GET, PUT, PATCH and DELETE for one SCIM user
+func handleSCIMUser(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetSCIMUser(w, r)
+	case http.MethodPut:
+		HandleReplaceSCIMUser(w, r)
+	case http.MethodPatch:
+		HandlePatchSCIMUser(w, r)
+	case http.MethodDelete:
+		HandleDeleteSCIMUser(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSCIMGroups routes between listing and creating SCIM groups
+func handleSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListSCIMGroups(w, r)
+	case http.MethodPost:
+		HandleCreateSCIMGroup(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSCIMGroup routes between GET, PUT, PATCH and DELETE for one SCIM group
+func handleSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetSCIMGroup(w, r)
+	case http.MethodPut:
+		HandleReplaceSCIMGroup(w, r)
+	case http.MethodPatch:
+		HandlePatchSCIMGroup(w, r)
+	case http.MethodDelete:
+		HandleDeleteSCIMGroup(w, r)
+	default:
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCurrentOrganization routes between GET and PUT for the
+// organization a session works in. GET needs one to be selected.
+func handleCurrentOrganization(db database.Database) http.HandlerFunc {
+	current := middleware.RequireOrganization(db)(http.HandlerFunc(HandleCurrentOrganization))
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			current.ServeHTTP(w, r)
+		case http.MethodPut:
+			HandleSwitchOrganization(w, r)
+		default:
+			writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
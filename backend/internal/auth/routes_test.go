@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+)
+
+func TestRoutes_RequirePermission(t *testing.T) {
+	service, db, _ := setupFullTestService(t)
+	session := loginTestUser(t, service, db)
+	user := "/api/admin/users/" + strconv.Itoa(session.User.ID)
+
+	tests := []struct {
+		method     string
+		path       string
+		permission string
+	}{
+		{"GET", "/api/admin/roles", rbac.PermissionRolesRead},
+		{"GET", user + "/roles", rbac.PermissionRolesRead},
+		{"PUT", user + "/roles/" + rbac.RoleAdmin, rbac.PermissionRolesWrite},
+		{"DELETE", user + "/roles/" + rbac.RoleAdmin, rbac.PermissionRolesWrite},
+		{"GET", "/api/admin/users", rbac.PermissionUsersRead},
+		{"GET", user, rbac.PermissionUsersRead},
+		{"DELETE", user, rbac.PermissionUsersWrite},
+		{"GET", user + "/sessions", rbac.PermissionUsersRead},
+		{"GET", user + "/api-keys", rbac.PermissionUsersRead},
+		{"POST", user + "/password-reset", rbac.PermissionUsersWrite},
+		{"POST", user + "/suspend", rbac.PermissionUsersWrite},
+		{"POST", user + "/unsuspend", rbac.PermissionUsersWrite},
+		{"POST", user + "/verification/resend", rbac.PermissionUsersWrite},
+		{"POST", user + "/impersonate", rbac.PermissionUsersImpersonate},
+		{"GET", "/api/admin/audit-log", rbac.PermissionAuditRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := serveAPI(service, tt.method, tt.path, `{"reason": "ticket 42"}`, session.Token)
+			if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), middleware.AuthCodePermissionRequired) || !strings.Contains(rr.Body.String(), tt.permission) {
+				t.Errorf("Expected the %s permission to be required, got %d: %s", tt.permission, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoutes_ForbidImpersonation(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	jane := createJane(t, db)
+
+	rr := postImpersonate(service, jane.ID, `{"reason": "ticket 42"}`, session.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var impersonation ImpersonationResponse
+	json.NewDecoder(rr.Body).Decode(&impersonation)
+
+	// The session works where impersonators are allowed
+	if rr := serveAPI(service, "GET", "/api/user/api-keys", "", impersonation.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the session to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{"DELETE", "/api/user/account"},
+		{"POST", "/api/user/mfa/totp/enroll"},
+		{"POST", "/api/user/mfa/totp/confirm"},
+		{"POST", "/api/user/mfa/totp/disable"},
+		{"POST", "/api/user/passkeys/register/begin"},
+		{"POST", "/api/user/passkeys/register/finish"},
+		{"DELETE", "/api/user/passkeys/1"},
+		{"POST", "/api/user/api-keys"},
+		{"DELETE", "/api/user/api-keys/1"},
+		{"POST", "/api/oauth/clients"},
+		{"DELETE", "/api/oauth/clients/client-1"},
+		{"POST", "/api/oauth/authorize"},
+		{"DELETE", "/api/organizations/1"},
+		{"POST", "/api/organizations/1/transfer"},
+		{"PUT", "/api/organizations/1/saml"},
+		{"DELETE", "/api/organizations/1/saml"},
+		{"POST", "/api/organizations/1/saml/verify"},
+		{"POST", "/api/organizations/1/scim/tokens"},
+		{"DELETE", "/api/organizations/1/scim/tokens/1"},
+		{"POST", "/api/invitations/accept"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rr := serveAPI(service, tt.method, tt.path, `{}`, impersonation.Token)
+			if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), middleware.AuthCodeImpersonating) {
+				t.Errorf("Expected impersonators to be refused, got %d: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoutes_RefuseAPIKeysOnAdminRoutes(t *testing.T) {
+	service, db, _, session := setupAdmin(t)
+	created := createTestAPIKey(t, service, db, session.Token, `{"name": "ci", "scopes": ["metrics:read", "profile:read"]}`)
+
+	// The key's owner may use the route, but the key has no scope for it
+	if rr := serveAPI(service, "GET", "/api/admin/roles", "", session.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the administrator to be allowed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, path := range []string{"/api/admin/roles", "/api/admin/users", "/api/admin/audit-log"} {
+		rr := serveAPI(service, "GET", path, "", created.Key)
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), middleware.AuthCodeInsufficientScope) {
+			t.Errorf("Expected the API key to be refused on %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,791 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/config"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/saml"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// AuthMethodSAML is recorded on sessions started through an organization's
+// SAML identity provider
+const AuthMethodSAML = "saml"
+
+// Error codes returned by the SAML endpoints. The assertion consumer
+// service redirects to the frontend with them in the error parameter.
+const (
+	CodeSAMLResponseInvalid      = "saml_response_invalid"
+	CodeSAMLIdPInitiatedDisabled = "saml_idp_initiated_disabled"
+	CodeSAMLAssertionReplayed    = "saml_assertion_replayed"
+	CodeSAMLEmailNotAllowed      = "saml_email_not_allowed"
+	CodeSAMLAccountNotMember     = "saml_account_not_member"
+	CodeSAMLAccountNotManaged    = "saml_account_not_managed"
+	CodeSAMLNotProvisioned       = "saml_not_provisioned"
+	CodeSAMLCodeInvalid          = "saml_code_invalid"
+	CodeSAMLDomainTaken          = "saml_domain_taken"
+	CodeSAMLDomainUnverified     = "saml_domain_unverified"
+)
+
+const (
+	// samlRequestTTL is how long the user has to sign in at the identity provider
+	samlRequestTTL = 10 * time.Minute
+
+	// samlLoginCodeTTL is how long the frontend has to exchange a login code
+	samlLoginCodeTTL = time.Minute
+
+	// defaultSAMLNameAttribute is read into the user's name unless the
+	// connection names another attribute
+	defaultSAMLNameAttribute = "name"
+
+	// samlVerificationRecordPrefix names the DNS TXT record, under a
+	// claimed domain, that holds the domain's verification token
+	samlVerificationRecordPrefix = "_saml-verification."
+)
+
+// SAMLConnectionRequest is the body of PUT /api/organizations/{id}/saml
+type SAMLConnectionRequest struct {
+	IdPEntityID       string   `json:"idp_entity_id"`
+	IdPSSOURL         string   `json:"idp_sso_url"`
+	IdPCertificate    string   `json:"idp_certificate"`
+	Domains           []string `json:"domains"`
+	EmailAttribute    string   `json:"email_attribute"`
+	NameAttribute     string   `json:"name_attribute"`
+	AllowIdPInitiated bool     `json:"allow_idp_initiated"`
+	JITProvisioning   bool     `json:"jit_provisioning"`
+}
+
+// SAMLConnectionResponse describes an organization's connection, which is
+// null until one is saved, with what the identity provider needs to know
+// about us
+type SAMLConnectionResponse struct {
+	Connection  *database.SAMLConnection `json:"connection"`
+	EntityID    string                   `json:"sp_entity_id"`
+	ACSURL      string                   `json:"sp_acs_url"`
+	MetadataURL string                   `json:"sp_metadata_url"`
+}
+
+// SAMLStartResponse tells the frontend where to send the browser. The
+// frontend should keep State and check it against the callback's.
+type SAMLStartResponse struct {
+	RedirectURL string `json:"redirect_url"`
+	State       string `json:"state"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// SAMLSessionRequest is the body of POST /auth/saml/session
+type SAMLSessionRequest struct {
+	Code string `json:"code"`
+}
+
+// samlEntityID identifies us to an organization's identity provider. It is
+// also where our metadata is served.
+func (s *Service) samlEntityID(organizationID int) string {
+	return s.samlBaseURL + "/auth/saml/" + strconv.Itoa(organizationID) + "/metadata"
+}
+
+// samlACSURL is where an organization's identity provider posts responses
+func (s *Service) samlACSURL(organizationID int) string {
+	return s.samlBaseURL + "/auth/saml/" + strconv.Itoa(organizationID) + "/acs"
+}
+
+// samlServiceProvider sets up our side of a connection
+func (s *Service) samlServiceProvider(conn *database.SAMLConnection) (*saml.ServiceProvider, error) {
+	cert, err := saml.ParseCertificate(conn.IdPCertificate)
+	if err != nil {
+		return nil, err
+	}
+	return saml.NewServiceProvider(saml.Config{
+		EntityID:       s.samlEntityID(conn.OrganizationID),
+		ACSURL:         s.samlACSURL(conn.OrganizationID),
+		IdPEntityID:    conn.IdPEntityID,
+		IdPSSOURL:      conn.IdPSSOURL,
+		IdPCertificate: cert,
+	})
+}
+
+// pathSAMLConnection loads the connection of the organization whose ID is
+// in the path, writing a 404 if it has none and a 403 if none of its
+// domains is verified yet
+func (s *Service) pathSAMLConnection(w http.ResponseWriter, r *http.Request) (*database.SAMLConnection, bool) {
+	id, err := strconv.Atoi(r.PathValue("org_id"))
+	if err != nil {
+		writeErrorResponse(w, "SAML is not configured for this organization", http.StatusNotFound)
+		return nil, false
+	}
+
+	conn, err := s.db.SAMLConnections().GetSAMLConnection(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrSAMLConnectionNotFound) {
+			writeErrorResponse(w, "SAML is not configured for this organization", http.StatusNotFound)
+			return nil, false
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	for _, domain := range conn.Domains {
+		if domain.Verified() {
+			return conn, true
+		}
+	}
+	writeCodedErrorResponse(w, "SAML sign-in needs a verified domain", CodeSAMLDomainUnverified, http.StatusForbidden)
+	return nil, false
+}
+
+// GetSAMLConnection returns an organization's SAML connection to its owner
+func (s *Service) GetSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	conn, err := s.db.SAMLConnections().GetSAMLConnection(r.Context(), org.ID)
+	if err != nil && !errors.Is(err, database.ErrSAMLConnectionNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, s.samlConnectionResponse(org.ID, conn), http.StatusOK)
+}
+
+// SaveSAMLConnection creates or replaces an organization's SAML connection
+func (s *Service) SaveSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	var req SAMLConnectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := s.validateSAMLConnection(org.ID, &req)
+	if err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := s.db.SAMLConnections().GetSAMLConnection(r.Context(), org.ID)
+	if err != nil && !errors.Is(err, database.ErrSAMLConnectionNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := keepSAMLDomainClaims(conn, existing); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := s.db.SAMLConnections().SaveSAMLConnection(r.Context(), conn)
+	if err != nil {
+		if errors.Is(err, database.ErrSAMLDomainTaken) {
+			writeCodedErrorResponse(w, "A domain is already verified by another organization", CodeSAMLDomainTaken, http.StatusConflict)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, s.samlConnectionResponse(org.ID, saved), http.StatusOK)
+}
+
+// keepSAMLDomainClaims carries the verification token and status of the
+// domains a connection already claimed over to its replacement, so saving
+// it again doesn't undo verification. New domains get a fresh token.
+func keepSAMLDomainClaims(conn, existing *database.SAMLConnection) error {
+	claimed := map[string]database.SAMLDomain{}
+	if existing != nil {
+		for _, domain := range existing.Domains {
+			claimed[domain.Domain] = domain
+		}
+	}
+
+	for i, domain := range conn.Domains {
+		if previous, ok := claimed[domain.Domain]; ok {
+			conn.Domains[i] = previous
+			continue
+		}
+		verificationToken, err := token.GenerateOpaque()
+		if err != nil {
+			return err
+		}
+		conn.Domains[i].VerificationToken = verificationToken
+	}
+	return nil
+}
+
+// VerifySAMLDomains checks DNS for the verification token of each of the
+// connection's unverified domains. A domain is verified once a TXT record
+// at _saml-verification.<domain> holds its token.
+func (s *Service) VerifySAMLDomains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	conn, err := s.db.SAMLConnections().GetSAMLConnection(ctx, org.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrSAMLConnectionNotFound) {
+			writeErrorResponse(w, "SAML is not configured for this organization", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	for _, domain := range conn.Domains {
+		if domain.Verified() || !s.publishesSAMLToken(ctx, domain) {
+			continue
+		}
+		if err := s.db.SAMLConnections().VerifySAMLDomain(ctx, org.ID, domain.Domain); err != nil {
+			if errors.Is(err, database.ErrSAMLDomainTaken) {
+				writeCodedErrorResponse(w, "A domain is already verified by another organization", CodeSAMLDomainTaken, http.StatusConflict)
+				return
+			}
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if conn, err = s.db.SAMLConnections().GetSAMLConnection(ctx, org.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, s.samlConnectionResponse(org.ID, conn), http.StatusOK)
+}
+
+// publishesSAMLToken reports whether a domain's DNS holds its verification
+// token. Lookup failures count as not published, so they can be retried.
+func (s *Service) publishesSAMLToken(ctx context.Context, domain database.SAMLDomain) bool {
+	records, err := s.lookupTXT(ctx, samlVerificationRecordPrefix+domain.Domain)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == domain.VerificationToken {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteSAMLConnection turns off SAML sign-in for an organization
+func (s *Service) DeleteSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	if err := s.db.SAMLConnections().DeleteSAMLConnection(r.Context(), org.ID); err != nil {
+		if errors.Is(err, database.ErrSAMLConnectionNotFound) {
+			writeErrorResponse(w, "SAML is not configured for this organization", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) samlConnectionResponse(organizationID int, conn *database.SAMLConnection) SAMLConnectionResponse {
+	return SAMLConnectionResponse{
+		Connection:  conn,
+		EntityID:    s.samlEntityID(organizationID),
+		ACSURL:      s.samlACSURL(organizationID),
+		MetadataURL: s.samlEntityID(organizationID),
+	}
+}
+
+// validateSAMLConnection checks a connection request and normalizes its
+// domains. The certificate and SSO URL are checked by setting up the
+// service provider with them.
+func (s *Service) validateSAMLConnection(organizationID int, req *SAMLConnectionRequest) (*database.SAMLConnection, error) {
+	conn := &database.SAMLConnection{
+		OrganizationID:    organizationID,
+		IdPEntityID:       strings.TrimSpace(req.IdPEntityID),
+		IdPSSOURL:         strings.TrimSpace(req.IdPSSOURL),
+		IdPCertificate:    strings.TrimSpace(req.IdPCertificate),
+		EmailAttribute:    strings.TrimSpace(req.EmailAttribute),
+		NameAttribute:     strings.TrimSpace(req.NameAttribute),
+		AllowIdPInitiated: req.AllowIdPInitiated,
+		JITProvisioning:   req.JITProvisioning,
+	}
+
+	if conn.IdPEntityID == "" || conn.IdPSSOURL == "" || conn.IdPCertificate == "" {
+		return nil, &ValidationError{"Identity provider entity ID, SSO URL and certificate are required"}
+	}
+	if u, err := url.Parse(conn.IdPSSOURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, &ValidationError{"SSO URL must be an https URL"}
+	}
+	if _, err := saml.ParseCertificate(conn.IdPCertificate); err != nil {
+		return nil, &ValidationError{"Certificate must be a PEM or base64 encoded X.509 certificate"}
+	}
+
+	seen := map[string]bool{}
+	var domains []string
+	for _, domain := range req.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || strings.ContainsAny(domain, "@/ ") || !strings.Contains(domain, ".") {
+			return nil, &ValidationError{"Domains must be bare domain names, such as example.com"}
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, &ValidationError{"At least one domain is required"}
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		conn.Domains = append(conn.Domains, database.SAMLDomain{Domain: domain})
+	}
+
+	return conn, nil
+}
+
+// SAMLMetadata serves our service provider metadata for an organization,
+// for its administrators to load into their identity provider
+func (s *Service) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Metadata doesn't depend on the connection, so it can be loaded into
+	// the identity provider before the connection is saved
+	id, err := strconv.Atoi(r.PathValue("org_id"))
+	if err != nil {
+		writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if _, err := s.db.Organizations().GetOrganization(r.Context(), id); err != nil {
+		if errors.Is(err, database.ErrOrganizationNotFound) {
+			writeErrorResponse(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(saml.Metadata(s.samlEntityID(id), s.samlACSURL(id)))
+}
+
+// StartSAMLLogin begins an SP-initiated login. The request ID is kept
+// server side so the response can be matched to it, and the state goes
+// to the identity provider as the relay state.
+func (s *Service) StartSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conn, ok := s.pathSAMLConnection(w, r)
+	if !ok {
+		return
+	}
+	sp, err := s.samlServiceProvider(conn)
+	if err != nil {
+		writeErrorResponse(w, "SAML connection is misconfigured", http.StatusInternalServerError)
+		return
+	}
+
+	requestID, errID := saml.NewRequestID()
+	state, errState := token.GenerateOpaque()
+	if errID != nil || errState != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	redirectURL, err := sp.AuthnRequestURL(requestID, state, now)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.db.SAMLLogins().CreateSAMLRequest(r.Context(), &database.SAMLRequest{
+		ID:             requestID,
+		OrganizationID: conn.OrganizationID,
+		ExpiresAt:      now.Add(samlRequestTTL),
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, SAMLStartResponse{
+		RedirectURL: redirectURL,
+		State:       state,
+		ExpiresIn:   int(samlRequestTTL.Seconds()),
+	}, http.StatusOK)
+}
+
+// samlLoginError carries the code the assertion consumer service redirects with
+type samlLoginError struct {
+	code string
+}
+
+func (e *samlLoginError) Error() string {
+	return "saml login refused: " + e.code
+}
+
+// SAMLAssertionConsumer receives the identity provider's response through
+// the browser. It verifies the response, finds or provisions the user and
+// redirects to the frontend with a one-time code for the session, or with
+// an error code.
+func (s *Service) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conn, ok := s.pathSAMLConnection(w, r)
+	if !ok {
+		return
+	}
+
+	relayState := r.PostFormValue("RelayState")
+	callback := func(params url.Values) {
+		if relayState != "" {
+			params.Set("state", relayState)
+		}
+		http.Redirect(w, r, config.GetAppConfig().AppBaseURL+"/saml/callback?"+params.Encode(), http.StatusSeeOther)
+	}
+
+	code, err := s.consumeSAMLResponse(r, conn, r.PostFormValue("SAMLResponse"))
+	if err != nil {
+		var refused *samlLoginError
+		if !errors.As(err, &refused) {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		callback(url.Values{"error": {refused.code}})
+		return
+	}
+
+	callback(url.Values{"code": {code}})
+}
+
+// consumeSAMLResponse verifies a response and returns a login code for its user
+func (s *Service) consumeSAMLResponse(r *http.Request, conn *database.SAMLConnection, encoded string) (string, error) {
+	ctx := r.Context()
+	now := time.Now()
+
+	sp, err := s.samlServiceProvider(conn)
+	if err != nil {
+		return "", err
+	}
+	assertion, err := sp.ParseResponse(encoded, now)
+	if err != nil {
+		return "", &samlLoginError{CodeSAMLResponseInvalid}
+	}
+
+	// A response to one of our requests has to answer a request made for
+	// this organization that hasn't been answered yet
+	if assertion.InResponseTo != "" {
+		request, err := s.db.SAMLLogins().ConsumeSAMLRequest(ctx, assertion.InResponseTo)
+		if err != nil && !errors.Is(err, database.ErrSAMLRequestNotFound) {
+			return "", err
+		}
+		if request == nil || request.OrganizationID != conn.OrganizationID || now.After(request.ExpiresAt) {
+			return "", &samlLoginError{CodeSAMLResponseInvalid}
+		}
+	} else if !conn.AllowIdPInitiated {
+		return "", &samlLoginError{CodeSAMLIdPInitiatedDisabled}
+	}
+
+	if err := s.db.SAMLLogins().RecordSAMLAssertion(ctx, assertion.Issuer, assertion.ID, assertion.NotOnOrAfter); err != nil {
+		if errors.Is(err, database.ErrSAMLAssertionReplayed) {
+			return "", &samlLoginError{CodeSAMLAssertionReplayed}
+		}
+		return "", err
+	}
+
+	user, err := s.samlUser(r, conn, assertion)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := token.GenerateOpaque()
+	if err != nil {
+		return "", err
+	}
+	err = s.db.SAMLLogins().CreateSAMLLoginCode(ctx, &database.SAMLLoginCode{
+		CodeHash:       token.HashOpaque(code),
+		UserID:         user.ID,
+		OrganizationID: conn.OrganizationID,
+		ExpiresAt:      now.Add(samlLoginCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// samlUser returns the user an assertion is about. The email must be in
+// one of the organization's verified domains. Existing accounts must
+// belong to the organization and nothing else, so an identity provider
+// can't take over accounts it doesn't manage. New users are created, as
+// members, only if the connection provisions them.
+func (s *Service) samlUser(r *http.Request, conn *database.SAMLConnection, assertion *saml.Assertion) (*User, error) {
+	ctx := r.Context()
+
+	email := assertion.NameID
+	if conn.EmailAttribute != "" && assertion.Attribute(conn.EmailAttribute) != "" {
+		email = assertion.Attribute(conn.EmailAttribute)
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !samlDomainAllowed(conn, email) {
+		return nil, &samlLoginError{CodeSAMLEmailNotAllowed}
+	}
+
+	nameAttribute := conn.NameAttribute
+	if nameAttribute == "" {
+		nameAttribute = defaultSAMLNameAttribute
+	}
+	name := assertion.Attribute(nameAttribute)
+
+	user, err := s.db.Users().GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if user == nil {
+		if !conn.JITProvisioning {
+			return nil, &samlLoginError{CodeSAMLNotProvisioned}
+		}
+		if user, err = s.createPasswordlessUser(ctx, email, name); err != nil {
+			return nil, err
+		}
+		if _, err := s.db.Organizations().AddMember(ctx, conn.OrganizationID, user.ID, database.OrgRoleMember); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if _, err := s.db.Organizations().GetMembership(ctx, conn.OrganizationID, user.ID); err != nil {
+		if errors.Is(err, database.ErrMembershipNotFound) {
+			return nil, &samlLoginError{CodeSAMLAccountNotMember}
+		}
+		return nil, err
+	}
+
+	// A session reaches all of the account's organizations and roles, which
+	// this identity provider doesn't vouch for
+	orgs, err := s.db.Organizations().ListUserOrganizations(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.db.Roles().ListUserRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) != 1 || len(roles) > 0 {
+		return nil, &samlLoginError{CodeSAMLAccountNotManaged}
+	}
+
+	// The identity provider is the source of truth for its users' names
+	if name != "" && name != user.Name {
+		user.Name = name
+		if user, err = s.db.Users().UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// samlDomainAllowed reports whether an email address is in one of the
+// connection's verified domains
+func samlDomainAllowed(conn *database.SAMLConnection, email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range conn.Domains {
+		if allowed.Verified() && strings.EqualFold(domain, allowed.Domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// FinishSAMLLogin exchanges the code from the assertion consumer service
+// for a session in the organization
+func (s *Service) FinishSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SAMLSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		writeErrorResponse(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	code, err := s.db.SAMLLogins().ConsumeSAMLLoginCode(ctx, token.HashOpaque(req.Code))
+	if err != nil && !errors.Is(err, database.ErrSAMLLoginCodeNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if code == nil || time.Now().After(code.ExpiresAt) {
+		writeCodedErrorResponse(w, "Invalid or expired login code", CodeSAMLCodeInvalid, http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeCodedErrorResponse(w, "Invalid or expired login code", CodeSAMLCodeInvalid, http.StatusBadRequest)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The identity provider vouches for who the user is, not for the
+	// second factor
+	if user.TOTPEnabled {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSONResponse(w, challenge, http.StatusOK)
+		return
+	}
+
+	session, err := s.openSession(w, r, user, AuthMethodSAML)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	if err := s.db.Sessions().SetSessionOrganization(ctx, session.ID, code.OrganizationID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	session.OrganizationID = code.OrganizationID
+
+	response, err := s.issueTokenPair(r, user, session)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// HandleGetSAMLConnection is a wrapper around the service GetSAMLConnection method
+func HandleGetSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.GetSAMLConnection(w, r)
+}
+
+// HandleSaveSAMLConnection is a wrapper around the service SaveSAMLConnection method
+func HandleSaveSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.SaveSAMLConnection(w, r)
+}
+
+// HandleVerifySAMLDomains is a wrapper around the service VerifySAMLDomains method
+func HandleVerifySAMLDomains(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.VerifySAMLDomains(w, r)
+}
+
+// HandleDeleteSAMLConnection is a wrapper around the service DeleteSAMLConnection method
+func HandleDeleteSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteSAMLConnection(w, r)
+}
+
+// HandleSAMLMetadata is a wrapper around the service SAMLMetadata method
+func HandleSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.SAMLMetadata(w, r)
+}
+
+// HandleStartSAMLLogin is a wrapper around the service StartSAMLLogin method
+func HandleStartSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.StartSAMLLogin(w, r)
+}
+
+// HandleSAMLAssertionConsumer is a wrapper around the service SAMLAssertionConsumer method
+func HandleSAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.SAMLAssertionConsumer(w, r)
+}
+
+// HandleFinishSAMLLogin is a wrapper around the service FinishSAMLLogin method
+func HandleFinishSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.FinishSAMLLogin(w, r)
+}
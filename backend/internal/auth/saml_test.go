@@ -0,0 +1,483 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+	"github.com/danielsaas/generic-saas/internal/saml/samltest"
+)
+
+// serveSAML routes a request through the public SAML endpoints
+func serveSAML(service *Service, method, path string, req *http.Request) *httptest.ResponseRecorder {
+	if req == nil {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	return serveRoutes(service, req)
+}
+
+// samlConnectionBody configures a connection to the identity provider for
+// the domains
+func samlConnectionBody(idp *samltest.IdP, domains ...string) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"idp_entity_id":   idp.EntityID,
+		"idp_sso_url":     idp.SSOURL,
+		"idp_certificate": idp.CertificatePEM(),
+		"domains":         domains,
+	})
+	return string(body)
+}
+
+// fakeTXT is a DNS zone of TXT records by name
+type fakeTXT map[string][]string
+
+func (z fakeTXT) lookup(ctx context.Context, name string) ([]string, error) {
+	if records, ok := z[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// publishSAMLTokens adds the organization's verification tokens to the zone,
+// as the owner of its domains would
+func publishSAMLTokens(t *testing.T, db database.Database, org *database.UserOrganization, zone fakeTXT) {
+	t.Helper()
+
+	conn, err := db.SAMLConnections().GetSAMLConnection(context.Background(), org.ID)
+	if err != nil {
+		t.Fatalf("Failed to get the connection: %v", err)
+	}
+	for _, domain := range conn.Domains {
+		name := "_saml-verification." + domain.Domain
+		zone[name] = append(zone[name], domain.VerificationToken)
+	}
+}
+
+// setupSAML gives Acme, owned by John with Jane as a member, a connection
+// to a fake identity provider for example.com addresses, and verifies the
+// domain
+func setupSAML(t *testing.T, settings string) (*Service, database.Database, *database.UserOrganization, AuthResponse, *samltest.IdP) {
+	t.Helper()
+
+	service, db, org, john, _ := setupOrganization(t)
+	service.samlBaseURL = "https://api.example.com"
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+
+	body := samlConnectionBody(idp, "Example.com")
+	if settings != "" {
+		body = body[:len(body)-1] + ", " + settings + "}"
+	}
+
+	rr := serveAPI(service, "PUT", organizationPath(org, "/saml"), body, john.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Saving the connection failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	zone := fakeTXT{}
+	service.lookupTXT = zone.lookup
+	publishSAMLTokens(t, db, org, zone)
+	rr = serveAPI(service, "POST", organizationPath(org, "/saml/verify"), "", john.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Verifying the domain failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	return service, db, org, john, idp
+}
+
+func samlPath(org *database.UserOrganization, suffix string) string {
+	return "/auth/saml/" + strconv.Itoa(org.ID) + suffix
+}
+
+// samlResponseFor answers an authentication request for the user
+func samlResponseFor(service *Service, org *database.UserOrganization, inResponseTo, email string) samltest.Response {
+	return samltest.Response{
+		ACSURL:       service.samlACSURL(org.ID),
+		Audience:     service.samlEntityID(org.ID),
+		InResponseTo: inResponseTo,
+		NameID:       email,
+		Attributes:   []samltest.Attribute{{Name: "name", Value: "Jane Doe"}},
+	}
+}
+
+// postACS posts a response to the assertion consumer service and returns
+// the parameters of the frontend callback it redirects to
+func postACS(t *testing.T, service *Service, org *database.UserOrganization, response, relayState string) url.Values {
+	t.Helper()
+
+	form := url.Values{"SAMLResponse": {response}, "RelayState": {relayState}}
+	req := httptest.NewRequest("POST", samlPath(org, "/acs"), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := serveSAML(service, "", "", req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || !strings.HasSuffix(location.Path, "/saml/callback") {
+		t.Fatalf("Expected a redirect to the frontend callback, got %q", rr.Header().Get("Location"))
+	}
+	return location.Query()
+}
+
+func finishSAML(service *Service, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/auth/saml/session", strings.NewReader(`{"code": "`+code+`"}`))
+	return serveSAML(service, "", "", req)
+}
+
+func TestSAMLConnection_Configure(t *testing.T) {
+	service, _, org, john, jane := setupOrganization(t)
+	service.samlBaseURL = "https://api.example.com"
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+	path := organizationPath(org, "/saml")
+
+	if rr := serveAPI(service, "GET", path, "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "GET", path, "", john.Token)
+	var response SAMLConnectionResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || response.Connection != nil {
+		t.Fatalf("Expected no connection yet, got %d: %s", rr.Code, rr.Body.String())
+	}
+	wantACS := "https://api.example.com/auth/saml/" + strconv.Itoa(org.ID) + "/acs"
+	if response.ACSURL != wantACS || response.EntityID != response.MetadataURL || !strings.HasSuffix(response.EntityID, "/metadata") {
+		t.Errorf("Unexpected service provider details: %+v", response)
+	}
+
+	valid := func(change func(map[string]interface{})) string {
+		body := map[string]interface{}{
+			"idp_entity_id":   idp.EntityID,
+			"idp_sso_url":     idp.SSOURL,
+			"idp_certificate": idp.CertificatePEM(),
+			"domains":         []string{" Example.com ", "example.com", "example.org"},
+		}
+		change(body)
+		encoded, _ := json.Marshal(body)
+		return string(encoded)
+	}
+	invalid := map[string]string{
+		"no certificate":   valid(func(b map[string]interface{}) { b["idp_certificate"] = "garbage" }),
+		"http SSO URL":     valid(func(b map[string]interface{}) { b["idp_sso_url"] = "http://idp.example.com/sso" }),
+		"no domains":       valid(func(b map[string]interface{}) { b["domains"] = []string{} }),
+		"email as domain":  valid(func(b map[string]interface{}) { b["domains"] = []string{"john@example.com"} }),
+		"no entity ID":     valid(func(b map[string]interface{}) { b["idp_entity_id"] = "" }),
+		"malformed domain": valid(func(b map[string]interface{}) { b["domains"] = []string{"localhost"} }),
+	}
+	for name, body := range invalid {
+		if rr := serveAPI(service, "PUT", path, body, john.Token); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusBadRequest, rr.Code)
+		}
+	}
+
+	rr = serveAPI(service, "PUT", path, valid(func(map[string]interface{}) {}), john.Token)
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || response.Connection == nil {
+		t.Fatalf("Expected the connection to be saved, got %d: %s", rr.Code, rr.Body.String())
+	}
+	domains := response.Connection.Domains
+	if len(domains) != 2 || domains[0].Domain != "example.com" || domains[1].Domain != "example.org" {
+		t.Fatalf("Expected domains to be normalized, got %+v", domains)
+	}
+	if domains[0].VerificationToken == "" || domains[0].Verified() {
+		t.Errorf("Expected a new domain to have a token and no verification, got %+v", domains[0])
+	}
+
+	// A connection can't be used before a domain is verified
+	if rr := serveSAML(service, "POST", samlPath(org, "/login"), nil); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeSAMLDomainUnverified) {
+		t.Errorf("Expected login with unverified domains to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	zone := fakeTXT{"_saml-verification.example.com": {"someone-elses-token", domains[0].VerificationToken}}
+	service.lookupTXT = zone.lookup
+	rr = serveAPI(service, "POST", path+"/verify", "", john.Token)
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.Connection.Domains[0].Verified() || response.Connection.Domains[1].Verified() {
+		t.Fatalf("Expected only the published domain to be verified, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "POST", path+"/verify", "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused, got %d", rr.Code)
+	}
+
+	// Saving again keeps the tokens and what was verified
+	rr = serveAPI(service, "PUT", path, valid(func(map[string]interface{}) {}), john.Token)
+	var saved SAMLConnectionResponse
+	json.NewDecoder(rr.Body).Decode(&saved)
+	if rr.Code != http.StatusOK || !saved.Connection.Domains[0].Verified() || saved.Connection.Domains[1].VerificationToken != domains[1].VerificationToken {
+		t.Errorf("Expected the domains to be kept, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveSAML(service, "POST", samlPath(org, "/login"), nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected login with a verified domain to start, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := serveAPI(service, "DELETE", path, "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	if rr := serveAPI(service, "DELETE", path, "", john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a second delete to find nothing, got %d", rr.Code)
+	}
+	if rr := serveSAML(service, "POST", samlPath(org, "/login"), nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected login without a connection to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	service, _, org, _, _ := setupSAML(t, "")
+
+	rr := serveSAML(service, "GET", samlPath(org, "/metadata"), nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/samlmetadata+xml" {
+		t.Fatalf("Expected metadata, got %d (%s)", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `entityID="`+service.samlEntityID(org.ID)+`"`) || !strings.Contains(body, service.samlACSURL(org.ID)) {
+		t.Errorf("Expected the entity ID and ACS URL in the metadata, got %s", body)
+	}
+
+	if rr := serveSAML(service, "GET", "/auth/saml/999/metadata", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown organization to return %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestSAML_SPInitiatedLogin(t *testing.T) {
+	service, db, org, _, idp := setupSAML(t, "")
+
+	rr := serveSAML(service, "POST", samlPath(org, "/login"), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Start failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	var start SAMLStartResponse
+	json.NewDecoder(rr.Body).Decode(&start)
+
+	request, err := samltest.ParseRequestURL(start.RedirectURL)
+	if err != nil {
+		t.Fatalf("ParseRequestURL() error = %v", err)
+	}
+	if request.RelayState != start.State || request.ACSURL != service.samlACSURL(org.ID) || request.Issuer != service.samlEntityID(org.ID) {
+		t.Fatalf("Unexpected authentication request: %+v", request)
+	}
+
+	response := idp.Respond(samlResponseFor(service, org, request.ID, "Jane@Example.com"))
+	callback := postACS(t, service, org, response, request.RelayState)
+	if callback.Get("state") != start.State || callback.Get("code") == "" {
+		t.Fatalf("Expected a code and the state on the callback, got %v", callback)
+	}
+
+	rr = finishSAML(service, callback.Get("code"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var session AuthResponse
+	json.NewDecoder(rr.Body).Decode(&session)
+	if session.Token == "" || session.User.Email != "jane@example.com" || session.User.Name != "Jane Doe" {
+		t.Errorf("Expected Jane to sign in with the name from the identity provider, got %+v", session)
+	}
+
+	sessions, _ := db.Sessions().ListUserSessions(context.Background(), session.User.ID)
+	var samlSession *database.Session
+	for _, s := range sessions {
+		if s.AuthMethod == AuthMethodSAML {
+			samlSession = s
+		}
+	}
+	if samlSession == nil || samlSession.OrganizationID != org.ID {
+		t.Errorf("Expected a SAML session in Acme, got %+v", samlSession)
+	}
+
+	if rr := finishSAML(service, callback.Get("code")); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeSAMLCodeInvalid) {
+		t.Errorf("Expected a login code to work once, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The request was answered, so posting the response again fails
+	callback = postACS(t, service, org, response, request.RelayState)
+	if callback.Get("error") != CodeSAMLResponseInvalid || callback.Get("code") != "" {
+		t.Errorf("Expected an answered request to be refused, got %v", callback)
+	}
+}
+
+func TestSAML_IdPInitiatedLogin(t *testing.T) {
+	service, db, org, _, idp := setupSAML(t, "")
+	response := idp.Respond(samlResponseFor(service, org, "", "jane@example.com"))
+
+	callback := postACS(t, service, org, response, "")
+	if callback.Get("error") != CodeSAMLIdPInitiatedDisabled {
+		t.Errorf("Expected IdP-initiated login to be off by default, got %v", callback)
+	}
+
+	conn, _ := db.SAMLConnections().GetSAMLConnection(context.Background(), org.ID)
+	conn.AllowIdPInitiated = true
+	db.SAMLConnections().SaveSAMLConnection(context.Background(), conn)
+
+	// The refused attempt didn't use up the assertion
+	callback = postACS(t, service, org, response, "")
+	if callback.Get("code") == "" {
+		t.Fatalf("Expected IdP-initiated login to succeed, got %v", callback)
+	}
+	if rr := finishSAML(service, callback.Get("code")); rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	callback = postACS(t, service, org, response, "")
+	if callback.Get("error") != CodeSAMLAssertionReplayed {
+		t.Errorf("Expected a replayed assertion to be refused, got %v", callback)
+	}
+}
+
+func TestSAML_JITProvisioning(t *testing.T) {
+	service, db, org, _, idp := setupSAML(t, `"allow_idp_initiated": true`)
+	ctx := context.Background()
+
+	callback := postACS(t, service, org, idp.Respond(samlResponseFor(service, org, "", "newbie@example.com")), "")
+	if callback.Get("error") != CodeSAMLNotProvisioned {
+		t.Errorf("Expected unknown users to be refused without provisioning, got %v", callback)
+	}
+	if _, err := db.Users().GetUserByEmail(ctx, "newbie@example.com"); err == nil {
+		t.Error("Expected no account to be created")
+	}
+
+	conn, _ := db.SAMLConnections().GetSAMLConnection(ctx, org.ID)
+	conn.JITProvisioning = true
+	db.SAMLConnections().SaveSAMLConnection(ctx, conn)
+
+	callback = postACS(t, service, org, idp.Respond(samlResponseFor(service, org, "", "newbie@example.com")), "")
+	rr := finishSAML(service, callback.Get("code"))
+	var session AuthResponse
+	json.NewDecoder(rr.Body).Decode(&session)
+	if rr.Code != http.StatusOK || session.User.Name != "Jane Doe" || !session.User.EmailVerified() {
+		t.Fatalf("Expected a verified account to be provisioned, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	membership, err := db.Organizations().GetMembership(ctx, org.ID, session.User.ID)
+	if err != nil || membership.Role != database.OrgRoleMember {
+		t.Errorf("Expected the new user to join Acme as a member, got %+v, %v", membership, err)
+	}
+}
+
+func TestSAML_RefusedLogins(t *testing.T) {
+	service, db, org, _, idp := setupSAML(t, `"allow_idp_initiated": true, "jit_provisioning": true`)
+	ctx := context.Background()
+
+	// An account outside the organization can't be taken over, even with
+	// provisioning on
+	db.Users().CreateUser(ctx, &database.User{Name: "Bob", Email: "bob@example.com"})
+
+	// Nor can members whose accounts reach beyond it: Carol also owns
+	// Initech, and Dave has a role across the whole service
+	carol, _ := db.Users().CreateUser(ctx, &database.User{Name: "Carol", Email: "carol@example.com"})
+	db.Organizations().CreateOrganization(ctx, &database.Organization{Name: "Initech"}, carol.ID)
+	db.Organizations().AddMember(ctx, org.ID, carol.ID, database.OrgRoleMember)
+	dave, _ := db.Users().CreateUser(ctx, &database.User{Name: "Dave", Email: "dave@example.com"})
+	db.Organizations().AddMember(ctx, org.ID, dave.ID, database.OrgRoleMember)
+	db.Roles().CreateRole(ctx, &database.Role{Name: "support", Permissions: []string{rbac.PermissionUsersRead}})
+	db.Roles().AssignRole(ctx, dave.ID, "support")
+
+	// Domains only count once verified
+	conn, _ := db.SAMLConnections().GetSAMLConnection(ctx, org.ID)
+	conn.Domains = append(conn.Domains, database.SAMLDomain{Domain: "example.net", VerificationToken: "unpublished"})
+	db.SAMLConnections().SaveSAMLConnection(ctx, conn)
+
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{"outside the domains", idp.Respond(samlResponseFor(service, org, "", "eve@evil.com")), CodeSAMLEmailNotAllowed},
+		{"unverified domain", idp.Respond(samlResponseFor(service, org, "", "eve@example.net")), CodeSAMLEmailNotAllowed},
+		{"not a member", idp.Respond(samlResponseFor(service, org, "", "bob@example.com")), CodeSAMLAccountNotMember},
+		{"member of another organization", idp.Respond(samlResponseFor(service, org, "", "carol@example.com")), CodeSAMLAccountNotManaged},
+		{"holds a role", idp.Respond(samlResponseFor(service, org, "", "dave@example.com")), CodeSAMLAccountNotManaged},
+		{"signed by another key", samltest.NewIdP(idp.EntityID).Respond(samlResponseFor(service, org, "", "jane@example.com")), CodeSAMLResponseInvalid},
+		{"unknown request", idp.Respond(samlResponseFor(service, org, "_unknown", "jane@example.com")), CodeSAMLResponseInvalid},
+		{"garbage", "not a response", CodeSAMLResponseInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callback := postACS(t, service, org, tt.response, "relay")
+			if callback.Get("error") != tt.want || callback.Get("state") != "relay" || callback.Get("code") != "" {
+				t.Errorf("Expected error %s, got %v", tt.want, callback)
+			}
+		})
+	}
+}
+
+func TestSAML_RequestForAnotherOrganization(t *testing.T) {
+	service, db, org, john, idp := setupSAML(t, "")
+
+	// A request started for Globex can't be answered at Acme's endpoint
+	rr := serveAPI(service, "POST", "/api/organizations", `{"name": "Globex"}`, john.Token)
+	var globex database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&globex)
+	serveAPI(service, "PUT", organizationPath(&globex, "/saml"), samlConnectionBody(idp, "globex.com"), john.Token)
+	zone := fakeTXT{}
+	service.lookupTXT = zone.lookup
+	publishSAMLTokens(t, db, &globex, zone)
+	serveAPI(service, "POST", organizationPath(&globex, "/saml/verify"), "", john.Token)
+
+	rr = serveSAML(service, "POST", samlPath(&globex, "/login"), nil)
+	var start SAMLStartResponse
+	json.NewDecoder(rr.Body).Decode(&start)
+	request, _ := samltest.ParseRequestURL(start.RedirectURL)
+
+	callback := postACS(t, service, org, idp.Respond(samlResponseFor(service, org, request.ID, "jane@example.com")), "")
+	if callback.Get("error") != CodeSAMLResponseInvalid {
+		t.Errorf("Expected another organization's request to be refused, got %v", callback)
+	}
+}
+
+func TestSAMLConnection_DomainSquatting(t *testing.T) {
+	service, db, org, john, jane := setupOrganization(t)
+	service.samlBaseURL = "https://api.example.com"
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+	zone := fakeTXT{}
+	service.lookupTXT = zone.lookup
+
+	// Jane claims example.com for an organization of their own before Acme
+	// gets round to it
+	rr := serveAPI(service, "POST", "/api/organizations", `{"name": "Squatters"}`, jane.Token)
+	var squatters database.UserOrganization
+	json.NewDecoder(rr.Body).Decode(&squatters)
+	squattersPath := organizationPath(&squatters, "/saml")
+	if rr := serveAPI(service, "PUT", squattersPath, samlConnectionBody(idp, "example.com"), jane.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected anyone to claim a domain, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Without the DNS record the claim is useless
+	if rr := serveAPI(service, "POST", squattersPath+"/verify", "", jane.Token); rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), `"verified_at":"`) {
+		t.Errorf("Expected the domain to stay unverified, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveSAML(service, "POST", samlPath(&squatters, "/login"), nil); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), CodeSAMLDomainUnverified) {
+		t.Errorf("Expected the squatted connection to be unusable, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The claim doesn't stop the real owner of the domain
+	if rr := serveAPI(service, "PUT", organizationPath(org, "/saml"), samlConnectionBody(idp, "example.com"), john.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected Acme to claim the domain too, got %d: %s", rr.Code, rr.Body.String())
+	}
+	publishSAMLTokens(t, db, org, zone)
+	rr = serveAPI(service, "POST", organizationPath(org, "/saml/verify"), "", john.Token)
+	var response SAMLConnectionResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusOK || !response.Connection.Domains[0].Verified() {
+		t.Fatalf("Expected Acme to verify the domain, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Once verified, the domain is Acme's alone, even if the squatter's
+	// token turns up in DNS too
+	publishSAMLTokens(t, db, &squatters, zone)
+	if rr := serveAPI(service, "POST", squattersPath+"/verify", "", jane.Token); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeSAMLDomainTaken) {
+		t.Errorf("Expected a verified domain to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "PUT", squattersPath, samlConnectionBody(idp, "example.com"), jane.Token); rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), CodeSAMLDomainTaken) {
+		t.Errorf("Expected a verified domain not to be claimable, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := serveSAML(service, "POST", samlPath(org, "/login"), nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected Acme's connection to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
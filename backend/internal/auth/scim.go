@@ -0,0 +1,1632 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/scim"
+)
+
+// maxSCIMTokenNameLength caps the label owners give their SCIM tokens
+const maxSCIMTokenNameLength = 64
+
+const (
+	// scimTokenTouchInterval limits how often a token's last use is
+	// written, as identity providers sync in bursts
+	scimTokenTouchInterval = time.Minute
+
+	// defaultSCIMPageSize is the count of a query that doesn't ask for one,
+	// and maxSCIMPageSize the most one can ask for
+	defaultSCIMPageSize = 100
+	maxSCIMPageSize     = 200
+)
+
+// SCIMTokensResponse is the body of GET /api/organizations/{id}/scim/tokens
+type SCIMTokensResponse struct {
+	Tokens  []*database.SCIMToken `json:"tokens"`
+	BaseURL string                `json:"scim_base_url"`
+}
+
+// CreateSCIMTokenRequest is the body of POST /api/organizations/{id}/scim/tokens
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateSCIMTokenResponse returns a new token with where the identity
+// provider should send requests. Token is shown only this once.
+type CreateSCIMTokenResponse struct {
+	Token     string              `json:"token"`
+	SCIMToken *database.SCIMToken `json:"scim_token"`
+	BaseURL   string              `json:"scim_base_url"`
+}
+
+// ListSCIMTokens returns an organization's SCIM tokens to its owner
+func (s *Service) ListSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	tokens, err := s.db.SCIMTokens().ListSCIMTokens(r.Context(), org.ID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, SCIMTokensResponse{Tokens: tokens, BaseURL: s.scimBaseURL}, http.StatusOK)
+}
+
+// CreateSCIMToken creates a token for an organization's identity provider
+// to provision its users with
+func (s *Service) CreateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, membership, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	var req CreateSCIMTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxSCIMTokenNameLength {
+		writeErrorResponse(w, "Name is required and must be at most "+strconv.Itoa(maxSCIMTokenNameLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	raw, prefix, err := scim.GenerateToken()
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := s.db.SCIMTokens().CreateSCIMToken(r.Context(), &database.SCIMToken{
+		OrganizationID: org.ID,
+		Name:           name,
+		Prefix:         prefix,
+		TokenHash:      scim.HashToken(raw),
+		CreatedBy:      membership.UserID,
+	})
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, CreateSCIMTokenResponse{Token: raw, SCIMToken: token, BaseURL: s.scimBaseURL}, http.StatusCreated)
+}
+
+// DeleteSCIMToken revokes one of an organization's SCIM tokens
+func (s *Service) DeleteSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org, _, ok := s.pathOrganization(w, r, database.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("token_id"))
+	if err != nil {
+		writeErrorResponse(w, "SCIM token not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.SCIMTokens().DeleteSCIMToken(r.Context(), org.ID, id); err != nil {
+		if errors.Is(err, database.ErrSCIMTokenNotFound) {
+			writeErrorResponse(w, "SCIM token not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimOrganization authenticates a SCIM request by its bearer token and
+// returns the ID of the organization the token belongs to. It writes the
+// error and returns false if the token isn't valid.
+func (s *Service) scimOrganization(w http.ResponseWriter, r *http.Request) (int, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || !scim.IsToken(strings.TrimSpace(parts[1])) {
+		writeSCIMUnauthorized(w)
+		return 0, false
+	}
+
+	ctx := r.Context()
+	token, err := s.db.SCIMTokens().GetSCIMTokenByHash(ctx, scim.HashToken(strings.TrimSpace(parts[1])))
+	if err != nil {
+		if errors.Is(err, database.ErrSCIMTokenNotFound) {
+			writeSCIMUnauthorized(w)
+			return 0, false
+		}
+		writeSCIMError(w, err)
+		return 0, false
+	}
+
+	// Recording use is best effort, like it is for API keys
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= scimTokenTouchInterval {
+		s.db.SCIMTokens().TouchSCIMToken(ctx, token.ID, now)
+	}
+
+	return token.OrganizationID, true
+}
+
+func writeSCIMUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+	writeSCIMError(w, scim.NewError(http.StatusUnauthorized, "", "Invalid or missing SCIM token"))
+}
+
+func writeSCIMResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", scim.MediaType)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeSCIMError writes an error as a SCIM error response. Errors that
+// aren't SCIM errors are internal.
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "Internal server error")
+	}
+	writeSCIMResponse(w, scimErr.Response(), scimErr.Status)
+}
+
+func scimMethodNotAllowed() error {
+	return scim.NewError(http.StatusMethodNotAllowed, "", "Method not allowed")
+}
+
+// decodeSCIMRequest reads a request body
+func decodeSCIMRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "Invalid request body")
+	}
+	return nil
+}
+
+// parseSCIMQuery reads the filter, startIndex and count of a query. Out of
+// range indexes and counts are clamped, as RFC 7644 §3.4.2.4 asks.
+func parseSCIMQuery(r *http.Request) (*scim.Filter, int, int, error) {
+	query := r.URL.Query()
+	startIndex, count := 1, defaultSCIMPageSize
+
+	var filter *scim.Filter
+	if expression := query.Get("filter"); expression != "" {
+		var err error
+		if filter, err = scim.ParseFilter(expression); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if value := query.Get("startIndex"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Invalid startIndex")
+		}
+		startIndex = max(n, 1)
+	}
+	if value := query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Invalid count")
+		}
+		count = min(max(n, 0), maxSCIMPageSize)
+	}
+	return filter, startIndex, count, nil
+}
+
+// scimTime formats a time for filters, which compare times as strings
+func scimTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (s *Service) scimUserLocation(userID int) string {
+	return s.scimBaseURL + "/Users/" + strconv.Itoa(userID)
+}
+
+func (s *Service) scimGroupLocation(groupID int) string {
+	return s.scimBaseURL + "/Groups/" + strconv.Itoa(groupID)
+}
+
+// scimUserChanges is the state of a managed user a request asks for
+type scimUserChanges struct {
+	email      string
+	name       string // Left as it is if empty
+	externalID string
+	active     bool
+}
+
+// scimUserChangesFrom reads the state a User resource asks for. Active is
+// kept as it is if the resource leaves it out.
+func scimUserChangesFrom(req *scim.User, active bool) (scimUserChanges, error) {
+	changes := scimUserChanges{
+		email:      strings.ToLower(strings.TrimSpace(req.UserName)),
+		name:       strings.TrimSpace(req.DisplayName),
+		externalID: strings.TrimSpace(req.ExternalID),
+		active:     active,
+	}
+	if changes.name == "" {
+		changes.name = req.Name.Joined()
+	}
+	if req.Active != nil {
+		changes.active = *req.Active
+	}
+
+	// Some identity providers send a user principal name as the userName.
+	// The primary email address is used then.
+	if !isValidEmail(changes.email) {
+		for _, email := range req.Emails {
+			if email.Primary && isValidEmail(strings.TrimSpace(email.Value)) {
+				changes.email = strings.ToLower(strings.TrimSpace(email.Value))
+			}
+		}
+	}
+	if !isValidEmail(changes.email) {
+		return changes, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "userName must be an email address")
+	}
+	return changes, nil
+}
+
+// errSCIMDomainNotAllowed refuses an address outside the organization's
+// verified domains
+var errSCIMDomainNotAllowed = scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "userName must be in one of the organization's verified domains")
+
+// scimDomainAllowed reports whether an address is in one of the domains the
+// organization verified for SAML. SCIM sets addresses without anyone
+// proving them, so it may only use ones the organization owns.
+func (s *Service) scimDomainAllowed(ctx context.Context, organizationID int, email string) (bool, error) {
+	conn, err := s.db.SAMLConnections().GetSAMLConnection(ctx, organizationID)
+	if err != nil {
+		if errors.Is(err, database.ErrSAMLConnectionNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return samlDomainAllowed(conn, email), nil
+}
+
+// saveSCIMUser applies changes to a managed user. Deactivating suspends
+// the account and signs it out everywhere. Reactivating lifts only the
+// suspension deactivating caused, not one an administrator made. A new
+// address also signs the account out, and the old address is told.
+func (s *Service) saveSCIMUser(r *http.Request, scimUser *database.SCIMUser, user *User, changes scimUserChanges) (*database.SCIMUser, *User, error) {
+	ctx := r.Context()
+
+	// Postgres keeps microseconds, so the times stay equal once stored
+	now := time.Now().Truncate(time.Microsecond)
+
+	userChanged := false
+	previousEmail := ""
+	if changes.email != user.Email {
+		allowed, err := s.scimDomainAllowed(ctx, scimUser.OrganizationID, changes.email)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !allowed {
+			return nil, nil, errSCIMDomainNotAllowed
+		}
+
+		// The organization owns the domain, so it vouches for the address
+		previousEmail = user.Email
+		user.Email = changes.email
+		user.EmailVerifiedAt = &now
+		userChanged = true
+	}
+	if changes.name != "" && changes.name != user.Name {
+		user.Name = changes.name
+		userChanged = true
+	}
+
+	deactivated := false
+	scimUserChanged := changes.externalID != scimUser.ExternalID
+	scimUser.ExternalID = changes.externalID
+	switch {
+	case !changes.active && scimUser.DeactivatedAt == nil:
+		scimUser.DeactivatedAt = &now
+		if !user.Suspended() {
+			user.SuspendedAt = &now
+			userChanged = true
+		}
+		deactivated, scimUserChanged = true, true
+	case changes.active && scimUser.DeactivatedAt != nil:
+		if user.SuspendedAt != nil && user.SuspendedAt.Equal(*scimUser.DeactivatedAt) {
+			user.SuspendedAt = nil
+			userChanged = true
+		}
+		scimUser.DeactivatedAt = nil
+		scimUserChanged = true
+	}
+
+	if scimUserChanged {
+		updated, err := s.db.SCIMUsers().UpdateSCIMUser(ctx, scimUser)
+		if err != nil {
+			if errors.Is(err, database.ErrSCIMExternalIDTaken) {
+				return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another user has this externalId")
+			}
+			return nil, nil, err
+		}
+		scimUser = updated
+	}
+
+	if userChanged {
+		updated, err := s.db.Users().UpdateUser(ctx, user)
+		if err != nil {
+			if errors.Is(err, database.ErrUserAlreadyExists) {
+				return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another account has this userName")
+			}
+			return nil, nil, err
+		}
+		user = updated
+	}
+
+	if deactivated || previousEmail != "" {
+		if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if previousEmail != "" {
+		s.sendSecurityAlertTo(r, previousEmail, "Your organization's identity provider changed the email address of your account to "+
+			user.Email+", and every device was signed out. If you didn't expect this, contact your organization's administrator.")
+	}
+	return scimUser, user, nil
+}
+
+// provisionSCIMUser creates a user for an organization's identity
+// provider. The address has to be in one of the organization's verified
+// domains. An account that already exists is taken over only if it is one
+// SCIM could have made, as SCIM can change its address and suspend it.
+func (s *Service) provisionSCIMUser(r *http.Request, organizationID int, req *scim.User) (*database.SCIMUser, *User, error) {
+	ctx := r.Context()
+	changes, err := scimUserChangesFrom(req, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowed, err := s.scimDomainAllowed(ctx, organizationID, changes.email)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !allowed {
+		return nil, nil, errSCIMDomainNotAllowed
+	}
+
+	// Checked up front so a new account isn't left behind if it clashes
+	if changes.externalID != "" {
+		managed, err := s.db.SCIMUsers().ListSCIMUsers(ctx, organizationID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, scimUser := range managed {
+			if scimUser.ExternalID == changes.externalID {
+				return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another user has this externalId")
+			}
+		}
+	}
+
+	user, err := s.db.Users().GetUserByEmail(ctx, changes.email)
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		if user, err = s.createPasswordlessUser(ctx, changes.email, changes.name); err != nil {
+			if errors.Is(err, database.ErrUserAlreadyExists) {
+				return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "User already exists")
+			}
+			return nil, nil, err
+		}
+		if _, err := s.db.Organizations().AddMember(ctx, organizationID, user.ID, database.OrgRoleMember); err != nil {
+			return nil, nil, err
+		}
+	case err != nil:
+		return nil, nil, err
+	default:
+		adoptable, err := s.scimAdoptable(ctx, organizationID, user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !adoptable {
+			return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "An account the organization can't take over has this userName")
+		}
+	}
+
+	scimUser, err := s.db.SCIMUsers().CreateSCIMUser(ctx, &database.SCIMUser{
+		OrganizationID: organizationID,
+		UserID:         user.ID,
+		ExternalID:     changes.externalID,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrSCIMUserExists) {
+			return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "User already exists")
+		}
+		if errors.Is(err, database.ErrSCIMExternalIDTaken) {
+			return nil, nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another user has this externalId")
+		}
+		return nil, nil, err
+	}
+
+	return s.saveSCIMUser(r, scimUser, user, changes)
+}
+
+// scimAdoptable reports whether SCIM may take over an existing account. It
+// has to belong to the organization alone, hold no roles, and have no way
+// to sign in of its own. Whoever set up a password, passkey, authenticator
+// or provider login would otherwise keep it once SCIM changes the address.
+func (s *Service) scimAdoptable(ctx context.Context, organizationID int, user *User) (bool, error) {
+	if user.Password != "" || user.TOTPEnabled {
+		return false, nil
+	}
+
+	orgs, err := s.db.Organizations().ListUserOrganizations(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	if len(orgs) != 1 || orgs[0].ID != organizationID {
+		return false, nil
+	}
+
+	roles, err := s.db.Roles().ListUserRoles(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	passkeys, err := s.db.WebAuthnCredentials().ListUserWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	identities, err := s.db.OIDCIdentities().ListUserOIDCIdentities(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	return len(roles) == 0 && len(passkeys) == 0 && len(identities) == 0, nil
+}
+
+// deprovisionSCIMUser suspends a managed user and takes them out of the
+// organization. The account itself is kept.
+func (s *Service) deprovisionSCIMUser(ctx context.Context, scimUser *database.SCIMUser, user *User) error {
+	organizationID := scimUser.OrganizationID
+
+	membership, err := s.db.Organizations().GetMembership(ctx, organizationID, user.ID)
+	if err != nil && !errors.Is(err, database.ErrMembershipNotFound) {
+		return err
+	}
+	if membership != nil && membership.Role == database.OrgRoleOwner {
+		owners, err := s.db.Organizations().CountMembers(ctx, organizationID, database.OrgRoleOwner)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return scim.NewError(http.StatusConflict, "", "The organization's last owner can't be deprovisioned")
+		}
+	}
+
+	if !user.Suspended() {
+		now := time.Now()
+		user.SuspendedAt = &now
+		if _, err := s.db.Users().UpdateUser(ctx, user); err != nil {
+			return err
+		}
+	}
+	if err := s.signOutEverywhere(ctx, user.ID); err != nil {
+		return err
+	}
+
+	if err := s.db.SCIMGroups().RemoveSCIMGroupMember(ctx, organizationID, user.ID); err != nil {
+		return err
+	}
+	if membership != nil {
+		err := s.db.Organizations().RemoveMember(ctx, organizationID, user.ID)
+		if err != nil && !errors.Is(err, database.ErrMembershipNotFound) {
+			return err
+		}
+	}
+	return s.db.SCIMUsers().DeleteSCIMUser(ctx, organizationID, user.ID)
+}
+
+// pathSCIMUser loads the managed user whose ID is in the path
+func (s *Service) pathSCIMUser(r *http.Request, organizationID int) (*database.SCIMUser, *User, error) {
+	notFound := scim.NewError(http.StatusNotFound, "", "User not found")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, nil, notFound
+	}
+
+	ctx := r.Context()
+	scimUser, err := s.db.SCIMUsers().GetSCIMUser(ctx, organizationID, id)
+	if err != nil {
+		if errors.Is(err, database.ErrSCIMUserNotFound) {
+			return nil, nil, notFound
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.db.Users().GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, nil, notFound
+		}
+		return nil, nil, err
+	}
+	return scimUser, user, nil
+}
+
+// scimGroupsByUser lists the groups each of an organization's users is in
+func (s *Service) scimGroupsByUser(ctx context.Context, organizationID int) (map[int][]scim.MultiValue, error) {
+	groups, err := s.db.SCIMGroups().ListSCIMGroups(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := map[int][]scim.MultiValue{}
+	for _, group := range groups {
+		for _, userID := range group.MemberIDs {
+			byUser[userID] = append(byUser[userID], scim.MultiValue{
+				Value:   strconv.Itoa(group.ID),
+				Display: group.DisplayName,
+				Ref:     s.scimGroupLocation(group.ID),
+			})
+		}
+	}
+	return byUser, nil
+}
+
+func (s *Service) scimUserResource(scimUser *database.SCIMUser, user *User, groups []scim.MultiValue) *scim.User {
+	active := scimUser.DeactivatedAt == nil
+	lastModified := user.UpdatedAt
+	if scimUser.UpdatedAt.After(lastModified) {
+		lastModified = scimUser.UpdatedAt
+	}
+
+	return &scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          strconv.Itoa(user.ID),
+		ExternalID:  scimUser.ExternalID,
+		UserName:    user.Email,
+		Name:        &scim.Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      groups,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: lastModified,
+			Location:     s.scimUserLocation(user.ID),
+		},
+	}
+}
+
+// loadSCIMUserResource builds the resource of one managed user
+func (s *Service) loadSCIMUserResource(ctx context.Context, scimUser *database.SCIMUser, user *User) (*scim.User, error) {
+	groups, err := s.scimGroupsByUser(ctx, scimUser.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimUserResource(scimUser, user, groups[user.ID]), nil
+}
+
+// scimUserAttributes returns the attributes filters can match a user on
+func scimUserAttributes(u *scim.User) scim.Attributes {
+	attributes := scim.Attributes{
+		"id":                {u.ID},
+		"username":          {u.UserName},
+		"displayname":       {u.DisplayName},
+		"name.formatted":    {u.Name.Formatted},
+		"emails":            {u.UserName},
+		"emails.value":      {u.UserName},
+		"active":            {strconv.FormatBool(*u.Active)},
+		"meta.created":      {scimTime(u.Meta.Created)},
+		"meta.lastmodified": {scimTime(u.Meta.LastModified)},
+	}
+	if u.ExternalID != "" {
+		attributes["externalid"] = []string{u.ExternalID}
+	}
+	for _, group := range u.Groups {
+		attributes["groups"] = append(attributes["groups"], group.Value)
+		attributes["groups.value"] = append(attributes["groups.value"], group.Value)
+	}
+	return attributes
+}
+
+// ListSCIMUsers lists the users an organization manages, filtered and paged
+func (s *Service) ListSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	filter, startIndex, count, err := parseSCIMQuery(r)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	managed, err := s.db.SCIMUsers().ListSCIMUsers(ctx, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	groups, err := s.scimGroupsByUser(ctx, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resources := []any{}
+	for _, scimUser := range managed {
+		user, err := s.db.Users().GetUserByID(ctx, scimUser.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrUserNotFound) {
+				continue
+			}
+			writeSCIMError(w, err)
+			return
+		}
+
+		resource := s.scimUserResource(scimUser, user, groups[user.ID])
+		if filter == nil || filter.Matches(scimUserAttributes(resource)) {
+			resources = append(resources, resource)
+		}
+	}
+
+	writeSCIMResponse(w, scim.NewListResponse(resources, startIndex, count), http.StatusOK)
+}
+
+// CreateSCIMUser provisions a user. They get an account without a
+// password, for signing in through the organization's identity provider,
+// and join the organization as a member.
+func (s *Service) CreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req scim.User
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	scimUser, user, err := s.provisionSCIMUser(r, organizationID, &req)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMUserResource(ctx, scimUser, user)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", resource.Meta.Location)
+	writeSCIMResponse(w, resource, http.StatusCreated)
+}
+
+// GetSCIMUser returns one of the users an organization manages
+func (s *Service) GetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	scimUser, user, err := s.pathSCIMUser(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMUserResource(r.Context(), scimUser, user)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// ReplaceSCIMUser replaces a managed user's attributes
+func (s *Service) ReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	scimUser, user, err := s.pathSCIMUser(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	var req scim.User
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	changes, err := scimUserChangesFrom(&req, scimUser.DeactivatedAt == nil)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if scimUser, user, err = s.saveSCIMUser(r, scimUser, user, changes); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMUserResource(ctx, scimUser, user)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// scimUserPatch applies patch operations to a managed user's state
+type scimUserPatch struct {
+	scimUserChanges
+
+	// Name parts, joined into the name unless the patch sets it whole
+	givenName, familyName string
+	nameSet               bool
+}
+
+func (p *scimUserPatch) apply(op scim.PatchOperation) error {
+	name := strings.ToLower(op.Op)
+	if op.Path != "" {
+		path, err := scim.ParsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		return p.set(name, path, op.Value)
+	}
+
+	// Without a path, the value holds the attributes to change
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Expected an object of attributes")
+	}
+	for attribute, value := range values {
+		path, err := scim.ParsePath(attribute)
+		if err != nil {
+			return err
+		}
+		if err := p.set(name, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set applies one operation to one attribute. Attributes that aren't
+// stored, such as phone numbers, are accepted and ignored.
+func (p *scimUserPatch) set(op string, path *scim.Path, value json.RawMessage) error {
+	if path.Filter != nil {
+		// Values of multi-valued attributes, such as emails[type eq
+		// "work"].value, aren't stored apart from userName
+		return nil
+	}
+
+	if op == scim.OpRemove {
+		switch path.Attribute {
+		case "username":
+			return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, "userName can't be removed")
+		case "externalid":
+			p.externalID = ""
+		}
+		return nil
+	}
+
+	var err error
+	switch path.Attribute {
+	case "username":
+		p.email, err = scim.ParseString(value)
+	case "displayname", "name.formatted":
+		p.name, err = scim.ParseString(value)
+		p.nameSet = true
+	case "name":
+		var name scim.Name
+		if json.Unmarshal(value, &name) != nil {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Expected a name")
+		}
+		if strings.TrimSpace(name.Formatted) != "" {
+			p.name, p.nameSet = name.Formatted, true
+		}
+		p.givenName, p.familyName = name.GivenName, name.FamilyName
+	case "name.givenname":
+		p.givenName, err = scim.ParseString(value)
+	case "name.familyname":
+		p.familyName, err = scim.ParseString(value)
+	case "active":
+		p.active, err = scim.ParseBool(value)
+	case "externalid":
+		p.externalID, err = scim.ParseString(value)
+	}
+	return err
+}
+
+// result returns the state the patch leads to
+func (p *scimUserPatch) result() (scimUserChanges, error) {
+	changes := p.scimUserChanges
+	if !p.nameSet && (p.givenName != "" || p.familyName != "") {
+		changes.name = (&scim.Name{GivenName: p.givenName, FamilyName: p.familyName}).Joined()
+	}
+	changes.email = strings.ToLower(strings.TrimSpace(changes.email))
+	changes.name = strings.TrimSpace(changes.name)
+	changes.externalID = strings.TrimSpace(changes.externalID)
+
+	if !isValidEmail(changes.email) {
+		return changes, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "userName must be an email address")
+	}
+	return changes, nil
+}
+
+// PatchSCIMUser changes some of a managed user's attributes. Identity
+// providers deactivate users this way.
+func (s *Service) PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	scimUser, user, err := s.pathSCIMUser(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	var req scim.PatchRequest
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	patch := scimUserPatch{scimUserChanges: scimUserChanges{
+		email:      user.Email,
+		externalID: scimUser.ExternalID,
+		active:     scimUser.DeactivatedAt == nil,
+	}}
+	for _, op := range req.Operations {
+		if err := patch.apply(op); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+	changes, err := patch.result()
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	if scimUser, user, err = s.saveSCIMUser(r, scimUser, user, changes); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMUserResource(ctx, scimUser, user)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// DeleteSCIMUser deprovisions a managed user. The account is suspended
+// rather than deleted, so its data is kept, and leaves the organization.
+func (s *Service) DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	scimUser, user, err := s.pathSCIMUser(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	if err := s.deprovisionSCIMUser(r.Context(), scimUser, user); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scimMemberIDs reads the members of a group, which must be users the
+// organization manages
+func (s *Service) scimMemberIDs(ctx context.Context, organizationID int, members []scim.MultiValue) ([]int, error) {
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		unknown := scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Unknown member "+strconv.Quote(member.Value))
+
+		id, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, unknown
+		}
+		if _, err := s.db.SCIMUsers().GetSCIMUser(ctx, organizationID, id); err != nil {
+			if errors.Is(err, database.ErrSCIMUserNotFound) {
+				return nil, unknown
+			}
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// scimMemberNames returns the names of an organization's members, for
+// showing group members by name
+func (s *Service) scimMemberNames(ctx context.Context, organizationID int) (map[int]string, error) {
+	members, err := s.db.Organizations().ListMembers(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string, len(members))
+	for _, member := range members {
+		names[member.UserID] = member.Name
+	}
+	return names, nil
+}
+
+func (s *Service) scimGroupResource(group *database.SCIMGroup, names map[int]string) *scim.Group {
+	members := []scim.MultiValue{}
+	for _, userID := range group.MemberIDs {
+		members = append(members, scim.MultiValue{
+			Value:   strconv.Itoa(userID),
+			Display: names[userID],
+			Ref:     s.scimUserLocation(userID),
+		})
+	}
+
+	return &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.Itoa(group.ID),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     members,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     s.scimGroupLocation(group.ID),
+		},
+	}
+}
+
+// loadSCIMGroupResource builds the resource of one group
+func (s *Service) loadSCIMGroupResource(ctx context.Context, group *database.SCIMGroup) (*scim.Group, error) {
+	names, err := s.scimMemberNames(ctx, group.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroupResource(group, names), nil
+}
+
+// scimGroupAttributes returns the attributes filters can match a group on
+func scimGroupAttributes(g *scim.Group) scim.Attributes {
+	attributes := scim.Attributes{
+		"id":                {g.ID},
+		"displayname":       {g.DisplayName},
+		"meta.created":      {scimTime(g.Meta.Created)},
+		"meta.lastmodified": {scimTime(g.Meta.LastModified)},
+	}
+	if g.ExternalID != "" {
+		attributes["externalid"] = []string{g.ExternalID}
+	}
+	for _, member := range g.Members {
+		attributes["members"] = append(attributes["members"], member.Value)
+		attributes["members.value"] = append(attributes["members.value"], member.Value)
+	}
+	return attributes
+}
+
+// saveSCIMGroup stores changes to a group
+func (s *Service) saveSCIMGroup(ctx context.Context, group *database.SCIMGroup) (*database.SCIMGroup, error) {
+	updated, err := s.db.SCIMGroups().UpdateSCIMGroup(ctx, group)
+	if err != nil {
+		if errors.Is(err, database.ErrSCIMGroupExists) {
+			return nil, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another group has this displayName")
+		}
+		if errors.Is(err, database.ErrSCIMGroupNotFound) {
+			return nil, scim.NewError(http.StatusNotFound, "", "Group not found")
+		}
+		return nil, err
+	}
+	return updated, nil
+}
+
+// pathSCIMGroup loads the group whose ID is in the path
+func (s *Service) pathSCIMGroup(r *http.Request, organizationID int) (*database.SCIMGroup, error) {
+	notFound := scim.NewError(http.StatusNotFound, "", "Group not found")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, notFound
+	}
+
+	group, err := s.db.SCIMGroups().GetSCIMGroup(r.Context(), organizationID, id)
+	if err != nil {
+		if errors.Is(err, database.ErrSCIMGroupNotFound) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+// scimGroupFrom reads the group a Group resource asks for
+func (s *Service) scimGroupFrom(ctx context.Context, organizationID int, req *scim.Group) (*database.SCIMGroup, error) {
+	displayName := strings.TrimSpace(req.DisplayName)
+	if displayName == "" {
+		return nil, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+	}
+
+	memberIDs, err := s.scimMemberIDs(ctx, organizationID, req.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.SCIMGroup{
+		OrganizationID: organizationID,
+		DisplayName:    displayName,
+		ExternalID:     strings.TrimSpace(req.ExternalID),
+		MemberIDs:      memberIDs,
+	}, nil
+}
+
+// ListSCIMGroups lists an organization's groups, filtered and paged
+func (s *Service) ListSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	filter, startIndex, count, err := parseSCIMQuery(r)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	groups, err := s.db.SCIMGroups().ListSCIMGroups(ctx, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	names, err := s.scimMemberNames(ctx, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resources := []any{}
+	for _, group := range groups {
+		resource := s.scimGroupResource(group, names)
+		if filter == nil || filter.Matches(scimGroupAttributes(resource)) {
+			resources = append(resources, resource)
+		}
+	}
+
+	writeSCIMResponse(w, scim.NewListResponse(resources, startIndex, count), http.StatusOK)
+}
+
+// CreateSCIMGroup creates a group of managed users
+func (s *Service) CreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req scim.Group
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	group, err := s.scimGroupFrom(ctx, organizationID, &req)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	if group, err = s.db.SCIMGroups().CreateSCIMGroup(ctx, group); err != nil {
+		if errors.Is(err, database.ErrSCIMGroupExists) {
+			writeSCIMError(w, scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "Another group has this displayName"))
+			return
+		}
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMGroupResource(ctx, group)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", resource.Meta.Location)
+	writeSCIMResponse(w, resource, http.StatusCreated)
+}
+
+// GetSCIMGroup returns one of an organization's groups
+func (s *Service) GetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := s.pathSCIMGroup(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMGroupResource(r.Context(), group)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// ReplaceSCIMGroup replaces a group's name, external ID and members
+func (s *Service) ReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	existing, err := s.pathSCIMGroup(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	var req scim.Group
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	group, err := s.scimGroupFrom(ctx, organizationID, &req)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	group.ID = existing.ID
+
+	if group, err = s.saveSCIMGroup(ctx, group); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMGroupResource(ctx, group)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// scimGroupPatch applies patch operations to a group
+type scimGroupPatch struct {
+	service *Service
+	ctx     context.Context
+	group   *database.SCIMGroup
+}
+
+func (p *scimGroupPatch) apply(op scim.PatchOperation) error {
+	name := strings.ToLower(op.Op)
+	if op.Path != "" {
+		path, err := scim.ParsePath(op.Path)
+		if err != nil {
+			return err
+		}
+		return p.set(name, path, op.Value)
+	}
+
+	// Without a path, the value holds the attributes to change
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Expected an object of attributes")
+	}
+	for attribute, value := range values {
+		path, err := scim.ParsePath(attribute)
+		if err != nil {
+			return err
+		}
+		if err := p.set(name, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set applies one operation to one attribute. Other attributes, such as
+// the id some identity providers send back, are ignored.
+func (p *scimGroupPatch) set(op string, path *scim.Path, value json.RawMessage) error {
+	switch path.Attribute {
+	case "displayname":
+		if op == scim.OpRemove {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, "displayName can't be removed")
+		}
+		displayName, err := scim.ParseString(value)
+		if err != nil {
+			return err
+		}
+		if displayName = strings.TrimSpace(displayName); displayName == "" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+		}
+		p.group.DisplayName = displayName
+	case "externalid":
+		if op == scim.OpRemove {
+			p.group.ExternalID = ""
+			return nil
+		}
+		externalID, err := scim.ParseString(value)
+		if err != nil {
+			return err
+		}
+		p.group.ExternalID = strings.TrimSpace(externalID)
+	case "members":
+		return p.setMembers(op, path, value)
+	}
+	return nil
+}
+
+func (p *scimGroupPatch) setMembers(op string, path *scim.Path, value json.RawMessage) error {
+	if path.Filter != nil {
+		// members[value eq "2"] selects members to remove
+		if op != scim.OpRemove {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidPath, "Only remove can select members with a filter")
+		}
+		p.removeMembers(func(userID int) bool {
+			return path.Filter.Matches(scim.Attributes{"value": {strconv.Itoa(userID)}})
+		})
+		return nil
+	}
+
+	if op == scim.OpRemove && len(value) == 0 {
+		p.group.MemberIDs = []int{}
+		return nil
+	}
+
+	var members []scim.MultiValue
+	if err := json.Unmarshal(value, &members); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "Expected a list of members")
+	}
+
+	if op == scim.OpRemove {
+		removed := map[string]bool{}
+		for _, member := range members {
+			removed[member.Value] = true
+		}
+		p.removeMembers(func(userID int) bool {
+			return removed[strconv.Itoa(userID)]
+		})
+		return nil
+	}
+
+	ids, err := p.service.scimMemberIDs(p.ctx, p.group.OrganizationID, members)
+	if err != nil {
+		return err
+	}
+	if op == scim.OpReplace {
+		p.group.MemberIDs = ids
+	} else {
+		p.group.MemberIDs = append(p.group.MemberIDs, ids...)
+	}
+	return nil
+}
+
+func (p *scimGroupPatch) removeMembers(remove func(userID int) bool) {
+	kept := []int{}
+	for _, userID := range p.group.MemberIDs {
+		if !remove(userID) {
+			kept = append(kept, userID)
+		}
+	}
+	p.group.MemberIDs = kept
+}
+
+// PatchSCIMGroup changes some of a group's attributes. Identity providers
+// add and remove members this way.
+func (s *Service) PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := s.pathSCIMGroup(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	var req scim.PatchRequest
+	if err := decodeSCIMRequest(r, &req); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	patch := scimGroupPatch{service: s, ctx: ctx, group: group}
+	for _, op := range req.Operations {
+		if err := patch.apply(op); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+
+	if group, err = s.saveSCIMGroup(ctx, group); err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	resource, err := s.loadSCIMGroupResource(ctx, group)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	writeSCIMResponse(w, resource, http.StatusOK)
+}
+
+// DeleteSCIMGroup deletes a group. Its members are left as they are.
+func (s *Service) DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeSCIMError(w, scimMethodNotAllowed())
+		return
+	}
+
+	organizationID, ok := s.scimOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := s.pathSCIMGroup(r, organizationID)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+
+	if err := s.db.SCIMGroups().DeleteSCIMGroup(r.Context(), organizationID, group.ID); err != nil {
+		if errors.Is(err, database.ErrSCIMGroupNotFound) {
+			writeSCIMError(w, scim.NewError(http.StatusNotFound, "", "Group not found"))
+			return
+		}
+		writeSCIMError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListSCIMTokens is a wrapper around the service ListSCIMTokens method
+func HandleListSCIMTokens(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListSCIMTokens(w, r)
+}
+
+// HandleCreateSCIMToken is a wrapper around the service CreateSCIMToken method
+func HandleCreateSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateSCIMToken(w, r)
+}
+
+// HandleDeleteSCIMToken is a wrapper around the service DeleteSCIMToken method
+func HandleDeleteSCIMToken(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteSCIMToken(w, r)
+}
+
+// HandleListSCIMUsers is a wrapper around the service ListSCIMUsers method
+func HandleListSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListSCIMUsers(w, r)
+}
+
+// HandleCreateSCIMUser is a wrapper around the service CreateSCIMUser method
+func HandleCreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateSCIMUser(w, r)
+}
+
+// HandleGetSCIMUser is a wrapper around the service GetSCIMUser method
+func HandleGetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.GetSCIMUser(w, r)
+}
+
+// HandleReplaceSCIMUser is a wrapper around the service ReplaceSCIMUser method
+func HandleReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ReplaceSCIMUser(w, r)
+}
+
+// HandlePatchSCIMUser is a wrapper around the service PatchSCIMUser method
+func HandlePatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.PatchSCIMUser(w, r)
+}
+
+// HandleDeleteSCIMUser is a wrapper around the service DeleteSCIMUser method
+func HandleDeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteSCIMUser(w, r)
+}
+
+// HandleListSCIMGroups is a wrapper around the service ListSCIMGroups method
+func HandleListSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListSCIMGroups(w, r)
+}
+
+// HandleCreateSCIMGroup is a wrapper around the service CreateSCIMGroup method
+func HandleCreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.CreateSCIMGroup(w, r)
+}
+
+// HandleGetSCIMGroup is a wrapper around the service GetSCIMGroup method
+func HandleGetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.GetSCIMGroup(w, r)
+}
+
+// HandleReplaceSCIMGroup is a wrapper around the service ReplaceSCIMGroup method
+func HandleReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ReplaceSCIMGroup(w, r)
+}
+
+// HandlePatchSCIMGroup is a wrapper around the service PatchSCIMGroup method
+func HandlePatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.PatchSCIMGroup(w, r)
+}
+
+// HandleDeleteSCIMGroup is a wrapper around the service DeleteSCIMGroup method
+func HandleDeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.DeleteSCIMGroup(w, r)
+}
@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/rbac"
+	"github.com/danielsaas/generic-saas/internal/saml/samltest"
+	"github.com/danielsaas/generic-saas/internal/scim"
+)
+
+// serveSCIM routes a request from an identity provider through the SCIM
+// endpoints
+func serveSCIM(service *Service, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", scim.MediaType)
+	return serveRoutes(service, req)
+}
+
+// setupSCIM gives Acme, owned by John with Jane as a member, a SCIM token
+// and the verified domains acme.com and example.com
+func setupSCIM(t *testing.T) (*Service, database.Database, *database.UserOrganization, AuthResponse, string) {
+	t.Helper()
+
+	service, db, org, john, _ := setupOrganization(t)
+	service.scimBaseURL = "https://api.example.com/scim/v2"
+
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+	rr := serveAPI(service, "PUT", organizationPath(org, "/saml"), samlConnectionBody(idp, "acme.com", "example.com"), john.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Saving the connection failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	zone := fakeTXT{}
+	service.lookupTXT = zone.lookup
+	publishSAMLTokens(t, db, org, zone)
+	if rr := serveAPI(service, "POST", organizationPath(org, "/saml/verify"), "", john.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Verifying the domains failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(service, "POST", organizationPath(org, "/scim/tokens"), `{"name": "Okta"}`, john.Token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Creating a token failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	var response CreateSCIMTokenResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return service, db, org, john, response.Token
+}
+
+// decodeSCIMError checks a response is a SCIM error with the status and type
+func decodeSCIMError(t *testing.T, rr *httptest.ResponseRecorder, status int, scimType string) {
+	t.Helper()
+
+	var response scim.ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != status || response.Status != strconv.Itoa(status) || response.ScimType != scimType ||
+		len(response.Schemas) != 1 || response.Schemas[0] != scim.SchemaError {
+		t.Errorf("Expected a %d %q SCIM error, got %d: %+v", status, scimType, rr.Code, response)
+	}
+	if rr.Header().Get("Content-Type") != scim.MediaType {
+		t.Errorf("Expected a SCIM content type, got %q", rr.Header().Get("Content-Type"))
+	}
+}
+
+// provisionSCIMUser creates a user through SCIM and returns its resource
+func provisionSCIMUser(t *testing.T, service *Service, token, body string) scim.User {
+	t.Helper()
+
+	rr := serveSCIM(service, "POST", "/scim/v2/Users", body, token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Provisioning failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	var user scim.User
+	json.NewDecoder(rr.Body).Decode(&user)
+	if rr.Header().Get("Location") != user.Meta.Location {
+		t.Errorf("Expected the Location header to be %q, got %q", user.Meta.Location, rr.Header().Get("Location"))
+	}
+	return user
+}
+
+func patchOp(ops string) string {
+	return `{"schemas": ["` + scim.SchemaPatchOp + `"], "Operations": [` + ops + `]}`
+}
+
+func TestSCIMTokens(t *testing.T) {
+	service, _, org, john, jane := setupOrganization(t)
+	service.scimBaseURL = "https://api.example.com/scim/v2"
+	path := organizationPath(org, "/scim/tokens")
+
+	if rr := serveAPI(service, "GET", path, "", jane.Token); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused, got %d", rr.Code)
+	}
+	if rr := serveAPI(service, "POST", path, `{"name": " "}`, john.Token); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a blank name to be rejected, got %d", rr.Code)
+	}
+
+	rr := serveAPI(service, "POST", path, `{"name": "Okta"}`, john.Token)
+	var created CreateSCIMTokenResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(created.Token, scim.TokenPrefix) || created.BaseURL != "https://api.example.com/scim/v2" {
+		t.Fatalf("Unexpected response %d: %+v", rr.Code, created)
+	}
+	if !strings.HasPrefix(created.Token, created.SCIMToken.Prefix) || created.SCIMToken.CreatedBy != john.User.ID {
+		t.Errorf("Unexpected token %+v", created.SCIMToken)
+	}
+
+	if rr := serveSCIM(service, "GET", "/scim/v2/Users", "", created.Token); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the token to authenticate, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveAPI(service, "GET", path, "", john.Token)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Token) {
+		t.Fatalf("Expected the token to be listed without its secret, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var list SCIMTokensResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Tokens) != 1 || list.Tokens[0].LastUsedAt == nil {
+		t.Errorf("Expected the token's use to be recorded, got %+v", list.Tokens)
+	}
+
+	tokenPath := path + "/" + strconv.Itoa(created.SCIMToken.ID)
+	if rr := serveAPI(service, "DELETE", tokenPath, "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if rr := serveAPI(service, "DELETE", tokenPath, "", john.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted token to be gone, got %d", rr.Code)
+	}
+
+	rr = serveSCIM(service, "GET", "/scim/v2/Users", "", created.Token)
+	if rr.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate challenge")
+	}
+	decodeSCIMError(t, rr, http.StatusUnauthorized, "")
+	decodeSCIMError(t, serveSCIM(service, "GET", "/scim/v2/Users", "", "not-a-scim-token"), http.StatusUnauthorized, "")
+}
+
+func TestSCIMUsers_Lifecycle(t *testing.T) {
+	service, db, org, _, token := setupSCIM(t)
+	ctx := context.Background()
+
+	created := provisionSCIMUser(t, service, token, `{
+		"schemas": ["`+scim.SchemaUser+`"],
+		"userName": "Alice@Acme.com",
+		"externalId": "00u1",
+		"name": {"givenName": "Alice", "familyName": "Smith"},
+		"emails": [{"value": "alice@acme.com", "type": "work", "primary": true}],
+		"active": true
+	}`)
+	if created.UserName != "alice@acme.com" || created.DisplayName != "Alice Smith" || created.ExternalID != "00u1" ||
+		created.Active == nil || !*created.Active {
+		t.Errorf("Unexpected resource %+v", created)
+	}
+	if !strings.HasPrefix(created.Meta.Location, "https://api.example.com/scim/v2/Users/") {
+		t.Errorf("Unexpected location %q", created.Meta.Location)
+	}
+
+	alice, err := db.Users().GetUserByEmail(ctx, "alice@acme.com")
+	if err != nil || alice.EmailVerifiedAt == nil || alice.Password != "" {
+		t.Fatalf("Expected a verified account without a password, got %+v, %v", alice, err)
+	}
+	if membership, err := db.Organizations().GetMembership(ctx, org.ID, alice.ID); err != nil || membership.Role != database.OrgRoleMember {
+		t.Errorf("Expected Alice to join Acme as a member, got %+v, %v", membership, err)
+	}
+
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "alice@acme.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "bob@acme.com", "externalId": "00u1"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "not an email"}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+	if _, err := db.Users().GetUserByEmail(ctx, "bob@acme.com"); err == nil {
+		t.Error("Expected no account to be left behind by a clashing externalId")
+	}
+
+	rr := serveSCIM(service, "GET", `/scim/v2/Users?filter=userName+eq+%22ALICE%40acme.com%22`, "", token)
+	var list scim.ListResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || list.TotalResults != 1 || len(list.Resources) != 1 {
+		t.Errorf("Expected the filter to find Alice, got %d: %+v", rr.Code, list)
+	}
+	rr = serveSCIM(service, "GET", `/scim/v2/Users?filter=userName+eq+%22bob%40acme.com%22`, "", token)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.TotalResults != 0 {
+		t.Errorf("Expected no match, got %+v", list)
+	}
+	decodeSCIMError(t, serveSCIM(service, "GET", `/scim/v2/Users?filter=userName+is+1`, "", token), http.StatusBadRequest, scim.ErrorInvalidFilter)
+
+	// John and Jane belong to Acme but aren't managed through SCIM
+	rr = serveSCIM(service, "GET", "/scim/v2/Users?startIndex=0&count=500", "", token)
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.TotalResults != 1 || list.StartIndex != 1 {
+		t.Errorf("Expected only Alice to be listed, got %+v", list)
+	}
+
+	userPath := "/scim/v2/Users/" + created.ID
+	rr = serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "Replace", "path": "active", "value": false}`), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var patched scim.User
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if patched.Active == nil || *patched.Active {
+		t.Errorf("Expected Alice to be inactive, got %+v", patched)
+	}
+	if alice, _ = db.Users().GetUserByID(ctx, alice.ID); !alice.Suspended() {
+		t.Error("Expected deactivating to suspend the account")
+	}
+
+	rr = serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "replace", "value": {"active": "True", "displayName": "Alice Jones"}}`), token)
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || !*patched.Active || patched.DisplayName != "Alice Jones" {
+		t.Errorf("Expected Alice to be active again, got %d: %+v", rr.Code, patched)
+	}
+	if alice, _ = db.Users().GetUserByID(ctx, alice.ID); alice.Suspended() || alice.Name != "Alice Jones" {
+		t.Errorf("Expected reactivating to lift the suspension, got %+v", alice)
+	}
+
+	decodeSCIMError(t, serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "remove", "path": "userName"}`), token), http.StatusBadRequest, scim.ErrorMutability)
+	decodeSCIMError(t, serveSCIM(service, "PATCH", userPath, `{"Operations": [{"op": "remove", "path": "externalId"}]}`, token), http.StatusBadRequest, scim.ErrorInvalidSyntax)
+
+	rr = serveSCIM(service, "PUT", userPath, `{"userName": "alice.jones@acme.com", "externalId": "00u1", "name": {"formatted": "Alice Jones"}}`, token)
+	json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || patched.UserName != "alice.jones@acme.com" || !*patched.Active {
+		t.Errorf("Expected the user to be replaced, got %d: %+v", rr.Code, patched)
+	}
+
+	if rr := serveSCIM(service, "DELETE", userPath, "", token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	alice, err = db.Users().GetUserByID(ctx, alice.ID)
+	if err != nil || !alice.Suspended() {
+		t.Errorf("Expected the account to be kept and suspended, got %+v, %v", alice, err)
+	}
+	if _, err := db.Organizations().GetMembership(ctx, org.ID, alice.ID); err == nil {
+		t.Error("Expected Alice to leave Acme")
+	}
+	decodeSCIMError(t, serveSCIM(service, "GET", userPath, "", token), http.StatusNotFound, "")
+}
+
+func TestSCIMUsers_DeactivationKeepsAdminSuspension(t *testing.T) {
+	service, db, _, _, token := setupSCIM(t)
+	ctx := context.Background()
+
+	created := provisionSCIMUser(t, service, token, `{"userName": "alice@acme.com"}`)
+	alice, _ := db.Users().GetUserByEmail(ctx, "alice@acme.com")
+
+	suspendedAt := time.Now().Add(-time.Hour)
+	alice.SuspendedAt = &suspendedAt
+	db.Users().UpdateUser(ctx, alice)
+
+	userPath := "/scim/v2/Users/" + created.ID
+	serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "replace", "path": "active", "value": false}`), token)
+	rr := serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "replace", "path": "active", "value": true}`), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if alice, _ = db.Users().GetUserByID(ctx, alice.ID); !alice.Suspended() {
+		t.Error("Expected an administrator's suspension to outlast reactivation")
+	}
+}
+
+func TestSCIMUsers_ExistingAccounts(t *testing.T) {
+	service, db, org, john, token := setupSCIM(t)
+	ctx := context.Background()
+
+	// Pat signed in through SAML, so the account belongs to Acme alone and
+	// has no way to sign in of its own. Acme can take it over.
+	pat, _ := db.Users().CreateUser(ctx, &database.User{Name: "Pat", Email: "pat@acme.com"})
+	db.Organizations().AddMember(ctx, org.ID, pat.ID, database.OrgRoleMember)
+	managedPat := provisionSCIMUser(t, service, token, `{"userName": "pat@acme.com", "externalId": "00u2"}`)
+	if managedPat.DisplayName != "Pat" {
+		t.Errorf("Expected Pat's account to be kept, got %+v", managedPat)
+	}
+
+	// A password or authenticator would outlive a change of address, and
+	// roles reach beyond Acme
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "jane@example.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+	sam, _ := db.Users().CreateUser(ctx, &database.User{Name: "Sam", Email: "sam@acme.com", TOTPEnabled: true})
+	db.Organizations().AddMember(ctx, org.ID, sam.ID, database.OrgRoleMember)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "sam@acme.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+	rbac.EnsureDefaultRoles(ctx, db.Roles())
+	kim, _ := db.Users().CreateUser(ctx, &database.User{Name: "Kim", Email: "kim@acme.com"})
+	db.Organizations().AddMember(ctx, org.ID, kim.ID, database.OrgRoleMember)
+	db.Roles().AssignRole(ctx, kim.ID, rbac.RoleAdmin)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "kim@acme.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+
+	outsider, _ := db.Users().CreateUser(ctx, &database.User{Name: "Olly", Email: "olly@example.com"})
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "olly@example.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+
+	// Belonging to another organization too keeps an account out of reach
+	db.Organizations().AddMember(ctx, org.ID, outsider.ID, database.OrgRoleMember)
+	globex, _ := db.Organizations().CreateOrganization(ctx, &database.Organization{Name: "Globex"}, outsider.ID)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "olly@example.com"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+
+	// Another organization's token doesn't reach Acme's users
+	raw, prefix, _ := scim.GenerateToken()
+	db.SCIMTokens().CreateSCIMToken(ctx, &database.SCIMToken{OrganizationID: globex.ID, Name: "Okta", Prefix: prefix, TokenHash: scim.HashToken(raw)})
+	decodeSCIMError(t, serveSCIM(service, "GET", "/scim/v2/Users/"+managedPat.ID, "", raw), http.StatusNotFound, "")
+
+	db.Organizations().UpdateMemberRole(ctx, org.ID, pat.ID, database.OrgRoleOwner)
+	db.Organizations().UpdateMemberRole(ctx, org.ID, john.User.ID, database.OrgRoleMember)
+	decodeSCIMError(t, serveSCIM(service, "DELETE", "/scim/v2/Users/"+managedPat.ID, "", token), http.StatusConflict, "")
+	if membership, err := db.Organizations().GetMembership(ctx, org.ID, pat.ID); err != nil || membership.Role != database.OrgRoleOwner {
+		t.Errorf("Expected the last owner to stay, got %+v, %v", membership, err)
+	}
+}
+
+func TestSCIMUsers_VerifiedDomains(t *testing.T) {
+	service, db, org, john, token := setupSCIM(t)
+	emails := service.emailService.(*recordingEmailService)
+	ctx := context.Background()
+
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "alice@globex.com"}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+	if _, err := db.Users().GetUserByEmail(ctx, "alice@globex.com"); err == nil {
+		t.Error("Expected no account outside the verified domains")
+	}
+
+	created := provisionSCIMUser(t, service, token, `{"userName": "alice@acme.com"}`)
+	alice, _ := db.Users().GetUserByEmail(ctx, "alice@acme.com")
+	session, _ := db.Sessions().CreateSession(ctx, &database.Session{ID: "alice-laptop", UserID: alice.ID, ExpiresAt: time.Now().Add(time.Hour)})
+
+	userPath := "/scim/v2/Users/" + created.ID
+	decodeSCIMError(t, serveSCIM(service, "PUT", userPath, `{"userName": "alice@globex.com"}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+	if alice, _ = db.Users().GetUserByID(ctx, alice.ID); alice.Email != "alice@acme.com" {
+		t.Errorf("Expected the address to stay, got %q", alice.Email)
+	}
+
+	// A new address in the domains signs the account out and tells the old one
+	emails.alerts = nil
+	rr := serveSCIM(service, "PATCH", userPath, patchOp(`{"op": "replace", "path": "userName", "value": "alice.smith@acme.com"}`), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if session, _ = db.Sessions().GetSession(ctx, session.ID); session.RevokedAt == nil {
+		t.Error("Expected a change of address to sign the account out")
+	}
+	if len(emails.alerts) != 1 || !strings.Contains(emails.alerts[0], "alice.smith@acme.com") {
+		t.Errorf("Expected an alert about the new address, got %v", emails.alerts)
+	}
+
+	// Without verified domains nothing can be provisioned
+	if rr := serveAPI(service, "DELETE", organizationPath(org, "/saml"), "", john.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Deleting the connection failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Users", `{"userName": "bob@acme.com"}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+}
+
+func TestSCIMGroups(t *testing.T) {
+	service, _, _, _, token := setupSCIM(t)
+
+	alice := provisionSCIMUser(t, service, token, `{"userName": "alice@acme.com", "displayName": "Alice"}`)
+	bob := provisionSCIMUser(t, service, token, `{"userName": "bob@acme.com", "displayName": "Bob"}`)
+
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Groups", `{"displayName": "Engineering", "members": [{"value": "999"}]}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Groups", `{"displayName": " "}`, token), http.StatusBadRequest, scim.ErrorInvalidValue)
+
+	rr := serveSCIM(service, "POST", "/scim/v2/Groups", `{
+		"schemas": ["`+scim.SchemaGroup+`"],
+		"displayName": "Engineering",
+		"members": [{"value": "`+alice.ID+`"}]
+	}`, token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var group scim.Group
+	json.NewDecoder(rr.Body).Decode(&group)
+	if len(group.Members) != 1 || group.Members[0].Value != alice.ID || group.Members[0].Display != "Alice" {
+		t.Errorf("Unexpected group %+v", group)
+	}
+
+	decodeSCIMError(t, serveSCIM(service, "POST", "/scim/v2/Groups", `{"displayName": "engineering"}`, token), http.StatusConflict, scim.ErrorUniqueness)
+
+	groupPath := "/scim/v2/Groups/" + group.ID
+	rr = serveSCIM(service, "PATCH", groupPath, patchOp(`{"op": "add", "path": "members", "value": [{"value": "`+bob.ID+`"}]}`), token)
+	json.NewDecoder(rr.Body).Decode(&group)
+	if rr.Code != http.StatusOK || len(group.Members) != 2 {
+		t.Errorf("Expected Bob to be added, got %d: %+v", rr.Code, group)
+	}
+
+	rr = serveSCIM(service, "GET", "/scim/v2/Users/"+bob.ID, "", token)
+	var user scim.User
+	json.NewDecoder(rr.Body).Decode(&user)
+	if len(user.Groups) != 1 || user.Groups[0].Value != group.ID || user.Groups[0].Display != "Engineering" {
+		t.Errorf("Expected Bob's groups to list Engineering, got %+v", user.Groups)
+	}
+
+	rr = serveSCIM(service, "PATCH", groupPath, patchOp(`{"op": "remove", "path": "members[value eq \"`+alice.ID+`\"]"}`), token)
+	json.NewDecoder(rr.Body).Decode(&group)
+	if len(group.Members) != 1 || group.Members[0].Value != bob.ID {
+		t.Errorf("Expected Alice to be removed, got %+v", group.Members)
+	}
+
+	// Azure AD sends the attributes to replace without a path, id included
+	rr = serveSCIM(service, "PATCH", groupPath, patchOp(`{"op": "Replace", "value": {"id": "`+group.ID+`", "displayName": "Platform"}}`), token)
+	json.NewDecoder(rr.Body).Decode(&group)
+	if rr.Code != http.StatusOK || group.DisplayName != "Platform" {
+		t.Errorf("Expected the group to be renamed, got %d: %+v", rr.Code, group)
+	}
+
+	rr = serveSCIM(service, "GET", `/scim/v2/Groups?filter=displayName+eq+%22platform%22`, "", token)
+	var list scim.ListResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if list.TotalResults != 1 {
+		t.Errorf("Expected the filter to find the group, got %+v", list)
+	}
+
+	rr = serveSCIM(service, "PUT", groupPath, `{"displayName": "Platform", "externalId": "grp1", "members": []}`, token)
+	json.NewDecoder(rr.Body).Decode(&group)
+	if rr.Code != http.StatusOK || len(group.Members) != 0 || group.ExternalID != "grp1" {
+		t.Errorf("Expected the group to be replaced, got %d: %+v", rr.Code, group)
+	}
+
+	if rr := serveSCIM(service, "DELETE", groupPath, "", token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	decodeSCIMError(t, serveSCIM(service, "GET", groupPath, "", token), http.StatusNotFound, "")
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// SessionInfo describes one of the user's signed-in devices
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	AuthMethod string    `json:"auth_method"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// SessionsResponse is the body of GET /api/user/sessions
+type SessionsResponse struct {
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// ListSessions returns the authenticated user's active sessions
+func (s *Service) ListSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	currentID, _ := middleware.SessionIDFromContext(r.Context())
+
+	sessions, err := s.db.Sessions().ListUserSessions(r.Context(), userID)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	response := SessionsResponse{Sessions: []SessionInfo{}}
+	for _, session := range sessions {
+		if !session.Active(now) {
+			continue
+		}
+		response.Sessions = append(response.Sessions, SessionInfo{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			AuthMethod: session.AuthMethod,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentID,
+		})
+	}
+
+	writeJSONResponse(w, response, http.StatusOK)
+}
+
+// RevokeSession signs out one of the authenticated user's sessions
+func (s *Service) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := s.db.Sessions().GetSession(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			writeErrorResponse(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Don't reveal whether another user's session exists
+	if session.UserID != userID {
+		writeErrorResponse(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.revokeSession(r.Context(), session.ID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "Session revoked"}, http.StatusOK)
+}
+
+// RevokeOtherSessions signs out every session of the authenticated user
+// except the one making the request
+func (s *Service) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	currentID, _ := middleware.SessionIDFromContext(r.Context())
+
+	// Refresh checks the session, so revoking it also retires its refresh tokens
+	if err := s.db.Sessions().RevokeUserSessions(r.Context(), userID, currentID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]string{"message": "Other sessions revoked"}, http.StatusOK)
+}
+
+// revokeSession revokes a session together with its refresh token family
+func (s *Service) revokeSession(ctx context.Context, sessionID string) error {
+	if err := s.db.Sessions().RevokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.db.RefreshTokens().RevokeRefreshTokenFamily(ctx, sessionID)
+}
+
+// HandleListSessions is a wrapper around the service ListSessions method
+func HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ListSessions(w, r)
+}
+
+// HandleRevokeSession is a wrapper around the service RevokeSession method
+func HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RevokeSession(w, r)
+}
+
+// HandleRevokeOtherSessions is a wrapper around the service RevokeOtherSessions method
+func HandleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.RevokeOtherSessions(w, r)
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// loginAgain starts another session for the standard test user
+func loginAgain(t *testing.T, service *Service) AuthResponse {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email": "john@example.com", "password": "password123"}`))
+	service.Login(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	return response
+}
+
+func listSessions(t *testing.T, service *Service, db database.Database, accessToken string) []SessionInfo {
+	t.Helper()
+
+	rr := serveAPI(service, "GET", "/api/user/sessions", "", accessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response SessionsResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode sessions response: %v", err)
+	}
+	return response.Sessions
+}
+
+func TestService_ListSessions(t *testing.T) {
+	service, db := setupTestService()
+	first := loginTestUser(t, service, db)
+	loginAgain(t, service)
+
+	sessions := listSessions(t, service, db, first.Token)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+
+	current := 0
+	for _, session := range sessions {
+		if session.Current {
+			current++
+		}
+		if session.AuthMethod != AuthMethodPassword {
+			t.Errorf("Expected auth method %q, got %q", AuthMethodPassword, session.AuthMethod)
+		}
+	}
+	if current != 1 {
+		t.Errorf("Expected exactly one current session, got %d", current)
+	}
+}
+
+func TestService_RevokeSession(t *testing.T) {
+	service, db := setupTestService()
+	first := loginTestUser(t, service, db)
+	second := loginAgain(t, service)
+
+	var secondID string
+	for _, session := range listSessions(t, service, db, first.Token) {
+		if !session.Current {
+			secondID = session.ID
+		}
+	}
+
+	rr := serveAPI(service, "DELETE", "/api/user/sessions/"+secondID, "", first.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// The revoked device loses both its access and refresh tokens
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", second.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked access token to be rejected, got %d", rr.Code)
+	}
+	if rr := postRefreshToken(service, service.Refresh, second.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked refresh token to be rejected, got %d", rr.Code)
+	}
+
+	if sessions := listSessions(t, service, db, first.Token); len(sessions) != 1 {
+		t.Errorf("Expected 1 remaining session, got %d", len(sessions))
+	}
+
+	if rr := serveAPI(service, "DELETE", "/api/user/sessions/unknown", "", first.Token); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown session, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestService_RevokeOtherSessions(t *testing.T) {
+	service, db := setupTestService()
+	first := loginTestUser(t, service, db)
+	second := loginAgain(t, service)
+	third := loginAgain(t, service)
+
+	rr := serveAPI(service, "POST", "/api/user/sessions/revoke-others", "", first.Token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	for _, other := range []AuthResponse{second, third} {
+		if rr := postRefreshToken(service, service.Refresh, other.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected other session's refresh token to be rejected, got %d", rr.Code)
+		}
+	}
+
+	sessions := listSessions(t, service, db, first.Token)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("Expected only the current session to remain, got %+v", sessions)
+	}
+}
+
+func TestService_Logout_RevokesSession(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	if rr := postRefreshToken(service, service.Logout, login.RefreshToken); rr.Code != http.StatusOK {
+		t.Fatalf("Logout failed with status %d", rr.Code)
+	}
+
+	if rr := serveAPI(service, "GET", "/api/user/sessions", "", login.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected access token of logged out session to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Error codes returned by the refresh endpoint
+const (
+	CodeRefreshTokenInvalid = "refresh_token_invalid"
+	CodeRefreshTokenExpired = "refresh_token_expired"
+	CodeRefreshTokenReused  = "refresh_token_reused"
+)
+
+// RefreshRequest is the body of /auth/refresh and /auth/logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Authentication methods recorded on sessions
+const (
+	AuthMethodPassword = "password"
+)
+
+// startSession records a new session for a freshly authenticated user and
+// issues its first token pair. The session ID is also the refresh token family.
+func (s *Service) startSession(w http.ResponseWriter, r *http.Request, user *User, authMethod string) (*AuthResponse, error) {
+	session, err := s.openSession(w, r, user, authMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokenPair(r, user, session)
+}
+
+// openSession records a new session for a freshly authenticated user
+// without issuing tokens for it yet. Whatever the sign-in method, it
+// recognizes the device signing in and emails the user when it's one they
+// haven't used before. A forced password reset holds for every method,
+// not just passwords.
+func (s *Service) openSession(w http.ResponseWriter, r *http.Request, user *User, authMethod string) (*database.Session, error) {
+	if user.Suspended() {
+		return nil, errAccountSuspended
+	}
+	if user.PasswordResetRequired {
+		return nil, errPasswordResetRequired
+	}
+
+	if user.DeletionScheduledAt != nil {
+		if err := s.cancelDeletion(r, user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.bootstrapAdmin(r.Context(), user); err != nil {
+		return nil, err
+	}
+
+	sessionID, err := newFamilyID()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.db.Sessions().CreateSession(r.Context(), &database.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  middleware.ClientIP(r),
+		AuthMethod: authMethod,
+		ExpiresAt:  time.Now().Add(s.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.recognizeDevice(w, r, user, session)
+	return session, nil
+}
+
+// writeSessionError responds to a failure to start a session
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAccountSuspended) {
+		writeCodedErrorResponse(w, "This account has been suspended", CodeAccountSuspended, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errPasswordResetRequired) {
+		writeCodedErrorResponse(w, "Reset your password to sign in", CodePasswordResetRequired, http.StatusForbidden)
+		return
+	}
+	writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// issueTokenPair issues an access token and a refresh token for the given session
+func (s *Service) issueTokenPair(r *http.Request, user *User, session *database.Session) (*AuthResponse, error) {
+	accessToken, err := s.issueAccessToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := token.GenerateOpaque()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.RefreshTokens().CreateRefreshToken(r.Context(), &database.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  session.ID,
+		TokenHash: token.HashOpaque(refreshToken),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+		RequestIP: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{
+		Token:        accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.TTL().Seconds()),
+		RefreshToken: refreshToken,
+		User:         *user,
+		sessionID:    session.ID,
+	}, nil
+}
+
+// newFamilyID generates a random identifier for a session and its refresh token family
+func newFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issueAccessToken signs a short-lived access token for the user's session
+func (s *Service) issueAccessToken(user *User, session *database.Session) (string, error) {
+	return s.tokens.Issue(token.Claims{
+		Subject:        strconv.Itoa(user.ID),
+		Type:           token.TypeAccess,
+		SessionID:      session.ID,
+		OrganizationID: session.OrganizationID,
+	})
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated
+// refresh token. Presenting a refresh token that was already rotated means it
+// was copied, so the whole family is revoked.
+func (s *Service) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, fromCookie, ok := s.requestRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	stored, err := s.db.RefreshTokens().GetRefreshTokenByHash(ctx, token.HashOpaque(refreshToken))
+	if err != nil {
+		if errors.Is(err, database.ErrRefreshTokenNotFound) {
+			writeCodedErrorResponse(w, "Invalid refresh token", CodeRefreshTokenInvalid, http.StatusUnauthorized)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if fromCookie && !middleware.CSRFValid(s.tokens, r, stored.FamilyID) {
+		writeCSRFTokenInvalid(w)
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		writeCodedErrorResponse(w, "Invalid refresh token", CodeRefreshTokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	if stored.UsedAt != nil {
+		s.revokeReusedFamily(w, r, stored)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		writeCodedErrorResponse(w, "Refresh token has expired", CodeRefreshTokenExpired, http.StatusUnauthorized)
+		return
+	}
+
+	// A revoked session takes its refresh tokens down with it
+	session, err := s.db.Sessions().GetSession(ctx, stored.FamilyID)
+	if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if session == nil || !session.Active(time.Now()) {
+		writeCodedErrorResponse(w, "Invalid refresh token", CodeRefreshTokenInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.db.RefreshTokens().MarkRefreshTokenUsed(ctx, stored.ID); err != nil {
+		if errors.Is(err, database.ErrRefreshTokenUsed) {
+			// Lost a race against another request rotating the same token
+			s.revokeReusedFamily(w, r, stored)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			writeCodedErrorResponse(w, "Invalid refresh token", CodeRefreshTokenInvalid, http.StatusUnauthorized)
+			return
+		}
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user.Suspended() {
+		writeCodedErrorResponse(w, "This account has been suspended", CodeAccountSuspended, http.StatusForbidden)
+		return
+	}
+
+	// Each rotation keeps the session alive for another refresh lifetime,
+	// except impersonation, which ends when it was meant to
+	if session.ImpersonatorID == 0 {
+		if err := s.db.Sessions().ExtendSession(ctx, session.ID, time.Now().Add(s.refreshTTL)); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response, err := s.issueTokenPair(r, user, session)
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSession(w, response, http.StatusOK)
+}
+
+// revokeReusedFamily revokes a refresh token family, and the session it
+// belongs to, after reuse was detected
+func (s *Service) revokeReusedFamily(w http.ResponseWriter, r *http.Request, reused *database.RefreshToken) {
+	if err := s.revokeSession(r.Context(), reused.FamilyID); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeCodedErrorResponse(w, "Refresh token has already been used", CodeRefreshTokenReused, http.StatusUnauthorized)
+}
+
+// Logout revokes the session the given refresh token belongs to. It
+// succeeds for unknown tokens so that logging out is idempotent.
+func (s *Service) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, fromCookie, ok := s.requestRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	stored, err := s.db.RefreshTokens().GetRefreshTokenByHash(r.Context(), token.HashOpaque(refreshToken))
+	if err != nil && !errors.Is(err, database.ErrRefreshTokenNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if stored != nil {
+		if fromCookie && !middleware.CSRFValid(s.tokens, r, stored.FamilyID) {
+			writeCSRFTokenInvalid(w)
+			return
+		}
+		if err := s.revokeSession(r.Context(), stored.FamilyID); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.clearSessionCookies(w)
+	writeJSONResponse(w, map[string]string{"message": "Logged out successfully"}, http.StatusOK)
+}
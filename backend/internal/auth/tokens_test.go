@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// loginTestUser creates the standard test user and logs in as them
+func loginTestUser(t *testing.T, service *Service, db database.Database) AuthResponse {
+	t.Helper()
+
+	hashedPassword := "$2a$10$5dPLX3zUSjWoaGGf.xz2muh.QGPmYFmKiFmdgiVCMOuuew0MA9AhC" // "password123"
+	_, err := db.Users().CreateUser(context.Background(), &database.User{
+		Name:     "John Doe",
+		Email:    "john@example.com",
+		Password: hashedPassword,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email": "john@example.com", "password": "password123"}`))
+	rr := httptest.NewRecorder()
+	service.Login(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+	return response
+}
+
+func postRefreshToken(service *Service, handler func(http.ResponseWriter, *http.Request), refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest("POST", "/auth/refresh", strings.NewReader(string(body)))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestService_Login_IssuesRefreshToken(t *testing.T) {
+	service, db := setupTestService()
+	response := loginTestUser(t, service, db)
+
+	if response.RefreshToken == "" {
+		t.Fatal("Expected refresh token in login response")
+	}
+	if response.TokenType != "Bearer" || response.ExpiresIn <= 0 {
+		t.Errorf("Unexpected token metadata: type=%q expires_in=%d", response.TokenType, response.ExpiresIn)
+	}
+}
+
+func TestService_Refresh_Rotation(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	rr := postRefreshToken(service, service.Refresh, login.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var rotated AuthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&rotated); err != nil {
+		t.Fatalf("Failed to decode refresh response: %v", err)
+	}
+	if rotated.Token == "" || rotated.RefreshToken == "" {
+		t.Fatal("Expected new access and refresh tokens")
+	}
+	if rotated.RefreshToken == login.RefreshToken {
+		t.Error("Expected refresh token to be rotated")
+	}
+
+	// The rotated token keeps working
+	rr = postRefreshToken(service, service.Refresh, rotated.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected rotated token to be accepted, got %d", rr.Code)
+	}
+}
+
+func TestService_Refresh_ReuseRevokesFamily(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	rr := postRefreshToken(service, service.Refresh, login.RefreshToken)
+	var rotated AuthResponse
+	json.NewDecoder(rr.Body).Decode(&rotated)
+
+	// Replaying the original token is detected as reuse
+	rr = postRefreshToken(service, service.Refresh, login.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	var errResponse ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&errResponse)
+	if errResponse.Code != CodeRefreshTokenReused {
+		t.Errorf("Expected code '%s', got '%s'", CodeRefreshTokenReused, errResponse.Code)
+	}
+
+	// And the legitimate successor is revoked along with the family
+	rr = postRefreshToken(service, service.Refresh, rotated.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected rotated token to be revoked, got %d", rr.Code)
+	}
+}
+
+func TestService_Refresh_InvalidToken(t *testing.T) {
+	service, _ := setupTestService()
+
+	rr := postRefreshToken(service, service.Refresh, "does-not-exist")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	var response ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Code != CodeRefreshTokenInvalid {
+		t.Errorf("Expected code '%s', got '%s'", CodeRefreshTokenInvalid, response.Code)
+	}
+
+	rr = postRefreshToken(service, service.Refresh, "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for empty token, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestService_Logout(t *testing.T) {
+	service, db := setupTestService()
+	login := loginTestUser(t, service, db)
+
+	rr := postRefreshToken(service, service.Logout, login.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = postRefreshToken(service, service.Refresh, login.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected refresh after logout to fail, got %d", rr.Code)
+	}
+
+	// Logging out twice is not an error
+	rr = postRefreshToken(service, service.Logout, login.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected repeated logout to succeed, got %d", rr.Code)
+	}
+}
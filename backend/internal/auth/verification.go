@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+)
+
+// Error codes returned by the email verification endpoints
+const (
+	CodeVerificationTokenInvalid  = "verification_token_invalid"
+	CodeVerificationNotConfigured = "verification_not_configured"
+)
+
+// ResendVerificationRequest is the body of POST /auth/verify/resend
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// sendVerificationEmail emails the user a verification link. Failures are
+// not reported, since the user can ask for another link.
+func (s *Service) sendVerificationEmail(r *http.Request, user *User) {
+	if s.emailTokens == nil || user.EmailVerified() {
+		return
+	}
+
+	s.emailTokens.RequestEmailVerification(email.EmailVerificationRequest{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		RequestIP: middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+}
+
+// VerifyEmail marks the user's email address verified using the token from
+// the link they were emailed
+func (s *Service) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Email verification is not configured", CodeVerificationNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	verificationToken := r.URL.Query().Get("token")
+	if verificationToken == "" {
+		writeErrorResponse(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	emailToken, err := s.emailTokens.VerifyEmailToken(verificationToken)
+	if errors.Is(err, email.ErrInvalidToken) || errors.Is(err, email.ErrTokenExpired) {
+		writeCodedErrorResponse(w, "Invalid or expired verification link", CodeVerificationTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	user, err := s.db.Users().GetUserByID(ctx, emailToken.UserID)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The link proves ownership of the address it was sent to, not of
+	// whatever address the account has moved to since
+	if user == nil || !strings.EqualFold(user.Email, emailToken.Email) {
+		writeCodedErrorResponse(w, "Invalid or expired verification link", CodeVerificationTokenInvalid, http.StatusBadRequest)
+		return
+	}
+
+	if !user.EmailVerified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if user, err = s.db.Users().UpdateUser(ctx, user); err != nil {
+			writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.bootstrapAdmin(ctx, user); err != nil {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, AuthResponse{User: *user}, http.StatusOK)
+}
+
+// ResendVerification emails a new verification link. Like ForgotPassword it
+// answers the same way for every address, so it can't be used to find users.
+func (s *Service) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.emailTokens == nil {
+		writeCodedErrorResponse(w, "Email verification is not configured", CodeVerificationNotConfigured, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Email) == "" {
+		writeErrorResponse(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.Users().GetUserByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		writeErrorResponse(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user != nil {
+		s.sendVerificationEmail(r, user)
+	}
+
+	writeJSONResponse(w, map[string]string{
+		"message": "If that email needs verifying, a new link has been sent",
+	}, http.StatusAccepted)
+}
+
+// HandleVerifyEmail is a wrapper around the service VerifyEmail method
+func HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.VerifyEmail(w, r)
+}
+
+// HandleResendVerification is a wrapper around the service ResendVerification method
+func HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if globalAuthService == nil {
+		writeErrorResponse(w, "Auth service not initialized", http.StatusInternalServerError)
+		return
+	}
+	globalAuthService.ResendVerification(w, r)
+}
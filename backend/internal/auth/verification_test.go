@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// registerTestUser registers a user through the handler and returns them
+func registerTestUser(t *testing.T, service *Service, db database.Database) *User {
+	t.Helper()
+
+	body := `{"name": "Jane Doe", "email": "jane@example.com", "password": "Password123!"}`
+	rr := httptest.NewRecorder()
+	service.Register(rr, httptest.NewRequest("POST", "/auth/register", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Register failed with status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	user, err := db.Users().GetUserByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatalf("Failed to load registered user: %v", err)
+	}
+	return user
+}
+
+// verificationToken pulls the token out of an emailed verification link
+func verificationToken(t *testing.T, link string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Query().Get("token") == "" {
+		t.Fatalf("Expected a verification link, got %q", link)
+	}
+	return parsed.Query().Get("token")
+}
+
+func getVerifyEmail(service *Service, verificationToken string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	service.VerifyEmail(rr, httptest.NewRequest("GET", "/auth/verify?token="+url.QueryEscape(verificationToken), nil))
+	return rr
+}
+
+func TestRegister_SendsVerificationEmail(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+
+	user := registerTestUser(t, service, db)
+	if user.EmailVerified() {
+		t.Error("Expected a new account to be unverified")
+	}
+	if len(emails.verificationURLs) != 1 {
+		t.Fatalf("Expected one verification email, got %d", len(emails.verificationURLs))
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	registerTestUser(t, service, db)
+	verification := verificationToken(t, emails.verificationURLs[0])
+
+	if rr := getVerifyEmail(service, "forged"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), CodeVerificationTokenInvalid) {
+		t.Errorf("Expected forged token to be rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := getVerifyEmail(service, verification)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response AuthResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if !response.User.EmailVerified() {
+		t.Error("Expected the response to show the email verified")
+	}
+
+	user, _ := db.Users().GetUserByEmail(context.Background(), "jane@example.com")
+	if !user.EmailVerified() {
+		t.Error("Expected the user to be verified")
+	}
+
+	// Links work once
+	if rr := getVerifyEmail(service, verification); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected used link to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestVerifyEmail_RejectsLinkForOldAddress(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	user := registerTestUser(t, service, db)
+
+	user.Email = "jane.new@example.com"
+	db.Users().UpdateUser(context.Background(), user)
+
+	if rr := getVerifyEmail(service, verificationToken(t, emails.verificationURLs[0])); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected link for the old address to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	service, db, emails := setupFullTestService(t)
+	registerTestUser(t, service, db)
+
+	resend := func(emailAddress string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ResendVerificationRequest{Email: emailAddress})
+		rr := httptest.NewRecorder()
+		service.ResendVerification(rr, httptest.NewRequest("POST", "/auth/verify/resend", strings.NewReader(string(body))))
+		return rr
+	}
+
+	known, unknown := resend("jane@example.com"), resend("nobody@example.com")
+	if known.Code != http.StatusAccepted || unknown.Code != http.StatusAccepted || known.Body.String() != unknown.Body.String() {
+		t.Fatalf("Expected identical 202 responses, got %d %q and %d %q", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	if len(emails.verificationURLs) != 2 {
+		t.Fatalf("Expected a second verification email, got %d", len(emails.verificationURLs))
+	}
+
+	// Verified addresses aren't sent another link
+	getVerifyEmail(service, verificationToken(t, emails.verificationURLs[1]))
+	resend("jane@example.com")
+	if len(emails.verificationURLs) != 2 {
+		t.Errorf("Expected no email for a verified address, got %d", len(emails.verificationURLs))
+	}
+}
@@ -0,0 +1,284 @@
+// Package cbor implements the subset of CBOR (RFC 8949) needed for WebAuthn:
+// integers, byte and text strings, arrays, maps, booleans and null.
+//
+// Decoded values use these Go types: int64 for integers, []byte, string,
+// []interface{}, map[interface{}]interface{}, bool and nil.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Major types
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+)
+
+// maxDepth bounds nesting so hostile input cannot exhaust the stack
+const maxDepth = 16
+
+// Errors returned by Decode
+var (
+	ErrUnexpectedEnd = errors.New("cbor: unexpected end of data")
+	ErrUnsupported   = errors.New("cbor: unsupported item")
+)
+
+// Decode decodes the first data item in data and returns it together with
+// the bytes that follow it
+func Decode(data []byte) (interface{}, []byte, error) {
+	d := decoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, d.data[d.pos:], nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", ErrUnsupported)
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUnsigned:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrUnsupported)
+		}
+		return int64(arg), nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrUnsupported)
+		}
+		return -1 - int64(arg), nil
+	case majorBytes, majorText:
+		b, err := d.take(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == majorText {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case majorArray:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrUnexpectedEnd
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case majorMap:
+		if arg > uint64(len(d.data)) {
+			return nil, ErrUnexpectedEnd
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: map key must be an integer or string", ErrUnsupported)
+			}
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := m[key]; dup {
+				return nil, fmt.Errorf("%w: duplicate map key", ErrUnsupported)
+			}
+			m[key] = value
+		}
+		return m, nil
+	case majorTag:
+		// Tags carry no meaning for WebAuthn; return the tagged item
+		return d.decode(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: simple value or float", ErrUnsupported)
+	}
+}
+
+// head reads an item's initial byte and argument
+func (d *decoder) head() (byte, uint64, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		v, err := d.take(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(v[0]), nil
+	case info == 25:
+		v, err := d.take(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(v)), nil
+	case info == 26:
+		v, err := d.take(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(v)), nil
+	case info == 27:
+		v, err := d.take(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(v), nil
+	default:
+		// 28-30 are reserved, 31 marks indefinite length items
+		return 0, 0, fmt.Errorf("%w: indefinite length or reserved encoding", ErrUnsupported)
+	}
+}
+
+func (d *decoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrUnexpectedEnd
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// Marshal encodes a value using the same Go types Decode produces, plus int.
+// Map keys are sorted in canonical order so output is deterministic.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		encodeInt(buf, int64(v))
+	case int64:
+		encodeInt(buf, v)
+	case []byte:
+		writeHead(buf, majorBytes, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		writeHead(buf, majorText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeHead(buf, majorArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(v))
+		for key, value := range v {
+			k, err := Marshal(key)
+			if err != nil {
+				return err
+			}
+			val, err := Marshal(value)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{k, val})
+		}
+		// Canonical CBOR: shorter keys first, then bytewise
+		sort.Slice(entries, func(i, j int) bool {
+			if len(entries[i].key) != len(entries[j].key) {
+				return len(entries[i].key) < len(entries[j].key)
+			}
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		writeHead(buf, majorMap, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			buf.Write(e.value)
+		}
+	case bool:
+		if v {
+			buf.WriteByte(majorSimple<<5 | 21)
+		} else {
+			buf.WriteByte(majorSimple<<5 | 20)
+		}
+	case nil:
+		buf.WriteByte(majorSimple<<5 | 22)
+	default:
+		return fmt.Errorf("%w: cannot encode %T", ErrUnsupported, v)
+	}
+	return nil
+}
+
+func encodeInt(buf *bytes.Buffer, v int64) {
+	if v >= 0 {
+		writeHead(buf, majorUnsigned, uint64(v))
+	} else {
+		writeHead(buf, majorNegative, uint64(-1-v))
+	}
+}
+
+func writeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(arg))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, arg)
+	}
+}
@@ -0,0 +1,119 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecode_RFCExamples(t *testing.T) {
+	// Examples from RFC 8949 Appendix A
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+	}
+
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.hex)
+		got, rest, err := Decode(data)
+		if err != nil {
+			t.Errorf("Decode(%s) error = %v", tt.hex, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("Decode(%s) left %d bytes", tt.hex, len(rest))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%s) = %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	tests := []string{
+		"",                        // empty
+		"1a0000",                  // truncated argument
+		"44010203",                // truncated byte string
+		"9f01ff",                  // indefinite length array
+		"f97c00",                  // half precision float
+		"a2010201",                // truncated map
+		"a201020102",              // duplicate key
+		"9b" + "ffffffffffffffff", // absurd array length
+	}
+
+	for _, h := range tests {
+		data, _ := hex.DecodeString(h)
+		if _, _, err := Decode(data); err == nil {
+			t.Errorf("Decode(%s) expected error", h)
+		} else if !errors.Is(err, ErrUnexpectedEnd) && !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Decode(%s) unexpected error type: %v", h, err)
+		}
+	}
+}
+
+func TestDecode_ReturnsRest(t *testing.T) {
+	data, _ := hex.DecodeString("0102")
+	v, rest, err := Decode(data)
+	if err != nil || v != int64(1) || !bytes.Equal(rest, []byte{2}) {
+		t.Errorf("Decode() = %v, %x, %v", v, rest, err)
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	value := map[interface{}]interface{}{
+		1:     2,
+		3:     -7,
+		-2:    []byte{0xde, 0xad},
+		"fmt": "none",
+		"arr": []interface{}{true, false, nil, int64(70000)},
+	}
+
+	data, err := Marshal(value)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	decoded, rest, err := Decode(data)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("Decode() error = %v, rest = %d", err, len(rest))
+	}
+
+	want := map[interface{}]interface{}{
+		int64(1):  int64(2),
+		int64(3):  int64(-7),
+		int64(-2): []byte{0xde, 0xad},
+		"fmt":     "none",
+		"arr":     []interface{}{true, false, nil, int64(70000)},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("Round trip = %#v, want %#v", decoded, want)
+	}
+
+	again, _ := Marshal(value)
+	if !bytes.Equal(data, again) {
+		t.Error("Expected deterministic encoding")
+	}
+
+	if _, err := Marshal(3.14); err == nil {
+		t.Error("Expected floats to be rejected")
+	}
+}
@@ -17,8 +17,13 @@ type AppConfig struct {
 	AppBaseURL      string
 	DashboardURL    string
 	VerificationURL string
+	MagicLinkURL    string
 	SecurityURL     string
 
+	EmailChangeConfirmURL string
+	EmailChangeCancelURL  string
+	InvitationURL         string
+
 	// Email configuration
 	EmailFromDomain string
 	EmailFromName   string
@@ -55,8 +60,13 @@ func loadAppConfig() *AppConfig {
 		AppBaseURL:      getEnvOrDefault("APP_BASE_URL", "https://app.saasplatform.com"),
 		DashboardURL:    getEnvOrDefault("DASHBOARD_URL", "https://app.saasplatform.com/dashboard"),
 		VerificationURL: getEnvOrDefault("VERIFICATION_BASE_URL", "https://app.saasplatform.com/verify"),
+		MagicLinkURL:    getEnvOrDefault("MAGIC_LINK_BASE_URL", "https://app.saasplatform.com/magic-link"),
 		SecurityURL:     getEnvOrDefault("SECURITY_URL", "https://app.saasplatform.com/settings/security"),
 
+		EmailChangeConfirmURL: getEnvOrDefault("EMAIL_CHANGE_CONFIRM_BASE_URL", "https://app.saasplatform.com/email-change/confirm"),
+		EmailChangeCancelURL:  getEnvOrDefault("EMAIL_CHANGE_CANCEL_BASE_URL", "https://app.saasplatform.com/email-change/cancel"),
+		InvitationURL:         getEnvOrDefault("INVITATION_BASE_URL", "https://app.saasplatform.com/invitations/accept"),
+
 		// Email configuration
 		EmailFromDomain: getEnvOrDefault("EMAIL_FROM_DOMAIN", "saasplatform.com"),
 		EmailFromName:   getEnvOrDefault("EMAIL_FROM_NAME", "SaaSPlatform"),
@@ -88,6 +98,29 @@ func (c *AppConfig) GetVerificationURL(token string) string {
 	return c.VerificationURL + "?token=" + token
 }
 
+// GetMagicLinkURL returns a complete sign-in link with token
+func (c *AppConfig) GetMagicLinkURL(token string) string {
+	return c.MagicLinkURL + "?token=" + token
+}
+
+// GetEmailChangeConfirmURL returns a complete link confirming a new email
+// address with token
+func (c *AppConfig) GetEmailChangeConfirmURL(token string) string {
+	return c.EmailChangeConfirmURL + "?token=" + token
+}
+
+// GetEmailChangeCancelURL returns a complete link cancelling an email change
+// with token
+func (c *AppConfig) GetEmailChangeCancelURL(token string) string {
+	return c.EmailChangeCancelURL + "?token=" + token
+}
+
+// GetInvitationURL returns a complete link accepting an organization
+// invitation with token
+func (c *AppConfig) GetInvitationURL(token string) string {
+	return c.InvitationURL + "?token=" + token
+}
+
 // GetWelcomeSubject returns the welcome email subject
 func (c *AppConfig) GetWelcomeSubject() string {
 	return "Welcome to " + c.AppDisplayName + "!"
@@ -103,6 +136,28 @@ func (c *AppConfig) GetVerificationSubject() string {
 	return "Verify Your Email - " + c.AppDisplayName
 }
 
+// GetMagicLinkSubject returns the sign-in link email subject
+func (c *AppConfig) GetMagicLinkSubject() string {
+	return "Sign In to " + c.AppDisplayName
+}
+
+// GetEmailChangeConfirmSubject returns the new address confirmation subject
+func (c *AppConfig) GetEmailChangeConfirmSubject() string {
+	return "Confirm Your New Email - " + c.AppDisplayName
+}
+
+// GetEmailChangeNoticeSubject returns the subject of the notice sent to the
+// old address when the email is being changed
+func (c *AppConfig) GetEmailChangeNoticeSubject() string {
+	return "Email Change Requested - " + c.AppDisplayName
+}
+
+// GetInvitationSubject returns the subject of an invitation to join an
+// organization
+func (c *AppConfig) GetInvitationSubject(organizationName string) string {
+	return "You're invited to join " + organizationName + " on " + c.AppDisplayName
+}
+
 // GetSecurityAlertSubject returns the security alert email subject
 func (c *AppConfig) GetSecurityAlertSubject() string {
 	return "Security Alert - " + c.AppDisplayName
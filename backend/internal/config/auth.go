@@ -0,0 +1,343 @@
+package config
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthConfig holds authentication and token configuration
+type AuthConfig struct {
+	// JWT signing
+	JWTAlgorithm      string
+	JWTSecret         string
+	JWTPrivateKeyFile string
+	JWTKeyID          string
+	JWTIssuer         string
+	JWTAudience       string
+
+	// Token lifetimes
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Two-factor authentication
+	MFAEncryptionKey string // base64 encoded 32 byte key for TOTP secrets
+	MFAIssuer        string // Issuer shown in authenticator apps
+
+	// Passkeys
+	WebAuthnRPID        string   // Relying party ID, a registrable domain
+	WebAuthnRPName      string   // Name shown by the browser during registration
+	WebAuthnOrigins     []string // Origins allowed to run ceremonies
+	WebAuthnAttestation string   // "none" or "direct"
+
+	// OpenID Connect login providers
+	OIDCProviders []OIDCProviderConfig
+
+	// Brute-force protection on password login
+	LoginMaxFailures      int           // Failures per email address before it is locked
+	LoginMaxFailuresPerIP int           // Failures per client IP before it is locked
+	LoginLockoutDuration  time.Duration // How long a lock lasts and failures are remembered
+
+	// What users who haven't verified their email may do: allow, read_only or block
+	EmailVerificationPolicy string
+
+	// Password hashing
+	PasswordHashAlgorithm string // argon2id or bcrypt, for new hashes
+	Argon2Memory          int    // KiB
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	BcryptCost            int
+	PasswordPepper        string // Server-side secret mixed into argon2id hashes
+
+	// Rules for new passwords
+	PasswordMinLength          int
+	PasswordMaxLength          int
+	PasswordRequireUpper       bool
+	PasswordRequireLower       bool
+	PasswordRequireDigit       bool
+	PasswordRequireSpecial     bool
+	PasswordRejectPersonalInfo bool   // Refuse passwords containing the user's name or email
+	PasswordHistory            int    // Previous passwords that may not be reused, counting the current one
+	PasswordBreachCheck        string // off, api or file
+	PasswordBreachAPIURL       string // Pwned Passwords compatible range API
+	PasswordBreachFile         string // Sorted SHA-1 hash list, for servers without network access
+
+	// Whether anyone may create an account, by registering or by signing in
+	// with a magic link for an unknown address
+	OpenRegistration bool
+
+	// How long a deleted account can still be recovered by signing in
+	AccountDeletionGracePeriod time.Duration
+
+	// Verified address given the admin role while nobody has it
+	BootstrapAdminEmail string
+
+	// How long an administrator's session as another user lasts, and
+	// whether the user is emailed when one starts
+	ImpersonationTTL        time.Duration
+	ImpersonationNotifyUser bool
+
+	// How long an emailed invitation to join an organization stays valid
+	InvitationTTL time.Duration
+
+	// Whether users are emailed when they sign in from a device they
+	// haven't used before, and how long a browser keeps its device cookie
+	NewDeviceAlerts    bool
+	DeviceCookieMaxAge time.Duration
+
+	// Session cookies carry browser sessions instead of bearer tokens
+	SessionCookies        bool
+	SessionCookieDomain   string
+	SessionCookieSecure   bool
+	SessionCookieSameSite string // "lax", "strict" or "none"
+
+	// Origins allowed to call the API from a browser. With session cookies
+	// on, only origins named here can send them.
+	CORSAllowedOrigins []string
+
+	// Lifetimes of the tokens the OAuth authorization server issues to
+	// third-party clients
+	OAuthAccessTokenTTL  time.Duration
+	OAuthRefreshTokenTTL time.Duration
+
+	// SAMLBaseURL is where identity providers reach this API's SAML
+	// endpoints. Service provider entity IDs and ACS URLs are built on it.
+	SAMLBaseURL string
+
+	// SCIMBaseURL is the base of the SCIM endpoints, given to organization
+	// owners to configure their identity provider with. Resource
+	// locations are built on it.
+	SCIMBaseURL string
+}
+
+// OIDCProviderConfig configures one OpenID Connect login provider
+type OIDCProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// wellKnownOIDCProviders fills in defaults for providers with a fixed issuer
+var wellKnownOIDCProviders = map[string]OIDCProviderConfig{
+	"google": {DisplayName: "Google", Issuer: "https://accounts.google.com"},
+}
+
+var (
+	authConfig *AuthConfig
+	authOnce   sync.Once
+)
+
+// GetAuthConfig returns the singleton auth configuration
+func GetAuthConfig() *AuthConfig {
+	authOnce.Do(func() {
+		authConfig = loadAuthConfig()
+	})
+	return authConfig
+}
+
+// loadAuthConfig loads auth configuration from environment variables with sensible defaults
+func loadAuthConfig() *AuthConfig {
+	return &AuthConfig{
+		// JWT signing - HS256 needs JWT_SECRET, RS256/EdDSA need JWT_PRIVATE_KEY_FILE
+		JWTAlgorithm:      getEnvOrDefault("JWT_ALGORITHM", "HS256"),
+		JWTSecret:         getEnvOrDefault("JWT_SECRET", ""),
+		JWTPrivateKeyFile: getEnvOrDefault("JWT_PRIVATE_KEY_FILE", ""),
+		JWTKeyID:          getEnvOrDefault("JWT_KEY_ID", "default"),
+		JWTIssuer:         getEnvOrDefault("JWT_ISSUER", GetAppConfig().AppBaseURL),
+		JWTAudience:       getEnvOrDefault("JWT_AUDIENCE", GetAppConfig().AppName+"-api"),
+
+		// Token lifetimes
+		AccessTokenTTL:  getEnvDurationOrDefault("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDurationOrDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		// Two-factor authentication
+		MFAEncryptionKey: getEnvOrDefault("MFA_ENCRYPTION_KEY", ""),
+		MFAIssuer:        getEnvOrDefault("MFA_ISSUER", GetAppConfig().AppDisplayName),
+
+		// Passkeys - the relying party defaults to the application's own host
+		WebAuthnRPID:        getEnvOrDefault("WEBAUTHN_RP_ID", hostname(GetAppConfig().AppBaseURL)),
+		WebAuthnRPName:      getEnvOrDefault("WEBAUTHN_RP_NAME", GetAppConfig().AppDisplayName),
+		WebAuthnOrigins:     getEnvListOrDefault("WEBAUTHN_ORIGINS", []string{GetAppConfig().AppBaseURL}),
+		WebAuthnAttestation: getEnvOrDefault("WEBAUTHN_ATTESTATION", "none"),
+
+		// OpenID Connect - OIDC_PROVIDERS lists names, each configured with OIDC_<NAME>_* variables
+		OIDCProviders: loadOIDCProviders(getEnvListOrDefault("OIDC_PROVIDERS", nil)),
+
+		// Brute-force protection
+		LoginMaxFailures:      getEnvIntOrDefault("LOGIN_MAX_FAILURES", 10),
+		LoginMaxFailuresPerIP: getEnvIntOrDefault("LOGIN_MAX_FAILURES_PER_IP", 100),
+		LoginLockoutDuration:  getEnvDurationOrDefault("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+
+		// Email verification
+		EmailVerificationPolicy: getEnvOrDefault("EMAIL_VERIFICATION_POLICY", "allow"),
+
+		// Password hashing - existing hashes are upgraded as users log in
+		PasswordHashAlgorithm: getEnvOrDefault("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		Argon2Memory:          getEnvIntOrDefault("ARGON2_MEMORY", 19*1024),
+		Argon2Iterations:      getEnvIntOrDefault("ARGON2_ITERATIONS", 2),
+		Argon2Parallelism:     getEnvIntOrDefault("ARGON2_PARALLELISM", 1),
+		BcryptCost:            getEnvIntOrDefault("BCRYPT_COST", 10),
+		PasswordPepper:        getEnvOrDefault("PASSWORD_PEPPER", ""),
+
+		// Password policy - applied at registration, reset and password change
+		PasswordMinLength:          getEnvIntOrDefault("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:          getEnvIntOrDefault("PASSWORD_MAX_LENGTH", 128),
+		PasswordRequireUpper:       getEnvBoolOrDefault("PASSWORD_REQUIRE_UPPER", true),
+		PasswordRequireLower:       getEnvBoolOrDefault("PASSWORD_REQUIRE_LOWER", true),
+		PasswordRequireDigit:       getEnvBoolOrDefault("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSpecial:     getEnvBoolOrDefault("PASSWORD_REQUIRE_SPECIAL", true),
+		PasswordRejectPersonalInfo: getEnvBoolOrDefault("PASSWORD_REJECT_PERSONAL_INFO", true),
+		PasswordHistory:            getEnvNonNegativeIntOrDefault("PASSWORD_HISTORY", 5),
+		PasswordBreachCheck:        getEnvOrDefault("PASSWORD_BREACH_CHECK", "off"),
+		PasswordBreachAPIURL:       getEnvOrDefault("PASSWORD_BREACH_API_URL", "https://api.pwnedpasswords.com"),
+		PasswordBreachFile:         getEnvOrDefault("PASSWORD_BREACH_FILE", ""),
+
+		// Sign-up
+		OpenRegistration: getEnvBoolOrDefault("OPEN_REGISTRATION", true),
+
+		// Account deletion
+		AccountDeletionGracePeriod: getEnvDurationOrDefault("ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour),
+
+		// Roles - the first administrator
+		BootstrapAdminEmail: getEnvOrDefault("BOOTSTRAP_ADMIN_EMAIL", ""),
+
+		// Impersonation
+		ImpersonationTTL:        getEnvDurationOrDefault("IMPERSONATION_TTL", time.Hour),
+		ImpersonationNotifyUser: getEnvBoolOrDefault("IMPERSONATION_NOTIFY_USER", true),
+
+		// Organization invitations
+		InvitationTTL: getEnvDurationOrDefault("INVITATION_TTL", 7*24*time.Hour),
+
+		// New-device alerts
+		NewDeviceAlerts:    getEnvBoolOrDefault("NEW_DEVICE_ALERTS", true),
+		DeviceCookieMaxAge: getEnvDurationOrDefault("DEVICE_COOKIE_MAX_AGE", 400*24*time.Hour),
+
+		// Session cookies - off by default, so clients send bearer tokens
+		SessionCookies:        getEnvBoolOrDefault("SESSION_COOKIES", false),
+		SessionCookieDomain:   getEnvOrDefault("SESSION_COOKIE_DOMAIN", ""),
+		SessionCookieSecure:   getEnvBoolOrDefault("SESSION_COOKIE_SECURE", true),
+		SessionCookieSameSite: strings.ToLower(getEnvOrDefault("SESSION_COOKIE_SAMESITE", "lax")),
+
+		// CORS
+		CORSAllowedOrigins: getEnvListOrDefault("CORS_ALLOWED_ORIGINS", []string{"*"}),
+
+		// OAuth authorization server
+		OAuthAccessTokenTTL:  getEnvDurationOrDefault("OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+		OAuthRefreshTokenTTL: getEnvDurationOrDefault("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		// SAML single sign-on
+		SAMLBaseURL: strings.TrimRight(getEnvOrDefault("SAML_SP_BASE_URL", GetAppConfig().AppBaseURL), "/"),
+
+		// SCIM provisioning
+		SCIMBaseURL: strings.TrimRight(getEnvOrDefault("SCIM_BASE_URL", GetAppConfig().AppBaseURL+"/scim/v2"), "/"),
+	}
+}
+
+// loadOIDCProviders reads the configuration of each named provider
+func loadOIDCProviders(names []string) []OIDCProviderConfig {
+	providers := make([]OIDCProviderConfig, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		defaults := wellKnownOIDCProviders[name]
+
+		displayName := defaults.DisplayName
+		if displayName == "" {
+			displayName = name
+		}
+
+		providers = append(providers, OIDCProviderConfig{
+			Name:         name,
+			DisplayName:  getEnvOrDefault(prefix+"DISPLAY_NAME", displayName),
+			Issuer:       getEnvOrDefault(prefix+"ISSUER", defaults.Issuer),
+			ClientID:     getEnvOrDefault(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnvOrDefault(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnvOrDefault(prefix+"REDIRECT_URL", GetAppConfig().AppBaseURL+"/auth/oidc/"+name+"/callback"),
+			Scopes:       getEnvListOrDefault(prefix+"SCOPES", nil),
+		})
+	}
+	return providers
+}
+
+// getEnvListOrDefault parses a comma separated environment variable or returns a default
+func getEnvListOrDefault(key string, defaultValue []string) []string {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// hostname returns the host of a URL without its port
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// getEnvDurationOrDefault parses a duration environment variable or returns a default
+func getEnvDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return defaultValue
+	}
+	return duration
+}
+
+// getEnvBoolOrDefault parses a boolean environment variable or returns a default
+func getEnvBoolOrDefault(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getEnvOrDefault(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvIntOrDefault parses a positive integer environment variable or returns a default
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvNonNegativeIntOrDefault parses an environment variable that may be
+// zero or positive, or returns a default
+func getEnvNonNegativeIntOrDefault(key string, defaultValue int) int {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return defaultValue
+	}
+	return n
+}
\ No newline at end of file
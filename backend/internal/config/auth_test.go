@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestLoadAuthConfig_PasswordHistory(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"unset", "", 5},
+		{"zero turns history off", "0", 0},
+		{"positive", "3", 3},
+		{"negative", "-1", 5},
+		{"not a number", "five", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_HISTORY", tt.value)
+
+			if got := loadAuthConfig().PasswordHistory; got != tt.want {
+				t.Errorf("PasswordHistory = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
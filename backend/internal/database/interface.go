@@ -13,6 +13,48 @@ type User struct {
 	Password  string    `json:"-"` // Don't include in JSON responses
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Two-factor authentication. TOTPSecret is encrypted at rest and is set
+	// while enrollment is pending, before TOTPEnabled flips on.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"` // Last accepted time step, to stop code replay
+
+	// EmailVerifiedAt is when the user proved they own Email, nil until then
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+
+	// DeletionScheduledAt is when the account will be purged, nil unless the
+	// user asked for it to be deleted
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+
+	// SuspendedAt is when an administrator suspended the account, nil
+	// unless it is suspended. Suspended users can't sign in.
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+
+	// PasswordResetRequired stops password logins until the user resets
+	// their password
+	PasswordResetRequired bool `json:"password_reset_required"`
+}
+
+// EmailVerified reports whether the user has verified their email address
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Suspended reports whether an administrator has suspended the account
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// UserFilter narrows a user search. Zero fields match every user.
+type UserFilter struct {
+	Query         string     // Part of the email or name, ignoring case
+	CreatedAfter  *time.Time // Created at or after
+	CreatedBefore *time.Time // Created before
+	Verified      *bool      // Whether the email is verified
+	Suspended     *bool      // Whether the account is suspended
+	Limit         int
+	Offset        int
 }
 
 // UserRepository defines the interface for user data operations
@@ -35,15 +77,1014 @@ type UserRepository interface {
 	// ListUsers retrieves all users (with optional pagination)
 	ListUsers(ctx context.Context, limit, offset int) ([]*User, error)
 
+	// ListUsersDueForDeletion retrieves the users whose scheduled deletion
+	// is at or before the given time
+	ListUsersDueForDeletion(ctx context.Context, before time.Time) ([]*User, error)
+
+	// SearchUsers retrieves the users matching a filter, newest first
+	SearchUsers(ctx context.Context, filter UserFilter) ([]*User, error)
+
 	// Close closes any database connections
 	Close() error
 }
 
+// RefreshToken represents an opaque refresh token. Tokens issued from the same
+// login share a FamilyID so that the whole chain can be revoked on reuse.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	TokenHash string     `json:"-"` // SHA-256 of the token, never the token itself
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RequestIP string     `json:"request_ip"`
+	UserAgent string     `json:"user_agent"`
+}
+
+// RefreshTokenRepository defines the interface for refresh token operations
+type RefreshTokenRepository interface {
+	// CreateRefreshToken stores a new refresh token
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error)
+
+	// GetRefreshTokenByHash retrieves a refresh token by its hash
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error)
+
+	// MarkRefreshTokenUsed atomically marks a token as used. It returns
+	// ErrRefreshTokenUsed if the token had already been used.
+	MarkRefreshTokenUsed(ctx context.Context, id int) error
+
+	// RevokeRefreshTokenFamily revokes every token in a family
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+
+	// RevokeUserRefreshTokens revokes every refresh token belonging to a user
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+
+	// DeleteExpiredRefreshTokens removes tokens that expired before the given time
+	DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int, error)
+}
+
+// RecoveryCode is a single-use two-factor recovery code, stored hashed
+type RecoveryCode struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RecoveryCodeRepository defines the interface for recovery code operations
+type RecoveryCodeRepository interface {
+	// ReplaceRecoveryCodes discards a user's existing codes and stores new ones
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+
+	// ConsumeRecoveryCode atomically marks an unused code as used. It returns
+	// ErrRecoveryCodeNotFound if the user has no such unused code.
+	ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) error
+
+	// CountUnusedRecoveryCodes returns how many codes a user has left
+	CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error)
+
+	// DeleteRecoveryCodes removes every code belonging to a user
+	DeleteRecoveryCodes(ctx context.Context, userID int) error
+}
+
+// WebAuthnCredential is a passkey registered to a user
+type WebAuthnCredential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"` // COSE_Key
+	Algorithm       int64      `json:"algorithm"`
+	SignCount       uint32     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	AttestationType string     `json:"attestation_type"`
+	Transports      []string   `json:"transports"`
+	Name            string     `json:"name"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnCredentialRepository defines the interface for passkey operations
+type WebAuthnCredentialRepository interface {
+	// CreateWebAuthnCredential stores a new credential. It returns
+	// ErrWebAuthnCredentialExists if the credential ID is already registered.
+	CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) (*WebAuthnCredential, error)
+
+	// GetWebAuthnCredential retrieves a credential by its authenticator-assigned ID
+	GetWebAuthnCredential(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error)
+
+	// ListUserWebAuthnCredentials retrieves every credential registered to a user
+	ListUserWebAuthnCredentials(ctx context.Context, userID int) ([]*WebAuthnCredential, error)
+
+	// UpdateWebAuthnCredentialUsage records a successful assertion
+	UpdateWebAuthnCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error
+
+	// DeleteWebAuthnCredential removes one of a user's credentials
+	DeleteWebAuthnCredential(ctx context.Context, userID, id int) error
+}
+
+// OIDCIdentity links a user to an account at an OpenID Connect provider
+type OIDCIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// OIDCIdentityRepository defines the interface for linked provider accounts
+type OIDCIdentityRepository interface {
+	// CreateOIDCIdentity links a provider account to a user. It returns
+	// ErrOIDCIdentityExists if the provider account is already linked.
+	CreateOIDCIdentity(ctx context.Context, identity *OIDCIdentity) (*OIDCIdentity, error)
+
+	// GetOIDCIdentity retrieves the link for a provider's subject
+	GetOIDCIdentity(ctx context.Context, provider, subject string) (*OIDCIdentity, error)
+
+	// ListUserOIDCIdentities retrieves every provider account linked to a user
+	ListUserOIDCIdentities(ctx context.Context, userID int) ([]*OIDCIdentity, error)
+
+	// TouchOIDCIdentity records a login and the email the provider reported
+	TouchOIDCIdentity(ctx context.Context, id int, email string, loginAt time.Time) error
+}
+
+// OIDCLoginState remembers an authorization request between the redirect to
+// the provider and the callback
+type OIDCLoginState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// OIDCLoginStateRepository defines the interface for pending OIDC logins
+type OIDCLoginStateRepository interface {
+	// CreateOIDCLoginState stores a pending login
+	CreateOIDCLoginState(ctx context.Context, state *OIDCLoginState) error
+
+	// ConsumeOIDCLoginState atomically retrieves and deletes a pending login.
+	// It returns ErrOIDCLoginStateNotFound if there is none.
+	ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error)
+
+	// DeleteExpiredOIDCLoginStates removes logins that expired before the given time
+	DeleteExpiredOIDCLoginStates(ctx context.Context, before time.Time) (int, error)
+}
+
+// APIKey is a personal key for programmatic access. Only a hash of the
+// secret is stored; Prefix is kept so users can tell their keys apart.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// HasScope reports whether the key was granted a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyRepository defines the interface for API key operations
+type APIKeyRepository interface {
+	// CreateAPIKey stores a new key
+	CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, error)
+
+	// GetAPIKeyByHash retrieves a key by the hash of its secret
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+
+	// ListUserAPIKeys retrieves the keys of a user that have not been revoked, oldest first
+	ListUserAPIKeys(ctx context.Context, userID int) ([]*APIKey, error)
+
+	// TouchAPIKey records use of a key
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+
+	// RevokeAPIKey revokes one of a user's keys. It returns ErrAPIKeyNotFound
+	// if the user has no such active key.
+	RevokeAPIKey(ctx context.Context, userID, id int) error
+}
+
+// LoginAttempts counts recent failed logins for a throttling key, such as
+// an email address or a client IP
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Locked reports whether the key is locked at the given time
+func (a *LoginAttempts) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// LoginAttemptRepository defines the interface for failed login counters
+type LoginAttemptRepository interface {
+	// GetLoginAttempts retrieves the counter for a key. It returns
+	// ErrLoginAttemptsNotFound if the key has no recorded failures.
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+
+	// RecordLoginFailure atomically counts a failure against a key. A counter
+	// whose last failure was before resetBefore starts over and loses its lock.
+	RecordLoginFailure(ctx context.Context, key string, at, resetBefore time.Time) (*LoginAttempts, error)
+
+	// LockLogin locks a key until the given time
+	LockLogin(ctx context.Context, key string, until time.Time) error
+
+	// ClearLoginAttempts forgets the failures and any lock of a key
+	ClearLoginAttempts(ctx context.Context, key string) error
+
+	// DeleteStaleLoginAttempts removes counters whose last failure and lock
+	// both ended before the given time
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error)
+}
+
+// PasswordHistoryRepository keeps the hashes of passwords users have
+// replaced, so they can't switch back to them
+type PasswordHistoryRepository interface {
+	// AddPasswordHash records a replaced password hash and forgets all but
+	// the newest keep hashes of the user
+	AddPasswordHash(ctx context.Context, userID int, passwordHash string, keep int) error
+
+	// ListPasswordHashes retrieves up to limit of a user's replaced password
+	// hashes, newest first
+	ListPasswordHashes(ctx context.Context, userID int, limit int) ([]string, error)
+}
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AllPermissions is the permission that grants every other permission
+const AllPermissions = "*"
+
+// HasPermission reports whether the role grants a permission
+func (r *Role) HasPermission(permission string) bool {
+	for _, p := range r.Permissions {
+		if p == permission || p == AllPermissions {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleRepository defines the interface for roles and their assignment to users
+type RoleRepository interface {
+	// CreateRole stores a new role. It returns ErrRoleExists if the name is taken.
+	CreateRole(ctx context.Context, role *Role) (*Role, error)
+
+	// GetRoleByName retrieves a role by its name
+	GetRoleByName(ctx context.Context, name string) (*Role, error)
+
+	// ListRoles retrieves every role, ordered by name
+	ListRoles(ctx context.Context) ([]*Role, error)
+
+	// AssignRole gives a user a role. Assigning a role the user already has
+	// does nothing. It returns ErrRoleNotFound if there is no such role.
+	AssignRole(ctx context.Context, userID int, roleName string) error
+
+	// UnassignRole takes a role away from a user. It returns
+	// ErrRoleNotFound if the user doesn't have the role.
+	UnassignRole(ctx context.Context, userID int, roleName string) error
+
+	// ListUserRoles retrieves the roles assigned to a user, ordered by name
+	ListUserRoles(ctx context.Context, userID int) ([]*Role, error)
+
+	// CountRoleUsers returns how many users have a role
+	CountRoleUsers(ctx context.Context, roleName string) (int, error)
+}
+
+// Session represents a logged-in device. A session's ID doubles as the family
+// ID of the refresh tokens issued to it.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	AuthMethod string     `json:"auth_method"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// ImpersonatorID is the administrator acting as the user in this
+	// session, or 0 if the user signed in themselves
+	ImpersonatorID int `json:"impersonator_id,omitempty"`
+
+	// OrganizationID is the organization the user switched to in this
+	// session, or 0 if they haven't
+	OrganizationID int `json:"organization_id,omitempty"`
+}
+
+// Active reports whether the session is neither revoked nor expired
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionRepository defines the interface for session operations
+type SessionRepository interface {
+	// CreateSession stores a new session
+	CreateSession(ctx context.Context, session *Session) (*Session, error)
+
+	// GetSession retrieves a session by its ID
+	GetSession(ctx context.Context, id string) (*Session, error)
+
+	// ListUserSessions retrieves the sessions of a user that have not been revoked
+	ListUserSessions(ctx context.Context, userID int) ([]*Session, error)
+
+	// TouchSession records activity on a session
+	TouchSession(ctx context.Context, id string, seenAt time.Time) error
+
+	// ExtendSession moves the expiry of a session
+	ExtendSession(ctx context.Context, id string, expiresAt time.Time) error
+
+	// SetSessionOrganization records the organization a session works in.
+	// 0 clears it.
+	SetSessionOrganization(ctx context.Context, id string, organizationID int) error
+
+	// RevokeSession revokes a single session
+	RevokeSession(ctx context.Context, id string) error
+
+	// RevokeUserSessions revokes every session of a user except exceptID,
+	// which may be empty to revoke them all
+	RevokeUserSessions(ctx context.Context, userID int, exceptID string) error
+}
+
+// AuditEntry records something an administrator did
+type AuditEntry struct {
+	ID           int       `json:"id"`
+	ActorID      int       `json:"actor_id"`
+	Action       string    `json:"action"`
+	TargetUserID int       `json:"target_user_id,omitempty"` // Zero if the action had no target user
+	Details      string    `json:"details,omitempty"`
+	IPAddress    string    `json:"ip_address"`
+	UserAgent    string    `json:"user_agent"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows a listing of the audit trail. Zero fields match
+// every entry.
+type AuditFilter struct {
+	ActorID      int
+	TargetUserID int
+	Action       string
+	Limit        int
+	Offset       int
+}
+
+// AuditLogRepository defines the interface for the audit trail. Entries are
+// never changed and outlive the accounts they mention.
+type AuditLogRepository interface {
+	// CreateAuditEntry records an entry
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error)
+
+	// ListAuditEntries retrieves the entries matching a filter, newest first
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+}
+
+// Organization is a workspace shared by a team of users
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Roles a member can have in an organization, from most to least powerful
+const (
+	OrgRoleOwner  = "owner"  // Everything, including deleting the organization and transferring it
+	OrgRoleAdmin  = "admin"  // Manage the organization and its members
+	OrgRoleMember = "member" // Use the organization
+)
+
+// orgRoleRanks orders the organization roles
+var orgRoleRanks = map[string]int{OrgRoleMember: 1, OrgRoleAdmin: 2, OrgRoleOwner: 3}
+
+// ValidOrgRole reports whether role is an organization role
+func ValidOrgRole(role string) bool {
+	return orgRoleRanks[role] > 0
+}
+
+// OrgRoleAtLeast reports whether role is at least as powerful as min
+func OrgRoleAtLeast(role, min string) bool {
+	return ValidOrgRole(role) && orgRoleRanks[role] >= orgRoleRanks[min]
+}
+
+// Membership is a user's place in an organization
+type Membership struct {
+	OrganizationID int       `json:"organization_id"`
+	UserID         int       `json:"user_id"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrganizationMember is a membership with the member's name and address
+type OrganizationMember struct {
+	Membership
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserOrganization is an organization with the role a user has in it
+type UserOrganization struct {
+	Organization
+	Role string `json:"role"`
+}
+
+// OrganizationRepository defines the interface for organizations and their
+// members
+type OrganizationRepository interface {
+	// CreateOrganization stores a new organization with ownerID as its
+	// first owner
+	CreateOrganization(ctx context.Context, org *Organization, ownerID int) (*Organization, error)
+
+	// GetOrganization retrieves an organization by its ID
+	GetOrganization(ctx context.Context, id int) (*Organization, error)
+
+	// UpdateOrganization updates an organization's name
+	UpdateOrganization(ctx context.Context, org *Organization) (*Organization, error)
+
+	// DeleteOrganization deletes an organization and its memberships
+	DeleteOrganization(ctx context.Context, id int) error
+
+	// ListUserOrganizations retrieves the organizations a user belongs to,
+	// ordered by name
+	ListUserOrganizations(ctx context.Context, userID int) ([]*UserOrganization, error)
+
+	// AddMember adds a user to an organization. It returns
+	// ErrMembershipExists if they already belong to it.
+	AddMember(ctx context.Context, organizationID, userID int, role string) (*Membership, error)
+
+	// GetMembership retrieves a user's membership of an organization
+	GetMembership(ctx context.Context, organizationID, userID int) (*Membership, error)
+
+	// ListMembers retrieves the members of an organization, longest
+	// standing first
+	ListMembers(ctx context.Context, organizationID int) ([]*OrganizationMember, error)
+
+	// UpdateMemberRole changes a member's role
+	UpdateMemberRole(ctx context.Context, organizationID, userID int, role string) error
+
+	// RemoveMember takes a user out of an organization
+	RemoveMember(ctx context.Context, organizationID, userID int) error
+
+	// CountMembers returns how many members of an organization have a
+	// role, or how many members it has if role is empty
+	CountMembers(ctx context.Context, organizationID int, role string) (int, error)
+
+	// TransferOwnership makes toUserID an owner and fromUserID an admin in
+	// one step, so the organization is never without an owner
+	TransferOwnership(ctx context.Context, organizationID, fromUserID, toUserID int) error
+}
+
+// Invitation states
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+)
+
+// Invitation asks someone to join an organization by email. Only the hash
+// of the emailed token is stored.
+type Invitation struct {
+	ID             int        `json:"id"`
+	OrganizationID int        `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	TokenHash      string     `json:"-"`
+	Status         string     `json:"status"`
+	InvitedBy      int        `json:"invited_by"`
+	AcceptedBy     int        `json:"accepted_by,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RespondedAt    *time.Time `json:"responded_at,omitempty"`
+}
+
+// Pending reports whether the invitation can still be accepted at now
+func (i *Invitation) Pending(now time.Time) bool {
+	return i.Status == InvitationPending && now.Before(i.ExpiresAt)
+}
+
+// InvitationRepository defines the interface for organization invitations
+type InvitationRepository interface {
+	// CreateInvitation stores a new pending invitation. Any invitation still
+	// pending for the same address and organization is revoked, so only the
+	// newest link works.
+	CreateInvitation(ctx context.Context, invitation *Invitation) (*Invitation, error)
+
+	// GetInvitation retrieves an invitation by its ID
+	GetInvitation(ctx context.Context, id int) (*Invitation, error)
+
+	// GetInvitationByTokenHash retrieves an invitation by the hash of its token
+	GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error)
+
+	// ListOrganizationInvitations retrieves an organization's invitations,
+	// newest first
+	ListOrganizationInvitations(ctx context.Context, organizationID int) ([]*Invitation, error)
+
+	// AcceptInvitation marks a pending invitation accepted by userID and adds
+	// them to its organization with its role in one step. It returns
+	// ErrInvitationNotPending if the invitation was already accepted or
+	// revoked, or has expired. A user who already belongs to the
+	// organization keeps their role.
+	AcceptInvitation(ctx context.Context, id, userID int) (*Membership, error)
+
+	// RevokeInvitation revokes a pending invitation. It returns
+	// ErrInvitationNotPending if the invitation was already accepted or
+	// revoked.
+	RevokeInvitation(ctx context.Context, id int) error
+}
+
+// KnownDevice is a device a user has signed in from before. It is
+// recognized by the long-lived cookie it was given, or failing that by
+// its fingerprint.
+type KnownDevice struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	TokenHash   string    `json:"-"` // Hash of the device cookie
+	Fingerprint string    `json:"-"` // Hash of the user agent and IP prefix
+	UserAgent   string    `json:"user_agent"`
+	IPAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// KnownDeviceRepository defines the interface for the devices users sign
+// in from
+type KnownDeviceRepository interface {
+	// CreateKnownDevice stores a device a user signed in from
+	CreateKnownDevice(ctx context.Context, device *KnownDevice) (*KnownDevice, error)
+
+	// FindKnownDevice retrieves the user's device with the cookie hash, or
+	// failing that the one with the fingerprint. It returns
+	// ErrKnownDeviceNotFound if there is neither.
+	FindKnownDevice(ctx context.Context, userID int, tokenHash, fingerprint string) (*KnownDevice, error)
+
+	// CountKnownDevices counts the devices a user has signed in from
+	CountKnownDevices(ctx context.Context, userID int) (int, error)
+
+	// UpdateKnownDevice records a new sign-in from a device, storing its
+	// cookie hash, fingerprint, user agent, IP address and last seen time
+	UpdateKnownDevice(ctx context.Context, device *KnownDevice) error
+}
+
+// OAuthClient is a third-party application registered to act for users
+// through the OAuth 2.0 authorization server. Public clients, such as
+// mobile and single-page apps, can't keep a secret and have none.
+type OAuthClient struct {
+	ID           int       `json:"id"`
+	ClientID     string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`   // The most the client can be granted
+	OwnerID      int       `json:"owner_id"` // The user who registered the client
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirectURI reports whether uri is one of the client's redirect
+// URIs. They are compared exactly.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthClientRepository defines the interface for OAuth client operations
+type OAuthClientRepository interface {
+	// CreateOAuthClient stores a new client
+	CreateOAuthClient(ctx context.Context, client *OAuthClient) (*OAuthClient, error)
+
+	// GetOAuthClient retrieves a client by its client ID
+	GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
+
+	// ListUserOAuthClients retrieves the clients a user registered, oldest first
+	ListUserOAuthClients(ctx context.Context, ownerID int) ([]*OAuthClient, error)
+
+	// DeleteOAuthClient deletes one of a user's clients together with its
+	// authorization codes and tokens. It returns ErrOAuthClientNotFound if
+	// the user has no such client.
+	DeleteOAuthClient(ctx context.Context, ownerID int, clientID string) error
+}
+
+// OAuthAuthorizationCode is issued when a user approves a client's
+// authorization request. The client exchanges it for tokens once, proving
+// with the PKCE verifier that it made the request.
+type OAuthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string // S256 challenge
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+// OAuthAuthorizationCodeRepository defines the interface for pending
+// authorization codes
+type OAuthAuthorizationCodeRepository interface {
+	// CreateOAuthAuthorizationCode stores a new code
+	CreateOAuthAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error
+
+	// ConsumeOAuthAuthorizationCode atomically retrieves and deletes a code.
+	// It returns ErrOAuthAuthorizationCodeNotFound if there is none.
+	ConsumeOAuthAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error)
+
+	// DeleteExpiredOAuthAuthorizationCodes removes codes that expired before the given time
+	DeleteExpiredOAuthAuthorizationCodes(ctx context.Context, before time.Time) (int, error)
+}
+
+// OAuthToken is an access token issued to a client, with the refresh token
+// that replaces it if the grant has one. A client credentials token acts
+// for the user who registered the client.
+type OAuthToken struct {
+	ID               int        `json:"id"`
+	ClientID         string     `json:"client_id"`
+	UserID           int        `json:"user_id"`
+	GrantType        string     `json:"grant_type"`
+	Scopes           []string   `json:"scopes"`
+	AccessTokenHash  string     `json:"-"`
+	RefreshTokenHash string     `json:"-"` // Empty if the grant has no refresh token
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the access token is neither revoked nor expired
+func (t *OAuthToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// RefreshActive reports whether the refresh token can still be used
+func (t *OAuthToken) RefreshActive(now time.Time) bool {
+	return t.RevokedAt == nil && t.RefreshExpiresAt != nil && now.Before(*t.RefreshExpiresAt)
+}
+
+// HasScope reports whether the token was granted a scope
+func (t *OAuthToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthTokenRepository defines the interface for OAuth token operations
+type OAuthTokenRepository interface {
+	// CreateOAuthToken stores a new token
+	CreateOAuthToken(ctx context.Context, token *OAuthToken) (*OAuthToken, error)
+
+	// GetOAuthTokenByAccessHash retrieves a token by the hash of its access token
+	GetOAuthTokenByAccessHash(ctx context.Context, accessTokenHash string) (*OAuthToken, error)
+
+	// GetOAuthTokenByRefreshHash retrieves a token by the hash of its refresh token
+	GetOAuthTokenByRefreshHash(ctx context.Context, refreshTokenHash string) (*OAuthToken, error)
+
+	// RevokeOAuthToken revokes a token. It returns ErrOAuthTokenNotFound if
+	// there is no such token or it was already revoked, so only one of two
+	// concurrent refreshes succeeds.
+	RevokeOAuthToken(ctx context.Context, id int) error
+
+	// RevokeUserOAuthTokens revokes every token issued for a user
+	RevokeUserOAuthTokens(ctx context.Context, userID int) error
+}
+
+// SAMLConnection connects an organization to its SAML identity provider.
+// Users whose email is in one of its verified Domains sign in to the
+// organization through it.
+type SAMLConnection struct {
+	OrganizationID int          `json:"organization_id"`
+	IdPEntityID    string       `json:"idp_entity_id"`
+	IdPSSOURL      string       `json:"idp_sso_url"`
+	IdPCertificate string       `json:"idp_certificate"` // PEM
+	Domains        []SAMLDomain `json:"domains"`
+
+	// EmailAttribute and NameAttribute name the assertion attributes read
+	// into the user's email and name. The email falls back to the NameID.
+	EmailAttribute string `json:"email_attribute"`
+	NameAttribute  string `json:"name_attribute"`
+
+	// AllowIdPInitiated accepts responses the identity provider sends
+	// without a request from us
+	AllowIdPInitiated bool `json:"allow_idp_initiated"`
+
+	// JITProvisioning creates accounts, and memberships, for users signing
+	// in for the first time
+	JITProvisioning bool `json:"jit_provisioning"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SAMLDomain is an email domain claimed by a SAML connection. Any number
+// of organizations can claim a domain, but only one can verify it, by
+// publishing VerificationToken in DNS, and only verified domains are used.
+type SAMLDomain struct {
+	Domain            string     `json:"domain"`
+	VerificationToken string     `json:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at"` // Nil until verified
+}
+
+// Verified reports whether the organization proved it owns the domain
+func (d SAMLDomain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// SAMLConnectionRepository defines the interface for SAML connections
+type SAMLConnectionRepository interface {
+	// SaveSAMLConnection creates or replaces an organization's connection.
+	// It returns ErrSAMLDomainTaken if another organization verified one of
+	// the domains.
+	SaveSAMLConnection(ctx context.Context, conn *SAMLConnection) (*SAMLConnection, error)
+
+	// VerifySAMLDomain marks a domain of an organization's connection as
+	// verified. It returns ErrSAMLDomainNotFound if the connection doesn't
+	// claim the domain, and ErrSAMLDomainTaken if another organization
+	// verified it first.
+	VerifySAMLDomain(ctx context.Context, organizationID int, domain string) error
+
+	// GetSAMLConnection retrieves an organization's connection. It returns
+	// ErrSAMLConnectionNotFound if there is none.
+	GetSAMLConnection(ctx context.Context, organizationID int) (*SAMLConnection, error)
+
+	// DeleteSAMLConnection deletes an organization's connection. It returns
+	// ErrSAMLConnectionNotFound if there is none.
+	DeleteSAMLConnection(ctx context.Context, organizationID int) error
+}
+
+// SAMLRequest remembers an authentication request we sent to an identity
+// provider until its response comes back
+type SAMLRequest struct {
+	ID             string
+	OrganizationID int
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// SAMLLoginCode hands a SAML login from the assertion consumer service to
+// the frontend, which exchanges it once for a session
+type SAMLLoginCode struct {
+	CodeHash       string
+	UserID         int
+	OrganizationID int
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
+
+// SAMLLoginRepository defines the interface for SAML logins in progress
+type SAMLLoginRepository interface {
+	// CreateSAMLRequest stores a pending authentication request
+	CreateSAMLRequest(ctx context.Context, request *SAMLRequest) error
+
+	// ConsumeSAMLRequest atomically retrieves and deletes a pending
+	// request. It returns ErrSAMLRequestNotFound if there is none.
+	ConsumeSAMLRequest(ctx context.Context, id string) (*SAMLRequest, error)
+
+	// RecordSAMLAssertion remembers an assertion until it expires. It
+	// returns ErrSAMLAssertionReplayed if the issuer's assertion was
+	// already recorded.
+	RecordSAMLAssertion(ctx context.Context, issuer, assertionID string, expiresAt time.Time) error
+
+	// CreateSAMLLoginCode stores a new login code
+	CreateSAMLLoginCode(ctx context.Context, code *SAMLLoginCode) error
+
+	// ConsumeSAMLLoginCode atomically retrieves and deletes a login code.
+	// It returns ErrSAMLLoginCodeNotFound if there is none.
+	ConsumeSAMLLoginCode(ctx context.Context, codeHash string) (*SAMLLoginCode, error)
+
+	// DeleteExpiredSAMLLogins removes requests, assertions and codes that
+	// expired before the given time
+	DeleteExpiredSAMLLogins(ctx context.Context, before time.Time) (int, error)
+}
+
+// SCIMToken authenticates an organization's identity provider to the SCIM
+// provisioning endpoints. Only a hash of the token is stored; Prefix is
+// kept so owners can tell their tokens apart.
+type SCIMToken struct {
+	ID             int        `json:"id"`
+	OrganizationID int        `json:"organization_id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	TokenHash      string     `json:"-"`
+	CreatedBy      int        `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// SCIMTokenRepository defines the interface for SCIM token operations
+type SCIMTokenRepository interface {
+	// CreateSCIMToken stores a new token
+	CreateSCIMToken(ctx context.Context, token *SCIMToken) (*SCIMToken, error)
+
+	// GetSCIMTokenByHash retrieves a token by its hash
+	GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error)
+
+	// ListSCIMTokens retrieves an organization's tokens, oldest first
+	ListSCIMTokens(ctx context.Context, organizationID int) ([]*SCIMToken, error)
+
+	// TouchSCIMToken records use of a token
+	TouchSCIMToken(ctx context.Context, id int, usedAt time.Time) error
+
+	// DeleteSCIMToken deletes one of an organization's tokens. It returns
+	// ErrSCIMTokenNotFound if the organization has no such token.
+	DeleteSCIMToken(ctx context.Context, organizationID, id int) error
+}
+
+// SCIMUser marks a user as provisioned by an organization's identity
+// provider, which can then change and suspend their account. A user is
+// managed by one organization at most.
+type SCIMUser struct {
+	OrganizationID int    `json:"organization_id"`
+	UserID         int    `json:"user_id"`
+	ExternalID     string `json:"external_id"` // The identity provider's ID for the user, if it sent one
+
+	// DeactivatedAt is when the identity provider deactivated the user,
+	// nil while they are active. Deactivating suspends the account.
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SCIMUserRepository defines the interface for users managed through SCIM
+type SCIMUserRepository interface {
+	// CreateSCIMUser marks a user as managed by an organization. It returns
+	// ErrSCIMUserExists if an organization already manages the user, and
+	// ErrSCIMExternalIDTaken if another of the organization's users has
+	// the external ID.
+	CreateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error)
+
+	// GetSCIMUser retrieves a user managed by an organization. It returns
+	// ErrSCIMUserNotFound if the organization doesn't manage the user.
+	GetSCIMUser(ctx context.Context, organizationID, userID int) (*SCIMUser, error)
+
+	// ListSCIMUsers retrieves the users an organization manages, in the
+	// order they were provisioned
+	ListSCIMUsers(ctx context.Context, organizationID int) ([]*SCIMUser, error)
+
+	// UpdateSCIMUser changes a managed user's external ID and when they
+	// were deactivated. It returns ErrSCIMExternalIDTaken if another of the
+	// organization's users has the external ID.
+	UpdateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error)
+
+	// DeleteSCIMUser stops an organization managing a user. It returns
+	// ErrSCIMUserNotFound if the organization doesn't manage the user.
+	DeleteSCIMUser(ctx context.Context, organizationID, userID int) error
+}
+
+// SCIMGroup is a group of managed users an organization's identity
+// provider pushes
+type SCIMGroup struct {
+	ID             int       `json:"id"`
+	OrganizationID int       `json:"organization_id"`
+	DisplayName    string    `json:"display_name"`
+	ExternalID     string    `json:"external_id"`
+	MemberIDs      []int     `json:"member_ids"` // Ascending
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SCIMGroupRepository defines the interface for groups pushed through SCIM
+type SCIMGroupRepository interface {
+	// CreateSCIMGroup stores a new group with its members. It returns
+	// ErrSCIMGroupExists if the organization has a group with the same
+	// display name, ignoring case.
+	CreateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error)
+
+	// GetSCIMGroup retrieves one of an organization's groups. It returns
+	// ErrSCIMGroupNotFound if the organization has no such group.
+	GetSCIMGroup(ctx context.Context, organizationID, id int) (*SCIMGroup, error)
+
+	// ListSCIMGroups retrieves an organization's groups, oldest first
+	ListSCIMGroups(ctx context.Context, organizationID int) ([]*SCIMGroup, error)
+
+	// UpdateSCIMGroup replaces a group's display name, external ID and
+	// members. It returns ErrSCIMGroupNotFound if there is no such group
+	// and ErrSCIMGroupExists if the display name is taken.
+	UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error)
+
+	// DeleteSCIMGroup deletes one of an organization's groups. It returns
+	// ErrSCIMGroupNotFound if the organization has no such group.
+	DeleteSCIMGroup(ctx context.Context, organizationID, id int) error
+
+	// RemoveSCIMGroupMember takes a user out of every group of an organization
+	RemoveSCIMGroupMember(ctx context.Context, organizationID, userID int) error
+}
+
 // Database represents the main database interface that can provide repositories
 type Database interface {
 	// Users returns the user repository
 	Users() UserRepository
 
+	// RefreshTokens returns the refresh token repository
+	RefreshTokens() RefreshTokenRepository
+
+	// Sessions returns the session repository
+	Sessions() SessionRepository
+
+	// RecoveryCodes returns the two-factor recovery code repository
+	RecoveryCodes() RecoveryCodeRepository
+
+	// WebAuthnCredentials returns the passkey repository
+	WebAuthnCredentials() WebAuthnCredentialRepository
+
+	// OIDCIdentities returns the linked provider account repository
+	OIDCIdentities() OIDCIdentityRepository
+
+	// OIDCLoginStates returns the pending OIDC login repository
+	OIDCLoginStates() OIDCLoginStateRepository
+
+	// APIKeys returns the API key repository
+	APIKeys() APIKeyRepository
+
+	// LoginAttempts returns the failed login counter repository
+	LoginAttempts() LoginAttemptRepository
+
+	// PasswordHistory returns the replaced password repository
+	PasswordHistory() PasswordHistoryRepository
+
+	// Roles returns the role repository
+	Roles() RoleRepository
+
+	// AuditLog returns the audit trail repository
+	AuditLog() AuditLogRepository
+
+	// Organizations returns the organization repository
+	Organizations() OrganizationRepository
+
+	// Invitations returns the organization invitation repository
+	Invitations() InvitationRepository
+
+	// KnownDevices returns the known device repository
+	KnownDevices() KnownDeviceRepository
+
+	// OAuthClients returns the OAuth client repository
+	OAuthClients() OAuthClientRepository
+
+	// OAuthAuthorizationCodes returns the pending authorization code repository
+	OAuthAuthorizationCodes() OAuthAuthorizationCodeRepository
+
+	// OAuthTokens returns the OAuth token repository
+	OAuthTokens() OAuthTokenRepository
+
+	// SAMLConnections returns the SAML connection repository
+	SAMLConnections() SAMLConnectionRepository
+
+	// SAMLLogins returns the repository of SAML logins in progress
+	SAMLLogins() SAMLLoginRepository
+
+	// SCIMTokens returns the SCIM token repository
+	SCIMTokens() SCIMTokenRepository
+
+	// SCIMUsers returns the repository of users managed through SCIM
+	SCIMUsers() SCIMUserRepository
+
+	// SCIMGroups returns the SCIM group repository
+	SCIMGroups() SCIMGroupRepository
+
+	// PurgeUser deletes a user together with every row they own, such as
+	// their sessions, tokens, credentials and keys
+	PurgeUser(ctx context.Context, userID int) error
+
 	// Close closes all database connections
 	Close() error
 
@@ -90,4 +1131,50 @@ var (
 	ErrUserAlreadyExists = &DatabaseError{Type: "CONFLICT", Message: "user already exists"}
 	ErrInvalidInput      = &DatabaseError{Type: "INVALID_INPUT", Message: "invalid input provided"}
 	ErrDatabaseConnection = &DatabaseError{Type: "CONNECTION", Message: "database connection error"}
+
+	ErrRefreshTokenNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "refresh token not found"}
+	ErrRefreshTokenUsed     = &DatabaseError{Type: "CONFLICT", Message: "refresh token already used"}
+	ErrSessionNotFound      = &DatabaseError{Type: "NOT_FOUND", Message: "session not found"}
+	ErrRecoveryCodeNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "recovery code not found"}
+
+	ErrWebAuthnCredentialNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "webauthn credential not found"}
+	ErrWebAuthnCredentialExists   = &DatabaseError{Type: "CONFLICT", Message: "webauthn credential already registered"}
+
+	ErrOIDCIdentityNotFound   = &DatabaseError{Type: "NOT_FOUND", Message: "oidc identity not found"}
+	ErrOIDCIdentityExists     = &DatabaseError{Type: "CONFLICT", Message: "oidc identity already linked"}
+	ErrOIDCLoginStateNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "oidc login state not found"}
+
+	ErrAPIKeyNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "api key not found"}
+
+	ErrLoginAttemptsNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "login attempts not found"}
+
+	ErrRoleNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "role not found"}
+	ErrRoleExists   = &DatabaseError{Type: "CONFLICT", Message: "role already exists"}
+
+	ErrOrganizationNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "organization not found"}
+	ErrMembershipNotFound   = &DatabaseError{Type: "NOT_FOUND", Message: "membership not found"}
+	ErrMembershipExists     = &DatabaseError{Type: "CONFLICT", Message: "user is already a member"}
+
+	ErrInvitationNotFound   = &DatabaseError{Type: "NOT_FOUND", Message: "invitation not found"}
+	ErrInvitationNotPending = &DatabaseError{Type: "CONFLICT", Message: "invitation is no longer pending"}
+
+	ErrKnownDeviceNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "known device not found"}
+
+	ErrOAuthClientNotFound            = &DatabaseError{Type: "NOT_FOUND", Message: "oauth client not found"}
+	ErrOAuthAuthorizationCodeNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "oauth authorization code not found"}
+	ErrOAuthTokenNotFound             = &DatabaseError{Type: "NOT_FOUND", Message: "oauth token not found"}
+
+	ErrSAMLConnectionNotFound = &DatabaseError{Type: "NOT_FOUND", Message: "saml connection not found"}
+	ErrSAMLDomainTaken        = &DatabaseError{Type: "CONFLICT", Message: "domain was verified by another organization's saml connection"}
+	ErrSAMLDomainNotFound     = &DatabaseError{Type: "NOT_FOUND", Message: "saml domain not found"}
+	ErrSAMLRequestNotFound    = &DatabaseError{Type: "NOT_FOUND", Message: "saml request not found"}
+	ErrSAMLAssertionReplayed  = &DatabaseError{Type: "CONFLICT", Message: "saml assertion already used"}
+	ErrSAMLLoginCodeNotFound  = &DatabaseError{Type: "NOT_FOUND", Message: "saml login code not found"}
+
+	ErrSCIMTokenNotFound   = &DatabaseError{Type: "NOT_FOUND", Message: "scim token not found"}
+	ErrSCIMUserNotFound    = &DatabaseError{Type: "NOT_FOUND", Message: "scim user not found"}
+	ErrSCIMUserExists      = &DatabaseError{Type: "CONFLICT", Message: "user is already managed through scim"}
+	ErrSCIMExternalIDTaken = &DatabaseError{Type: "CONFLICT", Message: "scim external id already used"}
+	ErrSCIMGroupNotFound   = &DatabaseError{Type: "NOT_FOUND", Message: "scim group not found"}
+	ErrSCIMGroupExists     = &DatabaseError{Type: "CONFLICT", Message: "scim group already exists"}
 )
\ No newline at end of file
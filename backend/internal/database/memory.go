@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -9,7 +10,29 @@ import (
 
 // MemoryDatabase implements the Database interface using in-memory storage
 type MemoryDatabase struct {
-	userRepo *MemoryUserRepository
+	userRepo         *MemoryUserRepository
+	refreshTokenRepo *MemoryRefreshTokenRepository
+	sessionRepo      *MemorySessionRepository
+	recoveryCodeRepo *MemoryRecoveryCodeRepository
+	webAuthnRepo     *MemoryWebAuthnCredentialRepository
+	oidcIdentityRepo *MemoryOIDCIdentityRepository
+	oidcStateRepo    *MemoryOIDCLoginStateRepository
+	apiKeyRepo       *MemoryAPIKeyRepository
+	loginAttemptRepo *MemoryLoginAttemptRepository
+	passwordHistory  *MemoryPasswordHistoryRepository
+	roleRepo         *MemoryRoleRepository
+	auditLogRepo     *MemoryAuditLogRepository
+	organizationRepo *MemoryOrganizationRepository
+	invitationRepo   *MemoryInvitationRepository
+	knownDeviceRepo  *MemoryKnownDeviceRepository
+	oauthClientRepo  *MemoryOAuthClientRepository
+	oauthCodeRepo    *MemoryOAuthAuthorizationCodeRepository
+	oauthTokenRepo   *MemoryOAuthTokenRepository
+	samlConnRepo     *MemorySAMLConnectionRepository
+	samlLoginRepo    *MemorySAMLLoginRepository
+	scimTokenRepo    *MemorySCIMTokenRepository
+	scimUserRepo     *MemorySCIMUserRepository
+	scimGroupRepo    *MemorySCIMGroupRepository
 }
 
 // MemoryUserRepository implements UserRepository interface using in-memory storage
@@ -22,12 +45,38 @@ type MemoryUserRepository struct {
 
 // NewMemoryDatabase creates a new in-memory database instance
 func NewMemoryDatabase() *MemoryDatabase {
+	userRepo := &MemoryUserRepository{
+		users:        make(map[int]*User),
+		usersByEmail: make(map[string]*User),
+		nextID:       1,
+	}
+	organizationRepo := NewMemoryOrganizationRepository(userRepo)
+	oauthCodeRepo := NewMemoryOAuthAuthorizationCodeRepository()
+	oauthTokenRepo := NewMemoryOAuthTokenRepository()
 	return &MemoryDatabase{
-		userRepo: &MemoryUserRepository{
-			users:        make(map[int]*User),
-			usersByEmail: make(map[string]*User),
-			nextID:       1,
-		},
+		userRepo:         userRepo,
+		refreshTokenRepo: NewMemoryRefreshTokenRepository(),
+		sessionRepo:      NewMemorySessionRepository(),
+		recoveryCodeRepo: NewMemoryRecoveryCodeRepository(),
+		webAuthnRepo:     NewMemoryWebAuthnCredentialRepository(),
+		oidcIdentityRepo: NewMemoryOIDCIdentityRepository(),
+		oidcStateRepo:    NewMemoryOIDCLoginStateRepository(),
+		apiKeyRepo:       NewMemoryAPIKeyRepository(),
+		loginAttemptRepo: NewMemoryLoginAttemptRepository(),
+		passwordHistory:  NewMemoryPasswordHistoryRepository(),
+		roleRepo:         NewMemoryRoleRepository(),
+		auditLogRepo:     NewMemoryAuditLogRepository(),
+		organizationRepo: organizationRepo,
+		invitationRepo:   NewMemoryInvitationRepository(organizationRepo),
+		knownDeviceRepo:  NewMemoryKnownDeviceRepository(),
+		oauthClientRepo:  NewMemoryOAuthClientRepository(oauthCodeRepo, oauthTokenRepo),
+		oauthCodeRepo:    oauthCodeRepo,
+		oauthTokenRepo:   oauthTokenRepo,
+		samlConnRepo:     NewMemorySAMLConnectionRepository(organizationRepo),
+		samlLoginRepo:    NewMemorySAMLLoginRepository(),
+		scimTokenRepo:    NewMemorySCIMTokenRepository(),
+		scimUserRepo:     NewMemorySCIMUserRepository(),
+		scimGroupRepo:    NewMemorySCIMGroupRepository(),
 	}
 }
 
@@ -36,6 +85,142 @@ func (db *MemoryDatabase) Users() UserRepository {
 	return db.userRepo
 }
 
+// RefreshTokens returns the refresh token repository
+func (db *MemoryDatabase) RefreshTokens() RefreshTokenRepository {
+	return db.refreshTokenRepo
+}
+
+// Sessions returns the session repository
+func (db *MemoryDatabase) Sessions() SessionRepository {
+	return db.sessionRepo
+}
+
+// RecoveryCodes returns the two-factor recovery code repository
+func (db *MemoryDatabase) RecoveryCodes() RecoveryCodeRepository {
+	return db.recoveryCodeRepo
+}
+
+// WebAuthnCredentials returns the passkey repository
+func (db *MemoryDatabase) WebAuthnCredentials() WebAuthnCredentialRepository {
+	return db.webAuthnRepo
+}
+
+// OIDCIdentities returns the linked provider account repository
+func (db *MemoryDatabase) OIDCIdentities() OIDCIdentityRepository {
+	return db.oidcIdentityRepo
+}
+
+// OIDCLoginStates returns the pending OIDC login repository
+func (db *MemoryDatabase) OIDCLoginStates() OIDCLoginStateRepository {
+	return db.oidcStateRepo
+}
+
+// APIKeys returns the API key repository
+func (db *MemoryDatabase) APIKeys() APIKeyRepository {
+	return db.apiKeyRepo
+}
+
+// LoginAttempts returns the failed login counter repository
+func (db *MemoryDatabase) LoginAttempts() LoginAttemptRepository {
+	return db.loginAttemptRepo
+}
+
+// PasswordHistory returns the replaced password repository
+func (db *MemoryDatabase) PasswordHistory() PasswordHistoryRepository {
+	return db.passwordHistory
+}
+
+// Roles returns the role repository
+func (db *MemoryDatabase) Roles() RoleRepository {
+	return db.roleRepo
+}
+
+// AuditLog returns the audit trail repository
+func (db *MemoryDatabase) AuditLog() AuditLogRepository {
+	return db.auditLogRepo
+}
+
+// Organizations returns the organization repository
+func (db *MemoryDatabase) Organizations() OrganizationRepository {
+	return db.organizationRepo
+}
+
+// Invitations returns the organization invitation repository
+func (db *MemoryDatabase) Invitations() InvitationRepository {
+	return db.invitationRepo
+}
+
+// KnownDevices returns the known device repository
+func (db *MemoryDatabase) KnownDevices() KnownDeviceRepository {
+	return db.knownDeviceRepo
+}
+
+// OAuthClients returns the OAuth client repository
+func (db *MemoryDatabase) OAuthClients() OAuthClientRepository {
+	return db.oauthClientRepo
+}
+
+// OAuthAuthorizationCodes returns the pending authorization code repository
+func (db *MemoryDatabase) OAuthAuthorizationCodes() OAuthAuthorizationCodeRepository {
+	return db.oauthCodeRepo
+}
+
+// OAuthTokens returns the OAuth token repository
+func (db *MemoryDatabase) OAuthTokens() OAuthTokenRepository {
+	return db.oauthTokenRepo
+}
+
+// SAMLConnections returns the SAML connection repository
+func (db *MemoryDatabase) SAMLConnections() SAMLConnectionRepository {
+	return db.samlConnRepo
+}
+
+// SAMLLogins returns the repository of SAML logins in progress
+func (db *MemoryDatabase) SAMLLogins() SAMLLoginRepository {
+	return db.samlLoginRepo
+}
+
+// SCIMTokens returns the SCIM token repository
+func (db *MemoryDatabase) SCIMTokens() SCIMTokenRepository {
+	return db.scimTokenRepo
+}
+
+// SCIMUsers returns the repository of users managed through SCIM
+func (db *MemoryDatabase) SCIMUsers() SCIMUserRepository {
+	return db.scimUserRepo
+}
+
+// SCIMGroups returns the SCIM group repository
+func (db *MemoryDatabase) SCIMGroups() SCIMGroupRepository {
+	return db.scimGroupRepo
+}
+
+// PurgeUser deletes a user together with every row they own, as the
+// foreign keys in PostgreSQL do
+func (db *MemoryDatabase) PurgeUser(ctx context.Context, userID int) error {
+	if err := db.userRepo.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+
+	db.refreshTokenRepo.deleteUserTokens(userID)
+	db.sessionRepo.deleteUserSessions(userID)
+	db.recoveryCodeRepo.deleteUserCodes(userID)
+	db.webAuthnRepo.deleteUserCredentials(userID)
+	db.oidcIdentityRepo.deleteUserIdentities(userID)
+	db.apiKeyRepo.deleteUserKeys(userID)
+	db.passwordHistory.deleteUserHistory(userID)
+	db.roleRepo.deleteUserRoles(userID)
+	db.organizationRepo.deleteUserMemberships(userID)
+	db.knownDeviceRepo.deleteUserDevices(userID)
+	db.oauthClientRepo.deleteUserClients(userID)
+	db.oauthCodeRepo.deleteUserCodes(userID)
+	db.oauthTokenRepo.deleteUserTokens(userID)
+	db.samlLoginRepo.deleteUserCodes(userID)
+	db.scimUserRepo.deleteUser(userID)
+	db.scimGroupRepo.deleteUserMemberships(userID)
+	return nil
+}
+
 // Close closes the database (no-op for memory database)
 func (db *MemoryDatabase) Close() error {
 	return nil
@@ -68,14 +253,12 @@ func (r *MemoryUserRepository) CreateUser(ctx context.Context, user *User) (*Use
 
 	// Create new user
 	now := time.Now()
-	newUser := &User{
-		ID:        r.nextID,
-		Name:      strings.TrimSpace(user.Name),
-		Email:     email,
-		Password:  user.Password,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
+	newUser := r.copyUser(user)
+	newUser.ID = r.nextID
+	newUser.Name = strings.TrimSpace(user.Name)
+	newUser.Email = email
+	newUser.CreatedAt = now
+	newUser.UpdatedAt = now
 
 	// Store user
 	r.users[newUser.ID] = newUser
@@ -145,14 +328,11 @@ func (r *MemoryUserRepository) UpdateUser(ctx context.Context, user *User) (*Use
 	}
 
 	// Update user
-	updatedUser := &User{
-		ID:        user.ID,
-		Name:      strings.TrimSpace(user.Name),
-		Email:     newEmail,
-		Password:  user.Password,
-		CreatedAt: existingUser.CreatedAt,
-		UpdatedAt: time.Now(),
-	}
+	updatedUser := r.copyUser(user)
+	updatedUser.Name = strings.TrimSpace(user.Name)
+	updatedUser.Email = newEmail
+	updatedUser.CreatedAt = existingUser.CreatedAt
+	updatedUser.UpdatedAt = time.Now()
 
 	// Store updated user
 	r.users[user.ID] = updatedUser
@@ -203,6 +383,63 @@ func (r *MemoryUserRepository) ListUsers(ctx context.Context, limit, offset int)
 	return allUsers[offset:end], nil
 }
 
+// ListUsersDueForDeletion retrieves the users whose scheduled deletion is at or before the given time
+func (r *MemoryUserRepository) ListUsersDueForDeletion(ctx context.Context, before time.Time) ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []*User
+	for _, user := range r.users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(before) {
+			users = append(users, r.copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+// SearchUsers retrieves the users matching a filter, newest first
+func (r *MemoryUserRepository) SearchUsers(ctx context.Context, filter UserFilter) ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	query := strings.ToLower(strings.TrimSpace(filter.Query))
+	users := []*User{}
+	for _, user := range r.users {
+		if query != "" && !strings.Contains(user.Email, query) && !strings.Contains(strings.ToLower(user.Name), query) {
+			continue
+		}
+		if filter.CreatedAfter != nil && user.CreatedAt.Before(*filter.CreatedAfter) {
+			continue
+		}
+		if filter.CreatedBefore != nil && !user.CreatedAt.Before(*filter.CreatedBefore) {
+			continue
+		}
+		if filter.Verified != nil && user.EmailVerified() != *filter.Verified {
+			continue
+		}
+		if filter.Suspended != nil && user.Suspended() != *filter.Suspended {
+			continue
+		}
+		users = append(users, r.copyUser(user))
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.After(users[j].CreatedAt)
+		}
+		return users[i].ID > users[j].ID
+	})
+
+	if filter.Offset >= len(users) {
+		return []*User{}, nil
+	}
+	users = users[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(users) {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
 // Close closes any database connections (no-op for memory repository)
 func (r *MemoryUserRepository) Close() error {
 	r.mu.Lock()
@@ -222,14 +459,8 @@ func (r *MemoryUserRepository) copyUser(user *User) *User {
 		return nil
 	}
 
-	return &User{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		Password:  user.Password,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-	}
+	c := *user
+	return &c
 }
 
 // GetUserCount returns the total number of users (helper method for testing)
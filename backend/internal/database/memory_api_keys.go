@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryAPIKeyRepository implements APIKeyRepository using in-memory storage
+type MemoryAPIKeyRepository struct {
+	mu     sync.RWMutex
+	keys   map[int]*APIKey
+	nextID int
+}
+
+// NewMemoryAPIKeyRepository creates an empty in-memory API key repository
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{
+		keys:   make(map[int]*APIKey),
+		nextID: 1,
+	}
+}
+
+// CreateAPIKey stores a new key
+func (r *MemoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, error) {
+	if key == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "api key cannot be nil"}
+	}
+	if key.KeyHash == "" || key.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "key hash and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.keys {
+		if existing.KeyHash == key.KeyHash {
+			return nil, &DatabaseError{Type: "CONFLICT", Message: "api key already exists"}
+		}
+	}
+
+	stored := copyAPIKey(key)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	stored.LastUsedAt = nil
+	stored.RevokedAt = nil
+	r.keys[stored.ID] = stored
+	r.nextID++
+
+	return copyAPIKey(stored), nil
+}
+
+// GetAPIKeyByHash retrieves a key by the hash of its secret
+func (r *MemoryAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.KeyHash == keyHash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// ListUserAPIKeys retrieves the keys of a user that have not been revoked, oldest first
+func (r *MemoryAPIKeyRepository) ListUserAPIKeys(ctx context.Context, userID int) ([]*APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []*APIKey{}
+	for id := 1; id < r.nextID; id++ {
+		if key, ok := r.keys[id]; ok && key.UserID == userID && key.RevokedAt == nil {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+	return keys, nil
+}
+
+// TouchAPIKey records use of a key
+func (r *MemoryAPIKeyRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	key.LastUsedAt = &usedAt
+	return nil
+}
+
+// RevokeAPIKey revokes one of a user's keys
+func (r *MemoryAPIKeyRepository) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+// copyAPIKey copies a key, including its scopes and optional times
+func copyAPIKey(key *APIKey) *APIKey {
+	k := *key
+	k.Scopes = append([]string(nil), key.Scopes...)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		k.ExpiresAt = &expiresAt
+	}
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		k.LastUsedAt = &lastUsedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		k.RevokedAt = &revokedAt
+	}
+	return &k
+}
+
+// deleteUserKeys removes every API key of a user
+func (r *MemoryAPIKeyRepository) deleteUserKeys(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, key := range r.keys {
+		if key.UserID == userID {
+			delete(r.keys, id)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryAPIKeyRepository(t *testing.T) {
+	repo := NewMemoryAPIKeyRepository()
+	ctx := context.Background()
+
+	created, err := repo.CreateAPIKey(ctx, &APIKey{UserID: 1, Name: "ci", Prefix: "gsk_abcd", KeyHash: "hash-1", Scopes: []string{"metrics:read"}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+	repo.CreateAPIKey(ctx, &APIKey{UserID: 2, Name: "other", KeyHash: "hash-2"})
+
+	if _, err := repo.CreateAPIKey(ctx, &APIKey{UserID: 1, KeyHash: ""}); err == nil {
+		t.Error("Expected a key without a hash to be rejected")
+	}
+
+	found, err := repo.GetAPIKeyByHash(ctx, "hash-1")
+	if err != nil || found.ID != created.ID || !found.HasScope("metrics:read") || found.HasScope("profile:read") {
+		t.Fatalf("GetAPIKeyByHash() = %+v, %v", found, err)
+	}
+	if _, err := repo.GetAPIKeyByHash(ctx, "missing"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	if err := repo.TouchAPIKey(ctx, created.ID, time.Now()); err != nil {
+		t.Fatalf("TouchAPIKey() error = %v", err)
+	}
+	list, _ := repo.ListUserAPIKeys(ctx, 1)
+	if len(list) != 1 || list[0].LastUsedAt == nil {
+		t.Fatalf("Unexpected keys: %+v", list)
+	}
+
+	if err := repo.RevokeAPIKey(ctx, 2, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected another user's key to be out of reach, got %v", err)
+	}
+	if err := repo.RevokeAPIKey(ctx, 1, created.ID); err != nil {
+		t.Fatalf("RevokeAPIKey() error = %v", err)
+	}
+	if err := repo.RevokeAPIKey(ctx, 1, created.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected revoking twice to fail, got %v", err)
+	}
+
+	if list, _ := repo.ListUserAPIKeys(ctx, 1); len(list) != 0 {
+		t.Errorf("Expected revoked key to be hidden, got %+v", list)
+	}
+	if revoked, _ := repo.GetAPIKeyByHash(ctx, "hash-1"); revoked.Active(time.Now()) {
+		t.Error("Expected revoked key to be inactive")
+	}
+}
+
+func TestAPIKey_Active(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	if !(&APIKey{}).Active(now) {
+		t.Error("Expected a key without expiry to be active")
+	}
+	if !(&APIKey{ExpiresAt: &future}).Active(now) {
+		t.Error("Expected an unexpired key to be active")
+	}
+	if (&APIKey{ExpiresAt: &past}).Active(now) {
+		t.Error("Expected an expired key to be inactive")
+	}
+}
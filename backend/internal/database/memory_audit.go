@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryAuditLogRepository implements AuditLogRepository using in-memory storage
+type MemoryAuditLogRepository struct {
+	mu      sync.RWMutex
+	entries []*AuditEntry // Oldest first
+	nextID  int
+}
+
+// NewMemoryAuditLogRepository creates an empty in-memory audit trail
+func NewMemoryAuditLogRepository() *MemoryAuditLogRepository {
+	return &MemoryAuditLogRepository{nextID: 1}
+}
+
+// CreateAuditEntry records an entry
+func (r *MemoryAuditLogRepository) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error) {
+	if entry == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "audit entry cannot be nil"}
+	}
+	if entry.Action == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "audit action is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *entry
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	r.entries = append(r.entries, &stored)
+	r.nextID++
+
+	created := stored
+	return &created, nil
+}
+
+// ListAuditEntries retrieves the entries matching a filter, newest first
+func (r *MemoryAuditLogRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := []*AuditEntry{}
+	skipped := 0
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		entry := r.entries[i]
+		if filter.ActorID != 0 && entry.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.TargetUserID != 0 && entry.TargetUserID != filter.TargetUserID {
+			continue
+		}
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+
+		c := *entry
+		entries = append(entries, &c)
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryAuditLogRepository(t *testing.T) {
+	repo := NewMemoryAuditLogRepository()
+	ctx := context.Background()
+
+	if _, err := repo.CreateAuditEntry(ctx, &AuditEntry{ActorID: 1}); err == nil {
+		t.Error("Expected an entry without an action to be rejected")
+	}
+
+	first, err := repo.CreateAuditEntry(ctx, &AuditEntry{ActorID: 1, Action: "user.suspend", TargetUserID: 2, Details: "spam"})
+	if err != nil {
+		t.Fatalf("CreateAuditEntry() error = %v", err)
+	}
+	if first.ID == 0 || first.CreatedAt.IsZero() {
+		t.Errorf("Expected an ID and time to be set, got %+v", first)
+	}
+	repo.CreateAuditEntry(ctx, &AuditEntry{ActorID: 1, Action: "users.search"})
+	repo.CreateAuditEntry(ctx, &AuditEntry{ActorID: 3, Action: "user.unsuspend", TargetUserID: 2})
+
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		expected []string
+	}{
+		{"everything, newest first", AuditFilter{}, []string{"user.unsuspend", "users.search", "user.suspend"}},
+		{"by actor", AuditFilter{ActorID: 1}, []string{"users.search", "user.suspend"}},
+		{"by target", AuditFilter{TargetUserID: 2}, []string{"user.unsuspend", "user.suspend"}},
+		{"by action", AuditFilter{Action: "users.search"}, []string{"users.search"}},
+		{"page", AuditFilter{Limit: 1, Offset: 1}, []string{"users.search"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := repo.ListAuditEntries(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListAuditEntries() error = %v", err)
+			}
+			actions := []string{}
+			for _, entry := range entries {
+				actions = append(actions, entry.Action)
+			}
+			if len(actions) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, actions)
+			}
+			for i := range actions {
+				if actions[i] != tt.expected[i] {
+					t.Fatalf("Expected %v, got %v", tt.expected, actions)
+				}
+			}
+		})
+	}
+}
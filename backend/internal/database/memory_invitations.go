@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryInvitationRepository implements InvitationRepository using
+// in-memory storage
+type MemoryInvitationRepository struct {
+	mu          sync.Mutex
+	invitations map[int]*Invitation
+	nextID      int
+
+	// organizations receives the members invitations add
+	organizations *MemoryOrganizationRepository
+}
+
+// NewMemoryInvitationRepository creates an empty in-memory invitation
+// repository whose accepted invitations add members to organizations
+func NewMemoryInvitationRepository(organizations *MemoryOrganizationRepository) *MemoryInvitationRepository {
+	return &MemoryInvitationRepository{
+		invitations:   make(map[int]*Invitation),
+		nextID:        1,
+		organizations: organizations,
+	}
+}
+
+// CreateInvitation stores a new pending invitation and revokes any still
+// pending for the same address and organization
+func (r *MemoryInvitationRepository) CreateInvitation(ctx context.Context, invitation *Invitation) (*Invitation, error) {
+	if invitation == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "invitation cannot be nil"}
+	}
+	if invitation.TokenHash == "" || strings.TrimSpace(invitation.Email) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "invitation email and token hash are required"}
+	}
+	if !ValidOrgRole(invitation.Role) {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+	if _, err := r.organizations.GetOrganization(ctx, invitation.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, existing := range r.invitations {
+		if existing.OrganizationID == invitation.OrganizationID && existing.Status == InvitationPending &&
+			strings.EqualFold(existing.Email, invitation.Email) {
+			existing.Status = InvitationRevoked
+			existing.RespondedAt = &now
+		}
+	}
+
+	stored := *invitation
+	stored.ID = r.nextID
+	stored.Status = InvitationPending
+	stored.AcceptedBy = 0
+	stored.RespondedAt = nil
+	stored.CreatedAt = now
+	r.invitations[stored.ID] = &stored
+	r.nextID++
+
+	created := stored
+	return &created, nil
+}
+
+// GetInvitation retrieves an invitation by its ID
+func (r *MemoryInvitationRepository) GetInvitation(ctx context.Context, id int) (*Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invitation, exists := r.invitations[id]
+	if !exists {
+		return nil, ErrInvitationNotFound
+	}
+
+	found := *invitation
+	return &found, nil
+}
+
+// GetInvitationByTokenHash retrieves an invitation by the hash of its token
+func (r *MemoryInvitationRepository) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, invitation := range r.invitations {
+		if invitation.TokenHash == tokenHash {
+			found := *invitation
+			return &found, nil
+		}
+	}
+	return nil, ErrInvitationNotFound
+}
+
+// ListOrganizationInvitations retrieves an organization's invitations,
+// newest first
+func (r *MemoryInvitationRepository) ListOrganizationInvitations(ctx context.Context, organizationID int) ([]*Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invitations := []*Invitation{}
+	for _, invitation := range r.invitations {
+		if invitation.OrganizationID == organizationID {
+			found := *invitation
+			invitations = append(invitations, &found)
+		}
+	}
+
+	sort.Slice(invitations, func(i, j int) bool {
+		return invitations[i].ID > invitations[j].ID
+	})
+	return invitations, nil
+}
+
+// AcceptInvitation marks a pending invitation accepted by userID and adds
+// them to its organization
+func (r *MemoryInvitationRepository) AcceptInvitation(ctx context.Context, id, userID int) (*Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invitation, exists := r.invitations[id]
+	if !exists {
+		return nil, ErrInvitationNotFound
+	}
+	now := time.Now()
+	if !invitation.Pending(now) {
+		return nil, ErrInvitationNotPending
+	}
+
+	membership, err := r.organizations.AddMember(ctx, invitation.OrganizationID, userID, invitation.Role)
+	if errors.Is(err, ErrMembershipExists) {
+		membership, err = r.organizations.GetMembership(ctx, invitation.OrganizationID, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Status = InvitationAccepted
+	invitation.AcceptedBy = userID
+	invitation.RespondedAt = &now
+	return membership, nil
+}
+
+// RevokeInvitation revokes a pending invitation
+func (r *MemoryInvitationRepository) RevokeInvitation(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invitation, exists := r.invitations[id]
+	if !exists {
+		return ErrInvitationNotFound
+	}
+	if invitation.Status != InvitationPending {
+		return ErrInvitationNotPending
+	}
+
+	now := time.Now()
+	invitation.Status = InvitationRevoked
+	invitation.RespondedAt = &now
+	return nil
+}
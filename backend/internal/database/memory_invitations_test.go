@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryInvitationRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	repo := db.Invitations()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+	acme, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	expires := time.Now().Add(time.Hour)
+
+	if _, err := repo.CreateInvitation(ctx, &Invitation{OrganizationID: acme.ID, Email: "bob@example.com", Role: "boss", TokenHash: "a", ExpiresAt: expires}); err == nil {
+		t.Error("Expected an unknown role to be rejected")
+	}
+	if _, err := repo.CreateInvitation(ctx, &Invitation{OrganizationID: 999, Email: "bob@example.com", Role: OrgRoleMember, TokenHash: "a", ExpiresAt: expires}); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+
+	first, err := repo.CreateInvitation(ctx, &Invitation{OrganizationID: acme.ID, Email: "bob@example.com", Role: OrgRoleMember, TokenHash: "first", InvitedBy: alice.ID, ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateInvitation() error = %v", err)
+	}
+	if first.Status != InvitationPending || !first.Pending(time.Now()) || first.Pending(expires) {
+		t.Errorf("Expected a pending invitation until it expires, got %+v", first)
+	}
+
+	// A new invitation to the same address replaces the old one
+	second, _ := repo.CreateInvitation(ctx, &Invitation{OrganizationID: acme.ID, Email: "Bob@Example.com", Role: OrgRoleAdmin, TokenHash: "second", InvitedBy: alice.ID, ExpiresAt: expires})
+	if replaced, _ := repo.GetInvitation(ctx, first.ID); replaced.Status != InvitationRevoked || replaced.RespondedAt == nil {
+		t.Errorf("Expected the first invitation to be revoked, got %+v", replaced)
+	}
+	if _, err := repo.AcceptInvitation(ctx, first.ID, bob.ID); !errors.Is(err, ErrInvitationNotPending) {
+		t.Errorf("Expected ErrInvitationNotPending, got %v", err)
+	}
+
+	found, err := repo.GetInvitationByTokenHash(ctx, "second")
+	if err != nil || found.ID != second.ID {
+		t.Fatalf("Expected the second invitation by its hash, got %+v, %v", found, err)
+	}
+	if _, err := repo.GetInvitationByTokenHash(ctx, "missing"); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("Expected ErrInvitationNotFound, got %v", err)
+	}
+
+	membership, err := repo.AcceptInvitation(ctx, second.ID, bob.ID)
+	if err != nil || membership.Role != OrgRoleAdmin || membership.UserID != bob.ID {
+		t.Fatalf("Expected Bob to join as an admin, got %+v, %v", membership, err)
+	}
+	if _, err := repo.AcceptInvitation(ctx, second.ID, bob.ID); !errors.Is(err, ErrInvitationNotPending) {
+		t.Errorf("Expected an invitation to be accepted once, got %v", err)
+	}
+	accepted, _ := repo.GetInvitation(ctx, second.ID)
+	if accepted.Status != InvitationAccepted || accepted.AcceptedBy != bob.ID {
+		t.Errorf("Expected the invitation to record Bob, got %+v", accepted)
+	}
+
+	// Members keep their role when accepting another invitation
+	third, _ := repo.CreateInvitation(ctx, &Invitation{OrganizationID: acme.ID, Email: "alice@example.com", Role: OrgRoleMember, TokenHash: "third", ExpiresAt: expires})
+	if membership, err := repo.AcceptInvitation(ctx, third.ID, alice.ID); err != nil || membership.Role != OrgRoleOwner {
+		t.Errorf("Expected Alice to stay owner, got %+v, %v", membership, err)
+	}
+
+	expired, _ := repo.CreateInvitation(ctx, &Invitation{OrganizationID: acme.ID, Email: "carol@example.com", Role: OrgRoleMember, TokenHash: "expired", ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, err := repo.AcceptInvitation(ctx, expired.ID, bob.ID); !errors.Is(err, ErrInvitationNotPending) {
+		t.Errorf("Expected an expired invitation to be refused, got %v", err)
+	}
+
+	if err := repo.RevokeInvitation(ctx, expired.ID); err != nil {
+		t.Errorf("RevokeInvitation() error = %v", err)
+	}
+	if err := repo.RevokeInvitation(ctx, second.ID); !errors.Is(err, ErrInvitationNotPending) {
+		t.Errorf("Expected an accepted invitation not to be revoked, got %v", err)
+	}
+	if err := repo.RevokeInvitation(ctx, 999); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("Expected ErrInvitationNotFound, got %v", err)
+	}
+
+	invitations, _ := repo.ListOrganizationInvitations(ctx, acme.ID)
+	if len(invitations) != 4 || invitations[0].ID != expired.ID || invitations[3].ID != first.ID {
+		t.Errorf("Expected Acme's four invitations newest first, got %+v", invitations)
+	}
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryKnownDeviceRepository implements KnownDeviceRepository using
+// in-memory storage
+type MemoryKnownDeviceRepository struct {
+	mu      sync.RWMutex
+	devices map[int]*KnownDevice
+	nextID  int
+}
+
+// NewMemoryKnownDeviceRepository creates an empty in-memory known device repository
+func NewMemoryKnownDeviceRepository() *MemoryKnownDeviceRepository {
+	return &MemoryKnownDeviceRepository{
+		devices: make(map[int]*KnownDevice),
+		nextID:  1,
+	}
+}
+
+// CreateKnownDevice stores a device a user signed in from
+func (r *MemoryKnownDeviceRepository) CreateKnownDevice(ctx context.Context, device *KnownDevice) (*KnownDevice, error) {
+	if device == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "device cannot be nil"}
+	}
+	if device.UserID <= 0 || device.TokenHash == "" || device.Fingerprint == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "device user, token hash and fingerprint are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored := *device
+	stored.ID = r.nextID
+	stored.CreatedAt = now
+	stored.LastSeenAt = now
+	r.devices[stored.ID] = &stored
+	r.nextID++
+
+	created := stored
+	return &created, nil
+}
+
+// FindKnownDevice retrieves the user's device with the cookie hash, or
+// failing that the most recently seen one with the fingerprint
+func (r *MemoryKnownDeviceRepository) FindKnownDevice(ctx context.Context, userID int, tokenHash, fingerprint string) (*KnownDevice, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var byFingerprint *KnownDevice
+	for _, device := range r.devices {
+		if device.UserID != userID {
+			continue
+		}
+		if tokenHash != "" && device.TokenHash == tokenHash {
+			found := *device
+			return &found, nil
+		}
+		if device.Fingerprint == fingerprint && (byFingerprint == nil || device.LastSeenAt.After(byFingerprint.LastSeenAt)) {
+			byFingerprint = device
+		}
+	}
+
+	if byFingerprint == nil {
+		return nil, ErrKnownDeviceNotFound
+	}
+	found := *byFingerprint
+	return &found, nil
+}
+
+// CountKnownDevices counts the devices a user has signed in from
+func (r *MemoryKnownDeviceRepository) CountKnownDevices(ctx context.Context, userID int) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, device := range r.devices {
+		if device.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpdateKnownDevice records a new sign-in from a device
+func (r *MemoryKnownDeviceRepository) UpdateKnownDevice(ctx context.Context, device *KnownDevice) error {
+	if device == nil {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "device cannot be nil"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.devices[device.ID]
+	if !exists {
+		return ErrKnownDeviceNotFound
+	}
+
+	stored.TokenHash = device.TokenHash
+	stored.Fingerprint = device.Fingerprint
+	stored.UserAgent = device.UserAgent
+	stored.IPAddress = device.IPAddress
+	stored.LastSeenAt = device.LastSeenAt
+	return nil
+}
+
+// deleteUserDevices forgets every device of a user
+func (r *MemoryKnownDeviceRepository) deleteUserDevices(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, device := range r.devices {
+		if device.UserID == userID {
+			delete(r.devices, id)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryKnownDeviceRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	repo := db.KnownDevices()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+
+	if _, err := repo.CreateKnownDevice(ctx, &KnownDevice{UserID: alice.ID, Fingerprint: "f"}); err == nil {
+		t.Error("Expected a device without a token hash to be rejected")
+	}
+
+	laptop, err := repo.CreateKnownDevice(ctx, &KnownDevice{UserID: alice.ID, TokenHash: "laptop", Fingerprint: "firefox", UserAgent: "Firefox"})
+	if err != nil {
+		t.Fatalf("CreateKnownDevice() error = %v", err)
+	}
+	phone, _ := repo.CreateKnownDevice(ctx, &KnownDevice{UserID: alice.ID, TokenHash: "phone", Fingerprint: "safari"})
+	repo.CreateKnownDevice(ctx, &KnownDevice{UserID: bob.ID, TokenHash: "bob", Fingerprint: "chrome"})
+
+	// The cookie wins over the fingerprint
+	if found, err := repo.FindKnownDevice(ctx, alice.ID, "laptop", "safari"); err != nil || found.ID != laptop.ID {
+		t.Errorf("Expected the laptop by its cookie, got %+v, %v", found, err)
+	}
+	if found, err := repo.FindKnownDevice(ctx, alice.ID, "", "safari"); err != nil || found.ID != phone.ID {
+		t.Errorf("Expected the phone by its fingerprint, got %+v, %v", found, err)
+	}
+	if _, err := repo.FindKnownDevice(ctx, alice.ID, "bob", "chrome"); !errors.Is(err, ErrKnownDeviceNotFound) {
+		t.Errorf("Expected another user's device not to match, got %v", err)
+	}
+
+	seen := time.Now().Add(time.Hour)
+	laptop.TokenHash = "laptop-2"
+	laptop.LastSeenAt = seen
+	if err := repo.UpdateKnownDevice(ctx, laptop); err != nil {
+		t.Fatalf("UpdateKnownDevice() error = %v", err)
+	}
+	if found, _ := repo.FindKnownDevice(ctx, alice.ID, "laptop-2", ""); found == nil || !found.LastSeenAt.Equal(seen) {
+		t.Errorf("Expected the laptop under its new cookie, got %+v", found)
+	}
+	if err := repo.UpdateKnownDevice(ctx, &KnownDevice{ID: 999}); !errors.Is(err, ErrKnownDeviceNotFound) {
+		t.Errorf("Expected ErrKnownDeviceNotFound, got %v", err)
+	}
+
+	if count, _ := repo.CountKnownDevices(ctx, alice.ID); count != 2 {
+		t.Errorf("Expected Alice to have 2 devices, got %d", count)
+	}
+
+	db.PurgeUser(ctx, alice.ID)
+	if count, _ := repo.CountKnownDevices(ctx, alice.ID); count != 0 {
+		t.Errorf("Expected purging Alice to forget her devices, got %d", count)
+	}
+}
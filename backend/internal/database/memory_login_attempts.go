@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLoginAttemptRepository implements LoginAttemptRepository using in-memory storage
+type MemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempts
+}
+
+// NewMemoryLoginAttemptRepository creates an empty in-memory login attempt repository
+func NewMemoryLoginAttemptRepository() *MemoryLoginAttemptRepository {
+	return &MemoryLoginAttemptRepository{
+		attempts: make(map[string]*LoginAttempts),
+	}
+}
+
+// GetLoginAttempts retrieves the counter for a key
+func (r *MemoryLoginAttemptRepository) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts, ok := r.attempts[key]
+	if !ok {
+		return nil, ErrLoginAttemptsNotFound
+	}
+	return copyLoginAttempts(attempts), nil
+}
+
+// RecordLoginFailure atomically counts a failure against a key
+func (r *MemoryLoginAttemptRepository) RecordLoginFailure(ctx context.Context, key string, at, resetBefore time.Time) (*LoginAttempts, error) {
+	if key == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "key is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts, ok := r.attempts[key]
+	if !ok || attempts.LastFailureAt.Before(resetBefore) {
+		attempts = &LoginAttempts{Key: key}
+		r.attempts[key] = attempts
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	return copyLoginAttempts(attempts), nil
+}
+
+// LockLogin locks a key until the given time
+func (r *MemoryLoginAttemptRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts, ok := r.attempts[key]
+	if !ok {
+		return ErrLoginAttemptsNotFound
+	}
+
+	attempts.LockedUntil = &until
+	return nil
+}
+
+// ClearLoginAttempts forgets the failures and any lock of a key
+func (r *MemoryLoginAttemptRepository) ClearLoginAttempts(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+// DeleteStaleLoginAttempts removes counters whose last failure and lock both ended before the given time
+func (r *MemoryLoginAttemptRepository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for key, attempts := range r.attempts {
+		if attempts.LastFailureAt.Before(before) && !attempts.Locked(before) {
+			delete(r.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// copyLoginAttempts copies a counter, including its lock time
+func copyLoginAttempts(attempts *LoginAttempts) *LoginAttempts {
+	a := *attempts
+	if attempts.LockedUntil != nil {
+		lockedUntil := *attempts.LockedUntil
+		a.LockedUntil = &lockedUntil
+	}
+	return &a
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginAttemptRepository(t *testing.T) {
+	repo := NewMemoryLoginAttemptRepository()
+	ctx := context.Background()
+	now := time.Now()
+	window := now.Add(-time.Hour)
+
+	if _, err := repo.GetLoginAttempts(ctx, "email:a@example.com"); !errors.Is(err, ErrLoginAttemptsNotFound) {
+		t.Errorf("Expected ErrLoginAttemptsNotFound, got %v", err)
+	}
+
+	repo.RecordLoginFailure(ctx, "email:a@example.com", now, window)
+	attempts, err := repo.RecordLoginFailure(ctx, "email:a@example.com", now, window)
+	if err != nil || attempts.Failures != 2 {
+		t.Fatalf("RecordLoginFailure() = %+v, %v", attempts, err)
+	}
+
+	if err := repo.LockLogin(ctx, "email:a@example.com", now.Add(time.Minute)); err != nil {
+		t.Fatalf("LockLogin() error = %v", err)
+	}
+	attempts, _ = repo.GetLoginAttempts(ctx, "email:a@example.com")
+	if !attempts.Locked(now) || attempts.Locked(now.Add(2*time.Minute)) {
+		t.Errorf("Unexpected lock %+v", attempts)
+	}
+
+	// A failure after the window starts the counter over and drops the lock
+	later := now.Add(2 * time.Hour)
+	attempts, _ = repo.RecordLoginFailure(ctx, "email:a@example.com", later, later.Add(-time.Hour))
+	if attempts.Failures != 1 || attempts.LockedUntil != nil {
+		t.Errorf("Expected counter to start over, got %+v", attempts)
+	}
+
+	if err := repo.ClearLoginAttempts(ctx, "email:a@example.com"); err != nil {
+		t.Fatalf("ClearLoginAttempts() error = %v", err)
+	}
+	if _, err := repo.GetLoginAttempts(ctx, "email:a@example.com"); !errors.Is(err, ErrLoginAttemptsNotFound) {
+		t.Errorf("Expected counter to be cleared, got %v", err)
+	}
+}
+
+func TestMemoryLoginAttemptRepository_DeleteStale(t *testing.T) {
+	repo := NewMemoryLoginAttemptRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	repo.RecordLoginFailure(ctx, "ip:1", now.Add(-2*time.Hour), now.Add(-3*time.Hour))
+	repo.RecordLoginFailure(ctx, "ip:2", now.Add(-2*time.Hour), now.Add(-3*time.Hour))
+	repo.LockLogin(ctx, "ip:2", now.Add(time.Hour))
+	repo.RecordLoginFailure(ctx, "ip:3", now, now.Add(-time.Hour))
+
+	if deleted, _ := repo.DeleteStaleLoginAttempts(ctx, now.Add(-time.Hour)); deleted != 1 {
+		t.Errorf("Expected only the stale, unlocked counter to be deleted, got %d", deleted)
+	}
+	if _, err := repo.GetLoginAttempts(ctx, "ip:2"); err != nil {
+		t.Errorf("Expected locked counter to be kept, got %v", err)
+	}
+}
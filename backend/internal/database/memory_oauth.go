@@ -0,0 +1,366 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryOAuthClientRepository implements OAuthClientRepository using
+// in-memory storage
+type MemoryOAuthClientRepository struct {
+	mu      sync.RWMutex
+	clients map[string]*OAuthClient
+	nextID  int
+
+	// codes and tokens lose what was issued to a deleted client
+	codes  *MemoryOAuthAuthorizationCodeRepository
+	tokens *MemoryOAuthTokenRepository
+}
+
+// NewMemoryOAuthClientRepository creates an empty in-memory client
+// repository whose deleted clients take their codes and tokens with them
+func NewMemoryOAuthClientRepository(codes *MemoryOAuthAuthorizationCodeRepository, tokens *MemoryOAuthTokenRepository) *MemoryOAuthClientRepository {
+	return &MemoryOAuthClientRepository{
+		clients: make(map[string]*OAuthClient),
+		nextID:  1,
+		codes:   codes,
+		tokens:  tokens,
+	}
+}
+
+// CreateOAuthClient stores a new client
+func (r *MemoryOAuthClientRepository) CreateOAuthClient(ctx context.Context, client *OAuthClient) (*OAuthClient, error) {
+	if client == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "oauth client cannot be nil"}
+	}
+	if client.ClientID == "" || client.OwnerID <= 0 || strings.TrimSpace(client.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "client id, name and owner are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.clients[client.ClientID]; exists {
+		return nil, &DatabaseError{Type: "CONFLICT", Message: "oauth client already exists"}
+	}
+
+	stored := copyOAuthClient(client)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	r.clients[stored.ClientID] = stored
+	r.nextID++
+
+	return copyOAuthClient(stored), nil
+}
+
+// GetOAuthClient retrieves a client by its client ID
+func (r *MemoryOAuthClientRepository) GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, ErrOAuthClientNotFound
+	}
+	return copyOAuthClient(client), nil
+}
+
+// ListUserOAuthClients retrieves the clients a user registered, oldest first
+func (r *MemoryOAuthClientRepository) ListUserOAuthClients(ctx context.Context, ownerID int) ([]*OAuthClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byID := make(map[int]*OAuthClient)
+	for _, client := range r.clients {
+		if client.OwnerID == ownerID {
+			byID[client.ID] = client
+		}
+	}
+
+	clients := []*OAuthClient{}
+	for id := 1; id < r.nextID; id++ {
+		if client, ok := byID[id]; ok {
+			clients = append(clients, copyOAuthClient(client))
+		}
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes one of a user's clients together with its
+// authorization codes and tokens
+func (r *MemoryOAuthClientRepository) DeleteOAuthClient(ctx context.Context, ownerID int, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	client, ok := r.clients[clientID]
+	if !ok || client.OwnerID != ownerID {
+		return ErrOAuthClientNotFound
+	}
+
+	delete(r.clients, clientID)
+	r.codes.deleteClientCodes(clientID)
+	r.tokens.deleteClientTokens(clientID)
+	return nil
+}
+
+// copyOAuthClient copies a client, including its redirect URIs and scopes
+func copyOAuthClient(client *OAuthClient) *OAuthClient {
+	c := *client
+	c.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	c.Scopes = append([]string(nil), client.Scopes...)
+	return &c
+}
+
+// deleteUserClients removes every client a user registered, with what was
+// issued to them
+func (r *MemoryOAuthClientRepository) deleteUserClients(ownerID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for clientID, client := range r.clients {
+		if client.OwnerID == ownerID {
+			delete(r.clients, clientID)
+			r.codes.deleteClientCodes(clientID)
+			r.tokens.deleteClientTokens(clientID)
+		}
+	}
+}
+
+// MemoryOAuthAuthorizationCodeRepository implements
+// OAuthAuthorizationCodeRepository using in-memory storage
+type MemoryOAuthAuthorizationCodeRepository struct {
+	mu    sync.Mutex
+	codes map[string]*OAuthAuthorizationCode
+}
+
+// NewMemoryOAuthAuthorizationCodeRepository creates an empty in-memory
+// authorization code repository
+func NewMemoryOAuthAuthorizationCodeRepository() *MemoryOAuthAuthorizationCodeRepository {
+	return &MemoryOAuthAuthorizationCodeRepository{
+		codes: make(map[string]*OAuthAuthorizationCode),
+	}
+}
+
+// CreateOAuthAuthorizationCode stores a new code
+func (r *MemoryOAuthAuthorizationCodeRepository) CreateOAuthAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error {
+	if code == nil || code.CodeHash == "" || code.ClientID == "" || code.UserID <= 0 {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "code hash, client and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.codes[code.CodeHash]; exists {
+		return &DatabaseError{Type: "CONFLICT", Message: "oauth authorization code already exists"}
+	}
+
+	stored := copyOAuthAuthorizationCode(code)
+	stored.CreatedAt = time.Now()
+	r.codes[code.CodeHash] = stored
+	return nil
+}
+
+// ConsumeOAuthAuthorizationCode atomically retrieves and deletes a code
+func (r *MemoryOAuthAuthorizationCodeRepository) ConsumeOAuthAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[codeHash]
+	if !ok {
+		return nil, ErrOAuthAuthorizationCodeNotFound
+	}
+
+	delete(r.codes, codeHash)
+	return copyOAuthAuthorizationCode(code), nil
+}
+
+// DeleteExpiredOAuthAuthorizationCodes removes codes that expired before the given time
+func (r *MemoryOAuthAuthorizationCodeRepository) DeleteExpiredOAuthAuthorizationCodes(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for hash, code := range r.codes {
+		if code.ExpiresAt.Before(before) {
+			delete(r.codes, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func copyOAuthAuthorizationCode(code *OAuthAuthorizationCode) *OAuthAuthorizationCode {
+	c := *code
+	c.Scopes = append([]string(nil), code.Scopes...)
+	return &c
+}
+
+// deleteClientCodes removes every code issued to a client
+func (r *MemoryOAuthAuthorizationCodeRepository) deleteClientCodes(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.ClientID == clientID {
+			delete(r.codes, hash)
+		}
+	}
+}
+
+// deleteUserCodes removes every code issued for a user
+func (r *MemoryOAuthAuthorizationCodeRepository) deleteUserCodes(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.UserID == userID {
+			delete(r.codes, hash)
+		}
+	}
+}
+
+// MemoryOAuthTokenRepository implements OAuthTokenRepository using
+// in-memory storage
+type MemoryOAuthTokenRepository struct {
+	mu     sync.RWMutex
+	tokens map[int]*OAuthToken
+	nextID int
+}
+
+// NewMemoryOAuthTokenRepository creates an empty in-memory OAuth token repository
+func NewMemoryOAuthTokenRepository() *MemoryOAuthTokenRepository {
+	return &MemoryOAuthTokenRepository{
+		tokens: make(map[int]*OAuthToken),
+		nextID: 1,
+	}
+}
+
+// CreateOAuthToken stores a new token
+func (r *MemoryOAuthTokenRepository) CreateOAuthToken(ctx context.Context, token *OAuthToken) (*OAuthToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "oauth token cannot be nil"}
+	}
+	if token.AccessTokenHash == "" || token.ClientID == "" || token.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "access token hash, client and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.tokens {
+		if existing.AccessTokenHash == token.AccessTokenHash ||
+			(token.RefreshTokenHash != "" && existing.RefreshTokenHash == token.RefreshTokenHash) {
+			return nil, &DatabaseError{Type: "CONFLICT", Message: "oauth token already exists"}
+		}
+	}
+
+	stored := copyOAuthToken(token)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	stored.RevokedAt = nil
+	r.tokens[stored.ID] = stored
+	r.nextID++
+
+	return copyOAuthToken(stored), nil
+}
+
+// GetOAuthTokenByAccessHash retrieves a token by the hash of its access token
+func (r *MemoryOAuthTokenRepository) GetOAuthTokenByAccessHash(ctx context.Context, accessTokenHash string) (*OAuthToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, token := range r.tokens {
+		if token.AccessTokenHash == accessTokenHash {
+			return copyOAuthToken(token), nil
+		}
+	}
+	return nil, ErrOAuthTokenNotFound
+}
+
+// GetOAuthTokenByRefreshHash retrieves a token by the hash of its refresh token
+func (r *MemoryOAuthTokenRepository) GetOAuthTokenByRefreshHash(ctx context.Context, refreshTokenHash string) (*OAuthToken, error) {
+	if refreshTokenHash == "" {
+		return nil, ErrOAuthTokenNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, token := range r.tokens {
+		if token.RefreshTokenHash == refreshTokenHash {
+			return copyOAuthToken(token), nil
+		}
+	}
+	return nil, ErrOAuthTokenNotFound
+}
+
+// RevokeOAuthToken revokes a token that hasn't been revoked yet
+func (r *MemoryOAuthTokenRepository) RevokeOAuthToken(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok || token.RevokedAt != nil {
+		return ErrOAuthTokenNotFound
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	return nil
+}
+
+// RevokeUserOAuthTokens revokes every token issued for a user
+func (r *MemoryOAuthTokenRepository) RevokeUserOAuthTokens(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			revokedAt := now
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+// copyOAuthToken copies a token, including its scopes and optional times
+func copyOAuthToken(token *OAuthToken) *OAuthToken {
+	t := *token
+	t.Scopes = append([]string(nil), token.Scopes...)
+	if token.RefreshExpiresAt != nil {
+		refreshExpiresAt := *token.RefreshExpiresAt
+		t.RefreshExpiresAt = &refreshExpiresAt
+	}
+	if token.RevokedAt != nil {
+		revokedAt := *token.RevokedAt
+		t.RevokedAt = &revokedAt
+	}
+	return &t
+}
+
+// deleteClientTokens removes every token issued to a client
+func (r *MemoryOAuthTokenRepository) deleteClientTokens(clientID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.ClientID == clientID {
+			delete(r.tokens, id)
+		}
+	}
+}
+
+// deleteUserTokens removes every token issued for a user
+func (r *MemoryOAuthTokenRepository) deleteUserTokens(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryOAuthClientRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+
+	if _, err := db.OAuthClients().CreateOAuthClient(ctx, &OAuthClient{ClientID: "no-owner", Name: "App"}); err == nil {
+		t.Error("Expected a client without an owner to be rejected")
+	}
+
+	client, err := db.OAuthClients().CreateOAuthClient(ctx, &OAuthClient{
+		ClientID:     "reports",
+		SecretHash:   "secret",
+		Name:         "Reports",
+		RedirectURIs: []string{"https://reports.example.com/callback"},
+		Scopes:       []string{"metrics:read"},
+		OwnerID:      alice.ID,
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthClient() error = %v", err)
+	}
+	if !client.Confidential() || !client.AllowsRedirectURI("https://reports.example.com/callback") || client.AllowsRedirectURI("https://reports.example.com/") {
+		t.Errorf("Unexpected client %+v", client)
+	}
+	if _, err := db.OAuthClients().CreateOAuthClient(ctx, &OAuthClient{ClientID: "reports", Name: "Copy", OwnerID: bob.ID}); err == nil {
+		t.Error("Expected a duplicate client ID to be rejected")
+	}
+	db.OAuthClients().CreateOAuthClient(ctx, &OAuthClient{ClientID: "mobile", Name: "Mobile", OwnerID: alice.ID})
+
+	clients, _ := db.OAuthClients().ListUserOAuthClients(ctx, alice.ID)
+	if len(clients) != 2 || clients[0].ClientID != "reports" || clients[1].Confidential() {
+		t.Errorf("Expected Alice's two clients oldest first, got %+v", clients)
+	}
+
+	db.OAuthAuthorizationCodes().CreateOAuthAuthorizationCode(ctx, &OAuthAuthorizationCode{
+		CodeHash: "code", ClientID: "reports", UserID: bob.ID, ExpiresAt: time.Now().Add(time.Minute),
+	})
+	issued, _ := db.OAuthTokens().CreateOAuthToken(ctx, &OAuthToken{
+		ClientID: "reports", UserID: bob.ID, AccessTokenHash: "access", ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	if err := db.OAuthClients().DeleteOAuthClient(ctx, bob.ID, "reports"); !errors.Is(err, ErrOAuthClientNotFound) {
+		t.Errorf("Expected Bob not to delete Alice's client, got %v", err)
+	}
+	if err := db.OAuthClients().DeleteOAuthClient(ctx, alice.ID, "reports"); err != nil {
+		t.Fatalf("DeleteOAuthClient() error = %v", err)
+	}
+	if _, err := db.OAuthClients().GetOAuthClient(ctx, "reports"); !errors.Is(err, ErrOAuthClientNotFound) {
+		t.Errorf("Expected the client to be gone, got %v", err)
+	}
+	if _, err := db.OAuthAuthorizationCodes().ConsumeOAuthAuthorizationCode(ctx, "code"); !errors.Is(err, ErrOAuthAuthorizationCodeNotFound) {
+		t.Errorf("Expected the client's codes to be gone, got %v", err)
+	}
+	if _, err := db.OAuthTokens().GetOAuthTokenByAccessHash(ctx, issued.AccessTokenHash); !errors.Is(err, ErrOAuthTokenNotFound) {
+		t.Errorf("Expected the client's tokens to be gone, got %v", err)
+	}
+
+	db.PurgeUser(ctx, alice.ID)
+	if clients, _ := db.OAuthClients().ListUserOAuthClients(ctx, alice.ID); len(clients) != 0 {
+		t.Errorf("Expected purging Alice to delete her clients, got %+v", clients)
+	}
+}
+
+func TestMemoryOAuthAuthorizationCodeRepository(t *testing.T) {
+	repo := NewMemoryOAuthAuthorizationCodeRepository()
+	ctx := context.Background()
+
+	if err := repo.CreateOAuthAuthorizationCode(ctx, &OAuthAuthorizationCode{ClientID: "app", UserID: 1}); err == nil {
+		t.Error("Expected a code without a hash to be rejected")
+	}
+
+	err := repo.CreateOAuthAuthorizationCode(ctx, &OAuthAuthorizationCode{
+		CodeHash: "live", ClientID: "app", UserID: 1, Scopes: []string{"profile:read"}, ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthAuthorizationCode() error = %v", err)
+	}
+	repo.CreateOAuthAuthorizationCode(ctx, &OAuthAuthorizationCode{
+		CodeHash: "stale", ClientID: "app", UserID: 1, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	if deleted, _ := repo.DeleteExpiredOAuthAuthorizationCodes(ctx, time.Now()); deleted != 1 {
+		t.Errorf("Expected one expired code to be deleted, got %d", deleted)
+	}
+
+	code, err := repo.ConsumeOAuthAuthorizationCode(ctx, "live")
+	if err != nil || code.UserID != 1 || len(code.Scopes) != 1 {
+		t.Fatalf("Expected the code, got %+v, %v", code, err)
+	}
+	if _, err := repo.ConsumeOAuthAuthorizationCode(ctx, "live"); !errors.Is(err, ErrOAuthAuthorizationCodeNotFound) {
+		t.Errorf("Expected a code to work once, got %v", err)
+	}
+}
+
+func TestMemoryOAuthTokenRepository(t *testing.T) {
+	repo := NewMemoryOAuthTokenRepository()
+	ctx := context.Background()
+
+	refreshExpiresAt := time.Now().Add(24 * time.Hour)
+	issued, err := repo.CreateOAuthToken(ctx, &OAuthToken{
+		ClientID:         "app",
+		UserID:           1,
+		GrantType:        "authorization_code",
+		Scopes:           []string{"profile:read"},
+		AccessTokenHash:  "access",
+		RefreshTokenHash: "refresh",
+		ExpiresAt:        time.Now().Add(time.Hour),
+		RefreshExpiresAt: &refreshExpiresAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthToken() error = %v", err)
+	}
+	machine, _ := repo.CreateOAuthToken(ctx, &OAuthToken{
+		ClientID: "app", UserID: 1, GrantType: "client_credentials", AccessTokenHash: "machine", ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if _, err := repo.CreateOAuthToken(ctx, &OAuthToken{ClientID: "app", UserID: 2, AccessTokenHash: "other", RefreshTokenHash: "refresh"}); err == nil {
+		t.Error("Expected a duplicate refresh token to be rejected")
+	}
+
+	now := time.Now()
+	if found, err := repo.GetOAuthTokenByRefreshHash(ctx, "refresh"); err != nil || found.ID != issued.ID || !found.Active(now) || !found.RefreshActive(now) {
+		t.Errorf("Expected the token by its refresh token, got %+v, %v", found, err)
+	}
+	if !issued.HasScope("profile:read") || issued.HasScope("profile:write") {
+		t.Error("Expected the token to have only its scopes")
+	}
+	if machine.RefreshActive(now) {
+		t.Error("Expected a token without a refresh token not to refresh")
+	}
+	if _, err := repo.GetOAuthTokenByRefreshHash(ctx, ""); !errors.Is(err, ErrOAuthTokenNotFound) {
+		t.Errorf("Expected an empty refresh token not to match, got %v", err)
+	}
+
+	if err := repo.RevokeOAuthToken(ctx, issued.ID); err != nil {
+		t.Fatalf("RevokeOAuthToken() error = %v", err)
+	}
+	if err := repo.RevokeOAuthToken(ctx, issued.ID); !errors.Is(err, ErrOAuthTokenNotFound) {
+		t.Errorf("Expected a token to be revoked once, got %v", err)
+	}
+	if found, _ := repo.GetOAuthTokenByAccessHash(ctx, "access"); found.Active(now) || found.RefreshActive(now) {
+		t.Error("Expected a revoked token to be inactive")
+	}
+
+	repo.RevokeUserOAuthTokens(ctx, 1)
+	if found, _ := repo.GetOAuthTokenByAccessHash(ctx, "machine"); found.Active(now) {
+		t.Error("Expected every token of the user to be revoked")
+	}
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryOIDCIdentityRepository implements OIDCIdentityRepository using in-memory storage
+type MemoryOIDCIdentityRepository struct {
+	mu         sync.RWMutex
+	identities map[int]*OIDCIdentity
+	nextID     int
+}
+
+// NewMemoryOIDCIdentityRepository creates an empty in-memory identity repository
+func NewMemoryOIDCIdentityRepository() *MemoryOIDCIdentityRepository {
+	return &MemoryOIDCIdentityRepository{
+		identities: make(map[int]*OIDCIdentity),
+		nextID:     1,
+	}
+}
+
+// CreateOIDCIdentity links a provider account to a user
+func (r *MemoryOIDCIdentityRepository) CreateOIDCIdentity(ctx context.Context, identity *OIDCIdentity) (*OIDCIdentity, error) {
+	if identity == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "identity cannot be nil"}
+	}
+	if identity.Provider == "" || identity.Subject == "" || identity.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "provider, subject and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return nil, ErrOIDCIdentityExists
+		}
+	}
+
+	stored := copyOIDCIdentity(identity)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	r.identities[stored.ID] = stored
+	r.nextID++
+
+	return copyOIDCIdentity(stored), nil
+}
+
+// GetOIDCIdentity retrieves the link for a provider's subject
+func (r *MemoryOIDCIdentityRepository) GetOIDCIdentity(ctx context.Context, provider, subject string) (*OIDCIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return copyOIDCIdentity(identity), nil
+		}
+	}
+	return nil, ErrOIDCIdentityNotFound
+}
+
+// ListUserOIDCIdentities retrieves every provider account linked to a user, oldest first
+func (r *MemoryOIDCIdentityRepository) ListUserOIDCIdentities(ctx context.Context, userID int) ([]*OIDCIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	identities := []*OIDCIdentity{}
+	for id := 1; id < r.nextID; id++ {
+		if identity, ok := r.identities[id]; ok && identity.UserID == userID {
+			identities = append(identities, copyOIDCIdentity(identity))
+		}
+	}
+	return identities, nil
+}
+
+// TouchOIDCIdentity records a login and the email the provider reported
+func (r *MemoryOIDCIdentityRepository) TouchOIDCIdentity(ctx context.Context, id int, email string, loginAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity, ok := r.identities[id]
+	if !ok {
+		return ErrOIDCIdentityNotFound
+	}
+
+	identity.Email = email
+	identity.LastLoginAt = &loginAt
+	return nil
+}
+
+func copyOIDCIdentity(identity *OIDCIdentity) *OIDCIdentity {
+	c := *identity
+	if identity.LastLoginAt != nil {
+		lastLoginAt := *identity.LastLoginAt
+		c.LastLoginAt = &lastLoginAt
+	}
+	return &c
+}
+
+// MemoryOIDCLoginStateRepository implements OIDCLoginStateRepository using in-memory storage
+type MemoryOIDCLoginStateRepository struct {
+	mu     sync.Mutex
+	states map[string]*OIDCLoginState
+}
+
+// NewMemoryOIDCLoginStateRepository creates an empty in-memory login state repository
+func NewMemoryOIDCLoginStateRepository() *MemoryOIDCLoginStateRepository {
+	return &MemoryOIDCLoginStateRepository{
+		states: make(map[string]*OIDCLoginState),
+	}
+}
+
+// CreateOIDCLoginState stores a pending login
+func (r *MemoryOIDCLoginStateRepository) CreateOIDCLoginState(ctx context.Context, state *OIDCLoginState) error {
+	if state == nil || state.StateHash == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "state is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.states[state.StateHash]; exists {
+		return &DatabaseError{Type: "CONFLICT", Message: "oidc login state already exists"}
+	}
+
+	stored := *state
+	stored.CreatedAt = time.Now()
+	r.states[state.StateHash] = &stored
+	return nil
+}
+
+// ConsumeOIDCLoginState atomically retrieves and deletes a pending login
+func (r *MemoryOIDCLoginStateRepository) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[stateHash]
+	if !ok {
+		return nil, ErrOIDCLoginStateNotFound
+	}
+
+	delete(r.states, stateHash)
+	c := *state
+	return &c, nil
+}
+
+// DeleteExpiredOIDCLoginStates removes logins that expired before the given time
+func (r *MemoryOIDCLoginStateRepository) DeleteExpiredOIDCLoginStates(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for hash, state := range r.states {
+		if state.ExpiresAt.Before(before) {
+			delete(r.states, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteUserIdentities removes every provider account linked to a user
+func (r *MemoryOIDCIdentityRepository) deleteUserIdentities(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, identity := range r.identities {
+		if identity.UserID == userID {
+			delete(r.identities, id)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryOIDCIdentityRepository(t *testing.T) {
+	repo := NewMemoryOIDCIdentityRepository()
+	ctx := context.Background()
+
+	created, err := repo.CreateOIDCIdentity(ctx, &OIDCIdentity{UserID: 1, Provider: "google", Subject: "abc", Email: "a@example.com"})
+	if err != nil {
+		t.Fatalf("CreateOIDCIdentity() error = %v", err)
+	}
+
+	if _, err := repo.CreateOIDCIdentity(ctx, &OIDCIdentity{UserID: 2, Provider: "google", Subject: "abc"}); !errors.Is(err, ErrOIDCIdentityExists) {
+		t.Errorf("Expected duplicate link to be rejected, got %v", err)
+	}
+	if _, err := repo.CreateOIDCIdentity(ctx, &OIDCIdentity{UserID: 2, Provider: "microsoft", Subject: "abc"}); err != nil {
+		t.Errorf("Expected the same subject at another provider to be allowed, got %v", err)
+	}
+
+	found, err := repo.GetOIDCIdentity(ctx, "google", "abc")
+	if err != nil || found.ID != created.ID || found.UserID != 1 {
+		t.Fatalf("GetOIDCIdentity() = %+v, %v", found, err)
+	}
+	if _, err := repo.GetOIDCIdentity(ctx, "google", "other"); !errors.Is(err, ErrOIDCIdentityNotFound) {
+		t.Errorf("Expected ErrOIDCIdentityNotFound, got %v", err)
+	}
+
+	if err := repo.TouchOIDCIdentity(ctx, created.ID, "new@example.com", time.Now()); err != nil {
+		t.Fatalf("TouchOIDCIdentity() error = %v", err)
+	}
+
+	list, _ := repo.ListUserOIDCIdentities(ctx, 1)
+	if len(list) != 1 || list[0].Email != "new@example.com" || list[0].LastLoginAt == nil {
+		t.Errorf("Unexpected identities: %+v", list)
+	}
+}
+
+func TestMemoryOIDCLoginStateRepository(t *testing.T) {
+	repo := NewMemoryOIDCLoginStateRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	repo.CreateOIDCLoginState(ctx, &OIDCLoginState{StateHash: "live", Provider: "google", ExpiresAt: now.Add(time.Minute)})
+	repo.CreateOIDCLoginState(ctx, &OIDCLoginState{StateHash: "stale", Provider: "google", ExpiresAt: now.Add(-time.Minute)})
+
+	state, err := repo.ConsumeOIDCLoginState(ctx, "live")
+	if err != nil || state.Provider != "google" {
+		t.Fatalf("ConsumeOIDCLoginState() = %+v, %v", state, err)
+	}
+	if _, err := repo.ConsumeOIDCLoginState(ctx, "live"); !errors.Is(err, ErrOIDCLoginStateNotFound) {
+		t.Errorf("Expected state to be consumed once, got %v", err)
+	}
+
+	if deleted, _ := repo.DeleteExpiredOIDCLoginStates(ctx, now); deleted != 1 {
+		t.Errorf("Expected 1 expired state to be deleted, got %d", deleted)
+	}
+}
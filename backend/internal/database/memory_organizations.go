@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryOrganizationRepository implements OrganizationRepository using
+// in-memory storage
+type MemoryOrganizationRepository struct {
+	mu            sync.RWMutex
+	organizations map[int]*Organization
+	members       map[int]map[int]*Membership // organization ID to user ID
+	nextID        int
+
+	// users supplies members' names and addresses
+	users *MemoryUserRepository
+}
+
+// NewMemoryOrganizationRepository creates an empty in-memory organization
+// repository whose members are looked up in users
+func NewMemoryOrganizationRepository(users *MemoryUserRepository) *MemoryOrganizationRepository {
+	return &MemoryOrganizationRepository{
+		organizations: make(map[int]*Organization),
+		members:       make(map[int]map[int]*Membership),
+		nextID:        1,
+		users:         users,
+	}
+}
+
+// CreateOrganization stores a new organization with ownerID as its first owner
+func (r *MemoryOrganizationRepository) CreateOrganization(ctx context.Context, org *Organization, ownerID int) (*Organization, error) {
+	if org == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization cannot be nil"}
+	}
+	if strings.TrimSpace(org.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization name is required"}
+	}
+	if _, err := r.users.GetUserByID(ctx, ownerID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	stored := *org
+	stored.ID = r.nextID
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	r.organizations[stored.ID] = &stored
+	r.members[stored.ID] = map[int]*Membership{
+		ownerID: {OrganizationID: stored.ID, UserID: ownerID, Role: OrgRoleOwner, CreatedAt: now},
+	}
+	r.nextID++
+
+	created := stored
+	return &created, nil
+}
+
+// GetOrganization retrieves an organization by its ID
+func (r *MemoryOrganizationRepository) GetOrganization(ctx context.Context, id int) (*Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	org, exists := r.organizations[id]
+	if !exists {
+		return nil, ErrOrganizationNotFound
+	}
+
+	found := *org
+	return &found, nil
+}
+
+// UpdateOrganization updates an organization's name
+func (r *MemoryOrganizationRepository) UpdateOrganization(ctx context.Context, org *Organization) (*Organization, error) {
+	if org == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization cannot be nil"}
+	}
+	if strings.TrimSpace(org.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization name is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, exists := r.organizations[org.ID]
+	if !exists {
+		return nil, ErrOrganizationNotFound
+	}
+
+	stored.Name = org.Name
+	stored.UpdatedAt = time.Now()
+
+	updated := *stored
+	return &updated, nil
+}
+
+// DeleteOrganization deletes an organization and its memberships
+func (r *MemoryOrganizationRepository) DeleteOrganization(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.organizations[id]; !exists {
+		return ErrOrganizationNotFound
+	}
+
+	delete(r.organizations, id)
+	delete(r.members, id)
+	return nil
+}
+
+// ListUserOrganizations retrieves the organizations a user belongs to,
+// ordered by name
+func (r *MemoryOrganizationRepository) ListUserOrganizations(ctx context.Context, userID int) ([]*UserOrganization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orgs := []*UserOrganization{}
+	for orgID, members := range r.members {
+		if membership, ok := members[userID]; ok {
+			orgs = append(orgs, &UserOrganization{Organization: *r.organizations[orgID], Role: membership.Role})
+		}
+	}
+
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].ID < orgs[j].ID
+	})
+	return orgs, nil
+}
+
+// AddMember adds a user to an organization
+func (r *MemoryOrganizationRepository) AddMember(ctx context.Context, organizationID, userID int, role string) (*Membership, error) {
+	if !ValidOrgRole(role) {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+	if _, err := r.users.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	members, exists := r.members[organizationID]
+	if !exists {
+		return nil, ErrOrganizationNotFound
+	}
+	if _, exists := members[userID]; exists {
+		return nil, ErrMembershipExists
+	}
+
+	membership := &Membership{OrganizationID: organizationID, UserID: userID, Role: role, CreatedAt: time.Now()}
+	members[userID] = membership
+
+	added := *membership
+	return &added, nil
+}
+
+// GetMembership retrieves a user's membership of an organization
+func (r *MemoryOrganizationRepository) GetMembership(ctx context.Context, organizationID, userID int) (*Membership, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	membership, exists := r.members[organizationID][userID]
+	if !exists {
+		return nil, ErrMembershipNotFound
+	}
+
+	found := *membership
+	return &found, nil
+}
+
+// ListMembers retrieves the members of an organization, longest standing first
+func (r *MemoryOrganizationRepository) ListMembers(ctx context.Context, organizationID int) ([]*OrganizationMember, error) {
+	r.mu.RLock()
+	memberships := []Membership{}
+	for _, membership := range r.members[organizationID] {
+		memberships = append(memberships, *membership)
+	}
+	r.mu.RUnlock()
+
+	members := []*OrganizationMember{}
+	for _, membership := range memberships {
+		user, err := r.users.GetUserByID(ctx, membership.UserID)
+		if err != nil {
+			continue
+		}
+		members = append(members, &OrganizationMember{Membership: membership, Name: user.Name, Email: user.Email})
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].CreatedAt.Equal(members[j].CreatedAt) {
+			return members[i].CreatedAt.Before(members[j].CreatedAt)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role
+func (r *MemoryOrganizationRepository) UpdateMemberRole(ctx context.Context, organizationID, userID int, role string) error {
+	if !ValidOrgRole(role) {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	membership, exists := r.members[organizationID][userID]
+	if !exists {
+		return ErrMembershipNotFound
+	}
+
+	membership.Role = role
+	return nil
+}
+
+// RemoveMember takes a user out of an organization
+func (r *MemoryOrganizationRepository) RemoveMember(ctx context.Context, organizationID, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.members[organizationID][userID]; !exists {
+		return ErrMembershipNotFound
+	}
+
+	delete(r.members[organizationID], userID)
+	return nil
+}
+
+// CountMembers returns how many members of an organization have a role, or
+// how many members it has if role is empty
+func (r *MemoryOrganizationRepository) CountMembers(ctx context.Context, organizationID int, role string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, membership := range r.members[organizationID] {
+		if role == "" || membership.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// TransferOwnership makes toUserID an owner and fromUserID an admin
+func (r *MemoryOrganizationRepository) TransferOwnership(ctx context.Context, organizationID, fromUserID, toUserID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from, fromExists := r.members[organizationID][fromUserID]
+	to, toExists := r.members[organizationID][toUserID]
+	if !fromExists || !toExists {
+		return ErrMembershipNotFound
+	}
+
+	to.Role = OrgRoleOwner
+	if fromUserID != toUserID {
+		from.Role = OrgRoleAdmin
+	}
+	return nil
+}
+
+// deleteUserMemberships takes a user out of every organization
+func (r *MemoryOrganizationRepository) deleteUserMemberships(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, members := range r.members {
+		delete(members, userID)
+	}
+}
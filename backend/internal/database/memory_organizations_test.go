@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryOrganizationRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	repo := db.Organizations()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+
+	if _, err := repo.CreateOrganization(ctx, &Organization{Name: " "}, alice.ID); err == nil {
+		t.Error("Expected an organization without a name to be rejected")
+	}
+	if _, err := repo.CreateOrganization(ctx, &Organization{Name: "Acme"}, 999); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an unknown owner, got %v", err)
+	}
+
+	acme, err := repo.CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	if err != nil {
+		t.Fatalf("CreateOrganization() error = %v", err)
+	}
+	other, _ := repo.CreateOrganization(ctx, &Organization{Name: "Aardvark"}, alice.ID)
+
+	membership, err := repo.GetMembership(ctx, acme.ID, alice.ID)
+	if err != nil || membership.Role != OrgRoleOwner {
+		t.Fatalf("Expected the creator to own the organization, got %+v, %v", membership, err)
+	}
+
+	if _, err := repo.AddMember(ctx, acme.ID, bob.ID, "boss"); err == nil {
+		t.Error("Expected an unknown role to be rejected")
+	}
+	if _, err := repo.AddMember(ctx, acme.ID, bob.ID, OrgRoleMember); err != nil {
+		t.Fatalf("AddMember() error = %v", err)
+	}
+	if _, err := repo.AddMember(ctx, acme.ID, bob.ID, OrgRoleMember); !errors.Is(err, ErrMembershipExists) {
+		t.Errorf("Expected ErrMembershipExists, got %v", err)
+	}
+
+	orgs, _ := repo.ListUserOrganizations(ctx, alice.ID)
+	if len(orgs) != 2 || orgs[0].ID != other.ID || orgs[1].Role != OrgRoleOwner {
+		t.Errorf("Expected Alice's organizations by name with her role, got %+v", orgs)
+	}
+
+	members, _ := repo.ListMembers(ctx, acme.ID)
+	if len(members) != 2 || members[0].UserID != alice.ID || members[1].Email != "bob@example.com" {
+		t.Errorf("Expected Alice then Bob, got %+v", members)
+	}
+
+	if owners, _ := repo.CountMembers(ctx, acme.ID, OrgRoleOwner); owners != 1 {
+		t.Errorf("Expected one owner, got %d", owners)
+	}
+	if everyone, _ := repo.CountMembers(ctx, acme.ID, ""); everyone != 2 {
+		t.Errorf("Expected two members, got %d", everyone)
+	}
+
+	if err := repo.TransferOwnership(ctx, acme.ID, alice.ID, bob.ID); err != nil {
+		t.Fatalf("TransferOwnership() error = %v", err)
+	}
+	if m, _ := repo.GetMembership(ctx, acme.ID, bob.ID); m.Role != OrgRoleOwner {
+		t.Errorf("Expected Bob to own the organization, got %s", m.Role)
+	}
+	if m, _ := repo.GetMembership(ctx, acme.ID, alice.ID); m.Role != OrgRoleAdmin {
+		t.Errorf("Expected Alice to become an admin, got %s", m.Role)
+	}
+	if err := repo.TransferOwnership(ctx, other.ID, alice.ID, bob.ID); !errors.Is(err, ErrMembershipNotFound) {
+		t.Errorf("Expected a transfer to a non-member to fail, got %v", err)
+	}
+
+	if err := repo.RemoveMember(ctx, acme.ID, alice.ID); err != nil {
+		t.Fatalf("RemoveMember() error = %v", err)
+	}
+	if _, err := repo.GetMembership(ctx, acme.ID, alice.ID); !errors.Is(err, ErrMembershipNotFound) {
+		t.Errorf("Expected Alice to be gone, got %v", err)
+	}
+
+	if err := repo.DeleteOrganization(ctx, acme.ID); err != nil {
+		t.Fatalf("DeleteOrganization() error = %v", err)
+	}
+	if _, err := repo.GetOrganization(ctx, acme.ID); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+	if orgs, _ := repo.ListUserOrganizations(ctx, bob.ID); len(orgs) != 0 {
+		t.Errorf("Expected Bob to belong to nothing, got %+v", orgs)
+	}
+}
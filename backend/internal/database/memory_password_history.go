@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPasswordHistoryRepository implements PasswordHistoryRepository using in-memory storage
+type MemoryPasswordHistoryRepository struct {
+	mu     sync.Mutex
+	hashes map[int][]string // newest last
+}
+
+// NewMemoryPasswordHistoryRepository creates an empty in-memory password history repository
+func NewMemoryPasswordHistoryRepository() *MemoryPasswordHistoryRepository {
+	return &MemoryPasswordHistoryRepository{
+		hashes: make(map[int][]string),
+	}
+}
+
+// AddPasswordHash records a replaced password hash, keeping the newest keep
+func (r *MemoryPasswordHistoryRepository) AddPasswordHash(ctx context.Context, userID int, passwordHash string, keep int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hashes := append(r.hashes[userID], passwordHash)
+	if len(hashes) > keep {
+		hashes = hashes[len(hashes)-max(keep, 0):]
+	}
+	if len(hashes) == 0 {
+		delete(r.hashes, userID)
+		return nil
+	}
+	r.hashes[userID] = append([]string(nil), hashes...)
+	return nil
+}
+
+// ListPasswordHashes retrieves up to limit of a user's replaced hashes, newest first
+func (r *MemoryPasswordHistoryRepository) ListPasswordHashes(ctx context.Context, userID int, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hashes := r.hashes[userID]
+	list := make([]string, 0, min(limit, len(hashes)))
+	for i := len(hashes) - 1; i >= 0 && len(list) < limit; i-- {
+		list = append(list, hashes[i])
+	}
+	return list, nil
+}
+
+// deleteUserHistory forgets every replaced password of a user
+func (r *MemoryPasswordHistoryRepository) deleteUserHistory(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.hashes, userID)
+}
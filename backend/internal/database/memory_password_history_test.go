@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryPasswordHistoryRepository(t *testing.T) {
+	repo := NewMemoryPasswordHistoryRepository()
+	ctx := context.Background()
+
+	for _, hash := range []string{"a", "b", "c", "d"} {
+		if err := repo.AddPasswordHash(ctx, 1, hash, 3); err != nil {
+			t.Fatalf("AddPasswordHash() error = %v", err)
+		}
+	}
+	repo.AddPasswordHash(ctx, 2, "x", 3)
+
+	// Only the newest three are kept, newest first
+	hashes, err := repo.ListPasswordHashes(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListPasswordHashes() error = %v", err)
+	}
+	if !reflect.DeepEqual(hashes, []string{"d", "c", "b"}) {
+		t.Errorf("Expected [d c b], got %v", hashes)
+	}
+
+	if hashes, _ := repo.ListPasswordHashes(ctx, 1, 2); !reflect.DeepEqual(hashes, []string{"d", "c"}) {
+		t.Errorf("Expected the limit to apply, got %v", hashes)
+	}
+	if hashes, _ := repo.ListPasswordHashes(ctx, 2, 10); !reflect.DeepEqual(hashes, []string{"x"}) {
+		t.Errorf("Expected other user's history to be separate, got %v", hashes)
+	}
+
+	// Keeping none forgets the history
+	repo.AddPasswordHash(ctx, 1, "e", 0)
+	if hashes, _ := repo.ListPasswordHashes(ctx, 1, 10); len(hashes) != 0 {
+		t.Errorf("Expected no history, got %v", hashes)
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryRecoveryCodeRepository implements RecoveryCodeRepository using in-memory storage
+type MemoryRecoveryCodeRepository struct {
+	mu     sync.Mutex
+	codes  map[int][]*RecoveryCode
+	nextID int
+}
+
+// NewMemoryRecoveryCodeRepository creates an empty in-memory recovery code repository
+func NewMemoryRecoveryCodeRepository() *MemoryRecoveryCodeRepository {
+	return &MemoryRecoveryCodeRepository{
+		codes:  make(map[int][]*RecoveryCode),
+		nextID: 1,
+	}
+}
+
+// ReplaceRecoveryCodes discards a user's existing codes and stores new ones
+func (r *MemoryRecoveryCodeRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	codes := make([]*RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, &RecoveryCode{
+			ID:        r.nextID,
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: now,
+		})
+		r.nextID++
+	}
+
+	r.codes[userID] = codes
+	return nil
+}
+
+// ConsumeRecoveryCode atomically marks an unused code as used
+func (r *MemoryRecoveryCodeRepository) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, code := range r.codes[userID] {
+		if code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return nil
+		}
+	}
+	return ErrRecoveryCodeNotFound
+}
+
+// CountUnusedRecoveryCodes returns how many codes a user has left
+func (r *MemoryRecoveryCodeRepository) CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, code := range r.codes[userID] {
+		if code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteRecoveryCodes removes every code belonging to a user
+func (r *MemoryRecoveryCodeRepository) DeleteRecoveryCodes(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.codes, userID)
+	return nil
+}
+
+// deleteUserCodes removes every recovery code of a user
+func (r *MemoryRecoveryCodeRepository) deleteUserCodes(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.codes, userID)
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryRecoveryCodeRepository(t *testing.T) {
+	repo := NewMemoryRecoveryCodeRepository()
+	ctx := context.Background()
+
+	if err := repo.ReplaceRecoveryCodes(ctx, 1, []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes() error = %v", err)
+	}
+	repo.ReplaceRecoveryCodes(ctx, 2, []string{"a"})
+
+	if err := repo.ConsumeRecoveryCode(ctx, 1, "b"); err != nil {
+		t.Fatalf("ConsumeRecoveryCode() error = %v", err)
+	}
+	if err := repo.ConsumeRecoveryCode(ctx, 1, "b"); !errors.Is(err, ErrRecoveryCodeNotFound) {
+		t.Errorf("Expected used code to be rejected, got %v", err)
+	}
+	if err := repo.ConsumeRecoveryCode(ctx, 1, "z"); !errors.Is(err, ErrRecoveryCodeNotFound) {
+		t.Errorf("Expected unknown code to be rejected, got %v", err)
+	}
+
+	if count, _ := repo.CountUnusedRecoveryCodes(ctx, 1); count != 2 {
+		t.Errorf("Expected 2 unused codes, got %d", count)
+	}
+
+	// Replacing discards old codes, used or not
+	repo.ReplaceRecoveryCodes(ctx, 1, []string{"d"})
+	if err := repo.ConsumeRecoveryCode(ctx, 1, "a"); !errors.Is(err, ErrRecoveryCodeNotFound) {
+		t.Errorf("Expected replaced code to be rejected, got %v", err)
+	}
+
+	repo.DeleteRecoveryCodes(ctx, 1)
+	if count, _ := repo.CountUnusedRecoveryCodes(ctx, 1); count != 0 {
+		t.Errorf("Expected no codes after delete, got %d", count)
+	}
+	if count, _ := repo.CountUnusedRecoveryCodes(ctx, 2); count != 1 {
+		t.Errorf("Expected other user's codes to be untouched, got %d", count)
+	}
+}
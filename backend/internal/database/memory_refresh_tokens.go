@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryRefreshTokenRepository implements RefreshTokenRepository using in-memory storage
+type MemoryRefreshTokenRepository struct {
+	mu     sync.RWMutex
+	tokens map[int]*RefreshToken
+	byHash map[string]*RefreshToken
+	nextID int
+}
+
+// NewMemoryRefreshTokenRepository creates an empty in-memory refresh token repository
+func NewMemoryRefreshTokenRepository() *MemoryRefreshTokenRepository {
+	return &MemoryRefreshTokenRepository{
+		tokens: make(map[int]*RefreshToken),
+		byHash: make(map[string]*RefreshToken),
+		nextID: 1,
+	}
+}
+
+// CreateRefreshToken stores a new refresh token
+func (r *MemoryRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "refresh token cannot be nil"}
+	}
+	if token.TokenHash == "" || token.FamilyID == "" || token.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "token hash, family and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.byHash[token.TokenHash]; exists {
+		return nil, &DatabaseError{Type: "CONFLICT", Message: "refresh token already exists"}
+	}
+
+	newToken := copyRefreshToken(token)
+	newToken.ID = r.nextID
+	newToken.CreatedAt = time.Now()
+	newToken.UsedAt = nil
+	newToken.RevokedAt = nil
+
+	r.tokens[newToken.ID] = newToken
+	r.byHash[newToken.TokenHash] = newToken
+	r.nextID++
+
+	return copyRefreshToken(newToken), nil
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by its hash
+func (r *MemoryRefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	token, exists := r.byHash[tokenHash]
+	if !exists {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	return copyRefreshToken(token), nil
+}
+
+// MarkRefreshTokenUsed atomically marks a token as used
+func (r *MemoryRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, exists := r.tokens[id]
+	if !exists {
+		return ErrRefreshTokenNotFound
+	}
+	if token.UsedAt != nil {
+		return ErrRefreshTokenUsed
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in a family
+func (r *MemoryRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token belonging to a user
+func (r *MemoryRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredRefreshTokens removes tokens that expired before the given time
+func (r *MemoryRefreshTokenRepository) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, token := range r.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(r.tokens, id)
+			delete(r.byHash, token.TokenHash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// copyRefreshToken creates a deep copy of a refresh token
+func copyRefreshToken(token *RefreshToken) *RefreshToken {
+	if token == nil {
+		return nil
+	}
+
+	c := *token
+	c.UsedAt = copyTime(token.UsedAt)
+	c.RevokedAt = copyTime(token.RevokedAt)
+	return &c
+}
+
+// copyTime copies an optional timestamp
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// deleteUserTokens removes every refresh token of a user
+func (r *MemoryRefreshTokenRepository) deleteUserTokens(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+			delete(r.byHash, token.TokenHash)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryRefreshTokenRepository(t *testing.T) {
+	repo := NewMemoryRefreshTokenRepository()
+	ctx := context.Background()
+
+	first, err := repo.CreateRefreshToken(ctx, &RefreshToken{
+		UserID:    1,
+		FamilyID:  "family-a",
+		TokenHash: "hash-1",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken() error = %v", err)
+	}
+	if first.ID != 1 || first.CreatedAt.IsZero() {
+		t.Errorf("Expected ID 1 and CreatedAt to be set, got %+v", first)
+	}
+
+	second, _ := repo.CreateRefreshToken(ctx, &RefreshToken{
+		UserID:    1,
+		FamilyID:  "family-a",
+		TokenHash: "hash-2",
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	other, _ := repo.CreateRefreshToken(ctx, &RefreshToken{
+		UserID:    2,
+		FamilyID:  "family-b",
+		TokenHash: "hash-3",
+		ExpiresAt: time.Now().Add(-time.Hour),
+	})
+
+	if _, err := repo.CreateRefreshToken(ctx, &RefreshToken{UserID: 1, FamilyID: "x", TokenHash: "hash-1"}); err == nil {
+		t.Error("Expected duplicate hash to be rejected")
+	}
+
+	if _, err := repo.GetRefreshTokenByHash(ctx, "missing"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("Expected ErrRefreshTokenNotFound, got %v", err)
+	}
+
+	if err := repo.MarkRefreshTokenUsed(ctx, first.ID); err != nil {
+		t.Fatalf("MarkRefreshTokenUsed() error = %v", err)
+	}
+	if err := repo.MarkRefreshTokenUsed(ctx, first.ID); !errors.Is(err, ErrRefreshTokenUsed) {
+		t.Errorf("Expected ErrRefreshTokenUsed on second use, got %v", err)
+	}
+
+	if err := repo.RevokeRefreshTokenFamily(ctx, "family-a"); err != nil {
+		t.Fatalf("RevokeRefreshTokenFamily() error = %v", err)
+	}
+	got, _ := repo.GetRefreshTokenByHash(ctx, second.TokenHash)
+	if got.RevokedAt == nil {
+		t.Error("Expected family member to be revoked")
+	}
+	got, _ = repo.GetRefreshTokenByHash(ctx, other.TokenHash)
+	if got.RevokedAt != nil {
+		t.Error("Expected other family to be untouched")
+	}
+
+	deleted, err := repo.DeleteExpiredRefreshTokens(ctx, time.Now())
+	if err != nil || deleted != 1 {
+		t.Errorf("Expected 1 expired token deleted, got %d (%v)", deleted, err)
+	}
+}
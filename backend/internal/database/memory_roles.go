@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRoleRepository implements RoleRepository using in-memory storage
+type MemoryRoleRepository struct {
+	mu          sync.RWMutex
+	roles       map[int]*Role
+	assignments map[int]map[int]bool // user ID to role IDs
+	nextID      int
+}
+
+// NewMemoryRoleRepository creates an empty in-memory role repository
+func NewMemoryRoleRepository() *MemoryRoleRepository {
+	return &MemoryRoleRepository{
+		roles:       make(map[int]*Role),
+		assignments: make(map[int]map[int]bool),
+		nextID:      1,
+	}
+}
+
+// CreateRole stores a new role
+func (r *MemoryRoleRepository) CreateRole(ctx context.Context, role *Role) (*Role, error) {
+	if role == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "role cannot be nil"}
+	}
+	if role.Name == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "role name is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.findRole(role.Name) != nil {
+		return nil, ErrRoleExists
+	}
+
+	stored := copyRole(role)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	r.roles[stored.ID] = stored
+	r.nextID++
+
+	return copyRole(stored), nil
+}
+
+// GetRoleByName retrieves a role by its name
+func (r *MemoryRoleRepository) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role := r.findRole(name)
+	if role == nil {
+		return nil, ErrRoleNotFound
+	}
+	return copyRole(role), nil
+}
+
+// ListRoles retrieves every role, ordered by name
+func (r *MemoryRoleRepository) ListRoles(ctx context.Context) ([]*Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roles := []*Role{}
+	for _, role := range r.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sortRoles(roles)
+	return roles, nil
+}
+
+// AssignRole gives a user a role
+func (r *MemoryRoleRepository) AssignRole(ctx context.Context, userID int, roleName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role := r.findRole(roleName)
+	if role == nil {
+		return ErrRoleNotFound
+	}
+
+	if r.assignments[userID] == nil {
+		r.assignments[userID] = make(map[int]bool)
+	}
+	r.assignments[userID][role.ID] = true
+	return nil
+}
+
+// UnassignRole takes a role away from a user
+func (r *MemoryRoleRepository) UnassignRole(ctx context.Context, userID int, roleName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role := r.findRole(roleName)
+	if role == nil || !r.assignments[userID][role.ID] {
+		return ErrRoleNotFound
+	}
+
+	delete(r.assignments[userID], role.ID)
+	if len(r.assignments[userID]) == 0 {
+		delete(r.assignments, userID)
+	}
+	return nil
+}
+
+// ListUserRoles retrieves the roles assigned to a user, ordered by name
+func (r *MemoryRoleRepository) ListUserRoles(ctx context.Context, userID int) ([]*Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roles := []*Role{}
+	for roleID := range r.assignments[userID] {
+		roles = append(roles, copyRole(r.roles[roleID]))
+	}
+	sortRoles(roles)
+	return roles, nil
+}
+
+// CountRoleUsers returns how many users have a role
+func (r *MemoryRoleRepository) CountRoleUsers(ctx context.Context, roleName string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role := r.findRole(roleName)
+	if role == nil {
+		return 0, nil
+	}
+
+	count := 0
+	for _, roleIDs := range r.assignments {
+		if roleIDs[role.ID] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// findRole returns the stored role with a name. The caller must hold r.mu.
+func (r *MemoryRoleRepository) findRole(name string) *Role {
+	for _, role := range r.roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
+
+// deleteUserRoles removes every role assignment of a user
+func (r *MemoryRoleRepository) deleteUserRoles(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.assignments, userID)
+}
+
+// copyRole copies a role, including its permissions
+func copyRole(role *Role) *Role {
+	c := *role
+	c.Permissions = append([]string{}, role.Permissions...)
+	return &c
+}
+
+func sortRoles(roles []*Role) {
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+}
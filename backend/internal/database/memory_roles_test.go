@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryRoleRepository(t *testing.T) {
+	repo := NewMemoryRoleRepository()
+	ctx := context.Background()
+
+	admin, err := repo.CreateRole(ctx, &Role{Name: "admin", Permissions: []string{AllPermissions}})
+	if err != nil {
+		t.Fatalf("CreateRole() error = %v", err)
+	}
+	repo.CreateRole(ctx, &Role{Name: "support", Permissions: []string{"users:read"}})
+
+	if _, err := repo.CreateRole(ctx, &Role{Name: "admin"}); !errors.Is(err, ErrRoleExists) {
+		t.Errorf("Expected ErrRoleExists, got %v", err)
+	}
+	if _, err := repo.CreateRole(ctx, &Role{}); err == nil {
+		t.Error("Expected a role without a name to be rejected")
+	}
+
+	found, err := repo.GetRoleByName(ctx, "admin")
+	if err != nil || found.ID != admin.ID || !found.HasPermission("anything") {
+		t.Fatalf("GetRoleByName() = %+v, %v", found, err)
+	}
+	if _, err := repo.GetRoleByName(ctx, "missing"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("Expected ErrRoleNotFound, got %v", err)
+	}
+
+	if err := repo.AssignRole(ctx, 1, "support"); err != nil {
+		t.Fatalf("AssignRole() error = %v", err)
+	}
+	repo.AssignRole(ctx, 1, "admin")
+	if err := repo.AssignRole(ctx, 1, "admin"); err != nil {
+		t.Errorf("Expected assigning a role twice to do nothing, got %v", err)
+	}
+	if err := repo.AssignRole(ctx, 1, "missing"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("Expected ErrRoleNotFound, got %v", err)
+	}
+
+	roles, _ := repo.ListUserRoles(ctx, 1)
+	if len(roles) != 2 || roles[0].Name != "admin" || roles[1].Name != "support" {
+		t.Fatalf("Unexpected roles: %+v", roles)
+	}
+	if count, _ := repo.CountRoleUsers(ctx, "admin"); count != 1 {
+		t.Errorf("Expected one admin, got %d", count)
+	}
+
+	if err := repo.UnassignRole(ctx, 1, "admin"); err != nil {
+		t.Fatalf("UnassignRole() error = %v", err)
+	}
+	if err := repo.UnassignRole(ctx, 1, "admin"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("Expected ErrRoleNotFound for a role the user doesn't have, got %v", err)
+	}
+	if count, _ := repo.CountRoleUsers(ctx, "admin"); count != 0 {
+		t.Errorf("Expected no admins, got %d", count)
+	}
+
+	repo.deleteUserRoles(1)
+	if roles, _ := repo.ListUserRoles(ctx, 1); len(roles) != 0 {
+		t.Errorf("Expected no roles after deleting the user's roles, got %+v", roles)
+	}
+}
+
+func TestRole_HasPermission(t *testing.T) {
+	role := &Role{Name: "support", Permissions: []string{"users:read"}}
+	if !role.HasPermission("users:read") || role.HasPermission("users:write") {
+		t.Errorf("Unexpected permissions for %+v", role)
+	}
+}
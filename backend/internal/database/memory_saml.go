@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySAMLConnectionRepository implements SAMLConnectionRepository using
+// in-memory storage
+type MemorySAMLConnectionRepository struct {
+	mu          sync.RWMutex
+	connections map[int]*SAMLConnection
+
+	// organizations checks connections are saved for organizations that exist
+	organizations *MemoryOrganizationRepository
+}
+
+// NewMemorySAMLConnectionRepository creates an empty in-memory SAML
+// connection repository
+func NewMemorySAMLConnectionRepository(organizations *MemoryOrganizationRepository) *MemorySAMLConnectionRepository {
+	return &MemorySAMLConnectionRepository{
+		connections:   make(map[int]*SAMLConnection),
+		organizations: organizations,
+	}
+}
+
+// SaveSAMLConnection creates or replaces an organization's connection
+func (r *MemorySAMLConnectionRepository) SaveSAMLConnection(ctx context.Context, conn *SAMLConnection) (*SAMLConnection, error) {
+	if conn == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "saml connection cannot be nil"}
+	}
+	if conn.IdPEntityID == "" || conn.IdPSSOURL == "" || conn.IdPCertificate == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "identity provider entity id, sso url and certificate are required"}
+	}
+	if _, err := r.organizations.GetOrganization(ctx, conn.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, domain := range conn.Domains {
+		if r.verifiedElsewhere(conn.OrganizationID, domain.Domain) {
+			return nil, ErrSAMLDomainTaken
+		}
+	}
+
+	now := time.Now()
+	stored := copySAMLConnection(conn)
+	stored.CreatedAt = now
+	if existing, ok := r.connections[conn.OrganizationID]; ok {
+		stored.CreatedAt = existing.CreatedAt
+	}
+	stored.UpdatedAt = now
+	r.connections[conn.OrganizationID] = stored
+
+	return copySAMLConnection(stored), nil
+}
+
+// VerifySAMLDomain marks a domain of an organization's connection as verified
+func (r *MemorySAMLConnectionRepository) VerifySAMLDomain(ctx context.Context, organizationID int, domain string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, ok := r.connections[organizationID]
+	if !ok {
+		return ErrSAMLDomainNotFound
+	}
+	for i := range conn.Domains {
+		if !strings.EqualFold(conn.Domains[i].Domain, domain) {
+			continue
+		}
+		if r.verifiedElsewhere(organizationID, domain) {
+			return ErrSAMLDomainTaken
+		}
+		if conn.Domains[i].VerifiedAt == nil {
+			now := time.Now()
+			conn.Domains[i].VerifiedAt = &now
+		}
+		return nil
+	}
+	return ErrSAMLDomainNotFound
+}
+
+// verifiedElsewhere reports whether an organization other than the given
+// one verified a domain. The caller must hold the lock.
+func (r *MemorySAMLConnectionRepository) verifiedElsewhere(organizationID int, domain string) bool {
+	for orgID, existing := range r.connections {
+		if orgID == organizationID {
+			continue
+		}
+		for _, taken := range existing.Domains {
+			if taken.Verified() && strings.EqualFold(domain, taken.Domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetSAMLConnection retrieves an organization's connection
+func (r *MemorySAMLConnectionRepository) GetSAMLConnection(ctx context.Context, organizationID int) (*SAMLConnection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	conn, ok := r.connections[organizationID]
+	if !ok {
+		return nil, ErrSAMLConnectionNotFound
+	}
+	return copySAMLConnection(conn), nil
+}
+
+// DeleteSAMLConnection deletes an organization's connection
+func (r *MemorySAMLConnectionRepository) DeleteSAMLConnection(ctx context.Context, organizationID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.connections[organizationID]; !ok {
+		return ErrSAMLConnectionNotFound
+	}
+	delete(r.connections, organizationID)
+	return nil
+}
+
+func copySAMLConnection(conn *SAMLConnection) *SAMLConnection {
+	c := *conn
+	c.Domains = make([]SAMLDomain, len(conn.Domains))
+	for i, domain := range conn.Domains {
+		c.Domains[i] = domain
+		c.Domains[i].VerifiedAt = copyTime(domain.VerifiedAt)
+	}
+	return &c
+}
+
+// MemorySAMLLoginRepository implements SAMLLoginRepository using in-memory storage
+type MemorySAMLLoginRepository struct {
+	mu         sync.Mutex
+	requests   map[string]*SAMLRequest
+	assertions map[[2]string]time.Time // Expiry by issuer and assertion ID
+	codes      map[string]*SAMLLoginCode
+}
+
+// NewMemorySAMLLoginRepository creates an empty in-memory SAML login repository
+func NewMemorySAMLLoginRepository() *MemorySAMLLoginRepository {
+	return &MemorySAMLLoginRepository{
+		requests:   make(map[string]*SAMLRequest),
+		assertions: make(map[[2]string]time.Time),
+		codes:      make(map[string]*SAMLLoginCode),
+	}
+}
+
+// CreateSAMLRequest stores a pending authentication request
+func (r *MemorySAMLLoginRepository) CreateSAMLRequest(ctx context.Context, request *SAMLRequest) error {
+	if request == nil || request.ID == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "saml request id is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.requests[request.ID]; exists {
+		return &DatabaseError{Type: "CONFLICT", Message: "saml request already exists"}
+	}
+
+	stored := *request
+	stored.CreatedAt = time.Now()
+	r.requests[request.ID] = &stored
+	return nil
+}
+
+// ConsumeSAMLRequest atomically retrieves and deletes a pending request
+func (r *MemorySAMLLoginRepository) ConsumeSAMLRequest(ctx context.Context, id string) (*SAMLRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request, ok := r.requests[id]
+	if !ok {
+		return nil, ErrSAMLRequestNotFound
+	}
+
+	delete(r.requests, id)
+	c := *request
+	return &c, nil
+}
+
+// RecordSAMLAssertion remembers an assertion until it expires
+func (r *MemorySAMLLoginRepository) RecordSAMLAssertion(ctx context.Context, issuer, assertionID string, expiresAt time.Time) error {
+	if issuer == "" || assertionID == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "issuer and assertion id are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := [2]string{issuer, assertionID}
+	if recordedUntil, ok := r.assertions[key]; ok && !recordedUntil.Before(time.Now()) {
+		return ErrSAMLAssertionReplayed
+	}
+	r.assertions[key] = expiresAt
+	return nil
+}
+
+// CreateSAMLLoginCode stores a new login code
+func (r *MemorySAMLLoginRepository) CreateSAMLLoginCode(ctx context.Context, code *SAMLLoginCode) error {
+	if code == nil || code.CodeHash == "" || code.UserID <= 0 {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "code hash and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.codes[code.CodeHash]; exists {
+		return &DatabaseError{Type: "CONFLICT", Message: "saml login code already exists"}
+	}
+
+	stored := *code
+	stored.CreatedAt = time.Now()
+	r.codes[code.CodeHash] = &stored
+	return nil
+}
+
+// ConsumeSAMLLoginCode atomically retrieves and deletes a login code
+func (r *MemorySAMLLoginRepository) ConsumeSAMLLoginCode(ctx context.Context, codeHash string) (*SAMLLoginCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[codeHash]
+	if !ok {
+		return nil, ErrSAMLLoginCodeNotFound
+	}
+
+	delete(r.codes, codeHash)
+	c := *code
+	return &c, nil
+}
+
+// DeleteExpiredSAMLLogins removes requests, assertions and codes that
+// expired before the given time
+func (r *MemorySAMLLoginRepository) DeleteExpiredSAMLLogins(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, request := range r.requests {
+		if request.ExpiresAt.Before(before) {
+			delete(r.requests, id)
+			deleted++
+		}
+	}
+	for key, expiresAt := range r.assertions {
+		if expiresAt.Before(before) {
+			delete(r.assertions, key)
+			deleted++
+		}
+	}
+	for hash, code := range r.codes {
+		if code.ExpiresAt.Before(before) {
+			delete(r.codes, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteUserCodes removes every login code issued for a user
+func (r *MemorySAMLLoginRepository) deleteUserCodes(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, code := range r.codes {
+		if code.UserID == userID {
+			delete(r.codes, hash)
+		}
+	}
+}
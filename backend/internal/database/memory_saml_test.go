@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemorySAMLConnectionRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	acme, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	globex, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Globex"}, alice.ID)
+
+	conn := &SAMLConnection{
+		OrganizationID: acme.ID,
+		IdPEntityID:    "https://idp.acme.com",
+		IdPSSOURL:      "https://idp.acme.com/sso",
+		IdPCertificate: "cert",
+		Domains:        []SAMLDomain{{Domain: "acme.com", VerificationToken: "token-1"}},
+	}
+	if _, err := db.SAMLConnections().GetSAMLConnection(ctx, acme.ID); !errors.Is(err, ErrSAMLConnectionNotFound) {
+		t.Errorf("Expected no connection yet, got %v", err)
+	}
+	if _, err := db.SAMLConnections().SaveSAMLConnection(ctx, &SAMLConnection{OrganizationID: acme.ID}); err == nil {
+		t.Error("Expected a connection without an identity provider to be rejected")
+	}
+	missing := *conn
+	missing.OrganizationID = 999
+	if _, err := db.SAMLConnections().SaveSAMLConnection(ctx, &missing); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Errorf("Expected ErrOrganizationNotFound, got %v", err)
+	}
+
+	saved, err := db.SAMLConnections().SaveSAMLConnection(ctx, conn)
+	if err != nil {
+		t.Fatalf("SaveSAMLConnection() error = %v", err)
+	}
+	created := saved.CreatedAt
+
+	conn.Domains = append(conn.Domains, SAMLDomain{Domain: "acme.io", VerificationToken: "token-2"})
+	conn.JITProvisioning = true
+	saved, err = db.SAMLConnections().SaveSAMLConnection(ctx, conn)
+	if err != nil {
+		t.Fatalf("SaveSAMLConnection() error = %v", err)
+	}
+	if !saved.CreatedAt.Equal(created) || !saved.JITProvisioning || len(saved.Domains) != 2 {
+		t.Errorf("Expected the connection to be replaced, got %+v", saved)
+	}
+
+	// Claims don't reserve a domain, verification does
+	taken := *conn
+	taken.OrganizationID = globex.ID
+	taken.Domains = []SAMLDomain{{Domain: "ACME.io", VerificationToken: "token-3"}}
+	if _, err := db.SAMLConnections().SaveSAMLConnection(ctx, &taken); err != nil {
+		t.Fatalf("Expected an unverified domain to be claimable twice, got %v", err)
+	}
+	if err := db.SAMLConnections().VerifySAMLDomain(ctx, acme.ID, "acme.org"); !errors.Is(err, ErrSAMLDomainNotFound) {
+		t.Errorf("Expected ErrSAMLDomainNotFound, got %v", err)
+	}
+	if err := db.SAMLConnections().VerifySAMLDomain(ctx, globex.ID, "acme.io"); err != nil {
+		t.Fatalf("VerifySAMLDomain() error = %v", err)
+	}
+	if err := db.SAMLConnections().VerifySAMLDomain(ctx, acme.ID, "acme.io"); !errors.Is(err, ErrSAMLDomainTaken) {
+		t.Errorf("Expected a domain to be verified once, got %v", err)
+	}
+	if _, err := db.SAMLConnections().SaveSAMLConnection(ctx, conn); !errors.Is(err, ErrSAMLDomainTaken) {
+		t.Errorf("Expected a domain verified elsewhere to be refused, got %v", err)
+	}
+	stored, _ := db.SAMLConnections().GetSAMLConnection(ctx, globex.ID)
+	if len(stored.Domains) != 1 || !stored.Domains[0].Verified() || stored.Domains[0].VerificationToken != "token-3" {
+		t.Errorf("Expected the verified domain to be stored, got %+v", stored.Domains)
+	}
+
+	if err := db.SAMLConnections().DeleteSAMLConnection(ctx, globex.ID); err != nil {
+		t.Fatalf("DeleteSAMLConnection() error = %v", err)
+	}
+	if err := db.SAMLConnections().DeleteSAMLConnection(ctx, globex.ID); !errors.Is(err, ErrSAMLConnectionNotFound) {
+		t.Errorf("Expected ErrSAMLConnectionNotFound, got %v", err)
+	}
+	if _, err := db.SAMLConnections().SaveSAMLConnection(ctx, conn); err != nil {
+		t.Errorf("Expected the domain to be free once its connection is deleted, got %v", err)
+	}
+}
+
+func TestMemorySAMLLoginRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	repo := db.SAMLLogins()
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := repo.CreateSAMLRequest(ctx, &SAMLRequest{OrganizationID: 1}); err == nil {
+		t.Error("Expected a request without an ID to be rejected")
+	}
+	repo.CreateSAMLRequest(ctx, &SAMLRequest{ID: "_req", OrganizationID: 1, ExpiresAt: now.Add(time.Minute)})
+	request, err := repo.ConsumeSAMLRequest(ctx, "_req")
+	if err != nil || request.OrganizationID != 1 {
+		t.Fatalf("ConsumeSAMLRequest() = %+v, %v", request, err)
+	}
+	if _, err := repo.ConsumeSAMLRequest(ctx, "_req"); !errors.Is(err, ErrSAMLRequestNotFound) {
+		t.Errorf("Expected a request to be consumed once, got %v", err)
+	}
+
+	if err := repo.RecordSAMLAssertion(ctx, "idp", "_a1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("RecordSAMLAssertion() error = %v", err)
+	}
+	if err := repo.RecordSAMLAssertion(ctx, "idp", "_a1", now.Add(time.Minute)); !errors.Is(err, ErrSAMLAssertionReplayed) {
+		t.Errorf("Expected ErrSAMLAssertionReplayed, got %v", err)
+	}
+	if err := repo.RecordSAMLAssertion(ctx, "other-idp", "_a1", now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected assertion IDs to be scoped to their issuer, got %v", err)
+	}
+	repo.RecordSAMLAssertion(ctx, "idp", "_old", now.Add(-time.Minute))
+	if err := repo.RecordSAMLAssertion(ctx, "idp", "_old", now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected an expired record not to count as a replay, got %v", err)
+	}
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	repo.CreateSAMLLoginCode(ctx, &SAMLLoginCode{CodeHash: "code", UserID: alice.ID, OrganizationID: 1, ExpiresAt: now.Add(time.Minute)})
+	code, err := repo.ConsumeSAMLLoginCode(ctx, "code")
+	if err != nil || code.UserID != alice.ID {
+		t.Fatalf("ConsumeSAMLLoginCode() = %+v, %v", code, err)
+	}
+	if _, err := repo.ConsumeSAMLLoginCode(ctx, "code"); !errors.Is(err, ErrSAMLLoginCodeNotFound) {
+		t.Errorf("Expected a code to be consumed once, got %v", err)
+	}
+
+	repo.CreateSAMLRequest(ctx, &SAMLRequest{ID: "_stale", ExpiresAt: now.Add(-time.Minute)})
+	repo.CreateSAMLLoginCode(ctx, &SAMLLoginCode{CodeHash: "stale", UserID: alice.ID, ExpiresAt: now.Add(-time.Minute)})
+	repo.CreateSAMLLoginCode(ctx, &SAMLLoginCode{CodeHash: "fresh", UserID: alice.ID, ExpiresAt: now.Add(time.Minute)})
+	if deleted, _ := repo.DeleteExpiredSAMLLogins(ctx, now); deleted != 2 {
+		t.Errorf("Expected the stale request and code to be deleted, deleted %d", deleted)
+	}
+
+	db.PurgeUser(ctx, alice.ID)
+	if _, err := repo.ConsumeSAMLLoginCode(ctx, "fresh"); !errors.Is(err, ErrSAMLLoginCodeNotFound) {
+		t.Errorf("Expected purging Alice to delete her codes, got %v", err)
+	}
+}
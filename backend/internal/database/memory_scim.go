@@ -0,0 +1,428 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySCIMTokenRepository implements SCIMTokenRepository using in-memory storage
+type MemorySCIMTokenRepository struct {
+	mu     sync.RWMutex
+	tokens map[int]*SCIMToken
+	nextID int
+}
+
+// NewMemorySCIMTokenRepository creates an empty in-memory SCIM token repository
+func NewMemorySCIMTokenRepository() *MemorySCIMTokenRepository {
+	return &MemorySCIMTokenRepository{
+		tokens: make(map[int]*SCIMToken),
+		nextID: 1,
+	}
+}
+
+// CreateSCIMToken stores a new token
+func (r *MemorySCIMTokenRepository) CreateSCIMToken(ctx context.Context, token *SCIMToken) (*SCIMToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim token cannot be nil"}
+	}
+	if token.TokenHash == "" || token.OrganizationID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "token hash and organization are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.tokens {
+		if existing.TokenHash == token.TokenHash {
+			return nil, &DatabaseError{Type: "CONFLICT", Message: "scim token already exists"}
+		}
+	}
+
+	stored := copySCIMToken(token)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	stored.LastUsedAt = nil
+	r.tokens[stored.ID] = stored
+	r.nextID++
+
+	return copySCIMToken(stored), nil
+}
+
+// GetSCIMTokenByHash retrieves a token by its hash
+func (r *MemorySCIMTokenRepository) GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			return copySCIMToken(token), nil
+		}
+	}
+	return nil, ErrSCIMTokenNotFound
+}
+
+// ListSCIMTokens retrieves an organization's tokens, oldest first
+func (r *MemorySCIMTokenRepository) ListSCIMTokens(ctx context.Context, organizationID int) ([]*SCIMToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tokens := []*SCIMToken{}
+	for id := 1; id < r.nextID; id++ {
+		if token, ok := r.tokens[id]; ok && token.OrganizationID == organizationID {
+			tokens = append(tokens, copySCIMToken(token))
+		}
+	}
+	return tokens, nil
+}
+
+// TouchSCIMToken records use of a token
+func (r *MemorySCIMTokenRepository) TouchSCIMToken(ctx context.Context, id int, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok {
+		return ErrSCIMTokenNotFound
+	}
+	token.LastUsedAt = &usedAt
+	return nil
+}
+
+// DeleteSCIMToken deletes one of an organization's tokens
+func (r *MemorySCIMTokenRepository) DeleteSCIMToken(ctx context.Context, organizationID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok || token.OrganizationID != organizationID {
+		return ErrSCIMTokenNotFound
+	}
+	delete(r.tokens, id)
+	return nil
+}
+
+func copySCIMToken(token *SCIMToken) *SCIMToken {
+	c := *token
+	if token.LastUsedAt != nil {
+		t := *token.LastUsedAt
+		c.LastUsedAt = &t
+	}
+	return &c
+}
+
+// MemorySCIMUserRepository implements SCIMUserRepository using in-memory storage
+type MemorySCIMUserRepository struct {
+	mu    sync.RWMutex
+	users map[int]*SCIMUser // By user ID, as a user is managed by one organization at most
+}
+
+// NewMemorySCIMUserRepository creates an empty in-memory SCIM user repository
+func NewMemorySCIMUserRepository() *MemorySCIMUserRepository {
+	return &MemorySCIMUserRepository{
+		users: make(map[int]*SCIMUser),
+	}
+}
+
+// CreateSCIMUser marks a user as managed by an organization
+func (r *MemorySCIMUserRepository) CreateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error) {
+	if scimUser == nil || scimUser.OrganizationID <= 0 || scimUser.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[scimUser.UserID]; exists {
+		return nil, ErrSCIMUserExists
+	}
+	if r.externalIDTaken(scimUser) {
+		return nil, ErrSCIMExternalIDTaken
+	}
+
+	stored := copySCIMUser(scimUser)
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	r.users[scimUser.UserID] = stored
+
+	return copySCIMUser(stored), nil
+}
+
+// GetSCIMUser retrieves a user managed by an organization
+func (r *MemorySCIMUserRepository) GetSCIMUser(ctx context.Context, organizationID, userID int) (*SCIMUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scimUser, ok := r.users[userID]
+	if !ok || scimUser.OrganizationID != organizationID {
+		return nil, ErrSCIMUserNotFound
+	}
+	return copySCIMUser(scimUser), nil
+}
+
+// ListSCIMUsers retrieves the users an organization manages, in the order
+// they were provisioned
+func (r *MemorySCIMUserRepository) ListSCIMUsers(ctx context.Context, organizationID int) ([]*SCIMUser, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []*SCIMUser{}
+	for _, scimUser := range r.users {
+		if scimUser.OrganizationID == organizationID {
+			users = append(users, copySCIMUser(scimUser))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].UserID < users[j].UserID
+	})
+	return users, nil
+}
+
+// UpdateSCIMUser changes a managed user's external ID and when they were
+// deactivated
+func (r *MemorySCIMUserRepository) UpdateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error) {
+	if scimUser == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim user cannot be nil"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[scimUser.UserID]
+	if !ok || stored.OrganizationID != scimUser.OrganizationID {
+		return nil, ErrSCIMUserNotFound
+	}
+	if r.externalIDTaken(scimUser) {
+		return nil, ErrSCIMExternalIDTaken
+	}
+
+	stored.ExternalID = scimUser.ExternalID
+	stored.DeactivatedAt = scimUser.DeactivatedAt
+	stored.UpdatedAt = time.Now()
+
+	return copySCIMUser(stored), nil
+}
+
+// DeleteSCIMUser stops an organization managing a user
+func (r *MemorySCIMUserRepository) DeleteSCIMUser(ctx context.Context, organizationID, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	scimUser, ok := r.users[userID]
+	if !ok || scimUser.OrganizationID != organizationID {
+		return ErrSCIMUserNotFound
+	}
+	delete(r.users, userID)
+	return nil
+}
+
+func copySCIMUser(scimUser *SCIMUser) *SCIMUser {
+	c := *scimUser
+	if scimUser.DeactivatedAt != nil {
+		t := *scimUser.DeactivatedAt
+		c.DeactivatedAt = &t
+	}
+	return &c
+}
+
+// externalIDTaken reports whether another of the organization's users has
+// the user's external ID. The caller must hold the lock.
+func (r *MemorySCIMUserRepository) externalIDTaken(scimUser *SCIMUser) bool {
+	if scimUser.ExternalID == "" {
+		return false
+	}
+	for _, existing := range r.users {
+		if existing.OrganizationID == scimUser.OrganizationID && existing.UserID != scimUser.UserID &&
+			existing.ExternalID == scimUser.ExternalID {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteUser forgets that a user is managed
+func (r *MemorySCIMUserRepository) deleteUser(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, userID)
+}
+
+// MemorySCIMGroupRepository implements SCIMGroupRepository using in-memory storage
+type MemorySCIMGroupRepository struct {
+	mu     sync.RWMutex
+	groups map[int]*SCIMGroup
+	nextID int
+}
+
+// NewMemorySCIMGroupRepository creates an empty in-memory SCIM group repository
+func NewMemorySCIMGroupRepository() *MemorySCIMGroupRepository {
+	return &MemorySCIMGroupRepository{
+		groups: make(map[int]*SCIMGroup),
+		nextID: 1,
+	}
+}
+
+// CreateSCIMGroup stores a new group with its members
+func (r *MemorySCIMGroupRepository) CreateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error) {
+	if group == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim group cannot be nil"}
+	}
+	if group.OrganizationID <= 0 || strings.TrimSpace(group.DisplayName) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization and display name are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.displayNameTaken(group) {
+		return nil, ErrSCIMGroupExists
+	}
+
+	stored := copySCIMGroup(group)
+	stored.ID = r.nextID
+	stored.MemberIDs = uniqueSortedIDs(group.MemberIDs)
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	r.groups[stored.ID] = stored
+	r.nextID++
+
+	return copySCIMGroup(stored), nil
+}
+
+// GetSCIMGroup retrieves one of an organization's groups
+func (r *MemorySCIMGroupRepository) GetSCIMGroup(ctx context.Context, organizationID, id int) (*SCIMGroup, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	group, ok := r.groups[id]
+	if !ok || group.OrganizationID != organizationID {
+		return nil, ErrSCIMGroupNotFound
+	}
+	return copySCIMGroup(group), nil
+}
+
+// ListSCIMGroups retrieves an organization's groups, oldest first
+func (r *MemorySCIMGroupRepository) ListSCIMGroups(ctx context.Context, organizationID int) ([]*SCIMGroup, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	groups := []*SCIMGroup{}
+	for id := 1; id < r.nextID; id++ {
+		if group, ok := r.groups[id]; ok && group.OrganizationID == organizationID {
+			groups = append(groups, copySCIMGroup(group))
+		}
+	}
+	return groups, nil
+}
+
+// UpdateSCIMGroup replaces a group's display name, external ID and members
+func (r *MemorySCIMGroupRepository) UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error) {
+	if group == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim group cannot be nil"}
+	}
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "display name is required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.groups[group.ID]
+	if !ok || stored.OrganizationID != group.OrganizationID {
+		return nil, ErrSCIMGroupNotFound
+	}
+	if r.displayNameTaken(group) {
+		return nil, ErrSCIMGroupExists
+	}
+
+	stored.DisplayName = group.DisplayName
+	stored.ExternalID = group.ExternalID
+	stored.MemberIDs = uniqueSortedIDs(group.MemberIDs)
+	stored.UpdatedAt = time.Now()
+
+	return copySCIMGroup(stored), nil
+}
+
+// DeleteSCIMGroup deletes one of an organization's groups
+func (r *MemorySCIMGroupRepository) DeleteSCIMGroup(ctx context.Context, organizationID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	group, ok := r.groups[id]
+	if !ok || group.OrganizationID != organizationID {
+		return ErrSCIMGroupNotFound
+	}
+	delete(r.groups, id)
+	return nil
+}
+
+// RemoveSCIMGroupMember takes a user out of every group of an organization
+func (r *MemorySCIMGroupRepository) RemoveSCIMGroupMember(ctx context.Context, organizationID, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, group := range r.groups {
+		if group.OrganizationID == organizationID {
+			r.removeMember(group, userID)
+		}
+	}
+	return nil
+}
+
+// displayNameTaken reports whether another of the organization's groups
+// has the group's display name. The caller must hold the lock.
+func (r *MemorySCIMGroupRepository) displayNameTaken(group *SCIMGroup) bool {
+	for _, existing := range r.groups {
+		if existing.OrganizationID == group.OrganizationID && existing.ID != group.ID &&
+			strings.EqualFold(existing.DisplayName, group.DisplayName) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeMember takes a user out of a group. The caller must hold the lock.
+func (r *MemorySCIMGroupRepository) removeMember(group *SCIMGroup, userID int) {
+	for i, id := range group.MemberIDs {
+		if id == userID {
+			group.MemberIDs = append(group.MemberIDs[:i:i], group.MemberIDs[i+1:]...)
+			group.UpdatedAt = time.Now()
+			return
+		}
+	}
+}
+
+// deleteUserMemberships takes a user out of every group
+func (r *MemorySCIMGroupRepository) deleteUserMemberships(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, group := range r.groups {
+		r.removeMember(group, userID)
+	}
+}
+
+func copySCIMGroup(group *SCIMGroup) *SCIMGroup {
+	c := *group
+	c.MemberIDs = append([]int{}, group.MemberIDs...)
+	return &c
+}
+
+// uniqueSortedIDs returns the IDs in ascending order without repeats
+func uniqueSortedIDs(ids []int) []int {
+	sorted := append([]int{}, ids...)
+	sort.Ints(sorted)
+	unique := []int{}
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
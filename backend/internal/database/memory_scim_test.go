@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemorySCIMTokenRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	acme, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	globex, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Globex"}, alice.ID)
+
+	if _, err := db.SCIMTokens().CreateSCIMToken(ctx, &SCIMToken{OrganizationID: acme.ID}); err == nil {
+		t.Error("Expected a token without a hash to be rejected")
+	}
+
+	first, err := db.SCIMTokens().CreateSCIMToken(ctx, &SCIMToken{OrganizationID: acme.ID, Name: "Okta", Prefix: "gsst_abc", TokenHash: "hash1", CreatedBy: alice.ID})
+	if err != nil {
+		t.Fatalf("CreateSCIMToken() error = %v", err)
+	}
+	db.SCIMTokens().CreateSCIMToken(ctx, &SCIMToken{OrganizationID: acme.ID, Name: "Azure", TokenHash: "hash2"})
+	db.SCIMTokens().CreateSCIMToken(ctx, &SCIMToken{OrganizationID: globex.ID, Name: "Okta", TokenHash: "hash3"})
+	if _, err := db.SCIMTokens().CreateSCIMToken(ctx, &SCIMToken{OrganizationID: acme.ID, TokenHash: "hash1"}); err == nil {
+		t.Error("Expected a duplicate hash to be rejected")
+	}
+
+	found, err := db.SCIMTokens().GetSCIMTokenByHash(ctx, "hash1")
+	if err != nil || found.ID != first.ID || found.OrganizationID != acme.ID || found.LastUsedAt != nil {
+		t.Fatalf("GetSCIMTokenByHash() = %+v, %v", found, err)
+	}
+	if _, err := db.SCIMTokens().GetSCIMTokenByHash(ctx, "unknown"); !errors.Is(err, ErrSCIMTokenNotFound) {
+		t.Errorf("Expected ErrSCIMTokenNotFound, got %v", err)
+	}
+
+	usedAt := time.Now()
+	if err := db.SCIMTokens().TouchSCIMToken(ctx, first.ID, usedAt); err != nil {
+		t.Fatalf("TouchSCIMToken() error = %v", err)
+	}
+	if found, _ := db.SCIMTokens().GetSCIMTokenByHash(ctx, "hash1"); found.LastUsedAt == nil || !found.LastUsedAt.Equal(usedAt) {
+		t.Errorf("Expected the token's use to be recorded, got %+v", found)
+	}
+
+	tokens, _ := db.SCIMTokens().ListSCIMTokens(ctx, acme.ID)
+	if len(tokens) != 2 || tokens[0].Name != "Okta" || tokens[1].Name != "Azure" {
+		t.Errorf("Expected Acme's tokens oldest first, got %+v", tokens)
+	}
+
+	if err := db.SCIMTokens().DeleteSCIMToken(ctx, globex.ID, first.ID); !errors.Is(err, ErrSCIMTokenNotFound) {
+		t.Errorf("Expected another organization's token to be out of reach, got %v", err)
+	}
+	if err := db.SCIMTokens().DeleteSCIMToken(ctx, acme.ID, first.ID); err != nil {
+		t.Fatalf("DeleteSCIMToken() error = %v", err)
+	}
+	if _, err := db.SCIMTokens().GetSCIMTokenByHash(ctx, "hash1"); !errors.Is(err, ErrSCIMTokenNotFound) {
+		t.Errorf("Expected the deleted token to be gone, got %v", err)
+	}
+}
+
+func TestMemorySCIMUserRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+	acme, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	globex, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Globex"}, alice.ID)
+
+	created, err := db.SCIMUsers().CreateSCIMUser(ctx, &SCIMUser{OrganizationID: acme.ID, UserID: alice.ID, ExternalID: "00u1"})
+	if err != nil {
+		t.Fatalf("CreateSCIMUser() error = %v", err)
+	}
+	if created.CreatedAt.IsZero() || created.DeactivatedAt != nil {
+		t.Errorf("Unexpected managed user %+v", created)
+	}
+	if _, err := db.SCIMUsers().CreateSCIMUser(ctx, &SCIMUser{OrganizationID: globex.ID, UserID: alice.ID}); !errors.Is(err, ErrSCIMUserExists) {
+		t.Errorf("Expected a user to be managed by one organization at most, got %v", err)
+	}
+	if _, err := db.SCIMUsers().CreateSCIMUser(ctx, &SCIMUser{OrganizationID: acme.ID, UserID: bob.ID, ExternalID: "00u1"}); !errors.Is(err, ErrSCIMExternalIDTaken) {
+		t.Errorf("Expected ErrSCIMExternalIDTaken, got %v", err)
+	}
+	if _, err := db.SCIMUsers().CreateSCIMUser(ctx, &SCIMUser{OrganizationID: acme.ID, UserID: bob.ID}); err != nil {
+		t.Fatalf("CreateSCIMUser() error = %v", err)
+	}
+
+	if _, err := db.SCIMUsers().GetSCIMUser(ctx, globex.ID, alice.ID); !errors.Is(err, ErrSCIMUserNotFound) {
+		t.Errorf("Expected ErrSCIMUserNotFound for another organization, got %v", err)
+	}
+
+	deactivatedAt := time.Now()
+	created.ExternalID = "00u9"
+	created.DeactivatedAt = &deactivatedAt
+	updated, err := db.SCIMUsers().UpdateSCIMUser(ctx, created)
+	if err != nil || updated.ExternalID != "00u9" || updated.DeactivatedAt == nil || !updated.DeactivatedAt.Equal(deactivatedAt) {
+		t.Errorf("UpdateSCIMUser() = %+v, %v", updated, err)
+	}
+	if _, err := db.SCIMUsers().UpdateSCIMUser(ctx, &SCIMUser{OrganizationID: acme.ID, UserID: bob.ID, ExternalID: "00u9"}); !errors.Is(err, ErrSCIMExternalIDTaken) {
+		t.Errorf("Expected ErrSCIMExternalIDTaken, got %v", err)
+	}
+
+	users, _ := db.SCIMUsers().ListSCIMUsers(ctx, acme.ID)
+	if len(users) != 2 || users[0].UserID != alice.ID || users[1].UserID != bob.ID {
+		t.Errorf("Expected Acme's users in provisioning order, got %+v", users)
+	}
+
+	if err := db.SCIMUsers().DeleteSCIMUser(ctx, acme.ID, bob.ID); err != nil {
+		t.Fatalf("DeleteSCIMUser() error = %v", err)
+	}
+	if err := db.SCIMUsers().DeleteSCIMUser(ctx, acme.ID, bob.ID); !errors.Is(err, ErrSCIMUserNotFound) {
+		t.Errorf("Expected ErrSCIMUserNotFound, got %v", err)
+	}
+
+	if err := db.PurgeUser(ctx, alice.ID); err != nil {
+		t.Fatalf("PurgeUser() error = %v", err)
+	}
+	if users, _ := db.SCIMUsers().ListSCIMUsers(ctx, acme.ID); len(users) != 0 {
+		t.Errorf("Expected purging to forget the managed user, got %+v", users)
+	}
+}
+
+func TestMemorySCIMGroupRepository(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice", Email: "alice@example.com"})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@example.com"})
+	acme, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, alice.ID)
+	globex, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Globex"}, alice.ID)
+
+	if _, err := db.SCIMGroups().CreateSCIMGroup(ctx, &SCIMGroup{OrganizationID: acme.ID}); err == nil {
+		t.Error("Expected a group without a display name to be rejected")
+	}
+
+	engineering, err := db.SCIMGroups().CreateSCIMGroup(ctx, &SCIMGroup{
+		OrganizationID: acme.ID,
+		DisplayName:    "Engineering",
+		MemberIDs:      []int{bob.ID, alice.ID, bob.ID},
+	})
+	if err != nil {
+		t.Fatalf("CreateSCIMGroup() error = %v", err)
+	}
+	if len(engineering.MemberIDs) != 2 || engineering.MemberIDs[0] != alice.ID || engineering.MemberIDs[1] != bob.ID {
+		t.Errorf("Expected members ascending without repeats, got %v", engineering.MemberIDs)
+	}
+
+	if _, err := db.SCIMGroups().CreateSCIMGroup(ctx, &SCIMGroup{OrganizationID: acme.ID, DisplayName: "ENGINEERING"}); !errors.Is(err, ErrSCIMGroupExists) {
+		t.Errorf("Expected ErrSCIMGroupExists, got %v", err)
+	}
+	if _, err := db.SCIMGroups().CreateSCIMGroup(ctx, &SCIMGroup{OrganizationID: globex.ID, DisplayName: "Engineering"}); err != nil {
+		t.Errorf("Expected another organization to use the same name, got %v", err)
+	}
+	sales, _ := db.SCIMGroups().CreateSCIMGroup(ctx, &SCIMGroup{OrganizationID: acme.ID, DisplayName: "Sales", MemberIDs: []int{bob.ID}})
+
+	if _, err := db.SCIMGroups().GetSCIMGroup(ctx, globex.ID, engineering.ID); !errors.Is(err, ErrSCIMGroupNotFound) {
+		t.Errorf("Expected ErrSCIMGroupNotFound for another organization, got %v", err)
+	}
+
+	engineering.DisplayName = "Sales"
+	if _, err := db.SCIMGroups().UpdateSCIMGroup(ctx, engineering); !errors.Is(err, ErrSCIMGroupExists) {
+		t.Errorf("Expected ErrSCIMGroupExists, got %v", err)
+	}
+	engineering.DisplayName = "Platform"
+	engineering.ExternalID = "grp1"
+	engineering.MemberIDs = []int{alice.ID}
+	updated, err := db.SCIMGroups().UpdateSCIMGroup(ctx, engineering)
+	if err != nil || updated.DisplayName != "Platform" || updated.ExternalID != "grp1" || len(updated.MemberIDs) != 1 {
+		t.Errorf("UpdateSCIMGroup() = %+v, %v", updated, err)
+	}
+
+	if err := db.SCIMGroups().RemoveSCIMGroupMember(ctx, acme.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveSCIMGroupMember() error = %v", err)
+	}
+	if group, _ := db.SCIMGroups().GetSCIMGroup(ctx, acme.ID, sales.ID); len(group.MemberIDs) != 0 {
+		t.Errorf("Expected Bob to be out of Sales, got %v", group.MemberIDs)
+	}
+
+	groups, _ := db.SCIMGroups().ListSCIMGroups(ctx, acme.ID)
+	if len(groups) != 2 || groups[0].ID != engineering.ID || groups[1].ID != sales.ID {
+		t.Errorf("Expected Acme's groups oldest first, got %+v", groups)
+	}
+
+	if err := db.PurgeUser(ctx, alice.ID); err != nil {
+		t.Fatalf("PurgeUser() error = %v", err)
+	}
+	if group, _ := db.SCIMGroups().GetSCIMGroup(ctx, acme.ID, engineering.ID); len(group.MemberIDs) != 0 {
+		t.Errorf("Expected purging to take Alice out of their groups, got %v", group.MemberIDs)
+	}
+
+	if err := db.SCIMGroups().DeleteSCIMGroup(ctx, acme.ID, sales.ID); err != nil {
+		t.Fatalf("DeleteSCIMGroup() error = %v", err)
+	}
+	if err := db.SCIMGroups().DeleteSCIMGroup(ctx, acme.ID, sales.ID); !errors.Is(err, ErrSCIMGroupNotFound) {
+		t.Errorf("Expected ErrSCIMGroupNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemorySessionRepository implements SessionRepository using in-memory storage
+type MemorySessionRepository struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionRepository creates an empty in-memory session repository
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// CreateSession stores a new session
+func (r *MemorySessionRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	if session == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "session cannot be nil"}
+	}
+	if session.ID == "" || session.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "session ID and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.sessions[session.ID]; exists {
+		return nil, &DatabaseError{Type: "CONFLICT", Message: "session already exists"}
+	}
+
+	now := time.Now()
+	newSession := copySession(session)
+	newSession.CreatedAt = now
+	newSession.LastSeenAt = now
+	newSession.RevokedAt = nil
+
+	r.sessions[newSession.ID] = newSession
+
+	return copySession(newSession), nil
+}
+
+// GetSession retrieves a session by its ID
+func (r *MemorySessionRepository) GetSession(ctx context.Context, id string) (*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
+	return copySession(session), nil
+}
+
+// ListUserSessions retrieves the sessions of a user that have not been revoked
+func (r *MemorySessionRepository) ListUserSessions(ctx context.Context, userID int) ([]*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			sessions = append(sessions, copySession(session))
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// TouchSession records activity on a session
+func (r *MemorySessionRepository) TouchSession(ctx context.Context, id string, seenAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	session.LastSeenAt = seenAt
+	return nil
+}
+
+// ExtendSession moves the expiry of a session
+func (r *MemorySessionRepository) ExtendSession(ctx context.Context, id string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// SetSessionOrganization records the organization a session works in
+func (r *MemorySessionRepository) SetSessionOrganization(ctx context.Context, id string, organizationID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	session.OrganizationID = organizationID
+	return nil
+}
+
+// RevokeSession revokes a single session
+func (r *MemorySessionRepository) RevokeSession(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	if session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user except exceptID
+func (r *MemorySessionRepository) RevokeUserSessions(ctx context.Context, userID int, exceptID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.ID != exceptID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// copySession creates a deep copy of a session
+func copySession(session *Session) *Session {
+	if session == nil {
+		return nil
+	}
+
+	c := *session
+	c.RevokedAt = copyTime(session.RevokedAt)
+	return &c
+}
+
+// deleteUserSessions removes every session of a user
+func (r *MemorySessionRepository) deleteUserSessions(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, session := range r.sessions {
+		if session.UserID == userID {
+			delete(r.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionRepository(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := repo.CreateSession(ctx, &Session{ID: id, UserID: 1, AuthMethod: "password", ExpiresAt: expires}); err != nil {
+			t.Fatalf("CreateSession(%s) error = %v", id, err)
+		}
+	}
+	repo.CreateSession(ctx, &Session{ID: "other", UserID: 2, ExpiresAt: expires})
+
+	if _, err := repo.CreateSession(ctx, &Session{ID: "a", UserID: 1}); err == nil {
+		t.Error("Expected duplicate session ID to be rejected")
+	}
+
+	session, err := repo.GetSession(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetSession() error = %v", err)
+	}
+	if session.CreatedAt.IsZero() || session.LastSeenAt.IsZero() || !session.Active(time.Now()) {
+		t.Errorf("Expected a fresh active session, got %+v", session)
+	}
+
+	if _, err := repo.GetSession(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := repo.TouchSession(ctx, "b", later); err != nil {
+		t.Fatalf("TouchSession() error = %v", err)
+	}
+
+	sessions, _ := repo.ListUserSessions(ctx, 1)
+	if len(sessions) != 3 || sessions[0].ID != "b" {
+		t.Errorf("Expected 3 sessions with the most recent first, got %+v", sessions)
+	}
+
+	if err := repo.RevokeSession(ctx, "a"); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if err := repo.RevokeUserSessions(ctx, 1, "b"); err != nil {
+		t.Fatalf("RevokeUserSessions() error = %v", err)
+	}
+
+	sessions, _ = repo.ListUserSessions(ctx, 1)
+	if len(sessions) != 1 || sessions[0].ID != "b" {
+		t.Errorf("Expected only session b to remain, got %+v", sessions)
+	}
+
+	if other, _ := repo.GetSession(ctx, "other"); other.RevokedAt != nil {
+		t.Error("Expected another user's session to be untouched")
+	}
+
+	if err := repo.ExtendSession(ctx, "b", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("ExtendSession() error = %v", err)
+	}
+	if session, _ := repo.GetSession(ctx, "b"); session.Active(time.Now()) {
+		t.Error("Expected session past its expiry to be inactive")
+	}
+}
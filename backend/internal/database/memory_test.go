@@ -2,7 +2,9 @@ package database
 
 import (
 	"context"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestMemoryUserRepository_CreateUser(t *testing.T) {
@@ -233,6 +235,172 @@ func TestMemoryUserRepository_UpdateUser(t *testing.T) {
 	}
 }
 
+func TestMemoryUserRepository_EmailVerifiedAt(t *testing.T) {
+	repo := &MemoryUserRepository{
+		users:        make(map[int]*User),
+		usersByEmail: make(map[string]*User),
+		nextID:       1,
+	}
+	ctx := context.Background()
+
+	user, err := repo.CreateUser(ctx, &User{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if user.EmailVerified() {
+		t.Fatal("Expected a new user to be unverified")
+	}
+
+	verifiedAt := time.Now()
+	user.EmailVerifiedAt = &verifiedAt
+	if _, err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	stored, _ := repo.GetUserByID(ctx, user.ID)
+	if !stored.EmailVerified() || !stored.EmailVerifiedAt.Equal(verifiedAt) {
+		t.Errorf("Expected verification time to be stored, got %v", stored.EmailVerifiedAt)
+	}
+}
+
+func TestMemoryUserRepository_ListUsersDueForDeletion(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	now := time.Now()
+
+	due, _ := db.Users().CreateUser(ctx, &User{Name: "Due", Email: "due@example.com"})
+	later, _ := db.Users().CreateUser(ctx, &User{Name: "Later", Email: "later@example.com"})
+	db.Users().CreateUser(ctx, &User{Name: "Kept", Email: "kept@example.com"})
+
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	due.DeletionScheduledAt = &past
+	later.DeletionScheduledAt = &future
+	db.Users().UpdateUser(ctx, due)
+	db.Users().UpdateUser(ctx, later)
+
+	users, err := db.Users().ListUsersDueForDeletion(ctx, now)
+	if err != nil {
+		t.Fatalf("ListUsersDueForDeletion() error = %v", err)
+	}
+	if len(users) != 1 || users[0].ID != due.ID {
+		t.Errorf("Expected only the due user, got %v", users)
+	}
+}
+
+func TestMemoryUserRepository_SearchUsers(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	now := time.Now()
+
+	alice, _ := db.Users().CreateUser(ctx, &User{Name: "Alice Smith", Email: "alice@example.com", EmailVerifiedAt: &now})
+	bob, _ := db.Users().CreateUser(ctx, &User{Name: "Bob", Email: "bob@smith.org"})
+	carol, _ := db.Users().CreateUser(ctx, &User{Name: "Carol", Email: "carol@example.com"})
+	carol.SuspendedAt = &now
+	db.Users().UpdateUser(ctx, carol)
+
+	verified, suspended := true, true
+	future := now.Add(time.Hour)
+	tests := []struct {
+		name     string
+		filter   UserFilter
+		expected []int
+	}{
+		{"everyone, newest first", UserFilter{}, []int{carol.ID, bob.ID, alice.ID}},
+		{"name or email", UserFilter{Query: "SMITH"}, []int{bob.ID, alice.ID}},
+		{"verified", UserFilter{Verified: &verified}, []int{alice.ID}},
+		{"suspended", UserFilter{Suspended: &suspended}, []int{carol.ID}},
+		{"created before", UserFilter{CreatedBefore: &alice.CreatedAt}, []int{}},
+		{"created after", UserFilter{CreatedAfter: &future}, []int{}},
+		{"page", UserFilter{Limit: 1, Offset: 1}, []int{bob.ID}},
+		{"past the end", UserFilter{Offset: 5}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := db.Users().SearchUsers(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("SearchUsers() error = %v", err)
+			}
+			ids := []int{}
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			if len(ids) != len(tt.expected) {
+				t.Fatalf("Expected users %v, got %v", tt.expected, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.expected[i] {
+					t.Fatalf("Expected users %v, got %v", tt.expected, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryDatabase_PurgeUser(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+
+	user, _ := db.Users().CreateUser(ctx, &User{Name: "John Doe", Email: "john@example.com"})
+	other, _ := db.Users().CreateUser(ctx, &User{Name: "Jane Doe", Email: "jane@example.com"})
+	db.Roles().CreateRole(ctx, &Role{Name: "admin"})
+	org, _ := db.Organizations().CreateOrganization(ctx, &Organization{Name: "Acme"}, other.ID)
+	db.Organizations().AddMember(ctx, org.ID, user.ID, OrgRoleMember)
+
+	for _, userID := range []int{user.ID, other.ID} {
+		db.Sessions().CreateSession(ctx, &Session{ID: "session-" + strconv.Itoa(userID), UserID: userID, ExpiresAt: time.Now().Add(time.Hour)})
+		db.RefreshTokens().CreateRefreshToken(ctx, &RefreshToken{UserID: userID, TokenHash: "hash-" + strconv.Itoa(userID), ExpiresAt: time.Now().Add(time.Hour)})
+		db.RecoveryCodes().ReplaceRecoveryCodes(ctx, userID, []string{"code"})
+		db.APIKeys().CreateAPIKey(ctx, &APIKey{UserID: userID, KeyHash: "key-" + strconv.Itoa(userID)})
+		db.PasswordHistory().AddPasswordHash(ctx, userID, "old", 5)
+		db.Roles().AssignRole(ctx, userID, "admin")
+	}
+
+	if err := db.PurgeUser(ctx, user.ID); err != nil {
+		t.Fatalf("PurgeUser() error = %v", err)
+	}
+
+	if _, err := db.Users().GetUserByID(ctx, user.ID); !isErrorType(err, ErrUserNotFound) {
+		t.Errorf("Expected the user to be gone, got %v", err)
+	}
+	if sessions, _ := db.Sessions().ListUserSessions(ctx, user.ID); len(sessions) != 0 {
+		t.Errorf("Expected sessions to be purged, got %d", len(sessions))
+	}
+	if _, err := db.RefreshTokens().GetRefreshTokenByHash(ctx, "hash-"+strconv.Itoa(user.ID)); err == nil {
+		t.Error("Expected refresh tokens to be purged")
+	}
+	if count, _ := db.RecoveryCodes().CountUnusedRecoveryCodes(ctx, user.ID); count != 0 {
+		t.Errorf("Expected recovery codes to be purged, got %d", count)
+	}
+	if keys, _ := db.APIKeys().ListUserAPIKeys(ctx, user.ID); len(keys) != 0 {
+		t.Errorf("Expected API keys to be purged, got %d", len(keys))
+	}
+	if hashes, _ := db.PasswordHistory().ListPasswordHashes(ctx, user.ID, 5); len(hashes) != 0 {
+		t.Errorf("Expected password history to be purged, got %d", len(hashes))
+	}
+	if roles, _ := db.Roles().ListUserRoles(ctx, user.ID); len(roles) != 0 {
+		t.Errorf("Expected role assignments to be purged, got %d", len(roles))
+	}
+	if orgs, _ := db.Organizations().ListUserOrganizations(ctx, user.ID); len(orgs) != 0 {
+		t.Errorf("Expected memberships to be purged, got %d", len(orgs))
+	}
+
+	// Nothing of the other user is touched
+	if sessions, _ := db.Sessions().ListUserSessions(ctx, other.ID); len(sessions) != 1 {
+		t.Errorf("Expected the other user's session to remain, got %d", len(sessions))
+	}
+	if keys, _ := db.APIKeys().ListUserAPIKeys(ctx, other.ID); len(keys) != 1 {
+		t.Errorf("Expected the other user's key to remain, got %d", len(keys))
+	}
+	if members, _ := db.Organizations().CountMembers(ctx, org.ID, ""); members != 1 {
+		t.Errorf("Expected the other user's organization to remain, got %d members", members)
+	}
+
+	if err := db.PurgeUser(ctx, user.ID); !isErrorType(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound purging twice, got %v", err)
+	}
+}
+
 func TestMemoryUserRepository_DeleteUser(t *testing.T) {
 	repo := &MemoryUserRepository{
 		users:        make(map[int]*User),
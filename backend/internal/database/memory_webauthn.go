@@ -0,0 +1,134 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryWebAuthnCredentialRepository implements WebAuthnCredentialRepository using in-memory storage
+type MemoryWebAuthnCredentialRepository struct {
+	mu          sync.RWMutex
+	credentials map[int]*WebAuthnCredential
+	nextID      int
+}
+
+// NewMemoryWebAuthnCredentialRepository creates an empty in-memory passkey repository
+func NewMemoryWebAuthnCredentialRepository() *MemoryWebAuthnCredentialRepository {
+	return &MemoryWebAuthnCredentialRepository{
+		credentials: make(map[int]*WebAuthnCredential),
+		nextID:      1,
+	}
+}
+
+// CreateWebAuthnCredential stores a new credential
+func (r *MemoryWebAuthnCredentialRepository) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) (*WebAuthnCredential, error) {
+	if credential == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "credential cannot be nil"}
+	}
+	if len(credential.CredentialID) == 0 || len(credential.PublicKey) == 0 || credential.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "credential ID, public key and user are required"}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return nil, ErrWebAuthnCredentialExists
+		}
+	}
+
+	stored := copyWebAuthnCredential(credential)
+	stored.ID = r.nextID
+	stored.CreatedAt = time.Now()
+	stored.LastUsedAt = nil
+	r.credentials[stored.ID] = stored
+	r.nextID++
+
+	return copyWebAuthnCredential(stored), nil
+}
+
+// GetWebAuthnCredential retrieves a credential by its authenticator-assigned ID
+func (r *MemoryWebAuthnCredentialRepository) GetWebAuthnCredential(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, credential := range r.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return copyWebAuthnCredential(credential), nil
+		}
+	}
+	return nil, ErrWebAuthnCredentialNotFound
+}
+
+// ListUserWebAuthnCredentials retrieves every credential registered to a user, oldest first
+func (r *MemoryWebAuthnCredentialRepository) ListUserWebAuthnCredentials(ctx context.Context, userID int) ([]*WebAuthnCredential, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	credentials := []*WebAuthnCredential{}
+	for id := 1; id < r.nextID; id++ {
+		if credential, ok := r.credentials[id]; ok && credential.UserID == userID {
+			credentials = append(credentials, copyWebAuthnCredential(credential))
+		}
+	}
+	return credentials, nil
+}
+
+// UpdateWebAuthnCredentialUsage records a successful assertion
+func (r *MemoryWebAuthnCredentialRepository) UpdateWebAuthnCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok {
+		return ErrWebAuthnCredentialNotFound
+	}
+
+	credential.SignCount = signCount
+	credential.BackupState = backupState
+	credential.LastUsedAt = &usedAt
+	return nil
+}
+
+// DeleteWebAuthnCredential removes one of a user's credentials
+func (r *MemoryWebAuthnCredentialRepository) DeleteWebAuthnCredential(ctx context.Context, userID, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[id]
+	if !ok || credential.UserID != userID {
+		return ErrWebAuthnCredentialNotFound
+	}
+
+	delete(r.credentials, id)
+	return nil
+}
+
+// copyWebAuthnCredential copies a credential, including its byte slices
+func copyWebAuthnCredential(credential *WebAuthnCredential) *WebAuthnCredential {
+	c := *credential
+	c.CredentialID = append([]byte(nil), credential.CredentialID...)
+	c.PublicKey = append([]byte(nil), credential.PublicKey...)
+	c.AAGUID = append([]byte(nil), credential.AAGUID...)
+	c.Transports = append([]string(nil), credential.Transports...)
+	if credential.LastUsedAt != nil {
+		lastUsedAt := *credential.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
+
+// deleteUserCredentials removes every passkey of a user
+func (r *MemoryWebAuthnCredentialRepository) deleteUserCredentials(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, credential := range r.credentials {
+		if credential.UserID == userID {
+			delete(r.credentials, id)
+		}
+	}
+}
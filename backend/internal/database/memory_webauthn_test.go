@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryWebAuthnCredentialRepository(t *testing.T) {
+	repo := NewMemoryWebAuthnCredentialRepository()
+	ctx := context.Background()
+
+	created, err := repo.CreateWebAuthnCredential(ctx, &WebAuthnCredential{
+		UserID:       1,
+		CredentialID: []byte("cred-1"),
+		PublicKey:    []byte("key"),
+		Transports:   []string{"internal"},
+		Name:         "Laptop",
+	})
+	if err != nil {
+		t.Fatalf("CreateWebAuthnCredential() error = %v", err)
+	}
+	repo.CreateWebAuthnCredential(ctx, &WebAuthnCredential{UserID: 2, CredentialID: []byte("cred-2"), PublicKey: []byte("key")})
+
+	_, err = repo.CreateWebAuthnCredential(ctx, &WebAuthnCredential{UserID: 2, CredentialID: []byte("cred-1"), PublicKey: []byte("key")})
+	if !errors.Is(err, ErrWebAuthnCredentialExists) {
+		t.Errorf("Expected duplicate credential ID to be rejected, got %v", err)
+	}
+
+	found, err := repo.GetWebAuthnCredential(ctx, []byte("cred-1"))
+	if err != nil || found.ID != created.ID || found.Name != "Laptop" {
+		t.Fatalf("GetWebAuthnCredential() = %+v, %v", found, err)
+	}
+
+	// Returned credentials are copies
+	found.CredentialID[0] = 'x'
+	if _, err := repo.GetWebAuthnCredential(ctx, []byte("cred-1")); err != nil {
+		t.Error("Expected stored credential to be unaffected by caller changes")
+	}
+
+	if err := repo.UpdateWebAuthnCredentialUsage(ctx, created.ID, 7, true, time.Now()); err != nil {
+		t.Fatalf("UpdateWebAuthnCredentialUsage() error = %v", err)
+	}
+
+	list, _ := repo.ListUserWebAuthnCredentials(ctx, 1)
+	if len(list) != 1 || list[0].SignCount != 7 || !list[0].BackupState || list[0].LastUsedAt == nil {
+		t.Errorf("Unexpected credentials after update: %+v", list)
+	}
+
+	if err := repo.DeleteWebAuthnCredential(ctx, 2, created.ID); !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		t.Errorf("Expected another user's credential to be untouchable, got %v", err)
+	}
+	if err := repo.DeleteWebAuthnCredential(ctx, 1, created.ID); err != nil {
+		t.Fatalf("DeleteWebAuthnCredential() error = %v", err)
+	}
+	if _, err := repo.GetWebAuthnCredential(ctx, []byte("cred-1")); !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		t.Errorf("Expected deleted credential to be gone, got %v", err)
+	}
+}
@@ -53,6 +53,623 @@ func GetMigrations() []Migration {
 				DROP TABLE IF EXISTS users;
 			`,
 		},
+		{
+			Version: 2,
+			Name:    "create_refresh_tokens_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS refresh_tokens (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					family_id VARCHAR(64) NOT NULL,
+					token_hash VARCHAR(64) UNIQUE NOT NULL,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					used_at TIMESTAMP WITH TIME ZONE,
+					revoked_at TIMESTAMP WITH TIME ZONE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					request_ip VARCHAR(45),
+					user_agent TEXT
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+				CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_refresh_tokens_expires_at;
+				DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+				DROP INDEX IF EXISTS idx_refresh_tokens_user_id;
+				DROP TABLE IF EXISTS refresh_tokens;
+			`,
+		},
+		{
+			Version: 3,
+			Name:    "create_sessions_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS sessions (
+					id VARCHAR(64) PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					user_agent TEXT,
+					ip_address VARCHAR(45),
+					auth_method VARCHAR(50) NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					revoked_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_sessions_user_id;
+				DROP TABLE IF EXISTS sessions;
+			`,
+		},
+		{
+			Version: 4,
+			Name:    "add_two_factor_authentication",
+			Up: `
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+				CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash VARCHAR(64) NOT NULL,
+					used_at TIMESTAMP WITH TIME ZONE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_mfa_recovery_codes_user_id;
+				DROP TABLE IF EXISTS mfa_recovery_codes;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+				ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+			`,
+		},
+		{
+			Version: 5,
+			Name:    "create_webauthn_credentials_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS webauthn_credentials (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					credential_id BYTEA NOT NULL UNIQUE,
+					public_key BYTEA NOT NULL,
+					algorithm INTEGER NOT NULL,
+					sign_count BIGINT NOT NULL DEFAULT 0,
+					aaguid BYTEA,
+					attestation_type VARCHAR(16) NOT NULL,
+					transports TEXT NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL DEFAULT '',
+					backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+					backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_webauthn_credentials_user_id;
+				DROP TABLE IF EXISTS webauthn_credentials;
+			`,
+		},
+		{
+			Version: 6,
+			Name:    "create_oidc_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS oidc_identities (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					provider VARCHAR(64) NOT NULL,
+					subject VARCHAR(255) NOT NULL,
+					email VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_login_at TIMESTAMP WITH TIME ZONE,
+					UNIQUE (provider, subject)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_oidc_identities_user_id ON oidc_identities(user_id);
+
+				CREATE TABLE IF NOT EXISTS oidc_login_states (
+					state_hash VARCHAR(64) PRIMARY KEY,
+					provider VARCHAR(64) NOT NULL,
+					nonce VARCHAR(255) NOT NULL,
+					code_verifier VARCHAR(255) NOT NULL,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_oidc_login_states_expires_at ON oidc_login_states(expires_at);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_oidc_login_states_expires_at;
+				DROP TABLE IF EXISTS oidc_login_states;
+				DROP INDEX IF EXISTS idx_oidc_identities_user_id;
+				DROP TABLE IF EXISTS oidc_identities;
+			`,
+		},
+		{
+			Version: 7,
+			Name:    "create_api_keys_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS api_keys (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					name VARCHAR(255) NOT NULL,
+					prefix VARCHAR(32) NOT NULL,
+					key_hash VARCHAR(64) NOT NULL UNIQUE,
+					scopes TEXT NOT NULL DEFAULT '',
+					expires_at TIMESTAMP WITH TIME ZONE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP WITH TIME ZONE,
+					revoked_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_api_keys_user_id;
+				DROP TABLE IF EXISTS api_keys;
+			`,
+		},
+		{
+			Version: 8,
+			Name:    "create_login_attempts_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS login_attempts (
+					key VARCHAR(320) PRIMARY KEY,
+					failures INTEGER NOT NULL DEFAULT 0,
+					last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					locked_until TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failure_at ON login_attempts(last_failure_at);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_login_attempts_last_failure_at;
+				DROP TABLE IF EXISTS login_attempts;
+			`,
+		},
+		{
+			Version: 9,
+			Name:    "create_email_tokens_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS email_tokens (
+					id SERIAL PRIMARY KEY,
+					token VARCHAR(64) NOT NULL,
+					user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					email VARCHAR(255) NOT NULL,
+					type VARCHAR(50) NOT NULL CHECK (type IN ('password_reset', 'email_verification', 'magic_link')),
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					used BOOLEAN DEFAULT FALSE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					request_ip VARCHAR(45),
+					user_agent TEXT
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_email_tokens_email_type ON email_tokens(email, type);
+				CREATE INDEX IF NOT EXISTS idx_email_tokens_token ON email_tokens(token);
+				CREATE INDEX IF NOT EXISTS idx_email_tokens_expires_at ON email_tokens(expires_at);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_email_tokens_expires_at;
+				DROP INDEX IF EXISTS idx_email_tokens_token;
+				DROP INDEX IF EXISTS idx_email_tokens_email_type;
+				DROP TABLE IF EXISTS email_tokens;
+			`,
+		},
+		{
+			Version: 10,
+			Name:    "add_users_email_verified_at",
+			Up: `
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+			`,
+			Down: `
+				ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
+			`,
+		},
+		{
+			Version: 11,
+			Name:    "create_password_history_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS password_history (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					password_hash TEXT NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_password_history_user_id ON password_history(user_id, id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_password_history_user_id;
+				DROP TABLE IF EXISTS password_history;
+			`,
+		},
+		{
+			Version: 12,
+			Name:    "add_users_deletion_scheduled_at",
+			Up: `
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+				CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users(deletion_scheduled_at)
+					WHERE deletion_scheduled_at IS NOT NULL;
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+				ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_at;
+			`,
+		},
+		{
+			Version: 13,
+			Name:    "add_email_change_token_types",
+			Up: `
+				ALTER TABLE email_tokens DROP CONSTRAINT IF EXISTS email_tokens_type_check;
+				ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_type_check
+					CHECK (type IN ('password_reset', 'email_verification', 'magic_link', 'email_change', 'email_change_cancel'));
+
+				CREATE INDEX IF NOT EXISTS idx_email_tokens_user_id_type ON email_tokens(user_id, type);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_email_tokens_user_id_type;
+				DELETE FROM email_tokens WHERE type IN ('email_change', 'email_change_cancel');
+
+				ALTER TABLE email_tokens DROP CONSTRAINT IF EXISTS email_tokens_type_check;
+				ALTER TABLE email_tokens ADD CONSTRAINT email_tokens_type_check
+					CHECK (type IN ('password_reset', 'email_verification', 'magic_link'));
+			`,
+		},
+		{
+			Version: 14,
+			Name:    "create_roles_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS roles (
+					id SERIAL PRIMARY KEY,
+					name VARCHAR(64) NOT NULL UNIQUE,
+					description TEXT NOT NULL DEFAULT '',
+					permissions TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS user_roles (
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, role_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_user_roles_role_id;
+				DROP TABLE IF EXISTS user_roles;
+				DROP TABLE IF EXISTS roles;
+			`,
+		},
+		{
+			Version: 15,
+			Name:    "add_users_admin_fields",
+			Up: `
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP WITH TIME ZONE;
+				ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+				CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_users_created_at;
+				ALTER TABLE users DROP COLUMN IF EXISTS password_reset_required;
+				ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
+			`,
+		},
+		{
+			Version: 16,
+			Name:    "create_audit_log_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS audit_log (
+					id SERIAL PRIMARY KEY,
+					actor_id INTEGER NOT NULL,
+					action VARCHAR(64) NOT NULL,
+					target_user_id INTEGER,
+					details TEXT NOT NULL DEFAULT '',
+					ip_address VARCHAR(45) NOT NULL DEFAULT '',
+					user_agent TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id);
+				CREATE INDEX IF NOT EXISTS idx_audit_log_target_user_id ON audit_log(target_user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_audit_log_target_user_id;
+				DROP INDEX IF EXISTS idx_audit_log_actor_id;
+				DROP TABLE IF EXISTS audit_log;
+			`,
+		},
+		{
+			Version: 17,
+			Name:    "add_sessions_impersonator_id",
+			Up: `
+				ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonator_id INTEGER;
+			`,
+			Down: `
+				ALTER TABLE sessions DROP COLUMN IF EXISTS impersonator_id;
+			`,
+		},
+		{
+			Version: 18,
+			Name:    "create_organizations_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS organizations (
+					id SERIAL PRIMARY KEY,
+					name VARCHAR(255) NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS organization_members (
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					role VARCHAR(20) NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (organization_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);
+
+				ALTER TABLE sessions ADD COLUMN IF NOT EXISTS organization_id INTEGER REFERENCES organizations(id) ON DELETE SET NULL;
+			`,
+			Down: `
+				ALTER TABLE sessions DROP COLUMN IF EXISTS organization_id;
+				DROP INDEX IF EXISTS idx_organization_members_user_id;
+				DROP TABLE IF EXISTS organization_members;
+				DROP TABLE IF EXISTS organizations;
+			`,
+		},
+		{
+			Version: 19,
+			Name:    "create_organization_invitations_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS organization_invitations (
+					id SERIAL PRIMARY KEY,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					email VARCHAR(255) NOT NULL,
+					role VARCHAR(20) NOT NULL,
+					token_hash VARCHAR(64) NOT NULL UNIQUE,
+					status VARCHAR(20) NOT NULL,
+					invited_by INTEGER,
+					accepted_by INTEGER,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					responded_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_organization_invitations_organization_id ON organization_invitations(organization_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_organization_invitations_organization_id;
+				DROP TABLE IF EXISTS organization_invitations;
+			`,
+		},
+		{
+			Version: 20,
+			Name:    "create_known_devices_table",
+			Up: `
+				CREATE TABLE IF NOT EXISTS known_devices (
+					id SERIAL PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					token_hash VARCHAR(64) NOT NULL,
+					fingerprint VARCHAR(64) NOT NULL,
+					user_agent TEXT NOT NULL DEFAULT '',
+					ip_address VARCHAR(45) NOT NULL DEFAULT '',
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_known_devices_user_id ON known_devices(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_known_devices_user_id;
+				DROP TABLE IF EXISTS known_devices;
+			`,
+		},
+		{
+			Version: 21,
+			Name:    "create_oauth_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS oauth_clients (
+					id SERIAL PRIMARY KEY,
+					client_id VARCHAR(64) NOT NULL UNIQUE,
+					secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+					name VARCHAR(255) NOT NULL,
+					redirect_uris TEXT NOT NULL DEFAULT '',
+					scopes TEXT NOT NULL DEFAULT '',
+					owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_oauth_clients_owner_id ON oauth_clients(owner_id);
+
+				CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+					code_hash VARCHAR(64) PRIMARY KEY,
+					client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					redirect_uri TEXT NOT NULL,
+					scopes TEXT NOT NULL DEFAULT '',
+					code_challenge VARCHAR(64) NOT NULL,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS oauth_tokens (
+					id SERIAL PRIMARY KEY,
+					client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					grant_type VARCHAR(32) NOT NULL,
+					scopes TEXT NOT NULL DEFAULT '',
+					access_token_hash VARCHAR(64) NOT NULL UNIQUE,
+					refresh_token_hash VARCHAR(64),
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					refresh_expires_at TIMESTAMP WITH TIME ZONE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					revoked_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_tokens_refresh_token_hash ON oauth_tokens(refresh_token_hash);
+				CREATE INDEX IF NOT EXISTS idx_oauth_tokens_user_id ON oauth_tokens(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_oauth_tokens_user_id;
+				DROP INDEX IF EXISTS idx_oauth_tokens_refresh_token_hash;
+				DROP TABLE IF EXISTS oauth_tokens;
+				DROP TABLE IF EXISTS oauth_authorization_codes;
+				DROP INDEX IF EXISTS idx_oauth_clients_owner_id;
+				DROP TABLE IF EXISTS oauth_clients;
+			`,
+		},
+		{
+			Version: 22,
+			Name:    "create_saml_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS saml_connections (
+					organization_id INTEGER PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+					idp_entity_id TEXT NOT NULL,
+					idp_sso_url TEXT NOT NULL,
+					idp_certificate TEXT NOT NULL,
+					email_attribute VARCHAR(255) NOT NULL DEFAULT '',
+					name_attribute VARCHAR(255) NOT NULL DEFAULT '',
+					allow_idp_initiated BOOLEAN NOT NULL DEFAULT FALSE,
+					jit_provisioning BOOLEAN NOT NULL DEFAULT FALSE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS saml_domains (
+					domain VARCHAR(255) PRIMARY KEY,
+					organization_id INTEGER NOT NULL REFERENCES saml_connections(organization_id) ON DELETE CASCADE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_saml_domains_organization_id ON saml_domains(organization_id);
+
+				CREATE TABLE IF NOT EXISTS saml_requests (
+					id VARCHAR(64) PRIMARY KEY,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE TABLE IF NOT EXISTS saml_assertions (
+					issuer TEXT NOT NULL,
+					assertion_id VARCHAR(255) NOT NULL,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					PRIMARY KEY (issuer, assertion_id)
+				);
+
+				CREATE TABLE IF NOT EXISTS saml_login_codes (
+					code_hash VARCHAR(64) PRIMARY KEY,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+			Down: `
+				DROP TABLE IF EXISTS saml_login_codes;
+				DROP TABLE IF EXISTS saml_assertions;
+				DROP TABLE IF EXISTS saml_requests;
+				DROP INDEX IF EXISTS idx_saml_domains_organization_id;
+				DROP TABLE IF EXISTS saml_domains;
+				DROP TABLE IF EXISTS saml_connections;
+			`,
+		},
+		{
+			Version: 23,
+			Name:    "create_scim_tables",
+			Up: `
+				CREATE TABLE IF NOT EXISTS scim_tokens (
+					id SERIAL PRIMARY KEY,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					name VARCHAR(64) NOT NULL,
+					prefix VARCHAR(32) NOT NULL,
+					token_hash VARCHAR(64) NOT NULL UNIQUE,
+					created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					last_used_at TIMESTAMP WITH TIME ZONE
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_scim_tokens_organization_id ON scim_tokens(organization_id);
+
+				CREATE TABLE IF NOT EXISTS scim_users (
+					user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					external_id VARCHAR(255) NOT NULL DEFAULT '',
+					deactivated_at TIMESTAMP WITH TIME ZONE,
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_scim_users_organization_id ON scim_users(organization_id);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_users_external_id ON scim_users(organization_id, external_id) WHERE external_id <> '';
+
+				CREATE TABLE IF NOT EXISTS scim_groups (
+					id SERIAL PRIMARY KEY,
+					organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+					display_name VARCHAR(255) NOT NULL,
+					external_id VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+					updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+				);
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_groups_display_name ON scim_groups(organization_id, LOWER(display_name));
+
+				CREATE TABLE IF NOT EXISTS scim_group_members (
+					group_id INTEGER NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					PRIMARY KEY (group_id, user_id)
+				);
+
+				CREATE INDEX IF NOT EXISTS idx_scim_group_members_user_id ON scim_group_members(user_id);
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_scim_group_members_user_id;
+				DROP TABLE IF EXISTS scim_group_members;
+				DROP INDEX IF EXISTS idx_scim_groups_display_name;
+				DROP TABLE IF EXISTS scim_groups;
+				DROP INDEX IF EXISTS idx_scim_users_external_id;
+				DROP INDEX IF EXISTS idx_scim_users_organization_id;
+				DROP TABLE IF EXISTS scim_users;
+				DROP INDEX IF EXISTS idx_scim_tokens_organization_id;
+				DROP TABLE IF EXISTS scim_tokens;
+			`,
+		},
+		{
+			Version: 24,
+			Name:    "verify_saml_domains",
+			Up: `
+				ALTER TABLE saml_domains ADD COLUMN IF NOT EXISTS verification_token VARCHAR(64) NOT NULL DEFAULT '';
+				ALTER TABLE saml_domains ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
+				UPDATE saml_domains SET verification_token = md5(random()::text || organization_id::text || domain)
+					WHERE verification_token = '';
+
+				-- Any organization can claim a domain, but only one can verify it
+				ALTER TABLE saml_domains DROP CONSTRAINT IF EXISTS saml_domains_pkey;
+				ALTER TABLE saml_domains ADD PRIMARY KEY (organization_id, domain);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_saml_domains_verified_domain ON saml_domains(domain) WHERE verified_at IS NOT NULL;
+			`,
+			Down: `
+				DROP INDEX IF EXISTS idx_saml_domains_verified_domain;
+				DELETE FROM saml_domains a USING saml_domains b
+					WHERE a.domain = b.domain AND a.organization_id <> b.organization_id
+					AND a.verified_at IS NULL AND (b.verified_at IS NOT NULL OR a.organization_id > b.organization_id);
+				ALTER TABLE saml_domains DROP CONSTRAINT IF EXISTS saml_domains_pkey;
+				ALTER TABLE saml_domains ADD PRIMARY KEY (domain);
+				ALTER TABLE saml_domains DROP COLUMN IF EXISTS verified_at;
+				ALTER TABLE saml_domains DROP COLUMN IF EXISTS verification_token;
+			`,
+		},
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,8 +13,30 @@ import (
 
 // PostgreSQLDatabase implements the Database interface using PostgreSQL
 type PostgreSQLDatabase struct {
-	db       *sql.DB
-	userRepo *PostgreSQLUserRepository
+	db               *sql.DB
+	userRepo         *PostgreSQLUserRepository
+	refreshTokenRepo *PostgreSQLRefreshTokenRepository
+	sessionRepo      *PostgreSQLSessionRepository
+	recoveryCodeRepo *PostgreSQLRecoveryCodeRepository
+	webAuthnRepo     *PostgreSQLWebAuthnCredentialRepository
+	oidcIdentityRepo *PostgreSQLOIDCIdentityRepository
+	oidcStateRepo    *PostgreSQLOIDCLoginStateRepository
+	apiKeyRepo       *PostgreSQLAPIKeyRepository
+	loginAttemptRepo *PostgreSQLLoginAttemptRepository
+	passwordHistory  *PostgreSQLPasswordHistoryRepository
+	roleRepo         *PostgreSQLRoleRepository
+	auditLogRepo     *PostgreSQLAuditLogRepository
+	organizationRepo *PostgreSQLOrganizationRepository
+	invitationRepo   *PostgreSQLInvitationRepository
+	knownDeviceRepo  *PostgreSQLKnownDeviceRepository
+	oauthClientRepo  *PostgreSQLOAuthClientRepository
+	oauthCodeRepo    *PostgreSQLOAuthAuthorizationCodeRepository
+	oauthTokenRepo   *PostgreSQLOAuthTokenRepository
+	samlConnRepo     *PostgreSQLSAMLConnectionRepository
+	samlLoginRepo    *PostgreSQLSAMLLoginRepository
+	scimTokenRepo    *PostgreSQLSCIMTokenRepository
+	scimUserRepo     *PostgreSQLSCIMUserRepository
+	scimGroupRepo    *PostgreSQLSCIMGroupRepository
 }
 
 // PostgreSQLUserRepository implements UserRepository interface using PostgreSQL
@@ -51,6 +74,72 @@ func NewPostgreSQLDatabase(dsn string) (*PostgreSQLDatabase, error) {
 		userRepo: &PostgreSQLUserRepository{
 			db: db,
 		},
+		refreshTokenRepo: &PostgreSQLRefreshTokenRepository{
+			db: db,
+		},
+		sessionRepo: &PostgreSQLSessionRepository{
+			db: db,
+		},
+		recoveryCodeRepo: &PostgreSQLRecoveryCodeRepository{
+			db: db,
+		},
+		webAuthnRepo: &PostgreSQLWebAuthnCredentialRepository{
+			db: db,
+		},
+		oidcIdentityRepo: &PostgreSQLOIDCIdentityRepository{
+			db: db,
+		},
+		oidcStateRepo: &PostgreSQLOIDCLoginStateRepository{
+			db: db,
+		},
+		apiKeyRepo: &PostgreSQLAPIKeyRepository{
+			db: db,
+		},
+		loginAttemptRepo: &PostgreSQLLoginAttemptRepository{
+			db: db,
+		},
+		passwordHistory: &PostgreSQLPasswordHistoryRepository{
+			db: db,
+		},
+		roleRepo: &PostgreSQLRoleRepository{
+			db: db,
+		},
+		auditLogRepo: &PostgreSQLAuditLogRepository{
+			db: db,
+		},
+		organizationRepo: &PostgreSQLOrganizationRepository{
+			db: db,
+		},
+		invitationRepo: &PostgreSQLInvitationRepository{
+			db: db,
+		},
+		knownDeviceRepo: &PostgreSQLKnownDeviceRepository{
+			db: db,
+		},
+		oauthClientRepo: &PostgreSQLOAuthClientRepository{
+			db: db,
+		},
+		oauthCodeRepo: &PostgreSQLOAuthAuthorizationCodeRepository{
+			db: db,
+		},
+		oauthTokenRepo: &PostgreSQLOAuthTokenRepository{
+			db: db,
+		},
+		samlConnRepo: &PostgreSQLSAMLConnectionRepository{
+			db: db,
+		},
+		samlLoginRepo: &PostgreSQLSAMLLoginRepository{
+			db: db,
+		},
+		scimTokenRepo: &PostgreSQLSCIMTokenRepository{
+			db: db,
+		},
+		scimUserRepo: &PostgreSQLSCIMUserRepository{
+			db: db,
+		},
+		scimGroupRepo: &PostgreSQLSCIMGroupRepository{
+			db: db,
+		},
 	}, nil
 }
 
@@ -59,6 +148,128 @@ func (db *PostgreSQLDatabase) Users() UserRepository {
 	return db.userRepo
 }
 
+// RefreshTokens returns the refresh token repository
+func (db *PostgreSQLDatabase) RefreshTokens() RefreshTokenRepository {
+	return db.refreshTokenRepo
+}
+
+// Sessions returns the session repository
+func (db *PostgreSQLDatabase) Sessions() SessionRepository {
+	return db.sessionRepo
+}
+
+// RecoveryCodes returns the two-factor recovery code repository
+func (db *PostgreSQLDatabase) RecoveryCodes() RecoveryCodeRepository {
+	return db.recoveryCodeRepo
+}
+
+// WebAuthnCredentials returns the passkey repository
+func (db *PostgreSQLDatabase) WebAuthnCredentials() WebAuthnCredentialRepository {
+	return db.webAuthnRepo
+}
+
+// OIDCIdentities returns the linked provider account repository
+func (db *PostgreSQLDatabase) OIDCIdentities() OIDCIdentityRepository {
+	return db.oidcIdentityRepo
+}
+
+// OIDCLoginStates returns the pending OIDC login repository
+func (db *PostgreSQLDatabase) OIDCLoginStates() OIDCLoginStateRepository {
+	return db.oidcStateRepo
+}
+
+// APIKeys returns the API key repository
+func (db *PostgreSQLDatabase) APIKeys() APIKeyRepository {
+	return db.apiKeyRepo
+}
+
+// LoginAttempts returns the failed login counter repository
+func (db *PostgreSQLDatabase) LoginAttempts() LoginAttemptRepository {
+	return db.loginAttemptRepo
+}
+
+// PasswordHistory returns the replaced password repository
+func (db *PostgreSQLDatabase) PasswordHistory() PasswordHistoryRepository {
+	return db.passwordHistory
+}
+
+// Roles returns the role repository
+func (db *PostgreSQLDatabase) Roles() RoleRepository {
+	return db.roleRepo
+}
+
+// AuditLog returns the audit trail repository
+func (db *PostgreSQLDatabase) AuditLog() AuditLogRepository {
+	return db.auditLogRepo
+}
+
+// Organizations returns the organization repository
+func (db *PostgreSQLDatabase) Organizations() OrganizationRepository {
+	return db.organizationRepo
+}
+
+// Invitations returns the organization invitation repository
+func (db *PostgreSQLDatabase) Invitations() InvitationRepository {
+	return db.invitationRepo
+}
+
+// KnownDevices returns the known device repository
+func (db *PostgreSQLDatabase) KnownDevices() KnownDeviceRepository {
+	return db.knownDeviceRepo
+}
+
+// OAuthClients returns the OAuth client repository
+func (db *PostgreSQLDatabase) OAuthClients() OAuthClientRepository {
+	return db.oauthClientRepo
+}
+
+// OAuthAuthorizationCodes returns the pending authorization code repository
+func (db *PostgreSQLDatabase) OAuthAuthorizationCodes() OAuthAuthorizationCodeRepository {
+	return db.oauthCodeRepo
+}
+
+// OAuthTokens returns the OAuth token repository
+func (db *PostgreSQLDatabase) OAuthTokens() OAuthTokenRepository {
+	return db.oauthTokenRepo
+}
+
+// SAMLConnections returns the SAML connection repository
+func (db *PostgreSQLDatabase) SAMLConnections() SAMLConnectionRepository {
+	return db.samlConnRepo
+}
+
+// SAMLLogins returns the repository of SAML logins in progress
+func (db *PostgreSQLDatabase) SAMLLogins() SAMLLoginRepository {
+	return db.samlLoginRepo
+}
+
+// SCIMTokens returns the SCIM token repository
+func (db *PostgreSQLDatabase) SCIMTokens() SCIMTokenRepository {
+	return db.scimTokenRepo
+}
+
+// SCIMUsers returns the repository of users managed through SCIM
+func (db *PostgreSQLDatabase) SCIMUsers() SCIMUserRepository {
+	return db.scimUserRepo
+}
+
+// SCIMGroups returns the SCIM group repository
+func (db *PostgreSQLDatabase) SCIMGroups() SCIMGroupRepository {
+	return db.scimGroupRepo
+}
+
+// PurgeUser deletes a user. Every table holding rows a user owns references
+// users with ON DELETE CASCADE, so those rows go with it.
+func (db *PostgreSQLDatabase) PurgeUser(ctx context.Context, userID int) error {
+	return db.userRepo.DeleteUser(ctx, userID)
+}
+
+// SQL returns the underlying connection pool for packages that keep their
+// own tables, such as the email token manager
+func (db *PostgreSQLDatabase) SQL() *sql.DB {
+	return db.db
+}
+
 // Close closes the database connection
 func (db *PostgreSQLDatabase) Close() error {
 	return db.db.Close()
@@ -69,6 +280,50 @@ func (db *PostgreSQLDatabase) Ping(ctx context.Context) error {
 	return db.db.PingContext(ctx)
 }
 
+// userColumns lists the users columns in the order scanUser reads them
+const userColumns = `id, name, email, password, created_at, updated_at, totp_secret, totp_enabled, totp_last_step, email_verified_at, deletion_scheduled_at, suspended_at, password_reset_required`
+
+// scanUser scans a user row selected with userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var user User
+	var totpSecret sql.NullString
+	var emailVerifiedAt sql.NullTime
+	var deletionScheduledAt sql.NullTime
+	var suspendedAt sql.NullTime
+
+	err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Password,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&totpSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastStep,
+		&emailVerifiedAt,
+		&deletionScheduledAt,
+		&suspendedAt,
+		&user.PasswordResetRequired,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = totpSecret.String
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	if deletionScheduledAt.Valid {
+		user.DeletionScheduledAt = &deletionScheduledAt.Time
+	}
+	if suspendedAt.Valid {
+		user.SuspendedAt = &suspendedAt.Time
+	}
+
+	return &user, nil
+}
+
 // CreateUser creates a new user and returns the created user
 func (r *PostgreSQLUserRepository) CreateUser(ctx context.Context, user *User) (*User, error) {
 	if user == nil {
@@ -87,20 +342,11 @@ func (r *PostgreSQLUserRepository) CreateUser(ctx context.Context, user *User) (
 	}
 
 	query := `
-		INSERT INTO users (name, email, password, created_at, updated_at)
-		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		RETURNING id, name, email, password, created_at, updated_at
-	`
+		INSERT INTO users (name, email, password, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING ` + userColumns
 
-	var createdUser User
-	err := r.db.QueryRowContext(ctx, query, name, email, user.Password).Scan(
-		&createdUser.ID,
-		&createdUser.Name,
-		&createdUser.Email,
-		&createdUser.Password,
-		&createdUser.CreatedAt,
-		&createdUser.UpdatedAt,
-	)
+	createdUser, err := scanUser(r.db.QueryRowContext(ctx, query, name, email, user.Password, user.EmailVerifiedAt))
 
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
@@ -113,26 +359,18 @@ func (r *PostgreSQLUserRepository) CreateUser(ctx context.Context, user *User) (
 		}
 	}
 
-	return &createdUser, nil
+	return createdUser, nil
 }
 
 // GetUserByID retrieves a user by their ID
 func (r *PostgreSQLUserRepository) GetUserByID(ctx context.Context, id int) (*User, error) {
 	query := `
-		SELECT id, name, email, password, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = $1
 	`
 
-	var user User
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -145,7 +383,7 @@ func (r *PostgreSQLUserRepository) GetUserByID(ctx context.Context, id int) (*Us
 		}
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetUserByEmail retrieves a user by their email address
@@ -153,20 +391,12 @@ func (r *PostgreSQLUserRepository) GetUserByEmail(ctx context.Context, email str
 	normalizedEmail := strings.ToLower(strings.TrimSpace(email))
 
 	query := `
-		SELECT id, name, email, password, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = $1
 	`
 
-	var user User
-	err := r.db.QueryRowContext(ctx, query, normalizedEmail).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.Password,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, normalizedEmail))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -179,7 +409,7 @@ func (r *PostgreSQLUserRepository) GetUserByEmail(ctx context.Context, email str
 		}
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // UpdateUser updates an existing user
@@ -201,20 +431,19 @@ func (r *PostgreSQLUserRepository) UpdateUser(ctx context.Context, user *User) (
 
 	query := `
 		UPDATE users
-		SET name = $2, email = $3, password = $4, updated_at = CURRENT_TIMESTAMP
+		SET name = $2, email = $3, password = $4,
+			totp_secret = $5, totp_enabled = $6, totp_last_step = $7,
+			email_verified_at = $8, deletion_scheduled_at = $9,
+			suspended_at = $10, password_reset_required = $11, updated_at = CURRENT_TIMESTAMP
 		WHERE id = $1
-		RETURNING id, name, email, password, created_at, updated_at
-	`
+		RETURNING ` + userColumns
 
-	var updatedUser User
-	err := r.db.QueryRowContext(ctx, query, user.ID, name, email, user.Password).Scan(
-		&updatedUser.ID,
-		&updatedUser.Name,
-		&updatedUser.Email,
-		&updatedUser.Password,
-		&updatedUser.CreatedAt,
-		&updatedUser.UpdatedAt,
-	)
+	updatedUser, err := scanUser(r.db.QueryRowContext(ctx, query,
+		user.ID, name, email, user.Password,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep,
+		user.EmailVerifiedAt, user.DeletionScheduledAt,
+		user.SuspendedAt, user.PasswordResetRequired,
+	))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -230,7 +459,7 @@ func (r *PostgreSQLUserRepository) UpdateUser(ctx context.Context, user *User) (
 		}
 	}
 
-	return &updatedUser, nil
+	return updatedUser, nil
 }
 
 // DeleteUser deletes a user by their ID
@@ -265,7 +494,7 @@ func (r *PostgreSQLUserRepository) DeleteUser(ctx context.Context, id int) error
 // ListUsers retrieves all users (with optional pagination)
 func (r *PostgreSQLUserRepository) ListUsers(ctx context.Context, limit, offset int) ([]*User, error) {
 	query := `
-		SELECT id, name, email, password, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		ORDER BY created_at DESC
 	`
@@ -295,15 +524,7 @@ func (r *PostgreSQLUserRepository) ListUsers(ctx context.Context, limit, offset
 
 	var users []*User
 	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Name,
-			&user.Email,
-			&user.Password,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, &DatabaseError{
 				Type:    "DATABASE_ERROR",
@@ -311,7 +532,7 @@ func (r *PostgreSQLUserRepository) ListUsers(ctx context.Context, limit, offset
 				Err:     err,
 			}
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -325,6 +546,134 @@ func (r *PostgreSQLUserRepository) ListUsers(ctx context.Context, limit, offset
 	return users, nil
 }
 
+// ListUsersDueForDeletion retrieves the users whose scheduled deletion is at or before the given time
+func (r *PostgreSQLUserRepository) ListUsersDueForDeletion(ctx context.Context, before time.Time) ([]*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1
+		ORDER BY deletion_scheduled_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list users due for deletion",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan user row",
+				Err:     err,
+			}
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating user rows",
+			Err:     err,
+		}
+	}
+
+	return users, nil
+}
+
+// SearchUsers retrieves the users matching a filter, newest first
+func (r *PostgreSQLUserRepository) SearchUsers(ctx context.Context, filter UserFilter) ([]*User, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if query := strings.TrimSpace(filter.Query); query != "" {
+		pattern := arg("%" + escapeLike(query) + "%")
+		conditions = append(conditions, "(email ILIKE "+pattern+" OR name ILIKE "+pattern+")")
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*filter.CreatedAfter))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*filter.CreatedBefore))
+	}
+	if filter.Verified != nil {
+		if *filter.Verified {
+			conditions = append(conditions, "email_verified_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "email_verified_at IS NULL")
+		}
+	}
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			conditions = append(conditions, "suspended_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "suspended_at IS NULL")
+		}
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += " OFFSET " + arg(filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to search users",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan user row",
+				Err:     err,
+			}
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating user rows",
+			Err:     err,
+		}
+	}
+
+	return users, nil
+}
+
+// escapeLike escapes the characters LIKE treats as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 // Close closes any database connections (no-op for PostgreSQL user repository)
 func (r *PostgreSQLUserRepository) Close() error {
 	return nil
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLAPIKeyRepository implements APIKeyRepository using PostgreSQL
+type PostgreSQLAPIKeyRepository struct {
+	db *sql.DB
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, created_at, last_used_at, revoked_at`
+
+// CreateAPIKey stores a new key
+func (r *PostgreSQLAPIKeyRepository) CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, error) {
+	if key == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "api key cannot be nil"}
+	}
+	if key.KeyHash == "" || key.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "key hash and user are required"}
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+
+	created, err := scanAPIKey(r.db.QueryRowContext(ctx, query,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, ","), key.ExpiresAt,
+	))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create api key",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetAPIKeyByHash retrieves a key by the hash of its secret
+func (r *PostgreSQLAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get api key",
+			Err:     err,
+		}
+	}
+
+	return key, nil
+}
+
+// ListUserAPIKeys retrieves the keys of a user that have not been revoked, oldest first
+func (r *PostgreSQLAPIKeyRepository) ListUserAPIKeys(ctx context.Context, userID int) ([]*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list api keys",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan api key row",
+				Err:     err,
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating api key rows",
+			Err:     err,
+		}
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey records use of a key
+func (r *PostgreSQLAPIKeyRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, usedAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update api key",
+			Err:     err,
+		}
+	}
+
+	return requireAPIKeyRow(result)
+}
+
+// RevokeAPIKey revokes one of a user's keys
+func (r *PostgreSQLAPIKeyRepository) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke api key",
+			Err:     err,
+		}
+	}
+
+	return requireAPIKeyRow(result)
+}
+
+// requireAPIKeyRow maps an update that touched no rows to ErrAPIKeyNotFound
+func requireAPIKeyRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		&scopes,
+		&expiresAt,
+		&key.CreatedAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// PostgreSQLAuditLogRepository implements AuditLogRepository using PostgreSQL
+type PostgreSQLAuditLogRepository struct {
+	db *sql.DB
+}
+
+const auditColumns = `id, actor_id, action, target_user_id, details, ip_address, user_agent, created_at`
+
+// CreateAuditEntry records an entry
+func (r *PostgreSQLAuditLogRepository) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error) {
+	if entry == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "audit entry cannot be nil"}
+	}
+	if entry.Action == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "audit action is required"}
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, action, target_user_id, details, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + auditColumns
+
+	created, err := scanAuditEntry(r.db.QueryRowContext(ctx, query,
+		entry.ActorID, entry.Action, nullID(entry.TargetUserID), entry.Details, entry.IPAddress, entry.UserAgent,
+	))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create audit entry",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// ListAuditEntries retrieves the entries matching a filter, newest first
+func (r *PostgreSQLAuditLogRepository) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.ActorID != 0 {
+		conditions = append(conditions, "actor_id = "+arg(filter.ActorID))
+	}
+	if filter.TargetUserID != 0 {
+		conditions = append(conditions, "target_user_id = "+arg(filter.TargetUserID))
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = "+arg(filter.Action))
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += " OFFSET " + arg(filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list audit entries",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan audit entry row",
+				Err:     err,
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating audit entry rows",
+			Err:     err,
+		}
+	}
+
+	return entries, nil
+}
+
+// scanAuditEntry scans an audit_log row selected with auditColumns
+func scanAuditEntry(row interface{ Scan(...interface{}) error }) (*AuditEntry, error) {
+	var entry AuditEntry
+	var targetUserID sql.NullInt64
+
+	err := row.Scan(
+		&entry.ID,
+		&entry.ActorID,
+		&entry.Action,
+		&targetUserID,
+		&entry.Details,
+		&entry.IPAddress,
+		&entry.UserAgent,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.TargetUserID = int(targetUserID.Int64)
+	return &entry, nil
+}
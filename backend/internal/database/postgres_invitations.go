@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLInvitationRepository implements InvitationRepository using PostgreSQL
+type PostgreSQLInvitationRepository struct {
+	db *sql.DB
+}
+
+const invitationColumns = `id, organization_id, email, role, token_hash, status, invited_by, accepted_by, expires_at, created_at, responded_at`
+
+// CreateInvitation stores a new pending invitation and revokes any still
+// pending for the same address and organization
+func (r *PostgreSQLInvitationRepository) CreateInvitation(ctx context.Context, invitation *Invitation) (*Invitation, error) {
+	if invitation == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "invitation cannot be nil"}
+	}
+	if invitation.TokenHash == "" || strings.TrimSpace(invitation.Email) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "invitation email and token hash are required"}
+	}
+	if !ValidOrgRole(invitation.Role) {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE organization_invitations SET status = $1, responded_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $2 AND LOWER(email) = LOWER($3) AND status = $4`,
+		InvitationRevoked, invitation.OrganizationID, invitation.Email, InvitationPending)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to replace pending invitation",
+			Err:     err,
+		}
+	}
+
+	created, err := scanInvitation(tx.QueryRowContext(ctx, `
+		INSERT INTO organization_invitations (organization_id, email, role, token_hash, status, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+invitationColumns,
+		invitation.OrganizationID, invitation.Email, invitation.Role, invitation.TokenHash,
+		InvitationPending, nullID(invitation.InvitedBy), invitation.ExpiresAt))
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create invitation",
+			Err:     err,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit invitation",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetInvitation retrieves an invitation by its ID
+func (r *PostgreSQLInvitationRepository) GetInvitation(ctx context.Context, id int) (*Invitation, error) {
+	return r.get(ctx, `SELECT `+invitationColumns+` FROM organization_invitations WHERE id = $1`, id)
+}
+
+// GetInvitationByTokenHash retrieves an invitation by the hash of its token
+func (r *PostgreSQLInvitationRepository) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*Invitation, error) {
+	return r.get(ctx, `SELECT `+invitationColumns+` FROM organization_invitations WHERE token_hash = $1`, tokenHash)
+}
+
+// ListOrganizationInvitations retrieves an organization's invitations,
+// newest first
+func (r *PostgreSQLInvitationRepository) ListOrganizationInvitations(ctx context.Context, organizationID int) ([]*Invitation, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+invitationColumns+` FROM organization_invitations
+		WHERE organization_id = $1
+		ORDER BY created_at DESC, id DESC`, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list invitations",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan invitation row",
+				Err:     err,
+			}
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to iterate invitation rows",
+			Err:     err,
+		}
+	}
+
+	return invitations, nil
+}
+
+// AcceptInvitation marks a pending invitation accepted by userID and adds
+// them to its organization in one transaction
+func (r *PostgreSQLInvitationRepository) AcceptInvitation(ctx context.Context, id, userID int) (*Membership, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	// Claim the invitation in the same statement that checks it is pending,
+	// so it can only be accepted once
+	var organizationID int
+	var role string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE organization_invitations
+		SET status = $1, accepted_by = $2, responded_at = CURRENT_TIMESTAMP
+		WHERE id = $3 AND status = $4 AND expires_at > $5
+		RETURNING organization_id, role`,
+		InvitationAccepted, userID, id, InvitationPending, time.Now()).Scan(&organizationID, &role)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM organization_invitations WHERE id = $1)`, id).Scan(&exists); err == nil && !exists {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, ErrInvitationNotPending
+	}
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to accept invitation",
+			Err:     err,
+		}
+	}
+
+	// A user who already belongs to the organization keeps their role
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO NOTHING`, organizationID, userID, role)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrUserNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to add organization member",
+			Err:     err,
+		}
+	}
+
+	membership, err := scanMembership(tx.QueryRowContext(ctx, `
+		SELECT `+memberColumns+` FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`, organizationID, userID))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get organization member",
+			Err:     err,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit invitation",
+			Err:     err,
+		}
+	}
+
+	return membership, nil
+}
+
+// RevokeInvitation revokes a pending invitation
+func (r *PostgreSQLInvitationRepository) RevokeInvitation(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE organization_invitations SET status = $1, responded_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND status = $3`, InvitationRevoked, id, InvitationPending)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke invitation",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		if _, err := r.GetInvitation(ctx, id); err != nil {
+			return err
+		}
+		return ErrInvitationNotPending
+	}
+
+	return nil
+}
+
+// get retrieves the single invitation a query selects
+func (r *PostgreSQLInvitationRepository) get(ctx context.Context, query string, args ...interface{}) (*Invitation, error) {
+	invitation, err := scanInvitation(r.db.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get invitation",
+			Err:     err,
+		}
+	}
+	return invitation, nil
+}
+
+// scanInvitation scans a row selected with invitationColumns
+func scanInvitation(row interface{ Scan(...interface{}) error }) (*Invitation, error) {
+	var invitation Invitation
+	var invitedBy, acceptedBy sql.NullInt64
+	var respondedAt sql.NullTime
+	err := row.Scan(&invitation.ID, &invitation.OrganizationID, &invitation.Email, &invitation.Role,
+		&invitation.TokenHash, &invitation.Status, &invitedBy, &acceptedBy,
+		&invitation.ExpiresAt, &invitation.CreatedAt, &respondedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.InvitedBy = int(invitedBy.Int64)
+	invitation.AcceptedBy = int(acceptedBy.Int64)
+	if respondedAt.Valid {
+		invitation.RespondedAt = &respondedAt.Time
+	}
+	return &invitation, nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// PostgreSQLKnownDeviceRepository implements KnownDeviceRepository using PostgreSQL
+type PostgreSQLKnownDeviceRepository struct {
+	db *sql.DB
+}
+
+const knownDeviceColumns = `id, user_id, token_hash, fingerprint, user_agent, ip_address, created_at, last_seen_at`
+
+// CreateKnownDevice stores a device a user signed in from
+func (r *PostgreSQLKnownDeviceRepository) CreateKnownDevice(ctx context.Context, device *KnownDevice) (*KnownDevice, error) {
+	if device == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "device cannot be nil"}
+	}
+	if device.UserID <= 0 || device.TokenHash == "" || device.Fingerprint == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "device user, token hash and fingerprint are required"}
+	}
+
+	created, err := scanKnownDevice(r.db.QueryRowContext(ctx, `
+		INSERT INTO known_devices (user_id, token_hash, fingerprint, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+knownDeviceColumns,
+		device.UserID, device.TokenHash, device.Fingerprint, device.UserAgent, device.IPAddress))
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrUserNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create known device",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// FindKnownDevice retrieves the user's device with the cookie hash, or
+// failing that the most recently seen one with the fingerprint
+func (r *PostgreSQLKnownDeviceRepository) FindKnownDevice(ctx context.Context, userID int, tokenHash, fingerprint string) (*KnownDevice, error) {
+	device, err := scanKnownDevice(r.db.QueryRowContext(ctx, `
+		SELECT `+knownDeviceColumns+` FROM known_devices
+		WHERE user_id = $1 AND ((token_hash = $2 AND $2 <> '') OR fingerprint = $3)
+		ORDER BY token_hash = $2 DESC, last_seen_at DESC
+		LIMIT 1`, userID, tokenHash, fingerprint))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrKnownDeviceNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to find known device",
+			Err:     err,
+		}
+	}
+
+	return device, nil
+}
+
+// CountKnownDevices counts the devices a user has signed in from
+func (r *PostgreSQLKnownDeviceRepository) CountKnownDevices(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM known_devices WHERE user_id = $1`, userID).Scan(&count)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to count known devices",
+			Err:     err,
+		}
+	}
+	return count, nil
+}
+
+// UpdateKnownDevice records a new sign-in from a device
+func (r *PostgreSQLKnownDeviceRepository) UpdateKnownDevice(ctx context.Context, device *KnownDevice) error {
+	if device == nil {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "device cannot be nil"}
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE known_devices
+		SET token_hash = $1, fingerprint = $2, user_agent = $3, ip_address = $4, last_seen_at = $5
+		WHERE id = $6`,
+		device.TokenHash, device.Fingerprint, device.UserAgent, device.IPAddress, device.LastSeenAt, device.ID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update known device",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrKnownDeviceNotFound
+	}
+
+	return nil
+}
+
+// scanKnownDevice scans a row selected with knownDeviceColumns
+func scanKnownDevice(row interface{ Scan(...interface{}) error }) (*KnownDevice, error) {
+	var device KnownDevice
+	err := row.Scan(&device.ID, &device.UserID, &device.TokenHash, &device.Fingerprint,
+		&device.UserAgent, &device.IPAddress, &device.CreatedAt, &device.LastSeenAt)
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
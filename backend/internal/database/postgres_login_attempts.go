@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgreSQLLoginAttemptRepository implements LoginAttemptRepository using PostgreSQL
+type PostgreSQLLoginAttemptRepository struct {
+	db *sql.DB
+}
+
+// GetLoginAttempts retrieves the counter for a key
+func (r *PostgreSQLLoginAttemptRepository) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	attempts, err := scanLoginAttempts(r.db.QueryRowContext(ctx, `
+		SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1`, key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLoginAttemptsNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get login attempts",
+			Err:     err,
+		}
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure atomically counts a failure against a key
+func (r *PostgreSQLLoginAttemptRepository) RecordLoginFailure(ctx context.Context, key string, at, resetBefore time.Time) (*LoginAttempts, error) {
+	if key == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "key is required"}
+	}
+
+	attempts, err := scanLoginAttempts(r.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			locked_until = CASE WHEN login_attempts.last_failure_at < $3 THEN NULL ELSE login_attempts.locked_until END,
+			last_failure_at = $2
+		RETURNING key, failures, last_failure_at, locked_until`, key, at, resetBefore))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to record login failure",
+			Err:     err,
+		}
+	}
+
+	return attempts, nil
+}
+
+// LockLogin locks a key until the given time
+func (r *PostgreSQLLoginAttemptRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`, key, until)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to lock login",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrLoginAttemptsNotFound
+	}
+	return nil
+}
+
+// ClearLoginAttempts forgets the failures and any lock of a key
+func (r *PostgreSQLLoginAttemptRepository) ClearLoginAttempts(ctx context.Context, key string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, key); err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to clear login attempts",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// DeleteStaleLoginAttempts removes counters whose last failure and lock both ended before the given time
+func (r *PostgreSQLLoginAttemptRepository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until <= $1)`, before)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete stale login attempts",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	return int(rowsAffected), nil
+}
+
+// scanLoginAttempts scans a key, failures, last_failure_at, locked_until row
+func scanLoginAttempts(row interface{ Scan(...interface{}) error }) (*LoginAttempts, error) {
+	var attempts LoginAttempts
+	var lockedUntil sql.NullTime
+
+	if err := row.Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailureAt, &lockedUntil); err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		attempts.LockedUntil = &lockedUntil.Time
+	}
+	return &attempts, nil
+}
@@ -0,0 +1,388 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLOAuthClientRepository implements OAuthClientRepository using PostgreSQL
+type PostgreSQLOAuthClientRepository struct {
+	db *sql.DB
+}
+
+const oauthClientColumns = `id, client_id, secret_hash, name, redirect_uris, scopes, owner_id, created_at`
+
+// CreateOAuthClient stores a new client. Redirect URIs may contain commas,
+// so they are stored one per line.
+func (r *PostgreSQLOAuthClientRepository) CreateOAuthClient(ctx context.Context, client *OAuthClient) (*OAuthClient, error) {
+	if client == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "oauth client cannot be nil"}
+	}
+	if client.ClientID == "" || client.OwnerID <= 0 || strings.TrimSpace(client.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "client id, name and owner are required"}
+	}
+
+	query := `
+		INSERT INTO oauth_clients (client_id, secret_hash, name, redirect_uris, scopes, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + oauthClientColumns
+
+	created, err := scanOAuthClient(r.db.QueryRowContext(ctx, query,
+		client.ClientID, client.SecretHash, client.Name,
+		strings.Join(client.RedirectURIs, "\n"), strings.Join(client.Scopes, ","), client.OwnerID,
+	))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create oauth client",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetOAuthClient retrieves a client by its client ID
+func (r *PostgreSQLOAuthClientRepository) GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE client_id = $1`
+
+	client, err := scanOAuthClient(r.db.QueryRowContext(ctx, query, clientID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get oauth client",
+			Err:     err,
+		}
+	}
+
+	return client, nil
+}
+
+// ListUserOAuthClients retrieves the clients a user registered, oldest first
+func (r *PostgreSQLOAuthClientRepository) ListUserOAuthClients(ctx context.Context, ownerID int) ([]*OAuthClient, error) {
+	query := `SELECT ` + oauthClientColumns + ` FROM oauth_clients WHERE owner_id = $1 ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, ownerID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list oauth clients",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan oauth client row",
+				Err:     err,
+			}
+		}
+		clients = append(clients, client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating oauth client rows",
+			Err:     err,
+		}
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient deletes one of a user's clients. Its authorization
+// codes and tokens reference it with ON DELETE CASCADE.
+func (r *PostgreSQLOAuthClientRepository) DeleteOAuthClient(ctx context.Context, ownerID int, clientID string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oauth_clients WHERE client_id = $1 AND owner_id = $2`, clientID, ownerID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete oauth client",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+// scanOAuthClient scans a row selected with oauthClientColumns
+func scanOAuthClient(row interface{ Scan(...interface{}) error }) (*OAuthClient, error) {
+	var client OAuthClient
+	var redirectURIs, scopes string
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		&redirectURIs,
+		&scopes,
+		&client.OwnerID,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.RedirectURIs = []string{}
+	if redirectURIs != "" {
+		client.RedirectURIs = strings.Split(redirectURIs, "\n")
+	}
+	client.Scopes = []string{}
+	if scopes != "" {
+		client.Scopes = strings.Split(scopes, ",")
+	}
+
+	return &client, nil
+}
+
+// PostgreSQLOAuthAuthorizationCodeRepository implements
+// OAuthAuthorizationCodeRepository using PostgreSQL
+type PostgreSQLOAuthAuthorizationCodeRepository struct {
+	db *sql.DB
+}
+
+// CreateOAuthAuthorizationCode stores a new code
+func (r *PostgreSQLOAuthAuthorizationCodeRepository) CreateOAuthAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error {
+	if code == nil || code.CodeHash == "" || code.ClientID == "" || code.UserID <= 0 {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "code hash, client and user are required"}
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		code.CodeHash, code.ClientID, code.UserID, code.RedirectURI,
+		strings.Join(code.Scopes, ","), code.CodeChallenge, code.ExpiresAt,
+	)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create oauth authorization code",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ConsumeOAuthAuthorizationCode atomically retrieves and deletes a code
+func (r *PostgreSQLOAuthAuthorizationCodeRepository) ConsumeOAuthAuthorizationCode(ctx context.Context, codeHash string) (*OAuthAuthorizationCode, error) {
+	var code OAuthAuthorizationCode
+	var scopes string
+	err := r.db.QueryRowContext(ctx, `
+		DELETE FROM oauth_authorization_codes WHERE code_hash = $1
+		RETURNING code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, created_at`, codeHash,
+	).Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, &scopes, &code.CodeChallenge, &code.ExpiresAt, &code.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOAuthAuthorizationCodeNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to consume oauth authorization code",
+			Err:     err,
+		}
+	}
+
+	code.Scopes = []string{}
+	if scopes != "" {
+		code.Scopes = strings.Split(scopes, ",")
+	}
+	return &code, nil
+}
+
+// DeleteExpiredOAuthAuthorizationCodes removes codes that expired before the given time
+func (r *PostgreSQLOAuthAuthorizationCodeRepository) DeleteExpiredOAuthAuthorizationCodes(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete expired oauth authorization codes",
+			Err:     err,
+		}
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	return int(deleted), nil
+}
+
+// PostgreSQLOAuthTokenRepository implements OAuthTokenRepository using PostgreSQL
+type PostgreSQLOAuthTokenRepository struct {
+	db *sql.DB
+}
+
+const oauthTokenColumns = `id, client_id, user_id, grant_type, scopes, access_token_hash, refresh_token_hash,
+	expires_at, refresh_expires_at, created_at, revoked_at`
+
+// CreateOAuthToken stores a new token
+func (r *PostgreSQLOAuthTokenRepository) CreateOAuthToken(ctx context.Context, token *OAuthToken) (*OAuthToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "oauth token cannot be nil"}
+	}
+	if token.AccessTokenHash == "" || token.ClientID == "" || token.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "access token hash, client and user are required"}
+	}
+
+	var refreshTokenHash sql.NullString
+	if token.RefreshTokenHash != "" {
+		refreshTokenHash = sql.NullString{String: token.RefreshTokenHash, Valid: true}
+	}
+
+	query := `
+		INSERT INTO oauth_tokens (client_id, user_id, grant_type, scopes, access_token_hash, refresh_token_hash, expires_at, refresh_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + oauthTokenColumns
+
+	created, err := scanOAuthToken(r.db.QueryRowContext(ctx, query,
+		token.ClientID, token.UserID, token.GrantType, strings.Join(token.Scopes, ","),
+		token.AccessTokenHash, refreshTokenHash, token.ExpiresAt, token.RefreshExpiresAt,
+	))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create oauth token",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetOAuthTokenByAccessHash retrieves a token by the hash of its access token
+func (r *PostgreSQLOAuthTokenRepository) GetOAuthTokenByAccessHash(ctx context.Context, accessTokenHash string) (*OAuthToken, error) {
+	return r.getOAuthToken(ctx, `access_token_hash = $1`, accessTokenHash)
+}
+
+// GetOAuthTokenByRefreshHash retrieves a token by the hash of its refresh token
+func (r *PostgreSQLOAuthTokenRepository) GetOAuthTokenByRefreshHash(ctx context.Context, refreshTokenHash string) (*OAuthToken, error) {
+	if refreshTokenHash == "" {
+		return nil, ErrOAuthTokenNotFound
+	}
+	return r.getOAuthToken(ctx, `refresh_token_hash = $1`, refreshTokenHash)
+}
+
+func (r *PostgreSQLOAuthTokenRepository) getOAuthToken(ctx context.Context, condition, hash string) (*OAuthToken, error) {
+	query := `SELECT ` + oauthTokenColumns + ` FROM oauth_tokens WHERE ` + condition
+
+	token, err := scanOAuthToken(r.db.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOAuthTokenNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get oauth token",
+			Err:     err,
+		}
+	}
+
+	return token, nil
+}
+
+// RevokeOAuthToken revokes a token that hasn't been revoked yet
+func (r *PostgreSQLOAuthTokenRepository) RevokeOAuthToken(ctx context.Context, id int) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE oauth_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke oauth token",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrOAuthTokenNotFound
+	}
+	return nil
+}
+
+// RevokeUserOAuthTokens revokes every token issued for a user
+func (r *PostgreSQLOAuthTokenRepository) RevokeUserOAuthTokens(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE oauth_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke user oauth tokens",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// scanOAuthToken scans a row selected with oauthTokenColumns
+func scanOAuthToken(row interface{ Scan(...interface{}) error }) (*OAuthToken, error) {
+	var token OAuthToken
+	var scopes string
+	var refreshTokenHash sql.NullString
+	var refreshExpiresAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&token.ID,
+		&token.ClientID,
+		&token.UserID,
+		&token.GrantType,
+		&scopes,
+		&token.AccessTokenHash,
+		&refreshTokenHash,
+		&token.ExpiresAt,
+		&refreshExpiresAt,
+		&token.CreatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = []string{}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	token.RefreshTokenHash = refreshTokenHash.String
+	if refreshExpiresAt.Valid {
+		token.RefreshExpiresAt = &refreshExpiresAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLOIDCIdentityRepository implements OIDCIdentityRepository using PostgreSQL
+type PostgreSQLOIDCIdentityRepository struct {
+	db *sql.DB
+}
+
+const oidcIdentityColumns = `id, user_id, provider, subject, email, created_at, last_login_at`
+
+// CreateOIDCIdentity links a provider account to a user
+func (r *PostgreSQLOIDCIdentityRepository) CreateOIDCIdentity(ctx context.Context, identity *OIDCIdentity) (*OIDCIdentity, error) {
+	if identity == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "identity cannot be nil"}
+	}
+	if identity.Provider == "" || identity.Subject == "" || identity.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "provider, subject and user are required"}
+	}
+
+	query := `
+		INSERT INTO oidc_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + oidcIdentityColumns
+
+	created, err := scanOIDCIdentity(r.db.QueryRowContext(ctx, query,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrOIDCIdentityExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create oidc identity",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetOIDCIdentity retrieves the link for a provider's subject
+func (r *PostgreSQLOIDCIdentityRepository) GetOIDCIdentity(ctx context.Context, provider, subject string) (*OIDCIdentity, error) {
+	query := `SELECT ` + oidcIdentityColumns + ` FROM oidc_identities WHERE provider = $1 AND subject = $2`
+
+	identity, err := scanOIDCIdentity(r.db.QueryRowContext(ctx, query, provider, subject))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOIDCIdentityNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get oidc identity",
+			Err:     err,
+		}
+	}
+
+	return identity, nil
+}
+
+// ListUserOIDCIdentities retrieves every provider account linked to a user, oldest first
+func (r *PostgreSQLOIDCIdentityRepository) ListUserOIDCIdentities(ctx context.Context, userID int) ([]*OIDCIdentity, error) {
+	query := `SELECT ` + oidcIdentityColumns + ` FROM oidc_identities WHERE user_id = $1 ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list oidc identities",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	identities := []*OIDCIdentity{}
+	for rows.Next() {
+		identity, err := scanOIDCIdentity(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan oidc identity row",
+				Err:     err,
+			}
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating oidc identity rows",
+			Err:     err,
+		}
+	}
+
+	return identities, nil
+}
+
+// TouchOIDCIdentity records a login and the email the provider reported
+func (r *PostgreSQLOIDCIdentityRepository) TouchOIDCIdentity(ctx context.Context, id int, email string, loginAt time.Time) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE oidc_identities SET email = $2, last_login_at = $3 WHERE id = $1`, id, email, loginAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update oidc identity",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrOIDCIdentityNotFound
+	}
+
+	return nil
+}
+
+// scanOIDCIdentity scans a row selected with oidcIdentityColumns
+func scanOIDCIdentity(row interface{ Scan(...interface{}) error }) (*OIDCIdentity, error) {
+	var identity OIDCIdentity
+	var lastLoginAt sql.NullTime
+
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&lastLoginAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastLoginAt.Valid {
+		identity.LastLoginAt = &lastLoginAt.Time
+	}
+
+	return &identity, nil
+}
+
+// PostgreSQLOIDCLoginStateRepository implements OIDCLoginStateRepository using PostgreSQL
+type PostgreSQLOIDCLoginStateRepository struct {
+	db *sql.DB
+}
+
+// CreateOIDCLoginState stores a pending login
+func (r *PostgreSQLOIDCLoginStateRepository) CreateOIDCLoginState(ctx context.Context, state *OIDCLoginState) error {
+	if state == nil || state.StateHash == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "state is required"}
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create oidc login state",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ConsumeOIDCLoginState atomically retrieves and deletes a pending login
+func (r *PostgreSQLOIDCLoginStateRepository) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error) {
+	var state OIDCLoginState
+	err := r.db.QueryRowContext(ctx, `
+		DELETE FROM oidc_login_states WHERE state_hash = $1
+		RETURNING state_hash, provider, nonce, code_verifier, expires_at, created_at`, stateHash,
+	).Scan(&state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.ExpiresAt, &state.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOIDCLoginStateNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to consume oidc login state",
+			Err:     err,
+		}
+	}
+
+	return &state, nil
+}
+
+// DeleteExpiredOIDCLoginStates removes logins that expired before the given time
+func (r *PostgreSQLOIDCLoginStateRepository) DeleteExpiredOIDCLoginStates(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete expired oidc login states",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	return int(rowsAffected), nil
+}
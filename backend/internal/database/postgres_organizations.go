@@ -0,0 +1,386 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// PostgreSQLOrganizationRepository implements OrganizationRepository using PostgreSQL
+type PostgreSQLOrganizationRepository struct {
+	db *sql.DB
+}
+
+const organizationColumns = `organizations.id, organizations.name, organizations.created_at, organizations.updated_at`
+
+const memberColumns = `organization_members.organization_id, organization_members.user_id, organization_members.role, organization_members.created_at`
+
+// CreateOrganization stores a new organization with ownerID as its first owner
+func (r *PostgreSQLOrganizationRepository) CreateOrganization(ctx context.Context, org *Organization, ownerID int) (*Organization, error) {
+	if org == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization cannot be nil"}
+	}
+	if strings.TrimSpace(org.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization name is required"}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	created, err := scanOrganization(tx.QueryRowContext(ctx,
+		`INSERT INTO organizations (name) VALUES ($1) RETURNING `+organizationColumns, org.Name))
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create organization",
+			Err:     err,
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)`,
+		created.ID, ownerID, OrgRoleOwner)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrUserNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to add organization owner",
+			Err:     err,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit organization",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetOrganization retrieves an organization by its ID
+func (r *PostgreSQLOrganizationRepository) GetOrganization(ctx context.Context, id int) (*Organization, error) {
+	org, err := scanOrganization(r.db.QueryRowContext(ctx,
+		`SELECT `+organizationColumns+` FROM organizations WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get organization",
+			Err:     err,
+		}
+	}
+
+	return org, nil
+}
+
+// UpdateOrganization updates an organization's name
+func (r *PostgreSQLOrganizationRepository) UpdateOrganization(ctx context.Context, org *Organization) (*Organization, error) {
+	if org == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization cannot be nil"}
+	}
+	if strings.TrimSpace(org.Name) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization name is required"}
+	}
+
+	updated, err := scanOrganization(r.db.QueryRowContext(ctx, `
+		UPDATE organizations SET name = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+organizationColumns, org.ID, org.Name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update organization",
+			Err:     err,
+		}
+	}
+
+	return updated, nil
+}
+
+// DeleteOrganization deletes an organization. Its memberships go with it
+// through the foreign key.
+func (r *PostgreSQLOrganizationRepository) DeleteOrganization(ctx context.Context, id int) error {
+	return r.exec(ctx, ErrOrganizationNotFound, "failed to delete organization",
+		`DELETE FROM organizations WHERE id = $1`, id)
+}
+
+// ListUserOrganizations retrieves the organizations a user belongs to,
+// ordered by name
+func (r *PostgreSQLOrganizationRepository) ListUserOrganizations(ctx context.Context, userID int) ([]*UserOrganization, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+organizationColumns+`, organization_members.role FROM organizations
+		JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organization_members.user_id = $1
+		ORDER BY organizations.name, organizations.id`, userID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list organizations",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	orgs := []*UserOrganization{}
+	for rows.Next() {
+		var org UserOrganization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt, &org.Role); err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan organization row",
+				Err:     err,
+			}
+		}
+		orgs = append(orgs, &org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating organization rows",
+			Err:     err,
+		}
+	}
+
+	return orgs, nil
+}
+
+// AddMember adds a user to an organization
+func (r *PostgreSQLOrganizationRepository) AddMember(ctx context.Context, organizationID, userID int, role string) (*Membership, error) {
+	if !ValidOrgRole(role) {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+
+	membership, err := scanMembership(r.db.QueryRowContext(ctx, `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		RETURNING `+memberColumns, organizationID, userID, role))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrMembershipExists
+		}
+		if strings.Contains(err.Error(), "organization_members_organization_id_fkey") {
+			return nil, ErrOrganizationNotFound
+		}
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrUserNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to add organization member",
+			Err:     err,
+		}
+	}
+
+	return membership, nil
+}
+
+// GetMembership retrieves a user's membership of an organization
+func (r *PostgreSQLOrganizationRepository) GetMembership(ctx context.Context, organizationID, userID int) (*Membership, error) {
+	membership, err := scanMembership(r.db.QueryRowContext(ctx, `
+		SELECT `+memberColumns+` FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`, organizationID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMembershipNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get organization membership",
+			Err:     err,
+		}
+	}
+
+	return membership, nil
+}
+
+// ListMembers retrieves the members of an organization, longest standing first
+func (r *PostgreSQLOrganizationRepository) ListMembers(ctx context.Context, organizationID int) ([]*OrganizationMember, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+memberColumns+`, users.name, users.email FROM organization_members
+		JOIN users ON users.id = organization_members.user_id
+		WHERE organization_members.organization_id = $1
+		ORDER BY organization_members.created_at, organization_members.user_id`, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list organization members",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	members := []*OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt, &member.Name, &member.Email)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan organization member row",
+				Err:     err,
+			}
+		}
+		members = append(members, &member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating organization member rows",
+			Err:     err,
+		}
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role
+func (r *PostgreSQLOrganizationRepository) UpdateMemberRole(ctx context.Context, organizationID, userID int, role string) error {
+	if !ValidOrgRole(role) {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "unknown organization role"}
+	}
+
+	return r.exec(ctx, ErrMembershipNotFound, "failed to update organization member",
+		`UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND user_id = $2`,
+		organizationID, userID, role)
+}
+
+// RemoveMember takes a user out of an organization
+func (r *PostgreSQLOrganizationRepository) RemoveMember(ctx context.Context, organizationID, userID int) error {
+	return r.exec(ctx, ErrMembershipNotFound, "failed to remove organization member",
+		`DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`,
+		organizationID, userID)
+}
+
+// CountMembers returns how many members of an organization have a role, or
+// how many members it has if role is empty
+func (r *PostgreSQLOrganizationRepository) CountMembers(ctx context.Context, organizationID int, role string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM organization_members
+		WHERE organization_id = $1 AND ($2 = '' OR role = $2)`, organizationID, role).Scan(&count)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to count organization members",
+			Err:     err,
+		}
+	}
+
+	return count, nil
+}
+
+// TransferOwnership makes toUserID an owner and fromUserID an admin
+func (r *PostgreSQLOrganizationRepository) TransferOwnership(ctx context.Context, organizationID, fromUserID, toUserID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE organization_members
+		SET role = CASE WHEN user_id = $3 THEN $4 ELSE $5 END
+		WHERE organization_id = $1 AND user_id IN ($2, $3)`,
+		organizationID, fromUserID, toUserID, OrgRoleOwner, OrgRoleAdmin)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to transfer organization ownership",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	expected := int64(2)
+	if fromUserID == toUserID {
+		expected = 1
+	}
+	if rowsAffected != expected {
+		return ErrMembershipNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit ownership transfer",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// exec runs a statement against a single row, returning notFound when no
+// row matched
+func (r *PostgreSQLOrganizationRepository) exec(ctx context.Context, notFound error, message, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: message,
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// scanOrganization scans a row selected with organizationColumns
+func scanOrganization(row interface{ Scan(...interface{}) error }) (*Organization, error) {
+	var org Organization
+	if err := row.Scan(&org.ID, &org.Name, &org.CreatedAt, &org.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// scanMembership scans a row selected with memberColumns
+func scanMembership(row interface{ Scan(...interface{}) error }) (*Membership, error) {
+	var membership Membership
+	if err := row.Scan(&membership.OrganizationID, &membership.UserID, &membership.Role, &membership.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PostgreSQLPasswordHistoryRepository implements PasswordHistoryRepository using PostgreSQL
+type PostgreSQLPasswordHistoryRepository struct {
+	db *sql.DB
+}
+
+// AddPasswordHash records a replaced password hash, keeping the newest keep
+func (r *PostgreSQLPasswordHistoryRepository) AddPasswordHash(ctx context.Context, userID int, passwordHash string, keep int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2)`, userID, passwordHash)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to store password history",
+			Err:     err,
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+		)`, userID, max(keep, 0))
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to trim password history",
+			Err:     err,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit password history",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ListPasswordHashes retrieves up to limit of a user's replaced hashes, newest first
+func (r *PostgreSQLPasswordHistoryRepository) ListPasswordHashes(ctx context.Context, userID int, limit int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list password history",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan password history",
+				Err:     err,
+			}
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list password history",
+			Err:     err,
+		}
+	}
+
+	return hashes, nil
+}
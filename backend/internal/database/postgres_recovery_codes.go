@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// PostgreSQLRecoveryCodeRepository implements RecoveryCodeRepository using PostgreSQL
+type PostgreSQLRecoveryCodeRepository struct {
+	db *sql.DB
+}
+
+// ReplaceRecoveryCodes discards a user's existing codes and stores new ones
+func (r *PostgreSQLRecoveryCodeRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete recovery codes",
+			Err:     err,
+		}
+	}
+
+	for _, hash := range codeHashes {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to store recovery code",
+				Err:     err,
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit recovery codes",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ConsumeRecoveryCode atomically marks an unused code as used
+func (r *PostgreSQLRecoveryCodeRepository) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	// Only one concurrent request can flip used_at, so a code works once
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM mfa_recovery_codes
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		) AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to consume recovery code",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+// CountUnusedRecoveryCodes returns how many codes a user has left
+func (r *PostgreSQLRecoveryCodeRepository) CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to count recovery codes",
+			Err:     err,
+		}
+	}
+	return count, nil
+}
+
+// DeleteRecoveryCodes removes every code belonging to a user
+func (r *PostgreSQLRecoveryCodeRepository) DeleteRecoveryCodes(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete recovery codes",
+			Err:     err,
+		}
+	}
+	return nil
+}
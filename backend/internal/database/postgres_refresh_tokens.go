@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLRefreshTokenRepository implements RefreshTokenRepository using PostgreSQL
+type PostgreSQLRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+const refreshTokenColumns = `id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at, request_ip, user_agent`
+
+// CreateRefreshToken stores a new refresh token
+func (r *PostgreSQLRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "refresh token cannot be nil"}
+	}
+	if token.TokenHash == "" || token.FamilyID == "" || token.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "token hash, family and user are required"}
+	}
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, request_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + refreshTokenColumns
+
+	created, err := scanRefreshToken(r.db.QueryRowContext(ctx, query,
+		token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.RequestIP, token.UserAgent,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, &DatabaseError{Type: "CONFLICT", Message: "refresh token already exists"}
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create refresh token",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by its hash
+func (r *PostgreSQLRefreshTokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	query := `SELECT ` + refreshTokenColumns + ` FROM refresh_tokens WHERE token_hash = $1`
+
+	token, err := scanRefreshToken(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get refresh token",
+			Err:     err,
+		}
+	}
+
+	return token, nil
+}
+
+// MarkRefreshTokenUsed atomically marks a token as used
+func (r *PostgreSQLRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id int) error {
+	// The used_at IS NULL guard makes concurrent rotations of the same token
+	// race safely: only one of them updates a row.
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to mark refresh token used",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+		if err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM refresh_tokens WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to check refresh token",
+				Err:     err,
+			}
+		}
+		if !exists {
+			return ErrRefreshTokenNotFound
+		}
+		return ErrRefreshTokenUsed
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in a family
+func (r *PostgreSQLRefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke refresh token family",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token belonging to a user
+func (r *PostgreSQLRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke user refresh tokens",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredRefreshTokens removes tokens that expired before the given time
+func (r *PostgreSQLRefreshTokenRepository) DeleteExpiredRefreshTokens(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete expired refresh tokens",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	return int(rowsAffected), nil
+}
+
+// scanRefreshToken scans a refresh token row
+func scanRefreshToken(row interface{ Scan(...interface{}) error }) (*RefreshToken, error) {
+	var token RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	var requestIP, userAgent sql.NullString
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+		&token.CreatedAt,
+		&requestIP,
+		&userAgent,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.UsedAt = nullTimePtr(usedAt)
+	token.RevokedAt = nullTimePtr(revokedAt)
+	token.RequestIP = requestIP.String
+	token.UserAgent = userAgent.String
+
+	return &token, nil
+}
+
+// nullTimePtr converts a nullable timestamp into an optional time
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
+
+// nullID stores an optional reference to a user, where 0 means none
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
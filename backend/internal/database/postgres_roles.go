@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// PostgreSQLRoleRepository implements RoleRepository using PostgreSQL
+type PostgreSQLRoleRepository struct {
+	db *sql.DB
+}
+
+const roleColumns = `roles.id, roles.name, roles.description, roles.permissions, roles.created_at`
+
+// CreateRole stores a new role
+func (r *PostgreSQLRoleRepository) CreateRole(ctx context.Context, role *Role) (*Role, error) {
+	if role == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "role cannot be nil"}
+	}
+	if role.Name == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "role name is required"}
+	}
+
+	query := `
+		INSERT INTO roles (name, description, permissions)
+		VALUES ($1, $2, $3)
+		RETURNING ` + roleColumns
+
+	created, err := scanRole(r.db.QueryRowContext(ctx, query,
+		role.Name, role.Description, strings.Join(role.Permissions, ","),
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrRoleExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create role",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetRoleByName retrieves a role by its name
+func (r *PostgreSQLRoleRepository) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	query := `SELECT ` + roleColumns + ` FROM roles WHERE name = $1`
+
+	role, err := scanRole(r.db.QueryRowContext(ctx, query, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get role",
+			Err:     err,
+		}
+	}
+
+	return role, nil
+}
+
+// ListRoles retrieves every role, ordered by name
+func (r *PostgreSQLRoleRepository) ListRoles(ctx context.Context) ([]*Role, error) {
+	return r.queryRoles(ctx, `SELECT `+roleColumns+` FROM roles ORDER BY roles.name`)
+}
+
+// AssignRole gives a user a role
+func (r *PostgreSQLRoleRepository) AssignRole(ctx context.Context, userID int, roleName string) error {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT (user_id, role_id) DO NOTHING`, userID, roleName)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return ErrUserNotFound
+		}
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to assign role",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		// Either the user already has the role or there is no such role
+		if _, err := r.GetRoleByName(ctx, roleName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnassignRole takes a role away from a user
+func (r *PostgreSQLRoleRepository) UnassignRole(ctx context.Context, userID int, roleName string) error {
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`, userID, roleName)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to unassign role",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// ListUserRoles retrieves the roles assigned to a user, ordered by name
+func (r *PostgreSQLRoleRepository) ListUserRoles(ctx context.Context, userID int) ([]*Role, error) {
+	return r.queryRoles(ctx, `
+		SELECT `+roleColumns+` FROM roles
+		JOIN user_roles ON user_roles.role_id = roles.id
+		WHERE user_roles.user_id = $1
+		ORDER BY roles.name`, userID)
+}
+
+// CountRoleUsers returns how many users have a role
+func (r *PostgreSQLRoleRepository) CountRoleUsers(ctx context.Context, roleName string) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM user_roles
+		JOIN roles ON roles.id = user_roles.role_id
+		WHERE roles.name = $1`, roleName).Scan(&count)
+	if err != nil {
+		return 0, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to count role users",
+			Err:     err,
+		}
+	}
+
+	return count, nil
+}
+
+// queryRoles runs a query selecting roleColumns
+func (r *PostgreSQLRoleRepository) queryRoles(ctx context.Context, query string, args ...interface{}) ([]*Role, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list roles",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan role row",
+				Err:     err,
+			}
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating role rows",
+			Err:     err,
+		}
+	}
+
+	return roles, nil
+}
+
+// scanRole scans a row selected with roleColumns
+func scanRole(row interface{ Scan(...interface{}) error }) (*Role, error) {
+	var role Role
+	var permissions string
+
+	err := row.Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&permissions,
+		&role.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions = []string{}
+	if permissions != "" {
+		role.Permissions = strings.Split(permissions, ",")
+	}
+
+	return &role, nil
+}
@@ -0,0 +1,400 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLSAMLConnectionRepository implements SAMLConnectionRepository using PostgreSQL
+type PostgreSQLSAMLConnectionRepository struct {
+	db *sql.DB
+}
+
+const samlConnectionColumns = `organization_id, idp_entity_id, idp_sso_url, idp_certificate,
+	email_attribute, name_attribute, allow_idp_initiated, jit_provisioning, created_at, updated_at`
+
+// SaveSAMLConnection creates or replaces an organization's connection. Its
+// domains live in their own table, whose partial unique index stops two
+// organizations verifying the same domain.
+func (r *PostgreSQLSAMLConnectionRepository) SaveSAMLConnection(ctx context.Context, conn *SAMLConnection) (*SAMLConnection, error) {
+	if conn == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "saml connection cannot be nil"}
+	}
+	if conn.IdPEntityID == "" || conn.IdPSSOURL == "" || conn.IdPCertificate == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "identity provider entity id, sso url and certificate are required"}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	saved, err := scanSAMLConnection(tx.QueryRowContext(ctx, `
+		INSERT INTO saml_connections (organization_id, idp_entity_id, idp_sso_url, idp_certificate,
+			email_attribute, name_attribute, allow_idp_initiated, jit_provisioning)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			idp_entity_id = EXCLUDED.idp_entity_id,
+			idp_sso_url = EXCLUDED.idp_sso_url,
+			idp_certificate = EXCLUDED.idp_certificate,
+			email_attribute = EXCLUDED.email_attribute,
+			name_attribute = EXCLUDED.name_attribute,
+			allow_idp_initiated = EXCLUDED.allow_idp_initiated,
+			jit_provisioning = EXCLUDED.jit_provisioning,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING `+samlConnectionColumns,
+		conn.OrganizationID, conn.IdPEntityID, conn.IdPSSOURL, conn.IdPCertificate,
+		conn.EmailAttribute, conn.NameAttribute, conn.AllowIdPInitiated, conn.JITProvisioning,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to save saml connection",
+			Err:     err,
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM saml_domains WHERE organization_id = $1`, conn.OrganizationID); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to replace saml domains",
+			Err:     err,
+		}
+	}
+	for _, domain := range conn.Domains {
+		var taken bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM saml_domains WHERE domain = $1 AND organization_id <> $2 AND verified_at IS NOT NULL)`,
+			strings.ToLower(domain.Domain), conn.OrganizationID).Scan(&taken)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to check saml domain",
+				Err:     err,
+			}
+		}
+		if taken {
+			return nil, ErrSAMLDomainTaken
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO saml_domains (domain, organization_id, verification_token, verified_at) VALUES ($1, $2, $3, $4)`,
+			strings.ToLower(domain.Domain), conn.OrganizationID, domain.VerificationToken, domain.VerifiedAt)
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+				return nil, ErrSAMLDomainTaken
+			}
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to save saml domain",
+				Err:     err,
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit transaction",
+			Err:     err,
+		}
+	}
+
+	saved.Domains = append([]SAMLDomain{}, conn.Domains...)
+	return saved, nil
+}
+
+// VerifySAMLDomain marks a domain of an organization's connection as verified
+func (r *PostgreSQLSAMLConnectionRepository) VerifySAMLDomain(ctx context.Context, organizationID int, domain string) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE saml_domains SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP) WHERE organization_id = $1 AND domain = $2`,
+		organizationID, strings.ToLower(domain))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return ErrSAMLDomainTaken
+		}
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to verify saml domain",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrSAMLDomainNotFound
+	}
+
+	return nil
+}
+
+// GetSAMLConnection retrieves an organization's connection
+func (r *PostgreSQLSAMLConnectionRepository) GetSAMLConnection(ctx context.Context, organizationID int) (*SAMLConnection, error) {
+	conn, err := scanSAMLConnection(r.db.QueryRowContext(ctx,
+		`SELECT `+samlConnectionColumns+` FROM saml_connections WHERE organization_id = $1`, organizationID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSAMLConnectionNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get saml connection",
+			Err:     err,
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT domain, verification_token, verified_at FROM saml_domains WHERE organization_id = $1 ORDER BY domain`, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list saml domains",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	conn.Domains = []SAMLDomain{}
+	for rows.Next() {
+		var domain SAMLDomain
+		var verifiedAt sql.NullTime
+		if err := rows.Scan(&domain.Domain, &domain.VerificationToken, &verifiedAt); err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan saml domain row",
+				Err:     err,
+			}
+		}
+		if verifiedAt.Valid {
+			domain.VerifiedAt = &verifiedAt.Time
+		}
+		conn.Domains = append(conn.Domains, domain)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating saml domain rows",
+			Err:     err,
+		}
+	}
+
+	return conn, nil
+}
+
+// DeleteSAMLConnection deletes an organization's connection and, through
+// the foreign key, its domains
+func (r *PostgreSQLSAMLConnectionRepository) DeleteSAMLConnection(ctx context.Context, organizationID int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM saml_connections WHERE organization_id = $1`, organizationID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete saml connection",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrSAMLConnectionNotFound
+	}
+
+	return nil
+}
+
+// scanSAMLConnection scans a row selected with samlConnectionColumns
+func scanSAMLConnection(row interface{ Scan(...interface{}) error }) (*SAMLConnection, error) {
+	var conn SAMLConnection
+	err := row.Scan(
+		&conn.OrganizationID,
+		&conn.IdPEntityID,
+		&conn.IdPSSOURL,
+		&conn.IdPCertificate,
+		&conn.EmailAttribute,
+		&conn.NameAttribute,
+		&conn.AllowIdPInitiated,
+		&conn.JITProvisioning,
+		&conn.CreatedAt,
+		&conn.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &conn, nil
+}
+
+// PostgreSQLSAMLLoginRepository implements SAMLLoginRepository using PostgreSQL
+type PostgreSQLSAMLLoginRepository struct {
+	db *sql.DB
+}
+
+// CreateSAMLRequest stores a pending authentication request
+func (r *PostgreSQLSAMLLoginRepository) CreateSAMLRequest(ctx context.Context, request *SAMLRequest) error {
+	if request == nil || request.ID == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "saml request id is required"}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO saml_requests (id, organization_id, expires_at) VALUES ($1, $2, $3)`,
+		request.ID, request.OrganizationID, request.ExpiresAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create saml request",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ConsumeSAMLRequest atomically retrieves and deletes a pending request
+func (r *PostgreSQLSAMLLoginRepository) ConsumeSAMLRequest(ctx context.Context, id string) (*SAMLRequest, error) {
+	var request SAMLRequest
+	err := r.db.QueryRowContext(ctx, `
+		DELETE FROM saml_requests WHERE id = $1
+		RETURNING id, organization_id, expires_at, created_at`, id,
+	).Scan(&request.ID, &request.OrganizationID, &request.ExpiresAt, &request.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSAMLRequestNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to consume saml request",
+			Err:     err,
+		}
+	}
+
+	return &request, nil
+}
+
+// RecordSAMLAssertion remembers an assertion until it expires. A row left
+// behind by an expired assertion is taken over rather than counted as a
+// replay.
+func (r *PostgreSQLSAMLLoginRepository) RecordSAMLAssertion(ctx context.Context, issuer, assertionID string, expiresAt time.Time) error {
+	if issuer == "" || assertionID == "" {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "issuer and assertion id are required"}
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO saml_assertions (issuer, assertion_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (issuer, assertion_id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE saml_assertions.expires_at < NOW()`,
+		issuer, assertionID, expiresAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to record saml assertion",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrSAMLAssertionReplayed
+	}
+
+	return nil
+}
+
+// CreateSAMLLoginCode stores a new login code
+func (r *PostgreSQLSAMLLoginRepository) CreateSAMLLoginCode(ctx context.Context, code *SAMLLoginCode) error {
+	if code == nil || code.CodeHash == "" || code.UserID <= 0 {
+		return &DatabaseError{Type: "INVALID_INPUT", Message: "code hash and user are required"}
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO saml_login_codes (code_hash, user_id, organization_id, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		code.CodeHash, code.UserID, code.OrganizationID, code.ExpiresAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create saml login code",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// ConsumeSAMLLoginCode atomically retrieves and deletes a login code
+func (r *PostgreSQLSAMLLoginRepository) ConsumeSAMLLoginCode(ctx context.Context, codeHash string) (*SAMLLoginCode, error) {
+	var code SAMLLoginCode
+	err := r.db.QueryRowContext(ctx, `
+		DELETE FROM saml_login_codes WHERE code_hash = $1
+		RETURNING code_hash, user_id, organization_id, expires_at, created_at`, codeHash,
+	).Scan(&code.CodeHash, &code.UserID, &code.OrganizationID, &code.ExpiresAt, &code.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSAMLLoginCodeNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to consume saml login code",
+			Err:     err,
+		}
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredSAMLLogins removes requests, assertions and codes that
+// expired before the given time
+func (r *PostgreSQLSAMLLoginRepository) DeleteExpiredSAMLLogins(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+	for _, table := range []string{"saml_requests", "saml_assertions", "saml_login_codes"} {
+		result, err := r.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE expires_at < $1`, before)
+		if err != nil {
+			return deleted, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to delete expired saml logins",
+				Err:     err,
+			}
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return deleted, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to get rows affected",
+				Err:     err,
+			}
+		}
+		deleted += int(rowsAffected)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,652 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLSCIMTokenRepository implements SCIMTokenRepository using PostgreSQL
+type PostgreSQLSCIMTokenRepository struct {
+	db *sql.DB
+}
+
+const scimTokenColumns = `id, organization_id, name, prefix, token_hash, created_by, created_at, last_used_at`
+
+// CreateSCIMToken stores a new token
+func (r *PostgreSQLSCIMTokenRepository) CreateSCIMToken(ctx context.Context, token *SCIMToken) (*SCIMToken, error) {
+	if token == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim token cannot be nil"}
+	}
+	if token.TokenHash == "" || token.OrganizationID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "token hash and organization are required"}
+	}
+
+	query := `
+		INSERT INTO scim_tokens (organization_id, name, prefix, token_hash, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+		RETURNING ` + scimTokenColumns
+
+	created, err := scanSCIMToken(r.db.QueryRowContext(ctx, query,
+		token.OrganizationID, token.Name, token.Prefix, token.TokenHash, token.CreatedBy,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create scim token",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetSCIMTokenByHash retrieves a token by its hash
+func (r *PostgreSQLSCIMTokenRepository) GetSCIMTokenByHash(ctx context.Context, tokenHash string) (*SCIMToken, error) {
+	query := `SELECT ` + scimTokenColumns + ` FROM scim_tokens WHERE token_hash = $1`
+
+	token, err := scanSCIMToken(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSCIMTokenNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get scim token",
+			Err:     err,
+		}
+	}
+
+	return token, nil
+}
+
+// ListSCIMTokens retrieves an organization's tokens, oldest first
+func (r *PostgreSQLSCIMTokenRepository) ListSCIMTokens(ctx context.Context, organizationID int) ([]*SCIMToken, error) {
+	query := `SELECT ` + scimTokenColumns + ` FROM scim_tokens WHERE organization_id = $1 ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list scim tokens",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	tokens := []*SCIMToken{}
+	for rows.Next() {
+		token, err := scanSCIMToken(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan scim token row",
+				Err:     err,
+			}
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating scim token rows",
+			Err:     err,
+		}
+	}
+
+	return tokens, nil
+}
+
+// TouchSCIMToken records use of a token
+func (r *PostgreSQLSCIMTokenRepository) TouchSCIMToken(ctx context.Context, id int, usedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE scim_tokens SET last_used_at = $2 WHERE id = $1`, id, usedAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update scim token",
+			Err:     err,
+		}
+	}
+
+	return requireSCIMRow(result, ErrSCIMTokenNotFound)
+}
+
+// DeleteSCIMToken deletes one of an organization's tokens
+func (r *PostgreSQLSCIMTokenRepository) DeleteSCIMToken(ctx context.Context, organizationID, id int) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM scim_tokens WHERE id = $1 AND organization_id = $2`, id, organizationID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete scim token",
+			Err:     err,
+		}
+	}
+
+	return requireSCIMRow(result, ErrSCIMTokenNotFound)
+}
+
+// scanSCIMToken scans a row selected with scimTokenColumns
+func scanSCIMToken(row interface{ Scan(...interface{}) error }) (*SCIMToken, error) {
+	var token SCIMToken
+	var createdBy sql.NullInt64
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&token.ID,
+		&token.OrganizationID,
+		&token.Name,
+		&token.Prefix,
+		&token.TokenHash,
+		&createdBy,
+		&token.CreatedAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.CreatedBy = int(createdBy.Int64)
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+// PostgreSQLSCIMUserRepository implements SCIMUserRepository using PostgreSQL
+type PostgreSQLSCIMUserRepository struct {
+	db *sql.DB
+}
+
+const scimUserColumns = `organization_id, user_id, external_id, deactivated_at, created_at, updated_at`
+
+// CreateSCIMUser marks a user as managed by an organization. The user ID
+// is the primary key, so a user can't be managed twice.
+func (r *PostgreSQLSCIMUserRepository) CreateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error) {
+	if scimUser == nil || scimUser.OrganizationID <= 0 || scimUser.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization and user are required"}
+	}
+
+	query := `
+		INSERT INTO scim_users (organization_id, user_id, external_id, deactivated_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + scimUserColumns
+
+	created, err := scanSCIMUser(r.db.QueryRowContext(ctx, query,
+		scimUser.OrganizationID, scimUser.UserID, scimUser.ExternalID, scimUser.DeactivatedAt,
+	))
+	if err != nil {
+		if isSCIMExternalIDConflict(err) {
+			return nil, ErrSCIMExternalIDTaken
+		}
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrSCIMUserExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create scim user",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetSCIMUser retrieves a user managed by an organization
+func (r *PostgreSQLSCIMUserRepository) GetSCIMUser(ctx context.Context, organizationID, userID int) (*SCIMUser, error) {
+	query := `SELECT ` + scimUserColumns + ` FROM scim_users WHERE organization_id = $1 AND user_id = $2`
+
+	scimUser, err := scanSCIMUser(r.db.QueryRowContext(ctx, query, organizationID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSCIMUserNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get scim user",
+			Err:     err,
+		}
+	}
+
+	return scimUser, nil
+}
+
+// ListSCIMUsers retrieves the users an organization manages, in the order
+// they were provisioned
+func (r *PostgreSQLSCIMUserRepository) ListSCIMUsers(ctx context.Context, organizationID int) ([]*SCIMUser, error) {
+	query := `SELECT ` + scimUserColumns + ` FROM scim_users WHERE organization_id = $1 ORDER BY created_at, user_id`
+
+	rows, err := r.db.QueryContext(ctx, query, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list scim users",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	users := []*SCIMUser{}
+	for rows.Next() {
+		scimUser, err := scanSCIMUser(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan scim user row",
+				Err:     err,
+			}
+		}
+		users = append(users, scimUser)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating scim user rows",
+			Err:     err,
+		}
+	}
+
+	return users, nil
+}
+
+// UpdateSCIMUser changes a managed user's external ID and when they were
+// deactivated
+func (r *PostgreSQLSCIMUserRepository) UpdateSCIMUser(ctx context.Context, scimUser *SCIMUser) (*SCIMUser, error) {
+	if scimUser == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim user cannot be nil"}
+	}
+
+	query := `
+		UPDATE scim_users SET external_id = $3, deactivated_at = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE organization_id = $1 AND user_id = $2
+		RETURNING ` + scimUserColumns
+
+	updated, err := scanSCIMUser(r.db.QueryRowContext(ctx, query,
+		scimUser.OrganizationID, scimUser.UserID, scimUser.ExternalID, scimUser.DeactivatedAt,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSCIMUserNotFound
+		}
+		if isSCIMExternalIDConflict(err) {
+			return nil, ErrSCIMExternalIDTaken
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update scim user",
+			Err:     err,
+		}
+	}
+
+	return updated, nil
+}
+
+// DeleteSCIMUser stops an organization managing a user
+func (r *PostgreSQLSCIMUserRepository) DeleteSCIMUser(ctx context.Context, organizationID, userID int) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM scim_users WHERE organization_id = $1 AND user_id = $2`, organizationID, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete scim user",
+			Err:     err,
+		}
+	}
+
+	return requireSCIMRow(result, ErrSCIMUserNotFound)
+}
+
+// isSCIMExternalIDConflict reports whether an error is a violation of the
+// unique index on the organization's external IDs
+func isSCIMExternalIDConflict(err error) bool {
+	return strings.Contains(err.Error(), "idx_scim_users_external_id")
+}
+
+// scanSCIMUser scans a row selected with scimUserColumns
+func scanSCIMUser(row interface{ Scan(...interface{}) error }) (*SCIMUser, error) {
+	var scimUser SCIMUser
+	var deactivatedAt sql.NullTime
+
+	err := row.Scan(
+		&scimUser.OrganizationID,
+		&scimUser.UserID,
+		&scimUser.ExternalID,
+		&deactivatedAt,
+		&scimUser.CreatedAt,
+		&scimUser.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if deactivatedAt.Valid {
+		scimUser.DeactivatedAt = &deactivatedAt.Time
+	}
+	return &scimUser, nil
+}
+
+// PostgreSQLSCIMGroupRepository implements SCIMGroupRepository using PostgreSQL
+type PostgreSQLSCIMGroupRepository struct {
+	db *sql.DB
+}
+
+const scimGroupColumns = `id, organization_id, display_name, external_id, created_at, updated_at`
+
+// CreateSCIMGroup stores a new group with its members
+func (r *PostgreSQLSCIMGroupRepository) CreateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error) {
+	if group == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim group cannot be nil"}
+	}
+	if group.OrganizationID <= 0 || strings.TrimSpace(group.DisplayName) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "organization and display name are required"}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	created, err := scanSCIMGroup(tx.QueryRowContext(ctx, `
+		INSERT INTO scim_groups (organization_id, display_name, external_id)
+		VALUES ($1, $2, $3)
+		RETURNING `+scimGroupColumns,
+		group.OrganizationID, group.DisplayName, group.ExternalID,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrSCIMGroupExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create scim group",
+			Err:     err,
+		}
+	}
+
+	if created.MemberIDs, err = replaceSCIMGroupMembers(ctx, tx, created.ID, group.MemberIDs); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit transaction",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetSCIMGroup retrieves one of an organization's groups
+func (r *PostgreSQLSCIMGroupRepository) GetSCIMGroup(ctx context.Context, organizationID, id int) (*SCIMGroup, error) {
+	group, err := scanSCIMGroup(r.db.QueryRowContext(ctx,
+		`SELECT `+scimGroupColumns+` FROM scim_groups WHERE id = $1 AND organization_id = $2`, id, organizationID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSCIMGroupNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get scim group",
+			Err:     err,
+		}
+	}
+
+	members, err := r.listMembers(ctx, `
+		SELECT group_id, user_id FROM scim_group_members
+		WHERE group_id = $1 ORDER BY user_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	if ids, ok := members[id]; ok {
+		group.MemberIDs = ids
+	}
+	return group, nil
+}
+
+// ListSCIMGroups retrieves an organization's groups, oldest first
+func (r *PostgreSQLSCIMGroupRepository) ListSCIMGroups(ctx context.Context, organizationID int) ([]*SCIMGroup, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+scimGroupColumns+` FROM scim_groups WHERE organization_id = $1 ORDER BY id`, organizationID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list scim groups",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	groups := []*SCIMGroup{}
+	for rows.Next() {
+		group, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan scim group row",
+				Err:     err,
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating scim group rows",
+			Err:     err,
+		}
+	}
+
+	members, err := r.listMembers(ctx, `
+		SELECT m.group_id, m.user_id FROM scim_group_members m
+		JOIN scim_groups g ON g.id = m.group_id
+		WHERE g.organization_id = $1 ORDER BY m.user_id`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if ids, ok := members[group.ID]; ok {
+			group.MemberIDs = ids
+		}
+	}
+	return groups, nil
+}
+
+// UpdateSCIMGroup replaces a group's display name, external ID and members
+func (r *PostgreSQLSCIMGroupRepository) UpdateSCIMGroup(ctx context.Context, group *SCIMGroup) (*SCIMGroup, error) {
+	if group == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "scim group cannot be nil"}
+	}
+	if strings.TrimSpace(group.DisplayName) == "" {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "display name is required"}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to begin transaction",
+			Err:     err,
+		}
+	}
+	defer tx.Rollback()
+
+	updated, err := scanSCIMGroup(tx.QueryRowContext(ctx, `
+		UPDATE scim_groups SET display_name = $3, external_id = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND organization_id = $2
+		RETURNING `+scimGroupColumns,
+		group.ID, group.OrganizationID, group.DisplayName, group.ExternalID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSCIMGroupNotFound
+		}
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrSCIMGroupExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update scim group",
+			Err:     err,
+		}
+	}
+
+	if updated.MemberIDs, err = replaceSCIMGroupMembers(ctx, tx, updated.ID, group.MemberIDs); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to commit transaction",
+			Err:     err,
+		}
+	}
+
+	return updated, nil
+}
+
+// DeleteSCIMGroup deletes one of an organization's groups and, through the
+// foreign key, its memberships
+func (r *PostgreSQLSCIMGroupRepository) DeleteSCIMGroup(ctx context.Context, organizationID, id int) error {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM scim_groups WHERE id = $1 AND organization_id = $2`, id, organizationID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete scim group",
+			Err:     err,
+		}
+	}
+
+	return requireSCIMRow(result, ErrSCIMGroupNotFound)
+}
+
+// RemoveSCIMGroupMember takes a user out of every group of an organization
+func (r *PostgreSQLSCIMGroupRepository) RemoveSCIMGroupMember(ctx context.Context, organizationID, userID int) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM scim_group_members
+		WHERE user_id = $2 AND group_id IN (SELECT id FROM scim_groups WHERE organization_id = $1)`,
+		organizationID, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to remove scim group member",
+			Err:     err,
+		}
+	}
+
+	return nil
+}
+
+// listMembers runs a query selecting group IDs and user IDs and returns
+// the user IDs by group
+func (r *PostgreSQLSCIMGroupRepository) listMembers(ctx context.Context, query string, args ...interface{}) (map[int][]int, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list scim group members",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	members := map[int][]int{}
+	for rows.Next() {
+		var groupID, userID int
+		if err := rows.Scan(&groupID, &userID); err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan scim group member row",
+				Err:     err,
+			}
+		}
+		members[groupID] = append(members[groupID], userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating scim group member rows",
+			Err:     err,
+		}
+	}
+
+	return members, nil
+}
+
+// replaceSCIMGroupMembers sets a group's members inside a transaction and
+// returns them in ascending order
+func replaceSCIMGroupMembers(ctx context.Context, tx *sql.Tx, groupID int, memberIDs []int) ([]int, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM scim_group_members WHERE group_id = $1`, groupID); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to replace scim group members",
+			Err:     err,
+		}
+	}
+
+	members := uniqueSortedIDs(memberIDs)
+	for _, userID := range members {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO scim_group_members (group_id, user_id) VALUES ($1, $2)`, groupID, userID)
+		if err != nil {
+			if strings.Contains(err.Error(), "foreign key") {
+				return nil, ErrUserNotFound
+			}
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to add scim group member",
+				Err:     err,
+			}
+		}
+	}
+	return members, nil
+}
+
+// scanSCIMGroup scans a row selected with scimGroupColumns
+func scanSCIMGroup(row interface{ Scan(...interface{}) error }) (*SCIMGroup, error) {
+	group := SCIMGroup{MemberIDs: []int{}}
+	err := row.Scan(
+		&group.ID,
+		&group.OrganizationID,
+		&group.DisplayName,
+		&group.ExternalID,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// requireSCIMRow maps a statement that touched no rows to notFound
+func requireSCIMRow(result sql.Result, notFound error) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return notFound
+	}
+	return nil
+}
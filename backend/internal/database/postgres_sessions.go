@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLSessionRepository implements SessionRepository using PostgreSQL
+type PostgreSQLSessionRepository struct {
+	db *sql.DB
+}
+
+const sessionColumns = `id, user_id, user_agent, ip_address, auth_method, created_at, last_seen_at, expires_at, revoked_at, impersonator_id, organization_id`
+
+// CreateSession stores a new session
+func (r *PostgreSQLSessionRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	if session == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "session cannot be nil"}
+	}
+	if session.ID == "" || session.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "session ID and user are required"}
+	}
+
+	query := `
+		INSERT INTO sessions (id, user_id, user_agent, ip_address, auth_method, expires_at, impersonator_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + sessionColumns
+
+	created, err := scanSession(r.db.QueryRowContext(ctx, query,
+		session.ID, session.UserID, session.UserAgent, session.IPAddress, session.AuthMethod, session.ExpiresAt,
+		nullID(session.ImpersonatorID),
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, &DatabaseError{Type: "CONFLICT", Message: "session already exists"}
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create session",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetSession retrieves a session by its ID
+func (r *PostgreSQLSessionRepository) GetSession(ctx context.Context, id string) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get session",
+			Err:     err,
+		}
+	}
+
+	return session, nil
+}
+
+// ListUserSessions retrieves the sessions of a user that have not been revoked
+func (r *PostgreSQLSessionRepository) ListUserSessions(ctx context.Context, userID int) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list sessions",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan session",
+				Err:     err,
+			}
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list sessions",
+			Err:     err,
+		}
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records activity on a session
+func (r *PostgreSQLSessionRepository) TouchSession(ctx context.Context, id string, seenAt time.Time) error {
+	return r.updateSession(ctx, `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, id, seenAt)
+}
+
+// ExtendSession moves the expiry of a session
+func (r *PostgreSQLSessionRepository) ExtendSession(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.updateSession(ctx, `UPDATE sessions SET expires_at = $2 WHERE id = $1`, id, expiresAt)
+}
+
+// SetSessionOrganization records the organization a session works in
+func (r *PostgreSQLSessionRepository) SetSessionOrganization(ctx context.Context, id string, organizationID int) error {
+	return r.updateSession(ctx, `UPDATE sessions SET organization_id = $2 WHERE id = $1`, id, nullID(organizationID))
+}
+
+// RevokeSession revokes a single session
+func (r *PostgreSQLSessionRepository) RevokeSession(ctx context.Context, id string) error {
+	return r.updateSession(ctx, `UPDATE sessions SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1`, id)
+}
+
+// RevokeUserSessions revokes every session of a user except exceptID
+func (r *PostgreSQLSessionRepository) RevokeUserSessions(ctx context.Context, userID int, exceptID string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userID, exceptID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to revoke user sessions",
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// updateSession runs an update against a single session, reporting
+// ErrSessionNotFound when no row matched
+func (r *PostgreSQLSessionRepository) updateSession(ctx context.Context, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update session",
+			Err:     err,
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// scanSession scans a session row
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	var session Session
+	var userAgent, ipAddress sql.NullString
+	var revokedAt sql.NullTime
+	var impersonatorID, organizationID sql.NullInt64
+
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&userAgent,
+		&ipAddress,
+		&session.AuthMethod,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&revokedAt,
+		&impersonatorID,
+		&organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.UserAgent = userAgent.String
+	session.IPAddress = ipAddress.String
+	session.RevokedAt = nullTimePtr(revokedAt)
+	session.ImpersonatorID = int(impersonatorID.Int64)
+	session.OrganizationID = int(organizationID.Int64)
+
+	return &session, nil
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PostgreSQLWebAuthnCredentialRepository implements WebAuthnCredentialRepository using PostgreSQL
+type PostgreSQLWebAuthnCredentialRepository struct {
+	db *sql.DB
+}
+
+const webAuthnCredentialColumns = `id, user_id, credential_id, public_key, algorithm, sign_count, aaguid,
+	attestation_type, transports, name, backup_eligible, backup_state, created_at, last_used_at`
+
+// CreateWebAuthnCredential stores a new credential
+func (r *PostgreSQLWebAuthnCredentialRepository) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) (*WebAuthnCredential, error) {
+	if credential == nil {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "credential cannot be nil"}
+	}
+	if len(credential.CredentialID) == 0 || len(credential.PublicKey) == 0 || credential.UserID <= 0 {
+		return nil, &DatabaseError{Type: "INVALID_INPUT", Message: "credential ID, public key and user are required"}
+	}
+
+	query := `
+		INSERT INTO webauthn_credentials (user_id, credential_id, public_key, algorithm, sign_count, aaguid,
+			attestation_type, transports, name, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING ` + webAuthnCredentialColumns
+
+	created, err := scanWebAuthnCredential(r.db.QueryRowContext(ctx, query,
+		credential.UserID, credential.CredentialID, credential.PublicKey, credential.Algorithm,
+		int64(credential.SignCount), credential.AAGUID, credential.AttestationType,
+		strings.Join(credential.Transports, ","), credential.Name,
+		credential.BackupEligible, credential.BackupState,
+	))
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return nil, ErrWebAuthnCredentialExists
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to create webauthn credential",
+			Err:     err,
+		}
+	}
+
+	return created, nil
+}
+
+// GetWebAuthnCredential retrieves a credential by its authenticator-assigned ID
+func (r *PostgreSQLWebAuthnCredentialRepository) GetWebAuthnCredential(ctx context.Context, credentialID []byte) (*WebAuthnCredential, error) {
+	query := `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE credential_id = $1`
+
+	credential, err := scanWebAuthnCredential(r.db.QueryRowContext(ctx, query, credentialID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get webauthn credential",
+			Err:     err,
+		}
+	}
+
+	return credential, nil
+}
+
+// ListUserWebAuthnCredentials retrieves every credential registered to a user, oldest first
+func (r *PostgreSQLWebAuthnCredentialRepository) ListUserWebAuthnCredentials(ctx context.Context, userID int) ([]*WebAuthnCredential, error) {
+	query := `SELECT ` + webAuthnCredentialColumns + ` FROM webauthn_credentials WHERE user_id = $1 ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to list webauthn credentials",
+			Err:     err,
+		}
+	}
+	defer rows.Close()
+
+	credentials := []*WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, &DatabaseError{
+				Type:    "DATABASE_ERROR",
+				Message: "failed to scan webauthn credential row",
+				Err:     err,
+			}
+		}
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "error iterating webauthn credential rows",
+			Err:     err,
+		}
+	}
+
+	return credentials, nil
+}
+
+// UpdateWebAuthnCredentialUsage records a successful assertion
+func (r *PostgreSQLWebAuthnCredentialRepository) UpdateWebAuthnCredentialUsage(ctx context.Context, id int, signCount uint32, backupState bool, usedAt time.Time) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = $4
+		WHERE id = $1`, id, int64(signCount), backupState, usedAt)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to update webauthn credential",
+			Err:     err,
+		}
+	}
+
+	return requireWebAuthnRow(result)
+}
+
+// DeleteWebAuthnCredential removes one of a user's credentials
+func (r *PostgreSQLWebAuthnCredentialRepository) DeleteWebAuthnCredential(ctx context.Context, userID, id int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to delete webauthn credential",
+			Err:     err,
+		}
+	}
+
+	return requireWebAuthnRow(result)
+}
+
+// requireWebAuthnRow maps an update that touched no rows to ErrWebAuthnCredentialNotFound
+func requireWebAuthnRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DatabaseError{
+			Type:    "DATABASE_ERROR",
+			Message: "failed to get rows affected",
+			Err:     err,
+		}
+	}
+	if rowsAffected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// scanWebAuthnCredential scans a row selected with webAuthnCredentialColumns
+func scanWebAuthnCredential(row interface{ Scan(...interface{}) error }) (*WebAuthnCredential, error) {
+	var credential WebAuthnCredential
+	var signCount int64
+	var transports string
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.Algorithm,
+		&signCount,
+		&credential.AAGUID,
+		&credential.AttestationType,
+		&transports,
+		&credential.Name,
+		&credential.BackupEligible,
+		&credential.BackupState,
+		&credential.CreatedAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.SignCount = uint32(signCount)
+	if transports != "" {
+		credential.Transports = strings.Split(transports, ",")
+	}
+	if lastUsedAt.Valid {
+		credential.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &credential, nil
+}
@@ -40,6 +40,12 @@ type EmailService interface {
 	SendWelcomeEmail(ctx context.Context, to, name string) error
 	SendPasswordResetCode(ctx context.Context, to, code string, securityCtx SecurityContext) error
 	SendEmailVerification(ctx context.Context, to, name, verificationURL string) error
+	SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx SecurityContext) error
+	SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx SecurityContext) error
+	SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx SecurityContext) error
+
+	// Organization emails
+	SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error
 
 	// Security notifications
 	SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx SecurityContext) error
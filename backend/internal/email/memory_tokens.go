@@ -0,0 +1,345 @@
+package email
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/config"
+)
+
+// UserLookup returns the ID of the user with the given email address
+type UserLookup func(email string) (userID int, ok bool)
+
+// MemoryTokenManager keeps email tokens in memory. It mirrors TokenManager
+// for deployments running on the in-memory database.
+type MemoryTokenManager struct {
+	mu           sync.Mutex
+	emailService EmailService
+	lookupUser   UserLookup
+	tokens       []*EmailToken
+	nextID       int
+}
+
+// NewMemoryTokenManager creates a token manager that resolves users with lookupUser
+func NewMemoryTokenManager(emailService EmailService, lookupUser UserLookup) *MemoryTokenManager {
+	return &MemoryTokenManager{
+		emailService: emailService,
+		lookupUser:   lookupUser,
+		nextID:       1,
+	}
+}
+
+// RequestPasswordReset initiates a password reset flow
+func (tm *MemoryTokenManager) RequestPasswordReset(req PasswordResetRequest) error {
+	if err := validateEmailAddress(req.Email); err != nil {
+		return err
+	}
+
+	code, err := generateNumericCode(6)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset code: %w", err)
+	}
+
+	now := time.Now()
+	tm.mu.Lock()
+	if tm.recentRequests(req.Email, TokenTypePasswordReset, now) >= maxTokenRequestsPerHour {
+		tm.mu.Unlock()
+		return ErrRateLimitExceeded
+	}
+
+	// No account has this email, so there is nothing to send
+	userID, ok := tm.lookupUser(req.Email)
+	if !ok {
+		tm.mu.Unlock()
+		return nil
+	}
+
+	tm.tokens = append(tm.tokens, &EmailToken{
+		ID:        tm.nextID,
+		Token:     storedTokenHash(code),
+		UserID:    userID,
+		Email:     req.Email,
+		Type:      TokenTypePasswordReset,
+		ExpiresAt: now.Add(passwordResetTTL),
+		CreatedAt: now,
+		RequestIP: req.RequestIP,
+		UserAgent: req.UserAgent,
+	})
+	tm.nextID++
+	tm.mu.Unlock()
+
+	return tm.emailService.SendPasswordResetCode(nil, req.Email, code, SecurityContext{
+		RequestIP:   req.RequestIP,
+		UserAgent:   req.UserAgent,
+		RequestTime: now,
+	})
+}
+
+// VerifyPasswordResetCode verifies a password reset code against the most
+// recent unused code sent to the email address
+func (tm *MemoryTokenManager) VerifyPasswordResetCode(email, code string) (*EmailToken, error) {
+	if err := validateEmailAddress(email); err != nil {
+		return nil, err
+	}
+
+	if code == "" {
+		return nil, errors.New("code cannot be empty")
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	var latest *EmailToken
+	for _, token := range tm.tokens {
+		if token.Type != TokenTypePasswordReset || token.Used || !strings.EqualFold(token.Email, email) {
+			continue
+		}
+		if latest == nil || !token.CreatedAt.Before(latest.CreatedAt) {
+			latest = token
+		}
+	}
+	if latest == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().After(latest.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(storedTokenHash(code)), []byte(latest.Token)) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	latest.Used = true
+	token := *latest
+	return &token, nil
+}
+
+// RequestEmailVerification emails a link that verifies the user's address
+func (tm *MemoryTokenManager) RequestEmailVerification(req EmailVerificationRequest) error {
+	if err := validateEmailAddress(req.Email); err != nil {
+		return err
+	}
+
+	if req.UserID <= 0 {
+		return errors.New("user ID is required")
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	now := time.Now()
+	tm.mu.Lock()
+	if tm.recentRequests(req.Email, TokenTypeEmailVerification, now) >= maxTokenRequestsPerHour {
+		tm.mu.Unlock()
+		return ErrRateLimitExceeded
+	}
+
+	tm.tokens = append(tm.tokens, &EmailToken{
+		ID:        tm.nextID,
+		Token:     storedTokenHash(token),
+		UserID:    req.UserID,
+		Email:     req.Email,
+		Type:      TokenTypeEmailVerification,
+		ExpiresAt: now.Add(emailVerificationTTL),
+		CreatedAt: now,
+		RequestIP: req.RequestIP,
+		UserAgent: req.UserAgent,
+	})
+	tm.nextID++
+	tm.mu.Unlock()
+
+	verificationURL := config.GetAppConfig().GetVerificationURL(token)
+	return tm.emailService.SendEmailVerification(nil, req.Email, req.Name, verificationURL)
+}
+
+// VerifyEmailToken redeems an email verification token. Unlike TokenManager
+// it has no users table to update, so marking the user verified is left to
+// the caller.
+func (tm *MemoryTokenManager) VerifyEmailToken(token string) (*EmailToken, error) {
+	return tm.redeem(token, TokenTypeEmailVerification)
+}
+
+// RequestMagicLink emails a single-use sign-in link
+func (tm *MemoryTokenManager) RequestMagicLink(req MagicLinkRequest) error {
+	if err := validateEmailAddress(req.Email); err != nil {
+		return err
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate sign-in token: %w", err)
+	}
+
+	now := time.Now()
+	tm.mu.Lock()
+	if tm.recentRequests(req.Email, TokenTypeMagicLink, now) >= maxTokenRequestsPerHour {
+		tm.mu.Unlock()
+		return ErrRateLimitExceeded
+	}
+
+	tm.tokens = append(tm.tokens, &EmailToken{
+		ID:        tm.nextID,
+		Token:     storedTokenHash(token),
+		UserID:    req.UserID,
+		Email:     req.Email,
+		Type:      TokenTypeMagicLink,
+		ExpiresAt: now.Add(magicLinkTTL),
+		CreatedAt: now,
+		RequestIP: req.RequestIP,
+		UserAgent: req.UserAgent,
+	})
+	tm.nextID++
+	tm.mu.Unlock()
+
+	return tm.emailService.SendMagicLink(nil, req.Email, config.GetAppConfig().GetMagicLinkURL(token), SecurityContext{
+		RequestIP:   req.RequestIP,
+		UserAgent:   req.UserAgent,
+		RequestTime: now,
+	})
+}
+
+// VerifyMagicLink redeems a sign-in link
+func (tm *MemoryTokenManager) VerifyMagicLink(token string) (*EmailToken, error) {
+	return tm.redeem(token, TokenTypeMagicLink)
+}
+
+// RequestEmailChange emails a confirmation link to the new address and a
+// notice with a cancel link to the old one. A new request replaces any
+// change still waiting for confirmation.
+func (tm *MemoryTokenManager) RequestEmailChange(req EmailChangeRequest) error {
+	if err := validateEmailAddress(req.NewEmail); err != nil {
+		return err
+	}
+
+	if req.UserID <= 0 {
+		return errors.New("user ID is required")
+	}
+
+	confirmToken, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	cancelToken, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+
+	now := time.Now()
+	tm.mu.Lock()
+	if tm.recentRequests(req.NewEmail, TokenTypeEmailChange, now) >= maxTokenRequestsPerHour {
+		tm.mu.Unlock()
+		return ErrRateLimitExceeded
+	}
+
+	tm.dropPending(req.UserID, TokenTypeEmailChange)
+	for _, token := range []*EmailToken{
+		{Token: storedTokenHash(confirmToken), Email: req.NewEmail, Type: TokenTypeEmailChange, ExpiresAt: now.Add(emailChangeTTL)},
+		{Token: storedTokenHash(cancelToken), Email: req.OldEmail, Type: TokenTypeEmailChangeCancel, ExpiresAt: now.Add(emailChangeCancelTTL)},
+	} {
+		token.ID = tm.nextID
+		token.UserID = req.UserID
+		token.CreatedAt = now
+		token.RequestIP = req.RequestIP
+		token.UserAgent = req.UserAgent
+		tm.tokens = append(tm.tokens, token)
+		tm.nextID++
+	}
+	tm.mu.Unlock()
+
+	return sendEmailChange(tm.emailService, req, confirmToken, cancelToken, now)
+}
+
+// VerifyEmailChange redeems the link sent to the new address
+func (tm *MemoryTokenManager) VerifyEmailChange(token string) (*EmailToken, error) {
+	return tm.redeem(token, TokenTypeEmailChange)
+}
+
+// CancelEmailChange redeems the link sent to the old address and drops any
+// change still waiting for confirmation
+func (tm *MemoryTokenManager) CancelEmailChange(token string) (*EmailToken, error) {
+	cancelled, err := tm.redeem(token, TokenTypeEmailChangeCancel)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.mu.Lock()
+	tm.dropPending(cancelled.UserID, TokenTypeEmailChange)
+	tm.mu.Unlock()
+
+	return cancelled, nil
+}
+
+// CleanupExpiredTokens removes expired tokens and used tokens older than a week
+func (tm *MemoryTokenManager) CleanupExpiredTokens() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	now := time.Now()
+	kept := tm.tokens[:0]
+	for _, token := range tm.tokens {
+		if now.After(token.ExpiresAt) || (token.Used && token.CreatedAt.Before(now.Add(-7*24*time.Hour))) {
+			continue
+		}
+		kept = append(kept, token)
+	}
+	tm.tokens = kept
+	return nil
+}
+
+// redeem marks the unused token of a type matching the plaintext token as
+// used and returns a copy of it
+func (tm *MemoryTokenManager) redeem(token string, tokenType TokenType) (*EmailToken, error) {
+	if token == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	hash := storedTokenHash(token)
+	for _, stored := range tm.tokens {
+		if stored.Type != tokenType || stored.Used ||
+			subtle.ConstantTimeCompare([]byte(hash), []byte(stored.Token)) != 1 {
+			continue
+		}
+
+		if time.Now().After(stored.ExpiresAt) {
+			return nil, ErrTokenExpired
+		}
+
+		stored.Used = true
+		verified := *stored
+		return &verified, nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// dropPending marks a user's unused tokens of a type as used. The caller
+// must hold tm.mu.
+func (tm *MemoryTokenManager) dropPending(userID int, tokenType TokenType) {
+	for _, token := range tm.tokens {
+		if token.Type == tokenType && token.UserID == userID {
+			token.Used = true
+		}
+	}
+}
+
+// recentRequests counts tokens of a type sent to an email address in the
+// last hour. The caller must hold tm.mu.
+func (tm *MemoryTokenManager) recentRequests(email string, tokenType TokenType, now time.Time) int {
+	count := 0
+	for _, token := range tm.tokens {
+		if token.Type == tokenType && strings.EqualFold(token.Email, email) && token.CreatedAt.After(now.Add(-time.Hour)) {
+			count++
+		}
+	}
+	return count
+}
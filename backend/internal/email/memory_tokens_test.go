@@ -0,0 +1,229 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMemoryTokenManager() (*MemoryTokenManager, *MockEmailService) {
+	emailService := &MockEmailService{}
+	tokenManager := NewMemoryTokenManager(emailService, func(email string) (int, bool) {
+		if email == "user@example.com" {
+			return 123, true
+		}
+		return 0, false
+	})
+	return tokenManager, emailService
+}
+
+func TestMemoryTokenManager_PasswordReset(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	if err := tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "user@example.com", RequestIP: "192.168.1.1"}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	if len(emailService.sentEmails) != 1 || emailService.sentEmails[0].Type != "password_reset" {
+		t.Fatalf("Expected one reset email, got %+v", emailService.sentEmails)
+	}
+	code := emailService.sentEmails[0].Code
+
+	if _, err := tokenManager.VerifyPasswordResetCode("user@example.com", "000000x"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected wrong code to be rejected, got %v", err)
+	}
+
+	token, err := tokenManager.VerifyPasswordResetCode("user@example.com", code)
+	if err != nil {
+		t.Fatalf("VerifyPasswordResetCode() error = %v", err)
+	}
+	if token.UserID != 123 || token.Type != TokenTypePasswordReset {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	// Codes are single use
+	if _, err := tokenManager.VerifyPasswordResetCode("user@example.com", code); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected used code to be rejected, got %v", err)
+	}
+}
+
+func TestMemoryTokenManager_UnknownEmailSendsNothing(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	if err := tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	if len(emailService.sentEmails) != 0 {
+		t.Errorf("Expected no email for an unknown address, got %d", len(emailService.sentEmails))
+	}
+}
+
+func TestMemoryTokenManager_OnlyLatestCodeCounts(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "user@example.com"})
+	tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "user@example.com"})
+	first, second := emailService.sentEmails[0].Code, emailService.sentEmails[1].Code
+
+	if first != second {
+		if _, err := tokenManager.VerifyPasswordResetCode("user@example.com", first); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected superseded code to be rejected, got %v", err)
+		}
+	}
+	if _, err := tokenManager.VerifyPasswordResetCode("user@example.com", second); err != nil {
+		t.Errorf("Expected latest code to verify, got %v", err)
+	}
+}
+
+func TestMemoryTokenManager_RateLimitAndExpiry(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	for i := 0; i < maxTokenRequestsPerHour; i++ {
+		if err := tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "user@example.com"}); err != nil {
+			t.Fatalf("RequestPasswordReset() error = %v", err)
+		}
+	}
+	if err := tokenManager.RequestPasswordReset(PasswordResetRequest{Email: "user@example.com"}); !errors.Is(err, ErrRateLimitExceeded) {
+		t.Errorf("Expected rate limit, got %v", err)
+	}
+
+	for _, token := range tokenManager.tokens {
+		token.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+	code := emailService.sentEmails[len(emailService.sentEmails)-1].Code
+	if _, err := tokenManager.VerifyPasswordResetCode("user@example.com", code); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected expired code to be rejected, got %v", err)
+	}
+
+	tokenManager.CleanupExpiredTokens()
+	if len(tokenManager.tokens) != 0 {
+		t.Errorf("Expected expired tokens to be removed, %d left", len(tokenManager.tokens))
+	}
+}
+
+func TestMemoryTokenManager_EmailVerification(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	err := tokenManager.RequestEmailVerification(EmailVerificationRequest{UserID: 123, Email: "user@example.com", Name: "User"})
+	if err != nil {
+		t.Fatalf("RequestEmailVerification() error = %v", err)
+	}
+	if len(emailService.sentEmails) != 1 || emailService.sentEmails[0].Type != "email_verification" {
+		t.Fatalf("Expected one verification email, got %+v", emailService.sentEmails)
+	}
+
+	sentURL := emailService.sentEmails[0].URL
+	verificationToken := sentURL[strings.Index(sentURL, "token=")+len("token="):]
+
+	if _, err := tokenManager.VerifyEmailToken("forged"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected unknown token to be rejected, got %v", err)
+	}
+
+	token, err := tokenManager.VerifyEmailToken(verificationToken)
+	if err != nil {
+		t.Fatalf("VerifyEmailToken() error = %v", err)
+	}
+	if token.UserID != 123 || token.Email != "user@example.com" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	if _, err := tokenManager.VerifyEmailToken(verificationToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected used token to be rejected, got %v", err)
+	}
+}
+
+func TestMemoryTokenManager_MagicLink(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	// Links can be sent to addresses without an account
+	err := tokenManager.RequestMagicLink(MagicLinkRequest{Email: "new@example.com", RequestIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("RequestMagicLink() error = %v", err)
+	}
+	if len(emailService.sentEmails) != 1 || emailService.sentEmails[0].Type != "magic_link" {
+		t.Fatalf("Expected one sign-in email, got %+v", emailService.sentEmails)
+	}
+	if emailService.sentEmails[0].SecurityCtx.RequestIP != "192.168.1.1" {
+		t.Errorf("Expected the request IP in the email, got %+v", emailService.sentEmails[0].SecurityCtx)
+	}
+
+	sentURL := emailService.sentEmails[0].URL
+	magicToken := sentURL[strings.Index(sentURL, "token=")+len("token="):]
+
+	// A sign-in link is not an email verification link
+	if _, err := tokenManager.VerifyEmailToken(magicToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected sign-in token to be rejected for verification, got %v", err)
+	}
+
+	token, err := tokenManager.VerifyMagicLink(magicToken)
+	if err != nil {
+		t.Fatalf("VerifyMagicLink() error = %v", err)
+	}
+	if token.UserID != 0 || token.Email != "new@example.com" || token.Type != TokenTypeMagicLink {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	if _, err := tokenManager.VerifyMagicLink(magicToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected used link to be rejected, got %v", err)
+	}
+}
+
+func TestMemoryTokenManager_EmailChange(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	request := EmailChangeRequest{UserID: 123, OldEmail: "user@example.com", NewEmail: "new@example.com", RequestIP: "192.168.1.1"}
+	if err := tokenManager.RequestEmailChange(request); err != nil {
+		t.Fatalf("RequestEmailChange() error = %v", err)
+	}
+	if len(emailService.sentEmails) != 2 {
+		t.Fatalf("Expected two emails, got %+v", emailService.sentEmails)
+	}
+	confirm, cancel := emailService.sentEmails[0], emailService.sentEmails[1]
+	if confirm.Type != "email_change" || confirm.To != "new@example.com" {
+		t.Errorf("Expected the confirmation to go to the new address, got %+v", confirm)
+	}
+	if cancel.Type != "email_change_cancel" || cancel.To != "user@example.com" {
+		t.Errorf("Expected the cancel link to go to the old address, got %+v", cancel)
+	}
+	confirmToken := confirm.URL[strings.Index(confirm.URL, "token=")+len("token="):]
+	cancelToken := cancel.URL[strings.Index(cancel.URL, "token=")+len("token="):]
+
+	// A cancel link can't confirm the change
+	if _, err := tokenManager.VerifyEmailChange(cancelToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected cancel token to be rejected for confirmation, got %v", err)
+	}
+
+	token, err := tokenManager.VerifyEmailChange(confirmToken)
+	if err != nil {
+		t.Fatalf("VerifyEmailChange() error = %v", err)
+	}
+	if token.UserID != 123 || token.Email != "new@example.com" || token.Type != TokenTypeEmailChange {
+		t.Errorf("Unexpected token %+v", token)
+	}
+
+	// The cancel link still works after the change is confirmed
+	token, err = tokenManager.CancelEmailChange(cancelToken)
+	if err != nil {
+		t.Fatalf("CancelEmailChange() error = %v", err)
+	}
+	if token.UserID != 123 || token.Email != "user@example.com" {
+		t.Errorf("Unexpected token %+v", token)
+	}
+}
+
+func TestMemoryTokenManager_EmailChangeCancelDropsPending(t *testing.T) {
+	tokenManager, emailService := newTestMemoryTokenManager()
+
+	request := EmailChangeRequest{UserID: 123, OldEmail: "user@example.com", NewEmail: "new@example.com"}
+	if err := tokenManager.RequestEmailChange(request); err != nil {
+		t.Fatalf("RequestEmailChange() error = %v", err)
+	}
+	confirmURL, cancelURL := emailService.sentEmails[0].URL, emailService.sentEmails[1].URL
+
+	if _, err := tokenManager.CancelEmailChange(cancelURL[strings.Index(cancelURL, "token=")+len("token="):]); err != nil {
+		t.Fatalf("CancelEmailChange() error = %v", err)
+	}
+	if _, err := tokenManager.VerifyEmailChange(confirmURL[strings.Index(confirmURL, "token=")+len("token="):]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the cancelled change to be rejected, got %v", err)
+	}
+}
@@ -7,7 +7,7 @@ CREATE TABLE IF NOT EXISTS email_tokens (
     token VARCHAR(64) NOT NULL, -- Stores hashed token (SHA-256 = 32 bytes = 64 hex chars)
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
     email VARCHAR(255) NOT NULL,
-    type VARCHAR(50) NOT NULL, -- password_reset, email_verification, magic_link
+    type VARCHAR(50) NOT NULL, -- password_reset, email_verification, magic_link, email_change, email_change_cancel
     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
     used BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
@@ -39,7 +39,7 @@ CREATE INDEX IF NOT EXISTS idx_users_email_verified_at ON users(email_verified_a
 -- Add some constraints for data integrity
 ALTER TABLE email_tokens
 ADD CONSTRAINT chk_email_tokens_type
-CHECK (type IN ('password_reset', 'email_verification', 'magic_link'));
+CHECK (type IN ('password_reset', 'email_verification', 'magic_link', 'email_change', 'email_change_cancel'));
 
 -- Add constraint to ensure expires_at is in the future when created
 ALTER TABLE email_tokens
@@ -67,7 +67,7 @@ $$ LANGUAGE plpgsql;
 -- Add comments for documentation
 COMMENT ON TABLE email_tokens IS 'Stores secure tokens for email-based authentication flows';
 COMMENT ON COLUMN email_tokens.token IS 'Hashed token (SHA-256) - never store plaintext tokens';
-COMMENT ON COLUMN email_tokens.type IS 'Type of token: password_reset, email_verification, magic_link';
+COMMENT ON COLUMN email_tokens.type IS 'Type of token: password_reset, email_verification, magic_link, email_change, email_change_cancel';
 COMMENT ON COLUMN email_tokens.expires_at IS 'Token expiration time - tokens are invalid after this time';
 COMMENT ON COLUMN email_tokens.used IS 'Whether the token has been consumed (single-use tokens)';
 COMMENT ON COLUMN email_tokens.request_ip IS 'IP address of the request that generated this token';
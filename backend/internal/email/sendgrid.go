@@ -5,17 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 	"time"
 
 	"github.com/danielsaas/generic-saas/internal/config"
 )
 
+// sendGridEndpoint is the SendGrid v3 mail send API
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
 // SendGridService implements EmailService for SendGrid
 type SendGridService struct {
 	apiKey    string
 	fromEmail string
 	fromName  string
+	endpoint  string
 	client    *http.Client
 }
 
@@ -52,6 +57,7 @@ func NewSendGridService(config *Config) (*SendGridService, error) {
 		apiKey:    config.SendGridAPIKey,
 		fromEmail: config.FromEmail,
 		fromName:  config.FromName,
+		endpoint:  sendGridEndpoint,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -97,7 +103,7 @@ func (s *SendGridService) SendEmail(ctx context.Context, email *Email) error {
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.sendgrid.com/v3/mail/send", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -164,6 +170,67 @@ func (s *SendGridService) SendEmailVerification(ctx context.Context, to, name, v
 	return s.SendEmail(ctx, email)
 }
 
+// SendMagicLink sends a single-use sign-in link
+func (s *SendGridService) SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetMagicLinkSubject()
+	body := s.buildMagicLinkTemplate(magicLinkURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeConfirmation sends the link that confirms a new email address
+func (s *SendGridService) SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeConfirmSubject()
+	body := s.buildEmailChangeConfirmationTemplate(confirmURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeNotice tells the old address about an email change, with a
+// link to cancel it
+func (s *SendGridService) SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeNoticeSubject()
+	body := s.buildEmailChangeNoticeTemplate(newEmail, cancelURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendInvitation invites someone to join an organization
+func (s *SendGridService) SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error {
+	subject := config.GetAppConfig().GetInvitationSubject(organizationName)
+	body := s.buildInvitationTemplate(inviterName, organizationName, inviteURL)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
 // SendSecurityAlert sends a security alert notification
 func (s *SendGridService) SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx SecurityContext) error {
 	subject := config.GetAppConfig().GetSecurityAlertSubject()
@@ -329,6 +396,208 @@ func (s *SendGridService) buildEmailVerificationTemplate(name, verificationURL s
 `, name, verificationURL, verificationURL, verificationURL)
 }
 
+// buildMagicLinkTemplate creates a sign-in link template
+func (s *SendGridService) buildMagicLinkTemplate(magicLinkURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Sign In</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <div style="text-align: center; margin-bottom: 30px;">
+        <h1 style="color: #1f2937;">Sign In to SaaSPlatform</h1>
+    </div>
+
+    <div style="background: #f9fafb; padding: 20px; border-radius: 8px; margin-bottom: 20px;">
+        <p style="color: #4b5563; line-height: 1.6;">
+            We received a request to sign in with this email address. Click the button below to continue:
+        </p>
+    </div>
+
+    <div style="text-align: center; margin: 30px 0;">
+        <a href="%s"
+           style="background: #3b82f6; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+            Sign In
+        </a>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <p style="color: #4b5563; margin: 0; line-height: 1.6; font-size: 14px;">
+            If you can't click the button, copy and paste this link into your browser:<br>
+            <a href="%s" style="color: #3b82f6; word-break: break-all;">%s</a>
+        </p>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <h3 style="color: #374151; margin-top: 0;">Security Information:</h3>
+        <ul style="color: #4b5563; margin: 0; padding-left: 20px;">
+            <li>Request from IP: %s</li>
+            <li>Time: %s</li>
+        </ul>
+    </div>
+
+    <hr style="border: none; border-top: 1px solid #e5e7eb; margin: 30px 0;">
+
+    <p style="color: #6b7280; font-size: 12px; text-align: center;">
+        This link expires in 15 minutes and can only be used once. If you didn't ask to sign in, please ignore this email.
+    </p>
+</body>
+</html>
+`, magicLinkURL, magicLinkURL, magicLinkURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+// buildEmailChangeConfirmationTemplate creates a new address confirmation template
+func (s *SendGridService) buildEmailChangeConfirmationTemplate(confirmURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Confirm Your New Email</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <div style="text-align: center; margin-bottom: 30px;">
+        <h1 style="color: #1f2937;">Confirm Your New Email</h1>
+    </div>
+
+    <div style="background: #f9fafb; padding: 20px; border-radius: 8px; margin-bottom: 20px;">
+        <p style="color: #4b5563; line-height: 1.6;">
+            We received a request to use this email address for a SaaSPlatform account. Click the button below to confirm it:
+        </p>
+    </div>
+
+    <div style="text-align: center; margin: 30px 0;">
+        <a href="%s"
+           style="background: #3b82f6; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+            Confirm Email
+        </a>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <p style="color: #4b5563; margin: 0; line-height: 1.6; font-size: 14px;">
+            If you can't click the button, copy and paste this link into your browser:<br>
+            <a href="%s" style="color: #3b82f6; word-break: break-all;">%s</a>
+        </p>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <h3 style="color: #374151; margin-top: 0;">Security Information:</h3>
+        <ul style="color: #4b5563; margin: 0; padding-left: 20px;">
+            <li>Request from IP: %s</li>
+            <li>Time: %s</li>
+        </ul>
+    </div>
+
+    <hr style="border: none; border-top: 1px solid #e5e7eb; margin: 30px 0;">
+
+    <p style="color: #6b7280; font-size: 12px; text-align: center;">
+        This link expires in 24 hours and can only be used once. If you didn't ask for this, please ignore this email.
+    </p>
+</body>
+</html>
+`, confirmURL, confirmURL, confirmURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+// buildEmailChangeNoticeTemplate creates an email change notice template
+func (s *SendGridService) buildEmailChangeNoticeTemplate(newEmail, cancelURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Email Change Requested</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <div style="text-align: center; margin-bottom: 30px;">
+        <h1 style="color: #1f2937;">Email Change Requested</h1>
+    </div>
+
+    <div style="background: #fef3c7; padding: 20px; border-radius: 8px; margin-bottom: 20px; border-left: 4px solid #f59e0b;">
+        <p style="color: #92400e; line-height: 1.6; margin: 0;">
+            Someone asked to change the email address of your SaaSPlatform account to <strong>%s</strong>.
+            It changes once the new address is confirmed.
+        </p>
+    </div>
+
+    <div style="text-align: center; margin: 30px 0;">
+        <a href="%s"
+           style="background: #ef4444; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+            Cancel Change
+        </a>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <p style="color: #4b5563; margin: 0; line-height: 1.6; font-size: 14px;">
+            If you can't click the button, copy and paste this link into your browser:<br>
+            <a href="%s" style="color: #3b82f6; word-break: break-all;">%s</a>
+        </p>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <h3 style="color: #374151; margin-top: 0;">Security Information:</h3>
+        <ul style="color: #4b5563; margin: 0; padding-left: 20px;">
+            <li>Request from IP: %s</li>
+            <li>Time: %s</li>
+        </ul>
+    </div>
+
+    <hr style="border: none; border-top: 1px solid #e5e7eb; margin: 30px 0;">
+
+    <p style="color: #6b7280; font-size: 12px; text-align: center;">
+        If this was you, no action is needed. The cancel link works for 7 days, even after the change went through.
+    </p>
+</body>
+</html>
+`, newEmail, cancelURL, cancelURL, cancelURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+// buildInvitationTemplate creates an organization invitation template. The
+// names are chosen by users, so they are escaped.
+func (s *SendGridService) buildInvitationTemplate(inviterName, organizationName, inviteURL string) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>You're Invited</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <div style="text-align: center; margin-bottom: 30px;">
+        <h1 style="color: #1f2937;">You're Invited</h1>
+    </div>
+
+    <div style="background: #f9fafb; padding: 20px; border-radius: 8px; margin-bottom: 20px;">
+        <p style="color: #4b5563; line-height: 1.6;">
+            %s invited you to join <strong>%s</strong> on %s. Click the button below to accept:
+        </p>
+    </div>
+
+    <div style="text-align: center; margin: 30px 0;">
+        <a href="%s"
+           style="background: #3b82f6; color: white; padding: 12px 24px; text-decoration: none; border-radius: 6px; display: inline-block;">
+            Accept Invitation
+        </a>
+    </div>
+
+    <div style="background: #f3f4f6; padding: 15px; border-radius: 6px; margin-bottom: 20px;">
+        <p style="color: #4b5563; margin: 0; line-height: 1.6; font-size: 14px;">
+            If you can't click the button, copy and paste this link into your browser:<br>
+            <a href="%s" style="color: #3b82f6; word-break: break-all;">%s</a>
+        </p>
+    </div>
+
+    <hr style="border: none; border-top: 1px solid #e5e7eb; margin: 30px 0;">
+
+    <p style="color: #6b7280; font-size: 12px; text-align: center;">
+        This invitation can only be used once. If you weren't expecting it, you can ignore this email.
+    </p>
+</body>
+</html>
+`, html.EscapeString(inviterName), html.EscapeString(organizationName), config.GetAppConfig().AppDisplayName, inviteURL, inviteURL, inviteURL)
+}
+
 // buildSecurityAlertTemplate creates a security alert template
 func (s *SendGridService) buildSecurityAlertTemplate(alertMessage string, securityCtx SecurityContext) string {
 	return fmt.Sprintf(`
@@ -2,6 +2,9 @@ package email
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -169,6 +172,66 @@ func TestSendGridServiceMethods(t *testing.T) {
 	})
 }
 
+func TestSendGridServiceSendMagicLink(t *testing.T) {
+	var received sendGridEmail
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	service, err := NewSendGridService(&Config{
+		SendGridAPIKey: "test-api-key",
+		FromEmail:      "test@example.com",
+		FromName:       "Test Service",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SendGrid service: %v", err)
+	}
+	service.endpoint = server.URL
+
+	securityCtx := SecurityContext{
+		RequestIP:   "192.168.1.100",
+		UserAgent:   "Test User Agent",
+		RequestTime: time.Now(),
+	}
+
+	magicLinkURL := "https://example.com/magic-link?token=abc123"
+	if err := service.SendMagicLink(context.Background(), "user@example.com", magicLinkURL, securityCtx); err != nil {
+		t.Fatalf("SendMagicLink() failed: %v", err)
+	}
+
+	if authorization != "Bearer test-api-key" {
+		t.Errorf("Authorization = %q, want the API key as a bearer token", authorization)
+	}
+	if len(received.Personalizations) != 1 || len(received.Personalizations[0].To) != 1 || received.Personalizations[0].To[0].Email != "user@example.com" {
+		t.Errorf("recipients = %+v, want user@example.com", received.Personalizations)
+	}
+	if received.From.Email != "test@example.com" {
+		t.Errorf("from = %q, want test@example.com", received.From.Email)
+	}
+	if len(received.Content) != 1 || !strings.Contains(received.Content[0].Value, magicLinkURL) {
+		t.Error("email body should contain the magic link")
+	}
+
+	t.Run("API error", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer failing.Close()
+		service.endpoint = failing.URL
+
+		err := service.SendMagicLink(context.Background(), "user@example.com", magicLinkURL, securityCtx)
+		if err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("SendMagicLink() error = %v, want a 401 API error", err)
+		}
+	})
+}
+
 func TestSendGridTemplateBuilders(t *testing.T) {
 	config := &Config{
 		SendGridAPIKey: "test-api-key",
@@ -232,6 +295,36 @@ func TestSendGridTemplateBuilders(t *testing.T) {
 		}
 	})
 
+	t.Run("magic link template", func(t *testing.T) {
+		magicLinkURL := "https://example.com/magic-link?token=abc123"
+		securityCtx := SecurityContext{
+			RequestIP:   "192.168.1.100",
+			RequestTime: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+		}
+
+		template := service.buildMagicLinkTemplate(magicLinkURL, securityCtx)
+
+		if count := strings.Count(template, magicLinkURL); count < 2 {
+			t.Errorf("magic link URL should appear at least twice, found %d times", count)
+		}
+		if !strings.Contains(template, "192.168.1.100") {
+			t.Error("magic link template should contain request IP")
+		}
+	})
+
+	t.Run("invitation template", func(t *testing.T) {
+		inviteURL := "https://example.com/invitations/accept?token=abc123"
+
+		template := service.buildInvitationTemplate("John Doe", "Acme & Co", inviteURL)
+
+		if count := strings.Count(template, inviteURL); count < 2 {
+			t.Errorf("invitation URL should appear at least twice, found %d times", count)
+		}
+		if !strings.Contains(template, "John Doe") || !strings.Contains(template, "Acme &amp; Co") {
+			t.Error("invitation template should contain the escaped inviter and organization names")
+		}
+	})
+
 	t.Run("security alert template", func(t *testing.T) {
 		alertMessage := "Suspicious login detected"
 		securityCtx := SecurityContext{
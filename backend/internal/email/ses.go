@@ -3,6 +3,7 @@ package email
 import (
 	"context"
 	"fmt"
+	"html"
 
 	"github.com/danielsaas/generic-saas/internal/config"
 )
@@ -103,6 +104,67 @@ func (s *SESService) SendEmailVerification(ctx context.Context, to, name, verifi
 	return s.SendEmail(ctx, email)
 }
 
+// SendMagicLink sends a single-use sign-in link
+func (s *SESService) SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetMagicLinkSubject()
+	body := s.buildMagicLinkTemplate(magicLinkURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeConfirmation sends the link that confirms a new email address
+func (s *SESService) SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeConfirmSubject()
+	body := s.buildEmailChangeConfirmationTemplate(confirmURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeNotice tells the old address about an email change, with a
+// link to cancel it
+func (s *SESService) SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeNoticeSubject()
+	body := s.buildEmailChangeNoticeTemplate(newEmail, cancelURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendInvitation invites someone to join an organization
+func (s *SESService) SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error {
+	subject := config.GetAppConfig().GetInvitationSubject(organizationName)
+	body := s.buildInvitationTemplate(inviterName, organizationName, inviteURL)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
 // SendSecurityAlert sends a security alert notification
 func (s *SESService) SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx SecurityContext) error {
 	subject := config.GetAppConfig().GetSecurityAlertSubject()
@@ -182,6 +244,86 @@ func (s *SESService) buildEmailVerificationTemplate(name, verificationURL string
 `, name, verificationURL, verificationURL)
 }
 
+func (s *SESService) buildMagicLinkTemplate(magicLinkURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Sign In</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Sign In</h1>
+    <p>Click the button below to sign in:</p>
+    <p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #007bff; color: white; text-decoration: none; border-radius: 5px;">Sign In</a></p>
+    <p>If you can't click the button, copy and paste this link: %s</p>
+    <p>This link expires in 15 minutes and can only be used once.</p>
+    <p><small>Request from IP: %s at %s</small></p>
+</body>
+</html>
+`, magicLinkURL, magicLinkURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SESService) buildEmailChangeConfirmationTemplate(confirmURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Confirm Your New Email</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Confirm Your New Email</h1>
+    <p>Click the button below to use this address for your account:</p>
+    <p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #007bff; color: white; text-decoration: none; border-radius: 5px;">Confirm Email</a></p>
+    <p>If you can't click the button, copy and paste this link: %s</p>
+    <p>This link expires in 24 hours and can only be used once.</p>
+    <p><small>Request from IP: %s at %s</small></p>
+</body>
+</html>
+`, confirmURL, confirmURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SESService) buildEmailChangeNoticeTemplate(newEmail, cancelURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Email Change Requested</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Email Change Requested</h1>
+    <p>Someone asked to change the email address of your account to %s. It changes once the new address is confirmed.</p>
+    <p>If this wasn't you, click the button below to cancel the change:</p>
+    <p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #dc3545; color: white; text-decoration: none; border-radius: 5px;">Cancel Change</a></p>
+    <p>If you can't click the button, copy and paste this link: %s</p>
+    <p>This link works for 7 days, even after the change went through.</p>
+    <p><small>Request from IP: %s at %s</small></p>
+</body>
+</html>
+`, newEmail, cancelURL, cancelURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SESService) buildInvitationTemplate(inviterName, organizationName, inviteURL string) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>You're Invited</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>You're Invited</h1>
+    <p>%s invited you to join %s on %s. Click the button below to accept:</p>
+    <p><a href="%s" style="display: inline-block; padding: 10px 20px; background: #007bff; color: white; text-decoration: none; border-radius: 5px;">Accept Invitation</a></p>
+    <p>If you can't click the button, copy and paste this link: %s</p>
+    <p>This invitation can only be used once. If you weren't expecting it, ignore this email.</p>
+</body>
+</html>
+`, html.EscapeString(inviterName), html.EscapeString(organizationName), config.GetAppConfig().AppDisplayName, inviteURL, inviteURL)
+}
+
 func (s *SESService) buildSecurityAlertTemplate(alertMessage string, securityCtx SecurityContext) string {
 	return fmt.Sprintf(`
 <!DOCTYPE html>
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"html"
 	"net"
 	"net/smtp"
 	"strings"
@@ -125,6 +126,67 @@ func (s *SMTPService) SendEmailVerification(ctx context.Context, to, name, verif
 	return s.SendEmail(ctx, email)
 }
 
+// SendMagicLink sends a single-use sign-in link
+func (s *SMTPService) SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetMagicLinkSubject()
+	body := s.buildMagicLinkTemplate(magicLinkURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeConfirmation sends the link that confirms a new email address
+func (s *SMTPService) SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeConfirmSubject()
+	body := s.buildEmailChangeConfirmationTemplate(confirmURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendEmailChangeNotice tells the old address about an email change, with a
+// link to cancel it
+func (s *SMTPService) SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx SecurityContext) error {
+	subject := config.GetAppConfig().GetEmailChangeNoticeSubject()
+	body := s.buildEmailChangeNoticeTemplate(newEmail, cancelURL, securityCtx)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
+// SendInvitation invites someone to join an organization
+func (s *SMTPService) SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error {
+	subject := config.GetAppConfig().GetInvitationSubject(organizationName)
+	body := s.buildInvitationTemplate(inviterName, organizationName, inviteURL)
+
+	email := &Email{
+		To:      to,
+		From:    s.fromEmail,
+		Subject: subject,
+		Body:    body,
+	}
+
+	return s.SendEmail(ctx, email)
+}
+
 // SendSecurityAlert sends a security alert notification
 func (s *SMTPService) SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx SecurityContext) error {
 	subject := config.GetAppConfig().GetSecurityAlertSubject()
@@ -228,6 +290,83 @@ func (s *SMTPService) buildEmailVerificationTemplate(name, verificationURL strin
 `, name, verificationURL, verificationURL)
 }
 
+func (s *SMTPService) buildMagicLinkTemplate(magicLinkURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Sign In</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Sign In</h1>
+    <p>Click this link to sign in. It expires in 15 minutes and works once:</p>
+    <p><a href="%s">Sign In</a></p>
+    <p>If you can't click the link, copy and paste: %s</p>
+    <p><small>Requested from IP: %s at %s. If you didn't ask to sign in, ignore this email.</small></p>
+</body>
+</html>
+`, magicLinkURL, magicLinkURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SMTPService) buildEmailChangeConfirmationTemplate(confirmURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Confirm Your New Email</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Confirm Your New Email</h1>
+    <p>Click this link to use this address for your account. It expires in 24 hours and works once:</p>
+    <p><a href="%s">Confirm Email</a></p>
+    <p>If you can't click the link, copy and paste: %s</p>
+    <p><small>Requested from IP: %s at %s. If you didn't ask for this, ignore this email.</small></p>
+</body>
+</html>
+`, confirmURL, confirmURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SMTPService) buildEmailChangeNoticeTemplate(newEmail, cancelURL string, securityCtx SecurityContext) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Email Change Requested</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>Email Change Requested</h1>
+    <p>Someone asked to change the email address of your account to %s. It changes once the new address is confirmed.</p>
+    <p>If this wasn't you, cancel the change. The link works for 7 days, even after the change went through:</p>
+    <p><a href="%s">Cancel Change</a></p>
+    <p>If you can't click the link, copy and paste: %s</p>
+    <p><small>Requested from IP: %s at %s</small></p>
+</body>
+</html>
+`, newEmail, cancelURL, cancelURL, securityCtx.RequestIP, securityCtx.RequestTime.Format("2006-01-02 15:04:05 UTC"))
+}
+
+func (s *SMTPService) buildInvitationTemplate(inviterName, organizationName, inviteURL string) string {
+	return fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>You're Invited</title>
+</head>
+<body style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto; padding: 20px;">
+    <h1>You're Invited</h1>
+    <p>%s invited you to join %s on %s. Click this link to accept. It works once:</p>
+    <p><a href="%s">Accept Invitation</a></p>
+    <p>If you can't click the link, copy and paste: %s</p>
+    <p><small>If you weren't expecting this invitation, ignore this email.</small></p>
+</body>
+</html>
+`, html.EscapeString(inviterName), html.EscapeString(organizationName), config.GetAppConfig().AppDisplayName, inviteURL, inviteURL)
+}
+
 func (s *SMTPService) buildSecurityAlertTemplate(alertMessage string, securityCtx SecurityContext) string {
 	return fmt.Sprintf(`
 <!DOCTYPE html>
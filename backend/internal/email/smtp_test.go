@@ -155,6 +155,72 @@ func TestSMTPServiceSendEmailVerification(t *testing.T) {
 	}
 }
 
+func TestSMTPServiceSendMagicLink(t *testing.T) {
+	service := &SMTPService{
+		fromEmail: "test@example.com",
+		fromName:  "Test Service",
+	}
+
+	securityCtx := SecurityContext{
+		RequestIP:   "192.168.1.100",
+		UserAgent:   "Test User Agent",
+		RequestTime: time.Now(),
+	}
+
+	ctx := context.Background()
+	err := service.SendMagicLink(ctx, "user@example.com", "https://example.com/magic-link?token=abc123", securityCtx)
+
+	if err != nil {
+		t.Errorf("SendMagicLink() failed: %v", err)
+	}
+}
+
+func TestSMTPServiceSendEmailChange(t *testing.T) {
+	service := &SMTPService{
+		fromEmail: "test@example.com",
+		fromName:  "Test Service",
+	}
+
+	securityCtx := SecurityContext{
+		RequestIP:   "192.168.1.100",
+		UserAgent:   "Test User Agent",
+		RequestTime: time.Now(),
+	}
+
+	ctx := context.Background()
+	if err := service.SendEmailChangeConfirmation(ctx, "new@example.com", "https://example.com/email-change/confirm?token=abc123", securityCtx); err != nil {
+		t.Errorf("SendEmailChangeConfirmation() failed: %v", err)
+	}
+
+	if err := service.SendEmailChangeNotice(ctx, "user@example.com", "new@example.com", "https://example.com/email-change/cancel?token=def456", securityCtx); err != nil {
+		t.Errorf("SendEmailChangeNotice() failed: %v", err)
+	}
+
+	body := service.buildEmailChangeNoticeTemplate("new@example.com", "https://example.com/email-change/cancel?token=def456", securityCtx)
+	if !strings.Contains(body, "new@example.com") || !strings.Contains(body, "token=def456") {
+		t.Error("Expected the notice to name the new address and include the cancel link")
+	}
+}
+
+func TestSMTPServiceSendInvitation(t *testing.T) {
+	service := &SMTPService{
+		fromEmail: "test@example.com",
+		fromName:  "Test Service",
+	}
+
+	if err := service.SendInvitation(context.Background(), "new@example.com", "John Doe", "Acme", "https://example.com/invitations/accept?token=abc123"); err != nil {
+		t.Errorf("SendInvitation() failed: %v", err)
+	}
+
+	body := service.buildInvitationTemplate("John Doe", "<b>Acme</b>", "https://example.com/invitations/accept?token=abc123")
+	if !strings.Contains(body, "John Doe") || !strings.Contains(body, "token=abc123") {
+		t.Error("Expected the invitation to name the inviter and include the link")
+	}
+	if strings.Contains(body, "<b>Acme</b>") || !strings.Contains(body, "&lt;b&gt;Acme&lt;/b&gt;") {
+		t.Error("Expected the organization name to be escaped")
+	}
+}
+
 func TestSMTPServiceSendSecurityAlert(t *testing.T) {
 	service := &SMTPService{
 		fromEmail: "test@example.com",
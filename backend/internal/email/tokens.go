@@ -3,6 +3,7 @@ package email
 import (
 	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -17,6 +18,31 @@ const (
 	TokenTypePasswordReset    TokenType = "password_reset"
 	TokenTypeEmailVerification TokenType = "email_verification"
 	TokenTypeMagicLink        TokenType = "magic_link"
+	TokenTypeEmailChange      TokenType = "email_change"
+	TokenTypeEmailChangeCancel TokenType = "email_change_cancel"
+)
+
+const (
+	// passwordResetTTL is how long an emailed reset code stays valid
+	passwordResetTTL = 15 * time.Minute
+
+	// emailVerificationTTL is how long an email verification link stays valid
+	emailVerificationTTL = 48 * time.Hour
+
+	// magicLinkTTL is how long an emailed sign-in link stays valid
+	magicLinkTTL = 15 * time.Minute
+
+	// emailChangeTTL is how long the link confirming a new address stays valid
+	emailChangeTTL = 24 * time.Hour
+
+	// emailChangeCancelTTL is how long the old address can undo a change,
+	// which outlasts the confirmation so a change can be reverted after it
+	// went through
+	emailChangeCancelTTL = 7 * 24 * time.Hour
+
+	// maxTokenRequestsPerHour limits how many tokens of one type an email
+	// address can be sent in an hour
+	maxTokenRequestsPerHour = 3
 )
 
 // EmailToken represents a token stored in the database
@@ -49,6 +75,25 @@ type EmailVerificationRequest struct {
 	UserAgent string
 }
 
+// MagicLinkRequest represents a request for a sign-in link. UserID is zero
+// when the address has no account yet and signing in will create one.
+type MagicLinkRequest struct {
+	UserID    int
+	Email     string
+	RequestIP string
+	UserAgent string
+}
+
+// EmailChangeRequest represents a request to move an account to a new
+// email address
+type EmailChangeRequest struct {
+	UserID    int
+	OldEmail  string
+	NewEmail  string
+	RequestIP string
+	UserAgent string
+}
+
 // TokenManager manages email tokens with security best practices
 type TokenManager struct {
 	db           *sql.DB
@@ -81,22 +126,24 @@ func (tm *TokenManager) RequestPasswordReset(req PasswordResetRequest) error {
 		return fmt.Errorf("failed to generate reset code: %w", err)
 	}
 
-	// Hash the code for storage
-	hashedCode := hashToken(code)
-
 	// Store in database
-	expiresAt := time.Now().Add(15 * time.Minute) // 15-minute expiration
-	_, err = tm.db.Exec(`
+	expiresAt := time.Now().Add(passwordResetTTL)
+	result, err := tm.db.Exec(`
 		INSERT INTO email_tokens (token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent)
 		SELECT $1, u.id, $2, $3, $4, false, $5, $6, $7
 		FROM users u
 		WHERE u.email = $2
-	`, string(hashedCode[:]), req.Email, TokenTypePasswordReset, expiresAt, time.Now(), req.RequestIP, req.UserAgent)
+	`, storedTokenHash(code), req.Email, TokenTypePasswordReset, expiresAt, time.Now(), req.RequestIP, req.UserAgent)
 
 	if err != nil {
 		return fmt.Errorf("failed to store password reset token: %w", err)
 	}
 
+	// No account has this email, so there is nothing to send
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return nil
+	}
+
 	// Send email with code
 	securityCtx := SecurityContext{
 		RequestIP:   req.RequestIP,
@@ -118,9 +165,6 @@ func (tm *TokenManager) VerifyPasswordResetCode(email, code string) (*EmailToken
 		return nil, errors.New("code cannot be empty")
 	}
 
-	// Hash the provided code for comparison
-	providedHash := hashToken(code)
-
 	// Look up the token in the database
 	var token EmailToken
 	err := tm.db.QueryRow(`
@@ -148,8 +192,7 @@ func (tm *TokenManager) VerifyPasswordResetCode(email, code string) (*EmailToken
 	}
 
 	// Verify token using constant-time comparison
-	storedHash := []byte(token.Token)
-	if subtle.ConstantTimeCompare(providedHash[:], storedHash) != 1 {
+	if subtle.ConstantTimeCompare([]byte(storedTokenHash(code)), []byte(token.Token)) != 1 {
 		return nil, ErrInvalidToken
 	}
 
@@ -182,15 +225,12 @@ func (tm *TokenManager) RequestEmailVerification(req EmailVerificationRequest) e
 		return fmt.Errorf("failed to generate verification token: %w", err)
 	}
 
-	// Hash token for storage
-	hashedToken := hashToken(token)
-
 	// Store in database
-	expiresAt := time.Now().Add(48 * time.Hour) // 48-hour expiration
+	expiresAt := time.Now().Add(emailVerificationTTL)
 	_, err = tm.db.Exec(`
 		INSERT INTO email_tokens (token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent)
 		VALUES ($1, $2, $3, $4, $5, false, $6, $7, $8)
-	`, string(hashedToken[:]), req.UserID, req.Email, TokenTypeEmailVerification, expiresAt, time.Now(), req.RequestIP, req.UserAgent)
+	`, storedTokenHash(token), req.UserID, req.Email, TokenTypeEmailVerification, expiresAt, time.Now(), req.RequestIP, req.UserAgent)
 
 	if err != nil {
 		return fmt.Errorf("failed to store verification token: %w", err)
@@ -209,9 +249,6 @@ func (tm *TokenManager) VerifyEmailToken(token string) (*EmailToken, error) {
 		return nil, errors.New("token cannot be empty")
 	}
 
-	// Hash the provided token for comparison
-	providedHash := hashToken(token)
-
 	// Look up the token in the database
 	var emailToken EmailToken
 	err := tm.db.QueryRow(`
@@ -219,7 +256,7 @@ func (tm *TokenManager) VerifyEmailToken(token string) (*EmailToken, error) {
 		FROM email_tokens
 		WHERE token = $1 AND type = $2 AND used = false
 		LIMIT 1
-	`, string(providedHash[:]), TokenTypeEmailVerification).Scan(
+	`, storedTokenHash(token), TokenTypeEmailVerification).Scan(
 		&emailToken.ID, &emailToken.Token, &emailToken.UserID, &emailToken.Email,
 		&emailToken.Type, &emailToken.ExpiresAt, &emailToken.Used, &emailToken.CreatedAt,
 		&emailToken.RequestIP, &emailToken.UserAgent,
@@ -258,6 +295,244 @@ func (tm *TokenManager) VerifyEmailToken(token string) (*EmailToken, error) {
 	return &emailToken, nil
 }
 
+// RequestMagicLink emails a single-use sign-in link
+func (tm *TokenManager) RequestMagicLink(req MagicLinkRequest) error {
+	if err := validateEmailAddress(req.Email); err != nil {
+		return err
+	}
+
+	if err := tm.checkRateLimit(req.Email, TokenTypeMagicLink); err != nil {
+		return err
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate sign-in token: %w", err)
+	}
+
+	// A link for an address without an account has no user yet
+	userID := sql.NullInt64{Int64: int64(req.UserID), Valid: req.UserID > 0}
+
+	now := time.Now()
+	_, err = tm.db.Exec(`
+		INSERT INTO email_tokens (token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, false, $6, $7, $8)
+	`, storedTokenHash(token), userID, req.Email, TokenTypeMagicLink, now.Add(magicLinkTTL), now, req.RequestIP, req.UserAgent)
+
+	if err != nil {
+		return fmt.Errorf("failed to store sign-in token: %w", err)
+	}
+
+	securityCtx := SecurityContext{
+		RequestIP:   req.RequestIP,
+		UserAgent:   req.UserAgent,
+		RequestTime: now,
+	}
+
+	return tm.emailService.SendMagicLink(nil, req.Email, config.GetAppConfig().GetMagicLinkURL(token), securityCtx)
+}
+
+// VerifyMagicLink redeems a sign-in link. The token's UserID is zero if
+// the address had no account when the link was sent.
+func (tm *TokenManager) VerifyMagicLink(token string) (*EmailToken, error) {
+	if token == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+
+	var emailToken EmailToken
+	var userID sql.NullInt64
+	err := tm.db.QueryRow(`
+		SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent
+		FROM email_tokens
+		WHERE token = $1 AND type = $2 AND used = false
+		LIMIT 1
+	`, storedTokenHash(token), TokenTypeMagicLink).Scan(
+		&emailToken.ID, &emailToken.Token, &userID, &emailToken.Email,
+		&emailToken.Type, &emailToken.ExpiresAt, &emailToken.Used, &emailToken.CreatedAt,
+		&emailToken.RequestIP, &emailToken.UserAgent,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to lookup token: %w", err)
+	}
+	emailToken.UserID = int(userID.Int64)
+
+	if time.Now().After(emailToken.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	// Claim the token in the same statement that checks it is unused, so two
+	// concurrent clicks can't both sign in
+	result, err := tm.db.Exec(`
+		UPDATE email_tokens SET used = true WHERE id = $1 AND used = false
+	`, emailToken.ID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark token as used: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return &emailToken, nil
+}
+
+// RequestEmailChange emails a confirmation link to the new address and a
+// notice with a cancel link to the old one. A new request replaces any
+// change still waiting for confirmation.
+func (tm *TokenManager) RequestEmailChange(req EmailChangeRequest) error {
+	if err := validateEmailAddress(req.NewEmail); err != nil {
+		return err
+	}
+
+	if req.UserID <= 0 {
+		return errors.New("user ID is required")
+	}
+
+	if err := tm.checkRateLimit(req.NewEmail, TokenTypeEmailChange); err != nil {
+		return err
+	}
+
+	confirmToken, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	cancelToken, err := generateSecureToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+
+	tx, err := tm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to store email change tokens: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE email_tokens SET used = true WHERE user_id = $1 AND type = $2 AND used = false
+	`, req.UserID, TokenTypeEmailChange)
+
+	if err != nil {
+		return fmt.Errorf("failed to replace pending email change: %w", err)
+	}
+
+	now := time.Now()
+	insert := `
+		INSERT INTO email_tokens (token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, false, $6, $7, $8)
+	`
+	if _, err := tx.Exec(insert, storedTokenHash(confirmToken), req.UserID, req.NewEmail, TokenTypeEmailChange,
+		now.Add(emailChangeTTL), now, req.RequestIP, req.UserAgent); err != nil {
+		return fmt.Errorf("failed to store email change token: %w", err)
+	}
+	if _, err := tx.Exec(insert, storedTokenHash(cancelToken), req.UserID, req.OldEmail, TokenTypeEmailChangeCancel,
+		now.Add(emailChangeCancelTTL), now, req.RequestIP, req.UserAgent); err != nil {
+		return fmt.Errorf("failed to store email change token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to store email change tokens: %w", err)
+	}
+
+	return sendEmailChange(tm.emailService, req, confirmToken, cancelToken, now)
+}
+
+// VerifyEmailChange redeems the link sent to the new address. The token's
+// Email is the address to move the account to.
+func (tm *TokenManager) VerifyEmailChange(token string) (*EmailToken, error) {
+	return tm.claim(token, TokenTypeEmailChange)
+}
+
+// CancelEmailChange redeems the link sent to the old address and drops any
+// change still waiting for confirmation. The token's Email is the address
+// the account had when the change was requested.
+func (tm *TokenManager) CancelEmailChange(token string) (*EmailToken, error) {
+	emailToken, err := tm.claim(token, TokenTypeEmailChangeCancel)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tm.db.Exec(`
+		UPDATE email_tokens SET used = true WHERE user_id = $1 AND type = $2 AND used = false
+	`, emailToken.UserID, TokenTypeEmailChange)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel pending email change: %w", err)
+	}
+
+	return emailToken, nil
+}
+
+// claim looks up an unused token of a type and marks it used in the same
+// statement that checks it is unused, so it can only be redeemed once
+func (tm *TokenManager) claim(token string, tokenType TokenType) (*EmailToken, error) {
+	if token == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+
+	var emailToken EmailToken
+	err := tm.db.QueryRow(`
+		SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent
+		FROM email_tokens
+		WHERE token = $1 AND type = $2 AND used = false
+		LIMIT 1
+	`, storedTokenHash(token), tokenType).Scan(
+		&emailToken.ID, &emailToken.Token, &emailToken.UserID, &emailToken.Email,
+		&emailToken.Type, &emailToken.ExpiresAt, &emailToken.Used, &emailToken.CreatedAt,
+		&emailToken.RequestIP, &emailToken.UserAgent,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("failed to lookup token: %w", err)
+	}
+
+	if time.Now().After(emailToken.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	result, err := tm.db.Exec(`
+		UPDATE email_tokens SET used = true WHERE id = $1 AND used = false
+	`, emailToken.ID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark token as used: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return &emailToken, nil
+}
+
+// sendEmailChange emails the confirmation link to the new address and the
+// cancel link to the old one
+func sendEmailChange(emailService EmailService, req EmailChangeRequest, confirmToken, cancelToken string, now time.Time) error {
+	appConfig := config.GetAppConfig()
+	securityCtx := SecurityContext{
+		RequestIP:   req.RequestIP,
+		UserAgent:   req.UserAgent,
+		RequestTime: now,
+	}
+
+	if err := emailService.SendEmailChangeConfirmation(nil, req.NewEmail, appConfig.GetEmailChangeConfirmURL(confirmToken), securityCtx); err != nil {
+		return err
+	}
+	return emailService.SendEmailChangeNotice(nil, req.OldEmail, req.NewEmail, appConfig.GetEmailChangeCancelURL(cancelToken), securityCtx)
+}
+
+// storedTokenHash returns the hex encoded SHA-256 of a token, the form kept
+// in email_tokens so the plaintext never reaches the database
+func storedTokenHash(token string) string {
+	hash := hashToken(token)
+	return hex.EncodeToString(hash[:])
+}
+
 // CleanupExpiredTokens removes expired tokens from the database
 func (tm *TokenManager) CleanupExpiredTokens() error {
 	_, err := tm.db.Exec(`
@@ -282,8 +557,7 @@ func (tm *TokenManager) checkRateLimit(email string, tokenType TokenType) error
 		return fmt.Errorf("failed to check rate limit: %w", err)
 	}
 
-	// Allow maximum 3 requests per hour
-	if count >= 3 {
+	if count >= maxTokenRequestsPerHour {
 		return ErrRateLimitExceeded
 	}
 
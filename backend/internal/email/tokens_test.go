@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -64,6 +65,57 @@ func (m *MockEmailService) SendEmailVerification(ctx context.Context, to, name,
 	return nil
 }
 
+func (m *MockEmailService) SendMagicLink(ctx context.Context, to, magicLinkURL string, securityCtx SecurityContext) error {
+	if m.shouldFail {
+		return errors.New("mock email service failure")
+	}
+	m.sentEmails = append(m.sentEmails, MockEmail{
+		To:          to,
+		Type:        "magic_link",
+		URL:         magicLinkURL,
+		SecurityCtx: securityCtx,
+	})
+	return nil
+}
+
+func (m *MockEmailService) SendEmailChangeConfirmation(ctx context.Context, to, confirmURL string, securityCtx SecurityContext) error {
+	if m.shouldFail {
+		return errors.New("mock email service failure")
+	}
+	m.sentEmails = append(m.sentEmails, MockEmail{
+		To:          to,
+		Type:        "email_change",
+		URL:         confirmURL,
+		SecurityCtx: securityCtx,
+	})
+	return nil
+}
+
+func (m *MockEmailService) SendEmailChangeNotice(ctx context.Context, to, newEmail, cancelURL string, securityCtx SecurityContext) error {
+	if m.shouldFail {
+		return errors.New("mock email service failure")
+	}
+	m.sentEmails = append(m.sentEmails, MockEmail{
+		To:          to,
+		Type:        "email_change_cancel",
+		URL:         cancelURL,
+		SecurityCtx: securityCtx,
+	})
+	return nil
+}
+
+func (m *MockEmailService) SendInvitation(ctx context.Context, to, inviterName, organizationName, inviteURL string) error {
+	if m.shouldFail {
+		return errors.New("mock email service failure")
+	}
+	m.sentEmails = append(m.sentEmails, MockEmail{
+		To:   to,
+		Type: "invitation",
+		URL:  inviteURL,
+	})
+	return nil
+}
+
 func (m *MockEmailService) SendSecurityAlert(ctx context.Context, to, alertMessage string, securityCtx SecurityContext) error {
 	if m.shouldFail {
 		return errors.New("mock email service failure")
@@ -109,6 +161,7 @@ func TestRequestPasswordReset(t *testing.T) {
 		request     PasswordResetRequest
 		setupMock   func(sqlmock.Sqlmock)
 		emailFails  bool
+		noEmail     bool
 		expectError bool
 		errorType   error
 	}{
@@ -133,6 +186,25 @@ func TestRequestPasswordReset(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "unknown email sends nothing",
+			request: PasswordResetRequest{
+				Email:     "nobody@example.com",
+				RequestIP: "192.168.1.100",
+				UserAgent: "Test Agent",
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM email_tokens").
+					WithArgs("nobody@example.com", TokenTypePasswordReset, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectExec("INSERT INTO email_tokens").
+					WithArgs(sqlmock.AnyArg(), "nobody@example.com", TokenTypePasswordReset,
+						sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			noEmail:     true,
+			expectError: false,
+		},
 		{
 			name: "invalid email",
 			request: PasswordResetRequest{
@@ -210,6 +282,13 @@ func TestRequestPasswordReset(t *testing.T) {
 				return
 			}
 
+			if tt.noEmail {
+				if len(emailService.sentEmails) != 0 {
+					t.Errorf("expected no email sent, got %d", len(emailService.sentEmails))
+				}
+				return
+			}
+
 			// Verify email was sent
 			if len(emailService.sentEmails) != 1 {
 				t.Errorf("expected 1 email sent, got %d", len(emailService.sentEmails))
@@ -239,7 +318,7 @@ func TestVerifyPasswordResetCode(t *testing.T) {
 
 	// Pre-compute hash for test token
 	testCode := "123456"
-	hashedToken := hashToken(testCode)
+	hashedToken := storedTokenHash(testCode)
 
 	tests := []struct {
 		name        string
@@ -256,7 +335,7 @@ func TestVerifyPasswordResetCode(t *testing.T) {
 			setupMock: func(mock sqlmock.Sqlmock) {
 				// Mock token lookup
 				rows := sqlmock.NewRows([]string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}).
-					AddRow(1, hashedToken[:], 123, "user@example.com", TokenTypePasswordReset, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
+					AddRow(1, hashedToken, 123, "user@example.com", TokenTypePasswordReset, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
 				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
 					WithArgs("user@example.com", TokenTypePasswordReset).
 					WillReturnRows(rows)
@@ -305,7 +384,7 @@ func TestVerifyPasswordResetCode(t *testing.T) {
 			code:  testCode,
 			setupMock: func(mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}).
-					AddRow(1, hashedToken[:], 123, "user@example.com", TokenTypePasswordReset, pastTime, false, fixedTime, "192.168.1.1", "Test Agent")
+					AddRow(1, hashedToken, 123, "user@example.com", TokenTypePasswordReset, pastTime, false, fixedTime, "192.168.1.1", "Test Agent")
 				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
 					WithArgs("user@example.com", TokenTypePasswordReset).
 					WillReturnRows(rows)
@@ -319,7 +398,7 @@ func TestVerifyPasswordResetCode(t *testing.T) {
 			code:  "wrong-code",
 			setupMock: func(mock sqlmock.Sqlmock) {
 				rows := sqlmock.NewRows([]string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}).
-					AddRow(1, hashedToken[:], 123, "user@example.com", TokenTypePasswordReset, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
+					AddRow(1, hashedToken, 123, "user@example.com", TokenTypePasswordReset, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
 				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
 					WithArgs("user@example.com", TokenTypePasswordReset).
 					WillReturnRows(rows)
@@ -482,7 +561,7 @@ func TestVerifyEmailToken(t *testing.T) {
 
 	// Pre-compute hash for test token
 	testToken := "abc123def456"
-	hashedToken := hashToken(testToken)
+	hashedToken := storedTokenHash(testToken)
 
 	tests := []struct {
 		name        string
@@ -497,9 +576,9 @@ func TestVerifyEmailToken(t *testing.T) {
 			setupMock: func(mock sqlmock.Sqlmock) {
 				// Mock token lookup
 				rows := sqlmock.NewRows([]string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}).
-					AddRow(1, hashedToken[:], 123, "user@example.com", TokenTypeEmailVerification, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
+					AddRow(1, hashedToken, 123, "user@example.com", TokenTypeEmailVerification, futureTime, false, fixedTime, "192.168.1.1", "Test Agent")
 				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
-					WithArgs(string(hashedToken[:]), TokenTypeEmailVerification).
+					WithArgs(hashedToken, TokenTypeEmailVerification).
 					WillReturnRows(rows)
 
 				// Mock token update
@@ -527,7 +606,7 @@ func TestVerifyEmailToken(t *testing.T) {
 			token: testToken,
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
-					WithArgs(string(hashedToken[:]), TokenTypeEmailVerification).
+					WithArgs(hashedToken, TokenTypeEmailVerification).
 					WillReturnError(sql.ErrNoRows)
 			},
 			expectError: true,
@@ -579,6 +658,221 @@ func TestVerifyEmailToken(t *testing.T) {
 	}
 }
 
+func TestRequestMagicLink(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	emailService := &MockEmailService{}
+	tokenManager := NewTokenManager(db, emailService)
+
+	// An address without an account is stored with no user
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM email_tokens").
+		WithArgs("new@example.com", TokenTypeMagicLink, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("INSERT INTO email_tokens").
+		WithArgs(sqlmock.AnyArg(), sql.NullInt64{}, "new@example.com", TokenTypeMagicLink,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), "192.168.1.100", "Test Agent").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = tokenManager.RequestMagicLink(MagicLinkRequest{Email: "new@example.com", RequestIP: "192.168.1.100", UserAgent: "Test Agent"})
+	if err != nil {
+		t.Fatalf("RequestMagicLink() error = %v", err)
+	}
+
+	if len(emailService.sentEmails) != 1 || emailService.sentEmails[0].Type != "magic_link" {
+		t.Fatalf("expected one magic_link email, got %+v", emailService.sentEmails)
+	}
+	if !strings.Contains(emailService.sentEmails[0].URL, "token=") {
+		t.Errorf("expected a sign-in URL with a token, got %q", emailService.sentEmails[0].URL)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRequestEmailChange(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	emailService := &MockEmailService{}
+	tokenManager := NewTokenManager(db, emailService)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM email_tokens").
+		WithArgs("new@example.com", TokenTypeEmailChange, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE email_tokens SET used = true WHERE user_id = \\$1 AND type = \\$2").
+		WithArgs(123, TokenTypeEmailChange).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO email_tokens").
+		WithArgs(sqlmock.AnyArg(), 123, "new@example.com", TokenTypeEmailChange,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), "192.168.1.100", "Test Agent").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO email_tokens").
+		WithArgs(sqlmock.AnyArg(), 123, "user@example.com", TokenTypeEmailChangeCancel,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), "192.168.1.100", "Test Agent").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	err = tokenManager.RequestEmailChange(EmailChangeRequest{
+		UserID:    123,
+		OldEmail:  "user@example.com",
+		NewEmail:  "new@example.com",
+		RequestIP: "192.168.1.100",
+		UserAgent: "Test Agent",
+	})
+	if err != nil {
+		t.Fatalf("RequestEmailChange() error = %v", err)
+	}
+
+	if len(emailService.sentEmails) != 2 {
+		t.Fatalf("expected two emails, got %+v", emailService.sentEmails)
+	}
+	if emailService.sentEmails[0].To != "new@example.com" || emailService.sentEmails[1].To != "user@example.com" {
+		t.Errorf("expected the confirmation at the new address and the notice at the old one, got %+v", emailService.sentEmails)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCancelEmailChange(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	tokenManager := NewTokenManager(db, &MockEmailService{})
+
+	testToken := "abc123def456"
+	hashedToken := storedTokenHash(testToken)
+	mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
+		WithArgs(hashedToken, TokenTypeEmailChangeCancel).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}).
+			AddRow(2, hashedToken, 123, "user@example.com", TokenTypeEmailChangeCancel, time.Now().Add(emailChangeCancelTTL), false, time.Now(), "192.168.1.1", "Test Agent"))
+	mock.ExpectExec("UPDATE email_tokens SET used = true WHERE id = \\$1 AND used = false").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE email_tokens SET used = true WHERE user_id = \\$1 AND type = \\$2").
+		WithArgs(123, TokenTypeEmailChange).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, err := tokenManager.CancelEmailChange(testToken)
+	if err != nil {
+		t.Fatalf("CancelEmailChange() error = %v", err)
+	}
+	if token.UserID != 123 || token.Email != "user@example.com" {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestVerifyMagicLink(t *testing.T) {
+	futureTime := time.Now().Add(magicLinkTTL)
+	testToken := "abc123def456"
+	hashedToken := storedTokenHash(testToken)
+	columns := []string{"id", "token", "user_id", "email", "type", "expires_at", "used", "created_at", "request_ip", "user_agent"}
+
+	tests := []struct {
+		name       string
+		setupMock  func(sqlmock.Sqlmock)
+		wantUserID int
+		errorType  error
+	}{
+		{
+			name: "link for a new account",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id, token, user_id, email, type, expires_at, used, created_at, request_ip, user_agent FROM email_tokens").
+					WithArgs(hashedToken, TokenTypeMagicLink).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, hashedToken, nil, "new@example.com", TokenTypeMagicLink, futureTime, false, time.Now(), "192.168.1.1", "Test Agent"))
+				mock.ExpectExec("UPDATE email_tokens SET used = true WHERE id = \\$1 AND used = false").
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "link for an existing account",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id, token, user_id").
+					WithArgs(hashedToken, TokenTypeMagicLink).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, hashedToken, 123, "user@example.com", TokenTypeMagicLink, futureTime, false, time.Now(), "192.168.1.1", "Test Agent"))
+				mock.ExpectExec("UPDATE email_tokens SET used = true").
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantUserID: 123,
+		},
+		{
+			name: "claimed by a concurrent request",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id, token, user_id").
+					WithArgs(hashedToken, TokenTypeMagicLink).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, hashedToken, 123, "user@example.com", TokenTypeMagicLink, futureTime, false, time.Now(), "192.168.1.1", "Test Agent"))
+				mock.ExpectExec("UPDATE email_tokens SET used = true").
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			errorType: ErrInvalidToken,
+		},
+		{
+			name: "expired link",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT id, token, user_id").
+					WithArgs(hashedToken, TokenTypeMagicLink).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, hashedToken, 123, "user@example.com", TokenTypeMagicLink, time.Now().Add(-time.Minute), false, time.Now(), "192.168.1.1", "Test Agent"))
+			},
+			errorType: ErrTokenExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+			if err != nil {
+				t.Fatalf("Failed to create mock DB: %v", err)
+			}
+			defer db.Close()
+
+			tokenManager := NewTokenManager(db, &MockEmailService{})
+			tt.setupMock(mock)
+
+			token, err := tokenManager.VerifyMagicLink(testToken)
+			if tt.errorType != nil {
+				if !errors.Is(err, tt.errorType) {
+					t.Errorf("expected error %v, got %v", tt.errorType, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if token.UserID != tt.wantUserID {
+				t.Errorf("expected user %d, got %d", tt.wantUserID, token.UserID)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
 func TestCleanupExpiredTokens(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
 	if err != nil {
@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/danielsaas/generic-saas/internal/database"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/danielsaas/generic-saas/internal/email"
+	"github.com/danielsaas/generic-saas/internal/middleware"
+	"github.com/danielsaas/generic-saas/internal/password"
 )
 
 // MetricsResponse represents the dashboard metrics
@@ -33,6 +35,10 @@ type UserProfileResponse struct {
 	ID    int    `json:"id"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+
+	// PendingEmail is the new address waiting to be confirmed, if the update
+	// asked for one
+	PendingEmail string `json:"pending_email,omitempty"`
 }
 
 // UpdateProfileRequest represents a profile update request
@@ -47,18 +53,45 @@ type UpdatePasswordRequest struct {
 	NewPassword     string `json:"newPassword"`
 }
 
+// EmailChanger starts a change of a user's email address that only takes
+// effect once the new address is confirmed. auth.Service implements it.
+type EmailChanger interface {
+	RequestEmailChange(r *http.Request, user *database.User, newEmail string) error
+}
+
 // Service holds the metrics service dependencies
 type Service struct {
-	db database.Database
+	db             database.Database
+	passwords      password.Hasher
+	passwordPolicy password.Policy
+	emailChanges   EmailChanger
 }
 
 // NewService creates a new metrics service
 func NewService(db database.Database) *Service {
 	return &Service{
-		db: db,
+		db:             db,
+		passwords:      password.Default(),
+		passwordPolicy: password.DefaultPolicy(),
 	}
 }
 
+// SetPasswordHasher sets how new passwords are hashed
+func (s *Service) SetPasswordHasher(hasher password.Hasher) {
+	s.passwords = hasher
+}
+
+// SetPasswordPolicy sets the rules new passwords must meet
+func (s *Service) SetPasswordPolicy(policy password.Policy) {
+	s.passwordPolicy = policy
+}
+
+// SetEmailChanger sets how email address changes are confirmed. Without one
+// the profile endpoint refuses to change the email address.
+func (s *Service) SetEmailChanger(changer EmailChanger) {
+	s.emailChanges = changer
+}
+
 // GetMetrics returns dashboard metrics for the authenticated user
 func (s *Service) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -131,19 +164,19 @@ func (s *Service) UpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 
 	// Validate input
 	name := strings.TrimSpace(req.Name)
-	email := strings.ToLower(strings.TrimSpace(req.Email))
+	address := strings.ToLower(strings.TrimSpace(req.Email))
 
 	if name == "" {
 		writeErrorResponse(w, "Name is required", http.StatusBadRequest)
 		return
 	}
 
-	if email == "" {
+	if address == "" {
 		writeErrorResponse(w, "Email is required", http.StatusBadRequest)
 		return
 	}
 
-	if !isValidEmail(email) {
+	if !isValidEmail(address) {
 		writeErrorResponse(w, "Invalid email format", http.StatusBadRequest)
 		return
 	}
@@ -155,29 +188,45 @@ func (s *Service) UpdateUserProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update user data
-	updatedUser := &database.User{
-		ID:       userID,
-		Name:     name,
-		Email:    email,
-		Password: currentUser.Password, // Keep existing password
+	// A new email address only replaces the current one once its owner
+	// follows the link sent to it, so it isn't saved here
+	pendingEmail := ""
+	if address != currentUser.Email {
+		if s.emailChanges == nil {
+			writeErrorResponse(w, "Email changes are not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := s.emailChanges.RequestEmailChange(r, currentUser, address); err != nil {
+			switch {
+			case errors.Is(err, database.ErrUserAlreadyExists):
+				writeErrorResponse(w, "Email already in use", http.StatusConflict)
+			case errors.Is(err, email.ErrRateLimitExceeded):
+				writeErrorResponse(w, "Too many email change requests, please try again later", http.StatusTooManyRequests)
+			default:
+				writeErrorResponse(w, "Failed to update profile", http.StatusInternalServerError)
+			}
+			return
+		}
+		pendingEmail = address
 	}
 
+	// Update user data, keeping fields this endpoint doesn't manage
+	updatedUser := *currentUser
+	updatedUser.Name = name
+
 	// Save updated user
-	user, err := s.db.Users().UpdateUser(r.Context(), updatedUser)
+	user, err := s.db.Users().UpdateUser(r.Context(), &updatedUser)
 	if err != nil {
-		if errors.Is(err, database.ErrUserAlreadyExists) {
-			writeErrorResponse(w, "Email already in use", http.StatusConflict)
-			return
-		}
 		writeErrorResponse(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
 	profile := UserProfileResponse{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
+		ID:           user.ID,
+		Name:         user.Name,
+		Email:        user.Email,
+		PendingEmail: pendingEmail,
 	}
 
 	writeJSONResponse(w, profile, http.StatusOK)
@@ -209,11 +258,6 @@ func (s *Service) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.NewPassword) < 8 {
-		writeErrorResponse(w, "New password must be at least 8 characters long", http.StatusBadRequest)
-		return
-	}
-
 	// Get current user
 	currentUser, err := s.db.Users().GetUserByID(r.Context(), userID)
 	if err != nil {
@@ -222,32 +266,53 @@ func (s *Service) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify current password
-	if err := bcrypt.CompareHashAndPassword([]byte(currentUser.Password), []byte(req.CurrentPassword)); err != nil {
+	if err := s.passwords.Verify(req.CurrentPassword, currentUser.Password); err != nil {
 		writeErrorResponse(w, "Current password is incorrect", http.StatusUnauthorized)
 		return
 	}
 
+	// Apply the same rules as registration and password reset
+	hashes, err := s.passwordPolicy.RecentHashes(r.Context(), s.db.PasswordHistory(), userID, currentUser.Password)
+	if err != nil {
+		writeErrorResponse(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	account := password.Account{Name: currentUser.Name, Email: currentUser.Email, PasswordHashes: hashes}
+	if err := s.passwordPolicy.Check(r.Context(), req.NewPassword, account, s.passwords); err != nil {
+		writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.Hash(req.NewPassword)
 	if err != nil {
 		writeErrorResponse(w, "Failed to process new password", http.StatusInternalServerError)
 		return
 	}
 
-	// Update user with new password
-	updatedUser := &database.User{
-		ID:       userID,
-		Name:     currentUser.Name,
-		Email:    currentUser.Email,
-		Password: string(hashedPassword),
+	if err := s.passwordPolicy.Remember(r.Context(), s.db.PasswordHistory(), userID, currentUser.Password); err != nil {
+		writeErrorResponse(w, "Failed to update password", http.StatusInternalServerError)
+		return
 	}
 
-	_, err = s.db.Users().UpdateUser(r.Context(), updatedUser)
+	// Update user with new password
+	updatedUser := *currentUser
+	updatedUser.Password = hashedPassword
+	updatedUser.PasswordResetRequired = false
+
+	_, err = s.db.Users().UpdateUser(r.Context(), &updatedUser)
 	if err != nil {
 		writeErrorResponse(w, "Failed to update password", http.StatusInternalServerError)
 		return
 	}
 
+	// Sign out every other device so a leaked password stops working there
+	currentSessionID, _ := middleware.SessionIDFromContext(r.Context())
+	if err := s.db.Sessions().RevokeUserSessions(r.Context(), userID, currentSessionID); err != nil {
+		writeErrorResponse(w, "Failed to revoke other sessions", http.StatusInternalServerError)
+		return
+	}
+
 	writeJSONResponse(w, map[string]string{"message": "Password updated successfully"}, http.StatusOK)
 }
 
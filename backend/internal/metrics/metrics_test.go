@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/password"
+)
+
+func putPassword(service *Service, userID int, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/api/user/password", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+	rr := httptest.NewRecorder()
+	service.UpdateUserPassword(rr, req)
+	return rr
+}
+
+func TestUpdateUserPassword_AppliesPolicy(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service := NewService(db)
+
+	hash, _ := password.Default().Hash("Original-Pass1")
+	user, err := db.Users().CreateUser(context.Background(), &database.User{
+		Name:     "Jane Doe",
+		Email:    "jane@example.com",
+		Password: hash,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	// Weaker passwords than registration allows are refused
+	rr := putPassword(service, user.ID, `{"currentPassword": "Original-Pass1", "newPassword": "password123"}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "uppercase") {
+		t.Errorf("Expected a weak password to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = putPassword(service, user.ID, `{"currentPassword": "Original-Pass1", "newPassword": "Changed-Pass1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Changing back to the previous password is refused
+	rr = putPassword(service, user.ID, `{"currentPassword": "Changed-Pass1", "newPassword": "Original-Pass1"}`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "previously used") {
+		t.Errorf("Expected a reused password to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// recordingEmailChanger records email changes instead of emailing links
+type recordingEmailChanger struct {
+	requested []string
+	err       error
+}
+
+func (c *recordingEmailChanger) RequestEmailChange(r *http.Request, user *database.User, newEmail string) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.requested = append(c.requested, newEmail)
+	return nil
+}
+
+func putProfile(service *Service, userID int, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/api/user/profile", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "user_id", userID))
+	rr := httptest.NewRecorder()
+	service.UpdateUserProfile(rr, req)
+	return rr
+}
+
+func TestUpdateUserProfile_EmailChangeNeedsConfirmation(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	service := NewService(db)
+
+	user, err := db.Users().CreateUser(context.Background(), &database.User{Name: "Jane Doe", Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	// Without a way to confirm the new address the email can't change
+	if rr := putProfile(service, user.ID, `{"name": "Jane Doe", "email": "new@example.com"}`); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	changer := &recordingEmailChanger{}
+	service.SetEmailChanger(changer)
+
+	rr := putProfile(service, user.ID, `{"name": "Jane Smith", "email": "New@Example.com"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var profile UserProfileResponse
+	json.NewDecoder(rr.Body).Decode(&profile)
+	if profile.Name != "Jane Smith" || profile.Email != "jane@example.com" || profile.PendingEmail != "new@example.com" {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+	if len(changer.requested) != 1 || changer.requested[0] != "new@example.com" {
+		t.Errorf("Expected a change to new@example.com, got %v", changer.requested)
+	}
+
+	stored, _ := db.Users().GetUserByID(context.Background(), user.ID)
+	if stored.Email != "jane@example.com" {
+		t.Errorf("Expected the email to stay unchanged until confirmed, got %q", stored.Email)
+	}
+
+	// Keeping the same address only updates the name
+	rr = putProfile(service, user.ID, `{"name": "Jane Doe", "email": "jane@example.com"}`)
+	if rr.Code != http.StatusOK || len(changer.requested) != 1 {
+		t.Errorf("Expected no email change, got %d and %v", rr.Code, changer.requested)
+	}
+
+	changer.err = database.ErrUserAlreadyExists
+	if rr := putProfile(service, user.ID, `{"name": "Jane Doe", "email": "taken@example.com"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+}
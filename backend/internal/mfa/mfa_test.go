@@ -0,0 +1,142 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed from RFC 6238 Appendix B
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, now)
+	previous, _ := Code(rfcSecret, now.Add(-Period))
+	stale, _ := Code(rfcSecret, now.Add(-3*Period))
+
+	step, ok := Validate(rfcSecret, code, now, 0)
+	if !ok || step != Step(now) {
+		t.Fatalf("Expected current code to validate at step %d, got %d, %v", Step(now), step, ok)
+	}
+
+	if _, ok := Validate(rfcSecret, previous, now, 0); !ok {
+		t.Error("Expected code from the previous period to be accepted")
+	}
+
+	if _, ok := Validate(rfcSecret, stale, now, 0); ok {
+		t.Error("Expected code from three periods ago to be rejected")
+	}
+
+	if _, ok := Validate(rfcSecret, code, now, step); ok {
+		t.Error("Expected replayed code to be rejected")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now, 0); ok {
+		t.Error("Expected short code to be rejected")
+	}
+
+	if _, ok := Validate("not base32!", code, now, 0); ok {
+		t.Error("Expected invalid secret to be rejected")
+	}
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("Expected 32 character secret, got %d", len(secret))
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Generated secret should be usable: %v", err)
+	}
+
+	uri := URI("My App", "john@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/My%20App:john@example.com?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+secret) || !strings.Contains(uri, "issuer=My+App") {
+		t.Errorf("URI missing secret or issuer: %s", uri)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewSecretBox() error = %v", err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Error("Sealed secret should not contain the plaintext")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil || opened != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Open() = %q, %v", opened, err)
+	}
+
+	other, _ := NewSecretBox([]byte("fedcba9876543210fedcba9876543210"))
+	if _, err := other.Open(sealed); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt with the wrong key, got %v", err)
+	}
+
+	if _, err := NewSecretBox([]byte("short")); err == nil {
+		t.Error("Expected short key to be rejected")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected code format: %s", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code: %s", code)
+		}
+		seen[code] = true
+	}
+
+	if NormalizeRecoveryCode(" ABCDE-fghij ") != "abcdefghij" {
+		t.Error("Expected normalisation to ignore case, dashes and spaces")
+	}
+}
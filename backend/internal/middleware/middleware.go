@@ -2,12 +2,19 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/danielsaas/generic-saas/internal/apikey"
 	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/oauth"
+	"github.com/danielsaas/generic-saas/internal/token"
 )
 
 type contextKey string
@@ -15,6 +22,22 @@ type contextKey string
 const (
 	RequestIDKey contextKey = "requestID"
 	StartTimeKey contextKey = "startTime"
+	ClaimsKey    contextKey = "claims"
+	APIKeyKey    contextKey = "apiKey"
+	RolesKey     contextKey = "roles"
+
+	// OAuthTokenKey holds the OAuth access token a request was made with
+	OAuthTokenKey contextKey = "oauthToken"
+
+	// ImpersonatorKey holds the ID of the administrator acting as the user
+	ImpersonatorKey contextKey = "impersonator"
+
+	// MembershipKey holds the user's membership of the organization the
+	// request works in
+	MembershipKey contextKey = "membership"
+
+	// logFieldsKey holds what RequireAuth learns for RequestLogging to log
+	logFieldsKey contextKey = "logFields"
 )
 
 type responseWriter struct {
@@ -34,6 +57,21 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// logFields collects who made a request. RequestLogging runs before the
+// request is authenticated, so RequireAuth fills these in for it.
+type logFields struct {
+	userID         int
+	impersonatorID int
+}
+
+// recordRequester tells RequestLogging who made the request
+func recordRequester(ctx context.Context, userID, impersonatorID int) {
+	if fields, ok := ctx.Value(logFieldsKey).(*logFields); ok {
+		fields.userID = userID
+		fields.impersonatorID = impersonatorID
+	}
+}
+
 func RequestLogging(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -42,6 +80,8 @@ func RequestLogging(logger *slog.Logger) func(http.Handler) http.Handler {
 
 			ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
 			ctx = context.WithValue(ctx, StartTimeKey, start)
+			fields := &logFields{}
+			ctx = context.WithValue(ctx, logFieldsKey, fields)
 			r = r.WithContext(ctx)
 
 			wrapped := &responseWriter{
@@ -61,14 +101,21 @@ func RequestLogging(logger *slog.Logger) func(http.Handler) http.Handler {
 
 			duration := time.Since(start)
 
-			logger.Info("Request completed",
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status_code", wrapped.statusCode,
 				"duration_ms", duration.Milliseconds(),
 				"bytes_written", wrapped.written,
 				"request_id", requestID,
-			)
+			}
+			if fields.userID != 0 {
+				attrs = append(attrs, "user_id", fields.userID)
+			}
+			if fields.impersonatorID != 0 {
+				attrs = append(attrs, "impersonator_id", fields.impersonatorID)
+			}
+			logger.Info("Request completed", attrs...)
 		})
 	}
 }
@@ -97,18 +144,43 @@ func ErrorRecovery(logger *slog.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// CORS allows cross-origin requests from the given origins. "*" alone
+// allows any origin.
 func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
+	return CORSWithOptions(CORSOptions{AllowedOrigins: allowedOrigins})
+}
+
+// CORSOptions configures CORSWithOptions
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to call the API. "*" alone
+	// allows any origin, unless AllowCredentials is set.
+	AllowedOrigins []string
+
+	// AllowCredentials lets browsers send cookies with cross-origin
+	// requests. Only origins named in AllowedOrigins are allowed then,
+	// because browsers refuse credentials with a wildcard.
+	AllowCredentials bool
+}
+
+// CORSWithOptions is CORS with support for credentialed requests
+func CORSWithOptions(opts CORSOptions) func(http.Handler) http.Handler {
+	allowHeaders := strings.Join([]string{"Content-Type", "Authorization", CSRFHeader, OrganizationHeader}, ", ")
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
-			if origin != "" && contains(allowedOrigins, origin) {
+			if origin != "" && contains(opts.AllowedOrigins, origin) {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
-			} else if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
+				w.Header().Add("Vary", "Origin")
+				if opts.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			} else if !opts.AllowCredentials && len(opts.AllowedOrigins) == 1 && opts.AllowedOrigins[0] == "*" {
 				w.Header().Set("Access-Control-Allow-Origin", "*")
 			}
 
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
 			w.Header().Set("Access-Control-Max-Age", "86400")
 
 			if r.Method == "OPTIONS" {
@@ -132,6 +204,15 @@ func getRequestID(ctx context.Context) string {
 	return "unknown"
 }
 
+// ClientIP returns the IP address of the client that sent the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -150,39 +231,168 @@ func randomString(length int) string {
 	return string(b)
 }
 
-// RequireAuth middleware ensures the request has valid authentication
-func RequireAuth(db database.Database) func(http.Handler) http.Handler {
+// Machine-readable codes returned with 401 responses
+const (
+	AuthCodeMissing           = "token_missing"
+	AuthCodeMalformed         = "token_malformed"
+	AuthCodeExpired           = "token_expired"
+	AuthCodeInvalidSignature  = "token_invalid_signature"
+	AuthCodeInvalidClaims     = "token_invalid_claims"
+	AuthCodeSessionRevoked    = "session_revoked"
+	AuthCodeAPIKeyInvalid     = "api_key_invalid"
+	AuthCodeAPIKeyExpired     = "api_key_expired"
+	AuthCodeOAuthTokenInvalid = "oauth_token_invalid"
+	AuthCodeOAuthTokenExpired = "oauth_token_expired"
+)
+
+// Machine-readable codes returned with 403 responses
+const (
+	AuthCodeInsufficientScope  = "insufficient_scope"  // An API key lacks the route's scope
+	AuthCodeEmailUnverified    = "email_unverified"    // The user has to verify their email first
+	AuthCodeRoleRequired       = "role_required"       // The user lacks the route's role
+	AuthCodePermissionRequired = "permission_required" // None of the user's roles grants the route's permission
+	AuthCodeImpersonating      = "impersonating"       // The route can't be used while impersonating the user
+	AuthCodeCSRFTokenInvalid   = "csrf_token_invalid"  // A cookie-authenticated request lacks a valid CSRF token
+
+	AuthCodeOrganizationMembershipRequired = "organization_membership_required" // The user doesn't belong to the organization
+	AuthCodeOrganizationRoleRequired       = "organization_role_required"       // The user's role in the organization is too weak
+)
+
+// AuthCodeOrganizationRequired is returned with a 400 response when a route
+// needs an organization and the request didn't pick one
+const AuthCodeOrganizationRequired = "organization_required"
+
+// OrganizationHeader picks the organization a request works in. It
+// overrides the organization in the access token.
+const OrganizationHeader = "X-Organization-ID"
+
+// Cookies that carry a browser's session when session cookies are on. The
+// CSRF cookie is readable by scripts, so the app can send it back in
+// CSRFHeader.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+)
+
+// CSRFHeader carries the CSRF token on unsafe requests authenticated by a
+// session cookie
+const CSRFHeader = "X-CSRF-Token"
+
+// Policies for users who haven't verified their email address
+const (
+	UnverifiedAllow    = "allow"     // No restrictions
+	UnverifiedReadOnly = "read_only" // Only GET, HEAD and OPTIONS requests
+	UnverifiedBlock    = "block"     // No requests at all
+)
+
+// AuthOptions configures RequireAuthWithOptions
+type AuthOptions struct {
+	// UnverifiedEmail is one of the Unverified policies. Empty means
+	// UnverifiedAllow and unknown values mean UnverifiedBlock.
+	UnverifiedEmail string
+
+	// SessionCookies accepts an access token from AccessTokenCookie when
+	// the request has no Authorization header. Unsafe methods then need
+	// the session's CSRF token in CSRFHeader.
+	SessionCookies bool
+}
+
+const (
+	// sessionTouchInterval throttles how often a session's last-seen time is written
+	sessionTouchInterval = time.Minute
+
+	// apiKeyTouchInterval throttles how often an API key's last-used time is written
+	apiKeyTouchInterval = time.Minute
+)
+
+// RequireAuth middleware ensures the request has a valid access token whose
+// session has not been revoked, and records activity on that session.
+//
+// It also accepts API keys and OAuth access tokens. A request made with
+// either carries no user until RequireScope finds the route's scope on it,
+// so handlers that are not wrapped in RequireScope refuse them.
+func RequireAuth(db database.Database, tokens *token.Manager) func(http.Handler) http.Handler {
+	return RequireAuthWithOptions(db, tokens, AuthOptions{})
+}
+
+// RequireAuthWithOptions is RequireAuth with restrictions on unverified users
+func RequireAuthWithOptions(db database.Database, tokens *token.Manager, opts AuthOptions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				writeAuthError(w, "Authorization header required")
+			raw, fromCookie, ok := requestToken(w, r, opts)
+			if !ok {
 				return
 			}
 
-			// Check Bearer token format
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				writeAuthError(w, "Invalid authorization header format")
+			if !fromCookie && apikey.IsKey(raw) {
+				authenticateAPIKey(db, w, r, raw, next, opts)
+				return
+			}
+			if !fromCookie && oauth.IsAccessToken(raw) {
+				authenticateOAuthToken(db, w, r, raw, next, opts)
 				return
 			}
 
-			token := parts[1]
-			if token == "" {
-				writeAuthError(w, "Token required")
+			claims, err := tokens.VerifyType(raw, token.TypeAccess)
+			if err != nil {
+				code, message := classifyTokenError(err)
+				writeAuthError(w, code, message)
 				return
 			}
 
-			// Parse and validate token (simplified - in production use JWT)
-			userID, err := parseToken(token, db)
+			userID, err := claims.UserID()
 			if err != nil {
-				writeAuthError(w, "Invalid token")
+				writeAuthError(w, AuthCodeInvalidClaims, "Invalid token subject")
+				return
+			}
+
+			if claims.SessionID == "" {
+				writeAuthError(w, AuthCodeInvalidClaims, "Token is not bound to a session")
+				return
+			}
+
+			// A browser sends its cookies whoever made it send the request
+			if fromCookie && !CSRFValid(tokens, r, claims.SessionID) {
+				writeForbidden(w, AuthCodeCSRFTokenInvalid, "Missing or invalid CSRF token")
+				return
+			}
+
+			session, err := db.Sessions().GetSession(r.Context(), claims.SessionID)
+			if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error": "Internal server error"}`))
+				return
+			}
+			now := time.Now()
+			if session == nil || session.UserID != userID || !session.Active(now) {
+				writeAuthError(w, AuthCodeSessionRevoked, "Session has been revoked")
+				return
+			}
+
+			if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+				// Best effort: failing to record activity must not fail the request
+				db.Sessions().TouchSession(r.Context(), session.ID, now)
+			}
+
+			if !allowUnverified(db, w, r, userID, opts) {
+				return
+			}
+
+			roles, ok := loadRoles(db, w, r, userID)
+			if !ok {
 				return
 			}
 
-			// Add user ID to context
+			// Add user ID, claims and roles to context
 			ctx := context.WithValue(r.Context(), "user_id", userID)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			ctx = context.WithValue(ctx, RolesKey, roles)
+			if session.ImpersonatorID != 0 {
+				ctx = context.WithValue(ctx, ImpersonatorKey, session.ImpersonatorID)
+			}
+			recordRequester(ctx, userID, session.ImpersonatorID)
 			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
@@ -190,50 +400,468 @@ func RequireAuth(db database.Database) func(http.Handler) http.Handler {
 	}
 }
 
-// parseToken extracts user ID from token (simplified implementation)
-func parseToken(token string, db database.Database) (int, error) {
-	// Simplified token parsing - in production use JWT
-	// Token format: "token_YYYYMMDDHHMMSS_X" where X is userID+48
-	if !strings.HasPrefix(token, "token_") {
-		return 0, &AuthError{"invalid token format"}
+// requestToken reads the bearer token from the Authorization header, or
+// with session cookies on from the access token cookie. It writes the
+// response and returns false if there is none.
+func requestToken(w http.ResponseWriter, r *http.Request, opts AuthOptions) (string, bool, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if opts.SessionCookies {
+			if cookie, err := r.Cookie(AccessTokenCookie); err == nil && cookie.Value != "" {
+				return cookie.Value, true, true
+			}
+		}
+		writeAuthError(w, AuthCodeMissing, "Authorization header required")
+		return "", false, false
+	}
+
+	// Check Bearer token format
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		writeAuthError(w, AuthCodeMalformed, "Invalid authorization header format")
+		return "", false, false
+	}
+
+	raw := strings.TrimSpace(parts[1])
+	if raw == "" {
+		writeAuthError(w, AuthCodeMissing, "Token required")
+		return "", false, false
+	}
+	return raw, false, true
+}
+
+// CSRFValid reports whether a request authenticated by a session cookie
+// may go ahead. Safe methods always may. Unsafe ones need a CSRF token
+// issued for the session in CSRFHeader. The token is signed and names the
+// session, so one planted in a cookie by another site doesn't work.
+func CSRFValid(tokens *token.Manager, r *http.Request, sessionID string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
 	}
 
-	parts := strings.Split(token, "_")
-	if len(parts) != 3 {
-		return 0, &AuthError{"invalid token format"}
+	raw := r.Header.Get(CSRFHeader)
+	if raw == "" {
+		return false
 	}
+	claims, err := tokens.VerifyType(raw, token.TypeCSRF)
+	return err == nil && claims.SessionID == sessionID
+}
 
-	// Extract user ID from the last part (simplified)
-	userIDStr := parts[2]
-	if len(userIDStr) == 0 {
-		return 0, &AuthError{"invalid user ID in token"}
+// authenticateAPIKey checks an API key and records its use. The key is put in
+// the context for RequireScope to check.
+func authenticateAPIKey(db database.Database, w http.ResponseWriter, r *http.Request, raw string, next http.Handler, opts AuthOptions) {
+	key, err := db.APIKeys().GetAPIKeyByHash(r.Context(), apikey.Hash(raw))
+	if err != nil && !errors.Is(err, database.ErrAPIKeyNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal server error"}`))
+		return
 	}
 
-	// Convert back from rune to int (reverse of the generation)
-	userID := int(userIDStr[0]) - 48
-	if userID < 1 {
-		return 0, &AuthError{"invalid user ID"}
+	now := time.Now()
+	if key == nil || key.RevokedAt != nil {
+		writeAuthError(w, AuthCodeAPIKeyInvalid, "API key is invalid or has been revoked")
+		return
+	}
+	if !key.Active(now) {
+		writeAuthError(w, AuthCodeAPIKeyExpired, "API key has expired")
+		return
 	}
 
-	// Verify user exists in database
-	_, err := db.Users().GetUserByID(context.Background(), userID)
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// Best effort: failing to record use must not fail the request
+		db.APIKeys().TouchAPIKey(r.Context(), key.ID, now)
+	}
+
+	if !allowUnverified(db, w, r, key.UserID, opts) {
+		return
+	}
+
+	roles, ok := loadRoles(db, w, r, key.UserID)
+	if !ok {
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), APIKeyKey, key)
+	ctx = context.WithValue(ctx, RolesKey, roles)
+	recordRequester(ctx, key.UserID, 0)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// authenticateOAuthToken checks an access token issued to an OAuth client.
+// The token is put in the context for RequireScope to check.
+func authenticateOAuthToken(db database.Database, w http.ResponseWriter, r *http.Request, raw string, next http.Handler, opts AuthOptions) {
+	issued, err := db.OAuthTokens().GetOAuthTokenByAccessHash(r.Context(), oauth.Hash(raw))
+	if err != nil && !errors.Is(err, database.ErrOAuthTokenNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal server error"}`))
+		return
+	}
+
+	if issued == nil || issued.RevokedAt != nil {
+		writeAuthError(w, AuthCodeOAuthTokenInvalid, "Access token is invalid or has been revoked")
+		return
+	}
+	if !issued.Active(time.Now()) {
+		writeAuthError(w, AuthCodeOAuthTokenExpired, "Access token has expired")
+		return
+	}
+
+	if !allowUnverified(db, w, r, issued.UserID, opts) {
+		return
+	}
+
+	roles, ok := loadRoles(db, w, r, issued.UserID)
+	if !ok {
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), OAuthTokenKey, issued)
+	ctx = context.WithValue(ctx, RolesKey, roles)
+	recordRequester(ctx, issued.UserID, 0)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// loadRoles fetches the roles of the user a request is made for. It writes
+// the response and returns false if they can't be loaded.
+func loadRoles(db database.Database, w http.ResponseWriter, r *http.Request, userID int) ([]*database.Role, bool) {
+	roles, err := db.Roles().ListUserRoles(r.Context(), userID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal server error"}`))
+		return nil, false
+	}
+	return roles, true
+}
+
+// allowUnverified applies the unverified email policy to the request. It
+// writes the response and returns false if the request is refused.
+func allowUnverified(db database.Database, w http.ResponseWriter, r *http.Request, userID int, opts AuthOptions) bool {
+	switch opts.UnverifiedEmail {
+	case "", UnverifiedAllow:
+		return true
+	case UnverifiedReadOnly:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			return true
+		}
+	}
+
+	user, err := db.Users().GetUserByID(r.Context(), userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeAuthError(w, AuthCodeInvalidClaims, "User no longer exists")
+		return false
+	}
 	if err != nil {
-		return 0, &AuthError{"user not found"}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal server error"}`))
+		return false
 	}
+	if user.EmailVerified() {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(AuthErrorResponse{
+		Error: "Verify your email address to continue",
+		Code:  AuthCodeEmailUnverified,
+	})
+	return false
+}
+
+// RequireScope middleware lets API keys and OAuth access tokens through to a
+// route only if they were granted the scope. Requests made with a session's
+// access token pass unchanged.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var userID int
+			var granted bool
+			var credential string
+			if key, ok := APIKeyFromContext(r.Context()); ok {
+				userID, granted, credential = key.UserID, key.HasScope(scope), "API key"
+			} else if issued, ok := OAuthTokenFromContext(r.Context()); ok {
+				userID, granted, credential = issued.UserID, issued.HasScope(scope), "Access token"
+			} else {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !granted {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(AuthErrorResponse{
+					Error: credential + " is missing the " + scope + " scope",
+					Code:  AuthCodeInsufficientScope,
+				})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), "user_id", userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole middleware lets a request through only if the authenticated
+// user has the role. It must run inside RequireAuth, and API keys are
+// refused unless RequireScope let them through first.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return requireGrant(AuthCodeRoleRequired, "The "+role+" role is required", func(ctx context.Context) bool {
+		return HasRole(ctx, role)
+	})
+}
+
+// RequirePermission middleware lets a request through only if one of the
+// authenticated user's roles grants the permission. It must run inside
+// RequireAuth, and API keys are refused unless RequireScope let them
+// through first.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return requireGrant(AuthCodePermissionRequired, "The "+permission+" permission is required", func(ctx context.Context) bool {
+		return HasPermission(ctx, permission)
+	})
+}
+
+// requireGrant refuses requests without a user, and requests whose user
+// isn't granted access by allowed
+func requireGrant(code, message string, allowed func(ctx context.Context) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if _, ok := requireUser(w, r); !ok {
+				return
+			}
+
+			if !allowed(ctx) {
+				writeForbidden(w, code, message)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireUser returns the user a request is made for. It writes the
+// response and returns false if there is none, such as for an API key or
+// OAuth access token that RequireScope didn't let through.
+func requireUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := UserIDFromContext(r.Context())
+	if ok {
+		return userID, true
+	}
+
+	if _, ok := APIKeyFromContext(r.Context()); ok {
+		writeForbidden(w, AuthCodeInsufficientScope, "API keys can't be used here")
+		return 0, false
+	}
+	if _, ok := OAuthTokenFromContext(r.Context()); ok {
+		writeForbidden(w, AuthCodeInsufficientScope, "OAuth access tokens can't be used here")
+		return 0, false
+	}
+	writeAuthError(w, AuthCodeMissing, "Authorization header required")
+	return 0, false
+}
+
+// RequireOrganization middleware finds the organization a request works in
+// and checks the user belongs to it. The X-Organization-ID header picks
+// it, and otherwise the access token's organization does. The membership
+// is put in the context. It must run inside RequireAuth, and inside
+// RequireScope for API keys.
+func RequireOrganization(db database.Database) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := requireUser(w, r)
+			if !ok {
+				return
+			}
+
+			ctx := r.Context()
+			organizationID := 0
+			if header := r.Header.Get(OrganizationHeader); header != "" {
+				id, err := strconv.Atoi(header)
+				if err != nil || id < 1 {
+					writeBadRequest(w, AuthCodeOrganizationRequired, "Invalid "+OrganizationHeader+" header")
+					return
+				}
+				organizationID = id
+			} else if claims, ok := ClaimsFromContext(ctx); ok {
+				organizationID = claims.OrganizationID
+			}
+			if organizationID == 0 {
+				writeBadRequest(w, AuthCodeOrganizationRequired, "Choose an organization with the "+OrganizationHeader+" header")
+				return
+			}
+
+			membership, err := db.Organizations().GetMembership(ctx, organizationID, userID)
+			if errors.Is(err, database.ErrMembershipNotFound) {
+				writeForbidden(w, AuthCodeOrganizationMembershipRequired, "You don't belong to this organization")
+				return
+			}
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error": "Internal server error"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, MembershipKey, membership)))
+		})
+	}
+}
+
+// RequireOrganizationRole middleware lets a request through only if the
+// user's role in its organization is at least role. It must run inside
+// RequireOrganization.
+func RequireOrganizationRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			membership, ok := MembershipFromContext(r.Context())
+			if !ok {
+				writeForbidden(w, AuthCodeOrganizationMembershipRequired, "You don't belong to this organization")
+				return
+			}
+			if !database.OrgRoleAtLeast(membership.Role, role) {
+				writeForbidden(w, AuthCodeOrganizationRoleRequired, "The organization "+role+" role is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ForbidImpersonation middleware refuses requests made while an
+// administrator is impersonating the user. It guards actions only the
+// account's owner should take, such as changing their password.
+func ForbidImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := ImpersonatorFromContext(r.Context()); ok {
+			writeForbidden(w, AuthCodeImpersonating, "This can't be done while impersonating the user")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// classifyTokenError maps a verification error to an error code and message
+func classifyTokenError(err error) (string, string) {
+	switch {
+	case errors.Is(err, token.ErrExpired):
+		return AuthCodeExpired, "Token has expired"
+	case errors.Is(err, token.ErrMalformed):
+		return AuthCodeMalformed, "Token is malformed"
+	case errors.Is(err, token.ErrSignatureInvalid),
+		errors.Is(err, token.ErrUnknownKey),
+		errors.Is(err, token.ErrInvalidKey),
+		errors.Is(err, token.ErrUnsupportedAlgorithm):
+		return AuthCodeInvalidSignature, "Token signature is invalid"
+	default:
+		return AuthCodeInvalidClaims, "Token is not valid for this service"
+	}
+}
+
+// UserIDFromContext returns the authenticated user ID set by RequireAuth
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value("user_id").(int)
+	return userID, ok
+}
+
+// SessionIDFromContext returns the ID of the session the request was made in
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.SessionID == "" {
+		return "", false
+	}
+	return claims.SessionID, true
+}
 
-	return userID, nil
+// ClaimsFromContext returns the verified token claims set by RequireAuth
+func ClaimsFromContext(ctx context.Context) (*token.Claims, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*token.Claims)
+	return claims, ok
 }
 
-func writeAuthError(w http.ResponseWriter, message string) {
+// ImpersonatorFromContext returns the ID of the administrator impersonating
+// the authenticated user, if the request was made in an impersonation session
+func ImpersonatorFromContext(ctx context.Context) (int, bool) {
+	impersonatorID, ok := ctx.Value(ImpersonatorKey).(int)
+	return impersonatorID, ok
+}
+
+// MembershipFromContext returns the user's membership of the organization
+// the request works in, set by RequireOrganization
+func MembershipFromContext(ctx context.Context) (*database.Membership, bool) {
+	membership, ok := ctx.Value(MembershipKey).(*database.Membership)
+	return membership, ok
+}
+
+// APIKeyFromContext returns the API key the request was made with, if any
+func APIKeyFromContext(ctx context.Context) (*database.APIKey, bool) {
+	key, ok := ctx.Value(APIKeyKey).(*database.APIKey)
+	return key, ok
+}
+
+// OAuthTokenFromContext returns the OAuth access token set by RequireAuth
+func OAuthTokenFromContext(ctx context.Context) (*database.OAuthToken, bool) {
+	issued, ok := ctx.Value(OAuthTokenKey).(*database.OAuthToken)
+	return issued, ok
+}
+
+// RolesFromContext returns the authenticated user's roles set by RequireAuth
+func RolesFromContext(ctx context.Context) ([]*database.Role, bool) {
+	roles, ok := ctx.Value(RolesKey).([]*database.Role)
+	return roles, ok
+}
+
+// HasRole reports whether the authenticated user has a role
+func HasRole(ctx context.Context, role string) bool {
+	roles, _ := RolesFromContext(ctx)
+	for _, r := range roles {
+		if r.Name == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether any of the authenticated user's roles
+// grants a permission
+func HasPermission(ctx context.Context, permission string) bool {
+	roles, _ := RolesFromContext(ctx)
+	for _, r := range roles {
+		if r.HasPermission(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthErrorResponse is the body of a 401 or 403 response
+type AuthErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func writeAuthError(w http.ResponseWriter, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+code+`"`)
 	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte(`{"error": "` + message + `"}`))
+	json.NewEncoder(w).Encode(AuthErrorResponse{Error: message, Code: code})
 }
 
-type AuthError struct {
-	message string
+func writeForbidden(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(AuthErrorResponse{Error: message, Code: code})
 }
 
-func (e *AuthError) Error() string {
-	return e.message
-}
\ No newline at end of file
+func writeBadRequest(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(AuthErrorResponse{Error: message, Code: code})
+}
@@ -2,11 +2,20 @@ package middleware
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/apikey"
+	"github.com/danielsaas/generic-saas/internal/database"
+	"github.com/danielsaas/generic-saas/internal/oauth"
+	"github.com/danielsaas/generic-saas/internal/token"
 )
 
 func TestRequestLogging(t *testing.T) {
@@ -185,6 +194,41 @@ func TestCORSPreflight(t *testing.T) {
 	}
 }
 
+func TestCORSWithOptions_Credentials(t *testing.T) {
+	handler := CORSWithOptions(CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected the origin to be echoed, got '%s'", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Expected Access-Control-Allow-Credentials for an allowed origin")
+	}
+	if !strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), CSRFHeader) {
+		t.Errorf("Expected %s to be an allowed header, got '%s'", CSRFHeader, rr.Header().Get("Access-Control-Allow-Headers"))
+	}
+
+	// Browsers refuse credentials with a wildcard, so it allows nothing
+	wildcard := CORSWithOptions(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})(handler)
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "https://malicious.com")
+	rr = httptest.NewRecorder()
+	wildcard.ServeHTTP(rr, req)
+
+	if origin := rr.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Expected no origin to be allowed, got '%s'", origin)
+	}
+}
+
 func TestGenerateRequestID(t *testing.T) {
 	id1 := generateRequestID()
 	id2 := generateRequestID()
@@ -264,4 +308,605 @@ func TestMiddlewareChain(t *testing.T) {
 	if !strings.Contains(logOutput, "Request started") {
 		t.Error("Request logging should be active in middleware chain")
 	}
-}
\ No newline at end of file
+}
+
+func newTestTokenManager(t *testing.T) *token.Manager {
+	t.Helper()
+	tokens, err := token.NewManager(token.Config{
+		Algorithm: token.HS256,
+		Secret:    []byte("test-secret-that-is-at-least-32-bytes"),
+		KeyID:     "test",
+		Issuer:    "https://test.example.com",
+		Audience:  "test-api",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create token manager: %v", err)
+	}
+	return tokens
+}
+
+func TestRequireAuth(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	expires := time.Now().Add(time.Hour)
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "live", UserID: 5, ExpiresAt: expires})
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "revoked", UserID: 5, ExpiresAt: expires})
+	db.Sessions().RevokeSession(ctx, "revoked")
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "someone-else", UserID: 6, ExpiresAt: expires})
+
+	valid, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "live"})
+	noSession, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess})
+	revoked, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "revoked"})
+	unknown, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "unknown"})
+	stolen, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "someone-else"})
+	expired, _ := tokens.Issue(token.Claims{
+		Subject:   "5",
+		Type:      token.TypeAccess,
+		SessionID: "live",
+		IssuedAt:  time.Now().Add(-time.Hour).Unix(),
+		NotBefore: time.Now().Add(-time.Hour).Unix(),
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+	})
+	wrongType, _ := tokens.Issue(token.Claims{Subject: "5", Type: "other"})
+	forged, _ := token.Sign(token.Header{Algorithm: token.HS256, KeyID: "test"},
+		[]byte(`{"sub":"5","typ":"access"}`), []byte("another-secret-that-is-long-enough"))
+
+	tests := []struct {
+		name         string
+		header       string
+		expectedCode string
+	}{
+		{"missing header", "", AuthCodeMissing},
+		{"wrong scheme", "Basic abc", AuthCodeMalformed},
+		{"malformed token", "Bearer token_20240101_5", AuthCodeMalformed},
+		{"expired token", "Bearer " + expired, AuthCodeExpired},
+		{"wrongly signed token", "Bearer " + forged, AuthCodeInvalidSignature},
+		{"wrong token type", "Bearer " + wrongType, AuthCodeInvalidClaims},
+		{"token without session", "Bearer " + noSession, AuthCodeInvalidClaims},
+		{"revoked session", "Bearer " + revoked, AuthCodeSessionRevoked},
+		{"unknown session", "Bearer " + unknown, AuthCodeSessionRevoked},
+		{"another user's session", "Bearer " + stolen, AuthCodeSessionRevoked},
+		{"valid token", "Bearer " + valid, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID int
+			var gotSessionID string
+			handler := RequireAuth(db, tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUserID, _ = UserIDFromContext(r.Context())
+				gotSessionID, _ = SessionIDFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/api/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if tt.expectedCode == "" {
+				if rr.Code != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+				}
+				if gotUserID != 5 || gotSessionID != "live" {
+					t.Errorf("Expected user 5 and session 'live' in context, got %d and '%s'", gotUserID, gotSessionID)
+				}
+				return
+			}
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+			}
+
+			var response AuthErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if response.Code != tt.expectedCode {
+				t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+			}
+			if rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequireAuth_APIKeys(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	createKey := func(userID int, scopes []string, expiresAt *time.Time) (string, *database.APIKey) {
+		raw, prefix, _ := apikey.Generate()
+		key, err := db.APIKeys().CreateAPIKey(ctx, &database.APIKey{
+			UserID: userID, Name: "test", Prefix: prefix, KeyHash: apikey.Hash(raw), Scopes: scopes, ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("CreateAPIKey() error = %v", err)
+		}
+		return raw, key
+	}
+
+	past := time.Now().Add(-time.Minute)
+	metricsKey, metricsRecord := createKey(5, []string{apikey.ScopeMetricsRead}, nil)
+	profileKey, _ := createKey(5, []string{apikey.ScopeProfileRead}, nil)
+	expiredKey, _ := createKey(5, []string{apikey.ScopeMetricsRead}, &past)
+	revokedKey, revokedRecord := createKey(5, []string{apikey.ScopeMetricsRead}, nil)
+	db.APIKeys().RevokeAPIKey(ctx, 5, revokedRecord.ID)
+
+	var gotUserID int
+	var gotUser bool
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, gotUser = UserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	scoped := RequireAuth(db, tokens)(RequireScope(apikey.ScopeMetricsRead)(inner))
+	unscoped := RequireAuth(db, tokens)(inner)
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		key            string
+		expectedStatus int
+		expectedCode   string
+		expectUser     bool
+	}{
+		{"key with scope", scoped, metricsKey, http.StatusOK, "", true},
+		{"key without scope", scoped, profileKey, http.StatusForbidden, AuthCodeInsufficientScope, false},
+		{"route without scope", unscoped, metricsKey, http.StatusOK, "", false},
+		{"expired key", scoped, expiredKey, http.StatusUnauthorized, AuthCodeAPIKeyExpired, false},
+		{"revoked key", scoped, revokedKey, http.StatusUnauthorized, AuthCodeAPIKeyInvalid, false},
+		{"unknown key", scoped, apikey.Prefix + "unknown", http.StatusUnauthorized, AuthCodeAPIKeyInvalid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, gotUser = 0, false
+			req := httptest.NewRequest("GET", "/api/metrics", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			rr := httptest.NewRecorder()
+
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var response AuthErrorResponse
+				json.NewDecoder(rr.Body).Decode(&response)
+				if response.Code != tt.expectedCode {
+					t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+				}
+			}
+			if gotUser != tt.expectUser || (tt.expectUser && gotUserID != 5) {
+				t.Errorf("Expected user in context = %v, got %v (%d)", tt.expectUser, gotUser, gotUserID)
+			}
+		})
+	}
+
+	used, _ := db.APIKeys().GetAPIKeyByHash(ctx, metricsRecord.KeyHash)
+	if used.LastUsedAt == nil {
+		t.Error("Expected key use to be recorded")
+	}
+}
+
+func TestRequireAuth_OAuthTokens(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	createToken := func(scopes []string, expiresAt time.Time) (string, *database.OAuthToken) {
+		raw, _ := oauth.NewAccessToken()
+		issued, err := db.OAuthTokens().CreateOAuthToken(ctx, &database.OAuthToken{
+			ClientID: "app", UserID: 5, GrantType: oauth.GrantAuthorizationCode, Scopes: scopes,
+			AccessTokenHash: oauth.Hash(raw), ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("CreateOAuthToken() error = %v", err)
+		}
+		return raw, issued
+	}
+
+	later := time.Now().Add(time.Hour)
+	metricsToken, _ := createToken([]string{apikey.ScopeMetricsRead}, later)
+	profileToken, _ := createToken([]string{apikey.ScopeProfileRead}, later)
+	expiredToken, _ := createToken([]string{apikey.ScopeMetricsRead}, time.Now().Add(-time.Minute))
+	revokedToken, revokedRecord := createToken([]string{apikey.ScopeMetricsRead}, later)
+	db.OAuthTokens().RevokeOAuthToken(ctx, revokedRecord.ID)
+
+	var gotUserID int
+	var gotUser bool
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, gotUser = UserIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	scoped := RequireAuth(db, tokens)(RequireScope(apikey.ScopeMetricsRead)(inner))
+	unscoped := RequireAuth(db, tokens)(inner)
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		token          string
+		expectedStatus int
+		expectedCode   string
+		expectUser     bool
+	}{
+		{"token with scope", scoped, metricsToken, http.StatusOK, "", true},
+		{"token without scope", scoped, profileToken, http.StatusForbidden, AuthCodeInsufficientScope, false},
+		{"route without scope", unscoped, metricsToken, http.StatusOK, "", false},
+		{"expired token", scoped, expiredToken, http.StatusUnauthorized, AuthCodeOAuthTokenExpired, false},
+		{"revoked token", scoped, revokedToken, http.StatusUnauthorized, AuthCodeOAuthTokenInvalid, false},
+		{"unknown token", scoped, oauth.AccessTokenPrefix + "unknown", http.StatusUnauthorized, AuthCodeOAuthTokenInvalid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, gotUser = 0, false
+			req := httptest.NewRequest("GET", "/api/metrics", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var response AuthErrorResponse
+				json.NewDecoder(rr.Body).Decode(&response)
+				if response.Code != tt.expectedCode {
+					t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+				}
+			}
+			if gotUser != tt.expectUser || (tt.expectUser && gotUserID != 5) {
+				t.Errorf("Expected user in context = %v, got %v (%d)", tt.expectUser, gotUser, gotUserID)
+			}
+		})
+	}
+}
+
+func TestRequireScope_AccessTokensPass(t *testing.T) {
+	handler := RequireScope(apikey.ScopeMetricsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestRequireAuthWithOptions_UnverifiedEmail(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	verifiedAt := time.Now()
+	unverified, _ := db.Users().CreateUser(ctx, &database.User{Name: "New User", Email: "new@example.com"})
+	verified, _ := db.Users().CreateUser(ctx, &database.User{Name: "Old User", Email: "old@example.com", EmailVerifiedAt: &verifiedAt})
+
+	accessToken := func(user *database.User) string {
+		sessionID := "session-" + user.Email
+		db.Sessions().CreateSession(ctx, &database.Session{ID: sessionID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		raw, _ := tokens.Issue(token.Claims{Subject: strconv.Itoa(user.ID), Type: token.TypeAccess, SessionID: sessionID})
+		return raw
+	}
+	unverifiedToken, verifiedToken := accessToken(unverified), accessToken(verified)
+
+	raw, prefix, _ := apikey.Generate()
+	db.APIKeys().CreateAPIKey(ctx, &database.APIKey{
+		UserID: unverified.ID, Name: "test", Prefix: prefix, KeyHash: apikey.Hash(raw), Scopes: []string{apikey.ScopeMetricsRead},
+	})
+
+	tests := []struct {
+		name           string
+		policy         string
+		method         string
+		credential     string
+		expectedStatus int
+	}{
+		{"allow lets unverified users write", UnverifiedAllow, "POST", unverifiedToken, http.StatusOK},
+		{"default is allow", "", "POST", unverifiedToken, http.StatusOK},
+		{"read only lets unverified users read", UnverifiedReadOnly, "GET", unverifiedToken, http.StatusOK},
+		{"read only refuses unverified writes", UnverifiedReadOnly, "POST", unverifiedToken, http.StatusForbidden},
+		{"read only lets verified users write", UnverifiedReadOnly, "POST", verifiedToken, http.StatusOK},
+		{"block refuses unverified reads", UnverifiedBlock, "GET", unverifiedToken, http.StatusForbidden},
+		{"block lets verified users read", UnverifiedBlock, "GET", verifiedToken, http.StatusOK},
+		{"block applies to API keys", UnverifiedBlock, "GET", raw, http.StatusForbidden},
+		{"unknown policy blocks", "strict", "GET", unverifiedToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAuthWithOptions(db, tokens, AuthOptions{UnverifiedEmail: tt.policy})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/api/user/profile", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.credential)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedStatus == http.StatusForbidden && !strings.Contains(rr.Body.String(), AuthCodeEmailUnverified) {
+				t.Errorf("Expected code %s, got %s", AuthCodeEmailUnverified, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireAuthWithOptions_SessionCookies(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	expires := time.Now().Add(time.Hour)
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "live", UserID: 5, ExpiresAt: expires})
+	accessToken, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "live"})
+	csrfToken, _ := tokens.Issue(token.Claims{Type: token.TypeCSRF, SessionID: "live"})
+	otherCSRF, _ := tokens.Issue(token.Claims{Type: token.TypeCSRF, SessionID: "other"})
+
+	tests := []struct {
+		name           string
+		cookies        bool
+		method         string
+		csrf           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"reads need no CSRF token", true, "GET", "", http.StatusOK, ""},
+		{"writes need the CSRF token", true, "POST", "", http.StatusForbidden, AuthCodeCSRFTokenInvalid},
+		{"access token is not a CSRF token", true, "POST", accessToken, http.StatusForbidden, AuthCodeCSRFTokenInvalid},
+		{"another session's CSRF token", true, "DELETE", otherCSRF, http.StatusForbidden, AuthCodeCSRFTokenInvalid},
+		{"session's CSRF token", true, "POST", csrfToken, http.StatusOK, ""},
+		{"cookie ignored when disabled", false, "GET", "", http.StatusUnauthorized, AuthCodeMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireAuthWithOptions(db, tokens, AuthOptions{SessionCookies: tt.cookies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/api/user/profile", nil)
+			req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: accessToken})
+			if tt.csrf != "" {
+				req.Header.Set(CSRFHeader, tt.csrf)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" && !strings.Contains(rr.Body.String(), tt.expectedCode) {
+				t.Errorf("Expected code %s, got %s", tt.expectedCode, rr.Body.String())
+			}
+		})
+	}
+
+	// Bearer tokens aren't sent by browsers on their own, so they need no CSRF token
+	handler := RequireAuthWithOptions(db, tokens, AuthOptions{SessionCookies: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest("POST", "/api/user/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a bearer token through without a CSRF token, got %d", rr.Code)
+	}
+}
+
+func TestRequireRoleAndPermission(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	db.Roles().CreateRole(ctx, &database.Role{Name: "admin", Permissions: []string{database.AllPermissions}})
+	db.Roles().CreateRole(ctx, &database.Role{Name: "support", Permissions: []string{"users:read"}})
+	db.Roles().AssignRole(ctx, 1, "admin")
+	db.Roles().AssignRole(ctx, 2, "support")
+
+	accessToken := func(userID int) string {
+		sessionID := "session-" + strconv.Itoa(userID)
+		db.Sessions().CreateSession(ctx, &database.Session{ID: sessionID, UserID: userID, ExpiresAt: time.Now().Add(time.Hour)})
+		raw, _ := tokens.Issue(token.Claims{Subject: strconv.Itoa(userID), Type: token.TypeAccess, SessionID: sessionID})
+		return raw
+	}
+	admin, support, member := accessToken(1), accessToken(2), accessToken(3)
+
+	raw, prefix, _ := apikey.Generate()
+	db.APIKeys().CreateAPIKey(ctx, &database.APIKey{
+		UserID: 1, Name: "test", Prefix: prefix, KeyHash: apikey.Hash(raw), Scopes: []string{apikey.ScopeMetricsRead},
+	})
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		credential     string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"admin has the role", RequireRole("admin")(ok), admin, http.StatusOK, ""},
+		{"support lacks the role", RequireRole("admin")(ok), support, http.StatusForbidden, AuthCodeRoleRequired},
+		{"admin has every permission", RequirePermission("roles:write")(ok), admin, http.StatusOK, ""},
+		{"support has its permission", RequirePermission("users:read")(ok), support, http.StatusOK, ""},
+		{"support lacks other permissions", RequirePermission("users:write")(ok), support, http.StatusForbidden, AuthCodePermissionRequired},
+		{"users without roles are refused", RequirePermission("users:read")(ok), member, http.StatusForbidden, AuthCodePermissionRequired},
+		{"API keys are refused", RequireRole("admin")(ok), raw, http.StatusForbidden, AuthCodeInsufficientScope},
+		{"scoped API keys act as their user", RequireScope(apikey.ScopeMetricsRead)(RequireRole("admin")(ok)), raw, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/admin/roles", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.credential)
+			rr := httptest.NewRecorder()
+			RequireAuth(db, tokens)(tt.handler).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var response AuthErrorResponse
+				json.NewDecoder(rr.Body).Decode(&response)
+				if response.Code != tt.expectedCode {
+					t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireRole_WithoutAuth(t *testing.T) {
+	handler := RequireRole("admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/admin/roles", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	expires := time.Now().Add(time.Hour)
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "own", UserID: 5, ExpiresAt: expires})
+	db.Sessions().CreateSession(ctx, &database.Session{ID: "impersonated", UserID: 5, ExpiresAt: expires, ImpersonatorID: 1})
+	own, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "own"})
+	impersonated, _ := tokens.Issue(token.Claims{Subject: "5", Type: token.TypeAccess, SessionID: "impersonated"})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	serve := func(accessToken string) (*httptest.ResponseRecorder, int) {
+		var impersonatorID int
+		handler := ForbidImpersonation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		inspect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			impersonatorID, _ = ImpersonatorFromContext(r.Context())
+			handler.ServeHTTP(w, r)
+		})
+
+		buf.Reset()
+		req := httptest.NewRequest("PUT", "/api/user/password", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		RequestLogging(logger)(RequireAuth(db, tokens)(inspect)).ServeHTTP(rr, req)
+		return rr, impersonatorID
+	}
+
+	rr, impersonatorID := serve(own)
+	if rr.Code != http.StatusOK || impersonatorID != 0 {
+		t.Errorf("Expected the owner through without an impersonator, got %d and %d", rr.Code, impersonatorID)
+	}
+	if !strings.Contains(buf.String(), `"user_id":5`) || strings.Contains(buf.String(), "impersonator_id") {
+		t.Errorf("Expected only the user in the log, got %s", buf.String())
+	}
+
+	rr, impersonatorID = serve(impersonated)
+	if rr.Code != http.StatusForbidden || impersonatorID != 1 {
+		t.Errorf("Expected impersonator 1 to be refused, got %d and %d", rr.Code, impersonatorID)
+	}
+	var response AuthErrorResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Code != AuthCodeImpersonating {
+		t.Errorf("Expected code '%s', got '%s'", AuthCodeImpersonating, response.Code)
+	}
+	if !strings.Contains(buf.String(), `"user_id":5`) || !strings.Contains(buf.String(), `"impersonator_id":1`) {
+		t.Errorf("Expected the user and impersonator in the log, got %s", buf.String())
+	}
+}
+
+func TestRequireOrganization(t *testing.T) {
+	tokens := newTestTokenManager(t)
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	for _, email := range []string{"owner@example.com", "member@example.com", "outsider@example.com"} {
+		db.Users().CreateUser(ctx, &database.User{Email: email, Name: email, Password: "hash"})
+	}
+	org, _ := db.Organizations().CreateOrganization(ctx, &database.Organization{Name: "Acme"}, 1)
+	db.Organizations().AddMember(ctx, org.ID, 2, database.OrgRoleMember)
+
+	accessToken := func(userID, organizationID int) string {
+		sessionID := "session-" + strconv.Itoa(userID) + "-" + strconv.Itoa(organizationID)
+		db.Sessions().CreateSession(ctx, &database.Session{ID: sessionID, UserID: userID, ExpiresAt: time.Now().Add(time.Hour)})
+		raw, _ := tokens.Issue(token.Claims{Subject: strconv.Itoa(userID), Type: token.TypeAccess, SessionID: sessionID, OrganizationID: organizationID})
+		return raw
+	}
+	orgHeader := strconv.Itoa(org.ID)
+
+	var membership *database.Membership
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, _ = MembershipFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		credential     string
+		header         string
+		expectedStatus int
+		expectedCode   string
+		expectedRole   string
+	}{
+		{"header picks the organization", RequireOrganization(db)(ok), accessToken(1, 0), orgHeader, http.StatusOK, "", database.OrgRoleOwner},
+		{"token picks the organization", RequireOrganization(db)(ok), accessToken(2, org.ID), "", http.StatusOK, "", database.OrgRoleMember},
+		{"header overrides the token", RequireOrganization(db)(ok), accessToken(2, 99), orgHeader, http.StatusOK, "", database.OrgRoleMember},
+		{"no organization", RequireOrganization(db)(ok), accessToken(1, 0), "", http.StatusBadRequest, AuthCodeOrganizationRequired, ""},
+		{"invalid header", RequireOrganization(db)(ok), accessToken(1, 0), "acme", http.StatusBadRequest, AuthCodeOrganizationRequired, ""},
+		{"outsiders are refused", RequireOrganization(db)(ok), accessToken(3, 0), orgHeader, http.StatusForbidden, AuthCodeOrganizationMembershipRequired, ""},
+		{"unknown organizations are refused", RequireOrganization(db)(ok), accessToken(1, 99), "", http.StatusForbidden, AuthCodeOrganizationMembershipRequired, ""},
+		{"owner has the admin role", RequireOrganization(db)(RequireOrganizationRole(database.OrgRoleAdmin)(ok)), accessToken(1, 0), orgHeader, http.StatusOK, "", database.OrgRoleOwner},
+		{"member lacks the admin role", RequireOrganization(db)(RequireOrganizationRole(database.OrgRoleAdmin)(ok)), accessToken(2, 0), orgHeader, http.StatusForbidden, AuthCodeOrganizationRoleRequired, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			membership = nil
+			req := httptest.NewRequest("GET", "/api/projects", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.credential)
+			if tt.header != "" {
+				req.Header.Set(OrganizationHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			RequireAuth(db, tokens)(tt.handler).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var response AuthErrorResponse
+				json.NewDecoder(rr.Body).Decode(&response)
+				if response.Code != tt.expectedCode {
+					t.Errorf("Expected code '%s', got '%s'", tt.expectedCode, response.Code)
+				}
+			}
+			if tt.expectedRole != "" && (membership == nil || membership.Role != tt.expectedRole || membership.OrganizationID != org.ID) {
+				t.Errorf("Expected %s membership of organization %d in the context, got %+v", tt.expectedRole, org.ID, membership)
+			}
+		})
+	}
+}
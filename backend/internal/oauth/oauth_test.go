@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danielsaas/generic-saas/internal/apikey"
+)
+
+func TestNewCredentials(t *testing.T) {
+	accessToken, err := NewAccessToken()
+	if err != nil {
+		t.Fatalf("NewAccessToken() error = %v", err)
+	}
+	refreshToken, _ := NewRefreshToken()
+	secret, _ := NewClientSecret()
+	clientID, _ := NewClientID()
+
+	if !IsAccessToken(accessToken) {
+		t.Errorf("Expected %q to be an access token", accessToken)
+	}
+	if IsAccessToken(refreshToken) || IsAccessToken(secret) || IsAccessToken(clientID) {
+		t.Error("Expected only access tokens to be taken for access tokens")
+	}
+	if IsAccessToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") || apikey.IsKey(accessToken) {
+		t.Error("Expected access tokens, JWTs and API keys to be told apart")
+	}
+	if len(clientID) != 32 {
+		t.Errorf("Unexpected client id %q", clientID)
+	}
+
+	if !SecretMatches(secret, Hash(secret)) || SecretMatches(secret+"x", Hash(secret)) {
+		t.Error("Expected a secret to match only its own hash")
+	}
+}
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	// The example from RFC 7636 Appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !ValidCodeChallenge(challenge) {
+		t.Error("Expected the challenge to be valid")
+	}
+	if ValidCodeChallenge("too-short") || ValidCodeChallenge(verifier+"!") {
+		t.Error("Expected malformed challenges to be invalid")
+	}
+
+	if !VerifyCodeVerifier(verifier, challenge) {
+		t.Error("Expected the verifier to match its challenge")
+	}
+	if VerifyCodeVerifier(strings.Replace(verifier, "d", "e", 1), challenge) {
+		t.Error("Expected another verifier not to match")
+	}
+	if VerifyCodeVerifier("short", challenge) || VerifyCodeVerifier(verifier+" ", challenge) {
+		t.Error("Expected malformed verifiers to be refused")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scopes := ParseScope("  profile:read metrics:read profile:read ")
+	if !reflect.DeepEqual(scopes, []string{"profile:read", "metrics:read"}) {
+		t.Errorf("Unexpected scopes %v", scopes)
+	}
+	if FormatScope(scopes) != "profile:read metrics:read" {
+		t.Errorf("Unexpected scope parameter %q", FormatScope(scopes))
+	}
+	if len(ParseScope("")) != 0 {
+		t.Error("Expected no scopes from an empty parameter")
+	}
+}
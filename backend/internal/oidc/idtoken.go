@@ -0,0 +1,195 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+const (
+	// clockSkew tolerates small differences between our clock and the provider's
+	clockSkew = time.Minute
+
+	// keySetTTL is how long a fetched JWKS is trusted before it is refreshed
+	keySetTTL = time.Hour
+
+	// minKeyRefresh limits refetching the JWKS when an unknown key ID shows up,
+	// so forged tokens can't make us hammer the provider
+	minKeyRefresh = time.Minute
+)
+
+// IDTokenClaims holds the verified claims of an ID token
+type IDTokenClaims struct {
+	Issuer          string         `json:"iss"`
+	Subject         string         `json:"sub"`
+	Audience        token.Audience `json:"aud"`
+	ExpiresAt       int64          `json:"exp"`
+	IssuedAt        int64          `json:"iat"`
+	Nonce           string         `json:"nonce"`
+	AuthorizedParty string         `json:"azp"`
+	Email           string         `json:"email"`
+	EmailVerified   flexibleBool   `json:"email_verified"`
+	Name            string         `json:"name"`
+}
+
+// flexibleBool accepts booleans and the "true"/"false" strings some
+// providers send for email_verified
+type flexibleBool bool
+
+// UnmarshalJSON decodes a boolean or a boolean string
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = flexibleBool(v)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b = flexibleBool(v)
+	return nil
+}
+
+// VerifiedEmail returns the email address if the provider vouches for it
+func (c *IDTokenClaims) VerifiedEmail() (string, bool) {
+	if c.Email == "" || !bool(c.EmailVerified) {
+		return "", false
+	}
+	return c.Email, true
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS
+// and validates its claims (OIDC Core §3.1.3.7)
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	jws, err := token.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// Only asymmetric algorithms: "none" and HS256 are never accepted
+	alg := jws.Header.Algorithm
+	if alg != token.RS256 && alg != token.ES256 && alg != token.EdDSA {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+
+	key, err := p.keys.get(ctx, jws.Header.KeyID, alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := jws.Verify(alg, key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims IDTokenClaims
+	if err := json.Unmarshal(jws.Payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims", ErrInvalidToken)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case !claims.Audience.Contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: audience does not include client", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, claims.AuthorizedParty)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	case claims.IssuedAt > now.Add(clockSkew).Unix():
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	return &claims, nil
+}
+
+// fetchKeys downloads the provider's current JWKS
+func (p *Provider) fetchKeys(ctx context.Context) (*token.JWKSet, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set token.JWKSet
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("%w: fetching JWKS: %v", ErrKeyNotFound, err)
+	}
+	return &set, nil
+}
+
+// keySet caches a provider's signing keys. Keys are refreshed when they go
+// stale or when a token names a key ID we haven't seen, which is how
+// providers roll their keys.
+type keySet struct {
+	fetch func(ctx context.Context) (*token.JWKSet, error)
+
+	mu        sync.Mutex
+	keys      map[string]token.JWK
+	fetchedAt time.Time
+}
+
+func newKeySet(fetch func(ctx context.Context) (*token.JWKSet, error)) *keySet {
+	return &keySet{fetch: fetch}
+}
+
+// get returns the public key with the given ID for the given algorithm
+func (s *keySet) get(ctx context.Context, keyID string, alg token.Algorithm) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	jwk, ok := s.keys[keyID]
+	stale := now.Sub(s.fetchedAt) > keySetTTL
+	if (!ok && now.Sub(s.fetchedAt) > minKeyRefresh) || stale {
+		if err := s.refresh(ctx, now); err != nil {
+			return nil, err
+		}
+		jwk, ok = s.keys[keyID]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, keyID)
+	}
+
+	keyAlg, err := jwk.SigningAlgorithm()
+	if err != nil || keyAlg != alg {
+		return nil, fmt.Errorf("%w: key %q cannot verify %s", ErrInvalidToken, keyID, alg)
+	}
+	return jwk.PublicKey()
+}
+
+// refresh replaces the cached keys. Callers hold the lock.
+func (s *keySet) refresh(ctx context.Context, now time.Time) error {
+	set, err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]token.JWK, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		keys[jwk.KeyID] = jwk
+	}
+
+	s.keys = keys
+	s.fetchedAt = now
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package oidc implements the relying party side of OpenID Connect: provider
+// discovery, the authorization code flow with PKCE, and ID token verification.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by providers
+var (
+	ErrDiscovery     = errors.New("oidc: discovery failed")
+	ErrExchange      = errors.New("oidc: code exchange failed")
+	ErrInvalidToken  = errors.New("oidc: invalid ID token")
+	ErrNonceMismatch = errors.New("oidc: nonce mismatch")
+	ErrKeyNotFound   = errors.New("oidc: signing key not found")
+)
+
+// maxResponseSize caps what we read from a provider
+const maxResponseSize = 1 << 20
+
+// Config configures a Provider
+type Config struct {
+	// Name identifies the provider in URLs and stored identities, e.g. "google"
+	Name string
+
+	// DisplayName is shown on login buttons. Defaults to Name.
+	DisplayName string
+
+	// Issuer is the provider's issuer URL. Discovery is fetched from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is where the provider sends the browser back to
+	RedirectURL string
+
+	// Scopes requested in addition to "openid". Defaults to email and profile.
+	Scopes []string
+
+	// HTTPClient is used for discovery, JWKS and token requests
+	HTTPClient *http.Client
+}
+
+// Discovery is the subset of the provider metadata document we use
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider is a configured OpenID Connect provider
+type Provider struct {
+	config Config
+	client *http.Client
+	keys   *keySet
+
+	mu        sync.Mutex
+	discovery *Discovery
+}
+
+// NewProvider validates the configuration and creates a Provider. Discovery
+// happens lazily on first use so an unreachable provider doesn't stop startup.
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: name, issuer, client ID and redirect URL are required")
+	}
+	if _, err := url.Parse(cfg.Issuer); err != nil {
+		return nil, fmt.Errorf("oidc: invalid issuer %q", cfg.Issuer)
+	}
+
+	if cfg.DisplayName == "" {
+		cfg.DisplayName = cfg.Name
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &Provider{config: cfg, client: client}
+	p.keys = newKeySet(p.fetchKeys)
+	return p, nil
+}
+
+// Name returns the provider's identifier
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// DisplayName returns the provider's human readable name
+func (p *Provider) DisplayName() string {
+	return p.config.DisplayName
+}
+
+// Discover returns the provider metadata, fetching it on first use
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	var discovery Discovery
+	if err := p.getJSON(ctx, wellKnown, &discovery); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	// The document must describe the issuer we were configured with (OIDC Discovery §4.3)
+	if discovery.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the URL to send the browser to. state and nonce must be
+// random and remembered until the callback; codeChallenge is the PKCE S256
+// challenge of a verifier that is also remembered.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint", ErrDiscovery)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// tokenResponse is the token endpoint's reply
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code, verifies the ID token that comes
+// back and returns its claims
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDTokenClaims, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	basicAuth := p.useBasicAuth(discovery)
+	if !basicAuth {
+		form.Set("client_id", p.config.ClientID)
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		// RFC 6749 §2.3.1 form-encodes the credentials before base64
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("%w: invalid token response", ErrExchange)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in response", ErrExchange)
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// useBasicAuth reports whether to authenticate with client_secret_basic,
+// which is the default when the provider doesn't say otherwise
+func (p *Provider) useBasicAuth(discovery *Discovery) bool {
+	if len(discovery.TokenEndpointAuthMethodsSupported) == 0 {
+		return true
+	}
+	for _, method := range discovery.TokenEndpointAuthMethodsSupported {
+		if method == "client_secret_basic" {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON fetches and decodes a JSON document
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Issuer) {
+	t.Helper()
+
+	issuer := oidctest.NewIssuer("client-1", "secret-1")
+	t.Cleanup(issuer.Close)
+
+	provider, err := NewProvider(Config{
+		Name:         "fake",
+		Issuer:       issuer.URL,
+		ClientID:     "client-1",
+		ClientSecret: "secret-1",
+		RedirectURL:  "https://app.example.com/auth/oidc/fake/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return provider, issuer
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	verifier, _ := RandomString()
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	if !strings.Contains(authURL, "code_challenge_method=S256") || !strings.Contains(authURL, "scope=openid+email+profile") {
+		t.Errorf("Unexpected authorization URL %s", authURL)
+	}
+
+	code, state, err := issuer.Authorize(authURL)
+	if err != nil || state != "state-1" {
+		t.Fatalf("Authorize() = %q, %q, %v", code, state, err)
+	}
+
+	claims, err := provider.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if email, ok := claims.VerifiedEmail(); !ok || email != "john@example.com" || claims.Subject != "fake-subject-1" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce-1"); !errors.Is(err, ErrExchange) {
+		t.Errorf("Expected redeemed code to be rejected, got %v", err)
+	}
+}
+
+func TestProvider_Exchange_Rejections(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	authorize := func() (string, string) {
+		verifier, _ := RandomString()
+		authURL, _ := provider.AuthCodeURL(ctx, "state", "nonce", CodeChallenge(verifier))
+		code, _, err := issuer.Authorize(authURL)
+		if err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+		return code, verifier
+	}
+
+	code, _ := authorize()
+	if _, err := provider.Exchange(ctx, code, "wrong-verifier", "nonce"); !errors.Is(err, ErrExchange) {
+		t.Errorf("Expected wrong PKCE verifier to be rejected, got %v", err)
+	}
+
+	code, verifier := authorize()
+	if _, err := provider.Exchange(ctx, code, verifier, "other-nonce"); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("Expected wrong nonce to be rejected, got %v", err)
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"sub": "user-1", "nonce": "n"}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]interface{})
+		wantErr error
+	}{
+		{"valid", func(map[string]interface{}) {}, nil},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "client-2" }, ErrInvalidToken},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, ErrInvalidToken},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrInvalidToken},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }, ErrInvalidToken},
+		{"missing subject", func(c map[string]interface{}) { delete(c, "sub") }, ErrInvalidToken},
+		{"multiple audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{"client-1", "client-2"} }, ErrInvalidToken},
+		{"multiple audiences with azp", func(c map[string]interface{}) {
+			c["aud"] = []string{"client-1", "client-2"}
+			c["azp"] = "client-1"
+		}, nil},
+		{"wrong nonce", func(c map[string]interface{}) { c["nonce"] = "other" }, ErrNonceMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+
+			_, err := provider.VerifyIDToken(ctx, issuer.IssueIDToken(claims), "n")
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Expected token to verify, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestProvider_VerifyIDToken_RejectsUnsignedAndTampered(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	raw := issuer.IssueIDToken(map[string]interface{}{"sub": "user-1", "nonce": "n"})
+	parts := strings.Split(raw, ".")
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	if _, err := provider.VerifyIDToken(ctx, none, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected alg none to be rejected, got %v", err)
+	}
+
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","nonce":"n"}`)) + "." + parts[2]
+	if _, err := provider.VerifyIDToken(ctx, tampered, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected tampered payload to be rejected, got %v", err)
+	}
+}
+
+func TestProvider_KeyRotation(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, issuer.IssueIDToken(map[string]interface{}{"sub": "1", "nonce": "n"}), "n"); err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+
+	issuer.RotateKey()
+	rotated := issuer.IssueIDToken(map[string]interface{}{"sub": "1", "nonce": "n"})
+
+	// Right after a fetch an unknown key ID doesn't trigger another one
+	if _, err := provider.VerifyIDToken(ctx, rotated, "n"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected refetch to be rate limited, got %v", err)
+	}
+
+	provider.keys.fetchedAt = time.Now().Add(-2 * minKeyRefresh)
+	if _, err := provider.VerifyIDToken(ctx, rotated, "n"); err != nil {
+		t.Errorf("Expected rotated key to be picked up, got %v", err)
+	}
+}
+
+func TestProvider_DiscoveryIssuerMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer("client-1", "secret-1")
+	defer issuer.Close()
+
+	provider, _ := NewProvider(Config{
+		Name:        "fake",
+		Issuer:      issuer.URL + "/",
+		ClientID:    "client-1",
+		RedirectURL: "https://app.example.com/callback",
+	})
+	if _, err := provider.Discover(context.Background()); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("Expected issuer mismatch to fail discovery, got %v", err)
+	}
+}
+
+func TestIDTokenClaims_EmailVerifiedString(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	raw := issuer.IssueIDToken(map[string]interface{}{"sub": "1", "nonce": "n", "email": "a@example.com", "email_verified": "true"})
+	claims, err := provider.VerifyIDToken(context.Background(), raw, "n")
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+	if _, ok := claims.VerifiedEmail(); !ok {
+		t.Error("Expected string email_verified to be accepted")
+	}
+}
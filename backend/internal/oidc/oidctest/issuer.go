@@ -0,0 +1,286 @@
+// Package oidctest runs an in-process OpenID Connect provider for tests. It
+// implements discovery, JWKS, an authorization endpoint that consents
+// immediately, and a token endpoint that enforces PKCE.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Identity is the user the fake provider signs in
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// grant is an issued, not yet redeemed authorization code
+type grant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	identity      Identity
+}
+
+// Issuer is a fake OpenID Connect provider
+type Issuer struct {
+	URL string
+
+	clientID     string
+	clientSecret string
+	server       *httptest.Server
+
+	mu       sync.Mutex
+	keyID    string
+	key      *rsa.PrivateKey
+	identity Identity
+	codes    map[string]grant
+}
+
+// NewIssuer starts a provider that accepts a single client. Call Close when done.
+func NewIssuer(clientID, clientSecret string) *Issuer {
+	i := &Issuer{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		codes:        make(map[string]grant),
+		identity: Identity{
+			Subject:       "fake-subject-1",
+			Email:         "john@example.com",
+			EmailVerified: true,
+			Name:          "John Doe",
+		},
+	}
+	i.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("/jwks", i.handleJWKS)
+	mux.HandleFunc("/authorize", i.handleAuthorize)
+	mux.HandleFunc("/token", i.handleToken)
+
+	i.server = httptest.NewServer(mux)
+	i.URL = i.server.URL
+	return i
+}
+
+// Close shuts the provider down
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// SetIdentity changes the user signed in by future authorizations
+func (i *Issuer) SetIdentity(identity Identity) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.identity = identity
+}
+
+// RotateKey replaces the signing key with a new one under a new key ID
+func (i *Issuer) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.key = key
+	i.keyID = randomString()
+}
+
+// Authorize plays the browser and the consenting user: it follows an
+// authorization URL and returns the code and state sent to the redirect URI
+func (i *Issuer) Authorize(authURL string) (code, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorization failed with status %d", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+// IssueIDToken signs arbitrary ID token claims with the current key. Missing
+// iss, aud, iat and exp claims are filled in.
+func (i *Issuer) IssueIDToken(claims map[string]interface{}) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.sign(claims)
+}
+
+// sign fills in the registered claims and signs them. Callers hold the lock.
+func (i *Issuer) sign(claims map[string]interface{}) string {
+	now := time.Now()
+	defaults := map[string]interface{}{
+		"iss": i.URL,
+		"aud": i.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range defaults {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+
+	payload, _ := json.Marshal(claims)
+	raw, err := token.Sign(token.Header{Algorithm: token.RS256, KeyID: i.keyID, Type: "JWT"}, payload, i.key)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	jwk, err := token.NewJWK(i.keyID, i.key)
+	i.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, token.JWKSet{Keys: []token.JWK{jwk}})
+}
+
+func (i *Issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != i.clientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid client or response type", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(" "+query.Get("scope")+" ", " openid ") {
+		http.Error(w, "openid scope is required", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.codes[code] = grant{
+		clientID:      i.clientID,
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		identity:      i.identity,
+	}
+	i.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (i *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != i.clientID || clientSecret != i.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	code := r.PostForm.Get("code")
+	g, ok := i.codes[code]
+	delete(i.codes, code)
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	idToken := i.sign(map[string]interface{}{
+		"sub":            g.identity.Subject,
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"name":           g.identity.Name,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
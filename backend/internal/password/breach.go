@@ -0,0 +1,210 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefixLength is how many hex characters of the SHA-1 hash are sent to a
+// range source. The rest of the hash never leaves the server.
+const prefixLength = 5
+
+// BreachSource looks up breached password hashes by k-anonymity: given the
+// first five hex characters of a SHA-1 hash it returns the remaining 35
+// characters of every breached hash with that prefix, with how often each
+// was seen
+type BreachSource interface {
+	Range(ctx context.Context, prefix string) (map[string]int, error)
+}
+
+// Breached reports whether the password appears in the source
+func Breached(ctx context.Context, source BreachSource, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(ctx, hash[:prefixLength])
+	if err != nil {
+		return false, err
+	}
+	return suffixes[hash[prefixLength:]] > 0, nil
+}
+
+// RangeAPI queries a Pwned Passwords compatible range API
+type RangeAPI struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewRangeAPI creates a source for the range API at baseURL, such as
+// https://api.pwnedpasswords.com
+func NewRangeAPI(baseURL string, client *http.Client) *RangeAPI {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &RangeAPI{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// Range fetches the suffixes for a prefix from the API
+func (a *RangeAPI) Range(ctx context.Context, prefix string) (map[string]int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Padding hides the real number of results from anyone watching
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("breach range request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("breach range request returned status %d", resp.StatusCode)
+	}
+
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, count, ok := parseHashLine(scanner.Text())
+		// Padding entries have a count of zero
+		if ok && count > 0 {
+			suffixes[suffix] = count
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breach range: %w", err)
+	}
+	return suffixes, nil
+}
+
+// BreachFile looks up hashes in a local file, for servers without network
+// access. The file holds one upper case SHA-1 hash per line, optionally
+// followed by :COUNT, sorted by hash - the format of the downloadable Pwned
+// Passwords list ordered by hash.
+type BreachFile struct {
+	path string
+}
+
+// NewBreachFile creates a source for the sorted hash file at path
+func NewBreachFile(path string) (*BreachFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("breached password file %s is a directory", path)
+	}
+	return &BreachFile{path: path}, nil
+}
+
+// Range binary searches the file for the first hash with the prefix and
+// reads the matching lines from there
+func (f *BreachFile) Range(ctx context.Context, prefix string) (map[string]int, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	// The line starting at or after each offset only moves forward as the
+	// offset grows, so the lines can be searched by byte offset
+	var searchErr error
+	offset := sort.Search(int(size), func(i int) bool {
+		line, _, err := lineAt(file, int64(i), size)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return line == "" || strings.ToUpper(line[:min(prefixLength, len(line))]) >= prefix
+	})
+	if searchErr != nil {
+		return nil, fmt.Errorf("failed to search breached password file: %w", searchErr)
+	}
+
+	_, start, err := lineAt(file, int64(offset), size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search breached password file: %w", err)
+	}
+
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(io.NewSectionReader(file, start, size-start))
+	for scanner.Scan() {
+		line := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if !strings.HasPrefix(line, prefix) {
+			break
+		}
+		if suffix, count, ok := parseHashLine(line[prefixLength:]); ok {
+			suffixes[suffix] = max(count, 1)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password file: %w", err)
+	}
+	return suffixes, nil
+}
+
+// lineAt returns the first whole line starting at or after offset and where
+// it starts, or an empty line at the end of the file
+func lineAt(file *os.File, offset, size int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Begin just before the offset so a line starting exactly there
+		// isn't skipped
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, start, size-start))
+
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", size, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	return strings.TrimSpace(line), start, nil
+}
+
+// parseHashLine splits a HASH:COUNT line. A missing count counts as one.
+func parseHashLine(line string) (string, int, bool) {
+	hash, countText, hasCount := strings.Cut(strings.TrimSpace(line), ":")
+	if hash == "" {
+		return "", 0, false
+	}
+
+	count := 1
+	if hasCount {
+		var err error
+		if count, err = strconv.Atoi(countText); err != nil {
+			return "", 0, false
+		}
+	}
+	return strings.ToUpper(hash), count, true
+}
@@ -0,0 +1,132 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestRangeAPI(t *testing.T) {
+	breached := sha1Hex("Password123!")
+	var gotPath, gotPadding string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotPadding = r.Header.Get("Add-Padding")
+		if r.URL.Path != "/range/"+breached[:5] {
+			fmt.Fprint(w, "0000000000000000000000000000000000A:0\r\n")
+			return
+		}
+		fmt.Fprintf(w, "0000000000000000000000000000000000A:0\r\n%s:42\r\n", breached[5:])
+	}))
+	defer server.Close()
+
+	api := NewRangeAPI(server.URL+"/", nil)
+
+	found, err := Breached(context.Background(), api, "Password123!")
+	if err != nil {
+		t.Fatalf("Breached() error = %v", err)
+	}
+	if !found {
+		t.Error("Expected the password to be found")
+	}
+	if gotPath != "/range/"+breached[:5] {
+		t.Errorf("Expected only the hash prefix to be sent, got %q", gotPath)
+	}
+	if gotPadding != "true" {
+		t.Errorf("Expected padding to be requested, got %q", gotPadding)
+	}
+
+	// Padding entries don't count as breaches
+	suffixes, _ := api.Range(context.Background(), breached[:5])
+	if _, ok := suffixes["0000000000000000000000000000000000A"]; ok {
+		t.Error("Expected padding entries to be dropped")
+	}
+
+	if found, _ := Breached(context.Background(), api, "Unbreached-Pass1"); found {
+		t.Error("Expected an unbreached password not to be found")
+	}
+}
+
+func TestRangeAPI_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if _, err := Breached(context.Background(), NewRangeAPI(server.URL, nil), "Password123!"); err == nil {
+		t.Error("Expected an error for a failed request")
+	}
+}
+
+func TestBreachFile(t *testing.T) {
+	breached := []string{"Password123!", "letmein", "qwerty", "hunter2", "Summer2024!"}
+
+	var lines []string
+	for i, password := range breached {
+		line := sha1Hex(password)
+		// Counts are optional
+		if i%2 == 0 {
+			line += fmt.Sprintf(":%d", i+1)
+		}
+		lines = append(lines, line)
+	}
+	for i := 0; i < 200; i++ {
+		lines = append(lines, sha1Hex(fmt.Sprintf("filler-%d", i))+":1")
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := NewBreachFile(path)
+	if err != nil {
+		t.Fatalf("NewBreachFile() error = %v", err)
+	}
+
+	// Check the very first and last lines too
+	first := lines[0][:40]
+	last := lines[len(lines)-1][:40]
+	suffixes, err := file.Range(context.Background(), first[:5])
+	if err != nil || suffixes[first[5:]] == 0 {
+		t.Errorf("Expected the first hash to be found, got %v, %v", suffixes, err)
+	}
+	if suffixes, _ := file.Range(context.Background(), last[:5]); suffixes[last[5:]] == 0 {
+		t.Errorf("Expected the last hash to be found, got %v", suffixes)
+	}
+
+	for _, password := range breached {
+		found, err := Breached(context.Background(), file, password)
+		if err != nil {
+			t.Fatalf("Breached() error = %v", err)
+		}
+		if !found {
+			t.Errorf("Expected %q to be found", password)
+		}
+	}
+
+	for _, password := range []string{"Unbreached-Pass1", "filler-x"} {
+		if found, _ := Breached(context.Background(), file, password); found {
+			t.Errorf("Expected %q not to be found", password)
+		}
+	}
+
+	if _, err := NewBreachFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,310 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// BcryptMaxBytes is the longest password bcrypt can hash
+const BcryptMaxBytes = 72
+
+var (
+	// ErrMismatch is returned when a password does not match its hash
+	ErrMismatch = errors.New("password does not match")
+
+	// ErrUnsupportedHash is returned for a stored hash in an unknown format
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+
+	// ErrUnknownPepper is returned when a hash was made with a pepper other
+	// than the configured one, so it can no longer be checked
+	ErrUnknownPepper = errors.New("password hash uses an unknown pepper")
+)
+
+// Hasher hashes passwords for storage and checks them at login
+type Hasher interface {
+	// Hash returns an encoded hash of the password
+	Hash(password string) (string, error)
+
+	// Verify returns nil if the password matches the encoded hash
+	Verify(password, encoded string) error
+
+	// NeedsRehash reports whether the encoded hash was made with a different
+	// algorithm, parameters or pepper than Hash would use now
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2Params are the argon2id cost parameters
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Config selects the algorithm new hashes are made with. Hashes made with
+// any supported algorithm can still be verified.
+type Config struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+
+	// Pepper is a server-side secret mixed into argon2id hashes. It is kept
+	// out of the database, so a leaked table alone can't be cracked. bcrypt
+	// doesn't support it.
+	Pepper string
+}
+
+// DefaultConfig returns argon2id with the parameters OWASP recommends
+func DefaultConfig() Config {
+	return Config{
+		Algorithm: AlgorithmArgon2id,
+		Argon2: Argon2Params{
+			Memory:      19 * 1024,
+			Iterations:  2,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// Default returns a Hasher using DefaultConfig
+func Default() Hasher {
+	hasher, _ := New(DefaultConfig())
+	return hasher
+}
+
+// phcHasher makes argon2id hashes in PHC string format and bcrypt hashes in
+// bcrypt's own format, which is already self-describing
+type phcHasher struct {
+	config   Config
+	pepper   []byte
+	pepperID string
+}
+
+// New creates a Hasher from a config
+func New(config Config) (Hasher, error) {
+	switch config.Algorithm {
+	case AlgorithmArgon2id:
+		p := config.Argon2
+		if p.Memory < 8*uint32(p.Parallelism) || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 8 || p.KeyLength < 16 {
+			return nil, fmt.Errorf("invalid argon2id parameters: %+v", p)
+		}
+	case AlgorithmBcrypt:
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, config.BcryptCost)
+		}
+		if config.Pepper != "" {
+			return nil, fmt.Errorf("a pepper can only be used with %s, not %s", AlgorithmArgon2id, AlgorithmBcrypt)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", config.Algorithm)
+	}
+
+	h := &phcHasher{config: config}
+	if config.Pepper != "" {
+		h.pepper = []byte(config.Pepper)
+		h.pepperID = pepperID(h.pepper)
+	}
+	return h, nil
+}
+
+// Hash returns an encoded hash of the password
+func (h *phcHasher) Hash(password string) (string, error) {
+	if h.config.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.config.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	p := h.config.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey(h.peppered(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return encodeArgon2id(argon2idHash{
+		params:   p,
+		pepperID: h.pepperID,
+		salt:     salt,
+		key:      key,
+	}), nil
+}
+
+// Verify returns nil if the password matches the encoded hash
+func (h *phcHasher) Verify(password, encoded string) error {
+	switch {
+	case encoded == "":
+		// Accounts created through a provider or magic link have no password
+		return ErrMismatch
+	case isBcrypt(encoded):
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+				return ErrMismatch
+			}
+			return ErrUnsupportedHash
+		}
+		return nil
+	}
+
+	stored, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	// Hashes made before the pepper was set are checked without it, so
+	// turning it on upgrades them at login instead of locking users out
+	if stored.pepperID != "" && stored.pepperID != h.pepperID {
+		return ErrUnknownPepper
+	}
+	input := []byte(password)
+	if stored.pepperID != "" {
+		input = h.peppered(password)
+	}
+
+	p := stored.params
+	key := argon2.IDKey(input, stored.salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, stored.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the encoded hash is out of date
+func (h *phcHasher) NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		if h.config.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.config.BcryptCost
+	}
+
+	stored, err := decodeArgon2id(encoded)
+	if err != nil {
+		// Nothing verifies against it, so there is nothing to upgrade
+		return false
+	}
+	return h.config.Algorithm != AlgorithmArgon2id ||
+		stored.params != h.config.Argon2 ||
+		stored.pepperID != h.pepperID
+}
+
+// peppered keys the password with the pepper, if there is one
+func (h *phcHasher) peppered(password string) []byte {
+	if h.pepper == nil {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// pepperID names a pepper in the hashes made with it without revealing it
+func pepperID(pepper []byte) string {
+	sum := sha256.Sum256(pepper)
+	return base64.RawStdEncoding.EncodeToString(sum[:6])
+}
+
+// isBcrypt reports whether the hash is in bcrypt's $2a$, $2b$ or $2y$ format
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// argon2idHash is a decoded argon2id PHC string
+type argon2idHash struct {
+	params   Argon2Params
+	pepperID string
+	salt     []byte
+	key      []byte
+}
+
+// encodeArgon2id formats a hash as
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>[,keyid=<pepper>]$<salt>$<key>
+func encodeArgon2id(hash argon2idHash) string {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", hash.params.Memory, hash.params.Iterations, hash.params.Parallelism)
+	if hash.pepperID != "" {
+		params += ",keyid=" + hash.pepperID
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(hash.salt),
+		base64.RawStdEncoding.EncodeToString(hash.key))
+}
+
+// decodeArgon2id parses a PHC string made by encodeArgon2id
+func decodeArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return nil, ErrUnsupportedHash
+	}
+	if parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return nil, ErrUnsupportedHash
+	}
+
+	hash := &argon2idHash{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, ErrUnsupportedHash
+		}
+
+		var err error
+		switch name {
+		case "m":
+			hash.params.Memory, err = parseUint32(value)
+		case "t":
+			hash.params.Iterations, err = parseUint32(value)
+		case "p":
+			var parallelism uint64
+			parallelism, err = strconv.ParseUint(value, 10, 8)
+			hash.params.Parallelism = uint8(parallelism)
+		case "keyid":
+			hash.pepperID = value
+		default:
+			err = ErrUnsupportedHash
+		}
+		if err != nil {
+			return nil, ErrUnsupportedHash
+		}
+	}
+	if hash.params.Memory == 0 || hash.params.Iterations == 0 || hash.params.Parallelism == 0 {
+		return nil, ErrUnsupportedHash
+	}
+
+	var err error
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(hash.salt) == 0 {
+		return nil, ErrUnsupportedHash
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash.key) == 0 {
+		return nil, ErrUnsupportedHash
+	}
+	hash.params.SaltLength = uint32(len(hash.salt))
+	hash.params.KeyLength = uint32(len(hash.key))
+
+	return hash, nil
+}
+
+func parseUint32(value string) (uint32, error) {
+	n, err := strconv.ParseUint(value, 10, 32)
+	return uint32(n), err
+}
@@ -0,0 +1,218 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testConfig keeps argon2id cheap so tests stay fast
+func testConfig() Config {
+	config := DefaultConfig()
+	config.Argon2.Memory = 64
+	config.Argon2.Iterations = 1
+	return config
+}
+
+func newTestHasher(t *testing.T, config Config) Hasher {
+	t.Helper()
+
+	hasher, err := New(config)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return hasher
+}
+
+func TestArgon2id_HashAndVerify(t *testing.T) {
+	hasher := newTestHasher(t, testConfig())
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Expected a PHC argon2id hash, got %q", hash)
+	}
+
+	if err := hasher.Verify("correct horse", hash); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := hasher.Verify("wrong horse", hash); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("Expected a fresh hash to be current")
+	}
+
+	// Each hash has its own salt
+	again, _ := hasher.Hash("correct horse")
+	if again == hash {
+		t.Error("Expected different hashes for the same password")
+	}
+}
+
+func TestBcrypt_HashAndVerify(t *testing.T) {
+	config := testConfig()
+	config.Algorithm = AlgorithmBcrypt
+	config.BcryptCost = bcrypt.MinCost
+	hasher := newTestHasher(t, config)
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("Expected a bcrypt hash with cost %d, got %q", bcrypt.MinCost, hash)
+	}
+
+	if err := hasher.Verify("correct horse", hash); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := hasher.Verify("wrong horse", hash); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("Expected a fresh hash to be current")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argonHasher := newTestHasher(t, testConfig())
+	argonHash, _ := argonHasher.Hash("secret")
+
+	bcryptConfig := testConfig()
+	bcryptConfig.Algorithm = AlgorithmBcrypt
+	bcryptConfig.BcryptCost = bcrypt.MinCost
+	bcryptHasher := newTestHasher(t, bcryptConfig)
+	bcryptHash, _ := bcryptHasher.Hash("secret")
+
+	stronger := testConfig()
+	stronger.Argon2.Iterations = 2
+
+	costlier := bcryptConfig
+	costlier.BcryptCost = bcrypt.MinCost + 1
+
+	peppered := testConfig()
+	peppered.Pepper = "server secret"
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{"bcrypt hash under argon2id", argonHasher, bcryptHash, true},
+		{"argon2id hash under bcrypt", bcryptHasher, argonHash, true},
+		{"argon2id parameters raised", newTestHasher(t, stronger), argonHash, true},
+		{"bcrypt cost raised", newTestHasher(t, costlier), bcryptHash, true},
+		{"pepper added", newTestHasher(t, peppered), argonHash, true},
+		{"empty hash", argonHasher, "", false},
+		{"unknown format", argonHasher, "$md5$abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Old hashes still verify whatever the configured algorithm
+	if err := argonHasher.Verify("secret", bcryptHash); err != nil {
+		t.Errorf("Expected bcrypt hash to verify under argon2id, got %v", err)
+	}
+	if err := bcryptHasher.Verify("secret", argonHash); err != nil {
+		t.Errorf("Expected argon2id hash to verify under bcrypt, got %v", err)
+	}
+}
+
+func TestPepper(t *testing.T) {
+	config := testConfig()
+	config.Pepper = "server secret"
+	hasher := newTestHasher(t, config)
+
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.Contains(hash, ",keyid=") || strings.Contains(hash, "server secret") {
+		t.Errorf("Expected the pepper to be named but not revealed, got %q", hash)
+	}
+	if err := hasher.Verify("secret", hash); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+
+	// Without the pepper the hash can't be checked
+	if err := newTestHasher(t, testConfig()).Verify("secret", hash); !errors.Is(err, ErrUnknownPepper) {
+		t.Errorf("Expected ErrUnknownPepper without the pepper, got %v", err)
+	}
+
+	other := testConfig()
+	other.Pepper = "another secret"
+	if err := newTestHasher(t, other).Verify("secret", hash); !errors.Is(err, ErrUnknownPepper) {
+		t.Errorf("Expected ErrUnknownPepper with a different pepper, got %v", err)
+	}
+
+	// Hashes made before the pepper was set still verify, and are upgraded
+	unpeppered, _ := newTestHasher(t, testConfig()).Hash("secret")
+	if err := hasher.Verify("secret", unpeppered); err != nil {
+		t.Errorf("Expected a hash without a pepper to verify, got %v", err)
+	}
+	if err := hasher.Verify("wrong", unpeppered); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch for a wrong password, got %v", err)
+	}
+	if !hasher.NeedsRehash(unpeppered) {
+		t.Error("Expected a hash without the pepper to need a rehash")
+	}
+}
+
+func TestVerify_RejectsBadHashes(t *testing.T) {
+	hasher := newTestHasher(t, testConfig())
+
+	tests := []struct {
+		name string
+		hash string
+		want error
+	}{
+		{"empty", "", ErrMismatch},
+		{"unknown algorithm", "$md5$abc", ErrUnsupportedHash},
+		{"wrong version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5", ErrUnsupportedHash},
+		{"missing parameters", "$argon2id$v=19$m=64$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5", ErrUnsupportedHash},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5a2V5a2V5a2V5a2V5a2V5", ErrUnsupportedHash},
+		{"truncated", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ", ErrUnsupportedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := hasher.Verify("secret", tt.hash); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_RejectsBadConfig(t *testing.T) {
+	badAlgorithm := testConfig()
+	badAlgorithm.Algorithm = "md5"
+
+	badCost := testConfig()
+	badCost.Algorithm = AlgorithmBcrypt
+	badCost.BcryptCost = 100
+
+	badArgon := testConfig()
+	badArgon.Argon2.Iterations = 0
+
+	bcryptPepper := testConfig()
+	bcryptPepper.Algorithm = AlgorithmBcrypt
+	bcryptPepper.Pepper = "server-secret"
+
+	for name, config := range map[string]Config{"algorithm": badAlgorithm, "bcrypt cost": badCost, "argon2id": badArgon, "bcrypt pepper": bcryptPepper} {
+		if _, err := New(config); err == nil {
+			t.Errorf("Expected an error for a bad %s", name)
+		}
+	}
+}
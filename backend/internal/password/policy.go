@@ -0,0 +1,190 @@
+package password
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PolicyError describes why a new password was rejected. Its message is safe
+// to show to the user.
+type PolicyError struct {
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// Policy holds the rules every new password must meet, wherever it is set
+type Policy struct {
+	MinLength int // In characters
+	MaxLength int // In characters, 0 for no limit
+	MaxBytes  int // In bytes, 0 for no limit. Set to BcryptMaxBytes with bcrypt.
+
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+
+	// RejectPersonalInfo refuses passwords containing the account's name or
+	// the local part of its email address
+	RejectPersonalInfo bool
+
+	// HistorySize is how many previous passwords may not be reused, counting
+	// the current one. 0 allows reuse.
+	HistorySize int
+
+	// Breaches, if set, refuses passwords known from public breaches
+	Breaches BreachSource
+}
+
+// DefaultPolicy returns the rules registration has always enforced, plus a
+// maximum length, a personal information check and a history of five
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:          8,
+		MaxLength:          128,
+		RequireUpper:       true,
+		RequireLower:       true,
+		RequireSpecial:     true,
+		RejectPersonalInfo: true,
+		HistorySize:        5,
+	}
+}
+
+// Account is what the policy needs to know about the account whose password
+// is being set
+type Account struct {
+	Name  string
+	Email string
+
+	// PasswordHashes are the current and previous hashes, newest first
+	PasswordHashes []string
+}
+
+// History stores the hashes of passwords that have been replaced.
+// database.PasswordHistoryRepository implements it.
+type History interface {
+	AddPasswordHash(ctx context.Context, userID int, passwordHash string, keep int) error
+	ListPasswordHashes(ctx context.Context, userID int, limit int) ([]string, error)
+}
+
+// RecentHashes returns the current hash followed by as many previous hashes
+// as the policy forbids reusing, newest first
+func (p Policy) RecentHashes(ctx context.Context, history History, userID int, current string) ([]string, error) {
+	hashes := []string{current}
+	if p.HistorySize <= 1 {
+		return hashes, nil
+	}
+
+	previous, err := history.ListPasswordHashes(ctx, userID, p.HistorySize-1)
+	if err != nil {
+		return nil, err
+	}
+	return append(hashes, previous...), nil
+}
+
+// Remember records a hash that is being replaced, so the policy can refuse
+// it later. Accounts that had no password have nothing to remember.
+func (p Policy) Remember(ctx context.Context, history History, userID int, replaced string) error {
+	if replaced == "" || p.HistorySize <= 1 {
+		return nil
+	}
+	return history.AddPasswordHash(ctx, userID, replaced, p.HistorySize-1)
+}
+
+// Check returns a *PolicyError if the candidate password breaks the policy.
+// The hasher is used to compare it with the account's previous passwords.
+func (p Policy) Check(ctx context.Context, candidate string, account Account, hasher Hasher) error {
+	if err := p.checkRules(candidate, account); err != nil {
+		return err
+	}
+
+	for i, hash := range account.PasswordHashes {
+		if i >= p.HistorySize {
+			break
+		}
+		if hash != "" && hasher.Verify(candidate, hash) == nil {
+			return &PolicyError{"Password must not match a previously used password"}
+		}
+	}
+
+	if p.Breaches != nil {
+		// A source that can't be reached must not stop people setting
+		// passwords, so errors are ignored
+		if breached, err := Breached(ctx, p.Breaches, candidate); err == nil && breached {
+			return &PolicyError{"Password has appeared in a data breach, please choose another"}
+		}
+	}
+
+	return nil
+}
+
+// checkRules applies the rules that need only the candidate itself
+func (p Policy) checkRules(candidate string, account Account) error {
+	length := utf8.RuneCountInString(candidate)
+	if length < p.MinLength {
+		return &PolicyError{fmt.Sprintf("Password must be at least %d characters", p.MinLength)}
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return &PolicyError{fmt.Sprintf("Password must be at most %d characters", p.MaxLength)}
+	}
+	if p.MaxBytes > 0 && len(candidate) > p.MaxBytes {
+		return &PolicyError{fmt.Sprintf("Password must be at most %d bytes", p.MaxBytes)}
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range candidate {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			special = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		return &PolicyError{"Password must contain at least one uppercase letter"}
+	}
+	if p.RequireLower && !lower {
+		return &PolicyError{"Password must contain at least one lowercase letter"}
+	}
+	if p.RequireDigit && !digit {
+		return &PolicyError{"Password must contain at least one number"}
+	}
+	if p.RequireSpecial && !special {
+		return &PolicyError{"Password must contain at least one special character"}
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(candidate, account) {
+		return &PolicyError{"Password must not contain your name or email address"}
+	}
+
+	return nil
+}
+
+// containsPersonalInfo reports whether the candidate contains any part of the
+// account's name or email local part long enough to be meaningful
+func containsPersonalInfo(candidate string, account Account) bool {
+	candidate = strings.ToLower(candidate)
+
+	parts := strings.Fields(account.Name)
+	if local, _, ok := strings.Cut(account.Email, "@"); ok {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		part = strings.ToLower(part)
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(candidate, part) {
+			return true
+		}
+	}
+	return false
+}
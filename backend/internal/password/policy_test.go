@@ -0,0 +1,144 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// stubBreaches is a BreachSource backed by a fixed set of passwords
+type stubBreaches struct {
+	passwords []string
+	err       error
+}
+
+func (s stubBreaches) Range(ctx context.Context, prefix string) (map[string]int, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	suffixes := make(map[string]int)
+	for _, password := range s.passwords {
+		if hash := sha1Hex(password); hash[:prefixLength] == prefix {
+			suffixes[hash[prefixLength:]] = 3
+		}
+	}
+	return suffixes, nil
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.RequireDigit = true
+	policy.MaxLength = 20
+	account := Account{Name: "Jane Doe", Email: "jdoe42@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{"valid", "Correct-Horse9", ""},
+		{"too short", "Ab1!", "Password must be at least 8 characters"},
+		{"too long", "Correct-Horse9-Battery-Staple", "Password must be at most 20 characters"},
+		{"no uppercase", "correct-horse9", "Password must contain at least one uppercase letter"},
+		{"no lowercase", "CORRECT-HORSE9", "Password must contain at least one lowercase letter"},
+		{"no digit", "Correct-Horse!", "Password must contain at least one number"},
+		{"no special", "CorrectHorse9", "Password must contain at least one special character"},
+		{"unicode letters count once", "Äpfel-über9", ""},
+		{"contains name", "Super-JANE-9", "Password must not contain your name or email address"},
+		{"contains email local part", "Jdoe42-Horse!", "Password must not contain your name or email address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), tt.password, account, Default())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) || policyErr.Message != tt.wantErr {
+				t.Errorf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_CheckMaxBytes(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.MaxBytes = BcryptMaxBytes
+	account := Account{Name: "Jane Doe", Email: "jdoe42@example.com"}
+
+	config := testConfig()
+	config.Algorithm = AlgorithmBcrypt
+	config.BcryptCost = bcrypt.MinCost
+	hasher := newTestHasher(t, config)
+
+	// 37 characters, but 72 bytes
+	fits := "Ä1-" + strings.Repeat("ä", 34)
+	if err := policy.Check(context.Background(), fits, account, hasher); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if _, err := hasher.Hash(fits); err != nil {
+		t.Errorf("Hash() of a password the policy allows failed: %v", err)
+	}
+
+	var policyErr *PolicyError
+	err := policy.Check(context.Background(), fits+"a", account, hasher)
+	if !errors.As(err, &policyErr) || policyErr.Message != "Password must be at most 72 bytes" {
+		t.Errorf("Check() error = %v, want the byte limit", err)
+	}
+}
+
+func TestPolicy_CheckHistory(t *testing.T) {
+	hasher := newTestHasher(t, testConfig())
+	current, _ := hasher.Hash("Current-Pass1")
+	previous, _ := hasher.Hash("Previous-Pass1")
+	oldest, _ := hasher.Hash("Oldest-Pass1")
+	account := Account{PasswordHashes: []string{current, previous, oldest}}
+
+	policy := DefaultPolicy()
+	policy.HistorySize = 2
+
+	for _, reused := range []string{"Current-Pass1", "Previous-Pass1"} {
+		if err := policy.Check(context.Background(), reused, account, hasher); err == nil {
+			t.Errorf("Expected %q to be rejected as reused", reused)
+		}
+	}
+
+	// Passwords older than the history may be used again
+	if err := policy.Check(context.Background(), "Oldest-Pass1", account, hasher); err != nil {
+		t.Errorf("Expected a password beyond the history to be allowed, got %v", err)
+	}
+
+	policy.HistorySize = 0
+	if err := policy.Check(context.Background(), "Current-Pass1", account, hasher); err != nil {
+		t.Errorf("Expected reuse to be allowed without a history, got %v", err)
+	}
+}
+
+func TestPolicy_CheckBreaches(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Breaches = stubBreaches{passwords: []string{"Password123!"}}
+
+	err := policy.Check(context.Background(), "Password123!", Account{}, Default())
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Errorf("Expected a breached password to be rejected, got %v", err)
+	}
+
+	if err := policy.Check(context.Background(), "Unbreached-Pass1", Account{}, Default()); err != nil {
+		t.Errorf("Expected an unbreached password to be allowed, got %v", err)
+	}
+
+	// An unavailable source fails open
+	policy.Breaches = stubBreaches{err: errors.New("unreachable")}
+	if err := policy.Check(context.Background(), "Password123!", Account{}, Default()); err != nil {
+		t.Errorf("Expected source errors to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package rbac defines the roles and permissions users can be given, and
+// how the first administrator gets their role. Roles are stored in the
+// database; the ones defined here are created at startup.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+// RoleAdmin is the role of the people running the service. It grants every
+// permission, including ones added later.
+const RoleAdmin = "admin"
+
+// Permissions that roles can grant
+const (
+	PermissionUsersRead        = "users:read"        // Look up accounts
+	PermissionUsersWrite       = "users:write"       // Change other people's accounts
+	PermissionUsersImpersonate = "users:impersonate" // Sign in as someone else to see what they see
+	PermissionRolesRead        = "roles:read"        // See roles and who has them
+	PermissionRolesWrite       = "roles:write"       // Give roles to users and take them away
+	PermissionAuditRead        = "audit:read"        // Read the audit trail of administrators' actions
+)
+
+// Permissions lists every permission a role can grant
+var Permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionUsersImpersonate, PermissionRolesRead, PermissionRolesWrite, PermissionAuditRead}
+
+// DefaultRoles returns the roles every deployment has
+func DefaultRoles() []*database.Role {
+	return []*database.Role{
+		{
+			Name:        RoleAdmin,
+			Description: "Full access to every account and setting",
+			Permissions: []string{database.AllPermissions},
+		},
+	}
+}
+
+// EnsureDefaultRoles creates any default role missing from the database.
+// Roles that already exist are left as they are, so changes made to them
+// by an administrator are kept.
+func EnsureDefaultRoles(ctx context.Context, roles database.RoleRepository) error {
+	for _, role := range DefaultRoles() {
+		if _, err := roles.CreateRole(ctx, role); err != nil && !errors.Is(err, database.ErrRoleExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// BootstrapAdmin gives the admin role to the account with the given email
+// address if nobody has it yet. The address has to be verified, so someone
+// who registers with it before its owner does can't claim the role. It
+// reports whether the role was given.
+func BootstrapAdmin(ctx context.Context, db database.Database, address string) (bool, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return false, nil
+	}
+
+	admins, err := db.Roles().CountRoleUsers(ctx, RoleAdmin)
+	if err != nil || admins > 0 {
+		return false, err
+	}
+
+	user, err := db.Users().GetUserByEmail(ctx, address)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !user.EmailVerified() {
+		return false, nil
+	}
+
+	if err := db.Roles().AssignRole(ctx, user.ID, RoleAdmin); err != nil {
+		return false, err
+	}
+	return true, nil
+}
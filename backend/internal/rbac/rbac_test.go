@@ -0,0 +1,69 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/database"
+)
+
+func TestEnsureDefaultRoles(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+
+	if err := EnsureDefaultRoles(ctx, db.Roles()); err != nil {
+		t.Fatalf("EnsureDefaultRoles() error = %v", err)
+	}
+	// Running again at the next startup changes nothing
+	if err := EnsureDefaultRoles(ctx, db.Roles()); err != nil {
+		t.Fatalf("EnsureDefaultRoles() error = %v", err)
+	}
+
+	admin, err := db.Roles().GetRoleByName(ctx, RoleAdmin)
+	if err != nil {
+		t.Fatalf("GetRoleByName() error = %v", err)
+	}
+	for _, permission := range Permissions {
+		if !admin.HasPermission(permission) {
+			t.Errorf("Expected admin to have %q", permission)
+		}
+	}
+	if roles, _ := db.Roles().ListRoles(ctx); len(roles) != len(DefaultRoles()) {
+		t.Errorf("Expected %d roles, got %d", len(DefaultRoles()), len(roles))
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	db := database.NewMemoryDatabase()
+	ctx := context.Background()
+	EnsureDefaultRoles(ctx, db.Roles())
+
+	verifiedAt := time.Now()
+	owner, _ := db.Users().CreateUser(ctx, &database.User{Name: "Owner", Email: "owner@example.com"})
+	other, _ := db.Users().CreateUser(ctx, &database.User{Name: "Other", Email: "other@example.com", EmailVerifiedAt: &verifiedAt})
+
+	if ok, err := BootstrapAdmin(ctx, db, ""); ok || err != nil {
+		t.Errorf("Expected no address to do nothing, got %v, %v", ok, err)
+	}
+	if ok, err := BootstrapAdmin(ctx, db, "missing@example.com"); ok || err != nil {
+		t.Errorf("Expected an unknown address to do nothing, got %v, %v", ok, err)
+	}
+	if ok, err := BootstrapAdmin(ctx, db, "owner@example.com"); ok || err != nil {
+		t.Errorf("Expected an unverified address to do nothing, got %v, %v", ok, err)
+	}
+
+	owner.EmailVerifiedAt = &verifiedAt
+	db.Users().UpdateUser(ctx, owner)
+	if ok, err := BootstrapAdmin(ctx, db, " Owner@Example.com "); !ok || err != nil {
+		t.Fatalf("Expected the owner to be made admin, got %v, %v", ok, err)
+	}
+	if roles, _ := db.Roles().ListUserRoles(ctx, owner.ID); len(roles) != 1 || roles[0].Name != RoleAdmin {
+		t.Errorf("Expected the owner to have the admin role, got %v", roles)
+	}
+
+	// Once there is an admin, nobody else can be bootstrapped
+	if ok, err := BootstrapAdmin(ctx, db, other.Email); ok || err != nil {
+		t.Errorf("Expected no second bootstrap, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,182 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// XML Signature namespaces and the algorithms we accept. SHA-1 is not
+// among them.
+const (
+	nsDSig   = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14 = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	algExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algECDSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algDigestSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algDigestSHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+// errNotSigned means an element has no signature of its own
+var errNotSigned = errors.New("saml: element is not signed")
+
+// verifySignature checks the enveloped signature that is a direct child of
+// e. The signature must cover e itself, by its ID, and nothing else, so
+// what was verified is exactly what the caller goes on to read. Only the
+// configured certificate is trusted; any KeyInfo in the document is ignored.
+func verifySignature(e *element, cert *x509.Certificate) error {
+	signatures := e.childElements(nsDSig, "Signature")
+	if len(signatures) == 0 {
+		return errNotSigned
+	}
+	if len(signatures) > 1 {
+		return fmt.Errorf("%w: more than one signature", ErrInvalidSignature)
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.child(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("%w: missing SignedInfo", ErrInvalidSignature)
+	}
+
+	c14nMethod := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != algExcC14N {
+		return fmt.Errorf("%w: unsupported canonicalization", ErrInvalidSignature)
+	}
+	signatureMethod := signedInfo.child(nsDSig, "SignatureMethod")
+	if signatureMethod == nil {
+		return fmt.Errorf("%w: missing SignatureMethod", ErrInvalidSignature)
+	}
+
+	reference := signedInfo.child(nsDSig, "Reference")
+	id := e.attr("ID")
+	if reference == nil || id == "" || reference.attr("URI") != "#"+id {
+		return fmt.Errorf("%w: the signature must reference the signed element", ErrInvalidSignature)
+	}
+
+	inclusive, err := referenceTransforms(reference)
+	if err != nil {
+		return err
+	}
+
+	digestMethod := reference.child(nsDSig, "DigestMethod")
+	digestValue := reference.child(nsDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return fmt.Errorf("%w: missing digest", ErrInvalidSignature)
+	}
+	digestHash, err := digestAlgorithm(digestMethod.attr("Algorithm"))
+	if err != nil {
+		return err
+	}
+	expectedDigest, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return fmt.Errorf("%w: malformed digest", ErrInvalidSignature)
+	}
+
+	h := digestHash.New()
+	h.Write(canonicalize(e, inclusive, signature))
+	if subtle.ConstantTimeCompare(h.Sum(nil), expectedDigest) != 1 {
+		return fmt.Errorf("%w: digest mismatch", ErrInvalidSignature)
+	}
+
+	signatureValue := signature.child(nsDSig, "SignatureValue")
+	if signatureValue == nil {
+		return fmt.Errorf("%w: missing SignatureValue", ErrInvalidSignature)
+	}
+	sig, err := decodeBase64(signatureValue.text())
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature value", ErrInvalidSignature)
+	}
+
+	return verifySignedInfo(canonicalize(signedInfo, inclusivePrefixes(c14nMethod), nil),
+		signatureMethod.attr("Algorithm"), sig, cert)
+}
+
+// referenceTransforms checks a reference's transforms are the enveloped
+// signature transform followed by exclusive canonicalization, and returns
+// the canonicalization's inclusive prefixes
+func referenceTransforms(reference *element) ([]string, error) {
+	transforms := reference.child(nsDSig, "Transforms")
+	if transforms == nil {
+		return nil, fmt.Errorf("%w: missing transforms", ErrInvalidSignature)
+	}
+
+	list := transforms.childElements(nsDSig, "Transform")
+	if len(list) != 2 || list[0].attr("Algorithm") != algEnveloped || list[1].attr("Algorithm") != algExcC14N {
+		return nil, fmt.Errorf("%w: unsupported transforms", ErrInvalidSignature)
+	}
+	return inclusivePrefixes(list[1]), nil
+}
+
+// inclusivePrefixes reads the InclusiveNamespaces PrefixList of an
+// exclusive canonicalization method or transform
+func inclusivePrefixes(method *element) []string {
+	inclusive := method.child(nsExcC14, "InclusiveNamespaces")
+	if inclusive == nil {
+		return nil
+	}
+	return strings.Fields(inclusive.attr("PrefixList"))
+}
+
+func digestAlgorithm(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case algDigestSHA256:
+		return crypto.SHA256, nil
+	case algDigestSHA512:
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("%w: unsupported digest %s", ErrInvalidSignature, algorithm)
+	}
+}
+
+// verifySignedInfo checks the signature over the canonical SignedInfo
+func verifySignedInfo(signedInfo []byte, algorithm string, sig []byte, cert *x509.Certificate) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case algRSASHA256, algECDSASHA256:
+		hash = crypto.SHA256
+	case algRSASHA512:
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported signature method %s", ErrInvalidSignature, algorithm)
+	}
+	h := hash.New()
+	h.Write(signedInfo)
+	digest := h.Sum(nil)
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm == algECDSASHA256 || rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+		}
+	case *ecdsa.PublicKey:
+		// XML Signature encodes ECDSA signatures as r and s side by side
+		if algorithm != algECDSASHA256 || len(sig)%2 != 0 {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidSignature)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// decodeBase64 decodes base64 that may be wrapped over several lines
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,504 @@
+// Package saml implements the service provider side of SAML 2.0 web browser
+// SSO: metadata, authentication requests over the HTTP-Redirect binding,
+// and verification of signed responses posted to the assertion consumer
+// service.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors returned when a response is refused
+var (
+	ErrInvalidResponse  = errors.New("saml: invalid response")
+	ErrInvalidSignature = errors.New("saml: invalid signature")
+	ErrNotSigned        = errors.New("saml: response is not signed")
+	ErrStatus           = errors.New("saml: identity provider reported a failure")
+	ErrExpired          = errors.New("saml: assertion is not valid at this time")
+	ErrAudience         = errors.New("saml: assertion is meant for another service provider")
+)
+
+// SAML namespaces, bindings and formats
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	BindingHTTPPost = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+	statusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// maxClockSkew is how far the identity provider's clock may be off from ours
+const maxClockSkew = 3 * time.Minute
+
+// maxResponseSize caps the encoded responses we parse
+const maxResponseSize = 256 << 10
+
+// Config configures a ServiceProvider for one identity provider
+type Config struct {
+	// EntityID identifies us to the identity provider. Assertions must name
+	// it as their audience.
+	EntityID string
+
+	// ACSURL is the assertion consumer service the identity provider posts
+	// responses to
+	ACSURL string
+
+	// IdPEntityID is the identity provider's issuer
+	IdPEntityID string
+
+	// IdPSSOURL is where authentication requests are sent
+	IdPSSOURL string
+
+	// IdPCertificate verifies the identity provider's signatures
+	IdPCertificate *x509.Certificate
+}
+
+// ServiceProvider is our side of the connection to one identity provider
+type ServiceProvider struct {
+	config Config
+}
+
+// NewServiceProvider validates the configuration and creates a ServiceProvider
+func NewServiceProvider(cfg Config) (*ServiceProvider, error) {
+	if cfg.EntityID == "" || cfg.ACSURL == "" || cfg.IdPEntityID == "" || cfg.IdPSSOURL == "" || cfg.IdPCertificate == nil {
+		return nil, errors.New("saml: entity ID, ACS URL, identity provider entity ID, SSO URL and certificate are required")
+	}
+	if u, err := url.Parse(cfg.IdPSSOURL); err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("saml: invalid SSO URL %q", cfg.IdPSSOURL)
+	}
+	return &ServiceProvider{config: cfg}, nil
+}
+
+// ParseCertificate reads a certificate as PEM or as the bare base64 DER
+// found in identity provider metadata
+func ParseCertificate(data string) (*x509.Certificate, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(data)); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("saml: expected a certificate, got %s", block.Type)
+		}
+		der = block.Bytes
+	} else {
+		decoded, err := decodeBase64(data)
+		if err != nil {
+			return nil, errors.New("saml: certificate is neither PEM nor base64")
+		}
+		der = decoded
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("saml: invalid certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// NewRequestID returns a random ID for an authentication request. IDs
+// must not start with a digit.
+func NewRequestID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
+
+// entityDescriptor is the service provider metadata document
+type entityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool            `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool            `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string          `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormat               string          `xml:"NameIDFormat"`
+	AssertionConsumerService   indexedEndpoint `xml:"AssertionConsumerService"`
+}
+
+type indexedEndpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+// Metadata returns the metadata document identity providers are set up
+// from. It doesn't depend on the identity provider, so it can be served
+// before one is configured.
+func Metadata(entityID, acsURL string) []byte {
+	doc, _ := xml.MarshalIndent(entityDescriptor{
+		EntityID: entityID,
+		SPSSODescriptor: spSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: nsProtocol,
+			NameIDFormat:               NameIDFormatEmail,
+			AssertionConsumerService: indexedEndpoint{
+				Binding:   BindingHTTPPost,
+				Location:  acsURL,
+				IsDefault: true,
+			},
+		},
+	}, "", "  ")
+	return append([]byte(xml.Header), doc...)
+}
+
+// authnRequest asks the identity provider to authenticate the user
+type authnRequest struct {
+	XMLName                     xml.Name     `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string       `xml:"ID,attr"`
+	Version                     string       `xml:"Version,attr"`
+	IssueInstant                string       `xml:"IssueInstant,attr"`
+	Destination                 string       `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string       `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string       `xml:"ProtocolBinding,attr"`
+	Issuer                      issuer       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                nameIDPolicy `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+type issuer struct {
+	Value string `xml:",chardata"`
+}
+
+type nameIDPolicy struct {
+	Format      string `xml:"Format,attr"`
+	AllowCreate bool   `xml:"AllowCreate,attr"`
+}
+
+// AuthnRequestURL returns the URL that sends the browser to the identity
+// provider with an authentication request, using the HTTP-Redirect binding.
+// The response will carry requestID in InResponseTo and relayState back.
+func (sp *ServiceProvider) AuthnRequestURL(requestID, relayState string, now time.Time) (string, error) {
+	request, err := xml.Marshal(authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                now.UTC().Format(time.RFC3339),
+		Destination:                 sp.config.IdPSSOURL,
+		AssertionConsumerServiceURL: sp.config.ACSURL,
+		ProtocolBinding:             BindingHTTPPost,
+		Issuer:                      issuer{Value: sp.config.EntityID},
+		NameIDPolicy:                nameIDPolicy{Format: NameIDFormatEmail, AllowCreate: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	writer.Write(request)
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(sp.config.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Assertion is what a verified response says about the user
+type Assertion struct {
+	// ID identifies the assertion, for replay protection
+	ID string
+
+	// Issuer is the identity provider's entity ID
+	Issuer string
+
+	// NameID is the subject's name identifier, and NameIDFormat its format
+	NameID       string
+	NameIDFormat string
+
+	// SessionIndex identifies the user's session at the identity provider
+	SessionIndex string
+
+	// InResponseTo is the ID of the authentication request this answers.
+	// It is empty for IdP-initiated logins.
+	InResponseTo string
+
+	// NotOnOrAfter is when the assertion can no longer be used. Its ID
+	// must be remembered until then.
+	NotOnOrAfter time.Time
+
+	// Attributes maps attribute names, and friendly names where given, to
+	// their values
+	Attributes map[string][]string
+}
+
+// Attribute returns the first value of an attribute, or "" if it is missing
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+// ParseResponse verifies a base64 encoded response from the HTTP-POST
+// binding and returns its assertion. Either the response or the assertion
+// must be signed by the identity provider. The caller still has to check
+// InResponseTo against its pending requests and that the assertion ID
+// hasn't been used before.
+func (sp *ServiceProvider) ParseResponse(encoded string, now time.Time) (*Assertion, error) {
+	if len(encoded) > maxResponseSize {
+		return nil, fmt.Errorf("%w: too large", ErrInvalidResponse)
+	}
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not base64", ErrInvalidResponse)
+	}
+	response, err := parseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	if err := checkUniqueIDs(response); err != nil {
+		return nil, err
+	}
+	if !response.is(nsProtocol, "Response") || response.attr("Version") != "2.0" {
+		return nil, fmt.Errorf("%w: not a SAML 2.0 response", ErrInvalidResponse)
+	}
+	if destination := response.attr("Destination"); destination != "" && destination != sp.config.ACSURL {
+		return nil, fmt.Errorf("%w: sent to %s", ErrInvalidResponse, destination)
+	}
+	if issuer := response.child(nsAssertion, "Issuer"); issuer != nil && issuer.text() != sp.config.IdPEntityID {
+		return nil, fmt.Errorf("%w: issued by %s", ErrInvalidResponse, issuer.text())
+	}
+	if err := checkStatus(response); err != nil {
+		return nil, err
+	}
+
+	if len(response.childElements(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("%w: encrypted assertions are not supported", ErrInvalidResponse)
+	}
+	assertion := response.child(nsAssertion, "Assertion")
+	if assertion == nil {
+		return nil, fmt.Errorf("%w: expected exactly one assertion", ErrInvalidResponse)
+	}
+
+	responseErr := verifySignature(response, sp.config.IdPCertificate)
+	assertionErr := verifySignature(assertion, sp.config.IdPCertificate)
+	for _, err := range []error{responseErr, assertionErr} {
+		if err != nil && !errors.Is(err, errNotSigned) {
+			return nil, err
+		}
+	}
+	if responseErr != nil && assertionErr != nil {
+		return nil, ErrNotSigned
+	}
+
+	return sp.readAssertion(assertion, response.attr("InResponseTo"), now)
+}
+
+// checkUniqueIDs refuses documents where two elements share an ID, so a
+// signature reference can only mean one element
+func checkUniqueIDs(root *element) error {
+	seen := map[string]bool{}
+	var err error
+	root.walk(func(e *element) {
+		if id := e.attr("ID"); id != "" {
+			if seen[id] {
+				err = fmt.Errorf("%w: duplicate ID %s", ErrInvalidResponse, id)
+			}
+			seen[id] = true
+		}
+	})
+	return err
+}
+
+// checkStatus returns ErrStatus unless the response reports success
+func checkStatus(response *element) error {
+	status := response.child(nsProtocol, "Status")
+	if status == nil {
+		return fmt.Errorf("%w: missing status", ErrInvalidResponse)
+	}
+	code := status.child(nsProtocol, "StatusCode")
+	if code == nil {
+		return fmt.Errorf("%w: missing status code", ErrInvalidResponse)
+	}
+	if value := code.attr("Value"); value != statusSuccess {
+		detail := value
+		if sub := code.child(nsProtocol, "StatusCode"); sub != nil {
+			detail += " " + sub.attr("Value")
+		}
+		return fmt.Errorf("%w: %s", ErrStatus, detail)
+	}
+	return nil
+}
+
+// readAssertion checks a verified assertion's issuer, subject, conditions
+// and audience, and reads what it says
+func (sp *ServiceProvider) readAssertion(e *element, inResponseTo string, now time.Time) (*Assertion, error) {
+	if e.attr("Version") != "2.0" || e.attr("ID") == "" {
+		return nil, fmt.Errorf("%w: not a SAML 2.0 assertion", ErrInvalidResponse)
+	}
+	issuer := e.child(nsAssertion, "Issuer")
+	if issuer == nil || issuer.text() != sp.config.IdPEntityID {
+		return nil, fmt.Errorf("%w: assertion has the wrong issuer", ErrInvalidResponse)
+	}
+
+	subject := e.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidResponse)
+	}
+	nameID := subject.child(nsAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, fmt.Errorf("%w: missing name ID", ErrInvalidResponse)
+	}
+
+	confirmedUntil, inResponseTo, err := sp.checkConfirmation(subject, inResponseTo, now)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := e.child(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, fmt.Errorf("%w: missing conditions", ErrInvalidResponse)
+	}
+	notOnOrAfter, err := sp.checkConditions(conditions, now)
+	if err != nil {
+		return nil, err
+	}
+	if notOnOrAfter.IsZero() || confirmedUntil.Before(notOnOrAfter) {
+		notOnOrAfter = confirmedUntil
+	}
+
+	authnStatement := e.child(nsAssertion, "AuthnStatement")
+	if authnStatement == nil {
+		return nil, fmt.Errorf("%w: missing authentication statement", ErrInvalidResponse)
+	}
+
+	return &Assertion{
+		ID:           e.attr("ID"),
+		Issuer:       issuer.text(),
+		NameID:       nameID.text(),
+		NameIDFormat: nameID.attr("Format"),
+		SessionIndex: authnStatement.attr("SessionIndex"),
+		InResponseTo: inResponseTo,
+		NotOnOrAfter: notOnOrAfter.Add(maxClockSkew),
+		Attributes:   readAttributes(e),
+	}, nil
+}
+
+// checkConfirmation looks for a bearer confirmation meant for our ACS URL
+// that is still valid, and returns when it expires. Its InResponseTo must
+// match the response's if both have one, and the one given is returned.
+func (sp *ServiceProvider) checkConfirmation(subject *element, inResponseTo string, now time.Time) (time.Time, string, error) {
+	for _, confirmation := range subject.childElements(nsAssertion, "SubjectConfirmation") {
+		if confirmation.attr("Method") != confirmationBearer {
+			continue
+		}
+		data := confirmation.child(nsAssertion, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != sp.config.ACSURL {
+			continue
+		}
+		confirmed := data.attr("InResponseTo")
+		if confirmed != "" && inResponseTo != "" && confirmed != inResponseTo {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || notOnOrAfter.IsZero() {
+			continue
+		}
+		if !now.Before(notOnOrAfter.Add(maxClockSkew)) {
+			return time.Time{}, "", ErrExpired
+		}
+		if inResponseTo == "" {
+			inResponseTo = confirmed
+		}
+		return notOnOrAfter, inResponseTo, nil
+	}
+	return time.Time{}, "", fmt.Errorf("%w: no bearer confirmation for this service provider", ErrInvalidResponse)
+}
+
+// checkConditions checks the validity window and that every audience
+// restriction names us. It returns the end of the window, if there is one.
+func (sp *ServiceProvider) checkConditions(conditions *element, now time.Time) (time.Time, error) {
+	notBefore, err := parseTime(conditions.attr("NotBefore"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: malformed NotBefore", ErrInvalidResponse)
+	}
+	notOnOrAfter, err := parseTime(conditions.attr("NotOnOrAfter"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: malformed NotOnOrAfter", ErrInvalidResponse)
+	}
+	if !notBefore.IsZero() && now.Add(maxClockSkew).Before(notBefore) {
+		return time.Time{}, ErrExpired
+	}
+	if !notOnOrAfter.IsZero() && !now.Before(notOnOrAfter.Add(maxClockSkew)) {
+		return time.Time{}, ErrExpired
+	}
+
+	restrictions := conditions.childElements(nsAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return time.Time{}, fmt.Errorf("%w: no audience restriction", ErrAudience)
+	}
+	for _, restriction := range restrictions {
+		found := false
+		for _, audience := range restriction.childElements(nsAssertion, "Audience") {
+			if audience.text() == sp.config.EntityID {
+				found = true
+			}
+		}
+		if !found {
+			return time.Time{}, ErrAudience
+		}
+	}
+
+	return notOnOrAfter, nil
+}
+
+// readAttributes collects the values of every attribute statement
+func readAttributes(assertion *element) map[string][]string {
+	attributes := map[string][]string{}
+	for _, statement := range assertion.childElements(nsAssertion, "AttributeStatement") {
+		for _, attr := range statement.childElements(nsAssertion, "Attribute") {
+			var values []string
+			for _, value := range attr.childElements(nsAssertion, "AttributeValue") {
+				values = append(values, value.text())
+			}
+			name, friendlyName := attr.attr("Name"), attr.attr("FriendlyName")
+			if name != "" {
+				attributes[name] = append(attributes[name], values...)
+			}
+			if friendlyName != "" && friendlyName != name {
+				attributes[friendlyName] = append(attributes[friendlyName], values...)
+			}
+		}
+	}
+	return attributes
+}
+
+// parseTime parses an xs:dateTime. An empty value is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
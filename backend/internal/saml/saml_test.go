@@ -0,0 +1,330 @@
+package saml
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/saml/samltest"
+)
+
+const (
+	testEntityID = "https://app.example.com/auth/saml/org-1/metadata"
+	testACSURL   = "https://app.example.com/auth/saml/org-1/acs"
+)
+
+func newTestServiceProvider(t *testing.T) (*ServiceProvider, *samltest.IdP) {
+	t.Helper()
+
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+	cert, err := ParseCertificate(idp.CertificatePEM())
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	sp, err := NewServiceProvider(Config{
+		EntityID:       testEntityID,
+		ACSURL:         testACSURL,
+		IdPEntityID:    idp.EntityID,
+		IdPSSOURL:      idp.SSOURL,
+		IdPCertificate: cert,
+	})
+	if err != nil {
+		t.Fatalf("NewServiceProvider() error = %v", err)
+	}
+	return sp, idp
+}
+
+func testResponse() samltest.Response {
+	return samltest.Response{
+		ACSURL:       testACSURL,
+		Audience:     testEntityID,
+		InResponseTo: "_request-1",
+		NameID:       "ada@example.com",
+		Attributes: []samltest.Attribute{
+			{Name: "email", Value: "ada@example.com"},
+			{Name: "name", Value: "Ada Lovelace"},
+		},
+	}
+}
+
+func TestCanonicalize_ExclusiveSpecExample(t *testing.T) {
+	// The example from section 2.2 of the Exclusive XML Canonicalization spec
+	doc := `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2></n0:local>`
+	root, err := parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatalf("parseDocument() error = %v", err)
+	}
+	elem2 := root.children[0].elem
+
+	got := string(canonicalize(elem2, nil, nil))
+	want := `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`
+	if got != want {
+		t.Errorf("canonicalize() =\n%s\nwant\n%s", got, want)
+	}
+
+	got = string(canonicalize(elem2, []string{"n3"}, nil))
+	want = `<n1:elem2 xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en">
+     <n3:stuff></n3:stuff>
+  </n1:elem2>`
+	if got != want {
+		t.Errorf("canonicalize() with inclusive n3 =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCanonicalize_SortsAttributesAndEscapes(t *testing.T) {
+	doc := `<a xmlns="urn:a" xmlns:z="urn:b" z:x='1' b="&lt;&amp;&gt;&#9;" a="&quot;">x &gt; y &amp; &#13;<b xmlns=""/></a>`
+	root, err := parseDocument([]byte(doc))
+	if err != nil {
+		t.Fatalf("parseDocument() error = %v", err)
+	}
+
+	got := string(canonicalize(root, nil, nil))
+	want := `<a xmlns="urn:a" xmlns:z="urn:b" a="&quot;" b="&lt;&amp;>&#x9;" z:x="1">x &gt; y &amp; &#xD;<b xmlns=""></b></a>`
+	if got != want {
+		t.Errorf("canonicalize() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseResponse_SignedAssertion(t *testing.T) {
+	sp, idp := newTestServiceProvider(t)
+
+	assertion, err := sp.ParseResponse(idp.Respond(testResponse()), time.Now())
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if assertion.NameID != "ada@example.com" || assertion.NameIDFormat != NameIDFormatEmail {
+		t.Errorf("NameID = %q (%s)", assertion.NameID, assertion.NameIDFormat)
+	}
+	if assertion.Issuer != idp.EntityID || assertion.InResponseTo != "_request-1" {
+		t.Errorf("Issuer = %q, InResponseTo = %q", assertion.Issuer, assertion.InResponseTo)
+	}
+	if assertion.ID == "" || assertion.SessionIndex == "" {
+		t.Errorf("ID = %q, SessionIndex = %q", assertion.ID, assertion.SessionIndex)
+	}
+	if got := assertion.Attribute("name"); got != "Ada Lovelace" {
+		t.Errorf("Attribute(name) = %q", got)
+	}
+	if got := assertion.Attribute("missing"); got != "" {
+		t.Errorf("Attribute(missing) = %q", got)
+	}
+	if !assertion.NotOnOrAfter.After(time.Now().Add(5 * time.Minute)) {
+		t.Errorf("NotOnOrAfter = %v, want the assertion's expiry plus clock skew", assertion.NotOnOrAfter)
+	}
+}
+
+func TestParseResponse_SignedResponse(t *testing.T) {
+	sp, idp := newTestServiceProvider(t)
+
+	r := testResponse()
+	r.SignResponse = true
+	if _, err := sp.ParseResponse(idp.Respond(r), time.Now()); err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+}
+
+func TestParseResponse_IdPInitiated(t *testing.T) {
+	sp, idp := newTestServiceProvider(t)
+
+	r := testResponse()
+	r.InResponseTo = ""
+	assertion, err := sp.ParseResponse(idp.Respond(r), time.Now())
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if assertion.InResponseTo != "" {
+		t.Errorf("InResponseTo = %q, want empty", assertion.InResponseTo)
+	}
+}
+
+func TestParseResponse_Refused(t *testing.T) {
+	sp, idp := newTestServiceProvider(t)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		encoded func() string
+		now     time.Time
+		want    error
+	}{
+		{
+			name: "tampered assertion",
+			encoded: func() string {
+				doc := idp.Document(testResponse())
+				return samltest.Encode(strings.Replace(doc, "Ada Lovelace", "Eve", 1))
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "tampered signed response",
+			encoded: func() string {
+				r := testResponse()
+				r.SignResponse = true
+				doc := idp.Document(r)
+				return samltest.Encode(strings.Replace(doc, "ada@example.com", "eve@example.com", 1))
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "unsigned",
+			encoded: func() string {
+				r := testResponse()
+				r.Unsigned = true
+				return idp.Respond(r)
+			},
+			want: ErrNotSigned,
+		},
+		{
+			name: "signed by another key",
+			encoded: func() string {
+				other := samltest.NewIdP(idp.EntityID)
+				return other.Respond(testResponse())
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "wrong audience",
+			encoded: func() string {
+				r := testResponse()
+				r.Audience = "https://other.example.com"
+				return idp.Respond(r)
+			},
+			want: ErrAudience,
+		},
+		{
+			name: "wrong recipient",
+			encoded: func() string {
+				r := testResponse()
+				r.ACSURL = "https://other.example.com/acs"
+				return idp.Respond(r)
+			},
+			want: ErrInvalidResponse,
+		},
+		{
+			name:    "expired",
+			encoded: func() string { return idp.Respond(testResponse()) },
+			now:     now.Add(10 * time.Minute),
+			want:    ErrExpired,
+		},
+		{
+			name:    "not yet valid",
+			encoded: func() string { return idp.Respond(testResponse()) },
+			now:     now.Add(-10 * time.Minute),
+			want:    ErrExpired,
+		},
+		{
+			name: "failure status",
+			encoded: func() string {
+				r := testResponse()
+				r.Status = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+				return idp.Respond(r)
+			},
+			want: ErrStatus,
+		},
+		{
+			name: "duplicate IDs",
+			encoded: func() string {
+				// A second assertion with the signed one's ID, for a verifier
+				// that reads a different element than it checked
+				r := testResponse()
+				r.AssertionID = "_assertion-1"
+				doc := idp.Document(r)
+				return samltest.Encode(strings.Replace(doc, "</samlp:Response>",
+					`<saml:Assertion ID="_assertion-1" Version="2.0"/></samlp:Response>`, 1))
+			},
+			want: ErrInvalidResponse,
+		},
+		{
+			name: "document type declaration",
+			encoded: func() string {
+				doc := idp.Document(testResponse())
+				return samltest.Encode(strings.Replace(doc, "?>", `?><!DOCTYPE r [<!ENTITY e "x">]>`, 1))
+			},
+			want: ErrInvalidResponse,
+		},
+		{
+			name:    "not base64",
+			encoded: func() string { return "%%%" },
+			want:    ErrInvalidResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := tt.now
+			if at.IsZero() {
+				at = now
+			}
+			_, err := sp.ParseResponse(tt.encoded(), at)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ParseResponse() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	sp, idp := newTestServiceProvider(t)
+
+	redirect, err := sp.AuthnRequestURL("_request-1", "relay-1", time.Now())
+	if err != nil {
+		t.Fatalf("AuthnRequestURL() error = %v", err)
+	}
+	if !strings.HasPrefix(redirect, idp.SSOURL+"?") {
+		t.Errorf("AuthnRequestURL() = %s, want the identity provider's SSO URL", redirect)
+	}
+
+	request, err := samltest.ParseRequestURL(redirect)
+	if err != nil {
+		t.Fatalf("ParseRequestURL() error = %v", err)
+	}
+	if request.ID != "_request-1" || request.ACSURL != testACSURL || request.Issuer != testEntityID || request.RelayState != "relay-1" {
+		t.Errorf("request = %+v", request)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var metadata struct {
+		EntityID   string `xml:"entityID,attr"`
+		Descriptor struct {
+			WantAssertionsSigned bool   `xml:"WantAssertionsSigned,attr"`
+			NameIDFormat         string `xml:"NameIDFormat"`
+			ACS                  struct {
+				Binding  string `xml:"Binding,attr"`
+				Location string `xml:"Location,attr"`
+			} `xml:"AssertionConsumerService"`
+		} `xml:"SPSSODescriptor"`
+	}
+	if err := xml.Unmarshal(Metadata(testEntityID, testACSURL), &metadata); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if metadata.EntityID != testEntityID {
+		t.Errorf("entityID = %q", metadata.EntityID)
+	}
+	if !metadata.Descriptor.WantAssertionsSigned || metadata.Descriptor.NameIDFormat != NameIDFormatEmail {
+		t.Errorf("descriptor = %+v", metadata.Descriptor)
+	}
+	if metadata.Descriptor.ACS.Binding != BindingHTTPPost || metadata.Descriptor.ACS.Location != testACSURL {
+		t.Errorf("ACS = %+v", metadata.Descriptor.ACS)
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	idp := samltest.NewIdP("https://idp.example.com/metadata")
+
+	bare := strings.Join(strings.Split(idp.CertificatePEM(), "\n")[1:], "\n")
+	bare = strings.Replace(bare, "-----END CERTIFICATE-----", "", 1)
+	if _, err := ParseCertificate(bare); err != nil {
+		t.Errorf("ParseCertificate(base64) error = %v", err)
+	}
+	if _, err := ParseCertificate("not a certificate"); err == nil {
+		t.Error("ParseCertificate() accepted garbage")
+	}
+}
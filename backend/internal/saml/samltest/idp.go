@@ -0,0 +1,402 @@
+// Package samltest is a SAML identity provider for tests. It signs
+// responses with a locally generated key. The signed elements are written
+// out in canonical form by hand rather than canonicalized by the saml
+// package, so tests check its canonicalization against an independent one.
+package samltest
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Namespace declarations as they are written
+const (
+	nsSAMLP = `xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"`
+	nsSAML  = `xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"`
+	nsXS    = `xmlns:xs="http://www.w3.org/2001/XMLSchema"`
+	nsXSI   = `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`
+	nsDS    = `xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+	nsEC    = `xmlns:ec="http://www.w3.org/2001/10/xml-exc-c14n#"`
+)
+
+// IdP is a fake identity provider
+type IdP struct {
+	EntityID    string
+	SSOURL      string
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// NewIdP generates a key and a self-signed certificate for an identity provider
+func NewIdP(entityID string) *IdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	return &IdP{
+		EntityID:    entityID,
+		SSOURL:      "https://idp.example.com/sso",
+		Key:         key,
+		Certificate: cert,
+	}
+}
+
+// CertificatePEM returns the signing certificate as PEM
+func (i *IdP) CertificatePEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.Certificate.Raw}))
+}
+
+// Request is an authentication request read from a redirect URL
+type Request struct {
+	ID         string
+	ACSURL     string
+	Issuer     string
+	RelayState string
+}
+
+// ParseRequestURL plays the identity provider receiving a browser sent with
+// the HTTP-Redirect binding
+func ParseRequestURL(redirectURL string) (*Request, error) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		return nil, err
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		return nil, err
+	}
+
+	var request struct {
+		ID     string `xml:"ID,attr"`
+		ACSURL string `xml:"AssertionConsumerServiceURL,attr"`
+		Issuer string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	}
+	if err := xml.Unmarshal(inflated, &request); err != nil {
+		return nil, err
+	}
+	if request.ID == "" {
+		return nil, errors.New("authentication request has no ID")
+	}
+
+	return &Request{
+		ID:         request.ID,
+		ACSURL:     request.ACSURL,
+		Issuer:     request.Issuer,
+		RelayState: u.Query().Get("RelayState"),
+	}, nil
+}
+
+// Attribute is an attribute of the signed-in user
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Response describes the response to build
+type Response struct {
+	// ACSURL is the response's Destination and the confirmation's Recipient
+	ACSURL string
+
+	// Audience is the service provider's entity ID
+	Audience string
+
+	// InResponseTo is the request answered, or empty for IdP-initiated login
+	InResponseTo string
+
+	// NameID is the user's email address
+	NameID     string
+	Attributes []Attribute
+
+	// IssuedAt defaults to now. The assertion is valid from a minute before
+	// to five minutes after.
+	IssuedAt time.Time
+
+	// AssertionID defaults to a random ID
+	AssertionID string
+
+	// SignResponse signs the response instead of the assertion, and
+	// Unsigned signs neither
+	SignResponse bool
+	Unsigned     bool
+
+	// Status defaults to success
+	Status string
+}
+
+// Respond returns a response as posted to the assertion consumer service
+func (i *IdP) Respond(r Response) string {
+	return Encode(i.Document(r))
+}
+
+// Encode base64 encodes a response document, for tests that edit one
+func Encode(document string) string {
+	return base64.StdEncoding.EncodeToString([]byte(document))
+}
+
+// Document returns a signed response document
+func (i *IdP) Document(r Response) string {
+	if r.IssuedAt.IsZero() {
+		r.IssuedAt = time.Now()
+	}
+	if r.AssertionID == "" {
+		r.AssertionID = randomID()
+	}
+	if r.Status == "" {
+		r.Status = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	}
+
+	responseID := randomID()
+	assertion := i.assertion(r, !r.SignResponse)
+	if !r.Unsigned && !r.SignResponse {
+		assertion.insertSignature(i.sign(assertion, r.AssertionID))
+	}
+
+	// The document declares every namespace on the root, so the signed
+	// elements inherit what their canonical form declares itself
+	responseAttrs := [][2]string{{"Destination", r.ACSURL}, {"ID", responseID}}
+	if r.InResponseTo != "" {
+		responseAttrs = append(responseAttrs, [2]string{"InResponseTo", r.InResponseTo})
+	}
+	responseAttrs = append(responseAttrs, [2]string{"IssueInstant", timestamp(r.IssuedAt)}, [2]string{"Version", "2.0"})
+
+	response := &node{
+		name:        "samlp:Response",
+		docNS:       []string{nsSAMLP, nsSAML, nsXS, nsXSI},
+		canonicalNS: []string{nsSAMLP, nsXS},
+		attrs:       responseAttrs,
+		children: []*node{
+			{name: "saml:Issuer", canonicalNS: []string{nsSAML}, text: i.EntityID},
+			{name: "samlp:Status", children: []*node{
+				{name: "samlp:StatusCode", attrs: [][2]string{{"Value", r.Status}}},
+			}},
+			assertion,
+		},
+	}
+	if !r.Unsigned && r.SignResponse {
+		response.insertSignature(i.sign(response, responseID))
+	}
+
+	return `<?xml version="1.0" encoding="UTF-8"?>` + response.document()
+}
+
+// assertion builds the assertion. Its canonical form declares xs when it
+// is the signed element, because the signature lists xs as an inclusive
+// prefix, and otherwise leaves that to the response.
+func (i *IdP) assertion(r Response, apex bool) *node {
+	canonicalNS := []string{nsSAML}
+	if apex {
+		canonicalNS = append(canonicalNS, nsXS)
+	}
+
+	confirmationAttrs := [][2]string{}
+	if r.InResponseTo != "" {
+		confirmationAttrs = append(confirmationAttrs, [2]string{"InResponseTo", r.InResponseTo})
+	}
+	confirmationAttrs = append(confirmationAttrs,
+		[2]string{"NotOnOrAfter", timestamp(r.IssuedAt.Add(5 * time.Minute))},
+		[2]string{"Recipient", r.ACSURL})
+
+	attributes := &node{name: "saml:AttributeStatement"}
+	for _, attr := range r.Attributes {
+		attributes.children = append(attributes.children, &node{
+			name: "saml:Attribute",
+			attrs: [][2]string{
+				{"Name", attr.Name},
+				{"NameFormat", "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"},
+			},
+			children: []*node{{
+				name:        "saml:AttributeValue",
+				canonicalNS: []string{nsXSI},
+				attrs:       [][2]string{{"xsi:type", "xs:string"}},
+				text:        attr.Value,
+			}},
+		})
+	}
+
+	return &node{
+		name:        "saml:Assertion",
+		canonicalNS: canonicalNS,
+		attrs:       [][2]string{{"ID", r.AssertionID}, {"IssueInstant", timestamp(r.IssuedAt)}, {"Version", "2.0"}},
+		children: []*node{
+			{name: "saml:Issuer", text: i.EntityID},
+			{name: "saml:Subject", children: []*node{
+				{name: "saml:NameID", attrs: [][2]string{{"Format", "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"}}, text: r.NameID},
+				{name: "saml:SubjectConfirmation", attrs: [][2]string{{"Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer"}}, children: []*node{
+					{name: "saml:SubjectConfirmationData", attrs: confirmationAttrs},
+				}},
+			}},
+			{name: "saml:Conditions", attrs: [][2]string{
+				{"NotBefore", timestamp(r.IssuedAt.Add(-time.Minute))},
+				{"NotOnOrAfter", timestamp(r.IssuedAt.Add(5 * time.Minute))},
+			}, children: []*node{
+				{name: "saml:AudienceRestriction", children: []*node{
+					{name: "saml:Audience", text: r.Audience},
+				}},
+			}},
+			{name: "saml:AuthnStatement", attrs: [][2]string{
+				{"AuthnInstant", timestamp(r.IssuedAt)},
+				{"SessionIndex", "_session-" + r.AssertionID},
+			}, children: []*node{
+				{name: "saml:AuthnContext", children: []*node{
+					{name: "saml:AuthnContextClassRef", text: "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"},
+				}},
+			}},
+			attributes,
+		},
+	}
+}
+
+// sign returns an enveloped signature over the canonical form of target
+func (i *IdP) sign(target *node, id string) *node {
+	digest := sha256.Sum256([]byte(target.canonical()))
+
+	signedInfo := &node{
+		name:        "ds:SignedInfo",
+		canonicalNS: []string{nsDS},
+		children: []*node{
+			{name: "ds:CanonicalizationMethod", attrs: [][2]string{{"Algorithm", "http://www.w3.org/2001/10/xml-exc-c14n#"}}},
+			{name: "ds:SignatureMethod", attrs: [][2]string{{"Algorithm", "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"}}},
+			{name: "ds:Reference", attrs: [][2]string{{"URI", "#" + id}}, children: []*node{
+				{name: "ds:Transforms", children: []*node{
+					{name: "ds:Transform", attrs: [][2]string{{"Algorithm", "http://www.w3.org/2000/09/xmldsig#enveloped-signature"}}},
+					{name: "ds:Transform", attrs: [][2]string{{"Algorithm", "http://www.w3.org/2001/10/xml-exc-c14n#"}}, children: []*node{
+						{name: "ec:InclusiveNamespaces", docNS: []string{nsEC}, canonicalNS: []string{nsEC}, attrs: [][2]string{{"PrefixList", "xs"}}},
+					}},
+				}},
+				{name: "ds:DigestMethod", attrs: [][2]string{{"Algorithm", "http://www.w3.org/2001/04/xmlenc#sha256"}}},
+				{name: "ds:DigestValue", text: base64.StdEncoding.EncodeToString(digest[:])},
+			}},
+		},
+	}
+
+	hashed := sha256.Sum256([]byte(signedInfo.canonical()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.Key, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return &node{
+		name:  "ds:Signature",
+		docNS: []string{nsDS},
+		children: []*node{
+			signedInfo,
+			{name: "ds:SignatureValue", text: base64.StdEncoding.EncodeToString(signature)},
+			{name: "ds:KeyInfo", children: []*node{
+				{name: "ds:X509Data", children: []*node{
+					{name: "ds:X509Certificate", text: base64.StdEncoding.EncodeToString(i.Certificate.Raw)},
+				}},
+			}},
+		},
+	}
+}
+
+// node is an element written two ways: in exclusive canonical form, with
+// the namespace declarations it renders there, and as it appears in the
+// document, with attributes reordered and empty elements self-closed
+type node struct {
+	name        string
+	docNS       []string
+	canonicalNS []string
+	attrs       [][2]string // in canonical order
+	text        string
+	children    []*node
+}
+
+// insertSignature puts a signature after the element's issuer, where the
+// schema wants it
+func (n *node) insertSignature(signature *node) {
+	n.children = append(n.children[:1], append([]*node{signature}, n.children[1:]...)...)
+}
+
+func (n *node) canonical() string {
+	var b strings.Builder
+	b.WriteString("<" + n.name)
+	for _, ns := range n.canonicalNS {
+		b.WriteString(" " + ns)
+	}
+	for _, attr := range n.attrs {
+		b.WriteString(" " + attr[0] + `="` + attrEscaper.Replace(attr[1]) + `"`)
+	}
+	b.WriteString(">" + textEscaper.Replace(n.text))
+	for _, child := range n.children {
+		b.WriteString(child.canonical())
+	}
+	b.WriteString("</" + n.name + ">")
+	return b.String()
+}
+
+func (n *node) document() string {
+	var b strings.Builder
+	b.WriteString("<" + n.name)
+	for _, ns := range n.docNS {
+		b.WriteString(" " + ns)
+	}
+	for j := len(n.attrs) - 1; j >= 0; j-- {
+		b.WriteString(" " + n.attrs[j][0] + "='" + documentAttrEscaper.Replace(n.attrs[j][1]) + "'")
+	}
+	if n.text == "" && len(n.children) == 0 {
+		b.WriteString("/>")
+		return b.String()
+	}
+	b.WriteString(">" + textEscaper.Replace(n.text))
+	for _, child := range n.children {
+		b.WriteString(child.document())
+	}
+	b.WriteString("</" + n.name + ">")
+	return b.String()
+}
+
+// Canonical XML escapes text and attribute values differently
+var (
+	textEscaper         = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper         = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;")
+	documentAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "'", "&apos;")
+)
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "_" + hex.EncodeToString(b)
+}
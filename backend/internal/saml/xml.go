@@ -0,0 +1,361 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// xmlNamespace is bound to the xml prefix in every document
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is a parsed XML element. Signature verification needs the
+// prefixes and namespace declarations as written, which encoding/xml's
+// unmarshalling throws away, so documents are parsed into this tree.
+type element struct {
+	prefix   string
+	local    string
+	attrs    []attribute
+	nsDecls  []nsDecl
+	children []node
+	parent   *element
+}
+
+// attribute is an attribute other than a namespace declaration
+type attribute struct {
+	prefix string
+	local  string
+	value  string
+}
+
+// nsDecl declares a namespace prefix. The default namespace has prefix "".
+type nsDecl struct {
+	prefix string
+	uri    string
+}
+
+// node is either a child element or a run of text
+type node struct {
+	elem *element
+	text string
+}
+
+// parseDocument parses a document into a tree of elements. Comments and
+// processing instructions are dropped, as exclusive canonicalization
+// without comments does. Documents with a DTD are refused, so entity
+// declarations can't change what was signed.
+func parseDocument(data []byte) (*element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var root, current *element
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && current == nil {
+				return nil, errors.New("more than one root element")
+			}
+			el := &element{prefix: t.Name.Space, local: t.Name.Local, parent: current}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					el.nsDecls = append(el.nsDecls, nsDecl{uri: a.Value})
+				case a.Name.Space == "xmlns":
+					el.nsDecls = append(el.nsDecls, nsDecl{prefix: a.Name.Local, uri: a.Value})
+				default:
+					el.attrs = append(el.attrs, attribute{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+				}
+			}
+			if err := el.checkPrefixes(); err != nil {
+				return nil, err
+			}
+
+			if current == nil {
+				root = el
+			} else {
+				current.children = append(current.children, node{elem: el})
+			}
+			current = el
+
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.prefix || t.Name.Local != current.local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			current = current.parent
+
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, node{text: string(t)})
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errors.New("text outside the root element")
+			}
+
+		case xml.Directive:
+			return nil, errors.New("document type declarations are not allowed")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, errors.New("incomplete document")
+	}
+	return root, nil
+}
+
+// checkPrefixes makes sure every prefix the element uses is declared
+func (e *element) checkPrefixes() error {
+	if _, ok := e.lookupNamespace(e.prefix); !ok {
+		return fmt.Errorf("undeclared namespace prefix %q", e.prefix)
+	}
+	for _, a := range e.attrs {
+		if a.prefix == "" {
+			continue
+		}
+		if _, ok := e.lookupNamespace(a.prefix); !ok {
+			return fmt.Errorf("undeclared namespace prefix %q", a.prefix)
+		}
+	}
+	return nil
+}
+
+// lookupNamespace returns the namespace a prefix is bound to in the scope
+// of the element. The default namespace is always in scope, possibly as "".
+func (e *element) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for el := e; el != nil; el = el.parent {
+		for _, decl := range el.nsDecls {
+			if decl.prefix == prefix {
+				return decl.uri, decl.uri != "" || prefix == ""
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// is reports whether the element has the given namespace and local name
+func (e *element) is(namespace, local string) bool {
+	uri, _ := e.lookupNamespace(e.prefix)
+	return e.local == local && uri == namespace
+}
+
+// childElements returns the element's children with the given name
+func (e *element) childElements(namespace, local string) []*element {
+	var found []*element
+	for _, child := range e.children {
+		if child.elem != nil && child.elem.is(namespace, local) {
+			found = append(found, child.elem)
+		}
+	}
+	return found
+}
+
+// child returns the element's only child with the given name, or nil if
+// it has none or more than one
+func (e *element) child(namespace, local string) *element {
+	found := e.childElements(namespace, local)
+	if len(found) != 1 {
+		return nil
+	}
+	return found[0]
+}
+
+// attr returns the value of an attribute without a namespace
+func (e *element) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// text returns the element's own text, trimmed
+func (e *element) text() string {
+	var b strings.Builder
+	for _, child := range e.children {
+		if child.elem == nil {
+			b.WriteString(child.text)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// walk calls fn for the element and each of its descendants
+func (e *element) walk(fn func(*element)) {
+	fn(e)
+	for _, child := range e.children {
+		if child.elem != nil {
+			child.elem.walk(fn)
+		}
+	}
+}
+
+// canonicalize serializes the element and its descendants with Exclusive
+// XML Canonicalization without comments (https://www.w3.org/TR/xml-exc-c14n/).
+// Prefixes in inclusive are rendered wherever they are in scope, like
+// inclusive canonicalization does; "#default" stands for the default
+// namespace. The element skip and its descendants are left out, which is
+// how the enveloped signature transform removes the signature.
+func canonicalize(e *element, inclusive []string, skip *element) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, map[string]string{}, inclusive, skip)
+	return buf.Bytes()
+}
+
+// writeCanonical writes one element. rendered holds the namespace
+// declarations already in effect from output ancestors.
+func writeCanonical(buf *bytes.Buffer, e *element, rendered map[string]string, inclusive []string, skip *element) {
+	// Namespaces are only declared where they are visibly used: by the
+	// element's name or one of its attributes' names
+	used := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.prefix != "" {
+			used[a.prefix] = true
+		}
+	}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		used[prefix] = true
+	}
+
+	var decls []nsDecl
+	for prefix := range used {
+		if prefix == "xml" {
+			continue
+		}
+		uri, inScope := e.lookupNamespace(prefix)
+		if !inScope {
+			continue
+		}
+		previous, ok := rendered[prefix]
+		if ok && previous == uri {
+			continue
+		}
+		if !ok && uri == "" {
+			// An empty default namespace only needs declaring to undo one
+			continue
+		}
+		decls = append(decls, nsDecl{prefix: prefix, uri: uri})
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+
+	attrs := make([]attribute, len(e.attrs))
+	copy(attrs, e.attrs)
+	sort.Slice(attrs, func(i, j int) bool {
+		nsI, nsJ := attrNamespace(e, attrs[i]), attrNamespace(e, attrs[j])
+		if nsI != nsJ {
+			return nsI < nsJ
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := qualifiedName(e.prefix, e.local)
+	buf.WriteByte('<')
+	buf.WriteString(name)
+	for _, decl := range decls {
+		if decl.prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + decl.prefix + `="`)
+		}
+		escapeAttr(buf, decl.uri)
+		buf.WriteByte('"')
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + qualifiedName(a.prefix, a.local) + `="`)
+		escapeAttr(buf, a.value)
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	inScope := rendered
+	if len(decls) > 0 {
+		inScope = make(map[string]string, len(rendered)+len(decls))
+		for prefix, uri := range rendered {
+			inScope[prefix] = uri
+		}
+		for _, decl := range decls {
+			inScope[decl.prefix] = decl.uri
+		}
+	}
+
+	for _, child := range e.children {
+		switch {
+		case child.elem == nil:
+			escapeText(buf, child.text)
+		case child.elem != skip:
+			writeCanonical(buf, child.elem, inScope, inclusive, skip)
+		}
+	}
+
+	buf.WriteString("</" + name + ">")
+}
+
+// attrNamespace returns the namespace of an attribute. Attributes without
+// a prefix have none, whatever the default namespace is.
+func attrNamespace(e *element, a attribute) string {
+	if a.prefix == "" {
+		return ""
+	}
+	uri, _ := e.lookupNamespace(a.prefix)
+	return uri
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func escapeText(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func escapeAttr(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\t':
+			buf.WriteString("&#x9;")
+		case '\n':
+			buf.WriteString("&#xA;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Attributes holds a resource's values by attribute path, lowercase and
+// without the schema URI, such as "username" or "emails.value". Values
+// are compared ignoring case.
+type Attributes map[string][]string
+
+// Filter is a parsed filter expression (RFC 7644 §3.4.2.2). Comparisons
+// can be combined with and, or, not and parentheses. Filters on the
+// values of a multi-valued attribute, such as emails[type eq "work"], are
+// only supported in paths.
+type Filter struct {
+	root node
+}
+
+// Matches reports whether a resource with the given attributes matches
+func (f *Filter) Matches(attributes Attributes) bool {
+	return f.root.matches(attributes)
+}
+
+type node interface {
+	matches(attributes Attributes) bool
+}
+
+// comparison compares an attribute with a value. Value is empty for pr.
+type comparison struct {
+	attribute string
+	operator  string
+	value     string
+	null      bool
+}
+
+func (c *comparison) matches(attributes Attributes) bool {
+	values := attributes[c.attribute]
+	switch {
+	case c.operator == "pr":
+		return len(values) > 0
+	case c.null && c.operator == "eq":
+		return len(values) == 0
+	case c.null && c.operator == "ne":
+		return len(values) > 0
+	case c.operator == "ne":
+		for _, v := range values {
+			if strings.EqualFold(v, c.value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	want := strings.ToLower(c.value)
+	for _, v := range values {
+		v = strings.ToLower(v)
+		var ok bool
+		switch c.operator {
+		case "eq":
+			ok = v == want
+		case "co":
+			ok = strings.Contains(v, want)
+		case "sw":
+			ok = strings.HasPrefix(v, want)
+		case "ew":
+			ok = strings.HasSuffix(v, want)
+		case "gt":
+			ok = v > want
+		case "ge":
+			ok = v >= want
+		case "lt":
+			ok = v < want
+		case "le":
+			ok = v <= want
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (l *logical) matches(attributes Attributes) bool {
+	if l.and {
+		return l.left.matches(attributes) && l.right.matches(attributes)
+	}
+	return l.left.matches(attributes) || l.right.matches(attributes)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n *negation) matches(attributes Attributes) bool {
+	return !n.operand.matches(attributes)
+}
+
+// comparisonOperators lists the operators that take a value
+var comparisonOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(expression string) (*Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected " + p.tokens[p.pos].text)
+	}
+	return &Filter{root: root}, nil
+}
+
+func invalidFilter(detail string) *Error {
+	return NewError(http.StatusBadRequest, ErrorInvalidFilter, "Invalid filter: "+detail)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string // The word, or the decoded string
+}
+
+// tokenize splits a filter into words, JSON strings and parentheses
+func tokenize(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expression); {
+		switch c := expression[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			kind := tokenOpen
+			if c == ')' {
+				kind = tokenClose
+			}
+			tokens = append(tokens, filterToken{kind: kind, text: string(c)})
+			i++
+		case c == '[' || c == ']':
+			return nil, invalidFilter("value filters are not supported")
+		case c == '"':
+			end := i + 1
+			for end < len(expression) && expression[end] != '"' {
+				if expression[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expression) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expression[i:end+1]), &s); err != nil {
+				return nil, invalidFilter("malformed string")
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: s})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expression) && !strings.ContainsRune(" \t()[]\"", rune(expression[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: expression[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalidFilter("empty filter")
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// keyword reports whether the next token is a word, ignoring case, and
+// consumes it if so
+func (p *filterParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) next() (filterToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *filterParser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (node, error) {
+	negated := p.keyword("not")
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOpen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.next(); !ok || t.kind != tokenClose {
+			return nil, invalidFilter("missing )")
+		}
+		if negated {
+			return &negation{operand: inner}, nil
+		}
+		return inner, nil
+	}
+	if negated {
+		return nil, invalidFilter("not must be followed by (")
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (node, error) {
+	attr, ok := p.next()
+	if !ok || attr.kind != tokenWord || !validAttributePath(attr.text) {
+		return nil, invalidFilter("expected an attribute")
+	}
+	op, ok := p.next()
+	if !ok || op.kind != tokenWord {
+		return nil, invalidFilter("expected an operator after " + attr.text)
+	}
+
+	c := &comparison{attribute: NormalizeAttribute(attr.text), operator: strings.ToLower(op.text)}
+	if c.operator == "pr" {
+		return c, nil
+	}
+	if !comparisonOperators[c.operator] {
+		return nil, invalidFilter("unknown operator " + op.text)
+	}
+
+	value, ok := p.next()
+	if !ok || (value.kind != tokenString && value.kind != tokenWord) {
+		return nil, invalidFilter("expected a value after " + op.text)
+	}
+	c.value = value.text
+	if value.kind == tokenWord {
+		// Unquoted values are true, false, null or numbers
+		switch strings.ToLower(value.text) {
+		case "true", "false":
+			c.value = strings.ToLower(value.text)
+		case "null":
+			if c.operator != "eq" && c.operator != "ne" {
+				return nil, invalidFilter("null can only be compared with eq or ne")
+			}
+			c.null = true
+		default:
+			if !json.Valid([]byte(value.text)) {
+				return nil, invalidFilter("strings must be quoted")
+			}
+		}
+	}
+	return c, nil
+}
+
+// NormalizeAttribute lowercases an attribute path and removes its schema
+// URI, so "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName"
+// becomes "name.givenname"
+func NormalizeAttribute(attribute string) string {
+	attribute = strings.ToLower(attribute)
+	if strings.HasPrefix(attribute, "urn:") {
+		attribute = attribute[strings.LastIndex(attribute, ":")+1:]
+	}
+	return attribute
+}
+
+// validAttributePath reports whether s can be an attribute path
+func validAttributePath(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".:_-$", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Path is the target of a patch operation (RFC 7644 §3.5.2), such as
+// "displayName", "name.givenName", members[value eq "2"] or
+// emails[type eq "work"].value
+type Path struct {
+	// Attribute is lowercase and without the schema URI
+	Attribute string
+
+	// Filter selects values of a multi-valued attribute. Their
+	// sub-attributes, such as "value" and "type", are matched.
+	Filter *Filter
+
+	// SubAttribute follows a filter, lowercase
+	SubAttribute string
+}
+
+// ParsePath parses the path of a patch operation
+func ParsePath(path string) (*Path, error) {
+	attribute, rest, hasFilter := strings.Cut(path, "[")
+	if !validAttributePath(attribute) {
+		return nil, NewError(http.StatusBadRequest, ErrorInvalidPath, "Invalid path "+path)
+	}
+	p := &Path{Attribute: NormalizeAttribute(attribute)}
+	if !hasFilter {
+		return p, nil
+	}
+
+	end := strings.LastIndex(rest, "]")
+	if end < 0 {
+		return nil, NewError(http.StatusBadRequest, ErrorInvalidPath, "Invalid path "+path)
+	}
+	filter, err := ParseFilter(rest[:end])
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, ErrorInvalidPath, "Invalid filter in path "+path)
+	}
+	p.Filter = filter
+
+	if sub := rest[end+1:]; sub != "" {
+		if !strings.HasPrefix(sub, ".") || !validAttributePath(sub[1:]) {
+			return nil, NewError(http.StatusBadRequest, ErrorInvalidPath, "Invalid path "+path)
+		}
+		p.SubAttribute = strings.ToLower(sub[1:])
+	}
+	return p, nil
+}
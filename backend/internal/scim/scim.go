@@ -0,0 +1,270 @@
+// Package scim implements the parts of SCIM 2.0 (RFC 7643 and RFC 7644)
+// the provisioning endpoints need: the User and Group resources, list,
+// patch and error messages, filters and attribute paths, and the bearer
+// tokens identity providers authenticate with. Like API keys, tokens are
+// opaque: the server stores only their hash.
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/token"
+)
+
+// Schema URIs of the resources and messages (RFC 7643 §8.7.1, RFC 7644 §3)
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// MediaType is the content type of SCIM requests and responses
+const MediaType = "application/scim+json"
+
+// TokenPrefix starts every token, so tokens are recognisable in configs
+// and logs
+const TokenPrefix = "gsst_"
+
+// displayLength is how much of a token is kept in the clear to identify it
+const displayLength = len(TokenPrefix) + 8
+
+// Error types sent in the scimType field (RFC 7644 §3.12)
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorNoTarget      = "noTarget"
+	ErrorInvalidValue  = "invalidValue"
+)
+
+// Patch operations (RFC 7644 §3.5.2). Clients differ in how they
+// capitalize them, so they are compared ignoring case.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// GenerateToken returns a new token and the prefix shown to identify it
+func GenerateToken() (raw, displayPrefix string, err error) {
+	secret, err := token.GenerateOpaque()
+	if err != nil {
+		return "", "", err
+	}
+	raw = TokenPrefix + secret
+	return raw, raw[:displayLength], nil
+}
+
+// IsToken reports whether a bearer credential is a SCIM token
+func IsToken(raw string) bool {
+	return strings.HasPrefix(raw, TokenPrefix)
+}
+
+// HashToken returns the hash a token is stored and looked up by
+func HashToken(raw string) string {
+	return token.HashOpaque(raw)
+}
+
+// Error is a SCIM error. It is sent as an ErrorResponse.
+type Error struct {
+	Status int
+	Type   string // scimType, if one applies
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Type != "" {
+		return "scim: " + e.Type + ": " + e.Detail
+	}
+	return "scim: " + e.Detail
+}
+
+// NewError returns an error with a status and detail, and a scimType if
+// typ isn't empty
+func NewError(status int, typ, detail string) *Error {
+	return &Error{Status: status, Type: typ, Detail: detail}
+}
+
+// ErrorResponse is the body of an error (RFC 7644 §3.12). The status is
+// sent as a string.
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Response returns the body an error is sent as
+func (e *Error) Response() ErrorResponse {
+	return ErrorResponse{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(e.Status),
+		ScimType: e.Type,
+		Detail:   e.Detail,
+	}
+}
+
+// Meta describes a resource (RFC 7643 §3.1)
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// Name is the components of a user's name (RFC 7643 §4.1.1)
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+// Joined returns the formatted name, or the given and family names
+// joined if there is none
+func (n *Name) Joined() string {
+	if n == nil {
+		return ""
+	}
+	if formatted := strings.TrimSpace(n.Formatted); formatted != "" {
+		return formatted
+	}
+	return strings.TrimSpace(strings.TrimSpace(n.GivenName) + " " + strings.TrimSpace(n.FamilyName))
+}
+
+// MultiValue is one value of a multi-valued attribute, such as an email
+// address, a group of a user or a member of a group (RFC 7643 §2.4)
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is the User resource (RFC 7643 §4.1). Active is nil when a request
+// leaves it out. Groups is read-only.
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Groups      []MultiValue `json:"groups,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// Group is the Group resource (RFC 7643 §4.2)
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// ListResponse is the body of a query (RFC 7644 §3.4.2)
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse pages through resources. startIndex counts from 1.
+func NewListResponse(resources []any, startIndex, count int) ListResponse {
+	page := []any{}
+	if from := startIndex - 1; from < len(resources) {
+		page = resources[from:min(len(resources), from+count)]
+	}
+	return ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// PatchRequest is the body of a PATCH request (RFC 7644 §3.5.2)
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one change in a PATCH request. Value is left raw, as
+// its type depends on the path.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate checks a PATCH request carries the PatchOp schema and known
+// operations
+func (p *PatchRequest) Validate() error {
+	found := false
+	for _, schema := range p.Schemas {
+		if schema == SchemaPatchOp {
+			found = true
+		}
+	}
+	if !found {
+		return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "Request must use the PatchOp schema")
+	}
+	if len(p.Operations) == 0 {
+		return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "Request has no operations")
+	}
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case OpAdd, OpReplace:
+			if len(op.Value) == 0 {
+				return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "Operation "+op.Op+" needs a value")
+			}
+		case OpRemove:
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, ErrorNoTarget, "Remove operations need a path")
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "Unknown operation "+strconv.Quote(op.Op))
+		}
+	}
+	return nil
+}
+
+// ParseBool reads a boolean value. Some identity providers send booleans
+// as the strings "True" and "False".
+func ParseBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, NewError(http.StatusBadRequest, ErrorInvalidValue, "Expected a boolean")
+}
+
+// ParseString reads a string value
+func ParseString(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", NewError(http.StatusBadRequest, ErrorInvalidValue, "Expected a string")
+	}
+	return s, nil
+}
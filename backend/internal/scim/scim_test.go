@@ -0,0 +1,193 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatches(t *testing.T) {
+	alice := Attributes{
+		"id":           {"7"},
+		"username":     {"Alice@Example.com"},
+		"externalid":   {"00u1"},
+		"active":       {"true"},
+		"emails.value": {"alice@example.com", "alice@home.example"},
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "alice@example.com"`, true},
+		{`USERNAME EQ "ALICE@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "alice@example.com"`, true},
+		{`userName eq "bob@example.com"`, false},
+		{`userName ne "bob@example.com"`, true},
+		{`userName co "example"`, true},
+		{`userName sw "alice"`, true},
+		{`userName ew ".org"`, false},
+		{`emails.value eq "alice@home.example"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`externalId pr`, true},
+		{`name.givenName pr`, false},
+		{`name.givenName eq null`, true},
+		{`id gt "6"`, true},
+		{`userName sw "alice" and externalId eq "00u2"`, false},
+		{`userName sw "bob" or externalId eq "00u1"`, true},
+		{`userName sw "bob" or userName sw "carol" and active eq true`, false},
+		{`not (userName sw "bob")`, true},
+		{`(userName sw "bob" or userName sw "alice") and active eq true`, true},
+		{`userName eq "say \"hi\""`, false},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) error = %v", tt.filter, err)
+			continue
+		}
+		if got := filter.Matches(alice); got != tt.want {
+			t.Errorf("ParseFilter(%s).Matches() = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterRefusesInvalidFilters(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName is "alice"`,
+		`userName eq alice`,
+		`userName eq "alice`,
+		`userName co null`,
+		`(userName eq "alice"`,
+		`userName eq "alice" and`,
+		`userName eq "alice" extra`,
+		`not userName eq "alice"`,
+		`emails[type eq "work"].value eq "alice@example.com"`,
+		`user!name eq "alice"`,
+	} {
+		_, err := ParseFilter(filter)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.Type != ErrorInvalidFilter || scimErr.Status != 400 {
+			t.Errorf("ParseFilter(%s) error = %v, want an invalidFilter error", filter, err)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path, err := ParsePath("urn:ietf:params:scim:schemas:core:2.0:User:name.givenName")
+	if err != nil || path.Attribute != "name.givenname" || path.Filter != nil {
+		t.Errorf("ParsePath() = %+v, %v", path, err)
+	}
+
+	path, err = ParsePath(`emails[type eq "work"].value`)
+	if err != nil {
+		t.Fatalf("ParsePath() error = %v", err)
+	}
+	if path.Attribute != "emails" || path.SubAttribute != "value" {
+		t.Errorf("ParsePath() = %+v", path)
+	}
+	if !path.Filter.Matches(Attributes{"type": {"work"}}) || path.Filter.Matches(Attributes{"type": {"home"}}) {
+		t.Error("Expected the path's filter to select work emails")
+	}
+
+	path, err = ParsePath(`members[value eq "2"]`)
+	if err != nil || path.Attribute != "members" || path.SubAttribute != "" || !path.Filter.Matches(Attributes{"value": {"2"}}) {
+		t.Errorf("ParsePath() = %+v, %v", path, err)
+	}
+
+	for _, invalid := range []string{"", "na me", `members[value eq "2"`, `members[value eq]`, `emails[type eq "work"]value`} {
+		_, err := ParsePath(invalid)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.Type != ErrorInvalidPath {
+			t.Errorf("ParsePath(%q) error = %v, want an invalidPath error", invalid, err)
+		}
+	}
+}
+
+func TestNewListResponse(t *testing.T) {
+	resources := []any{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		startIndex, count int
+		want              []any
+	}{
+		{1, 2, []any{"a", "b"}},
+		{4, 10, []any{"d", "e"}},
+		{6, 10, []any{}},
+		{2, 0, []any{}},
+	}
+	for _, tt := range tests {
+		list := NewListResponse(resources, tt.startIndex, tt.count)
+		if list.TotalResults != 5 || list.StartIndex != tt.startIndex || list.ItemsPerPage != len(tt.want) {
+			t.Errorf("NewListResponse(%d, %d) = %+v", tt.startIndex, tt.count, list)
+		}
+		for i := range tt.want {
+			if list.Resources[i] != tt.want[i] {
+				t.Errorf("NewListResponse(%d, %d).Resources = %v, want %v", tt.startIndex, tt.count, list.Resources, tt.want)
+				break
+			}
+		}
+	}
+
+	body, _ := json.Marshal(NewListResponse(nil, 1, 10))
+	if !strings.Contains(string(body), `"Resources":[]`) || !strings.Contains(string(body), SchemaListResponse) {
+		t.Errorf("Unexpected empty list response %s", body)
+	}
+}
+
+func TestPatchRequestValidate(t *testing.T) {
+	valid := `{"schemas":["` + SchemaPatchOp + `"],"Operations":[
+		{"op":"Replace","path":"active","value":false},
+		{"op":"add","value":{"displayName":"Alice"}},
+		{"op":"remove","path":"externalId"}]}`
+	var req PatchRequest
+	if err := json.Unmarshal([]byte(valid), &req); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := req.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	for _, invalid := range []string{
+		`{"schemas":["urn:example"],"Operations":[{"op":"remove","path":"externalId"}]}`,
+		`{"schemas":["` + SchemaPatchOp + `"],"Operations":[]}`,
+		`{"schemas":["` + SchemaPatchOp + `"],"Operations":[{"op":"move","path":"externalId"}]}`,
+		`{"schemas":["` + SchemaPatchOp + `"],"Operations":[{"op":"add","path":"externalId"}]}`,
+		`{"schemas":["` + SchemaPatchOp + `"],"Operations":[{"op":"remove"}]}`,
+	} {
+		var req PatchRequest
+		json.Unmarshal([]byte(invalid), &req)
+		if err := req.Validate(); err == nil {
+			t.Errorf("Expected %s to be refused", invalid)
+		}
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for raw, want := range map[string]bool{`true`: true, `false`: false, `"True"`: true, `"False"`: false} {
+		if got, err := ParseBool(json.RawMessage(raw)); err != nil || got != want {
+			t.Errorf("ParseBool(%s) = %v, %v", raw, got, err)
+		}
+	}
+	if _, err := ParseBool(json.RawMessage(`"yes"`)); err == nil {
+		t.Error("Expected ParseBool to refuse a string that isn't a boolean")
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	raw, prefix, err := GenerateToken()
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	if !IsToken(raw) || !strings.HasPrefix(raw, prefix) || len(prefix) != displayLength {
+		t.Errorf("GenerateToken() = %q, %q", raw, prefix)
+	}
+	if HashToken(raw) == raw || HashToken(raw) != HashToken(raw) {
+		t.Error("Expected HashToken to be a stable hash")
+	}
+}
@@ -0,0 +1,150 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set document
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes the public half of an RS256, ES256 or EdDSA key
+func NewJWK(keyID string, key interface{}) (JWK, error) {
+	enc := base64.RawURLEncoding
+
+	switch k := publicKey(key).(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     keyID,
+			Use:       "sig",
+			Algorithm: string(RS256),
+			N:         enc.EncodeToString(k.N.Bytes()),
+			E:         enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("%w: only P-256 EC keys are supported", ErrInvalidKey)
+		}
+		point, err := k.Bytes()
+		if err != nil {
+			return JWK{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return JWK{
+			KeyType:   "EC",
+			KeyID:     keyID,
+			Use:       "sig",
+			Algorithm: string(ES256),
+			Curve:     "P-256",
+			X:         enc.EncodeToString(point[1:33]),
+			Y:         enc.EncodeToString(point[33:]),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     keyID,
+			Use:       "sig",
+			Algorithm: string(EdDSA),
+			Curve:     "Ed25519",
+			X:         enc.EncodeToString(k),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, key)
+	}
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (k JWK) PublicKey() (interface{}, error) {
+	enc := base64.RawURLEncoding
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := enc.DecodeString(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("%w: invalid RSA modulus", ErrInvalidKey)
+		}
+		e, err := enc.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA exponent", ErrInvalidKey)
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA keys must be at least 2048 bits", ErrInvalidKey)
+		}
+		return key, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("%w: unsupported curve %q", ErrInvalidKey, k.Curve)
+		}
+		x, errX := enc.DecodeString(k.X)
+		y, errY := enc.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid EC point", ErrInvalidKey)
+		}
+		point := append([]byte{4}, append(x, y...)...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid EC point", ErrInvalidKey)
+		}
+		return key, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("%w: unsupported curve %q", ErrInvalidKey, k.Curve)
+		}
+		x, err := enc.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key", ErrInvalidKey)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %q", ErrInvalidKey, k.KeyType)
+	}
+}
+
+// SigningAlgorithm returns the algorithm the key is used with. Keys that don't
+// name one get the only algorithm this package supports for their type.
+func (k JWK) SigningAlgorithm() (Algorithm, error) {
+	var alg Algorithm
+	switch k.KeyType {
+	case "RSA":
+		alg = RS256
+	case "EC":
+		alg = ES256
+	case "OKP":
+		alg = EdDSA
+	default:
+		return "", fmt.Errorf("%w: unsupported key type %q", ErrInvalidKey, k.KeyType)
+	}
+
+	if k.Algorithm != "" && Algorithm(k.Algorithm) != alg {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, k.Algorithm)
+	}
+	return alg, nil
+}
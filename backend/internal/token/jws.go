@@ -0,0 +1,218 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Algorithm identifies a JWS signing algorithm
+type Algorithm string
+
+const (
+	HS256 Algorithm = "HS256"
+	RS256 Algorithm = "RS256"
+	ES256 Algorithm = "ES256"
+	EdDSA Algorithm = "EdDSA"
+)
+
+// Header represents the JOSE header of a signed token
+type Header struct {
+	Algorithm Algorithm `json:"alg"`
+	KeyID     string    `json:"kid,omitempty"`
+	Type      string    `json:"typ,omitempty"`
+}
+
+// JWS is a decoded, not yet verified, compact JWS
+type JWS struct {
+	Header    Header
+	Payload   []byte
+	Signature []byte
+
+	signingInput string
+}
+
+// Decode splits a compact JWS into its parts without verifying the signature
+func Decode(raw string) (*JWS, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 segments, got %d", ErrMalformed, len(parts))
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid header encoding", ErrMalformed)
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header", ErrMalformed)
+	}
+
+	if header.Algorithm == "" {
+		return nil, fmt.Errorf("%w: missing alg header", ErrMalformed)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid payload encoding", ErrMalformed)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrMalformed)
+	}
+
+	return &JWS{
+		Header:       header,
+		Payload:      payload,
+		Signature:    signature,
+		signingInput: parts[0] + "." + parts[1],
+	}, nil
+}
+
+// Verify checks the signature with the given algorithm and key. The algorithm
+// must be chosen by the caller rather than taken from the header so that a
+// token cannot downgrade itself to a weaker algorithm.
+func (j *JWS) Verify(alg Algorithm, key interface{}) error {
+	if j.Header.Algorithm != alg {
+		return fmt.Errorf("%w: token uses %s, expected %s", ErrSignatureInvalid, j.Header.Algorithm, alg)
+	}
+	return verifySignature(alg, key, []byte(j.signingInput), j.Signature)
+}
+
+// Sign produces a compact JWS for the given header and payload
+func Sign(header Header, payload []byte, key interface{}) (string, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode header: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+
+	signature, err := createSignature(header.Algorithm, key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func createSignature(alg Algorithm, key interface{}, input []byte) ([]byte, error) {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return nil, fmt.Errorf("%w: HS256 requires a secret", ErrInvalidKey)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: RS256 requires an RSA private key", ErrInvalidKey)
+		}
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:])
+	case ES256:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: ES256 requires an ECDSA private key", ErrInvalidKey)
+		}
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-width r||s encoding rather than ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case EdDSA:
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: EdDSA requires an Ed25519 private key", ErrInvalidKey)
+		}
+		return ed25519.Sign(priv, input), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+func verifySignature(alg Algorithm, key interface{}, input, signature []byte) error {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return fmt.Errorf("%w: HS256 requires a secret", ErrInvalidKey)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	case RS256:
+		pub, ok := publicKey(key).(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: RS256 requires an RSA public key", ErrInvalidKey)
+		}
+		digest := sha256.Sum256(input)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrSignatureInvalid
+		}
+		return nil
+	case ES256:
+		pub, ok := publicKey(key).(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: ES256 requires an ECDSA public key", ErrInvalidKey)
+		}
+		if len(signature) != 64 {
+			return ErrSignatureInvalid
+		}
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	case EdDSA:
+		pub, ok := publicKey(key).(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: EdDSA requires an Ed25519 public key", ErrInvalidKey)
+		}
+		if !ed25519.Verify(pub, input, signature) {
+			return ErrSignatureInvalid
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// publicKey returns the public half of a private key, or the key unchanged
+func publicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	default:
+		return key
+	}
+}
@@ -0,0 +1,359 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Token types carried in the "typ" claim
+const (
+	TypeAccess       = "access"
+	TypeMFAChallenge = "mfa_challenge" // Proves the password step of a two-factor login
+
+	TypeWebAuthnRegistration = "webauthn_registration" // Carries a pending passkey registration challenge
+	TypeWebAuthnLogin        = "webauthn_login"        // Carries a pending passkey login challenge
+
+	TypeAccountUnlock = "account_unlock" // Emailed to lift a login lockout early
+	TypeLoginReport   = "login_report"   // Emailed with a new-device alert to report the sign-in
+
+	TypeCSRF = "csrf" // Sent back by browsers using session cookies to prove a request came from the app
+)
+
+// Errors returned when a token fails verification
+var (
+	ErrMalformed            = errors.New("token is malformed")
+	ErrSignatureInvalid     = errors.New("token signature is invalid")
+	ErrExpired              = errors.New("token has expired")
+	ErrNotYetValid          = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("token issuer is invalid")
+	ErrInvalidAudience      = errors.New("token audience is invalid")
+	ErrInvalidType          = errors.New("token type is invalid")
+	ErrUnknownKey           = errors.New("token signing key is unknown")
+	ErrInvalidKey           = errors.New("invalid signing key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// Audience is the "aud" claim, which may be a single string or an array
+type Audience []string
+
+// MarshalJSON encodes a single audience as a plain string
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts both the string and the array form
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// Contains reports whether the audience includes the given value
+func (a Audience) Contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims holds the registered JWT claims plus the ones this application uses
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	// Type distinguishes access tokens from other token kinds we sign
+	Type string `json:"typ,omitempty"`
+
+	// SessionID ties an access token to the server-side session it was issued for
+	SessionID string `json:"sid,omitempty"`
+
+	// OrganizationID is the organization the session works in, if any
+	OrganizationID int `json:"org,omitempty"`
+
+	// Nonce binds a ceremony token to the random challenge it was issued for
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// UserID returns the subject parsed as a numeric user ID
+func (c *Claims) UserID() (int, error) {
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%w: subject is not a user ID", ErrMalformed)
+	}
+	return id, nil
+}
+
+// Config configures a Manager
+type Config struct {
+	// Algorithm used to sign new tokens
+	Algorithm Algorithm
+
+	// Secret is the HMAC key for HS256
+	Secret []byte
+
+	// PrivateKey is the signing key for RS256 or EdDSA
+	PrivateKey interface{}
+
+	// KeyID is written to the "kid" header of issued tokens
+	KeyID string
+
+	Issuer   string
+	Audience string
+
+	// TTL is the default lifetime of issued tokens
+	TTL time.Duration
+
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+// verificationKey is a key accepted when verifying tokens
+type verificationKey struct {
+	algorithm Algorithm
+	key       interface{}
+}
+
+// Manager issues and verifies signed JWTs
+type Manager struct {
+	algorithm  Algorithm
+	keyID      string
+	signingKey interface{}
+	keys       map[string]verificationKey
+	issuer     string
+	audience   string
+	ttl        time.Duration
+	leeway     time.Duration
+	now        func() time.Time
+}
+
+// NewManager creates a new token manager
+func NewManager(cfg Config) (*Manager, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("token issuer and audience are required")
+	}
+
+	var signingKey interface{}
+	switch cfg.Algorithm {
+	case HS256:
+		if len(cfg.Secret) < 32 {
+			return nil, fmt.Errorf("%w: HS256 secret must be at least 32 bytes", ErrInvalidKey)
+		}
+		signingKey = cfg.Secret
+	case RS256:
+		key, ok := cfg.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: RS256 requires an RSA private key", ErrInvalidKey)
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA keys must be at least 2048 bits", ErrInvalidKey)
+		}
+		signingKey = key
+	case EdDSA:
+		key, ok := cfg.PrivateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: EdDSA requires an Ed25519 private key", ErrInvalidKey)
+		}
+		signingKey = key
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, cfg.Algorithm)
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+
+	m := &Manager{
+		algorithm:  cfg.Algorithm,
+		keyID:      cfg.KeyID,
+		signingKey: signingKey,
+		keys:       make(map[string]verificationKey),
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		ttl:        ttl,
+		leeway:     cfg.Leeway,
+		now:        time.Now,
+	}
+	m.keys[cfg.KeyID] = verificationKey{algorithm: cfg.Algorithm, key: publicKey(signingKey)}
+
+	return m, nil
+}
+
+// AddVerificationKey registers an additional key that tokens may be verified
+// with, typically the previous key during a rotation
+func (m *Manager) AddVerificationKey(keyID string, alg Algorithm, key interface{}) error {
+	if keyID == m.keyID {
+		return fmt.Errorf("%w: key ID %q is already the signing key", ErrInvalidKey, keyID)
+	}
+	m.keys[keyID] = verificationKey{algorithm: alg, key: publicKey(key)}
+	return nil
+}
+
+// TTL returns the default token lifetime
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Issue signs the claims, filling in the registered claims that are unset
+func (m *Manager) Issue(claims Claims) (string, error) {
+	now := m.now()
+
+	if claims.Issuer == "" {
+		claims.Issuer = m.issuer
+	}
+	if len(claims.Audience) == 0 {
+		claims.Audience = Audience{m.audience}
+	}
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = now.Unix()
+	}
+	if claims.NotBefore == 0 {
+		claims.NotBefore = now.Unix()
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = now.Add(m.ttl).Unix()
+	}
+	if claims.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return "", err
+		}
+		claims.ID = id
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claims: %w", err)
+	}
+
+	header := Header{Algorithm: m.algorithm, KeyID: m.keyID, Type: "JWT"}
+	return Sign(header, payload, m.signingKey)
+}
+
+// Verify checks the signature and registered claims of a token
+func (m *Manager) Verify(raw string) (*Claims, error) {
+	jws, err := Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := m.keys[jws.Header.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if err := jws.Verify(key.algorithm, key.key); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(jws.Payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims", ErrMalformed)
+	}
+
+	if err := m.validate(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// VerifyType verifies a token and additionally requires the given "typ" claim
+func (m *Manager) VerifyType(raw, tokenType string) (*Claims, error) {
+	claims, err := m.Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != tokenType {
+		return nil, ErrInvalidType
+	}
+	return claims, nil
+}
+
+func (m *Manager) validate(claims *Claims) error {
+	now := m.now()
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp claim", ErrMalformed)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(m.leeway)) {
+		return ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(m.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if claims.Issuer != m.issuer {
+		return ErrInvalidIssuer
+	}
+	if !claims.Audience.Contains(m.audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+// newID generates a random token identifier for the "jti" claim
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key
+func ParsePrivateKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			return k, nil
+		default:
+			return nil, fmt.Errorf("%w: unsupported PKCS#8 key type %T", ErrInvalidKey, key)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported PEM block %q", ErrInvalidKey, block.Type)
+	}
+}
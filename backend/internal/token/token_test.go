@@ -0,0 +1,352 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-bytes"
+
+func newTestManager(t *testing.T, alg Algorithm) *Manager {
+	t.Helper()
+
+	cfg := Config{
+		Algorithm: alg,
+		KeyID:     "key-1",
+		Issuer:    "https://auth.example.com",
+		Audience:  "example-api",
+		TTL:       time.Minute,
+	}
+
+	switch alg {
+	case HS256:
+		cfg.Secret = []byte(testSecret)
+	case RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		cfg.PrivateKey = key
+	case EdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate Ed25519 key: %v", err)
+		}
+		cfg.PrivateKey = key
+	}
+
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return m
+}
+
+func TestManager_IssueAndVerify(t *testing.T) {
+	for _, alg := range []Algorithm{HS256, RS256, EdDSA} {
+		t.Run(string(alg), func(t *testing.T) {
+			m := newTestManager(t, alg)
+
+			raw, err := m.Issue(Claims{Subject: "42", Type: TypeAccess})
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+
+			jws, err := Decode(raw)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if jws.Header.Algorithm != alg {
+				t.Errorf("Expected alg %s, got %s", alg, jws.Header.Algorithm)
+			}
+			if jws.Header.KeyID != "key-1" {
+				t.Errorf("Expected kid 'key-1', got '%s'", jws.Header.KeyID)
+			}
+
+			claims, err := m.VerifyType(raw, TypeAccess)
+			if err != nil {
+				t.Fatalf("VerifyType() error = %v", err)
+			}
+
+			userID, err := claims.UserID()
+			if err != nil || userID != 42 {
+				t.Errorf("Expected user ID 42, got %d (%v)", userID, err)
+			}
+			if claims.Issuer != "https://auth.example.com" {
+				t.Errorf("Unexpected issuer %q", claims.Issuer)
+			}
+			if !claims.Audience.Contains("example-api") {
+				t.Errorf("Unexpected audience %v", claims.Audience)
+			}
+			if claims.ID == "" || claims.IssuedAt == 0 || claims.NotBefore == 0 {
+				t.Error("Expected jti, iat and nbf to be set")
+			}
+			if claims.ExpiresAt-claims.IssuedAt != 60 {
+				t.Errorf("Expected 60s lifetime, got %ds", claims.ExpiresAt-claims.IssuedAt)
+			}
+		})
+	}
+}
+
+func TestManager_VerifyErrors(t *testing.T) {
+	m := newTestManager(t, HS256)
+	valid, err := m.Issue(Claims{Subject: "1", Type: TypeAccess})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	other := newTestManager(t, HS256)
+	other.issuer = "https://other.example.com"
+	wrongIssuer, _ := other.Issue(Claims{Subject: "1"})
+
+	forged, _ := Sign(Header{Algorithm: HS256, KeyID: "key-1"}, []byte(`{"sub":"1"}`), []byte("a-completely-different-secret-value"))
+
+	parts := strings.Split(valid, ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"2","iss":"https://auth.example.com","aud":"example-api","exp":9999999999}`))
+	tampered := parts[0] + "." + tamperedPayload + "." + parts[2]
+
+	unknownKey, _ := Sign(Header{Algorithm: HS256, KeyID: "key-2"}, []byte(`{"sub":"1"}`), []byte(testSecret))
+	algNone := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"garbage", "not-a-token", ErrMalformed},
+		{"bad base64", "a.b!.c", ErrMalformed},
+		{"forged signature", forged, ErrSignatureInvalid},
+		{"tampered payload", tampered, ErrSignatureInvalid},
+		{"unknown key ID", unknownKey, ErrUnknownKey},
+		{"alg none", algNone, ErrSignatureInvalid},
+		{"wrong issuer", wrongIssuer, ErrInvalidIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_Expiry(t *testing.T) {
+	m := newTestManager(t, HS256)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	raw, err := m.Issue(Claims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	m.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if _, err := m.Verify(raw); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected ErrExpired, got %v", err)
+	}
+
+	future, err := m.Issue(Claims{Subject: "1", NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	m.now = func() time.Time { return now }
+	if _, err := m.Verify(future); !errors.Is(err, ErrNotYetValid) {
+		t.Errorf("Expected ErrNotYetValid, got %v", err)
+	}
+}
+
+func TestManager_VerifyType(t *testing.T) {
+	m := newTestManager(t, HS256)
+
+	raw, err := m.Issue(Claims{Subject: "1", Type: "something_else"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	if _, err := m.VerifyType(raw, TypeAccess); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+}
+
+func TestManager_KeyRotation(t *testing.T) {
+	old := newTestManager(t, EdDSA)
+	raw, err := old.Issue(Claims{Subject: "7"})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	current := newTestManager(t, EdDSA)
+	current.keyID = "key-2"
+	current.keys = map[string]verificationKey{"key-2": current.keys["key-1"]}
+
+	if _, err := current.Verify(raw); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Expected ErrUnknownKey before rotation, got %v", err)
+	}
+
+	if err := current.AddVerificationKey("key-1", EdDSA, old.signingKey); err != nil {
+		t.Fatalf("AddVerificationKey() error = %v", err)
+	}
+
+	if _, err := current.Verify(raw); err != nil {
+		t.Errorf("Expected token signed with previous key to verify, got %v", err)
+	}
+}
+
+func TestNewManager_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"short secret", Config{Algorithm: HS256, Secret: []byte("short"), Issuer: "i", Audience: "a"}},
+		{"missing issuer", Config{Algorithm: HS256, Secret: []byte(testSecret), Audience: "a"}},
+		{"unsupported algorithm", Config{Algorithm: "none", Issuer: "i", Audience: "a"}},
+		{"RS256 without key", Config{Algorithm: RS256, Issuer: "i", Audience: "a"}},
+		{"EdDSA with RSA key", Config{Algorithm: EdDSA, PrivateKey: &rsa.PrivateKey{}, Issuer: "i", Audience: "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewManager(tt.cfg); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestAudience_JSON(t *testing.T) {
+	var claims Claims
+	if err := json.Unmarshal([]byte(`{"aud":["a","b"]}`), &claims); err != nil {
+		t.Fatalf("Unmarshal array error = %v", err)
+	}
+	if !claims.Audience.Contains("b") {
+		t.Errorf("Expected audience to contain 'b', got %v", claims.Audience)
+	}
+
+	if err := json.Unmarshal([]byte(`{"aud":"single"}`), &claims); err != nil {
+		t.Fatalf("Unmarshal string error = %v", err)
+	}
+	data, _ := json.Marshal(claims.Audience)
+	if string(data) != `"single"` {
+		t.Errorf("Expected single audience to marshal as string, got %s", data)
+	}
+}
+
+func TestES256_SignAndVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %v", err)
+	}
+
+	raw, err := Sign(Header{Algorithm: ES256}, []byte(`{"sub":"1"}`), key)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	jws, err := Decode(raw)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if err := jws.Verify(ES256, &key.PublicKey); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := jws.Verify(RS256, &key.PublicKey); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Expected algorithm mismatch to fail, got %v", err)
+	}
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	key, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParsePrivateKeyPEM() error = %v", err)
+	}
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		t.Errorf("Expected Ed25519 key, got %T", key)
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not pem")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestJWK_RoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg Algorithm
+		key interface{}
+	}{
+		{RS256, rsaKey},
+		{ES256, ecKey},
+		{EdDSA, edKey},
+	}
+
+	for _, tt := range tests {
+		jwk, err := NewJWK("kid-"+string(tt.alg), tt.key)
+		if err != nil {
+			t.Fatalf("%s: NewJWK() error = %v", tt.alg, err)
+		}
+
+		data, _ := json.Marshal(JWKSet{Keys: []JWK{jwk}})
+		var set JWKSet
+		if err := json.Unmarshal(data, &set); err != nil || len(set.Keys) != 1 {
+			t.Fatalf("%s: failed to decode key set %s", tt.alg, data)
+		}
+
+		pub, err := set.Keys[0].PublicKey()
+		if err != nil {
+			t.Fatalf("%s: PublicKey() error = %v", tt.alg, err)
+		}
+		alg, err := set.Keys[0].SigningAlgorithm()
+		if err != nil || alg != tt.alg {
+			t.Errorf("%s: SigningAlgorithm() = %s, %v", tt.alg, alg, err)
+		}
+
+		// A token signed with the private key verifies with the decoded public key
+		raw, err := Sign(Header{Algorithm: tt.alg, KeyID: jwk.KeyID}, []byte(`{"sub":"1"}`), tt.key)
+		if err != nil {
+			t.Fatalf("%s: Sign() error = %v", tt.alg, err)
+		}
+		jws, _ := Decode(raw)
+		if err := jws.Verify(alg, pub); err != nil {
+			t.Errorf("%s: Verify() with decoded JWK error = %v", tt.alg, err)
+		}
+	}
+}
+
+func TestJWK_Invalid(t *testing.T) {
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	small, _ := NewJWK("small", smallKey)
+	if _, err := small.PublicKey(); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected short RSA key to be rejected, got %v", err)
+	}
+
+	mismatched := JWK{KeyType: "RSA", Algorithm: "ES256"}
+	if _, err := mismatched.SigningAlgorithm(); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected mismatched algorithm to be rejected, got %v", err)
+	}
+
+	if _, err := (JWK{KeyType: "oct"}).PublicKey(); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected symmetric key to be rejected, got %v", err)
+	}
+}
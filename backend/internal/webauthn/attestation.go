@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+// Attestation statement formats
+const (
+	FormatNone   = "none"
+	FormatPacked = "packed"
+)
+
+// oidFIDOGenCeAAGUID is the certificate extension carrying the authenticator AAGUID
+var oidFIDOGenCeAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyAttestation checks an attestation statement and returns the
+// attestation type it establishes
+func verifyAttestation(format string, statement map[interface{}]interface{}, rawAuthData, clientDataHash []byte,
+	authData *authenticatorData, credentialKey crypto.PublicKey, credentialAlg int64) (string, error) {
+
+	switch format {
+	case FormatNone:
+		if len(statement) != 0 {
+			return "", fmt.Errorf("%w: none statement must be empty", ErrInvalidAttestation)
+		}
+		return AttestationTypeNone, nil
+	case FormatPacked:
+		return verifyPackedAttestation(statement, rawAuthData, clientDataHash, authData, credentialKey, credentialAlg)
+	}
+
+	return "", fmt.Errorf("%w: unsupported format %q", ErrInvalidAttestation, format)
+}
+
+// verifyPackedAttestation implements WebAuthn §8.2. The certificate chain is
+// not checked against a trust anchor since we keep no authenticator metadata;
+// "basic" only means the statement was signed by the certificate presented.
+func verifyPackedAttestation(statement map[interface{}]interface{}, rawAuthData, clientDataHash []byte,
+	authData *authenticatorData, credentialKey crypto.PublicKey, credentialAlg int64) (string, error) {
+
+	alg, ok := statement["alg"].(int64)
+	if !ok {
+		return "", fmt.Errorf("%w: missing alg", ErrInvalidAttestation)
+	}
+	sig, ok := statement["sig"].([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w: missing sig", ErrInvalidAttestation)
+	}
+
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash...)
+
+	x5c, hasCertificates := statement["x5c"].([]interface{})
+	if !hasCertificates {
+		// Self attestation: signed by the credential key itself
+		if alg != credentialAlg {
+			return "", fmt.Errorf("%w: self attestation algorithm mismatch", ErrInvalidAttestation)
+		}
+		if err := verifySignature(alg, credentialKey, signed, sig); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+		}
+		return AttestationTypeSelf, nil
+	}
+
+	if len(x5c) == 0 {
+		return "", fmt.Errorf("%w: empty x5c", ErrInvalidAttestation)
+	}
+	leafDER, ok := x5c[0].([]byte)
+	if !ok {
+		return "", fmt.Errorf("%w: x5c entries must be byte strings", ErrInvalidAttestation)
+	}
+	cert, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+	}
+
+	if err := checkPackedCertificate(cert, authData.AAGUID); err != nil {
+		return "", err
+	}
+
+	if !certificateKeyMatches(alg, cert.PublicKey) {
+		return "", fmt.Errorf("%w: certificate key does not match alg", ErrInvalidAttestation)
+	}
+	if err := verifySignature(alg, cert.PublicKey, signed, sig); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAttestation, err)
+	}
+
+	return AttestationTypeBasic, nil
+}
+
+// checkPackedCertificate enforces the attestation certificate requirements
+// of WebAuthn §8.2.1
+func checkPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	if cert.Version != 3 {
+		return fmt.Errorf("%w: certificate must be version 3", ErrInvalidAttestation)
+	}
+	if cert.IsCA {
+		return fmt.Errorf("%w: certificate must not be a CA", ErrInvalidAttestation)
+	}
+
+	hasOU := false
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == "Authenticator Attestation" {
+			hasOU = true
+		}
+	}
+	if !hasOU || len(cert.Subject.Organization) == 0 || len(cert.Subject.Country) == 0 || cert.Subject.CommonName == "" {
+		return fmt.Errorf("%w: certificate subject does not meet requirements", ErrInvalidAttestation)
+	}
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFIDOGenCeAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return fmt.Errorf("%w: AAGUID extension must not be critical", ErrInvalidAttestation)
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || !bytes.Equal(value, aaguid) {
+			return fmt.Errorf("%w: certificate AAGUID does not match", ErrInvalidAttestation)
+		}
+	}
+
+	return nil
+}
+
+// certificateKeyMatches reports whether a certificate key suits the algorithm
+func certificateKeyMatches(alg int64, key crypto.PublicKey) bool {
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		return alg == AlgES256
+	case *rsa.PublicKey:
+		return alg == AlgRS256
+	case ed25519.PublicKey:
+		return alg == AlgEdDSA
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/danielsaas/generic-saas/internal/cbor"
+)
+
+// COSE algorithm identifiers (RFC 9053) for the credential types we accept
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgorithms lists the accepted algorithms in order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// ParsePublicKey decodes a COSE_Key into a Go public key and its algorithm
+func ParsePublicKey(data []byte) (crypto.PublicKey, int64, error) {
+	decoded, _, err := cbor.Decode(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	return parseCOSEKey(decoded)
+}
+
+func parseCOSEKey(decoded interface{}) (crypto.PublicKey, int64, error) {
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: not a map", ErrInvalidPublicKey)
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: bad P-256 parameters", ErrInvalidPublicKey)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		return key, alg, nil
+
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: bad Ed25519 parameters", ErrInvalidPublicKey)
+		}
+		return ed25519.PublicKey(append([]byte(nil), x...)), alg, nil
+
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("%w: bad RSA parameters", ErrInvalidPublicKey)
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, 0, fmt.Errorf("%w: RSA key must be at least 2048 bits", ErrInvalidPublicKey)
+		}
+		return key, alg, nil
+	}
+
+	return nil, 0, fmt.Errorf("%w: key type %d with algorithm %d", ErrUnsupportedAlgorithm, kty, alg)
+}
+
+// verifySignature checks a WebAuthn signature. ECDSA signatures are ASN.1
+// DER encoded, unlike in JWS.
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrSignatureInvalid
+		}
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return ErrSignatureInvalid
+		}
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrSignatureInvalid
+		}
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrSignatureInvalid
+		}
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrSignatureInvalid
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return ErrSignatureInvalid
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// MarshalPublicKey encodes a public key as a COSE_Key
+func MarshalPublicKey(key crypto.PublicKey) ([]byte, error) {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		point, err := key.Bytes()
+		if err != nil || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: only P-256 is supported", ErrUnsupportedAlgorithm)
+		}
+		return cbor.Marshal(map[interface{}]interface{}{
+			coseKty: ktyEC2,
+			coseAlg: AlgES256,
+			coseCrv: crvP256,
+			coseX:   point[1:33],
+			coseY:   point[33:],
+		})
+	case ed25519.PublicKey:
+		return cbor.Marshal(map[interface{}]interface{}{
+			coseKty: ktyOKP,
+			coseAlg: AlgEdDSA,
+			coseCrv: crvEd25519,
+			coseX:   []byte(key),
+		})
+	case *rsa.PublicKey:
+		return cbor.Marshal(map[interface{}]interface{}{
+			coseKty:  ktyRSA,
+			coseAlg:  AlgRS256,
+			coseRSAN: key.N.Bytes(),
+			coseRSAE: big.NewInt(int64(key.E)).Bytes(),
+		})
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, key)
+}
@@ -0,0 +1,159 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/danielsaas/generic-saas/internal/cbor"
+)
+
+// Authenticator data flags
+const (
+	FlagUserPresent    byte = 0x01
+	FlagUserVerified   byte = 0x04
+	FlagBackupEligible byte = 0x08
+	FlagBackupState    byte = 0x10
+	FlagAttestedData   byte = 0x40
+	FlagExtensionData  byte = 0x80
+)
+
+// maxCredentialIDLength is the largest credential ID the spec allows
+const maxCredentialIDLength = 1023
+
+// clientData is the subset of CollectedClientData we check
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed authData structure
+type authenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Present when FlagAttestedData is set
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key bytes
+}
+
+func (a *authenticatorData) has(flag byte) bool {
+	return a.Flags&flag != 0
+}
+
+// parseAuthenticatorData decodes the binary authenticator data
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+
+	ad := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if ad.has(FlagAttestedData) {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+		}
+		ad.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > maxCredentialIDLength || len(rest) < idLength {
+			return nil, fmt.Errorf("%w: bad credential ID length", ErrInvalidResponse)
+		}
+		ad.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key: %v", ErrInvalidResponse, err)
+		}
+		ad.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if ad.has(FlagExtensionData) {
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions: %v", ErrInvalidResponse, err)
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing bytes in authenticator data", ErrInvalidResponse)
+	}
+
+	return ad, nil
+}
+
+// verifyClientData checks the collected client data against the ceremony
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremonyType string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: client data: %v", ErrInvalidResponse, err)
+	}
+
+	if cd.Type != ceremonyType {
+		return fmt.Errorf("%w: client data type %q", ErrInvalidResponse, cd.Type)
+	}
+
+	got, err := DecodeBase64URL(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if cd.CrossOrigin || !rp.allowedOrigin(cd.Origin) {
+		return ErrOriginMismatch
+	}
+
+	return nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user presence and
+// verification flags. Both ceremonies ask for user verification, which is what
+// lets a passkey stand in for a password and a second factor.
+func (rp *RelyingParty) verifyAuthenticatorData(ad *authenticatorData) error {
+	expected := sha256.Sum256([]byte(rp.id))
+	if !bytes.Equal(ad.RPIDHash, expected[:]) {
+		return ErrRPIDMismatch
+	}
+	if !ad.has(FlagUserPresent) {
+		return ErrUserNotPresent
+	}
+	if !ad.has(FlagUserVerified) {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+func (rp *RelyingParty) allowedOrigin(origin string) bool {
+	for _, allowed := range rp.origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeBase64URL encodes bytes the way WebAuthn JSON serialisation does
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeBase64URL decodes base64url with or without padding
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
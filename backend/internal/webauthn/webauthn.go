@@ -0,0 +1,427 @@
+// Package webauthn implements the relying party side of Web Authentication
+// (https://www.w3.org/TR/webauthn-3/): building options for the browser and
+// verifying registration and assertion responses.
+//
+// The package is stateless. Callers generate a challenge, keep it somewhere
+// the client cannot tamper with, and pass it back in when verifying.
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/cbor"
+)
+
+// Errors returned by the ceremony verifiers
+var (
+	ErrInvalidResponse      = errors.New("webauthn: invalid response")
+	ErrChallengeMismatch    = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch       = errors.New("webauthn: origin not allowed")
+	ErrRPIDMismatch         = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotPresent       = errors.New("webauthn: user not present")
+	ErrUserNotVerified      = errors.New("webauthn: user not verified")
+	ErrInvalidPublicKey     = errors.New("webauthn: invalid credential public key")
+	ErrUnsupportedAlgorithm = errors.New("webauthn: unsupported algorithm")
+	ErrSignatureInvalid     = errors.New("webauthn: signature invalid")
+	ErrInvalidAttestation   = errors.New("webauthn: invalid attestation statement")
+	ErrSignCountRegression  = errors.New("webauthn: signature counter did not increase")
+)
+
+// Attestation conveyance preferences
+const (
+	AttestationNone   = "none"
+	AttestationDirect = "direct"
+)
+
+// Attestation types recorded on verified credentials
+const (
+	AttestationTypeNone  = "none"
+	AttestationTypeSelf  = "self"
+	AttestationTypeBasic = "basic"
+)
+
+// challengeSize is the number of random bytes in a challenge
+const challengeSize = 32
+
+// Config configures a RelyingParty
+type Config struct {
+	// RPID is the relying party ID, a registrable domain such as "example.com"
+	RPID string
+
+	// RPName is shown to users by their authenticator
+	RPName string
+
+	// Origins lists the exact origins ceremonies may run on
+	Origins []string
+
+	// Attestation is the conveyance preference sent to browsers. Defaults to "none".
+	Attestation string
+
+	// Timeout is the hint given to browsers. Defaults to five minutes.
+	Timeout time.Duration
+}
+
+// RelyingParty builds ceremony options and verifies authenticator responses
+type RelyingParty struct {
+	id          string
+	name        string
+	origins     []string
+	attestation string
+	timeout     time.Duration
+}
+
+// NewRelyingParty validates the configuration and creates a RelyingParty
+func NewRelyingParty(cfg Config) (*RelyingParty, error) {
+	if cfg.RPID == "" {
+		return nil, errors.New("webauthn: relying party ID is required")
+	}
+	if len(cfg.Origins) == 0 {
+		return nil, errors.New("webauthn: at least one origin is required")
+	}
+	for _, origin := range cfg.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("webauthn: invalid origin %q", origin)
+		}
+	}
+
+	rp := &RelyingParty{
+		id:          cfg.RPID,
+		name:        cfg.RPName,
+		origins:     cfg.Origins,
+		attestation: cfg.Attestation,
+		timeout:     cfg.Timeout,
+	}
+	if rp.name == "" {
+		rp.name = rp.id
+	}
+	if rp.attestation == "" {
+		rp.attestation = AttestationNone
+	}
+	if rp.timeout == 0 {
+		rp.timeout = 5 * time.Minute
+	}
+
+	return rp, nil
+}
+
+// ID returns the relying party ID
+func (rp *RelyingParty) ID() string {
+	return rp.id
+}
+
+// NewChallenge returns a random ceremony challenge
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	return b, nil
+}
+
+// RPEntity describes the relying party to the authenticator
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity describes the account a credential is created for
+type UserEntity struct {
+	ID          string `json:"id"` // base64url user handle
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an acceptable credential type and algorithm
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor identifies an existing credential
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"` // base64url credential ID
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection states the authenticator features the RP requires
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// User is the account information needed to register a credential
+type User struct {
+	Handle      []byte
+	Name        string
+	DisplayName string
+}
+
+// CreationOptions returns options for registering a discoverable, user
+// verified credential, excluding credentials the user already has
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]CredentialParameter, len(SupportedAlgorithms))
+	for i, alg := range SupportedAlgorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return CreationOptions{
+		Challenge: EncodeBase64URL(challenge),
+		RP:        RPEntity{ID: rp.id, Name: rp.name},
+		User: UserEntity{
+			ID:          EncodeBase64URL(user.Handle),
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            rp.timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: rp.attestation,
+	}
+}
+
+// RequestOptions returns options for a user verified assertion. An empty
+// allow list lets the user pick any discoverable credential for this RP.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+
+	return RequestOptions{
+		Challenge:        EncodeBase64URL(challenge),
+		Timeout:          rp.timeout.Milliseconds(),
+		RPID:             rp.id,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// AttestationResponse is the JSON form of a PublicKeyCredential returned by
+// navigator.credentials.create()
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of a PublicKeyCredential returned by
+// navigator.credentials.get()
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// CredentialID returns the decoded ID of the credential that signed the assertion
+func (a *AssertionResponse) CredentialID() ([]byte, error) {
+	id, err := DecodeBase64URL(a.RawID)
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("%w: credential ID", ErrInvalidResponse)
+	}
+	return id, nil
+}
+
+// UserHandle returns the decoded user handle, which is empty for credentials
+// that are not discoverable
+func (a *AssertionResponse) UserHandle() ([]byte, error) {
+	if a.Response.UserHandle == "" {
+		return nil, nil
+	}
+	return DecodeBase64URL(a.Response.UserHandle)
+}
+
+// Credential is a verified, newly registered credential
+type Credential struct {
+	ID              []byte
+	PublicKey       []byte // COSE_Key
+	Algorithm       int64
+	SignCount       uint32
+	AAGUID          []byte
+	AttestationType string
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+}
+
+// VerifyRegistration runs the registration ceremony checks (WebAuthn §7.1)
+// and returns the credential to store
+func (rp *RelyingParty) VerifyRegistration(response *AttestationResponse, challenge []byte) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: credential type %q", ErrInvalidResponse, response.Type)
+	}
+
+	clientDataJSON, err := DecodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client data encoding", ErrInvalidResponse)
+	}
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAttestation, err := DecodeBase64URL(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object encoding", ErrInvalidResponse)
+	}
+	decoded, _, err := cbor.Decode(rawAttestation)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrInvalidResponse, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrInvalidResponse)
+	}
+
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, fmt.Errorf("%w: attestation object is incomplete", ErrInvalidResponse)
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if !authData.has(FlagAttestedData) {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrInvalidResponse)
+	}
+
+	rawID, err := DecodeBase64URL(response.RawID)
+	if err != nil || string(rawID) != string(authData.CredentialID) {
+		return nil, fmt.Errorf("%w: credential ID does not match authenticator data", ErrInvalidResponse)
+	}
+
+	publicKey, alg, err := ParsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	attestationType, err := verifyAttestation(format, statement, rawAuthData, clientDataHash[:], authData, publicKey, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:              append([]byte(nil), authData.CredentialID...),
+		PublicKey:       append([]byte(nil), authData.PublicKey...),
+		Algorithm:       alg,
+		SignCount:       authData.SignCount,
+		AAGUID:          append([]byte(nil), authData.AAGUID...),
+		AttestationType: attestationType,
+		Transports:      response.Response.Transports,
+		BackupEligible:  authData.has(FlagBackupEligible),
+		BackupState:     authData.has(FlagBackupState),
+	}, nil
+}
+
+// AssertionResult is the outcome of a verified assertion
+type AssertionResult struct {
+	SignCount   uint32
+	BackupState bool
+}
+
+// VerifyAssertion runs the authentication ceremony checks (WebAuthn §7.2)
+// against a stored credential's public key and signature counter
+func (rp *RelyingParty) VerifyAssertion(response *AssertionResponse, challenge []byte, publicKey []byte, storedSignCount uint32) (*AssertionResult, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: credential type %q", ErrInvalidResponse, response.Type)
+	}
+
+	clientDataJSON, err := DecodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client data encoding", ErrInvalidResponse)
+	}
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := DecodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: authenticator data encoding", ErrInvalidResponse)
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	signature, err := DecodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidResponse)
+	}
+
+	key, alg, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(alg, key, signed, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators that don't implement a counter always report zero.
+	// Otherwise the counter must move forward, or the credential was cloned.
+	if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+		return nil, ErrSignCountRegression
+	}
+
+	return &AssertionResult{
+		SignCount:   authData.SignCount,
+		BackupState: authData.has(FlagBackupState),
+	}, nil
+}
@@ -0,0 +1,254 @@
+package webauthn_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/danielsaas/generic-saas/internal/webauthn"
+	"github.com/danielsaas/generic-saas/internal/webauthn/webauthntest"
+)
+
+const testOrigin = "https://app.example.com"
+
+func newTestRelyingParty(t *testing.T) *webauthn.RelyingParty {
+	t.Helper()
+	rp, err := webauthn.NewRelyingParty(webauthn.Config{
+		RPID:    "app.example.com",
+		RPName:  "Example",
+		Origins: []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("NewRelyingParty() error = %v", err)
+	}
+	return rp
+}
+
+func testUser() webauthn.User {
+	return webauthn.User{Handle: []byte("42"), Name: "john@example.com", DisplayName: "John Doe"}
+}
+
+// register runs a registration ceremony and returns the stored credential
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+
+	challenge, _ := webauthn.NewChallenge()
+	response, err := authenticator.Register(rp.CreationOptions(testUser(), challenge, nil), testOrigin)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	credential, err := rp.VerifyRegistration(response, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration() error = %v", err)
+	}
+	return credential
+}
+
+func TestCeremonies_AllAlgorithms(t *testing.T) {
+	rp := newTestRelyingParty(t)
+
+	for _, alg := range webauthn.SupportedAlgorithms {
+		authenticator := webauthntest.NewAuthenticator(alg)
+		credential := register(t, rp, authenticator)
+
+		if credential.Algorithm != alg || credential.AttestationType != webauthn.AttestationTypeNone {
+			t.Errorf("alg %d: unexpected credential %+v", alg, credential)
+		}
+
+		challenge, _ := webauthn.NewChallenge()
+		assertion, err := authenticator.Login(rp.RequestOptions(challenge, nil), testOrigin)
+		if err != nil {
+			t.Fatalf("alg %d: Login() error = %v", alg, err)
+		}
+
+		result, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, credential.SignCount)
+		if err != nil {
+			t.Fatalf("alg %d: VerifyAssertion() error = %v", alg, err)
+		}
+		if result.SignCount != 1 {
+			t.Errorf("alg %d: expected sign count 1, got %d", alg, result.SignCount)
+		}
+
+		handle, _ := assertion.UserHandle()
+		if string(handle) != "42" {
+			t.Errorf("alg %d: expected user handle 42, got %q", alg, handle)
+		}
+	}
+}
+
+func TestVerifyRegistration_PackedSelfAttestation(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := webauthntest.NewAuthenticator(webauthn.AlgES256)
+	authenticator.Format = webauthn.FormatPacked
+
+	credential := register(t, rp, authenticator)
+	if credential.AttestationType != webauthn.AttestationTypeSelf {
+		t.Errorf("Expected self attestation, got %s", credential.AttestationType)
+	}
+}
+
+func TestVerifyRegistration_PackedFullAttestation(t *testing.T) {
+	aaguid := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name       string
+		certAAGUID []byte
+		ou         string
+		wantErr    bool
+	}{
+		{"valid certificate", aaguid, "Authenticator Attestation", false},
+		{"AAGUID mismatch", []byte("fedcba9876543210"), "Authenticator Attestation", true},
+		{"wrong organizational unit", aaguid, "Something Else", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestRelyingParty(t)
+			attestationKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+			aaguidExt, _ := asn1.Marshal(tt.certAAGUID)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject: pkix.Name{
+					Country:            []string{"US"},
+					Organization:       []string{"Example Vendor"},
+					OrganizationalUnit: []string{tt.ou},
+					CommonName:         "Example Authenticator",
+				},
+				NotBefore:       time.Now().Add(-time.Hour),
+				NotAfter:        time.Now().Add(time.Hour),
+				ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguidExt}},
+			}
+			certDER, err := x509.CreateCertificate(rand.Reader, template, template, &attestationKey.PublicKey, attestationKey)
+			if err != nil {
+				t.Fatalf("CreateCertificate() error = %v", err)
+			}
+
+			authenticator := webauthntest.NewAuthenticator(webauthn.AlgEdDSA)
+			authenticator.Format = webauthn.FormatPacked
+			authenticator.AAGUID = aaguid
+			authenticator.AttestationKey = attestationKey
+			authenticator.AttestationCert = certDER
+
+			challenge, _ := webauthn.NewChallenge()
+			response, _ := authenticator.Register(rp.CreationOptions(testUser(), challenge, nil), testOrigin)
+			credential, err := rp.VerifyRegistration(response, challenge)
+
+			if tt.wantErr {
+				if !errors.Is(err, webauthn.ErrInvalidAttestation) {
+					t.Errorf("Expected ErrInvalidAttestation, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyRegistration() error = %v", err)
+			}
+			if credential.AttestationType != webauthn.AttestationTypeBasic {
+				t.Errorf("Expected basic attestation, got %s", credential.AttestationType)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistration_Rejections(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	challenge, _ := webauthn.NewChallenge()
+	otherChallenge, _ := webauthn.NewChallenge()
+	options := rp.CreationOptions(testUser(), challenge, nil)
+
+	wrongRP := options
+	wrongRP.RP.ID = "evil.example.com"
+
+	noUV := webauthntest.NewAuthenticator(webauthn.AlgES256)
+	noUV.SkipUserVerification = true
+
+	tests := []struct {
+		name          string
+		authenticator *webauthntest.Authenticator
+		options       webauthn.CreationOptions
+		origin        string
+		challenge     []byte
+		wantErr       error
+	}{
+		{"wrong origin", webauthntest.NewAuthenticator(webauthn.AlgES256), options, "https://evil.example.com", challenge, webauthn.ErrOriginMismatch},
+		{"wrong challenge", webauthntest.NewAuthenticator(webauthn.AlgES256), options, testOrigin, otherChallenge, webauthn.ErrChallengeMismatch},
+		{"wrong RP ID", webauthntest.NewAuthenticator(webauthn.AlgES256), wrongRP, testOrigin, challenge, webauthn.ErrRPIDMismatch},
+		{"no user verification", noUV, options, testOrigin, challenge, webauthn.ErrUserNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := tt.authenticator.Register(tt.options, tt.origin)
+			if err != nil {
+				t.Fatalf("Register() error = %v", err)
+			}
+			if _, err := rp.VerifyRegistration(response, tt.challenge); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion_Rejections(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := webauthntest.NewAuthenticator(webauthn.AlgES256)
+	credential := register(t, rp, authenticator)
+
+	challenge, _ := webauthn.NewChallenge()
+	options := rp.RequestOptions(challenge, nil)
+
+	t.Run("tampered signature", func(t *testing.T) {
+		assertion, _ := authenticator.Login(options, testOrigin)
+		sig, _ := webauthn.DecodeBase64URL(assertion.Response.Signature)
+		sig[len(sig)-1] ^= 0xff
+		assertion.Response.Signature = webauthn.EncodeBase64URL(sig)
+
+		if _, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, 0); err == nil {
+			t.Error("Expected tampered signature to be rejected")
+		}
+	})
+
+	t.Run("wrong ceremony type", func(t *testing.T) {
+		registration, _ := authenticator.Register(rp.CreationOptions(testUser(), challenge, nil), testOrigin)
+		assertion, _ := authenticator.Login(options, testOrigin)
+		assertion.Response.ClientDataJSON = registration.Response.ClientDataJSON
+
+		if _, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, 0); !errors.Is(err, webauthn.ErrInvalidResponse) {
+			t.Errorf("Expected ErrInvalidResponse, got %v", err)
+		}
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		authenticator := webauthntest.NewAuthenticator(webauthn.AlgES256)
+		credential := register(t, rp, authenticator)
+		authenticator.SetSignCount(9)
+
+		assertion, _ := authenticator.Login(options, testOrigin) // counter 10
+		if _, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, 10); !errors.Is(err, webauthn.ErrSignCountRegression) {
+			t.Errorf("Expected ErrSignCountRegression, got %v", err)
+		}
+		if _, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, 9); err != nil {
+			t.Errorf("Expected increasing counter to be accepted, got %v", err)
+		}
+	})
+}
+
+func TestNewRelyingParty_Validation(t *testing.T) {
+	if _, err := webauthn.NewRelyingParty(webauthn.Config{Origins: []string{testOrigin}}); err == nil {
+		t.Error("Expected missing RP ID to be rejected")
+	}
+	if _, err := webauthn.NewRelyingParty(webauthn.Config{RPID: "example.com"}); err == nil {
+		t.Error("Expected missing origins to be rejected")
+	}
+	if _, err := webauthn.NewRelyingParty(webauthn.Config{RPID: "example.com", Origins: []string{"example.com/login"}}); err == nil {
+		t.Error("Expected malformed origin to be rejected")
+	}
+}
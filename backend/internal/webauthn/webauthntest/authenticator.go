@@ -0,0 +1,279 @@
+// Package webauthntest provides a software authenticator for exercising
+// WebAuthn ceremonies end to end in tests.
+package webauthntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/danielsaas/generic-saas/internal/cbor"
+	"github.com/danielsaas/generic-saas/internal/webauthn"
+)
+
+// Authenticator is an in-memory authenticator holding discoverable credentials
+type Authenticator struct {
+	// Algorithm is the COSE algorithm of newly created credentials
+	Algorithm int64
+
+	// Format is the attestation statement format, "none" or "packed"
+	Format string
+
+	// AttestationKey and AttestationCert (DER) switch packed attestation from
+	// self attestation to full attestation with an x5c chain
+	AttestationKey  crypto.Signer
+	AttestationCert []byte
+
+	// AAGUID identifies the authenticator model
+	AAGUID []byte
+
+	// SkipUserVerification leaves the UV flag unset
+	SkipUserVerification bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        crypto.Signer
+	signCount  uint32
+}
+
+// NewAuthenticator creates an authenticator producing credentials for alg
+// with "none" attestation
+func NewAuthenticator(alg int64) *Authenticator {
+	return &Authenticator{
+		Algorithm: alg,
+		Format:    webauthn.FormatNone,
+		AAGUID:    make([]byte, 16),
+	}
+}
+
+// Register performs navigator.credentials.create() for the given options
+func (a *Authenticator) Register(options webauthn.CreationOptions, origin string) (*webauthn.AttestationResponse, error) {
+	key, err := generateKey(a.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	userHandle, err := webauthn.DecodeBase64URL(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &credential{
+		id:         make([]byte, 16),
+		rpID:       options.RP.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+	if _, err := rand.Read(cred.id); err != nil {
+		return nil, err
+	}
+
+	coseKey, err := webauthn.MarshalPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authenticatorData(cred, webauthn.FlagAttestedData)
+	authData = append(authData, a.AAGUID...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(cred.id)))
+	authData = append(authData, cred.id...)
+	authData = append(authData, coseKey...)
+
+	clientDataJSON, err := clientData("webauthn.create", options.Challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := a.attestationStatement(cred, authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := cbor.Marshal(map[interface{}]interface{}{
+		"fmt":      a.Format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, cred)
+
+	response := &webauthn.AttestationResponse{
+		ID:    webauthn.EncodeBase64URL(cred.id),
+		RawID: webauthn.EncodeBase64URL(cred.id),
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = webauthn.EncodeBase64URL(clientDataJSON)
+	response.Response.AttestationObject = webauthn.EncodeBase64URL(attestationObject)
+	response.Response.Transports = []string{"internal"}
+	return response, nil
+}
+
+// Login performs navigator.credentials.get() for the given options. With an
+// empty allow list it uses the most recently created credential for the RP.
+func (a *Authenticator) Login(options webauthn.RequestOptions, origin string) (*webauthn.AssertionResponse, error) {
+	cred := a.find(options)
+	if cred == nil {
+		return nil, errors.New("webauthntest: no matching credential")
+	}
+
+	cred.signCount++
+	authData := a.authenticatorData(cred, 0)
+
+	clientDataJSON, err := clientData("webauthn.get", options.Challenge, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := sign(a.algorithmOf(cred), cred.key, authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &webauthn.AssertionResponse{
+		ID:    webauthn.EncodeBase64URL(cred.id),
+		RawID: webauthn.EncodeBase64URL(cred.id),
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = webauthn.EncodeBase64URL(clientDataJSON)
+	response.Response.AuthenticatorData = webauthn.EncodeBase64URL(authData)
+	response.Response.Signature = webauthn.EncodeBase64URL(signature)
+	response.Response.UserHandle = webauthn.EncodeBase64URL(cred.userHandle)
+	return response, nil
+}
+
+// SetSignCount overrides the counter of every credential, for simulating
+// cloned authenticators
+func (a *Authenticator) SetSignCount(count uint32) {
+	for _, cred := range a.credentials {
+		cred.signCount = count
+	}
+}
+
+func (a *Authenticator) find(options webauthn.RequestOptions) *credential {
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		cred := a.credentials[i]
+		if cred.rpID != options.RPID {
+			continue
+		}
+		if len(options.AllowCredentials) == 0 {
+			return cred
+		}
+		for _, allowed := range options.AllowCredentials {
+			if allowed.ID == webauthn.EncodeBase64URL(cred.id) {
+				return cred
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authenticatorData(cred *credential, extraFlags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+
+	flags := webauthn.FlagUserPresent | extraFlags
+	if !a.SkipUserVerification {
+		flags |= webauthn.FlagUserVerified
+	}
+
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, cred.signCount)
+}
+
+func (a *Authenticator) attestationStatement(cred *credential, authData, clientDataJSON []byte) (map[interface{}]interface{}, error) {
+	if a.Format == webauthn.FormatNone {
+		return map[interface{}]interface{}{}, nil
+	}
+
+	if a.AttestationKey == nil {
+		sig, err := sign(a.Algorithm, cred.key, authData, clientDataJSON)
+		if err != nil {
+			return nil, err
+		}
+		return map[interface{}]interface{}{"alg": a.Algorithm, "sig": sig}, nil
+	}
+
+	alg, err := algorithmOfKey(a.AttestationKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(alg, a.AttestationKey, authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		"alg": alg,
+		"sig": sig,
+		"x5c": []interface{}{a.AttestationCert},
+	}, nil
+}
+
+func (a *Authenticator) algorithmOf(cred *credential) int64 {
+	alg, _ := algorithmOfKey(cred.key.Public())
+	return alg
+}
+
+func clientData(ceremonyType, challenge, origin string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+}
+
+// sign signs authData || SHA-256(clientDataJSON) the way authenticators do
+func sign(alg int64, key crypto.Signer, authData, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	switch alg {
+	case webauthn.AlgES256, webauthn.AlgRS256:
+		digest := sha256.Sum256(message)
+		return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case webauthn.AlgEdDSA:
+		return key.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("webauthntest: unsupported algorithm %d", alg)
+}
+
+func generateKey(alg int64) (crypto.Signer, error) {
+	switch alg {
+	case webauthn.AlgES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case webauthn.AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("webauthntest: unsupported algorithm %d", alg)
+}
+
+func algorithmOfKey(key crypto.PublicKey) (int64, error) {
+	switch key.(type) {
+	case *ecdsa.PublicKey:
+		return webauthn.AlgES256, nil
+	case *rsa.PublicKey:
+		return webauthn.AlgRS256, nil
+	case ed25519.PublicKey:
+		return webauthn.AlgEdDSA, nil
+	}
+	return 0, fmt.Errorf("webauthntest: unsupported key %T", key)
+}